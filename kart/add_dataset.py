@@ -1,7 +1,7 @@
 import click
 import sys
 
-from .cli_util import StringFromFile
+from .cli_util import KartCommand, StringFromFile
 from .commit import (
     commit_obj_to_json,
     commit_json_to_text,
@@ -15,7 +15,7 @@ from .status import get_untracked_tables
 from kart.tabular.v3 import TableV3
 
 
-@click.command()
+@click.command(cls=KartCommand, writes_to_repo=True)
 @click.argument("table_name")
 @click.pass_context
 @click.option(
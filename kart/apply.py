@@ -372,7 +372,7 @@ def apply_patch(
         repo.working_copy.reset(new_wc_target, track_changes_as_dirty=not do_commit)
 
 
-@click.command(cls=KartCommand)
+@click.command(cls=KartCommand, writes_to_repo=True)
 @click.pass_context
 @click.option(
     "--commit/--no-commit",
@@ -0,0 +1,111 @@
+import logging
+import sys
+from pathlib import Path
+
+import click
+
+from kart.arrow_util import ArrowFeatureWriter, import_pyarrow
+from kart.base_diff_writer import BaseDiffWriter
+from kart.diff_format import DiffFormat
+
+L = logging.getLogger("kart.arrow_diff_writer")
+
+
+class ArrowDiffWriter(BaseDiffWriter):
+    """
+    Writes all feature deltas as an Apache Arrow IPC stream - one stream per dataset - with geometries encoded as WKB.
+
+    Each row is a feature, and the leading _change column indicates whether it is the old or new version of the
+    feature, or if it was inserted or deleted - using the same codes as GeoJSON diffs:
+
+        U-  - old version of an updated feature
+        U+  - new version of an updated feature
+        D   - feature as it was before it was deleted
+        I   - feature as it is after it was inserted
+
+    Note:
+
+        Meta deltas aren't output at all. If a dataset's schema changed, every row uses the new schema.
+    """
+
+    CHANGE_FIELD = "_change"
+
+    @classmethod
+    def _check_output_path(cls, repo, output_path):
+        if isinstance(output_path, Path):
+            if output_path.is_file():
+                raise click.BadParameter(
+                    "Output path should be a directory for Arrow format.",
+                    param_hint="--output",
+                )
+            if not output_path.exists():
+                output_path.mkdir()
+            else:
+                for p in output_path.glob("*.arrows"):
+                    p.unlink()
+        else:
+            output_path = "-"
+        return output_path
+
+    def write_diff(self, diff_format=DiffFormat.FULL):
+        if diff_format != DiffFormat.FULL.value:
+            raise click.UsageError("Arrow format only supports full diffs")
+        # Fail fast, before generating the diff.
+        import_pyarrow()
+        repo_diff = self.get_repo_diff(include_files=False, diff_format=diff_format)
+
+        self.has_changes = bool(repo_diff)
+        if len(repo_diff) > 1 and not isinstance(self.output_path, Path):
+            raise click.BadParameter(
+                "Need to specify a directory via --output for Arrow with more than one dataset",
+                param_hint="--output",
+            )
+        for ds_path, ds_diff in repo_diff.items():
+            if "meta" in ds_diff:
+                meta_changes = ", ".join(ds_diff["meta"].keys())
+                click.echo(
+                    f"Warning: {ds_path} meta changes aren't included in Arrow output: {meta_changes}",
+                    err=True,
+                )
+            if "feature" not in ds_diff:
+                continue
+
+            if self.output_path == "-":
+                self.write_dataset_deltas(sys.stdout.buffer, ds_path, ds_diff)
+                sys.stdout.buffer.flush()
+            else:
+                ds_output_filename = str(ds_path).replace("/", "__") + ".arrows"
+                with open(self.output_path / ds_output_filename, "wb") as sink:
+                    self.write_dataset_deltas(sink, ds_path, ds_diff)
+        self.write_warnings_footer()
+
+    def write_dataset_deltas(self, sink, ds_path, ds_diff):
+        old_schema, new_schema = self._get_old_and_new_schema(ds_path, ds_diff)
+        dataset = self._get_old_or_new_dataset(ds_path)
+        old_transform, new_transform = self.get_geometry_transforms(ds_path, ds_diff)
+
+        with ArrowFeatureWriter(
+            sink,
+            new_schema or old_schema,
+            get_crs_definition=self._get_crs_definition(dataset),
+            leading_fields=[self.CHANGE_FIELD],
+        ) as writer:
+            for key, delta in self.filtered_dataset_deltas(ds_path, ds_diff):
+                if delta.old:
+                    writer.write(
+                        delta.old_value,
+                        old_transform,
+                        **{self.CHANGE_FIELD: "U-" if delta.new else "D"},
+                    )
+                if delta.new:
+                    writer.write(
+                        delta.new_value,
+                        new_transform,
+                        **{self.CHANGE_FIELD: "U+" if delta.old else "I"},
+                    )
+
+    def _get_crs_definition(self, dataset):
+        if self.target_crs is not None:
+            target_wkt = self.target_crs.ExportToWkt()
+            return lambda crs_identifier: target_wkt
+        return dataset.get_crs_definition
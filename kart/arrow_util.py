@@ -0,0 +1,208 @@
+import json
+import logging
+
+from kart.exceptions import NotYetImplemented
+
+L = logging.getLogger("kart.arrow_util")
+
+# Features are buffered and written as record batches of this many rows.
+DEFAULT_BATCH_SIZE = 10_000
+
+
+def import_pyarrow():
+    try:
+        import pyarrow
+        import pyarrow.ipc
+    except ImportError:
+        raise NotYetImplemented(
+            "This build of Kart doesn't support Apache Arrow output"
+        )
+    return pyarrow
+
+
+def arrow_type(column):
+    """
+    Returns the Arrow type for the given Kart ColumnSchema. Geometries are stored as WKB. Types that Arrow can't
+    represent exactly - numeric, date, time, timestamp and interval - are stored as strings, in the same form that
+    Kart uses in JSON output.
+    """
+    pa = import_pyarrow()
+    data_type = column.data_type
+    size = column.get("size")
+    if data_type == "boolean":
+        return pa.bool_()
+    elif data_type in ("blob", "geometry"):
+        return pa.binary()
+    elif data_type == "float":
+        return pa.float32() if size == 32 else pa.float64()
+    elif data_type == "integer":
+        return {8: pa.int8(), 16: pa.int16(), 32: pa.int32()}.get(size, pa.int64())
+    return pa.string()
+
+
+def arrow_field(column, crs_definition=None):
+    pa = import_pyarrow()
+    metadata = None
+    if column.data_type == "geometry":
+        # See https://geoarrow.org/extension-types
+        extension_metadata = {"crs": crs_definition} if crs_definition else {}
+        metadata = {
+            "ARROW:extension:name": "geoarrow.wkb",
+            "ARROW:extension:metadata": json.dumps(extension_metadata),
+        }
+    return pa.field(column.name, arrow_type(column), metadata=metadata)
+
+
+def arrow_schema(kart_schema, *, get_crs_definition=None, leading_fields=()):
+    """
+    Returns the Arrow schema for features with the given Kart schema.
+    get_crs_definition - if supplied, is called with each geometry column's geometryCRS to find its WKT.
+    leading_fields - any extra string fields to add at the start of the schema, eg to describe the type of change.
+    """
+    pa = import_pyarrow()
+    fields = [pa.field(name, pa.string()) for name in leading_fields]
+    for column in kart_schema.columns:
+        crs_definition = None
+        crs_identifier = column.get("geometryCRS")
+        if crs_identifier and get_crs_definition is not None:
+            try:
+                crs_definition = get_crs_definition(crs_identifier)
+            except KeyError:
+                pass
+        fields.append(arrow_field(column, crs_definition))
+    return pa.schema(fields)
+
+
+def geoparquet_metadata(kart_schema, get_crs_definition=None):
+    """
+    Returns the "geo" file metadata that marks a Parquet file as GeoParquet - see https://geoparquet.org
+    GeoParquet requires CRSs to be given as PROJJSON - if a CRS can't be converted, it's left out.
+    """
+    from osgeo import osr
+
+    columns = {}
+    for column in kart_schema.geometry_columns:
+        column_meta = {"encoding": "WKB", "geometry_types": []}
+        crs_identifier = column.get("geometryCRS")
+        if crs_identifier and get_crs_definition is not None:
+            try:
+                srs = osr.SpatialReference()
+                srs.ImportFromWkt(get_crs_definition(crs_identifier))
+                column_meta["crs"] = json.loads(srs.ExportToPROJJSON())
+            except (KeyError, RuntimeError):
+                L.warning("Couldn't convert CRS %s to PROJJSON", crs_identifier)
+        columns[column.name] = column_meta
+    if not columns:
+        return None
+    return {
+        "version": "1.0.0",
+        "primary_column": kart_schema.geometry_columns[0].name,
+        "columns": columns,
+    }
+
+
+class ArrowFeatureWriter:
+    """
+    Writes Kart features to an Arrow IPC stream or file, or a Parquet file, in record batches.
+
+    Usage:
+    with ArrowFeatureWriter(sink, dataset.schema) as writer:
+        for feature in dataset.features():
+            writer.write(feature)
+    """
+
+    def __init__(
+        self,
+        sink,
+        kart_schema,
+        *,
+        get_crs_definition=None,
+        leading_fields=(),
+        file_format="stream",
+        batch_size=DEFAULT_BATCH_SIZE,
+    ):
+        """
+        sink - a path or a writable binary file object.
+        file_format - "stream" for the Arrow IPC streaming format, which can be consumed as it is written, "file" for
+            the Arrow IPC file format (also known as Feather V2), which supports random access, or "parquet" for
+            Apache Parquet (where each batch becomes a row group).
+        """
+        pa = import_pyarrow()
+        self.columns = kart_schema.columns
+        self.leading_fields = list(leading_fields)
+        self.schema = arrow_schema(
+            kart_schema,
+            get_crs_definition=get_crs_definition,
+            leading_fields=leading_fields,
+        )
+        self.batch_size = batch_size
+        if file_format == "parquet":
+            import pyarrow.parquet
+
+            geo_metadata = geoparquet_metadata(kart_schema, get_crs_definition)
+            if geo_metadata:
+                self.schema = self.schema.with_metadata(
+                    {"geo": json.dumps(geo_metadata)}
+                )
+            self.writer = pyarrow.parquet.ParquetWriter(sink, self.schema)
+        elif file_format == "file":
+            self.writer = pa.ipc.new_file(sink, self.schema)
+        else:
+            self.writer = pa.ipc.new_stream(sink, self.schema)
+        self._rows = {field.name: [] for field in self.schema}
+        self._row_count = 0
+        self.count = 0
+
+    def __enter__(self):
+        return self
+
+    def __exit__(self, exc_type, *args):
+        if exc_type is None:
+            self.flush()
+        self.writer.close()
+
+    def write(self, feature, transform=None, **leading_values):
+        """
+        Buffers a single feature, writing a record batch if the buffer is full.
+        transform - an optional osr.CoordinateTransformation to apply to the feature's geometries.
+        leading_values - the values of any leading_fields.
+        """
+        for name in self.leading_fields:
+            self._rows[name].append(leading_values.get(name))
+        for column in self.columns:
+            value = feature.get(column.name)
+            if value is not None:
+                value = self._adapt_value(column, value, transform)
+            self._rows[column.name].append(value)
+        self._row_count += 1
+        self.count += 1
+        if self._row_count >= self.batch_size:
+            self.flush()
+
+    def _adapt_value(self, column, value, transform):
+        data_type = column.data_type
+        if data_type == "geometry":
+            if transform is not None:
+                ogr_geom = value.to_ogr()
+                ogr_geom.Transform(transform)
+                return bytes(ogr_geom.ExportToIsoWkb())
+            return value.to_wkb()
+        elif data_type in ("boolean", "blob", "float", "integer"):
+            return value
+        return str(value)
+
+    def flush(self):
+        if not self._row_count:
+            return
+        pa = import_pyarrow()
+        batch = pa.record_batch(
+            [
+                pa.array(self._rows[field.name], type=field.type)
+                for field in self.schema
+            ],
+            schema=self.schema,
+        )
+        self.writer.write_batch(batch)
+        for values in self._rows.values():
+            values.clear()
+        self._row_count = 0
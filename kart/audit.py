@@ -0,0 +1,163 @@
+import json
+import logging
+import sys
+from datetime import datetime, timezone
+
+import click
+
+from kart.cli_util import KartCommand, KartGroup
+from kart.exceptions import NotFound, NO_DATA
+from kart.output_util import dump_json_output
+from kart.timestamps import datetime_to_iso8601_utc
+
+L = logging.getLogger("kart.audit")
+
+
+def _is_audit_enabled(repo):
+    from kart.repo import KartConfigKeys
+
+    key = KartConfigKeys.KART_AUDIT_ENABLED
+    return key in repo.config and repo.config.get_bool(key)
+
+
+def get_ref_targets(repo):
+    """Returns a dict of {ref-name: target} for every ref in the repo - the target is a commit ID or another ref name."""
+    result = {}
+    for ref_name in repo.references:
+        target = repo.references[ref_name].target
+        result[ref_name] = target.hex if hasattr(target, "hex") else str(target)
+    return result
+
+
+def start_audit(ctx):
+    """
+    Called before any Kart command runs. If the repository has auditing enabled, records the state of every ref, so
+    that when the command finishes, any refs that were changed by the command - eg by commit, import, merge, or push -
+    can be recorded in the audit log along with who ran the command, when, and with what arguments.
+    """
+    from kart.repo import KartRepo
+
+    if ctx.invoked_subcommand in (None, "audit", "init", "clone", "helper"):
+        return
+    try:
+        repo = KartRepo(ctx.obj.repo_path)
+    except Exception:
+        # No repo, or it can't be opened - the command itself will report any problem with the repo.
+        return
+    if not _is_audit_enabled(repo):
+        return
+
+    refs_before = get_ref_targets(repo)
+    command_line = ["kart", *getattr(ctx, "unparsed_args", [])]
+
+    def _finish_audit():
+        try:
+            _record_audit_event(
+                repo, ctx.invoked_subcommand, command_line, refs_before
+            )
+        except Exception as e:
+            # The command has already happened by now - not being able to record it shouldn't cause it to fail.
+            L.warning("Couldn't write to the audit log: %s", e)
+
+    ctx.call_on_close(_finish_audit)
+
+
+def _record_audit_event(repo, operation, command_line, refs_before):
+    from kart.repo import KartRepoFiles
+
+    refs_after = get_ref_targets(repo)
+    changed_refs = {}
+    for ref_name in sorted(set(refs_before) | set(refs_after)):
+        old, new = refs_before.get(ref_name), refs_after.get(ref_name)
+        if old != new:
+            changed_refs[ref_name] = {"old": old, "new": new}
+    if not changed_refs:
+        # Nothing was written.
+        return
+
+    committer = repo.committer_signature()
+    head_commit = repo.head_commit
+    event = {
+        "timestamp": datetime_to_iso8601_utc(datetime.now(timezone.utc)),
+        "user": f"{committer.name} <{committer.email}>",
+        "operation": operation,
+        "commandLine": command_line,
+        "commit": head_commit.hex if head_commit is not None else None,
+        "refs": changed_refs,
+    }
+    # Opening with mode "a" means every write goes to the end of the file - existing entries are never modified.
+    with open(repo.gitdir_file(KartRepoFiles.AUDIT_LOG), "a", encoding="utf-8") as f:
+        f.write(json.dumps(event, separators=(",", ":")) + "\n")
+
+
+def read_audit_log(repo):
+    """Yields every event from the audit log, oldest first."""
+    from kart.repo import KartRepoFiles
+
+    path = repo.gitdir_file(KartRepoFiles.AUDIT_LOG)
+    if not path.exists():
+        return
+    with open(path, encoding="utf-8") as f:
+        for line in f:
+            if line.strip():
+                yield json.loads(line)
+
+
+def audit_event_to_text(event):
+    desc = f"{event['timestamp']}  {event['user']}  {event['operation']}"
+    if event.get("commit"):
+        desc += f"  {event['commit'][:7]}"
+    lines = [desc, f"    {' '.join(event['commandLine'])}"]
+    for ref_name, change in event["refs"].items():
+        old = change["old"][:7] if change["old"] else "(none)"
+        new = change["new"][:7] if change["new"] else "(deleted)"
+        lines.append(f"    {ref_name}: {old} -> {new}")
+    return "\n".join(lines)
+
+
+@click.group(cls=KartGroup)
+@click.pass_context
+def audit(ctx, **kwargs):
+    """
+    View the audit trail of write operations in this repository.
+
+    Auditing is off by default. To turn it on, run "kart config kart.audit.enabled true". From then on, every command
+    that changes a branch or other ref - such as import, commit, merge or push - is recorded in an append-only log
+    in the Kart repository, along with the user, timestamp, command line and resulting commit.
+    """
+
+
+@audit.command(cls=KartCommand, name="log")
+@click.pass_context
+@click.option(
+    "--output-format",
+    "-o",
+    type=click.Choice(["text", "json"]),
+    default="text",
+)
+@click.option(
+    "--max-count",
+    "-n",
+    type=click.INT,
+    default=None,
+    help="Only show the most recent N operations.",
+)
+def audit_log(ctx, output_format, max_count):
+    """Show the audit trail of write operations in this repository, most recent first."""
+    from kart.repo import KartRepoState
+
+    repo = ctx.obj.get_repo(allowed_states=KartRepoState.ALL_STATES)
+    events = list(read_audit_log(repo))
+    if not events and not _is_audit_enabled(repo):
+        raise NotFound(
+            'No audit log found - to turn on auditing, run "kart config kart.audit.enabled true"',
+            exit_code=NO_DATA,
+        )
+    events.reverse()
+    if max_count is not None:
+        events = events[:max_count]
+
+    if output_format == "json":
+        dump_json_output({"kart.audit/v1": events}, sys.stdout)
+    else:
+        click.echo("\n\n".join(audit_event_to_text(e) for e in events))
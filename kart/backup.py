@@ -0,0 +1,316 @@
+import hashlib
+import json
+import logging
+import shutil
+import sys
+import tarfile
+import tempfile
+from datetime import datetime, timezone
+from pathlib import Path, PurePosixPath
+
+import click
+
+from kart.cli_util import KartCommand
+from kart.exceptions import (
+    InvalidOperation,
+    NotFound,
+    SubprocessError,
+    INVALID_FILE_FORMAT,
+    NO_COMMIT,
+)
+from kart.timestamps import datetime_to_iso8601_utc
+from kart import subprocess_util as subprocess
+
+L = logging.getLogger("kart.backup")
+
+MANIFEST_NAME = "manifest.json"
+BUNDLE_NAME = "repo.bundle"
+CONFIG_NAME = "config"
+LFS_OBJECTS_DIR = PurePosixPath("lfs/objects")
+
+# Config that describes the original repository's layout on disk, rather than its contents, isn't restored.
+UNRESTORED_CONFIG_SECTIONS = ("core.",)
+
+
+def _sha256(path):
+    sha256 = hashlib.sha256()
+    with open(path, "rb") as f:
+        for chunk in iter(lambda: f.read(1024 * 1024), b""):
+            sha256.update(chunk)
+    return sha256.hexdigest()
+
+
+def _lfs_objects(repo):
+    """Yields (archive_name, path) for every object in the repo's local LFS cache - point cloud and raster tiles."""
+    lfs_objects_path = repo.gitdir_file("lfs/objects")
+    if not lfs_objects_path.is_dir():
+        return
+    for path in sorted(lfs_objects_path.rglob("*")):
+        if path.is_file():
+            rel_path = path.relative_to(lfs_objects_path).as_posix()
+            yield str(LFS_OBJECTS_DIR / rel_path), path
+
+
+def _safe_member_name(name):
+    path = PurePosixPath(name)
+    return not path.is_absolute() and ".." not in path.parts
+
+
+def create_backup(repo, fileobj, temp_dir):
+    """Writes a backup of the given repo to fileobj, as a gzipped tar stream. Returns the manifest."""
+    if not any(True for _ in repo.references):
+        raise NotFound(
+            "Repository has no commits - nothing to back up", exit_code=NO_COMMIT
+        )
+
+    bundle_path = temp_dir / BUNDLE_NAME
+    try:
+        subprocess.check_call(
+            [
+                "git",
+                "-C",
+                repo.path,
+                "bundle",
+                "create",
+                "--quiet",
+                bundle_path,
+                "--all",
+            ]
+        )
+    except subprocess.CalledProcessError as e:
+        raise SubprocessError(
+            f"There was a problem bundling the repository: {e}",
+            called_process_error=e,
+        )
+
+    files = {BUNDLE_NAME: bundle_path, CONFIG_NAME: repo.gitdir_file(CONFIG_NAME)}
+    files.update(_lfs_objects(repo))
+
+    head = repo.references["HEAD"]
+    manifest = {
+        "created": datetime_to_iso8601_utc(datetime.now(timezone.utc)),
+        "bare": repo.is_bare,
+        "head": head.target if isinstance(head.target, str) else head.target.hex,
+        "files": {name: _sha256(path) for name, path in files.items()},
+    }
+    manifest_path = temp_dir / MANIFEST_NAME
+    manifest_path.write_text(json.dumps({"kart.backup/v1": manifest}, indent=2))
+
+    # The manifest goes first, so that restore-backup can check each file as it's read.
+    with tarfile.open(fileobj=fileobj, mode="w|gz") as tar:
+        tar.add(manifest_path, arcname=MANIFEST_NAME)
+        for name, path in files.items():
+            tar.add(path, arcname=name)
+    return manifest
+
+
+def extract_backup(fileobj, temp_dir):
+    """
+    Extracts a backup written by create_backup to temp_dir, verifying every file against the manifest's checksums.
+    Returns the manifest.
+    """
+    manifest = None
+    seen = set()
+    try:
+        with tarfile.open(fileobj=fileobj, mode="r|gz") as tar:
+            for member in tar:
+                if not member.isfile() or not _safe_member_name(member.name):
+                    raise InvalidOperation(
+                        f"Unexpected entry in backup: {member.name}",
+                        exit_code=INVALID_FILE_FORMAT,
+                    )
+                if manifest is None:
+                    if member.name != MANIFEST_NAME:
+                        raise InvalidOperation(
+                            "Not a Kart backup - no manifest found",
+                            exit_code=INVALID_FILE_FORMAT,
+                        )
+                    content = tar.extractfile(member).read()
+                    manifest = json.loads(content)["kart.backup/v1"]
+                    continue
+
+                expected = manifest["files"].get(member.name)
+                if expected is None:
+                    raise InvalidOperation(
+                        f"Unexpected file in backup: {member.name}",
+                        exit_code=INVALID_FILE_FORMAT,
+                    )
+                dest = temp_dir / member.name
+                dest.parent.mkdir(parents=True, exist_ok=True)
+                with tar.extractfile(member) as src, open(dest, "wb") as dst:
+                    shutil.copyfileobj(src, dst)
+                if _sha256(dest) != expected:
+                    raise InvalidOperation(
+                        f"Backup is corrupt - checksum mismatch for {member.name}",
+                        exit_code=INVALID_FILE_FORMAT,
+                    )
+                seen.add(member.name)
+    except (tarfile.TarError, EOFError, OSError, ValueError, KeyError) as e:
+        raise InvalidOperation(
+            f"Couldn't read backup: {e}", exit_code=INVALID_FILE_FORMAT
+        )
+
+    if manifest is None:
+        raise InvalidOperation(
+            "Not a Kart backup - no manifest found", exit_code=INVALID_FILE_FORMAT
+        )
+    missing = set(manifest["files"]) - seen
+    if missing:
+        raise InvalidOperation(
+            f"Backup is incomplete - missing {', '.join(sorted(missing))}",
+            exit_code=INVALID_FILE_FORMAT,
+        )
+    return manifest
+
+
+def _restore_config(repo, config_path):
+    """Copies the settings from the backed-up config into the restored repo - remotes, user, kart.* etc."""
+    output = subprocess.check_output(
+        ["git", "config", "--file", config_path, "--null", "--list"], text=True
+    )
+    entries = [e.split("\n", 1) for e in output.split("\0") if e]
+    keys = {k for k, v in entries}
+    for key in sorted(keys):
+        if key.startswith(UNRESTORED_CONFIG_SECTIONS):
+            continue
+        subprocess.call(
+            ["git", "-C", repo.path, "config", "--unset-all", key],
+            stderr=subprocess.DEVNULL,
+        )
+    for key, value in entries:
+        if key.startswith(UNRESTORED_CONFIG_SECTIONS):
+            continue
+        subprocess.check_call(
+            ["git", "-C", repo.path, "config", "--add", key, value]
+        )
+
+
+def recreate_repo(manifest, temp_dir, repo_path, *, bare=None, wc_location=None):
+    """Recreates the backed-up repository at repo_path from the files extracted to temp_dir. Returns the repo."""
+    from kart.repo import KartRepo
+
+    if bare is None:
+        bare = manifest["bare"]
+    bundle_path = temp_dir / BUNDLE_NAME
+    repo = KartRepo.clone_repository(
+        str(bundle_path), repo_path, ["--quiet"], wc_location, bare
+    )
+
+    # Cloning only creates the bundle's branches as remote-tracking branches - replace them with every ref as it was.
+    subprocess.check_call(["git", "-C", repo.path, "remote", "remove", "origin"])
+    subprocess.check_call(
+        [
+            "git",
+            "-C",
+            repo.path,
+            "fetch",
+            "--quiet",
+            "--update-head-ok",
+            bundle_path,
+            "+refs/*:refs/*",
+        ]
+    )
+    head = manifest["head"]
+    if head.startswith("refs/"):
+        subprocess.check_call(
+            ["git", "-C", repo.path, "symbolic-ref", "HEAD", head]
+        )
+    else:
+        subprocess.check_call(
+            ["git", "-C", repo.path, "update-ref", "--no-deref", "HEAD", head]
+        )
+
+    _restore_config(repo, temp_dir / CONFIG_NAME)
+    if wc_location is not None:
+        repo.config["kart.workingcopy.location"] = wc_location
+
+    for name in manifest["files"]:
+        path = PurePosixPath(name)
+        if path.parts[: len(LFS_OBJECTS_DIR.parts)] == LFS_OBJECTS_DIR.parts:
+            dest = repo.gitdir_file(name)
+            dest.parent.mkdir(parents=True, exist_ok=True)
+            shutil.move(str(temp_dir / name), dest)
+
+    return KartRepo(repo.path)
+
+
+@click.command(cls=KartCommand)
+@click.pass_context
+@click.argument("output", type=click.Path(dir_okay=False, allow_dash=True))
+def backup(ctx, output):
+    """
+    Back up the whole repository to a single compressed archive file.
+
+    The backup includes every commit, branch and tag, the repository config, and the local copies of any point
+    cloud or raster tiles. Each file in the backup is checksummed, so that restore-backup can check that the backup
+    is intact. Specify - as the OUTPUT to stream the backup to stdout.
+
+    The working copy isn't backed up - restore-backup creates a new one. Commit any changes before backing up.
+    """
+    repo = ctx.obj.repo
+    with tempfile.TemporaryDirectory() as temp_dir:
+        if output == "-":
+            manifest = create_backup(repo, sys.stdout.buffer, Path(temp_dir))
+            sys.stdout.buffer.flush()
+        else:
+            with open(output, "wb") as f:
+                manifest = create_backup(repo, f, Path(temp_dir))
+    click.echo(
+        f"Backed up repository ({len(manifest['files'])} files) to {output}",
+        err=output == "-",
+    )
+
+
+@click.command("restore-backup", cls=KartCommand, writes_to_repo=True)
+@click.pass_context
+@click.option(
+    "--bare/--no-bare",
+    default=None,
+    help="Whether the restored repository should be bare. Defaults to whatever the backed-up repository was.",
+)
+@click.option(
+    "--checkout/--no-checkout",
+    "do_checkout",
+    default=True,
+    help="Whether to create a working copy once the repository is restored (only affects non-bare repos)",
+)
+@click.option(
+    "--workingcopy-location",
+    "wc_location",
+    help="Location where the working copy should be created, if different to the backed-up repository's.",
+)
+@click.argument("backup_file", type=click.Path(dir_okay=False, allow_dash=True))
+@click.argument(
+    "directory",
+    type=click.Path(exists=False, file_okay=False, writable=True),
+)
+def restore_backup(ctx, bare, do_checkout, wc_location, backup_file, directory):
+    """
+    Restore a repository from a backup made with `kart backup`, into a new DIRECTORY.
+
+    Every file is checked against the checksums recorded in the backup before the repository is recreated.
+    Specify - as the BACKUP_FILE to read the backup from stdin.
+    """
+    repo_path = Path(directory).resolve()
+    if repo_path.exists() and any(repo_path.iterdir()):
+        raise InvalidOperation(f'"{repo_path}" isn\'t empty', param_hint="directory")
+
+    with tempfile.TemporaryDirectory() as temp_dir:
+        temp_dir = Path(temp_dir)
+        if backup_file == "-":
+            manifest = extract_backup(sys.stdin.buffer, temp_dir)
+        else:
+            with open(backup_file, "rb") as f:
+                manifest = extract_backup(f, temp_dir)
+        click.echo(f"Verified {len(manifest['files'])} files in backup")
+        repo = recreate_repo(
+            manifest, temp_dir, repo_path, bare=bare, wc_location=wc_location
+        )
+
+    parts_to_create = (
+        repo.datasets().working_copy_part_types()
+        if do_checkout and not repo.is_bare and not repo.head_is_unborn
+        else ()
+    )
+    repo.working_copy.reset_to_head(create_parts_if_missing=parts_to_create)
+    click.echo(f"Restored repository to {repo_path}")
@@ -61,6 +61,14 @@ class BaseDiffWriter:
             from .json_diff_writers import GeojsonDiffWriter
 
             return GeojsonDiffWriter
+        elif output_format == "geojson-lines":
+            from .json_diff_writers import GeojsonLinesDiffWriter
+
+            return GeojsonLinesDiffWriter
+        elif output_format == "arrow":
+            from .arrow_diff_writer import ArrowDiffWriter
+
+            return ArrowDiffWriter
         elif output_format == "html":
             from .html_diff_writer import HtmlDiffWriter
 
@@ -126,6 +134,11 @@ class BaseDiffWriter:
         self.commit = None
         self.do_convert_to_dataset_format = None
         self.do_full_file_diffs = False
+        self.do_measure = False
+
+        self.limit = None
+        self.limit_reached = False
+        self.limited_delta_count = 0
 
     def include_target_commit_as_header(self):
         """
@@ -140,6 +153,17 @@ class BaseDiffWriter:
     def full_file_diffs(self, do_full_file_diffs=True):
         self.do_full_file_diffs = do_full_file_diffs
 
+    def measure_geometries(self, do_measure=True):
+        """Also output the geodesic area or length of the old and new geometry of each feature delta."""
+        self.do_measure = do_measure
+
+    def limit_deltas(self, limit):
+        """
+        Only output the first `limit` feature or tile deltas. Once the limit is reached, no more deltas are loaded,
+        and the diffs of any remaining datasets aren't generated, where the output format allows.
+        """
+        self.limit = limit
+
     @classmethod
     def _normalize_output_path(cls, output_path):
         if not output_path or output_path == "-":
@@ -227,6 +251,7 @@ class BaseDiffWriter:
         self.write_spatial_filter_conflicts_warning_footer()
         self.write_list_of_conflicts_warning_footer()
         self.write_linked_dataset_changes_warning_footer()
+        self.write_limit_warning_footer()
 
     def write_spatial_filter_conflicts_warning_footer(self):
         """
@@ -306,6 +331,15 @@ class BaseDiffWriter:
         for ds_path in sorted(self.linked_dataset_changes):
             click.echo(f"  {ds_path}", err=True)
 
+    def write_limit_warning_footer(self):
+        if not self.limit_reached:
+            return
+        click.secho(
+            f"Warning: only the first {self.limit} changes are shown, because of --limit.",
+            bold=True,
+            err=True,
+        )
+
     def write_diff(self, diff_format=DiffFormat.FULL):
         """Default implementation for writing a diff. Subclasses can override."""
         # Entered when -o is text
@@ -327,10 +361,13 @@ class BaseDiffWriter:
         # Else, print the entire diff
         self.has_changes = False
         for ds_path in self.all_ds_paths:
+            if self.limit_reached:
+                break
             self.has_changes |= self.write_ds_diff_for_path(
                 ds_path, diff_format=DiffFormat.FULL
             )
-        self.has_changes |= self.write_file_diff(self.get_file_diff())
+        if not self.limit_reached:
+            self.has_changes |= self.write_file_diff(self.get_file_diff())
         self.write_warnings_footer()
 
     def write_ds_diff_for_path(self, ds_path, diff_format=DiffFormat.FULL):
@@ -449,7 +486,21 @@ class BaseDiffWriter:
         self.spatial_filter. Note that deltas are always considered to match the spatial-filter
         if they are marked as working-copy edits, since working-copy edits are always relevant to the user
         even if they are outside the spatial filter.
+        If self.limit is set, stops once that many deltas have been yielded across all datasets.
         """
+        deltas = self._spatially_filtered_dataset_deltas(ds_path, ds_diff)
+        if self.limit is None:
+            yield from deltas
+            return
+
+        for key, delta in deltas:
+            if self.limited_delta_count >= self.limit:
+                self.limit_reached = True
+                return
+            self.limited_delta_count += 1
+            yield key, delta
+
+    def _spatially_filtered_dataset_deltas(self, ds_path, ds_diff):
         item_type = self._get_old_or_new_dataset(ds_path).ITEM_TYPE
         if not item_type or item_type not in ds_diff:
             return
@@ -506,7 +557,7 @@ class BaseDiffWriter:
         as the deltas are filtered and output. In fact, this function works just by iterating over them without
         outputting them, which causes the stats to be recorded in the same way.
         """
-        for _ in self.filtered_dataset_deltas(ds_path, ds_diff):
+        for _ in self._spatially_filtered_dataset_deltas(ds_path, ds_diff):
             pass
 
     def record_spatial_filter_stat(
@@ -654,6 +705,45 @@ class BaseDiffWriter:
         )
         return (_get_transform(old_crs), _get_transform(new_crs))
 
+    def get_geodesic_measurers(self, ds_path, ds_diff):
+        """
+        Returns old_measurer, new_measurer for the dataset at a particular path - GeodesicMeasurers for the CRS of the
+        old, pre-diff values and of the new, post-diff values - or (None, None) if measurements aren't needed.
+        """
+        if not self.do_measure:
+            return None, None
+        dataset = self._get_old_or_new_dataset(ds_path)
+        if dataset.DATASET_TYPE != "table" or not dataset.has_geometry:
+            return None, None
+
+        from kart.tabular.geodesic import GeodesicMeasurer
+
+        old_crs, new_crs = self.get_old_and_new_crs(
+            ds_path, ds_diff, context="geodesic measurement"
+        )
+        return (
+            GeodesicMeasurer(old_crs) if old_crs is not None else None,
+            GeodesicMeasurer(new_crs) if new_crs is not None else None,
+        )
+
+    def measure_delta(self, ds_path, delta, old_measurer, new_measurer):
+        """
+        Returns the geodesic measurements of the old and new geometries of the given feature delta, as a dict
+        eg {"-": {"area": 1234.5}, "+": {"area": 1200.5}} - leaving out any half that has no measurements.
+        """
+        geom_column = self._get_old_or_new_dataset(ds_path).geom_column_name
+        result = {}
+        for half, measurer, value in (
+            ("-", old_measurer, delta.old_value if delta.old else None),
+            ("+", new_measurer, delta.new_value if delta.new else None),
+        ):
+            if measurer is None or value is None:
+                continue
+            measurements = measurer.measure(value.get(geom_column))
+            if measurements:
+                result[half] = measurements
+        return result
+
     def get_spatial_filters(self, ds_path, ds_diff):
         """
         Returns old_spatial_filter, new_spatial filter for the datast at a particular path -
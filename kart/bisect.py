@@ -0,0 +1,290 @@
+import json
+import logging
+import tempfile
+from pathlib import Path
+
+import click
+import pygit2
+
+from kart import subprocess_util as subprocess
+from kart.cli_util import KartGroup, add_help_subcommand
+from kart.exceptions import InvalidOperation, NotFound, SubprocessError, NO_COMMIT
+from kart.repo import KartRepoFiles
+
+L = logging.getLogger("kart.bisect")
+
+# Exit codes of the `kart bisect run` command, as for `git bisect run`.
+SKIP_EXIT_CODE = 125
+ABORT_EXIT_CODE = 128
+
+
+class BisectState:
+    """
+    The state of a bisect session: the commit known to be bad, the commits known to be good, and any commits that
+    couldn't be tested - stored in the gitdir. Unlike `git bisect`, the working copy is never changed - candidate
+    commits are exported to a temporary GeoPackage to be tested.
+    """
+
+    def __init__(self, repo, bad=None, good=(), skipped=(), datasets=()):
+        self.repo = repo
+        self.bad = bad
+        self.good = list(good)
+        self.skipped = list(skipped)
+        self.datasets = list(datasets)
+
+    @classmethod
+    def load(cls, repo):
+        text = repo.read_gitdir_file(KartRepoFiles.BISECT_STATE, missing_ok=True)
+        if text is None:
+            raise InvalidOperation(
+                "No bisect session in progress - start one with `kart bisect start`"
+            )
+        return cls(repo, **json.loads(text))
+
+    def save(self):
+        self.repo.write_gitdir_file(
+            KartRepoFiles.BISECT_STATE,
+            json.dumps(
+                {
+                    "bad": self.bad,
+                    "good": self.good,
+                    "skipped": self.skipped,
+                    "datasets": self.datasets,
+                },
+                indent=2,
+            ),
+        )
+
+    def resolve(self, rev):
+        try:
+            return self.repo.revparse_single(rev).peel(pygit2.Commit).hex
+        except (KeyError, ValueError):
+            raise NotFound(f"No commit found at {rev}", exit_code=NO_COMMIT)
+
+    def _rev_list(self, *args):
+        cmd = [
+            "git",
+            "-C",
+            self.repo.path,
+            "rev-list",
+            *args,
+            self.bad,
+            "--not",
+            *self.good,
+        ]
+        if self.datasets:
+            cmd += ["--", *self.datasets]
+        try:
+            r = subprocess.run(cmd, encoding="utf8", check=True, capture_output=True)
+        except subprocess.CalledProcessError as e:
+            raise SubprocessError(
+                f"There was a problem with git rev-list: {e}", called_process_error=e
+            )
+        return [line.split()[0] for line in r.stdout.splitlines() if line]
+
+    def remaining(self):
+        """The commits that could still be the first bad commit - ordered from the best one to test next."""
+        if self.bad is None or not self.good:
+            return None
+        # With --bisect-all, commits are ordered by how evenly they split the remaining commits.
+        result = self._rev_list("--bisect-all")
+        if self.bad not in result:
+            # The bad commit doesn't change the given datasets - the first bad commit is the last that did.
+            result.append(self.bad)
+        return result
+
+    def next_step(self):
+        """
+        Returns (first_bad_commit, None) if the first bad commit has been found, or (None, next_commit) if there's
+        another commit to test, or (None, None) if the only commits left to test were skipped.
+        """
+        remaining = self.remaining()
+        if remaining is None:
+            return None, None
+        candidates = [c for c in remaining if c != self.bad]
+        if not candidates:
+            return self.bad, None
+        untested = [c for c in candidates if c not in self.skipped]
+        return None, (untested[0] if untested else None)
+
+    def mark(self, rev, verdict):
+        commit_id = self.resolve(rev)
+        if verdict == "bad":
+            self.bad = commit_id
+        elif verdict == "good":
+            self.good.append(commit_id)
+        else:
+            self.skipped.append(commit_id)
+        self.save()
+
+
+def _describe_commit(repo, commit_id):
+    commit = repo[commit_id]
+    return f"{commit.short_id} {commit.message.splitlines()[0] if commit.message else ''}"
+
+
+def _echo_next_step(state):
+    """Tells the user what happens next. Returns the next commit to test, if there is one."""
+    repo = state.repo
+    if state.bad is None:
+        click.echo("Waiting for a bad commit - mark one with `kart bisect bad [REV]`")
+        return None
+    if not state.good:
+        click.echo("Waiting for a good commit - mark one with `kart bisect good REV`")
+        return None
+    first_bad, next_commit = state.next_step()
+    if first_bad is not None:
+        click.echo(f"{_describe_commit(repo, first_bad)} is the first bad commit")
+        return None
+    if next_commit is None:
+        remaining = [c for c in state.remaining() if c != state.bad]
+        click.echo(
+            "There are only skipped commits left to test. The first bad commit could be any of:"
+        )
+        for commit_id in [*remaining, state.bad]:
+            click.echo(f"  {_describe_commit(repo, commit_id)}")
+        return None
+    count = len(state.remaining())
+    click.echo(
+        f"Bisecting: {count} commits left to test. Next: {_describe_commit(repo, next_commit)}"
+    )
+    return next_commit
+
+
+def export_commit_to_gpkg(repo, commit_id, ds_paths, path):
+    from kart.export import EXPORT_FORMATS, get_datasets_to_export
+
+    datasets = get_datasets_to_export(repo, commit_id, ds_paths)
+    with EXPORT_FORMATS["GPKG"].exporter(path) as exporter:
+        for dataset in datasets:
+            exporter.write_dataset(dataset)
+
+
+@add_help_subcommand
+@click.group(cls=KartGroup)
+@click.pass_context
+def bisect(ctx, **kwargs):
+    """
+    Use binary search to find the commit that introduced a problem with the data.
+
+    Start with `kart bisect start BAD GOOD` - then either mark each candidate commit as good or bad with
+    `kart bisect good` / `kart bisect bad`, or let `kart bisect run CMD` test them automatically.
+    The working copy isn't changed - candidate commits are exported to a temporary GeoPackage to be tested.
+    """
+
+
+@bisect.command(writes_to_repo=True)
+@click.pass_context
+@click.option(
+    "--dataset",
+    "datasets",
+    multiple=True,
+    help="Only consider commits that changed this dataset, and only export this dataset. Can be specified repeatedly.",
+)
+@click.argument("bad", required=False)
+@click.argument("good", nargs=-1)
+def start(ctx, datasets, bad, good):
+    """
+    Start a bisect session. BAD is a commit where the problem exists, and GOOD are commits where it doesn't.
+    Both can also be specified later, using `kart bisect bad` and `kart bisect good`.
+    """
+    repo = ctx.obj.repo
+    if repo.read_gitdir_file(KartRepoFiles.BISECT_STATE, missing_ok=True):
+        raise InvalidOperation(
+            "A bisect session is already in progress - use `kart bisect reset` to end it"
+        )
+    state = BisectState(repo, datasets=datasets)
+    if bad is not None:
+        state.bad = state.resolve(bad)
+    state.good = [state.resolve(g) for g in good]
+    state.save()
+    _echo_next_step(state)
+
+
+@bisect.command(writes_to_repo=True)
+@click.pass_context
+@click.argument("rev", default="HEAD")
+def bad(ctx, rev):
+    """Mark a commit - by default HEAD - as having the problem."""
+    state = BisectState.load(ctx.obj.repo)
+    state.mark(rev, "bad")
+    _echo_next_step(state)
+
+
+@bisect.command(writes_to_repo=True)
+@click.pass_context
+@click.argument("rev")
+def good(ctx, rev):
+    """Mark a commit as not having the problem."""
+    state = BisectState.load(ctx.obj.repo)
+    state.mark(rev, "good")
+    _echo_next_step(state)
+
+
+@bisect.command(writes_to_repo=True)
+@click.pass_context
+@click.argument("rev")
+def skip(ctx, rev):
+    """Mark a commit as untestable - another commit nearby will be tested instead."""
+    state = BisectState.load(ctx.obj.repo)
+    state.mark(rev, "skip")
+    _echo_next_step(state)
+
+
+@bisect.command(writes_to_repo=True)
+@click.pass_context
+def reset(ctx):
+    """End the bisect session."""
+    repo = ctx.obj.repo
+    repo.remove_gitdir_file(KartRepoFiles.BISECT_STATE)
+    click.echo("Bisect session ended")
+
+
+@bisect.command(writes_to_repo=True)
+@click.pass_context
+@click.argument("command")
+def run(ctx, command):
+    """
+    Test each candidate commit by exporting it to a temporary GeoPackage and running COMMAND using the shell, until
+    the first bad commit is found. The path of the GeoPackage is in the KART_BISECT_GPKG environment variable, and
+    the commit ID is in KART_BISECT_COMMIT.
+
+    COMMAND should exit with 0 if the commit is good, 125 if it can't be tested, or any other code between 1 and
+    127 if it is bad. An exit code of 128 or more aborts the bisect.
+
+    eg: kart bisect run 'python validate.py "$KART_BISECT_GPKG"'
+    """
+    state = BisectState.load(ctx.obj.repo)
+    repo = state.repo
+    next_commit = _echo_next_step(state)
+    while next_commit is not None:
+        with tempfile.TemporaryDirectory() as temp_dir:
+            gpkg_path = Path(temp_dir) / f"{next_commit[:7]}.gpkg"
+            try:
+                export_commit_to_gpkg(repo, next_commit, state.datasets, gpkg_path)
+            except NotFound as e:
+                # The datasets don't exist at this commit, so it can't be tested.
+                click.echo(f"Can't test {next_commit[:7]}: {e}")
+                returncode = SKIP_EXIT_CODE
+            else:
+                returncode = subprocess.run(
+                    command,
+                    shell=True,
+                    env_overrides={
+                        "KART_BISECT_GPKG": str(gpkg_path),
+                        "KART_BISECT_COMMIT": next_commit,
+                    },
+                ).returncode
+        if returncode == 0:
+            verdict = "good"
+        elif returncode == SKIP_EXIT_CODE:
+            verdict = "skip"
+        elif returncode < ABORT_EXIT_CODE:
+            verdict = "bad"
+        else:
+            raise InvalidOperation(
+                f"Bisect aborted - `{command}` exited with code {returncode} at {next_commit[:7]}"
+            )
+        click.echo(f"{next_commit[:7]} is {verdict}")
+        state.mark(next_commit, verdict)
+        next_commit = _echo_next_step(state)
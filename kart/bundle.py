@@ -0,0 +1,192 @@
+import logging
+from pathlib import Path
+
+import click
+import pygit2
+
+from kart.cli_util import KartCommand, KartGroup
+from kart.completion_shared import ref_completer
+from kart.exceptions import (
+    InvalidOperation,
+    NotFound,
+    SubprocessError,
+    INVALID_FILE_FORMAT,
+    NO_CHANGES,
+    NO_COMMIT,
+)
+from kart import subprocess_util as subprocess
+
+L = logging.getLogger("kart.bundle")
+
+BUNDLE_SIGNATURES = (b"# v2 git bundle\n", b"# v3 git bundle\n")
+
+
+def read_bundle_header(path):
+    """
+    Reads the header of a bundle file, as written by `git bundle create`.
+    Returns (prerequisites, refs) - the IDs of the commits that a repository must already have for the bundle to be
+    fetched into it, and a {ref-name: commit-id} dict of the refs in the bundle.
+    """
+    prerequisites, refs = [], {}
+    with open(path, "rb") as f:
+        signature = f.readline()
+        if signature not in BUNDLE_SIGNATURES:
+            raise InvalidOperation(
+                f"{path} is not a bundle", exit_code=INVALID_FILE_FORMAT
+            )
+        for line in f:
+            line = line.decode("utf-8").rstrip("\n")
+            if not line:
+                break
+            if line.startswith("@"):
+                # v3 capabilities, eg the object format.
+                continue
+            if line.startswith("-"):
+                prerequisites.append(line[1:].split(" ", 1)[0])
+            else:
+                oid, ref_name = line.split(" ", 1)
+                refs[ref_name] = oid
+    return prerequisites, refs
+
+
+def check_bundle_prerequisites(repo, prerequisites):
+    """Raises NotFound unless the repo contains every prerequisite commit of a bundle."""
+    missing = [oid for oid in prerequisites if oid not in repo]
+    if missing:
+        raise NotFound(
+            "This repository doesn't have the commits this bundle was created from:\n"
+            + "\n".join(missing)
+            + "\nFetch an earlier bundle - or from a remote - first.",
+            exit_code=NO_COMMIT,
+        )
+
+
+@click.group(cls=KartGroup)
+@click.pass_context
+def bundle(ctx, **kwargs):
+    """
+    Move commits between repositories that can't connect to each other, using bundle files -
+    for example, over sneakernet between disconnected networks.
+    """
+
+
+@bundle.command(cls=KartCommand, name="create")
+@click.pass_context
+@click.option(
+    "--since",
+    shell_complete=ref_completer,
+    help=(
+        "Only bundle the commits made since this commit. The repository the bundle is fetched into must already "
+        "have this commit. Defaults to bundling the entire history."
+    ),
+)
+@click.argument(
+    "file", type=click.Path(dir_okay=False, writable=True, path_type=Path)
+)
+@click.argument("refs", nargs=-1, shell_complete=ref_completer)
+def bundle_create(ctx, since, file, refs):
+    """
+    Write the given branches and tags - by default, the current branch - to the bundle FILE.
+    """
+    repo = ctx.obj.repo
+    if not refs:
+        if repo.head_is_unborn or repo.head_is_detached:
+            raise InvalidOperation(
+                "Not on a branch - specify which branches or tags to bundle"
+            )
+        refs = (repo.head.name,)
+
+    ref_names = []
+    for ref in refs:
+        try:
+            ref_names.append(repo.lookup_reference_dwim(ref).name)
+        except KeyError:
+            raise NotFound(
+                f"No branch or tag found called {ref}", exit_code=NO_COMMIT
+            )
+
+    args = list(ref_names)
+    if since is not None:
+        since_commit = repo.revparse_single(since).peel(pygit2.Commit)
+        walker = repo.walk(None)
+        for ref_name in ref_names:
+            walker.push(repo.references[ref_name].peel(pygit2.Commit).id)
+        walker.hide(since_commit.id)
+        if not any(True for _ in walker):
+            raise NotFound(
+                f"Nothing to bundle - no commits since {since_commit.short_id}",
+                exit_code=NO_CHANGES,
+            )
+        args.append(f"^{since_commit.hex}")
+
+    try:
+        subprocess.check_call(
+            [
+                "git",
+                "-C",
+                repo.path,
+                "bundle",
+                "create",
+                "--quiet",
+                file.resolve(),
+                *args,
+            ]
+        )
+    except subprocess.CalledProcessError as e:
+        raise SubprocessError(
+            f"There was a problem creating the bundle: {e}",
+            called_process_error=e,
+        )
+
+    prerequisites, bundle_refs = read_bundle_header(file)
+    click.echo(f"Created bundle {file} containing {', '.join(bundle_refs)}")
+    if prerequisites:
+        click.echo(
+            f"The repository it is fetched into must already have: {', '.join(prerequisites)}"
+        )
+
+
+@bundle.command(cls=KartCommand, name="fetch", writes_to_repo=True)
+@click.pass_context
+@click.option(
+    "--remote",
+    "remote_name",
+    default="bundle",
+    show_default=True,
+    help="Fetch the bundle's branches as the remote-tracking branches of this remote name.",
+)
+@click.argument("file", type=click.Path(exists=True, dir_okay=False, path_type=Path))
+def bundle_fetch(ctx, remote_name, file):
+    """
+    Fetch the commits in the bundle FILE. The bundle's branches are fetched as remote-tracking branches - for
+    example, main is fetched as bundle/main - and can then be merged with `kart merge`. Tags are fetched as tags.
+    """
+    repo = ctx.obj.repo
+    prerequisites, bundle_refs = read_bundle_header(file)
+    check_bundle_prerequisites(repo, prerequisites)
+
+    refspecs = []
+    for ref_name in bundle_refs:
+        if ref_name.startswith("refs/heads/"):
+            branch = ref_name[len("refs/heads/") :]
+            refspecs.append(f"+{ref_name}:refs/remotes/{remote_name}/{branch}")
+        elif ref_name.startswith("refs/tags/"):
+            refspecs.append(f"{ref_name}:{ref_name}")
+    if not refspecs:
+        raise NotFound(
+            f"Bundle {file} contains no branches or tags", exit_code=NO_COMMIT
+        )
+
+    try:
+        subprocess.check_call(
+            ["git", "-C", repo.path, "fetch", "--quiet", file.resolve(), *refspecs]
+        )
+    except subprocess.CalledProcessError as e:
+        raise SubprocessError(
+            f"There was a problem fetching the bundle: {e}",
+            called_process_error=e,
+        )
+
+    for refspec in refspecs:
+        src, dest = refspec.lstrip("+").split(":")
+        click.echo(f"{bundle_refs[src][:7]}  {src} -> {dest}")
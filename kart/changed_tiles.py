@@ -0,0 +1,208 @@
+import math
+
+import click
+
+from kart import diff_util
+from kart.cli_util import KartCommand, OutputFormatType
+from kart.completion_shared import ref_completer
+from kart.crs_util import make_crs
+from kart.exceptions import InvalidOperation
+from kart.key_filters import RepoKeyFilter
+from kart.output_util import dump_json_output
+
+# Web Mercator can't show the poles - tiles only cover latitudes within this range.
+MAX_LATITUDE = 85.0511287798066
+
+
+def _tile_x(lon, zoom):
+    return min((1 << zoom) - 1, max(0, math.floor((lon + 180) / 360 * (1 << zoom))))
+
+
+def _tile_y(lat, zoom):
+    lat = math.radians(max(-MAX_LATITUDE, min(MAX_LATITUDE, lat)))
+    y = (1 - math.log(math.tan(lat) + 1 / math.cos(lat)) / math.pi) / 2
+    return min((1 << zoom) - 1, max(0, math.floor(y * (1 << zoom))))
+
+
+def tile_ranges_for_envelope(envelope, zoom):
+    """
+    Returns a list of (x_min, x_max, y_min, y_max) ranges of the XYZ tiles at the given zoom that cover the
+    given (w, s, e, n) envelope in WGS 84. Envelopes that cross the anti-meridian (w > e) need two ranges.
+    """
+    w, s, e, n = envelope
+    lon_ranges = [(w, e)] if w <= e else [(w, 180), (-180, e)]
+    # Tile rows count down from the north.
+    y_min, y_max = _tile_y(n, zoom), _tile_y(s, zoom)
+    return [
+        (_tile_x(range_w, zoom), _tile_x(range_e, zoom), y_min, y_max)
+        for range_w, range_e in lon_ranges
+    ]
+
+
+def _wgs84_transform(repo, dataset):
+    from kart.spatial_filter.index import CrsHelper
+
+    crs_defs = dataset.crs_definitions()
+    if not crs_defs:
+        return None
+    crs = make_crs(list(crs_defs.values())[0])
+    return CrsHelper(repo).transform_from_src_crs(crs)
+
+
+def changed_envelopes(repo, base_rs, target_rs, repo_key_filter):
+    """
+    Yields the (w, s, e, n) envelope in WGS 84 of the old and new geometry of every feature that changed between the
+    given commits. Yields None for geometries whose envelope couldn't be calculated.
+    """
+    from kart.spatial_filter.index import get_envelope_for_indexing
+
+    repo_diff = diff_util.get_repo_diff(
+        base_rs, target_rs, repo_key_filter=repo_key_filter
+    )
+    for ds_path, ds_diff in repo_diff.items():
+        if "feature" not in ds_diff:
+            continue
+        old_ds = base_rs.datasets().get(ds_path)
+        new_ds = target_rs.datasets().get(ds_path)
+        sides = []
+        for dataset, attr in ((old_ds, "old_value"), (new_ds, "new_value")):
+            if dataset is not None and dataset.geom_column_name:
+                transform = _wgs84_transform(repo, dataset)
+                sides.append((attr, dataset.geom_column_name, transform))
+
+        for key, delta in ds_diff["feature"].items():
+            for attr, geom_column, transform in sides:
+                value = getattr(delta, attr)
+                geom = value.get(geom_column) if value is not None else None
+                if geom is None or geom.is_empty():
+                    continue
+                if transform is None:
+                    yield None
+                    continue
+                yield get_envelope_for_indexing(
+                    geom, [transform], f"{ds_path}:feature:{key}"
+                )
+
+
+def _too_many_tiles(max_tiles):
+    raise InvalidOperation(
+        f"More than {max_tiles} tiles have changed - use a lower --max-zoom, or a higher --max-tiles"
+    )
+
+
+def get_changed_tiles(
+    repo, base, target, min_zoom, max_zoom, filters=(), max_tiles=None
+):
+    """
+    Returns (tiles, unknown_count) where tiles is a dict of {zoom: set of (x, y)} - every XYZ tile that contains
+    part of a feature that changed between the given commits - and unknown_count is the number of changed
+    geometries that couldn't be placed in any tile.
+    """
+    base_rs = repo.structure(base)
+    target_rs = repo.structure(target)
+    repo_key_filter = RepoKeyFilter.build_from_user_patterns(filters)
+
+    tiles = {zoom: set() for zoom in range(min_zoom, max_zoom + 1)}
+    unknown_count = 0
+    for envelope in changed_envelopes(repo, base_rs, target_rs, repo_key_filter):
+        if envelope is None:
+            unknown_count += 1
+            continue
+        for zoom, zoom_tiles in tiles.items():
+            for x_min, x_max, y_min, y_max in tile_ranges_for_envelope(envelope, zoom):
+                if max_tiles and (x_max - x_min + 1) * (y_max - y_min + 1) > max_tiles:
+                    _too_many_tiles(max_tiles)
+                zoom_tiles.update(
+                    (x, y)
+                    for x in range(x_min, x_max + 1)
+                    for y in range(y_min, y_max + 1)
+                )
+        if max_tiles and sum(len(t) for t in tiles.values()) > max_tiles:
+            _too_many_tiles(max_tiles)
+    return tiles, unknown_count
+
+
+@click.command("changed-tiles", cls=KartCommand)
+@click.pass_context
+@click.option(
+    "--min-zoom",
+    type=click.IntRange(0, 30),
+    default=0,
+    show_default=True,
+    help="The lowest zoom level to list tiles for.",
+)
+@click.option(
+    "--max-zoom",
+    type=click.IntRange(0, 30),
+    default=14,
+    show_default=True,
+    help="The highest zoom level to list tiles for.",
+)
+@click.option(
+    "--max-tiles",
+    type=click.IntRange(1),
+    default=1_000_000,
+    show_default=True,
+    help="Fail rather than list more than this many tiles.",
+)
+@click.option(
+    "--output-format",
+    "-o",
+    type=OutputFormatType(
+        output_types=["text", "json"],
+        allow_text_formatstring=False,
+    ),
+    default="text",
+)
+@click.argument("base", shell_complete=ref_completer)
+@click.argument("target", shell_complete=ref_completer)
+@click.argument("filters", nargs=-1)
+def changed_tiles(
+    ctx, min_zoom, max_zoom, max_tiles, output_format, base, target, filters
+):
+    """
+    List the XYZ map tiles affected by the feature changes between two commits, so that map tile caches can be
+    invalidated precisely. A tile is affected if it overlaps the bounding box of the old or new geometry of any
+    feature that was inserted, updated or deleted. Tiles use the usual Web Mercator tiling scheme and are listed as
+    Z/X/Y.
+
+    FILTERS can be used to only consider some datasets or features, as for `kart diff`.
+
+    eg: kart changed-tiles v1.0 v1.1 --min-zoom 10 --max-zoom 16
+    """
+    if min_zoom > max_zoom:
+        raise click.BadParameter(
+            "--min-zoom can't be greater than --max-zoom", param_hint="--min-zoom"
+        )
+    repo = ctx.obj.repo
+    tiles, unknown_count = get_changed_tiles(
+        repo, base, target, min_zoom, max_zoom, filters=filters, max_tiles=max_tiles
+    )
+    tile_names = [
+        f"{zoom}/{x}/{y}" for zoom, xys in tiles.items() for x, y in sorted(xys)
+    ]
+    if unknown_count:
+        click.echo(
+            f"Warning: couldn't find the location of {unknown_count} changed geometries - "
+            "they may be missing a CRS definition",
+            err=True,
+        )
+
+    output_type, fmt = output_format
+    if output_type == "json":
+        dump_json_output(
+            {
+                "kart.changed-tiles/v1": {
+                    "base": repo.structure(base).commit.hex,
+                    "target": repo.structure(target).commit.hex,
+                    "minZoom": min_zoom,
+                    "maxZoom": max_zoom,
+                    "tiles": tile_names,
+                }
+            },
+            "-",
+        )
+        return
+
+    for tile_name in tile_names:
+        click.echo(tile_name)
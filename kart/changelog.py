@@ -0,0 +1,273 @@
+import logging
+import sys
+
+import click
+
+from kart import diff_util
+from kart.cli_util import KartCommand
+from kart.completion_shared import ref_completer
+from kart.key_filters import RepoKeyFilter
+from kart.output_util import dump_json_output
+
+L = logging.getLogger("kart.changelog")
+
+SCHEMA_KEY = "schema.json"
+
+# The types of items in each dataset type that are summarised - features for table datasets, tiles for the others.
+ITEM_TYPES = ("feature", "tile")
+
+
+def schema_changes(old_schema, new_schema):
+    """
+    Returns a dict describing how columns changed between the given schemas, matching up columns by ID.
+    Columns that changed in more ways than just their name are "changed".
+    """
+    old_columns = {c.id: c for c in old_schema.columns} if old_schema else {}
+    new_columns = {c.id: c for c in new_schema.columns} if new_schema else {}
+    result = {"added": [], "removed": [], "renamed": [], "changed": []}
+    for col_id, new_col in new_columns.items():
+        old_col = old_columns.get(col_id)
+        if old_col is None:
+            result["added"].append(new_col.name)
+            continue
+        if old_col.name != new_col.name:
+            result["renamed"].append({"old": old_col.name, "new": new_col.name})
+        old_rest = {k: v for k, v in old_col.items() if k != "name"}
+        new_rest = {k: v for k, v in new_col.items() if k != "name"}
+        if old_rest != new_rest:
+            result["changed"].append(new_col.name)
+    for col_id, old_col in old_columns.items():
+        if col_id not in new_columns:
+            result["removed"].append(old_col.name)
+    return {k: v for k, v in result.items() if v}
+
+
+def dataset_extent(dataset):
+    """
+    Returns the 2D extent of all the geometries in the given table dataset, in the dataset's own CRS,
+    as [min-x, min-y, max-x, max-y] - or None if it has no geometries.
+    """
+    geom_columns = dataset.schema.geometry_columns
+    if not geom_columns:
+        return None
+    geom_name = geom_columns[0].name
+    extent = None
+    for feature in dataset.features():
+        geom = feature.get(geom_name)
+        if geom is None:
+            continue
+        envelope = geom.envelope(only_2d=True, calculate_if_missing=True)
+        if envelope is None:
+            continue
+        min_x, max_x, min_y, max_y = envelope
+        if extent is None:
+            extent = [min_x, min_y, max_x, max_y]
+        else:
+            extent = [
+                min(extent[0], min_x),
+                min(extent[1], min_y),
+                max(extent[2], max_x),
+                max(extent[3], max_y),
+            ]
+    return extent
+
+
+def summarise_dataset_diff(ds_diff, old_ds, new_ds, *, include_extent=True):
+    if old_ds is None:
+        status = "added"
+    elif new_ds is None:
+        status = "deleted"
+    else:
+        status = "modified"
+    result = {"status": status}
+
+    for item_type in ITEM_TYPES:
+        if item_type in ds_diff:
+            result[f"{item_type}s"] = ds_diff[item_type].type_counts()
+
+    meta_diff = ds_diff.get("meta", {})
+    if SCHEMA_KEY in meta_diff:
+        old_schema = old_ds.schema if old_ds is not None else None
+        new_schema = new_ds.schema if new_ds is not None else None
+        result["schema"] = schema_changes(old_schema, new_schema)
+    other_meta = sorted(k for k in meta_diff if k != SCHEMA_KEY)
+    if other_meta:
+        result["meta"] = other_meta
+
+    is_table = any(
+        ds is not None and ds.DATASET_TYPE == "table" for ds in (old_ds, new_ds)
+    )
+    if is_table:
+        result["featureCount"] = {
+            "old": old_ds.feature_count if old_ds is not None else 0,
+            "new": new_ds.feature_count if new_ds is not None else 0,
+        }
+        if include_extent and "feature" in ds_diff:
+            result["extent"] = {
+                "old": dataset_extent(old_ds) if old_ds is not None else None,
+                "new": dataset_extent(new_ds) if new_ds is not None else None,
+            }
+    return result
+
+
+def get_changelog(repo, since, until="HEAD", filters=(), include_extent=True):
+    base_rs = repo.structure(since)
+    target_rs = repo.structure(until)
+    repo_key_filter = RepoKeyFilter.build_from_user_patterns(filters)
+    repo_diff = diff_util.get_repo_diff(
+        base_rs, target_rs, repo_key_filter=repo_key_filter
+    )
+    datasets = {}
+    for ds_path, ds_diff in repo_diff.items():
+        old_ds = base_rs.datasets().get(ds_path)
+        new_ds = target_rs.datasets().get(ds_path)
+        datasets[ds_path] = summarise_dataset_diff(
+            ds_diff, old_ds, new_ds, include_extent=include_extent
+        )
+    return {
+        "since": {"ref": since, "commit": base_rs.commit.hex},
+        "until": {"ref": until, "commit": target_rs.commit.hex},
+        "datasets": datasets,
+    }
+
+
+def _plural(count, noun):
+    return f"{count} {noun}" if count == 1 else f"{count} {noun}s"
+
+
+def _format_extent(extent):
+    if extent is None:
+        return "none"
+    return "[" + ", ".join(f"{v:.6g}" for v in extent) + "]"
+
+
+def _dataset_lines(summary, code):
+    lines = []
+    if summary["status"] != "modified":
+        lines.append(f"Dataset {summary['status']}")
+
+    for item_type in ITEM_TYPES:
+        counts = summary.get(f"{item_type}s")
+        if not counts:
+            continue
+        parts = []
+        for delta_type, verb in (
+            ("inserts", "added"),
+            ("updates", "modified"),
+            ("deletes", "removed"),
+        ):
+            if counts.get(delta_type):
+                parts.append(f"{_plural(counts[delta_type], item_type)} {verb}")
+        lines.append(", ".join(parts))
+
+    schema = summary.get("schema")
+    if schema:
+        parts = []
+        for change_type in ("added", "removed", "changed"):
+            if schema.get(change_type):
+                names = ", ".join(code(n) for n in schema[change_type])
+                parts.append(f"{change_type} {names}")
+        for rename in schema.get("renamed", []):
+            parts.append(f"renamed {code(rename['old'])} to {code(rename['new'])}")
+        lines.append(f"Schema: {'; '.join(parts)}")
+
+    if summary.get("meta"):
+        lines.append(
+            "Metadata changed: " + ", ".join(code(k) for k in summary["meta"])
+        )
+
+    feature_count = summary.get("featureCount")
+    if feature_count and feature_count["old"] != feature_count["new"]:
+        lines.append(
+            f"Feature count: {feature_count['old']} → {feature_count['new']}"
+        )
+
+    extent = summary.get("extent")
+    if extent:
+        if extent["old"] == extent["new"]:
+            lines.append(f"Extent unchanged: {_format_extent(extent['new'])}")
+        else:
+            lines.append(
+                f"Extent: {_format_extent(extent['old'])} → {_format_extent(extent['new'])}"
+            )
+    return lines
+
+
+def changelog_to_markdown(changelog):
+    since, until = changelog["since"], changelog["until"]
+    lines = [
+        f"# Changes from {since['ref']} ({since['commit'][:7]}) to {until['ref']} ({until['commit'][:7]})",
+        "",
+    ]
+    if not changelog["datasets"]:
+        lines.append("No changes.")
+    for ds_path, summary in changelog["datasets"].items():
+        lines.append(f"## {ds_path}")
+        lines.append("")
+        dataset_lines = _dataset_lines(summary, lambda s: f"`{s}`")
+        lines.extend(f"- {line}" for line in dataset_lines)
+        lines.append("")
+    return "\n".join(lines).rstrip()
+
+
+def changelog_to_text(changelog):
+    since, until = changelog["since"], changelog["until"]
+    lines = [
+        f"Changes from {since['ref']} ({since['commit'][:7]}) to {until['ref']} ({until['commit'][:7]}):"
+    ]
+    if not changelog["datasets"]:
+        lines.append("  No changes")
+    for ds_path, summary in changelog["datasets"].items():
+        lines.append(f"  {ds_path}:")
+        lines.extend(f"    {line}" for line in _dataset_lines(summary, str))
+    return "\n".join(lines)
+
+
+@click.command(cls=KartCommand)
+@click.pass_context
+@click.option(
+    "--since",
+    required=True,
+    shell_complete=ref_completer,
+    help="The tag or commit of the previous release.",
+)
+@click.option(
+    "--until",
+    default="HEAD",
+    show_default=True,
+    shell_complete=ref_completer,
+    help="The tag or commit of the new release.",
+)
+@click.option(
+    "--output-format",
+    "-o",
+    type=click.Choice(["text", "markdown", "json"]),
+    default="text",
+)
+@click.option(
+    "--extent/--no-extent",
+    default=True,
+    help=(
+        "Whether to show how the extent of each dataset with changed features has changed. "
+        "Calculating the extent requires reading every feature."
+    ),
+)
+@click.argument("filters", nargs=-1, metavar="[FILTERS]...")
+def changelog(ctx, since, until, output_format, extent, filters):
+    """
+    Summarise the changes to each dataset between two releases, for use in data release notes.
+
+    For each dataset, shows how many features were added, modified and removed, any schema and other metadata
+    changes, and how the feature count and extent changed. Use -o markdown for output that can be pasted into
+    release notes.
+
+    FILTERS restrict the changelog to particular datasets - see `kart diff --help`.
+    """
+    repo = ctx.obj.repo
+    result = get_changelog(repo, since, until, filters, include_extent=extent)
+    if output_format == "json":
+        dump_json_output({"kart.changelog/v1": result}, sys.stdout)
+    elif output_format == "markdown":
+        click.echo(changelog_to_markdown(result))
+    else:
+        click.echo(changelog_to_text(result))
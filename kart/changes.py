@@ -0,0 +1,166 @@
+import json
+import logging
+import sys
+
+import click
+import pygit2
+
+from kart import diff_util
+from kart.cli_util import KartCommand
+from kart.completion_shared import ref_completer
+from kart.exceptions import InvalidOperation
+from kart.key_filters import RepoKeyFilter
+from kart.log import commit_obj_to_json
+from kart.structs import CommitWithReference
+from kart.tabular.feature_output import feature_as_json
+
+L = logging.getLogger("kart.changes")
+
+CHANGES_VERSION = "kart.changes/v1"
+
+
+def resolve_changes_range(repo, since, until="HEAD"):
+    """
+    Resolves the given refs to a (since_commit, until_commit) tuple - raising an InvalidOperation if changes can't be
+    streamed from one to the other, since only changes that were made after since_commit are streamed.
+    """
+    since_commit = CommitWithReference.resolve(repo, since).commit
+    until_commit = CommitWithReference.resolve(repo, until).commit
+    if since_commit.id != until_commit.id and not repo.descendant_of(
+        until_commit.id, since_commit.id
+    ):
+        raise InvalidOperation(
+            f"{until} ({until_commit.short_id}) doesn't contain {since} ({since_commit.short_id}) - "
+            "the history must have been rewritten since changes were last consumed"
+        )
+    return since_commit, until_commit
+
+
+def change_events(repo, since_commit, until_commit, filters=()):
+    """
+    Yields an ordered stream of change events - dicts that can be dumped as JSON - describing every change made to
+    the repository's datasets after since_commit, up to and including until_commit.
+
+    Each commit on the first-parent history of until_commit is described in turn, oldest first. Its commit event is
+    followed by an event for each meta-item it changed, and then one for each feature it changed, in dataset order
+    and then primary key order. A merge commit is described by how it changed its first parent. The last event is a
+    checkpoint - consumers should store its commit, and pass it as `since` when they next consume changes.
+    """
+    repo_key_filter = RepoKeyFilter.build_from_user_patterns(filters)
+    yield {
+        "type": "version",
+        "version": CHANGES_VERSION,
+        "outputFormat": "JSONL+hexwkb",
+        "since": since_commit.hex,
+        "until": until_commit.hex,
+    }
+
+    walker = repo.walk(
+        until_commit.id, pygit2.GIT_SORT_TOPOLOGICAL | pygit2.GIT_SORT_REVERSE
+    )
+    walker.simplify_first_parent()
+    walker.hide(since_commit.id)
+    for commit in walker:
+        base_rs = repo.structure(f"{commit.hex}^?")
+        target_rs = repo.structure(commit.hex)
+        repo_diff = diff_util.get_repo_diff(
+            base_rs, target_rs, repo_key_filter=repo_key_filter
+        )
+        if not repo_diff:
+            continue
+        yield {"type": "commit", "value": commit_obj_to_json(commit)}
+        for ds_path, ds_diff in sorted(repo_diff.items()):
+            yield from _dataset_change_events(commit, ds_path, ds_diff)
+
+    yield {"type": "checkpoint", "commit": until_commit.hex}
+
+
+def _dataset_change_events(commit, ds_path, ds_diff):
+    if "meta" in ds_diff:
+        for key, delta in ds_diff["meta"].sorted_items():
+            yield {
+                "type": "meta",
+                "commit": commit.hex,
+                "dataset": ds_path,
+                "key": key,
+                "change": delta.to_plus_minus_dict(),
+            }
+
+    if "feature" not in ds_diff:
+        return
+    for key, delta in ds_diff["feature"].sorted_items():
+        change = {}
+        if delta.old:
+            change["-"] = feature_as_json(delta.old_value, delta.old_key)
+        if delta.new:
+            change["+"] = feature_as_json(delta.new_value, delta.new_key)
+        yield {
+            "type": "feature",
+            "commit": commit.hex,
+            "dataset": ds_path,
+            "operation": delta.type,
+            "key": delta.new_key if delta.new else delta.old_key,
+            "change": change,
+        }
+
+
+def _event_as_text(event):
+    if event["type"] == "commit":
+        value = event["value"]
+        return f"commit {value['commit']}: {value['message'].splitlines()[0]}"
+    elif event["type"] == "meta":
+        return f"  {event['dataset']}: {event['key']} changed"
+    elif event["type"] == "feature":
+        return f"  {event['dataset']}: {event['operation']} {event['key']}"
+    elif event["type"] == "checkpoint":
+        return f"Up to date at {event['commit']}"
+    return None
+
+
+@click.command(cls=KartCommand)
+@click.pass_context
+@click.option(
+    "--since",
+    required=True,
+    shell_complete=ref_completer,
+    help="Stream the changes made after this commit - usually the checkpoint from the last time changes were consumed.",
+)
+@click.option(
+    "--until",
+    default="HEAD",
+    show_default=True,
+    shell_complete=ref_completer,
+    help="Stream the changes made up to and including this commit.",
+)
+@click.option(
+    "--output-format",
+    "-o",
+    type=click.Choice(["text", "ndjson"]),
+    default="text",
+)
+@click.argument("filters", nargs=-1, metavar="[FILTERS]...")
+def changes(ctx, since, until, output_format, filters):
+    """
+    Stream every feature-level change made since a commit, in the order the changes were made - so that downstream
+    systems can consume changes incrementally, rather than reloading whole snapshots of each dataset.
+
+    With -o ndjson, outputs one JSON event per line: a commit event for each commit, followed by a meta event for
+    each meta-item it changed and a feature event for each feature it changed. The last event is a checkpoint -
+    store its commit, and pass it as --since next time. To serve the events to other systems, run this from a
+    service of your own, which can handle authentication.
+
+    FILTERS restrict the changes to particular datasets or features - see `kart diff --help`.
+    """
+    repo = ctx.obj.repo
+    since_commit, until_commit = resolve_changes_range(repo, since, until)
+    events = change_events(repo, since_commit, until_commit, filters)
+    if output_format == "ndjson":
+        for event in events:
+            json.dump(event, sys.stdout)
+            sys.stdout.write("\n")
+    else:
+        for event in events:
+            text = _event_as_text(event)
+            if text is not None:
+                click.echo(text)
+
@@ -11,6 +11,7 @@ from kart.exceptions import (
     InvalidOperation,
     NotFound,
 )
+from kart.guardrails import check_deletion_guardrails
 from kart.key_filters import RepoKeyFilter
 from kart.promisor_utils import get_partial_clone_envelope
 from kart.spatial_filter import SpatialFilterString, spatial_filter_help_text
@@ -24,7 +25,7 @@ _DISCARD_CHANGES_HELP_MESSAGE = (
 )
 
 
-@click.command(cls=KartCommand)
+@click.command(cls=KartCommand, writes_to_repo=True)
 @click.pass_context
 @click.option("new_branch", "-b", help="Name for new branch")
 @click.option(
@@ -62,6 +63,12 @@ _DISCARD_CHANGES_HELP_MESSAGE = (
     multiple=True,
     help="Request that a particular dataset *not* be checked out (one which is currently configured to be checked out)",
 )
+@click.option(
+    "--yes",
+    "-y",
+    is_flag=True,
+    help="Don't ask for confirmation, even if more features would be deleted than the configured guardrails allow.",
+)
 @click.argument("refish", default=None, required=False, shell_complete=ref_completer)
 def checkout(
     ctx,
@@ -72,6 +79,7 @@ def checkout(
     spatial_filter_spec,
     do_checkout_spec,
     non_checkout_spec,
+    yes,
     refish,
 ):
     """Switch branches or restore working tree files"""
@@ -179,6 +187,11 @@ def checkout(
             f"A branch named '{new_branch}' already exists.", param_hint="branch"
         )
 
+    if do_switch_commit:
+        check_deletion_guardrails(
+            repo, commit, yes=yes, action=f"Checking out {refish}"
+        )
+
     # Finished pre-flight checks - start action:
 
     if do_refetch:
@@ -303,7 +316,7 @@ def git_refetch(repo, promisor_remote, spec):
         )
 
 
-@click.command(cls=KartCommand)
+@click.command(cls=KartCommand, writes_to_repo=True)
 @click.pass_context
 @click.option("--create", "-c", help="Create a new branch")
 @click.option(
@@ -320,8 +333,14 @@ def git_refetch(repo, promisor_remote, spec):
     help="If a local branch of given name doesn't exist, but a remote does, "
     "this option guesses that the user wants to create a local to track the remote",
 )
+@click.option(
+    "--yes",
+    "-y",
+    is_flag=True,
+    help="Don't ask for confirmation, even if more features would be deleted than the configured guardrails allow.",
+)
 @click.argument("refish", default=None, required=False, shell_complete=ref_completer)
-def switch(ctx, create, force_create, discard_changes, do_guess, refish):
+def switch(ctx, create, force_create, discard_changes, do_guess, yes, refish):
     """
     Switch branches
 
@@ -364,6 +383,10 @@ def switch(ctx, create, force_create, discard_changes, do_guess, refish):
         do_switch_commit = repo.head_commit != commit
         if do_switch_commit and not discard_changes:
             ctx.obj.check_not_dirty(_DISCARD_CHANGES_HELP_MESSAGE)
+        if do_switch_commit:
+            check_deletion_guardrails(
+                repo, commit, yes=yes, action=f"Switching to {start_point}"
+            )
 
         if new_branch in repo.branches and not force_create:
             raise click.BadParameter(
@@ -422,6 +445,10 @@ def switch(ctx, create, force_create, discard_changes, do_guess, refish):
         do_switch_commit = repo.head_commit != commit
         if do_switch_commit and not discard_changes:
             ctx.obj.check_not_dirty(_DISCARD_CHANGES_HELP_MESSAGE)
+        if do_switch_commit:
+            check_deletion_guardrails(
+                repo, commit, yes=yes, action=f"Switching to {refish}"
+            )
 
         if _is_in_branches(existing_branch.shorthand, repo.branches.local):
             branch = existing_branch
@@ -464,7 +491,7 @@ def _is_in_branches(branch_name, branches):
         return False
 
 
-@click.command(cls=KartCommand)
+@click.command(cls=KartCommand, writes_to_repo=True)
 @click.pass_context
 @click.option(
     "--source",
@@ -477,8 +504,14 @@ def _is_in_branches(branch_name, branches):
     default="HEAD",
     shell_complete=ref_completer,
 )
+@click.option(
+    "--yes",
+    "-y",
+    is_flag=True,
+    help="Don't ask for confirmation, even if more features would be deleted than the configured guardrails allow.",
+)
 @click.argument("filters", nargs=-1)
-def restore(ctx, source, filters):
+def restore(ctx, source, yes, filters):
     """
     Restore specified paths in the working copy with some contents from the given restore source.
     By default, restores the entire working copy to the commit at HEAD (so, discards all uncommitted changes).
@@ -495,6 +528,10 @@ def restore(ctx, source, filters):
         raise NotFound(f"{source} is not a commit or tree", exit_code=NO_COMMIT)
 
     repo_key_filter = RepoKeyFilter.build_from_user_patterns(filters)
+    if repo_key_filter.match_all:
+        check_deletion_guardrails(
+            repo, commit_or_tree, yes=yes, action=f"Restoring from {source}"
+        )
 
     repo.working_copy.reset(
         commit_or_tree,
@@ -503,7 +540,7 @@ def restore(ctx, source, filters):
     )
 
 
-@click.command(cls=KartCommand)
+@click.command(cls=KartCommand, writes_to_repo=True)
 @click.pass_context
 @click.option(
     "--discard-changes",
@@ -512,8 +549,14 @@ def restore(ctx, source, filters):
     is_flag=True,
     help="Discard local changes in working copy if necessary",
 )
+@click.option(
+    "--yes",
+    "-y",
+    is_flag=True,
+    help="Don't ask for confirmation, even if more features would be deleted than the configured guardrails allow.",
+)
 @click.argument("refish", default="HEAD", shell_complete=ref_completer)
-def reset(ctx, discard_changes, refish):
+def reset(ctx, discard_changes, yes, refish):
     """
     Reset the branch head to point to a particular commit.
     Defaults to HEAD, which has no effect unless --discard-changes is also specified.
@@ -535,6 +578,10 @@ def reset(ctx, discard_changes, refish):
     do_switch_commit = repo.head_commit != commit
     if do_switch_commit and not discard_changes:
         ctx.obj.check_not_dirty(_DISCARD_CHANGES_HELP_MESSAGE)
+    if do_switch_commit:
+        check_deletion_guardrails(
+            repo, commit, yes=yes, action=f"Resetting to {refish}"
+        )
 
     head_branch = repo.head_branch
     if head_branch is not None:
@@ -19,38 +19,83 @@ from kart.cli_util import (
     add_help_subcommand,
     call_and_exit_flag,
     KartGroup,
+    MutexOption,
+    tls_git_config_args,
+    tls_options,
 )
+from kart.audit import start_audit
 from kart.context import Context
+from kart.logging_util import VERBOSITY_LEVELS, configure_logging
 from kart.parse_args import PreserveDoubleDash
 from kart import subprocess_util as subprocess
 
 MODULE_COMMANDS = {
     "annotations.cli": {"build-annotations"},
     "apply": {"apply"},
+    "audit": {"audit"},
+    "backup": {"backup", "restore-backup"},
+    "bisect": {"bisect"},
     "branch": {"branch"},
+    "bundle": {"bundle"},
+    "changed_tiles": {"changed-tiles"},
+    "changelog": {"changelog"},
+    "changes": {"changes"},
     "checkout": {"checkout", "reset", "restore", "switch"},
     "clone": {"clone"},
     "conflicts": {"conflicts"},
     "commit": {"commit"},
+    "compact_history": {"compact-history"},
     "create_workingcopy": {"create-workingcopy"},
     "data": {"data"},
     "diff": {"diff"},
+    "export": {"export"},
+    "features_at": {"features-at"},
     "fsck": {"fsck"},
+    "guardrails": {"guardrails"},
     "helper": {"helper"},
+    "id_map": {"id-map"},
+    "identity": {"whoami"},
     "import_": {"import"},
+    "integrity": {"check-integrity"},
     "init": {"init"},
+    "iso_metadata": {"export-metadata"},
+    "kafka_publish": {"publish"},
+    "lineage": {"lineage"},
+    "lock": {"lock"},
     "lfs_commands": {"lfs+"},
     "log": {"log"},
     "merge": {"merge"},
     "meta": {"commit-files", "meta"},
+    "mirror": {"mirror"},
+    "protect": {"protect"},
+    "publish_profile": {"profile"},
     "pull": {"pull"},
     "raster.import_": {"raster-import"},
+    "release": {"release"},
     "resolve": {"resolve"},
+    "rpc": {"rpc"},
+    "schema_log": {"schema"},
+    "search": {"search"},
+    "session": {"session"},
     "show": {"create-patch", "show"},
     "spatial_filter": {"spatial-filter"},
+    "stats": {"stats"},
     "status": {"status"},
+    "style": {"style"},
+    "sync": {"sync"},
     "upgrade": {"upgrade"},
+    "wfst": {"push-wfst"},
+    "workspace": {"workspace"},
+    "tabular.archive": {"archive"},
+    "tabular.clean_geometry": {"clean-geometry"},
+    "tabular.diff_external": {"diff-external"},
+    "tabular.generate_fixture": {"generate-fixture"},
     "tabular.import_": {"table-import"},
+    "tabular.load_ext": {"load-ext"},
+    "tabular.load_fgdb": {"load-fgdb"},
+    "tabular.load_ogr": {"load-ogr"},
+    "tabular.replace": {"replace"},
+    "tabular.transform": {"transform"},
     "point_cloud.import_": {"point-cloud-import"},
     "install": {"install"},
     "add_dataset": {"add-dataset"},
@@ -171,6 +216,42 @@ def print_version(ctx):
     help="Show version information and exit.",
 )
 @click.option("-v", "--verbose", count=True, help="Repeat for more verbosity")
+@click.option(
+    "--verbosity",
+    type=click.Choice(list(VERBOSITY_LEVELS)),
+    cls=MutexOption,
+    exclusive_with=["verbose"],
+    help="The level of log messages to show on stderr.",
+)
+@click.option(
+    "--log-file",
+    type=click.Path(dir_okay=False, writable=True),
+    envvar="KART_LOG_FILE",
+    help=(
+        "Also append log messages to this file - at INFO level, or DEBUG if more verbosity is requested. "
+        "Can also be set using KART_LOG_FILE."
+    ),
+)
+@click.option(
+    "--log-format",
+    type=click.Choice(["text", "json"]),
+    default="text",
+    envvar="KART_LOG_FORMAT",
+    help=(
+        "Format of log messages. json writes one JSON object per line, which suits log aggregators. "
+        "Can also be set using KART_LOG_FORMAT."
+    ),
+)
+# NOTE: this option isn't used in `cli`, but it is used in `kart.process_lock.start_write_lock`.
+@click.option(
+    "--wait",
+    is_flag=True,
+    envvar="KART_WAIT",
+    help=(
+        "If another Kart process is writing to the repository, wait for it to finish rather than failing. "
+        "Can also be set using KART_WAIT."
+    ),
+)
 # NOTE: this option isn't used in `cli`, but it is used in `PdbGroup` above.
 @click.option(
     "--post-mortem",
@@ -179,24 +260,27 @@ def print_version(ctx):
     help="Interactively debug uncaught exceptions",
 )
 @click.pass_context
-def cli(ctx, repo_dir, verbose, post_mortem):
+def cli(ctx, repo_dir, verbose, verbosity, log_file, log_format, wait, post_mortem):
     ctx.ensure_object(Context)
     if repo_dir:
         ctx.obj.user_repo_path = repo_dir
 
     # default == WARNING; -v == INFO; -vv == DEBUG
-    ctx.obj.verbosity = verbose
-    log_level = logging.WARNING - min(10 * verbose, 20)
-    if verbose >= 2:
-        fmt = "%(asctime)s T%(thread)d %(levelname)s %(name)s [%(filename)s:%(lineno)d] - %(message)s"
+    if verbosity:
+        log_level, verbose = VERBOSITY_LEVELS[verbosity]
     else:
-        fmt = "%(asctime)s %(levelname)s %(name)s - %(message)s"
-    logging.basicConfig(level=log_level, format=fmt)
+        log_level = logging.WARNING - min(10 * verbose, 20)
+    ctx.obj.verbosity = verbose
+    configure_logging(
+        log_level, detailed=verbose >= 2, log_file=log_file, log_format=log_format
+    )
 
     if verbose >= 3:
         # enable SQLAlchemy query logging
         logging.getLogger("sqlalchemy.engine").setLevel("INFO")
 
+    start_audit(ctx)
+
 
 # straight process-replace commands
 
@@ -210,12 +294,49 @@ def cli(ctx, repo_dir, verbose, post_mortem):
     default=True,
     help="Whether to report progress to stderr",
 )
+@click.option(
+    "--ignore-locks",
+    is_flag=True,
+    default=False,
+    help="Push even if the commits change datasets that another user has locked with `kart lock acquire`.",
+)
+@click.option(
+    "--allow-large-changes",
+    is_flag=True,
+    default=False,
+    help=(
+        "Push even if the commits delete more features, or move a feature further, than the guardrails configured "
+        "with kart.guardrails.push.* allow. To skip the remote's own check, also push with "
+        "-o kart.allowLargeChanges."
+    ),
+)
+@tls_options
 @click.argument("args", nargs=-1, type=click.UNPROCESSED)
-def push(ctx, do_progress, args):
+def push(ctx, do_progress, ignore_locks, allow_large_changes, cert, key, ca, args):
     """Update remote refs along with associated objects"""
+    git_config_args = tls_git_config_args(cert, key, ca)
+    if not (ignore_locks and allow_large_changes):
+        from kart.lock import push_positional_args
+
+        repo = ctx.obj.repo
+        positional_args = push_positional_args(args)
+        if positional_args and positional_args[0] in repo.remotes.names():
+            remote = positional_args[0]
+        else:
+            remote = repo.head_remote_name_or_default
+        if remote is not None and not ignore_locks:
+            from kart.lock import check_push_not_locked
+
+            check_push_not_locked(repo, remote, args, git_config_args)
+        if remote is not None and not allow_large_changes:
+            from kart.guardrails import check_push_change_size
+
+            check_push_change_size(repo, remote, args)
+
     ctx.invoke(
         git,
         args=[
+            *git_config_args,
             "push",
             "--progress" if do_progress else "--quiet",
             *args,
@@ -232,12 +353,14 @@ def push(ctx, do_progress, args):
     default=True,
     help="Whether to report progress to stderr",
 )
+@tls_options
 @click.argument("args", nargs=-1, type=click.UNPROCESSED)
-def fetch(ctx, do_progress, args):
+def fetch(ctx, do_progress, cert, key, ca, args):
     """Download objects and refs from another repository"""
     ctx.invoke(
         git,
         args=[
+            *tls_git_config_args(cert, key, ca),
             "fetch",
             "--progress" if do_progress else "--quiet",
             *args,
@@ -282,6 +405,10 @@ def config(ctx, args):
 @click.argument("args", nargs=-1, type=click.UNPROCESSED)
 def gc(ctx, args):
     """Cleanup unnecessary files and optimize the local repository"""
+    from kart.data import expire_tombstones
+
+    for ds_path in expire_tombstones(ctx.obj.repo):
+        click.echo(f"Expired tombstone for deleted dataset {ds_path}")
     ctx.invoke(git, args=["gc", *args])
 
 
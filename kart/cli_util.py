@@ -19,10 +19,22 @@ L = logging.getLogger("kart.cli_util")
 
 
 class KartCommand(click.Command):
+    def __init__(self, *args, writes_to_repo=False, **kwargs):
+        super().__init__(*args, **kwargs)
+        # Commands that change the repository's refs or working copy hold its write lock - see kart.process_lock.
+        self.writes_to_repo = writes_to_repo
+
     def parse_args(self, ctx, args):
         ctx.unparsed_args = list(args)
         super().parse_args(ctx, args)
 
+    def invoke(self, ctx):
+        if self.writes_to_repo:
+            from kart.process_lock import start_write_lock
+
+            start_write_lock(ctx)
+        return super().invoke(ctx)
+
     def format_help(self, ctx, formatter):
         try:
             render(ctx.command_path)
@@ -34,6 +46,10 @@ class KartCommand(click.Command):
 class KartGroup(click.Group):
     command_class = KartCommand
 
+    def parse_args(self, ctx, args):
+        ctx.unparsed_args = list(args)
+        return super().parse_args(ctx, args)
+
     def get_command(self, ctx, cmd_name):
         rv = super().get_command(ctx, cmd_name)
         if rv is not None:
@@ -301,6 +317,52 @@ class JsonFromFile(StringFromFile):
         return value
 
 
+def tls_options(func):
+    """
+    Adds --cert, --key and --ca options to a command that connects to a remote, for servers that require TLS client
+    certificates or that use a private certificate authority. Use tls_git_config_args to pass them to git.
+    """
+    path_type = click.Path(exists=True, dir_okay=False, resolve_path=True)
+    func = click.option(
+        "--ca",
+        type=path_type,
+        help="CA certificate bundle to verify the remote server's certificate with. Sets git's http.sslCAInfo.",
+    )(func)
+    func = click.option(
+        "--key",
+        type=path_type,
+        help="Private key for the client certificate given by --cert. Sets git's http.sslKey.",
+    )(func)
+    func = click.option(
+        "--cert",
+        type=path_type,
+        help="Client certificate to authenticate with, for remotes that require mutual TLS. Sets git's http.sslCert.",
+    )(func)
+    return func
+
+
+def tls_git_config(cert=None, key=None, ca=None):
+    """Returns the git config that corresponds to the options added by tls_options, as a dict."""
+    if key and not cert:
+        raise click.UsageError("--key requires --cert")
+    result = {}
+    if cert:
+        result["http.sslCert"] = cert
+    if key:
+        result["http.sslKey"] = key
+    if ca:
+        result["http.sslCAInfo"] = ca
+    return result
+
+
+def tls_git_config_args(cert=None, key=None, ca=None):
+    """Returns the options added by tls_options as git arguments, eg ["-c", "http.sslCert=client.pem"]."""
+    result = []
+    for k, v in tls_git_config(cert, key, ca).items():
+        result += ["-c", f"{k}={v}"]
+    return result
+
+
 def call_and_exit_flag(*args, callback, is_eager=True, **kwargs):
     """
     Add an is_flag option that, when set, eagerly calls the given callback with only the context as a parameter.
@@ -7,7 +7,7 @@ import click
 from .exceptions import InvalidOperation
 from .repo import KartRepo, PotentialRepo
 from .spatial_filter import SpatialFilterString, spatial_filter_help_text
-from kart.cli_util import KartCommand
+from kart.cli_util import KartCommand, tls_git_config, tls_options
 
 
 def get_directory_from_url(url, is_bare):
@@ -115,6 +115,7 @@ def get_directory_from_url(url, is_bare):
         "may be necessary if the remote doesn't support spatially filtered clones."
     ),
 )
+@tls_options
 @click.argument("url", nargs=1)
 @click.argument(
     "directory",
@@ -132,10 +133,17 @@ def clone(
     branch,
     spatial_filter_spec,
     spatial_filter_after_clone,
+    cert,
+    key,
+    ca,
     url,
     directory,
 ):
-    """Clone a repository into a new directory"""
+    """
+    Clone a repository into a new directory
+
+    Any of --cert, --key and --ca are saved in the new repository's config, so later fetches and pushes use them too.
+    """
     repo_path = Path(directory or get_directory_from_url(url, is_bare=bare)).resolve()
 
     if repo_path.exists() and any(repo_path.iterdir()):
@@ -158,6 +166,8 @@ def clone(
         # for the various forms it can take, see
         # https://git-scm.com/docs/git-rev-list#Documentation/git-rev-list.txt---filterltfilter-specgt
         args.append(f"--filter={filterspec}")
+    for k, v in tls_git_config(cert, key, ca).items():
+        args.append(f"--config={k}={v}")
 
     repo = KartRepo.clone_repository(
         url,
@@ -11,6 +11,7 @@ from kart import is_windows
 from kart.base_diff_writer import BaseDiffWriter
 from kart.cli_util import StringFromFile, KartCommand
 from kart.core import check_git_user
+from kart.lock import check_datasets_not_locked, get_all_locks
 from kart.diff_format import DiffFormat
 from kart.exceptions import (
     NO_CHANGES,
@@ -20,6 +21,7 @@ from kart.exceptions import (
     SubprocessError,
 )
 from kart.key_filters import RepoKeyFilter
+from kart.lineage import DerivedFromType, record_lineage, resolve_derived_from
 from kart.output_util import dump_json_output
 from kart.repo import KartRepoFiles
 from kart.status import (
@@ -69,7 +71,7 @@ class CommitDiffWriter(BaseDiffWriter):
             )
 
 
-@click.command(cls=KartCommand)
+@click.command(cls=KartCommand, writes_to_repo=True)
 @click.pass_context
 @click.option(
     "--message",
@@ -109,6 +111,24 @@ class CommitDiffWriter(BaseDiffWriter):
         "This option bypasses the safety."
     ),
 )
+@click.option(
+    "--ignore-locks",
+    is_flag=True,
+    default=False,
+    help=(
+        "By default, datasets that another user has locked with `kart lock acquire` can't be committed to. "
+        "This option bypasses the safety."
+    ),
+)
+@click.option(
+    "--derived-from",
+    type=DerivedFromType(),
+    multiple=True,
+    help=(
+        "Record that the changed datasets were derived from the given dataset as it was at the given commit - eg "
+        "--derived-from=roads/raw@HEAD. Can be specified more than once. See `kart lineage show`."
+    ),
+)
 @click.option(
     "--convert-to-dataset-format/--no-convert-to-dataset-format",
     is_flag=True,
@@ -134,6 +154,8 @@ def commit(
     launch_editor,
     allow_empty,
     allow_spatial_filter_conflicts,
+    ignore_locks,
+    derived_from,
     convert_to_dataset_format,
     output_format,
     filters,
@@ -149,6 +171,7 @@ def commit(
     repo.working_copy.assert_matches_head_tree()
 
     check_git_user(repo)
+    derived_from = resolve_derived_from(repo, derived_from)
 
     commit_diff_writer = CommitDiffWriter(repo, "HEAD", filters)
     commit_diff_writer.convert_to_dataset_format(convert_to_dataset_format)
@@ -172,6 +195,9 @@ def commit(
     if commit_diff_writer.linked_dataset_changes:
         commit_diff_writer.write_warnings_footer()
         raise InvalidOperation("Aborting commit due to changes to linked datasets.")
+    if not ignore_locks:
+        locks = {lock.ds_path: lock for lock in get_all_locks(repo)}
+        check_datasets_not_locked(repo, wc_diff.keys(), locks, "commit")
 
     do_json = output_format == "json"
     commit_msg = None
@@ -185,6 +211,7 @@ def commit(
     new_commit = repo.structure().commit_diff(
         wc_diff, commit_msg, allow_empty=allow_empty
     )
+    record_lineage(repo, new_commit, wc_diff.keys(), derived_from)
 
     repo.working_copy.soft_reset_after_commit(
         new_commit,
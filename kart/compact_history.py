@@ -0,0 +1,263 @@
+import sys
+import time
+from datetime import datetime, timezone
+
+import click
+import pygit2
+
+from kart.cli_util import KartCommand
+from kart.exceptions import NO_CHANGES, InvalidOperation, NotFound
+from kart.output_util import dump_json_output
+
+# The retention policy that compact-history applies: every commit younger than keepAllDays is kept, then only the last
+# commit of each day is kept until keepDailyDays, then only the last commit of each month.
+# eg `kart config kart.retention.keepAllDays 7`
+KEEP_ALL_DAYS_KEY = "kart.retention.keepAllDays"
+KEEP_DAILY_DAYS_KEY = "kart.retention.keepDailyDays"
+DEFAULT_KEEP_ALL_DAYS = 30
+DEFAULT_KEEP_DAILY_DAYS = 365
+
+# The git trailer added to the message of a commit that other commits were squashed into, eg "Compacted-Commits: 24"
+COMPACTED_COMMITS_TRAILER = "Compacted-Commits"
+
+
+def _get_days(repo, key, default):
+    value = repo.get_config_str(key, default)
+    try:
+        return int(value)
+    except ValueError:
+        raise InvalidOperation(f"Invalid {key} in config: {value!r}")
+
+
+def retention_bucket(commit, now, keep_all_days, keep_daily_days):
+    """
+    Returns the period that the given commit falls into under the retention policy - eg ("day", "2023-01-31") - or
+    None if it is young enough that it is always kept.
+    """
+    age_days = (now - commit.commit_time) / (24 * 60 * 60)
+    if age_days < keep_all_days:
+        return None
+    commit_date = datetime.fromtimestamp(commit.commit_time, timezone.utc)
+    if age_days < keep_daily_days:
+        return ("day", commit_date.strftime("%Y-%m-%d"))
+    return ("month", commit_date.strftime("%Y-%m"))
+
+
+def _history(repo):
+    """Returns every commit in the history of HEAD, oldest first. Raises an error if there are any merge commits."""
+    commits = []
+    commit = repo.head_commit
+    while commit is not None:
+        if len(commit.parents) > 1:
+            raise InvalidOperation(
+                f"Can't compact history that contains merge commits - {commit.short_id} is a merge"
+            )
+        commits.append(commit)
+        commit = commit.parents[0] if commit.parents else None
+    commits.reverse()
+    return commits
+
+
+def _tagged_commit_ids(repo):
+    return {
+        repo.references[r].peel(pygit2.Commit).id
+        for r in repo.references
+        if r.startswith("refs/tags/")
+    }
+
+
+def plan_compaction(commits, tagged_ids, now, keep_all_days, keep_daily_days):
+    """
+    Given a list of commits - oldest first - returns a list of groups of consecutive commits, each of which is to be
+    squashed into the last commit in the group. Tagged commits are always at the end of a group, so they are kept.
+    """
+    buckets = [
+        retention_bucket(c, now, keep_all_days, keep_daily_days) for c in commits
+    ]
+    groups = []
+    group = []
+    for i, commit in enumerate(commits):
+        group.append(commit)
+        is_last = i == len(commits) - 1
+        if (
+            is_last
+            or buckets[i] is None
+            or commit.id in tagged_ids
+            or buckets[i + 1] != buckets[i]
+        ):
+            groups.append(group)
+            group = []
+    return groups
+
+
+def _compacted_message(group):
+    message = group[-1].message.rstrip()
+    return f"{message}\n\n{COMPACTED_COMMITS_TRAILER}: {len(group)}\n"
+
+
+def _copy_notes(repo, old_id, new_id):
+    for ref in repo.references:
+        if not ref.startswith("refs/notes/"):
+            continue
+        try:
+            note = repo.lookup_note(str(old_id), ref)
+        except KeyError:
+            continue
+        repo.create_note(
+            note.message,
+            repo.author_signature(),
+            repo.committer_signature(),
+            str(new_id),
+            ref,
+            True,
+        )
+
+
+def _move_tags(repo, new_ids):
+    """Moves every tag that points at a rewritten commit to point at the new commit instead."""
+    for ref_name in list(repo.references):
+        if not ref_name.startswith("refs/tags/"):
+            continue
+        ref = repo.references[ref_name]
+        new_id = new_ids.get(ref.peel(pygit2.Commit).id)
+        if new_id is None:
+            continue
+        target = repo[ref.target]
+        if isinstance(target, pygit2.Tag):
+            repo.references.delete(ref_name)
+            repo.create_tag(
+                target.name,
+                new_id,
+                pygit2.GIT_OBJ_COMMIT,
+                target.tagger or repo.committer_signature(),
+                target.message,
+            )
+        else:
+            ref.set_target(new_id, "compact-history")
+
+
+def rewrite_history(repo, groups):
+    """
+    Rewrites the history of HEAD so that each group of commits is squashed into one commit, which has the tree, author
+    and committer of the last commit in the group. Returns a {old_commit_id: new_commit_id} dict of the commits that
+    were kept.
+    """
+    new_ids = {}
+    parent_id = None
+    rewriting = False
+    for group in groups:
+        kept = group[-1]
+        if len(group) > 1:
+            rewriting = True
+        if not rewriting:
+            parent_id = new_ids[kept.id] = kept.id
+            continue
+        new_id = repo.create_commit(
+            None,
+            kept.author,
+            kept.committer,
+            _compacted_message(group) if len(group) > 1 else kept.message,
+            kept.tree_id,
+            [parent_id] if parent_id is not None else [],
+        )
+        _copy_notes(repo, kept.id, new_id)
+        parent_id = new_ids[kept.id] = new_id
+
+    if repo.head_branch is None:
+        repo.head.set_target(parent_id, "compact-history")
+    else:
+        repo.references[repo.head_branch].set_target(parent_id, "compact-history")
+    _move_tags(repo, {old: new for old, new in new_ids.items() if old != new})
+    return new_ids
+
+
+def _commit_date(commit):
+    return datetime.fromtimestamp(commit.commit_time, timezone.utc).strftime(
+        "%Y-%m-%d %H:%M"
+    )
+
+
+@click.command("compact-history", cls=KartCommand, writes_to_repo=True)
+@click.pass_context
+@click.option(
+    "--keep-all-days",
+    type=click.IntRange(min=0),
+    help=f"Keep every commit younger than this many days. Defaults to the {KEEP_ALL_DAYS_KEY} config, or {DEFAULT_KEEP_ALL_DAYS}.",
+)
+@click.option(
+    "--keep-daily-days",
+    type=click.IntRange(min=0),
+    help=(
+        "Keep the last commit of each day for commits younger than this many days, and the last commit of each month "
+        f"for older commits. Defaults to the {KEEP_DAILY_DAYS_KEY} config, or {DEFAULT_KEEP_DAILY_DAYS}."
+    ),
+)
+@click.option(
+    "--dry-run",
+    is_flag=True,
+    help="Show which commits would be squashed together, without changing anything.",
+)
+@click.option(
+    "--output-format",
+    "-o",
+    type=click.Choice(["text", "json"]),
+    default="text",
+)
+def compact_history(ctx, keep_all_days, keep_daily_days, dry_run, output_format):
+    """
+    Squash old commits on the current branch according to the retention policy - for repositories that are
+    updated by frequent scheduled imports, where every old version doesn't need to be kept forever.
+
+    By default every commit from the last 30 days is kept, then the last commit of each day for a year, then the last
+    commit of each month. Each kept commit has the same data as before, and the commits squashed into it are counted
+    in its message. Tagged commits are always kept, and their tags are moved to the rewritten commits.
+
+    This rewrites history: other branches and any remotes still have the old commits, so the branch must be
+    force-pushed afterwards. The previous branch tip can be found with `kart reflog`.
+    """
+    repo = ctx.obj.repo
+    if repo.head_is_unborn:
+        raise NotFound("No commits to compact", exit_code=NO_CHANGES)
+    if keep_all_days is None:
+        keep_all_days = _get_days(repo, KEEP_ALL_DAYS_KEY, DEFAULT_KEEP_ALL_DAYS)
+    if keep_daily_days is None:
+        keep_daily_days = _get_days(repo, KEEP_DAILY_DAYS_KEY, DEFAULT_KEEP_DAILY_DAYS)
+
+    commits = _history(repo)
+    groups = plan_compaction(
+        commits, _tagged_commit_ids(repo), time.time(), keep_all_days, keep_daily_days
+    )
+    if len(groups) == len(commits):
+        raise NotFound("No commits to compact", exit_code=NO_CHANGES)
+
+    new_ids = {} if dry_run else rewrite_history(repo, groups)
+
+    if output_format == "json":
+        dump_json_output(
+            {
+                "kart.compact_history/v1": {
+                    "dryRun": dry_run,
+                    "commits": [
+                        {
+                            "commit": group[-1].id.hex,
+                            "newCommit": new_ids[group[-1].id].hex if new_ids else None,
+                            "squashed": [c.id.hex for c in group[:-1]],
+                        }
+                        for group in groups
+                    ],
+                }
+            },
+            sys.stdout,
+        )
+        return
+
+    verb = "Would compact" if dry_run else "Compacted"
+    click.echo(f"{verb} {len(commits)} commits into {len(groups)}:")
+    for group in groups:
+        if len(group) == 1:
+            continue
+        kept = group[-1]
+        commit_id = new_ids[kept.id] if new_ids else kept.id
+        click.echo(
+            f"  {commit_id.hex[:7]} {_commit_date(kept)} - {len(group)} commits"
+        )
@@ -187,18 +187,41 @@ def install_powershell(*, prog_name: str, complete_var: str, shell: str) -> Path
     return path_obj
 
 
-def install_tab_completion(
-    shell: Optional[str] = None,
-) -> Tuple[str, Path]:
-    if shell is None and shellingham is not None:
+def detect_shell() -> Optional[str]:
+    if shellingham is not None:
         try:
             shell, _ = shellingham.detect_shell()
+            return shell
         except shellingham.ShellDetectionFailure:
-            shell = os.path.basename(provide_default_shell())
+            pass
+    return os.path.basename(provide_default_shell()) or None
+
+
+# Hardcode these variables - kart helper means that click can get mixed up between KART and KART_CLI,
+# but this means we always use KART in practise.
+PROG_NAME = "kart"
+COMPLETE_VAR = "_KART_COMPLETE"
+
+
+def get_tab_completion_script(shell: Optional[str] = None) -> Tuple[str, str]:
+    """
+    Returns the shell and the tab completion script for that shell - for users who want to install it themselves,
+    eg in a system-wide completions directory or their dotfiles.
+    """
+    if shell is None:
+        shell = detect_shell()
+    return shell, get_completion_script(
+        prog_name=PROG_NAME, complete_var=COMPLETE_VAR, shell=shell
+    )
+
+
+def install_tab_completion(
+    shell: Optional[str] = None,
+) -> Tuple[str, Path]:
+    if shell is None:
+        shell = detect_shell()
 
-    # Hardcode these variables - kart helper means that click can get mixed up between KART and KART_CLI,
-    # but this means we always use KART in practise.
-    kwargs = {"prog_name": "kart", "complete_var": "_KART_COMPLETE", "shell": shell}
+    kwargs = {"prog_name": PROG_NAME, "complete_var": COMPLETE_VAR, "shell": shell}
 
     if shell == "bash":
         installed_path = install_bash(**kwargs)
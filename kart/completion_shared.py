@@ -74,14 +74,26 @@ def repo_path_completer(ctx=None, param=None, incomplete=""):
     if not repo:
         return []
 
-    return _do_complete_paths(repo, incomplete)
+    return CompletionSet(_do_complete_paths(repo, incomplete))
 
 
 def _do_complete_paths(repo, incomplete=""):
+    if repo.head_is_unborn:
+        return set()
     all_ds_paths = repo.datasets("HEAD").paths()
     return set(p for p in all_ds_paths if p.startswith(incomplete))
 
 
+def deleted_repo_path_completer(ctx=None, param=None, incomplete=""):
+    from kart.data import tombstones
+
+    repo = discover_repository()
+    if not repo:
+        return []
+
+    return CompletionSet(p for p in tombstones(repo) if p.startswith(incomplete))
+
+
 def ref_or_repo_path_completer(ctx=None, param=None, incomplete=""):
     repo = discover_repository()
     if not repo:
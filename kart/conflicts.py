@@ -11,7 +11,7 @@ from .repo import KartRepoState
     "--output-format",
     "-o",
     type=OutputFormatType(
-        output_types=["text", "json", "geojson", "quiet"],
+        output_types=["text", "json", "geojson", "gpkg", "quiet"],
         allow_text_formatstring=False,
     ),
     default="text",
@@ -72,6 +72,9 @@ def conflicts(
     Lists merge conflicts that need to be resolved before the ongoing merge can be completed.
 
     To list only particular conflicts, supply one or more FILTERS of the form [DATASET[:PRIMARY_KEY]]
+
+    To resolve feature conflicts visually, use `-o gpkg --output conflicts.gpkg`, which writes the ancestor, ours and
+    theirs versions of the conflicting features in each dataset to separate layers, styled for QGIS.
     """
     repo = ctx.obj.get_repo(allowed_states=KartRepoState.MERGING)
     output_type, fmt = output_format
@@ -89,6 +89,7 @@ class BaseConflictsWriter:
             "json": JsonConflictsWriter,
             "text": TextConflictsWriter,
             "geojson": GeojsonConflictsWriter,
+            "gpkg": GpkgConflictsWriter,
         }
 
         cls.output_format = output_format
@@ -365,3 +366,146 @@ class GeojsonConflictsWriter(BaseConflictsWriter):
                 f"Warning: {path} meta changes aren't included in GeoJSON output.",
                 err=True,
             )
+
+
+# The colour (R,G,B) used to style each version's layers in a GeoPackage of conflicts.
+CONFLICT_VERSION_COLOURS = {
+    "ancestor": "150,150,150",
+    "ours": "31,120,180",
+    "theirs": "227,26,28",
+}
+
+
+def conflict_layer_qml(version_name, geometry_type):
+    """
+    Returns a minimal QGIS style (QML) which draws the given version of the conflicting features in its own colour
+    - ancestor in grey, ours in blue and theirs in red. Only the outlines of polygons are drawn, so that all three
+    versions can be seen on top of each other.
+    """
+    colour = CONFLICT_VERSION_COLOURS[version_name]
+    geometry_type = (geometry_type or "GEOMETRY").upper()
+    if "POINT" in geometry_type:
+        symbol_type, layer_class = "marker", "SimpleMarker"
+        props = {"color": f"{colour},255", "size": "3"}
+    elif any(t in geometry_type for t in ("LINE", "CURVE")) and not any(
+        t in geometry_type for t in ("POLYGON", "SURFACE")
+    ):
+        symbol_type, layer_class = "line", "SimpleLine"
+        props = {"line_color": f"{colour},255", "line_width": "0.8"}
+    else:
+        symbol_type, layer_class = "fill", "SimpleFill"
+        props = {
+            "color": f"{colour},0",
+            "style": "no",
+            "outline_color": f"{colour},255",
+            "outline_width": "0.8",
+        }
+    prop_elements = "".join(f'<prop k="{k}" v="{v}"/>' for k, v in props.items())
+    return (
+        '<qgis version="3.0.0" styleCategories="Symbology">'
+        '<renderer-v2 type="singleSymbol">'
+        f'<symbols><symbol type="{symbol_type}" name="0" alpha="1">'
+        f'<layer class="{layer_class}" enabled="1" pass="0">{prop_elements}</layer>'
+        "</symbol></symbols>"
+        "</renderer-v2>"
+        "</qgis>"
+    )
+
+
+class GpkgConflictsWriter(BaseConflictsWriter):
+    """Writes all feature conflicts to a GeoPackage, for resolving them visually in a GIS such as QGIS.
+
+    Each version of the conflicting features in each dataset is written to its own layer:
+
+        dataset_ancestor - the common ancestor versions of the conflicting features
+        dataset_ours - our versions of the conflicting features
+        dataset_theirs - their versions of the conflicting features
+
+    Each layer has a default QGIS style in the layer_styles table, so the three versions are drawn in different colours.
+
+    Note:
+
+        Meta conflicts aren't output at all.
+    """
+
+    def __init__(self, *args, **kwargs):
+        super().__init__(*args, **kwargs)
+        if self.target_crs is not None:
+            raise click.BadParameter(
+                "--crs is not supported for GPKG format - features are written in each dataset's own CRS",
+                param_hint="--crs",
+            )
+
+    @classmethod
+    def _check_output_path(cls, repo: pygit2.Repository, output_path: Path):
+        """Make sure the given output_path is valid for this implementation (ie, are directories supported)."""
+        if not isinstance(output_path, Path):
+            raise click.BadParameter(
+                "Need to specify a file via --output for GPKG format.",
+                param_hint="--output",
+            )
+        if output_path.is_dir():
+            raise click.BadParameter(
+                "Directory is not valid for --output with -o gpkg",
+                param_hint="--output",
+            )
+        return output_path
+
+    def write_conflicts(self) -> None:
+        from kart.tabular.ogr_export import LayerStyle, OgrTableExporter
+
+        conflicts = self.get_conflicts()
+        if not self.repo_key_filter.match_all:
+            conflicts = (c for c in conflicts if c.matches_filter(self.repo_key_filter))
+        conflicts = ensure_conflicts_ready(conflicts, self.merge_context.repo)
+
+        # {(ds_path, version_name): (dataset, [features])}
+        layers = {}
+        meta_conflict_ds_paths = set()
+        for conflict in conflicts:
+            for version in conflict.true_versions:
+                if version.is_meta:
+                    meta_conflict_ds_paths.add(version.dataset_path)
+                    continue
+                if not version.is_feature:
+                    continue
+                key = (version.dataset_path, version.version_name)
+                layers.setdefault(key, (version.dataset, []))[1].append(
+                    version.feature
+                )
+
+        for ds_path in sorted(meta_conflict_ds_paths):
+            click.echo(
+                f"Warning: {ds_path} meta changes aren't included in GPKG output.",
+                err=True,
+            )
+
+        if self.output_path.exists():
+            self.output_path.unlink()
+
+        styles = []
+        with OgrTableExporter(
+            self.output_path, "GPKG", fid_layer_option="FID"
+        ) as exporter:
+            for (ds_path, version_name), (dataset, features) in sorted(
+                layers.items()
+            ):
+                table_name = dataset.dataset_path_to_table_name(ds_path)
+                layer_name = f"{table_name}_{version_name}"
+                exporter.write_dataset(dataset, layer_name, features)
+                geom_columns = dataset.schema.geometry_columns
+                if geom_columns:
+                    styles.append(
+                        LayerStyle(
+                            layer_name,
+                            geom_columns[0].name,
+                            layer_name,
+                            conflict_layer_qml(
+                                version_name, geom_columns[0].get("geometryType")
+                            ),
+                            None,
+                            True,
+                        )
+                    )
+            if styles:
+                exporter.write_layer_styles(styles)
@@ -163,7 +163,7 @@ def create_workdir(repo, delete_existing, discard_changes):
     )
 
 
-@click.command("create-workingcopy", cls=KartCommand)
+@click.command("create-workingcopy", cls=KartCommand, writes_to_repo=True)
 @click.pass_context
 @click.option(
     "--discard-changes",
@@ -1,24 +1,91 @@
+import re
 import sys
+import time
+from urllib.parse import quote, unquote
 
 import click
+import pygit2
 
 from .cli_util import KartGroup, StringFromFile, add_help_subcommand
 from .commit import commit_json_to_text, commit_obj_to_json, get_commit_message
 from .diff_structs import DatasetDiff, Delta, DeltaDiff, RepoDiff
-from .exceptions import NO_TABLE, NotFound
+from .exceptions import NO_TABLE, InvalidOperation, NotFound
 from .output_util import dump_json_output
 from .repo import KartRepoState
-from .completion_shared import ref_completer
+from .completion_shared import (
+    deleted_repo_path_completer,
+    ref_completer,
+    repo_path_completer,
+)
 
 # Changing these items would generally break the repo;
 # we disallow that.
 
+# The git trailer added to the message of a commit that renames a dataset, eg "Renamed-Dataset: old/path -> new/path"
+RENAMED_DATASET_TRAILER = "Renamed-Dataset"
+RENAMED_DATASET_PATTERN = re.compile(
+    rf"^{RENAMED_DATASET_TRAILER}: (?P<old>.+) -> (?P<new>.+)$", re.MULTILINE
+)
+
+# When a dataset is deleted, a tombstone ref is left pointing at the commit that deleted it - which keeps the dataset
+# recoverable with `kart data undelete` even if that commit is later reset away - until `kart gc` expires it.
+TOMBSTONE_REF_PREFIX = "refs/tombstones/"
+TOMBSTONE_EXPIRY_KEY = "kart.tombstones.expiryDays"
+DEFAULT_TOMBSTONE_EXPIRY_DAYS = 30
+
+
+def _tombstone_ref(ds_path):
+    # Dataset paths contain slashes - these are escaped, so that one path being a prefix of another doesn't matter.
+    return f"{TOMBSTONE_REF_PREFIX}{quote(ds_path, safe='')}"
+
+
+def tombstones(repo):
+    """Returns a {dataset-path: deleting-commit} dict of every dataset with a tombstone."""
+    return {
+        unquote(r[len(TOMBSTONE_REF_PREFIX) :]): repo.references[r].peel(
+            pygit2.Commit
+        )
+        for r in repo.references
+        if r.startswith(TOMBSTONE_REF_PREFIX)
+    }
+
+
+def tombstone_expiry_days(repo):
+    return int(
+        repo.get_config_str(TOMBSTONE_EXPIRY_KEY, DEFAULT_TOMBSTONE_EXPIRY_DAYS)
+    )
+
+
+def tombstone_expiry_time(repo, commit):
+    return commit.commit_time + tombstone_expiry_days(repo) * 24 * 60 * 60
+
+
+def expire_tombstones(repo):
+    """Deletes tombstones that are older than the expiry window. Returns the paths of the datasets they were for."""
+    now = time.time()
+    expired = []
+    for ds_path, commit in tombstones(repo).items():
+        if tombstone_expiry_time(repo, commit) < now:
+            repo.references.delete(_tombstone_ref(ds_path))
+            expired.append(ds_path)
+    return expired
+
 
 @add_help_subcommand
 @click.group(cls=KartGroup)
 @click.pass_context
 def data(ctx, **kwargs):
-    """Information about the datasets in a repository."""
+    """
+    Information about the datasets in a repository.
+
+    \b
+    Examples:
+    $ kart data ls
+    $ kart data show my_points
+    $ kart data mv my_points points/my_points
+    $ kart data rm my_points
+    $ kart data undelete my_points
+    """
 
 
 @data.command(name="ls")
@@ -68,7 +135,61 @@ def data_ls(ctx, output_format, with_dataset_types, refish):
         dump_json_output({f"kart.data.ls/{version_marker}": json_list}, sys.stdout)
 
 
-@data.command(name="rm")
+@data.command(name="show")
+@click.option(
+    "--output-format",
+    "-o",
+    type=click.Choice(["text", "json"]),
+    default="text",
+)
+@click.option(
+    "--ref",
+    default="HEAD",
+    shell_complete=ref_completer,
+    help="The commit to show the dataset at. Defaults to HEAD.",
+)
+@click.argument(
+    "ds_path", metavar="DATASET", shell_complete=repo_path_completer
+)
+@click.pass_context
+def data_show(ctx, output_format, ref, ds_path):
+    """
+    Show a dataset's title, description and README - the documentation of what the data is and how it should be
+    used. Set the README with `kart meta set-description DATASET FILE.md`.
+    """
+    repo = ctx.obj.get_repo(allowed_states=KartRepoState.ALL_STATES)
+    dataset = repo.datasets(ref).get(ds_path)
+    if dataset is None:
+        raise NotFound(f"No dataset found at {ds_path}", exit_code=NO_TABLE)
+
+    json_obj = {
+        "path": dataset.path,
+        "type": dataset.DATASET_TYPE,
+        "version": dataset.VERSION,
+        "title": dataset.get_meta_item("title"),
+        "description": dataset.get_meta_item("description"),
+        "readme": dataset.get_meta_item("readme.md"),
+    }
+    if output_format == "json":
+        dump_json_output({"kart.data.show/v1": json_obj}, sys.stdout)
+        return
+
+    click.secho(
+        f"{json_obj['path']}\t({json_obj['type']}.v{json_obj['version']})", bold=True
+    )
+    for key in ("title", "description"):
+        if json_obj[key]:
+            click.echo(f"{key.capitalize()}: {json_obj[key]}")
+    click.echo()
+    if json_obj["readme"]:
+        click.echo(json_obj["readme"].rstrip("\n"))
+    else:
+        click.echo(
+            f'No README (use "kart meta set-description {dataset.path} FILE.md" to add one)'
+        )
+
+
+@data.command(name="rm", writes_to_repo=True)
 @click.option(
     "--message",
     "-m",
@@ -85,7 +206,9 @@ def data_ls(ctx, output_format, with_dataset_types, refish):
     type=click.Choice(["text", "json"]),
     default="text",
 )
-@click.argument("datasets", nargs=-1, type=click.UNPROCESSED)
+@click.argument(
+    "datasets", nargs=-1, type=click.UNPROCESSED, shell_complete=repo_path_completer
+)
 @click.pass_context
 def data_rm(ctx, message, output_format, datasets):
     """Delete one or more datasets in the Kart repository, and commit the result"""
@@ -123,6 +246,8 @@ def data_rm(ctx, message, output_format, datasets):
         raise click.UsageError("Aborting commit due to empty commit message.")
 
     new_commit = repo.structure().commit_diff(repo_diff, commit_msg)
+    for ds_path in datasets:
+        repo.references.create(_tombstone_ref(ds_path), new_commit.id, force=True)
     repo.working_copy.reset_to_head()
 
     jdict = commit_obj_to_json(new_commit, repo, repo_diff)
@@ -130,10 +255,212 @@ def data_rm(ctx, message, output_format, datasets):
         dump_json_output(jdict, sys.stdout)
     else:
         click.echo(commit_json_to_text(jdict))
+        click.echo(
+            "  (use `kart data undelete DATASET` within "
+            f"{tombstone_expiry_days(repo)} days to restore a deleted dataset)"
+        )
 
     repo.gc("--auto")
 
 
+@data.command(name="undelete", writes_to_repo=True)
+@click.option(
+    "--message",
+    "-m",
+    multiple=True,
+    help=(
+        "Use the given message as the commit message. If multiple `-m` options are given, their values are "
+        "concatenated as separate paragraphs."
+    ),
+    type=StringFromFile(encoding="utf-8"),
+)
+@click.option(
+    "--list",
+    "do_list",
+    is_flag=True,
+    help="List the deleted datasets that can be restored, instead of restoring one.",
+)
+@click.argument(
+    "datasets",
+    nargs=-1,
+    type=click.UNPROCESSED,
+    shell_complete=deleted_repo_path_completer,
+)
+@click.pass_context
+def data_undelete(ctx, message, do_list, datasets):
+    """
+    Restore one or more datasets that were deleted with `kart data rm`, as they were just before they were deleted,
+    and commit the result. Deleted datasets can be restored until their tombstones expire - after
+    kart.tombstones.expiryDays days (default 30) - and are removed by `kart gc`.
+    """
+    from .diff_util import get_repo_diff
+    from .pack_util import packfile_object_builder
+
+    repo = ctx.obj.get_repo()
+    all_tombstones = tombstones(repo)
+    if do_list:
+        for ds_path, commit in sorted(all_tombstones.items()):
+            expiry = time.strftime(
+                "%Y-%m-%d", time.localtime(tombstone_expiry_time(repo, commit))
+            )
+            click.echo(f"{ds_path}\tdeleted in {commit.short_id}, expires {expiry}")
+        return
+    if not datasets:
+        raise click.UsageError(
+            "Specify a dataset to restore: eg `kart data undelete DATASET`"
+        )
+
+    existing_ds_paths = set(repo.datasets().paths())
+    for ds_path in datasets:
+        if ds_path not in all_tombstones:
+            raise NotFound(
+                f"No deleted dataset found at path '{ds_path}'", exit_code=NO_TABLE
+            )
+        if tombstone_expiry_time(repo, all_tombstones[ds_path]) < time.time():
+            raise InvalidOperation(
+                f"The tombstone for dataset '{ds_path}' has expired - it can't be restored"
+            )
+        if ds_path in existing_ds_paths:
+            raise InvalidOperation(
+                f"Cannot restore dataset at path '{ds_path}' since a dataset already exists there"
+            )
+
+    repo.working_copy.check_not_dirty()
+
+    commit_msg = "\n\n".join([m.strip() for m in message]).strip()
+    if not commit_msg:
+        commit_msg = "Restore deleted dataset " + ", ".join(datasets)
+
+    with packfile_object_builder(repo, repo.head_tree) as object_builder:
+        for ds_path in datasets:
+            deleting_commit = all_tombstones[ds_path]
+            dataset = repo.datasets(deleting_commit.parent_ids[0].hex)[ds_path]
+            object_builder.insert(ds_path, dataset.tree)
+        new_commit = object_builder.commit(
+            "HEAD",
+            repo.author_signature(),
+            repo.committer_signature(),
+            commit_msg,
+            [repo.head_commit.id],
+        )
+    for ds_path in datasets:
+        repo.references.delete(_tombstone_ref(ds_path))
+    repo.working_copy.reset_to_head()
+
+    repo_diff = get_repo_diff(
+        repo.structure(new_commit.parent_ids[0].hex), repo.structure(new_commit.hex)
+    )
+    click.echo(commit_json_to_text(commit_obj_to_json(new_commit, repo, repo_diff)))
+
+
+@data.command(name="mv", writes_to_repo=True)
+@click.option(
+    "--message",
+    "-m",
+    multiple=True,
+    help=(
+        "Use the given message as the commit message. If multiple `-m` options are given, their values are "
+        "concatenated as separate paragraphs."
+    ),
+    type=StringFromFile(encoding="utf-8"),
+)
+@click.option(
+    "--output-format",
+    "-o",
+    type=click.Choice(["text", "json"]),
+    default="text",
+)
+@click.argument("old_path", shell_complete=repo_path_completer)
+@click.argument("new_path")
+@click.pass_context
+def data_mv(ctx, message, output_format, old_path, new_path):
+    """
+    Rename or move a dataset in the Kart repository, and commit the result.
+
+    The dataset's features and metadata are moved unchanged, and the commit records the rename, so that
+    `kart log --follow NEW_PATH` shows the history of the dataset from before it was renamed.
+    """
+    from .dataset_util import validate_dataset_paths
+    from .diff_util import get_repo_diff
+    from .pack_util import packfile_object_builder
+
+    repo = ctx.obj.get_repo()
+    datasets = repo.datasets()
+    if old_path not in datasets:
+        raise NotFound(
+            f"Cannot move dataset at path '{old_path}' since it does not exist",
+            exit_code=NO_TABLE,
+        )
+    other_paths = [p for p in datasets.paths() if p != old_path]
+    validate_dataset_paths(other_paths + [new_path])
+    for path in other_paths:
+        if new_path.startswith(f"{path}/") or path.startswith(f"{new_path}/"):
+            raise InvalidOperation(
+                f"Cannot move dataset to '{new_path}' since it would be nested with dataset '{path}'"
+            )
+    try:
+        repo.head_tree / new_path
+        raise InvalidOperation(
+            f"Cannot move dataset to '{new_path}' since that path is already in use"
+        )
+    except KeyError:
+        pass
+
+    repo.working_copy.check_not_dirty()
+
+    commit_msg = "\n\n".join([m.strip() for m in message]).strip()
+    if not commit_msg:
+        commit_msg = f"Rename dataset {old_path} to {new_path}"
+    commit_msg += f"\n\n{RENAMED_DATASET_TRAILER}: {old_path} -> {new_path}\n"
+
+    with packfile_object_builder(repo, repo.head_tree) as object_builder:
+        object_builder.remove(old_path)
+        object_builder.insert(new_path, datasets[old_path].tree)
+        new_commit = object_builder.commit(
+            "HEAD",
+            repo.author_signature(),
+            repo.committer_signature(),
+            commit_msg,
+            [repo.head_commit.id],
+        )
+    repo.working_copy.reset_to_head()
+
+    repo_diff = get_repo_diff(
+        repo.structure(new_commit.parent_ids[0].hex), repo.structure(new_commit.hex)
+    )
+    jdict = commit_obj_to_json(new_commit, repo, repo_diff)
+    if output_format == "json":
+        dump_json_output(jdict, sys.stdout)
+    else:
+        click.echo(commit_json_to_text(jdict))
+
+
+def find_previous_dataset_paths(repo, ds_path, commits):
+    """
+    Returns the paths that the dataset at ds_path had before it was renamed with `kart data mv`, in the history
+    of the given commits / refs / ranges - most recent first.
+    """
+    from . import subprocess_util as subprocess
+
+    cmd = [
+        "git",
+        "-C",
+        repo.path,
+        "log",
+        "--format=%B%x00",
+        f"--grep=^{RENAMED_DATASET_TRAILER}: ",
+        *commits,
+    ]
+    output = subprocess.check_output(cmd, encoding="utf8")
+    result = []
+    for message in output.split("\0"):
+        for match in RENAMED_DATASET_PATTERN.finditer(message):
+            if match.group("new") == ds_path:
+                ds_path = match.group("old")
+                result.append(ds_path)
+    return result
+
+
 @data.command(name="version", hidden=True)
 @click.option(
     "--output-format",
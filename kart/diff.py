@@ -1,4 +1,5 @@
 import sys
+from pathlib import Path
 
 import click
 
@@ -6,6 +7,7 @@ from kart import diff_estimation
 from kart.cli_util import OutputFormatType
 from kart.completion_shared import ref_or_repo_path_completer
 from kart.crs_util import CoordinateReferenceString
+from kart.exceptions import InvalidOperation
 from kart.output_util import dump_json_output
 from kart.parse_args import PreserveDoubleDash, parse_revisions_and_filters
 from kart.repo import KartRepoState
@@ -52,6 +54,44 @@ def feature_count_diff(
         sys.exit(1)
 
 
+def region_summary_diff(
+    repo,
+    output_format,
+    commit_spec,
+    filters,
+    output_path,
+    exit_code,
+    json_style,
+    *,
+    grid_size,
+    regions_path,
+    heatmap_path,
+):
+    if output_format not in ("text", "json"):
+        raise click.UsageError(
+            "--summarize-by-grid and --summarize-by-regions require text or json output"
+        )
+    if heatmap_path is not None and Path(heatmap_path).exists():
+        raise InvalidOperation(f"{heatmap_path} already exists")
+
+    from .region_summary_diff_writer import RegionSummaryDiffWriter
+
+    diff_writer = RegionSummaryDiffWriter(
+        repo,
+        commit_spec,
+        filters,
+        output_path,
+        json_style=json_style,
+        output_format=output_format,
+        grid_size=grid_size,
+        regions_path=regions_path,
+        heatmap_path=heatmap_path,
+    )
+    diff_writer.write_diff()
+    if exit_code:
+        diff_writer.exit_with_code()
+
+
 @click.command(cls=PreserveDoubleDash)
 @click.pass_context
 @click.option(
@@ -62,17 +102,21 @@ def feature_count_diff(
             "text",
             "json",
             "geojson",
+            "geojson-lines",
             "quiet",
             "feature-count",
             "html",
             "json-lines",
+            "arrow",
         ],
         allow_text_formatstring=False,
     ),
     default="text",
     help=(
         "Output format. 'quiet' disables all output and implies --exit-code.\n"
-        "'html' attempts to open a browser unless writing to stdout ( --output=- )"
+        "'html' attempts to open a browser unless writing to stdout ( --output=- )\n"
+        "'arrow' writes an Apache Arrow IPC stream, with geometries as WKB\n"
+        "'geojson-lines' writes one GeoJSON feature per line for each changed feature, with its old and new versions"
     ),
 )
 @click.option(
@@ -80,6 +124,52 @@ def feature_count_diff(
     is_flag=True,
     help="Make the program exit with codes similar to diff(1). That is, it exits with 1 if there were differences and 0 means no differences.",
 )
+@click.option(
+    "--quiet",
+    "-q",
+    is_flag=True,
+    help=(
+        "Disable all output, and exit with 1 as soon as any difference is found, or 0 if there are no differences. "
+        "The same as --output-format=quiet."
+    ),
+)
+@click.option(
+    "--limit",
+    type=click.IntRange(min=0),
+    help=(
+        "Only show the first N feature or tile changes, and stop generating the diff once they have been output. "
+        "Not supported with --only-feature-count."
+    ),
+)
+@click.option(
+    "--summarize-by-grid",
+    "grid_size",
+    type=click.FloatRange(min=0, min_open=True),
+    metavar="CELL_SIZE",
+    help=(
+        "Instead of showing the changes, count how many features changed in each cell of a grid of squares of the "
+        "given size, in the units of each dataset's CRS. Requires text or json output."
+    ),
+)
+@click.option(
+    "--summarize-by-regions",
+    "regions_path",
+    type=click.Path(exists=True, dir_okay=False),
+    metavar="FILE",
+    help=(
+        "Instead of showing the changes, count how many features changed in each of the polygons in FILE - eg a "
+        "GeoJSON file or a GPKG. Polygons are named after their name field. Requires text or json output."
+    ),
+)
+@click.option(
+    "--heatmap",
+    "heatmap_path",
+    type=click.Path(dir_okay=False, writable=True),
+    help=(
+        "With --summarize-by-grid or --summarize-by-regions, also write the cells or regions that have changes, and "
+        "how many, as a layer of polygons per dataset to a new GPKG file."
+    ),
+)
 @click.option(
     "--crs",
     type=CoordinateReferenceString(encoding="utf-8"),
@@ -129,6 +219,26 @@ def feature_count_diff(
     is_flag=True,
     help="Show changes to file contents (instead of just showing the object IDs of changed files)",
 )
+@click.option(
+    "--measure",
+    is_flag=True,
+    help=(
+        "Show the geodesic area of polygons and length of lines before and after each feature change - measured on "
+        "the ellipsoid of the dataset's CRS, in square metres and metres. Supported for text, json and json-lines "
+        "output."
+    ),
+)
+@click.option(
+    "--html",
+    "html_path",
+    type=click.Path(dir_okay=False, writable=True),
+    metavar="PATH",
+    help=(
+        "Write a HTML report of the diff to PATH - a map of the changed features, showing their old and new "
+        "geometries, and tables of their attribute changes - without opening it in a browser. "
+        "Shorthand for --output-format=html --output=PATH."
+    ),
+)
 @click.option(
     "--html-template",
     default=None,
@@ -148,11 +258,18 @@ def diff(
     crs,
     output_path,
     exit_code,
+    quiet,
+    limit,
+    grid_size,
+    regions_path,
+    heatmap_path,
     json_style,
     only_feature_count,
     add_feature_count_estimate,
     convert_to_dataset_format,
     diff_files,
+    measure,
+    html_path,
     html_template,
     args,
 ):
@@ -177,6 +294,16 @@ def diff(
     repo = ctx.obj.get_repo(allowed_states=KartRepoState.ALL_STATES)
     options, commits, filters = parse_revisions_and_filters(repo, args)
     output_type, fmt = output_format
+    if quiet:
+        output_type = "quiet"
+    open_in_browser = True
+    if html_path is not None:
+        if output_path is not None or output_type not in ("text", "html"):
+            raise click.UsageError(
+                "--html can't be used with --output-format or --output"
+            )
+        output_type, output_path = "html", html_path
+        open_in_browser = False
 
     assert len(commits) <= 2
     if len(commits) == 2:
@@ -191,6 +318,10 @@ def diff(
         commit_spec = "HEAD"
 
     if only_feature_count:
+        if limit is not None:
+            raise click.UsageError(
+                "--limit is not supported with --only-feature-count"
+            )
         return feature_count_diff(
             repo,
             output_type,
@@ -201,6 +332,29 @@ def diff(
             only_feature_count,
         )
 
+    if grid_size is not None or regions_path is not None:
+        return region_summary_diff(
+            repo,
+            output_type,
+            commit_spec,
+            filters,
+            output_path,
+            exit_code,
+            fmt,
+            grid_size=grid_size,
+            regions_path=regions_path,
+            heatmap_path=heatmap_path,
+        )
+    if heatmap_path is not None:
+        raise click.UsageError(
+            "--heatmap requires --summarize-by-grid or --summarize-by-regions"
+        )
+
+    if measure and output_type not in ("text", "json", "json-lines"):
+        raise click.UsageError(
+            "--measure is only supported for text, json and json-lines output"
+        )
+
     from .base_diff_writer import BaseDiffWriter
 
     diff_writer_class = BaseDiffWriter.get_diff_writer_class(output_type)
@@ -216,6 +370,12 @@ def diff(
     )
     diff_writer.convert_to_dataset_format(convert_to_dataset_format)
     diff_writer.full_file_diffs(diff_files)
+    if measure:
+        diff_writer.measure_geometries()
+    if output_type == "html":
+        diff_writer.open_in_browser = open_in_browser
+    if limit is not None:
+        diff_writer.limit_deltas(limit)
     diff_writer.write_diff()
 
     if exit_code or output_type == "quiet":
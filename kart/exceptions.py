@@ -45,6 +45,7 @@ NO_CHECKSUM = 54
 CONNECTION_ERROR = 60
 
 BAD_WORKING_COPY_STATE = 70
+FILE_LOCKED = 71
 
 SUBPROCESS_ERROR_FLAG = 128
 DEFAULT_SUBPROCESS_ERROR = 129
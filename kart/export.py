@@ -0,0 +1,552 @@
+import logging
+from datetime import datetime, timezone
+from pathlib import Path
+
+import click
+import pygit2
+from sqlalchemy.orm import sessionmaker
+
+from kart.cli_util import JsonFromFile, KartCommand
+from kart.completion_shared import ref_completer, repo_path_completer
+from kart.exceptions import (
+    InvalidOperation,
+    NotFound,
+    NotYetImplemented,
+    NO_DATA,
+    INVALID_ARGUMENT,
+)
+from kart.sqlalchemy.adapter.gpkg import KartAdapter_GPKG
+from kart.style import get_layer_styles
+from kart.tabular.archive import ArchivedFeaturesTableDataset
+from kart.tabular.arrow_export import ArrowTableExporter
+from kart.tabular.derived_columns import (
+    ALL_DATASETS,
+    PROVENANCE_COLUMNS,
+    DerivedColumnsTableDataset,
+    get_derived_columns,
+)
+from kart.tabular.dxf_export import DxfTableExporter
+from kart.tabular.ogr_export import KmlTableExporter, OgrTableExporter
+from kart.tabular.redaction import (
+    REDACTION_SCHEMA,
+    RedactedTableDataset,
+    build_redaction_rules,
+)
+from kart.timestamps import datetime_to_iso8601_utc
+
+L = logging.getLogger("kart.export")
+
+
+class ExportFormat:
+    """A file format that table datasets can be exported to."""
+
+    def __init__(
+        self,
+        name,
+        driver_name,
+        extensions,
+        *,
+        dataset_options=(),
+        layer_options=(),
+        fid_layer_option=None,
+        exporter_class=OgrTableExporter,
+        exporter_options=(),
+        single_dataset=False,
+        supports_stdout=False,
+        supports_layer_styles=False,
+        supports_related_tables=False,
+    ):
+        self.name = name
+        self.driver_name = driver_name
+        self.extensions = extensions
+        self.dataset_options = dataset_options
+        self.layer_options = layer_options
+        self.fid_layer_option = fid_layer_option
+        self.exporter_class = exporter_class
+        # The names of any format-specific options that the exporter_class accepts.
+        self.exporter_options = exporter_options
+        # Whether each file can only contain one dataset.
+        self.single_dataset = single_dataset
+        # Whether the format can be streamed to stdout, by specifying "-" as the path.
+        self.supports_stdout = supports_stdout
+        # Whether the datasets' styles can be written to a layer_styles table, as QGIS does.
+        self.supports_layer_styles = supports_layer_styles
+        # Whether the datasets' relations can be written to a gpkgext_relations table - see relations.json.
+        self.supports_related_tables = supports_related_tables
+
+    def exporter(self, path, **kwargs):
+        return self.exporter_class(
+            path,
+            self.driver_name,
+            dataset_options=self.dataset_options,
+            layer_options=self.layer_options,
+            fid_layer_option=self.fid_layer_option,
+            **kwargs,
+        )
+
+
+# The exporter options supported by OgrTableExporter and its subclasses.
+OGR_EXPORTER_OPTIONS = ("num_workers", "batch_size")
+
+EXPORT_FORMATS = {
+    f.name: f
+    for f in [
+        ExportFormat(
+            "GPKG",
+            "GPKG",
+            (".gpkg",),
+            fid_layer_option="FID",
+            exporter_options=OGR_EXPORTER_OPTIONS,
+            supports_layer_styles=True,
+            supports_related_tables=True,
+        ),
+        ExportFormat(
+            "SPATIALITE",
+            "SQLite",
+            (".sqlite", ".db"),
+            dataset_options=["SPATIALITE=YES"],
+            # Store geometries as Spatialite geometry blobs, with geometry_columns / spatial_ref_sys metadata.
+            # Don't launder the layer and column names - they are kept exactly as they are in the dataset, eg
+            # Max(Height) would otherwise become max_height_.
+            layer_options=["FORMAT=SPATIALITE", "SPATIAL_INDEX=YES", "LAUNDER=NO"],
+            fid_layer_option="FID",
+            exporter_options=OGR_EXPORTER_OPTIONS,
+        ),
+        ExportFormat(
+            "KML",
+            "KML",
+            (".kml",),
+            exporter_class=KmlTableExporter,
+            exporter_options=("name_field", "styles") + OGR_EXPORTER_OPTIONS,
+        ),
+        ExportFormat(
+            "KMZ",
+            "KML",
+            (".kmz",),
+            exporter_class=KmlTableExporter,
+            exporter_options=("name_field", "styles") + OGR_EXPORTER_OPTIONS,
+        ),
+        ExportFormat(
+            "DXF",
+            None,
+            (".dxf",),
+            exporter_class=DxfTableExporter,
+            exporter_options=("attributes",),
+        ),
+        ExportFormat(
+            "GEOJSON",
+            "GeoJSON",
+            (".geojson", ".json"),
+            exporter_options=OGR_EXPORTER_OPTIONS,
+            single_dataset=True,
+        ),
+        ExportFormat(
+            "ARROW",
+            None,
+            (".arrow", ".arrows", ".feather"),
+            exporter_class=ArrowTableExporter,
+            single_dataset=True,
+            supports_stdout=True,
+        ),
+        ExportFormat(
+            "PARQUET",
+            None,
+            (".parquet", ".geoparquet"),
+            exporter_class=ArrowTableExporter,
+            single_dataset=True,
+        ),
+    ]
+}
+
+HEX_COLOR_PATTERN = "^#[0-9a-fA-F]{6}([0-9a-fA-F]{2})?$"
+STYLE_SCHEMA = {
+    "type": "object",
+    "$schema": "http://json-schema.org/draft-07/schema",
+    "patternProperties": {
+        ".*": {
+            "type": "object",
+            "properties": {
+                "color": {"type": "string", "pattern": HEX_COLOR_PATTERN},
+                "fill": {"type": "string", "pattern": HEX_COLOR_PATTERN},
+                "width": {"type": "number"},
+                "icon": {"type": "string"},
+            },
+            "additionalProperties": False,
+        }
+    },
+}
+
+
+def parse_export_destination(dest, format_name=None):
+    """
+    Given an export destination of the form [FORMAT:]PATH, returns (ExportFormat, Path).
+    If no format is specified either as a prefix or as format_name, it is inferred from the file extension.
+    """
+    if format_name is None and ":" in dest:
+        prefix, rest = dest.split(":", 1)
+        # Don't mistake a Windows drive letter for a format.
+        if prefix.upper() in EXPORT_FORMATS:
+            format_name, dest = prefix, rest
+
+    path = Path(dest)
+    if format_name is not None:
+        export_format = EXPORT_FORMATS.get(format_name.upper())
+        if export_format is None:
+            raise NotYetImplemented(
+                f"Exporting to {format_name} is not supported - supported formats are: {', '.join(EXPORT_FORMATS)}"
+            )
+        return export_format, path
+
+    suffix = path.suffix.lower()
+    for export_format in EXPORT_FORMATS.values():
+        if suffix in export_format.extensions:
+            return export_format, path
+    raise InvalidOperation(
+        f"Couldn't tell which format to export to from {dest} - use --format or a prefix such as GPKG:{dest}",
+        exit_code=INVALID_ARGUMENT,
+    )
+
+
+def get_datasets_to_export(
+    repo, refish, ds_paths, publish_profile=None, include_archived=False
+):
+    from kart.tabular.table_dataset import TableDataset
+
+    all_datasets = {ds.path: ds for ds in repo.datasets(refish)}
+    if not ds_paths:
+        datasets = [
+            ds
+            for ds in all_datasets.values()
+            if isinstance(ds, TableDataset)
+            and (publish_profile is None or publish_profile.includes_dataset(ds.path))
+        ]
+        if not datasets:
+            raise NotFound(f"No table datasets found at {refish}", exit_code=NO_DATA)
+        return [
+            _prepare_dataset(ds, publish_profile, include_archived) for ds in datasets
+        ]
+
+    result = []
+    for ds_path in ds_paths:
+        ds = all_datasets.get(ds_path)
+        if ds is None:
+            raise NotFound(f"No dataset found at {ds_path}", exit_code=NO_DATA)
+        if not isinstance(ds, TableDataset):
+            raise NotYetImplemented(
+                f"Exporting {ds.DATASET_TYPE} datasets is not supported - only table datasets can be exported"
+            )
+        if publish_profile is not None and not publish_profile.includes_dataset(
+            ds_path
+        ):
+            raise InvalidOperation(
+                f"{ds_path} is not published by profile {publish_profile.name}"
+            )
+        result.append(_prepare_dataset(ds, publish_profile, include_archived))
+    return result
+
+
+def _prepare_dataset(dataset, publish_profile, include_archived):
+    # Archived features are added first, so that the profile's filters and redaction rules apply to them too.
+    if include_archived:
+        dataset = ArchivedFeaturesTableDataset.wrap_if_needed(dataset)
+    return publish_profile.apply(dataset) if publish_profile else dataset
+
+
+@click.command(cls=KartCommand)
+@click.pass_context
+@click.option(
+    "--ref",
+    default="HEAD",
+    shell_complete=ref_completer,
+    help="The commit to export the datasets from. Defaults to HEAD.",
+)
+@click.option(
+    "--format",
+    "format_name",
+    type=click.Choice(list(EXPORT_FORMATS), case_sensitive=False),
+    help="The format to export to. Defaults to a format inferred from the file extension.",
+)
+@click.option(
+    "--overwrite",
+    is_flag=True,
+    help="Overwrite the destination file if it already exists.",
+)
+@click.option(
+    "--name-field",
+    help="KML/KMZ only: the field to use as the name of each placemark.",
+)
+@click.option(
+    "--style",
+    "styles",
+    type=JsonFromFile(encoding="utf-8", schema=STYLE_SCHEMA),
+    help=(
+        "KML/KMZ only: styles to apply to each dataset, as a JSON object or @filename of a JSON file. "
+        'Each key is a dataset path (or "*" for all other datasets), and each value is an object containing any of '
+        '"color" (of lines and points, eg "#ff0000"), "width" (of lines, in pixels), "fill" (of polygons, eg '
+        '"#ff000080" for translucent red) and "icon" (the URL of a point icon).'
+    ),
+)
+@click.option(
+    "--attributes",
+    help=(
+        "DXF only: a comma-separated list of fields to write as block attributes of each feature, "
+        "eg --attributes=name,height"
+    ),
+)
+@click.option(
+    "--exclude-columns",
+    help="A comma-separated list of columns to leave out of every exported dataset, eg --exclude-columns=owner,phone",
+)
+@click.option(
+    "--redact",
+    "redaction_config",
+    type=JsonFromFile(encoding="utf-8", schema=REDACTION_SCHEMA),
+    help=(
+        "Rules for redacting privacy-sensitive columns, as a JSON object or @filename of a JSON file. "
+        'Each key is a dataset path (or "*" for all datasets), and each value is an object mapping column names to '
+        'one of "exclude", "null", "hash" (replace each value with its SHA-256 hash) or "truncate:N" (keep only the '
+        'first N characters), eg {"*": {"phone": "null", "owner": "hash"}}'
+    ),
+)
+@click.option(
+    "--profile",
+    "profile_name",
+    help=(
+        "The name of a publish profile to apply - see `kart profile`. Only the datasets, features and columns "
+        "selected by the profile are exported, with its redaction rules applied."
+    ),
+)
+@click.option(
+    "--num-workers",
+    type=click.IntRange(min=1),
+    help=(
+        "How many threads to use to read and convert features, while another thread writes them. "
+        "Speeds up exporting large datasets. By default, features are read, converted and written by one thread."
+    ),
+)
+@click.option(
+    "--batch-size",
+    type=click.IntRange(min=1),
+    help="How many features to write in each transaction. Defaults to 10000.",
+)
+@click.option(
+    "--derived-columns/--no-derived-columns",
+    default=True,
+    help=(
+        "Whether to add the derived columns declared in the repository config to the exported datasets. "
+        "Declare them with eg `kart config --add kart.derivedColumn parcels:area_m2=area`."
+    ),
+)
+@click.option(
+    "--stamp-provenance",
+    is_flag=True,
+    help=(
+        "Add _commit, _exported_at and _source columns to every exported dataset, recording the commit the data was "
+        "exported from, when it was exported, and the repository and dataset it came from."
+    ),
+)
+@click.option(
+    "--include-archived",
+    is_flag=True,
+    help=(
+        "Also export the features that were archived when they were deleted - see `kart archive`. Adds "
+        "_archived_at and _archived_by columns to datasets that have archived features."
+    ),
+)
+@click.argument("destination", metavar="[FORMAT:]PATH")
+@click.argument(
+    "datasets",
+    nargs=-1,
+    metavar="[DATASETS]...",
+    shell_complete=repo_path_completer,
+)
+def export(
+    ctx,
+    ref,
+    format_name,
+    overwrite,
+    name_field,
+    styles,
+    attributes,
+    exclude_columns,
+    redaction_config,
+    profile_name,
+    num_workers,
+    batch_size,
+    derived_columns,
+    stamp_provenance,
+    include_archived,
+    destination,
+    datasets,
+):
+    """
+    Export table datasets from a commit to a new file - one layer per dataset.
+
+    DATASETS are the paths of the datasets to export - if none are specified, every table dataset is exported.
+    The format is inferred from the file extension, or can be specified with --format or a prefix, eg
+    SPATIALITE:out.sqlite. Supported formats: GPKG, SPATIALITE, KML, KMZ, DXF, GEOJSON,
+    ARROW, PARQUET.
+
+    When exporting to KML or KMZ, each dataset becomes a folder of placemarks, reprojected to WGS84.
+    When exporting to DXF, each dataset becomes a layer of points and polylines, and any fields selected with
+    --attributes are written as block attributes.
+    When exporting to ARROW, only one dataset can be exported at a time. Specify ARROW:- to write an Arrow IPC stream
+    to stdout - otherwise, .arrows files are written as streams, and .arrow or .feather files in the Arrow IPC file
+    format. Geometries are encoded as WKB. GEOJSON and PARQUET files can also only contain one dataset each.
+
+    Use --exclude-columns or --redact to strip privacy-sensitive fields from the exported copy. The data in the
+    repository itself isn't changed. Use export rather than clone to make a redacted copy - a clone contains every
+    earlier version of every feature, so it can't be redacted.
+
+    Use --profile to export the product defined by a publish profile, so that the same datasets, features and
+    columns are published consistently every time.
+
+    Derived columns - which are computed from each feature as it is exported, and aren't stored in the repository -
+    can be declared in the repository config as [DATASET:]NAME=FUNCTION, where FUNCTION is one of area, length,
+    centroid_x, centroid_y (all in the units of the dataset's CRS), geodesic_area, geodesic_length (in square metres
+    and metres, measured on the ellipsoid of the dataset's CRS), updated_by (the author of the last commit
+    that changed the feature), commit, exported_at or source.
+
+    Use --stamp-provenance to add _commit, _exported_at and _source columns to every exported dataset, so that
+    downstream copies of the data can always be traced back to the exact commit they were exported from.
+
+    When exporting to GPKG, any styles attached to the datasets with `kart style set` are written to the
+    layer_styles table, so QGIS applies them when the layers are loaded. Related tables - such as a table of photos
+    linked to each feature - are re-linked with the GeoPackage Related Tables Extension, as long as the related and
+    mapping datasets are exported too.
+
+    Use --include-archived to export the features that were deleted from datasets that archive their deleted
+    features, along with when and by whom they were deleted.
+
+    Unlike a working copy, the exported file isn't tracked by Kart - changes made to it can't be committed.
+    """
+    repo = ctx.obj.repo
+    export_format, path = parse_export_destination(destination, format_name)
+    to_stdout = str(path) == "-"
+    if to_stdout and not export_format.supports_stdout:
+        raise click.BadParameter(
+            f"Exporting {export_format.name} to stdout is not supported",
+            param_hint="PATH",
+        )
+    publish_profile = None
+    if profile_name is not None:
+        from kart.publish_profile import PublishProfile
+
+        publish_profile = PublishProfile.load(repo, profile_name)
+    datasets = get_datasets_to_export(
+        repo, ref, datasets, publish_profile, include_archived
+    )
+    if export_format.single_dataset and len(datasets) > 1:
+        raise click.UsageError(
+            f"Only one dataset can be exported to {export_format.name} at a time - specify which one to export"
+        )
+
+    derived_columns = get_derived_columns(repo) if derived_columns else {}
+    if stamp_provenance:
+        derived_columns.setdefault(ALL_DATASETS, {}).update(PROVENANCE_COLUMNS)
+    if derived_columns:
+        commit = repo.revparse_single(ref).peel(pygit2.Commit)
+        # Every dataset is stamped with the same export time.
+        exported_at = datetime_to_iso8601_utc(datetime.now(timezone.utc))
+        datasets = [
+            DerivedColumnsTableDataset.wrap_if_needed(
+                ds, derived_columns, commit, exported_at
+            )
+            for ds in datasets
+        ]
+
+    if attributes is not None:
+        attributes = [a.strip() for a in attributes.split(",") if a.strip()]
+
+    exporter_kwargs = {}
+    for param, option, value in [
+        ("--name-field", "name_field", name_field),
+        ("--style", "styles", styles),
+        ("--attributes", "attributes", attributes),
+        ("--num-workers", "num_workers", num_workers),
+        ("--batch-size", "batch_size", batch_size),
+    ]:
+        if value is None:
+            continue
+        if option not in export_format.exporter_options:
+            raise click.UsageError(
+                f"{param} is not supported when exporting to {export_format.name}"
+            )
+        exporter_kwargs[option] = value
+
+    if exclude_columns is not None:
+        exclude_columns = [c.strip() for c in exclude_columns.split(",") if c.strip()]
+    all_fields = {c.name for ds in datasets for c in ds.schema.columns}
+    for param, fields in [
+        ("--exclude-columns", exclude_columns or []),
+        ("--redact", [c for r in (redaction_config or {}).values() for c in r]),
+    ]:
+        missing = sorted(set(f for f in fields if f not in all_fields))
+        if missing:
+            raise click.BadParameter(
+                f"None of the datasets being exported have a field called {', '.join(missing)}",
+                param_hint=param,
+            )
+    rules = build_redaction_rules(exclude_columns or (), redaction_config)
+    datasets = [RedactedTableDataset.wrap_if_needed(ds, rules) for ds in datasets]
+
+    all_fields = {c.name for ds in datasets for c in ds.schema.columns}
+    for param, fields in [
+        ("--name-field", [name_field] if name_field else []),
+        ("--attributes", attributes or []),
+    ]:
+        missing = [f for f in fields if f not in all_fields]
+        if missing:
+            raise click.BadParameter(
+                f"None of the datasets being exported have a field called {', '.join(missing)}",
+                param_hint=param,
+            )
+
+    if not to_stdout and path.exists():
+        if not overwrite:
+            raise InvalidOperation(
+                f"{path} already exists - use --overwrite to replace it"
+            )
+        path.unlink()
+
+    layer_styles = []
+    with export_format.exporter(path, **exporter_kwargs) as exporter:
+        for dataset in datasets:
+            count = exporter.write_dataset(dataset)
+            click.echo(
+                f"Exported {count} features from {dataset.path}", err=to_stdout
+            )
+            if export_format.supports_layer_styles:
+                layer_name = dataset.dataset_path_to_table_name(dataset.path)
+                layer_styles.extend(get_layer_styles(dataset, layer_name))
+        if layer_styles:
+            exporter.write_layer_styles(layer_styles)
+    if export_format.supports_related_tables:
+        write_related_tables(path, datasets)
+    if not to_stdout:
+        click.echo(f"Wrote {export_format.name} file: {path}")
+
+
+def write_related_tables(path, datasets):
+    """
+    Writes the relations between the given datasets - see relations.json - to the gpkgext_relations table of the
+    GPKG at the given path. Relations to datasets that weren't exported are left out.
+    """
+    ds_paths = {dataset.path for dataset in datasets}
+    gpkgext_relations = []
+    for dataset in datasets:
+        layer_name = dataset.dataset_path_to_table_name(dataset.path)
+        gpkgext_relations.extend(
+            KartAdapter_GPKG.generate_gpkgext_relations(dataset, layer_name, ds_paths)
+        )
+    if not gpkgext_relations:
+        return
+
+    engine = KartAdapter_GPKG.create_engine(path)
+    try:
+        with sessionmaker(bind=engine)() as sess:
+            sess.execute("BEGIN TRANSACTION;")
+            KartAdapter_GPKG.write_gpkgext_relations(sess, gpkgext_relations)
+            sess.commit()
+    finally:
+        engine.dispose()
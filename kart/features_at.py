@@ -0,0 +1,310 @@
+from itertools import islice
+
+import click
+import pygit2
+
+from kart.cli_util import KartCommand
+from kart.completion_shared import repo_path_completer
+from kart.crs_util import CoordinateReferenceString, make_crs
+from kart.exceptions import (
+    CrsError,
+    InvalidOperation,
+    NotFound,
+    NO_COMMIT,
+    NO_DATA,
+)
+from kart.output_util import dump_json_output
+from kart.repo import KartRepoFiles
+from kart.spatial_filter.cell_index import find_candidate_features
+from kart.tabular.feature_output import feature_as_geojson
+
+# How many envelopes to look up in the spatial index at a time.
+LOOKUP_BATCH_SIZE = 500
+
+
+class FeatureEnvelopeIndex:
+    """
+    Read-only access to the envelopes stored by `kart spatial-filter index`. Envelopes are keyed by feature blob ID
+    and are in WGS 84, rounded outwards - so they can rule out features that definitely don't intersect a query
+    envelope, but can't prove that a feature does.
+    """
+
+    @classmethod
+    def open(cls, repo, commit_id):
+        """Returns the index, or None if there is no index or it doesn't cover the given commit."""
+        db_path = repo.gitdir_file(KartRepoFiles.FEATURE_ENVELOPES)
+        if not db_path.exists():
+            return None
+
+        from pysqlite3 import dbapi2 as sqlite
+
+        db = sqlite.connect(f"file:{db_path}?mode=ro", uri=True)
+        tables = {
+            row[0]
+            for row in db.execute(
+                "SELECT name FROM sqlite_master WHERE type='table';"
+            )
+        }
+        if not {"commits", "feature_envelopes"} <= tables:
+            db.close()
+            return None
+        has_cells = "feature_cells" in tables
+
+        # A commit is indexed if it is one of the indexed commits or one of their ancestors.
+        commit_oid = pygit2.Oid(hex=commit_id)
+        for (indexed_id,) in db.execute("SELECT commit_id FROM commits;"):
+            indexed_oid = pygit2.Oid(raw=bytes(indexed_id))
+            if indexed_oid == commit_oid or repo.descendant_of(
+                indexed_oid, commit_oid
+            ):
+                return cls(db, has_cells)
+        db.close()
+        return None
+
+    def __init__(self, db, has_cells=False):
+        from kart.spatial_filter.index import EnvelopeEncoder
+
+        self.db = db
+        self.has_cells = has_cells
+        envelope_length = db.execute(
+            "SELECT length(envelope) FROM feature_envelopes LIMIT 1;"
+        ).fetchone()
+        self.encoder = EnvelopeEncoder(
+            envelope_length[0] * 8 // 4 if envelope_length else None
+        )
+
+    def envelopes(self, blob_ids):
+        """Returns a dict of {blob_id: (w, s, e, n)} for those of the given blob IDs that have an envelope."""
+        params = [bytes.fromhex(b) for b in blob_ids]
+        placeholders = ",".join("?" * len(params))
+        rows = self.db.execute(
+            f"SELECT blob_id, envelope FROM feature_envelopes WHERE blob_id IN ({placeholders});",
+            params,
+        )
+        return {bytes(b).hex(): self.encoder.decode(bytes(e)) for b, e in rows}
+
+    def close(self):
+        self.db.close()
+
+
+def _lon_ranges(w, e):
+    # Envelopes that cross the anti-meridian have w > e.
+    return [(w, e)] if w <= e else [(w, 180), (-180, e)]
+
+
+def envelopes_intersect(a, b):
+    """
+    Given two (w, s, e, n) envelopes in WGS 84 - returns True if they overlap or touch. Unlike bbox_intersects_fast,
+    this handles zero-width envelopes (points), and envelopes that cross the anti-meridian.
+    """
+    if a[1] > b[3] or b[1] > a[3]:
+        return False
+    return any(
+        a_w <= b_e and b_w <= a_e
+        for a_w, a_e in _lon_ranges(a[0], a[2])
+        for b_w, b_e in _lon_ranges(b[0], b[2])
+    )
+
+
+def _parse_numbers(value, count, param_hint):
+    try:
+        result = [float(v) for v in value.split(",")]
+    except ValueError:
+        result = []
+    if len(result) != count:
+        raise click.BadParameter(
+            f"Expected {count} comma-separated numbers, got {value!r}",
+            param_hint=param_hint,
+        )
+    return result
+
+
+def query_geometry_wkt(point, bbox):
+    if point and bbox:
+        raise click.UsageError("--point and --bbox are mutually exclusive")
+    if point:
+        x, y = _parse_numbers(point, 2, "--point")
+        return f"POINT({x!r} {y!r})"
+    if bbox:
+        w, s, e, n = _parse_numbers(bbox, 4, "--bbox")
+        if w > e or s > n:
+            raise click.BadParameter(
+                "Expected W,S,E,N where W <= E and S <= N", param_hint="--bbox"
+            )
+        return f"POLYGON(({w!r} {s!r},{e!r} {s!r},{e!r} {n!r},{w!r} {n!r},{w!r} {s!r}))"
+    raise click.UsageError("One of --point or --bbox is required")
+
+
+def _envelope_wgs84(spatial_filter):
+    from osgeo import osr
+
+    geom_ogr = spatial_filter.filter_ogr.Clone()
+    try:
+        geom_ogr.Transform(
+            osr.CoordinateTransformation(spatial_filter.crs, make_crs("EPSG:4326"))
+        )
+    except RuntimeError as e:
+        raise CrsError(f"Can't reproject query geometry into EPSG:4326:\n{e}")
+    w, e, s, n = geom_ogr.GetEnvelope()
+    return w, s, e, n
+
+
+def _batches(iterable, size):
+    iterator = iter(iterable)
+    while True:
+        batch = list(islice(iterator, size))
+        if not batch:
+            return
+        yield batch
+
+
+def _features_from_cell_index(repo, dataset, commit_id, index, envelope, matches):
+    # The cell index has rows for every indexed commit - only those features that are at this commit are relevant.
+    tree = repo[commit_id].tree
+    for feature_path, blob_id in find_candidate_features(
+        index.db, dataset.path, envelope
+    ):
+        try:
+            blob = tree / feature_path
+        except KeyError:
+            continue
+        if blob.id.hex != blob_id:
+            continue
+        feature = dataset.get_feature_from_blob(blob)
+        if matches(feature):
+            yield feature
+
+
+def iter_features_at(repo, dataset, commit_id, original_filter):
+    """
+    Yields every feature of the dataset whose geometry intersects the given OriginalSpatialFilter. If the spatial
+    index covers the commit, only the features in the cells near the query are read (or for older indexes without
+    cells, only the features whose indexed envelopes intersect the query) - otherwise, every feature is read.
+    """
+    spatial_filter = original_filter.transform_for_dataset(dataset)
+    geom_column = dataset.geom_column_name
+
+    def _matches(feature):
+        # The spatial filter matches features with no geometry, but these don't intersect anything.
+        return feature[geom_column] is not None and spatial_filter.matches(feature)
+
+    index = FeatureEnvelopeIndex.open(repo, commit_id)
+    if index is None:
+        click.echo(
+            f"Warning: The spatial index doesn't cover commit {commit_id[:7]}, so every feature will be read. "
+            "Run `kart spatial-filter index` to speed this up.",
+            err=True,
+        )
+        for feature in dataset.features():
+            if _matches(feature):
+                yield feature
+        return
+
+    query_envelope = _envelope_wgs84(original_filter)
+    try:
+        if index.has_cells:
+            yield from _features_from_cell_index(
+                repo, dataset, commit_id, index, query_envelope, _matches
+            )
+            return
+
+        for blobs in _batches(dataset.feature_blobs(), LOOKUP_BATCH_SIZE):
+            envelopes = index.envelopes([blob.id.hex for blob in blobs])
+            for blob in blobs:
+                envelope = envelopes.get(blob.id.hex)
+                # Features that weren't indexed - eg, because they have no geometry - are checked the slow way.
+                if envelope is not None and not envelopes_intersect(
+                    envelope, query_envelope
+                ):
+                    continue
+                feature = dataset.get_feature_from_blob(blob)
+                if _matches(feature):
+                    yield feature
+    finally:
+        index.close()
+
+
+@click.command("features-at", cls=KartCommand)
+@click.pass_context
+@click.option(
+    "--point",
+    metavar="X,Y",
+    help="Find the features that intersect this point.",
+)
+@click.option(
+    "--bbox",
+    metavar="W,S,E,N",
+    help="Find the features that intersect this bounding box.",
+)
+@click.option(
+    "--crs",
+    "crs_spec",
+    type=CoordinateReferenceString(encoding="utf-8", keep_as_string=True),
+    default="EPSG:4326",
+    show_default=True,
+    help="The coordinate reference system of the --point or --bbox coordinates.",
+)
+@click.option(
+    "--output-crs",
+    type=CoordinateReferenceString(encoding="utf-8"),
+    help="Reproject geometries into the given coordinate reference system. Accepts: 'EPSG:<code>'; proj text; OGC WKT; OGC URN; PROJJSON.)",
+)
+@click.option(
+    "--output",
+    "output_path",
+    help="Output to a specific file instead of stdout.",
+    type=click.Path(writable=True, allow_dash=True, dir_okay=False),
+    default="-",
+)
+@click.argument(
+    "dataset_spec", metavar="DATASET[@COMMIT]", shell_complete=repo_path_completer
+)
+def features_at(ctx, point, bbox, crs_spec, output_crs, output_path, dataset_spec):
+    """
+    Output the features of a dataset that intersect a point or bounding box as GeoJSON, without exporting the whole
+    dataset. If the commit has been indexed using `kart spatial-filter index`, the index is used to avoid reading
+    features that are nowhere near the query.
+
+    eg: kart features-at parcels@HEAD~2 --point 174.77,-41.29
+    """
+    from kart.spatial_filter import OriginalSpatialFilter
+
+    repo = ctx.obj.repo
+    ds_path, _, commit_spec = dataset_spec.partition("@")
+    commit_spec = commit_spec or "HEAD"
+    try:
+        commit = repo.revparse_single(commit_spec).peel(pygit2.Commit)
+    except (KeyError, ValueError):
+        raise NotFound(f"{commit_spec} is not a commit", exit_code=NO_COMMIT)
+
+    dataset = repo.datasets(commit).get(ds_path)
+    if dataset is None:
+        raise NotFound(
+            f"No dataset found at {ds_path} at commit {commit.short_id}",
+            exit_code=NO_DATA,
+        )
+    if dataset.DATASET_TYPE != "table" or not dataset.has_geometry:
+        raise InvalidOperation(f"Dataset {ds_path} has no geometry to query")
+
+    original_filter = OriginalSpatialFilter.from_spec(
+        crs_spec, query_geometry_wkt(point, bbox)
+    )
+
+    geometry_transform = None
+    if output_crs is not None:
+        from osgeo import osr
+
+        ds_crs_defs = dataset.crs_definitions()
+        if ds_crs_defs:
+            ds_crs = make_crs(list(ds_crs_defs.values())[0])
+            geometry_transform = osr.CoordinateTransformation(ds_crs, output_crs)
+
+    features = (
+        feature_as_geojson(
+            feature,
+            dataset.pk_value(feature),
+            geometry_transform=geometry_transform,
+        )
+        for feature in iter_features_at(repo, dataset, commit.hex, original_filter)
+    )
+    dump_json_output({"type": "FeatureCollection", "features": features}, output_path)
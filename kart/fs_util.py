@@ -0,0 +1,238 @@
+import errno
+import logging
+import os
+import shutil
+import sys
+import time
+from pathlib import Path
+
+from kart import is_windows
+from kart.exceptions import FILE_LOCKED, InvalidOperation
+from kart import subprocess_util as subprocess
+
+L = logging.getLogger("kart.fs_util")
+
+# Paths longer than this need the \\?\ prefix to be used with the Windows API.
+# (Strictly, the limit is 260 including the terminating NUL - but some APIs, such as CreateDirectory, have a limit of
+# 248 characters, so we start using the prefix a little early.)
+WINDOWS_MAX_PATH = 248
+
+# Windows error codes that mean a file is open by another process:
+ERROR_SHARING_VIOLATION = 32
+ERROR_LOCK_VIOLATION = 33
+
+# How many times we retry a file operation that failed due to the file being locked, and how long we wait (in seconds)
+# before the first retry - the delay doubles with each retry. Network shares and virus scanners can briefly lock files
+# that Kart has just written, so a few retries usually resolve the problem.
+DEFAULT_LOCK_RETRIES = 5
+DEFAULT_LOCK_RETRY_DELAY = 0.1
+
+
+def long_path(path):
+    """
+    Returns a version of the given path that can be used with OS file APIs even if it is very long.
+    On Windows, absolute paths longer than MAX_PATH are prefixed with \\\\?\\ (or \\\\?\\UNC\\ for network shares).
+    On all other platforms - or if the path is short enough - the path is returned unchanged.
+    """
+    if not is_windows:
+        return path
+    path_str = str(path)
+    if len(path_str) < WINDOWS_MAX_PATH or path_str.startswith("\\\\?\\"):
+        return path
+    path_str = os.path.abspath(path_str)
+    if path_str.startswith("\\\\"):
+        # \\server\share\... -> \\?\UNC\server\share\...
+        result = "\\\\?\\UNC\\" + path_str[2:]
+    else:
+        result = "\\\\?\\" + path_str
+    return Path(result) if isinstance(path, Path) else result
+
+
+def is_file_locked_error(error):
+    """Returns True if the given OSError was caused by the file being open or locked by another process."""
+    if not isinstance(error, OSError):
+        return False
+    if is_windows:
+        return getattr(error, "winerror", None) in (
+            ERROR_SHARING_VIOLATION,
+            ERROR_LOCK_VIOLATION,
+        )
+    return error.errno in (errno.EBUSY, errno.ETXTBSY)
+
+
+def _lock_retry_settings():
+    retries = int(os.environ.get("KART_LOCK_RETRIES", DEFAULT_LOCK_RETRIES))
+    delay = float(os.environ.get("KART_LOCK_RETRY_DELAY", DEFAULT_LOCK_RETRY_DELAY))
+    return retries, delay
+
+
+def retry_if_locked(func, path, *args, **kwargs):
+    """
+    Calls func(long_path(path), *args, **kwargs). If this fails because the file at path is locked by another process,
+    retries a few times with an increasing delay, then raises an error that says which process holds the lock.
+    The number of retries and the initial delay can be configured with the environment variables KART_LOCK_RETRIES
+    and KART_LOCK_RETRY_DELAY (in seconds).
+    """
+    retries, delay = _lock_retry_settings()
+    for attempt in range(retries + 1):
+        try:
+            return func(long_path(path), *args, **kwargs)
+        except OSError as e:
+            if not is_file_locked_error(e):
+                raise
+            if attempt == retries:
+                raise file_locked_error(path, e) from e
+            L.info(
+                "File %s is locked - retrying in %ss (attempt %d/%d)",
+                path,
+                delay,
+                attempt + 1,
+                retries,
+            )
+            time.sleep(delay)
+            delay *= 2
+
+
+def unlink(path, missing_ok=False):
+    """Like Path.unlink, but supports long paths and retries if the file is locked."""
+    try:
+        retry_if_locked(os.unlink, path)
+    except FileNotFoundError:
+        if not missing_ok:
+            raise
+
+
+def rmtree(path):
+    """Like shutil.rmtree, but supports long paths and retries if a file is locked."""
+
+    def _on_exc(func, failed_path, error):
+        if is_file_locked_error(error):
+            retry_if_locked(func, failed_path)
+        else:
+            raise error
+
+    if sys.version_info >= (3, 12):
+        shutil.rmtree(long_path(path), onexc=_on_exc)
+    else:
+        # onerror is deprecated since Python 3.12, in favour of onexc.
+        def _on_error(func, failed_path, exc_info):
+            _on_exc(func, failed_path, exc_info[1])
+
+        shutil.rmtree(long_path(path), onerror=_on_error)
+
+
+def file_locked_error(path, cause=None):
+    """Returns an InvalidOperation error explaining that the file is locked, and by which processes if possible."""
+    message = f"Couldn't access {path} - it is locked by another process"
+    holders = find_processes_holding_file(path)
+    if holders:
+        holders_desc = ", ".join(
+            f"PID {pid} ({name})" if name else f"PID {pid}" for pid, name in holders
+        )
+        message += f": {holders_desc}"
+    message += "\nClose any applications that have this file open (such as a GIS application) and try again."
+    if cause is not None:
+        message += f"\nCaused by error:\n{cause}"
+    return InvalidOperation(message, exit_code=FILE_LOCKED)
+
+
+def find_processes_holding_file(path):
+    """
+    Returns a list of (pid, process_name) tuples for the processes that have the given file open - best effort.
+    Returns an empty list if this can't be determined. The process name may be None if it can't be determined.
+    """
+    try:
+        if is_windows:
+            return _find_processes_holding_file_windows(path)
+        else:
+            return _find_processes_holding_file_posix(path)
+    except Exception as e:
+        L.debug("Couldn't find processes holding %s: %s", path, e)
+        return []
+
+
+def _find_processes_holding_file_posix(path):
+    if not shutil.which("lsof"):
+        return []
+    proc = subprocess.run(
+        ["lsof", "-F", "pc", "--", str(path)],
+        capture_output=True,
+        encoding="utf-8",
+    )
+    result = []
+    pid = None
+    for line in proc.stdout.splitlines():
+        if line.startswith("p"):
+            pid = int(line[1:])
+        elif line.startswith("c") and pid is not None:
+            result.append((pid, line[1:]))
+            pid = None
+    if pid is not None:
+        result.append((pid, None))
+    return result
+
+
+def _find_processes_holding_file_windows(path):
+    # Uses the Windows Restart Manager API, which is the supported way to find out which processes are using a file.
+    import ctypes
+    from ctypes import wintypes
+
+    CCH_RM_SESSION_KEY = 32
+    CCH_RM_MAX_APP_NAME = 255
+    CCH_RM_MAX_SVC_NAME = 63
+    ERROR_MORE_DATA = 234
+
+    class RM_UNIQUE_PROCESS(ctypes.Structure):
+        _fields_ = [
+            ("dwProcessId", wintypes.DWORD),
+            ("ProcessStartTime", wintypes.FILETIME),
+        ]
+
+    class RM_PROCESS_INFO(ctypes.Structure):
+        _fields_ = [
+            ("Process", RM_UNIQUE_PROCESS),
+            ("strAppName", wintypes.WCHAR * (CCH_RM_MAX_APP_NAME + 1)),
+            ("strServiceShortName", wintypes.WCHAR * (CCH_RM_MAX_SVC_NAME + 1)),
+            ("ApplicationType", ctypes.c_int),
+            ("AppStatus", wintypes.ULONG),
+            ("TSSessionId", wintypes.DWORD),
+            ("bRestartable", wintypes.BOOL),
+        ]
+
+    rstrtmgr = ctypes.WinDLL("rstrtmgr")
+    session = wintypes.DWORD()
+    session_key = (wintypes.WCHAR * (CCH_RM_SESSION_KEY + 1))()
+    if rstrtmgr.RmStartSession(ctypes.byref(session), 0, session_key) != 0:
+        return []
+    try:
+        resources = (wintypes.LPCWSTR * 1)(str(os.path.abspath(path)))
+        if rstrtmgr.RmRegisterResources(session, 1, resources, 0, None, 0, None):
+            return []
+        needed = wintypes.UINT(0)
+        count = wintypes.UINT(0)
+        reboot_reasons = wintypes.DWORD()
+        status = rstrtmgr.RmGetList(
+            session,
+            ctypes.byref(needed),
+            ctypes.byref(count),
+            None,
+            ctypes.byref(reboot_reasons),
+        )
+        if status not in (0, ERROR_MORE_DATA) or needed.value == 0:
+            return []
+        infos = (RM_PROCESS_INFO * needed.value)()
+        count = wintypes.UINT(needed.value)
+        if rstrtmgr.RmGetList(
+            session,
+            ctypes.byref(needed),
+            ctypes.byref(count),
+            infos,
+            ctypes.byref(reboot_reasons),
+        ):
+            return []
+        return [
+            (info.Process.dwProcessId, info.strAppName or None)
+            for info in infos[: count.value]
+        ]
+    finally:
+        rstrtmgr.RmEndSession(session)
@@ -1,14 +1,80 @@
 import os
+import re
+import time
 
 import click
 
 from kart.cli_util import KartCommand
-from kart.exceptions import NO_WORKING_COPY, NotFound
+from kart.exceptions import (
+    NO_WORKING_COPY,
+    NotFound,
+    SubprocessError,
+)
 from kart.geometry import normalise_gpkg_geom
+from kart.process_lock import start_write_lock
 from kart import subprocess_util as subprocess
 from kart.sqlalchemy.gpkg import Db_GPKG
 
 
+# Matches the path of a loose object in git fsck's error messages, eg:
+# error: hash mismatch for ./objects/ab/cdef... (expected abcdef...)
+LOOSE_OBJECT_PATH_PATTERN = re.compile(r"objects/([0-9a-f]{2})/([0-9a-f]{38})\b")
+# Matches objects that git fsck reports as missing, eg "missing blob abcdef..." or "broken link from tree X to blob Y"
+MISSING_OBJECT_PATTERN = re.compile(
+    r"(?:missing (?:blob|tree|commit|tag)|broken link from\s+\w+\s+[0-9a-f]{40}\s+to\s+\w+)\s+([0-9a-f]{40})"
+)
+
+
+def _find_damaged_objects(fsck_output):
+    """
+    Parses the output of git fsck. Returns a tuple (corrupt, missing) of the object IDs of loose objects that
+    are corrupt, and of objects that are missing.
+    """
+    corrupt = {a + b for a, b in LOOSE_OBJECT_PATH_PATTERN.findall(fsck_output)}
+    missing = set(MISSING_OBJECT_PATTERN.findall(fsck_output)) - corrupt
+    return corrupt, missing
+
+
+def _git_fsck(repo, fsck_args):
+    """Runs git fsck, displaying its output. Returns (exit_code, combined stdout and stderr)."""
+    exit_code, stdout, stderr = subprocess.run_and_tee_output(
+        ["git", "-C", repo.path, "fsck"] + list(fsck_args),
+        tee_stdout=True,
+        tee_stderr=True,
+    )
+    return exit_code, f"{stdout}\n{stderr}"
+
+
+def _repair_from_remote(repo, remote_name, corrupt):
+    """
+    Moves any corrupt loose objects out of the object store - so that git doesn't keep reading them - and then
+    refetches every object from the given remote, to replace the damaged or missing ones.
+    """
+    if remote_name not in repo.remotes.names():
+        raise NotFound(f"No such remote: {remote_name}")
+
+    if corrupt:
+        timestamp = time.strftime("%Y%m%d%H%M%S")
+        quarantine = repo.gitdir_file(f"objects-corrupt/{timestamp}")
+        quarantine.mkdir(parents=True, exist_ok=True)
+        for oid in sorted(corrupt):
+            path = repo.gitdir_file(f"objects/{oid[:2]}/{oid[2:]}")
+            if path.exists():
+                path.rename(quarantine / oid)
+        click.echo(f"Moved {len(corrupt)} corrupt objects to {quarantine}")
+
+    click.echo(f"Refetching objects from {remote_name}...")
+    try:
+        subprocess.check_call(
+            ["git", "-C", repo.path, "fetch", "--refetch", remote_name]
+        )
+    except subprocess.CalledProcessError as e:
+        raise SubprocessError(
+            f"There was a problem refetching objects from {remote_name}: {e}",
+            called_process_error=e,
+        )
+
+
 def _fsck_reset(repo, working_copy, dataset_paths):
     commit = repo.head_commit
     datasets = [repo.datasets()[p] for p in dataset_paths]
@@ -25,17 +91,48 @@ def _fsck_reset(repo, working_copy, dataset_paths):
     multiple=True,
     help="Reset the working copy for this dataset path",
 )
+@click.option(
+    "--repair-from",
+    "repair_remote",
+    metavar="REMOTE",
+    help=(
+        "If any objects are missing or corrupt, refetch them from this remote. "
+        "Corrupt loose objects are moved aside to the objects-corrupt directory first."
+    ),
+)
 @click.argument("fsck_args", nargs=-1, type=click.UNPROCESSED)
-def fsck(ctx, reset_datasets, fsck_args):
-    """Verifies the connectivity and validity of the objects in the database"""
+def fsck(ctx, reset_datasets, repair_remote, fsck_args):
+    """
+    Verifies the connectivity and validity of the objects in the database
+
+    Every object is re-hashed to check it matches its ID, so any corruption is reported, as are any missing objects.
+    Then the working copy is checked against the repository.
+    """
     repo = ctx.obj.repo
+    if reset_datasets or repair_remote:
+        # Plain fsck doesn't change anything, so it only needs the write lock if it might repair or reset something.
+        start_write_lock(ctx)
 
     click.echo("Checking repository integrity...")
-    r = subprocess.call(
-        ["git", "-C", repo.path, "fsck"] + list(fsck_args),
-    )
+    r, fsck_output = _git_fsck(repo, fsck_args)
     if r:
-        click.Abort()
+        corrupt, missing = _find_damaged_objects(fsck_output)
+        if not corrupt and not missing:
+            raise click.Abort()
+        click.secho(
+            f"✘ Repository has {len(corrupt)} corrupt and {len(missing)} missing objects",
+            fg="red",
+        )
+        if not repair_remote:
+            raise click.Abort()
+        _repair_from_remote(repo, repair_remote, corrupt)
+
+        click.echo("Checking repository integrity again...")
+        r, fsck_output = _git_fsck(repo, fsck_args)
+        if r:
+            click.secho("✘ Repository is still damaged", fg="red")
+            raise click.Abort()
+        click.secho(f"✔︎ Repository repaired from {repair_remote}", fg="green")
 
     # now check our stuff:
     # 1. working copy
@@ -46,6 +46,54 @@ class GeometryType(IntEnum):
     MULTILINESTRING = 5
     MULTIPOLYGON = 6
     GEOMETRYCOLLECTION = 7
+    CIRCULARSTRING = 8
+    COMPOUNDCURVE = 9
+    CURVEPOLYGON = 10
+    MULTICURVE = 11
+    MULTISURFACE = 12
+    CURVE = 13
+    SURFACE = 14
+    POLYHEDRALSURFACE = 15
+    TIN = 16
+    TRIANGLE = 17
+
+
+# Geometry types that can contain curved segments, and the linear type that each is approximated by
+# when it is linearized for a target that doesn't support curves.
+LINEAR_GEOMETRY_TYPE_FOR_CURVE_TYPE = {
+    GeometryType.CIRCULARSTRING: GeometryType.LINESTRING,
+    GeometryType.COMPOUNDCURVE: GeometryType.LINESTRING,
+    GeometryType.CURVE: GeometryType.LINESTRING,
+    GeometryType.CURVEPOLYGON: GeometryType.POLYGON,
+    GeometryType.SURFACE: GeometryType.POLYGON,
+    GeometryType.MULTICURVE: GeometryType.MULTILINESTRING,
+    GeometryType.MULTISURFACE: GeometryType.MULTIPOLYGON,
+}
+
+
+def is_curve_geometry_type_name(geometry_type_name):
+    """
+    Given a V2 geometryType such as "COMPOUNDCURVE Z" or "POLYGON", returns True if geometries
+    of that type can contain curved segments.
+    """
+    flat_name = geometry_type_name.strip().split(" ", 1)[0].upper()
+    return flat_name in GeometryType.__members__ and (
+        GeometryType[flat_name] in LINEAR_GEOMETRY_TYPE_FOR_CURVE_TYPE
+    )
+
+
+def linearized_geometry_type_name(geometry_type_name):
+    """
+    Given a V2 geometryType such as "COMPOUNDCURVE Z", returns the linear geometryType that it would
+    be approximated by - in this case "LINESTRING Z". Linear geometry types are returned unchanged.
+    """
+    parts = geometry_type_name.strip().split(" ", 1)
+    flat_name = parts[0].upper()
+    if flat_name in GeometryType.__members__:
+        linear_type = LINEAR_GEOMETRY_TYPE_FOR_CURVE_TYPE.get(GeometryType[flat_name])
+        if linear_type is not None:
+            parts[0] = linear_type.name
+    return " ".join(parts)
 
 
 class GeometryString(StringFromFile):
@@ -103,6 +151,20 @@ def geometry_from_string(string, allowed_types=None, allow_empty=False, context=
         raise GeometryError(f"Invalid {geometry_desc}: {string!r} ({e})")
 
 
+def is_missing_geometry(geom):
+    """Returns True if the given geometry is NULL (None) or EMPTY."""
+    return geom is None or geom.is_empty()
+
+
+def missing_geometry_description(geom):
+    """Describes why the given geometry is considered missing - see is_missing_geometry."""
+    if geom is None:
+        return "NULL"
+    elif geom.is_empty():
+        return f"EMPTY ({geom.geometry_type_name} EMPTY)"
+    return None
+
+
 class Geometry(bytes):
     """
     Contains a geometry in Kart's chosen format - StandardGeoPackageBinary.
@@ -158,6 +220,10 @@ class Geometry(bytes):
         return self[3]
 
     def is_empty(self):
+        """
+        Returns True if this is an EMPTY geometry - eg 'POINT EMPTY'. Note that a NULL geometry is not represented by
+        a Geometry object at all, but by None.
+        """
         return bool(self.flags & _GPKG_EMPTY_BIT)
 
     def is_little_endian(self):
@@ -197,6 +263,31 @@ class Geometry(bytes):
             name = f"{name} {suffix}"
         return name
 
+    def has_curve(self):
+        """
+        Returns True if this geometry is of a type that may contain curved segments -
+        CircularString, CompoundCurve, CurvePolygon, MultiCurve or MultiSurface - or if it is
+        a GeometryCollection that contains such a geometry.
+        """
+        geom_type = self.geometry_type
+        if ogr.GT_IsNonLinear(geom_type):
+            return True
+        if ogr.GT_Flatten(geom_type) == ogr.wkbGeometryCollection:
+            return bool(self.to_ogr().HasCurveGeometry(True))
+        return False
+
+    def linearize(self, max_angle_step_degrees=0):
+        """
+        Returns a linear approximation of this geometry - one where every curved segment is replaced by a
+        series of straight line segments - or this geometry unchanged if it contains no curves.
+        max_angle_step_degrees controls the largest step along a curve between two vertices, 0 means
+        use OGR's default (4 degrees, or as set by the OGR_ARC_STEPSIZE config option).
+        """
+        if not self.has_curve():
+            return self
+        ogr_geom = self.to_ogr().GetLinearGeometry(max_angle_step_degrees)
+        return ogr_to_gpkg_geom(ogr_geom)
+
     def envelope(self, only_2d=False, calculate_if_missing=False):
         """
         Returns the envelope as a tuple of 4, 6, or 8 values, or None if no envelope is stored.
@@ -269,6 +360,21 @@ def _wkb_endianness_and_geometry_type(buf, wkb_offset=0):
     return is_le, typ
 
 
+def _wkb_is_empty(buf, wkb_offset=0):
+    """
+    Given a buffer containing some WKB at the given offset, returns True if the WKB describes an empty geometry.
+    Empty points are stored as POINT(NaN NaN) since WKB can't represent 'POINT EMPTY' - all other empty geometries
+    have zero points / rings / parts.
+    """
+    wkb_is_le, geom_type = _wkb_endianness_and_geometry_type(buf, wkb_offset=wkb_offset)
+    bo = _bo(wkb_is_le)
+    if ogr.GT_Flatten(geom_type) == ogr.wkbPoint:
+        px, py = struct.unpack_from(f"{bo}dd", buf, offset=wkb_offset + 5)
+        return math.isnan(px) and math.isnan(py)
+    (num_parts,) = struct.unpack_from(f"{bo}I", buf, offset=wkb_offset + 5)
+    return num_parts == 0
+
+
 def _desired_gpkg_envelope_type(flags, wkb_buffer, wkb_offset=0):
     """
     Given some GPKG geometry flags and some WKB,
@@ -310,8 +416,13 @@ def normalise_gpkg_geom(gpkg_geom):
         * has little-endian WKB
         * has an envelope
         * has srs_id set to 0.
+        * has the empty flag set if and only if the geometry is empty.
     If so, returns the geometry unmodified.
     Otherwise, returns a little-endian geometry with an envelope attached and srs_id=0.
+
+    Note that a NULL geometry (None) and an EMPTY geometry (eg 'POINT EMPTY') are different values, and are never
+    normalised to each other - None stays None, and an empty geometry is stored as a GPKG geometry with the empty flag
+    set (and without an envelope).
     """
     if gpkg_geom is None:
         return None
@@ -333,7 +444,11 @@ def normalise_gpkg_geom(gpkg_geom):
             flags, gpkg_geom, wkb_offset=8 + envelope_size
         )
         envelope_type = (flags & _GPKG_ENVELOPE_BITS) >> 1
-        if wkb_is_le and envelope_type == want_envelope_type:
+        # Some software doesn't set the empty flag for empty geometries - if so, we need to set it.
+        empty_flag_ok = bool(flags & _GPKG_EMPTY_BIT) == _wkb_is_empty(
+            gpkg_geom, wkb_offset=wkb_offset
+        )
+        if wkb_is_le and envelope_type == want_envelope_type and empty_flag_ok:
             # everything is fine, no need to roundtrip via OGR
             # just need to set srs_id to zero if it's not already
             if gpkg_geom[4:8] == b"\x00\x00\x00\x00":
@@ -0,0 +1,133 @@
+from difflib import SequenceMatcher
+import logging
+
+from osgeo import ogr
+
+from kart.geometry import Geometry, ogr_to_gpkg_geom
+
+L = logging.getLogger("kart.geometry_merge")
+
+# The geometry types whose vertices can be merged - everything else is made of these, or isn't supported.
+_VERTEX_SEQUENCE_TYPES = (ogr.wkbLineString, ogr.wkbLinearRing)
+_CONTAINER_TYPES = (
+    ogr.wkbPolygon,
+    ogr.wkbMultiLineString,
+    ogr.wkbMultiPolygon,
+)
+
+
+def _structure(ogr_geom):
+    """
+    Returns a description of the structure of the given geometry - which parts and rings it has - or None if it
+    contains anything other than linestrings and polygons.
+    """
+    flat_type = ogr.GT_Flatten(ogr_geom.GetGeometryType())
+    if flat_type in _VERTEX_SEQUENCE_TYPES:
+        return flat_type
+    if flat_type in _CONTAINER_TYPES:
+        children = []
+        for i in range(ogr_geom.GetGeometryCount()):
+            child = _structure(ogr_geom.GetGeometryRef(i))
+            if child is None:
+                return None
+            children.append(child)
+        return (flat_type, tuple(children))
+    return None
+
+
+def _vertex_sequences(ogr_geom):
+    """Yields every linestring and ring in the given geometry, in order."""
+    if ogr.GT_Flatten(ogr_geom.GetGeometryType()) in _VERTEX_SEQUENCE_TYPES:
+        yield ogr_geom
+        return
+    for i in range(ogr_geom.GetGeometryCount()):
+        yield from _vertex_sequences(ogr_geom.GetGeometryRef(i))
+
+
+def _edits(ancestor, version):
+    """Returns the changes made to the ancestor sequence as a list of (start, end, replacement) tuples."""
+    matcher = SequenceMatcher(None, ancestor, version, autojunk=False)
+    return [
+        (i1, i2, tuple(version[j1:j2]))
+        for tag, i1, i2, j1, j2 in matcher.get_opcodes()
+        if tag != "equal"
+    ]
+
+
+def _edits_overlap(x, y):
+    (x1, x2, _), (y1, y2, _) = x, y
+    if x1 == x2 or y1 == y2:
+        # An insertion conflicts with any other edit at the same place, including the ends of a replaced range.
+        return x1 <= y2 and y1 <= x2
+    return x1 < y2 and y1 < x2
+
+
+def merge_sequences(ancestor, ours, theirs):
+    """
+    Three-way merges two edited versions of a sequence. Returns the merged sequence as a list, or None if both versions
+    changed the same part of the ancestor in different ways.
+    """
+    our_edits, their_edits = _edits(ancestor, ours), _edits(ancestor, theirs)
+    for x in our_edits:
+        for y in their_edits:
+            if x != y and _edits_overlap(x, y):
+                return None
+
+    result = list(ancestor)
+    for start, end, replacement in sorted(set(our_edits + their_edits), reverse=True):
+        result[start:end] = replacement
+    return result
+
+
+def _merge_vertices(ancestor, ours, theirs, is_ring):
+    if is_ring:
+        # Merge rings without their closing vertex, so that moving the first vertex is a single edit.
+        ancestor, ours, theirs = ancestor[:-1], ours[:-1], theirs[:-1]
+    result = merge_sequences(ancestor, ours, theirs)
+    if result and is_ring:
+        result.append(result[0])
+    return result
+
+
+def merge_geometries(ancestor, ours, theirs):
+    """
+    Attempts a vertex-level three-way merge of a linestring or polygon geometry (or a multi-part geometry made of
+    these) that was edited on both sides of a merge. This succeeds when both sides moved, added or removed different
+    vertices, and the result is a valid geometry. Each geometry is a Geometry or None.
+
+    Returns the merged Geometry, or None if the edits can't be merged.
+    """
+    if ancestor is None or ours is None or theirs is None:
+        return None
+    if any(g.is_empty() for g in (ancestor, ours, theirs)):
+        return None
+
+    ogr_geoms = [g.to_ogr() for g in (ancestor, ours, theirs)]
+    if any(g.IsMeasured() for g in ogr_geoms):
+        return None
+    if len(set(g.GetCoordinateDimension() for g in ogr_geoms)) != 1:
+        return None
+    structure = _structure(ogr_geoms[0])
+    if structure is None or any(_structure(g) != structure for g in ogr_geoms[1:]):
+        return None
+
+    result = ogr_geoms[1].Clone()
+    is_3d = result.GetCoordinateDimension() == 3
+    sequences = zip(*(list(_vertex_sequences(g)) for g in ogr_geoms + [result]))
+    for a, o, t, r in sequences:
+        # OGR reports the type of polygon rings as LineString, but they do have their own name.
+        is_ring = r.GetGeometryName() == "LINEARRING"
+        merged = _merge_vertices(a.GetPoints(), o.GetPoints(), t.GetPoints(), is_ring)
+        if merged is None:
+            return None
+        r.Empty()
+        for point in merged:
+            if is_3d:
+                r.AddPoint(*point)
+            else:
+                r.AddPoint_2D(*point)
+
+    if not result.IsValid():
+        L.debug("Vertex-level merge produced an invalid geometry: %s", result)
+        return None
+    return Geometry.of(ogr_to_gpkg_geom(result)).with_crs_id(ours.crs_id)
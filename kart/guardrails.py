@@ -0,0 +1,307 @@
+import logging
+import os
+import sys
+
+import click
+import pygit2
+
+from kart import diff_util
+from kart.cli_util import KartCommand, KartGroup
+from kart.exceptions import InvalidOperation
+from kart.output_util import InputMode, get_input_mode
+
+L = logging.getLogger("kart.guardrails")
+
+# Thresholds for how much of a dataset an operation may delete from the working copy before the user has to confirm
+# it. Neither is set by default - eg `kart config kart.guardrails.maxDeletedPercent 30`.
+MAX_DELETED_PERCENT_KEY = "kart.guardrails.maxDeletedPercent"
+MAX_DELETED_FEATURES_KEY = "kart.guardrails.maxDeletedFeatures"
+
+# Thresholds for how large a change can be pushed or merged without --allow-large-changes - eg
+# `kart config kart.guardrails.push.maxDeletedFeatures 10000` or `kart config kart.guardrails.push.maxGeometryShift 100`
+# (in metres). These are checked by `kart push` and `kart merge`, and by the server, if it has the pre-receive hook
+# installed by `kart guardrails install-hook`.
+MAX_PUSH_DELETED_FEATURES_KEY = "kart.guardrails.push.maxDeletedFeatures"
+MAX_PUSH_GEOMETRY_SHIFT_KEY = "kart.guardrails.push.maxGeometryShift"
+
+FLOAT_THRESHOLD_KEYS = (MAX_DELETED_PERCENT_KEY, MAX_PUSH_GEOMETRY_SHIFT_KEY)
+
+# Pushing with `git push -o kart.allowLargeChanges` skips the server-side check.
+ALLOW_LARGE_CHANGES_PUSH_OPTION = "kart.allowLargeChanges"
+
+PRE_RECEIVE_HOOK = """#!/bin/sh
+# Installed by `kart guardrails install-hook`
+exec kart guardrails pre-receive
+"""
+
+# How many of the features that moved too far are listed individually.
+MAX_LISTED_SHIFTS = 10
+
+
+def _get_threshold(repo, key):
+    value = repo.get_config_str(key)
+    if value is None:
+        return None
+    try:
+        return float(value) if key in FLOAT_THRESHOLD_KEYS else int(value)
+    except ValueError:
+        raise InvalidOperation(f"Invalid {key} in config: {value!r}")
+
+
+def _count_deleted_features(old_ds, new_ds):
+    if new_ds is None:
+        return old_ds.feature_count
+    if old_ds.feature_tree.id == new_ds.feature_tree.id:
+        return 0
+    diff = old_ds.feature_tree.diff_to_tree(new_ds.feature_tree)
+    return sum(1 for d in diff.deltas if d.status == pygit2.GIT_DELTA_DELETED)
+
+
+def find_excessive_deletions(repo, old_tree, new_tree):
+    """
+    Yields (ds_path, deleted_count, feature_count) for each table dataset that would lose more features than the
+    configured thresholds allow, if the working copy was changed from old_tree to new_tree.
+    """
+    from kart.tabular.table_dataset import TableDataset
+
+    max_percent = _get_threshold(repo, MAX_DELETED_PERCENT_KEY)
+    max_features = _get_threshold(repo, MAX_DELETED_FEATURES_KEY)
+    if max_percent is None and max_features is None:
+        return
+    if old_tree is None or new_tree is None or old_tree.id == new_tree.id:
+        return
+
+    new_datasets = repo.structure(new_tree).datasets()
+    for old_ds in repo.structure(old_tree).datasets():
+        if not isinstance(old_ds, TableDataset):
+            continue
+        feature_count = old_ds.feature_count
+        if not feature_count:
+            continue
+        deleted = _count_deleted_features(old_ds, new_datasets.get(old_ds.path))
+        if (max_features is not None and deleted > max_features) or (
+            max_percent is not None and deleted * 100 / feature_count > max_percent
+        ):
+            yield old_ds.path, deleted, feature_count
+
+
+def check_deletion_guardrails(repo, target, yes=False, action="This"):
+    """
+    Checks that changing the working copy from HEAD to target - a commit or tree - wouldn't delete more features than
+    the thresholds configured with kart.guardrails.* allow. If it would, the user is asked to confirm, or if Kart
+    isn't being run interactively, InvalidOperation is raised. Specify yes=True to skip the check.
+    """
+    if yes or repo.head_is_unborn or not repo.working_copy.exists():
+        return
+    new_tree = target.peel(pygit2.Tree) if target is not None else None
+    excessive = list(find_excessive_deletions(repo, repo.head_tree, new_tree))
+    if not excessive:
+        return
+
+    desc = "\n".join(
+        f"  {ds_path}: {deleted} of {count} features ({deleted * 100 / count:.0f}%)"
+        for ds_path, deleted, count in excessive
+    )
+    message = (
+        f"{action} would delete more features from the working copy than the configured guardrails allow "
+        f"({MAX_DELETED_PERCENT_KEY} / {MAX_DELETED_FEATURES_KEY}):\n{desc}"
+    )
+    if get_input_mode() is InputMode.INTERACTIVE:
+        click.echo(message)
+        if click.confirm("Continue anyway?", default=False):
+            return
+        raise InvalidOperation("Aborted")
+    raise InvalidOperation(f"{message}\nUse --yes to continue anyway.")
+
+
+def _find_excessive_shifts(ds_path, ds_diff, old_ds, max_shift):
+    from kart.tabular.geodesic import GeodesicMeasurer
+
+    if old_ds is None or not old_ds.has_geometry:
+        return
+    measurer = GeodesicMeasurer.for_dataset(old_ds)
+    if measurer is None:
+        return
+    geom_column = old_ds.geom_column_name
+    for key, delta in ds_diff["feature"].sorted_items():
+        if delta.type != "update":
+            continue
+        old_geom = delta.old_value.get(geom_column)
+        new_geom = delta.new_value.get(geom_column)
+        if old_geom == new_geom:
+            continue
+        shift = measurer.centroid_distance(old_geom, new_geom)
+        if shift is not None and shift > max_shift:
+            yield ds_path, delta.old_key, shift
+
+
+def find_excessive_changes(repo, old_tree, new_tree):
+    """
+    Yields a line describing each way in which changing old_tree to new_tree - by pushing or merging - would exceed
+    the thresholds configured with kart.guardrails.push.*: deleting too many features, or moving any feature too far.
+    """
+    from kart.tabular.geodesic import format_measurement
+
+    max_deleted = _get_threshold(repo, MAX_PUSH_DELETED_FEATURES_KEY)
+    max_shift = _get_threshold(repo, MAX_PUSH_GEOMETRY_SHIFT_KEY)
+    if max_deleted is None and max_shift is None:
+        return
+    if old_tree is None or new_tree is None or old_tree.id == new_tree.id:
+        return
+
+    old_rs = repo.structure(old_tree)
+    repo_diff = diff_util.get_repo_diff(old_rs, repo.structure(new_tree))
+    old_datasets = old_rs.datasets()
+
+    deleted = 0
+    shifts = []
+    for ds_path, ds_diff in sorted(repo_diff.items()):
+        if "feature" not in ds_diff:
+            continue
+        if max_deleted is not None:
+            deleted += sum(
+                1 for delta in ds_diff["feature"].values() if delta.type == "delete"
+            )
+        if max_shift is not None:
+            shifts.extend(
+                _find_excessive_shifts(
+                    ds_path, ds_diff, old_datasets.get(ds_path), max_shift
+                )
+            )
+
+    if max_deleted is not None and deleted > max_deleted:
+        yield f"{deleted} features would be deleted ({MAX_PUSH_DELETED_FEATURES_KEY} is {max_deleted})"
+    for ds_path, pk, shift in shifts[:MAX_LISTED_SHIFTS]:
+        yield (
+            f"{ds_path}:feature:{pk} would move {format_measurement('length', shift)} "
+            f"({MAX_PUSH_GEOMETRY_SHIFT_KEY} is {max_shift:g} m)"
+        )
+    if len(shifts) > MAX_LISTED_SHIFTS:
+        yield f"... and {len(shifts) - MAX_LISTED_SHIFTS} more features would move too far"
+
+
+def check_change_size_guardrails(
+    repo, old_tree, new_tree, action="push", override_hint=None
+):
+    """
+    Raises an InvalidOperation if changing old_tree to new_tree would exceed the thresholds configured with
+    kart.guardrails.push.* - so that bad bulk edits are caught before they are pushed or merged.
+    """
+    excessive = list(find_excessive_changes(repo, old_tree, new_tree))
+    if not excessive:
+        return
+    desc = "\n".join(f"  {line}" for line in excessive)
+    if override_hint is None:
+        override_hint = f"Use --allow-large-changes to {action} anyway."
+    raise InvalidOperation(
+        f"Can't {action} - the changes are larger than the configured guardrails allow:\n{desc}\n{override_hint}"
+    )
+
+
+def check_push_change_size(repo, remote, args):
+    """
+    Checks the commits that `git push` with the given args would push - on every branch - against the thresholds
+    configured with kart.guardrails.push.*, comparing each pushed branch to the same branch on the remote, as it was
+    when last fetched. A branch that isn't on the remote yet is compared to where it diverged from the remote's default
+    branch - see kart.lock.push_base_commit.
+    """
+    from kart.lock import get_pushed_branches, push_base_commit
+
+    for commit, branch in get_pushed_branches(repo, remote, args):
+        base_commit = push_base_commit(repo, remote, commit, branch)
+        if base_commit is None:
+            # Nothing to compare it to, so nothing can be deleted or moved.
+            continue
+        check_change_size_guardrails(
+            repo, base_commit.tree, commit.tree, action="push"
+        )
+
+
+def _push_options():
+    count = int(os.environ.get("GIT_PUSH_OPTION_COUNT") or 0)
+    return {os.environ.get(f"GIT_PUSH_OPTION_{i}") for i in range(count)}
+
+
+@click.group(cls=KartGroup)
+@click.pass_context
+def guardrails(ctx, **kwargs):
+    """
+    Guardrails that catch bad bulk edits before they are shared.
+
+    Configure the largest change that can be pushed or merged without --allow-large-changes - eg
+    `kart config kart.guardrails.push.maxDeletedFeatures 10000` or
+    `kart config kart.guardrails.push.maxGeometryShift 100` (in metres). `kart push` and `kart merge` check these
+    before changing anything. Install the pre-receive hook in a repository that others push to, to check them there
+    as well.
+    """
+
+
+@guardrails.command("install-hook", cls=KartCommand, writes_to_repo=True)
+@click.pass_context
+@click.option(
+    "--force",
+    is_flag=True,
+    help="Replace any existing pre-receive hook.",
+)
+def install_hook(ctx, force):
+    """
+    Install a pre-receive hook that rejects pushes to this repository that exceed the guardrails in its config, or
+    that break the rules of its protected branches.
+
+    The hook runs `kart guardrails pre-receive`, so Kart must be on the PATH of the user that receives pushes. Users
+    can push a change that exceeds the guardrails anyway with `kart push -o kart.allowLargeChanges`.
+    """
+    repo = ctx.obj.repo
+    hook_path = repo.gitdir_path / "hooks" / "pre-receive"
+    if hook_path.exists() and not force:
+        if hook_path.read_text() == PRE_RECEIVE_HOOK:
+            click.echo("The guardrails pre-receive hook is already installed")
+            return
+        raise InvalidOperation(
+            f"{hook_path} already exists - use --force to replace it"
+        )
+    hook_path.parent.mkdir(parents=True, exist_ok=True)
+    hook_path.write_text(PRE_RECEIVE_HOOK)
+    hook_path.chmod(0o755)
+    # So that clients can send the kart.allowLargeChanges push option.
+    repo.config["receive.advertisePushOptions"] = True
+    click.echo(f"Installed pre-receive hook at {hook_path}")
+
+
+@guardrails.command("pre-receive", cls=KartCommand)
+@click.pass_context
+def pre_receive(ctx):
+    """
+    Check the ref updates of an incoming push against the guardrails - run by the hook that `install-hook` installs.
+
+    Reads "OLD NEW REF" lines from stdin, as git's pre-receive hook does. Updates to protected branches are checked
+    against their rules - see `kart protect`. Updates to existing branches are checked against the change-size
+    guardrails, since new branches have nothing to compare to.
+    """
+    from kart.protect import check_protected_ref_update
+
+    repo = ctx.obj.repo
+    check_size = ALLOW_LARGE_CHANGES_PUSH_OPTION not in _push_options()
+
+    # Until the push is accepted, the objects being pushed are kept in a quarantine directory - see git-receive-pack.
+    quarantine_path = os.environ.get("GIT_QUARANTINE_PATH")
+    if quarantine_path:
+        repo.odb.add_disk_alternate(quarantine_path)
+
+    # Read every update first, since required checks that are run along the way mustn't consume stdin.
+    updates = [line.split() for line in sys.stdin if line.strip()]
+    for old_id, new_id, ref_name in updates:
+        old_id = None if set(old_id) == {"0"} else old_id
+        new_id = None if set(new_id) == {"0"} else new_id
+        check_protected_ref_update(repo, ref_name, old_id, new_id)
+        if not check_size or not ref_name.startswith("refs/heads/"):
+            continue
+        if old_id is None or new_id is None:
+            continue
+        check_change_size_guardrails(
+            repo,
+            repo[old_id].peel(pygit2.Tree),
+            repo[new_id].peel(pygit2.Tree),
+            action=f"update {ref_name}",
+            override_hint=f"Use `kart push -o {ALLOW_LARGE_CHANGES_PUSH_OPTION}` to push anyway.",
+        )
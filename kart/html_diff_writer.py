@@ -18,9 +18,12 @@ from .output_util import ExtendedJsonEncoder, resolve_output_path
 class HtmlDiffWriter(BaseDiffWriter):
     """
     Writes a file usually called DIFF.html (the default name), which contains both a GeoJSON viewer, and the diff itself
-    in GeoJSON. Automatically opens the created file using webbrowser if the created file is not stdout.
+    in GeoJSON. Automatically opens the created file using webbrowser if the created file is not stdout, unless
+    open_in_browser is set to False.
     """
 
+    open_in_browser = True
+
     @classmethod
     def _check_output_path(cls, repo, output_path):
         if isinstance(output_path, Path) and output_path.is_dir():
@@ -61,7 +64,8 @@ class HtmlDiffWriter(BaseDiffWriter):
 
         if fo != sys.stdout:
             fo.close()
-            webbrowser.open_new(f"file://{self.output_path.resolve()}")
+            if self.open_in_browser:
+                webbrowser.open_new(f"file://{self.output_path.resolve()}")
 
         self.write_warnings_footer()
 
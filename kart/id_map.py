@@ -0,0 +1,141 @@
+import sys
+
+import click
+import pygit2
+import sqlalchemy as sa
+
+from kart.cli_util import KartCommand, KartGroup
+from kart.completion_shared import ref_completer
+from kart.output_util import dump_json_output
+from kart.pk_remap import PK_REMAP_NOTES_REF, get_pk_remaps
+from kart.structs import CommitWithReference
+
+# The column that a GPKG working copy adds as the primary key of a table, if the dataset's own primary key isn't a
+# single integer column - see KartAdapter_GPKG.v2_schema_to_sql_spec
+GPKG_AUTO_INT_PK = "auto_int_pk"
+
+
+def _has_int_pk(dataset):
+    pk_columns = dataset.schema.pk_columns
+    return len(pk_columns) == 1 and pk_columns[0].data_type == "integer"
+
+
+def merge_reassignments(repo, commit, ds_paths=()):
+    """
+    Yields an id-map row for each feature that was renumbered by a merge in the history of the given commit, oldest
+    first - see kart.pk_remap. Replaying them in order maps the primary keys that a system saw before each merge to
+    the primary keys that Kart uses now.
+    """
+    if PK_REMAP_NOTES_REF not in repo.references:
+        return
+    noted_ids = set(note.annotated_id for note in repo.notes(PK_REMAP_NOTES_REF))
+    walker = repo.walk(commit.id, pygit2.GIT_SORT_TOPOLOGICAL | pygit2.GIT_SORT_REVERSE)
+    for merge_commit in walker:
+        if merge_commit.id not in noted_ids:
+            continue
+        for remap in get_pk_remaps(repo, merge_commit):
+            if ds_paths and remap["dataset"] not in ds_paths:
+                continue
+            yield {
+                "dataset": remap["dataset"],
+                "source": "merge",
+                "pk": remap["newPk"],
+                "emittedPk": remap["oldPk"],
+                "commit": merge_commit.id.hex,
+            }
+
+
+def working_copy_keys(repo, dataset):
+    """
+    Yields an id-map row for each feature of the given dataset that the working copy identifies by a different key
+    to the dataset's primary key - which only happens in GPKG working copies, for datasets that don't have a single
+    integer primary key column.
+    """
+    table_wc = repo.working_copy.tabular
+    if table_wc is None or table_wc.WORKING_COPY_TYPE_NAME != "GPKG":
+        return
+    if _has_int_pk(dataset):
+        return
+
+    pk_names = [c.name for c in dataset.schema.pk_columns]
+    pk_sql = ", ".join(table_wc.quote(name) for name in pk_names)
+    with table_wc.session() as sess:
+        table_exists = sess.scalar(
+            sa.text(
+                "SELECT count(*) FROM sqlite_master WHERE type='table' AND name=:name;"
+            ),
+            {"name": dataset.table_name},
+        )
+        if not table_exists:
+            return
+        rows = sess.execute(
+            sa.text(
+                f"SELECT {GPKG_AUTO_INT_PK}, {pk_sql} "
+                f"FROM {table_wc.table_identifier(dataset)} ORDER BY {GPKG_AUTO_INT_PK};"
+            )
+        )
+        for wc_pk, *pk_values in rows:
+            yield {
+                "dataset": dataset.path,
+                "source": "workingcopy",
+                "pk": dataset.pk_value(dict(zip(pk_names, pk_values))),
+                "emittedPk": wc_pk,
+                "commit": repo.head_commit.id.hex,
+            }
+
+
+@click.group("id-map", cls=KartGroup)
+@click.pass_context
+def id_map(ctx, **kwargs):
+    """
+    Mappings between Kart's primary keys and the keys seen by other systems.
+
+    Features are identified by their primary key, but the key that another system saw can differ from the one Kart
+    has now - if the feature was renumbered during a merge to avoid a collision with a feature inserted on another
+    branch, or if it is in a GPKG working copy that had to give it an integer key.
+    """
+
+
+@id_map.command("export", cls=KartCommand)
+@click.pass_context
+@click.option(
+    "--output-format",
+    "-o",
+    type=click.Choice(["text", "json"]),
+    default="text",
+)
+@click.option(
+    "--ref",
+    default="HEAD",
+    shell_complete=ref_completer,
+    help="Export the mapping as at this commit. Working copy keys are only exported for HEAD.",
+)
+@click.argument("datasets", nargs=-1)
+def export(ctx, output_format, ref, datasets):
+    """
+    Export the mapping from each key that a feature was given elsewhere, to its primary key in Kart.
+
+    Each row has a source - "merge" for a feature that was renumbered by a merge (the emitted key is the primary key
+    it had on the renumbered branch), or "workingcopy" for a feature that the working copy identifies by a surrogate
+    key (the emitted key is that surrogate key). Exports to files that support integer feature IDs always use the
+    primary key as the feature ID, so they need no mapping.
+    """
+    repo = ctx.obj.repo
+    commit = CommitWithReference.resolve(repo, ref).commit
+    rows = list(merge_reassignments(repo, commit, datasets))
+    if commit.id == repo.head_commit.id:
+        for dataset in repo.datasets(commit.id.hex):
+            if dataset.DATASET_TYPE != "table":
+                continue
+            if datasets and dataset.path not in datasets:
+                continue
+            rows.extend(working_copy_keys(repo, dataset))
+
+    if output_format == "json":
+        dump_json_output({"kart.id-map/v1": rows}, sys.stdout)
+        return
+    for row in rows:
+        click.echo(
+            f"{row['dataset']}\t{row['source']}\t{row['emittedPk']}\t→\t{row['pk']}"
+            f"\t{row['commit'][:7]}"
+        )
@@ -0,0 +1,162 @@
+import os
+import re
+import sys
+
+import click
+import pygit2
+
+from kart.cli_util import KartCommand
+from kart.exceptions import NotFound
+from kart.output_util import dump_json_output
+from kart import subprocess_util as subprocess
+from kart.timestamps import tz_offset_to_minutes
+
+_GIT_VAR_OUTPUT_RE = re.compile(
+    r"^(?P<name>.*) <(?P<email>[^>]*)> (?P<time>\d+) (?P<offset>[+-]?\d+)$"
+)
+
+PERSON_TYPES = ("AUTHOR", "COMMITTER")
+
+
+def get_signature(person_type, cwd=None, **overrides):
+    """
+    Returns a pygit2.Signature for the given person_type - either "AUTHOR" or "COMMITTER".
+    The identity is resolved the same way as Git resolves it, in this order:
+    - environment variables eg GIT_AUTHOR_NAME, GIT_AUTHOR_EMAIL, GIT_AUTHOR_DATE
+    - the user.name and user.email settings from the repository config (if cwd is inside a repository)
+    - the user.name and user.email settings from the global config
+    - the EMAIL environment variable (email only)
+    - a default based on the system username and hostname.
+    Any of name, email, time, or offset can be supplied as overrides.
+    """
+    # 'git var' lets us use the environment variables to
+    # control the user info, e.g. GIT_AUTHOR_DATE.
+    # libgit2/pygit2 doesn't handle those env vars at all :(
+    env_overrides = {}
+
+    name = overrides.pop("name", None)
+    if name is not None:
+        env_overrides[f"GIT_{person_type}_NAME"] = name
+
+    email = overrides.pop("email", None)
+    if email is not None:
+        env_overrides[f"GIT_{person_type}_EMAIL"] = email
+
+    output = subprocess.check_output(
+        ["git", "var", f"GIT_{person_type}_IDENT"],
+        cwd=cwd,
+        encoding="utf8",
+        env_overrides=env_overrides,
+    )
+    m = _GIT_VAR_OUTPUT_RE.match(output)
+    kwargs = m.groupdict()
+    kwargs["time"] = int(kwargs["time"])
+    kwargs["offset"] = tz_offset_to_minutes(kwargs["offset"])
+    kwargs.update(overrides)
+    return pygit2.Signature(**kwargs)
+
+
+_CONFIG_LEVEL_NAMES = {
+    pygit2.GIT_CONFIG_LEVEL_PROGRAMDATA: "system",
+    pygit2.GIT_CONFIG_LEVEL_SYSTEM: "system",
+    pygit2.GIT_CONFIG_LEVEL_XDG: "global",
+    pygit2.GIT_CONFIG_LEVEL_GLOBAL: "global",
+    pygit2.GIT_CONFIG_LEVEL_LOCAL: "repository",
+    pygit2.GIT_CONFIG_LEVEL_APP: "application",
+}
+
+
+def _config_source(config, key):
+    """Returns which config file the given key is set in - eg "global" or "repository" - or None if it isn't set."""
+    level = None
+    for entry in config:
+        # Entries with a higher level take precedence.
+        if entry.name == key and (level is None or entry.level >= level):
+            level = entry.level
+    if level is None:
+        return None
+    return _CONFIG_LEVEL_NAMES.get(level, "unknown")
+
+
+def get_identity_source(person_type, attr, config):
+    """
+    Explains where the given attribute ("name" or "email") of the given person_type ("AUTHOR" or "COMMITTER")
+    comes from, following the same order of precedence as get_signature.
+    """
+    env_var = f"GIT_{person_type}_{attr.upper()}"
+    if env_var in os.environ:
+        return f"environment variable {env_var}"
+    config_source = _config_source(config, f"user.{attr}") if config else None
+    if config_source:
+        return f"{config_source} config user.{attr}"
+    if attr == "email" and "EMAIL" in os.environ:
+        return "environment variable EMAIL"
+    return "system default"
+
+
+def get_identity_json(repo=None):
+    if repo is not None:
+        config = repo.config
+        cwd = repo.path
+    else:
+        try:
+            config = pygit2.Config.get_global_config()
+        except IOError:
+            # there is no global config
+            config = None
+        cwd = None
+
+    result = {}
+    for person_type in PERSON_TYPES:
+        signature = get_signature(person_type, cwd=cwd)
+        result[person_type.lower()] = {
+            "name": signature.name,
+            "email": signature.email,
+            "nameSource": get_identity_source(person_type, "name", config),
+            "emailSource": get_identity_source(person_type, "email", config),
+        }
+    return result
+
+
+def identity_to_text(jdict):
+    lines = []
+    for person_type in PERSON_TYPES:
+        person = jdict[person_type.lower()]
+        lines.append(
+            f"{person_type.capitalize() + ':':<11}{person['name']} <{person['email']}>"
+        )
+        lines.append(f"  name from {person['nameSource']}")
+        lines.append(f"  email from {person['emailSource']}")
+    return "\n".join(lines)
+
+
+@click.command(cls=KartCommand)
+@click.pass_context
+@click.option(
+    "--output-format",
+    "-o",
+    type=click.Choice(["text", "json"]),
+    default="text",
+)
+def whoami(ctx, output_format):
+    """
+    Show the identity that Kart records as the author and committer of new commits.
+
+    The identity is configured using "kart config user.name" and "kart config user.email" - add --global to set it
+    for all repositories, or omit it to set it only for the current repository. The environment variables
+    GIT_AUTHOR_NAME, GIT_AUTHOR_EMAIL, GIT_COMMITTER_NAME and GIT_COMMITTER_EMAIL override the config.
+    The same identity is used by every Kart command that creates commits, including commit, import and merge.
+    """
+    from kart.repo import KartRepoState
+
+    try:
+        repo = ctx.obj.get_repo(allowed_states=KartRepoState.ALL_STATES)
+    except NotFound:
+        # Outside a repository, only the global config and environment variables apply.
+        repo = None
+
+    jdict = get_identity_json(repo)
+    if output_format == "json":
+        dump_json_output({"kart.whoami/v1": jdict}, sys.stdout)
+    else:
+        click.echo(identity_to_text(jdict))
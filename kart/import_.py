@@ -9,6 +9,24 @@ from kart.cli_util import (
 )
 from kart.completion_shared import file_path_completer
 from kart.import_sources import from_spec, suggest_specs, ImportType
+from kart.remote_source import (
+    is_remote_or_zipped,
+    resolve_source,
+    source_cache_root,
+)
+
+
+def resolve_remote_sources(ctx, args):
+    """
+    Downloads or extracts any of the given args that are URLs or zip archive members, replacing them with local
+    paths - both in the returned list, and in the arguments that are forwarded to the specific import command.
+    """
+    cache_root = source_cache_root(ctx.obj.repo)
+    resolved = {
+        a: resolve_source(a, cache_root) for a in args if is_remote_or_zipped(a)
+    }
+    ctx.unparsed_args = [resolved.get(a, a) for a in ctx.unparsed_args]
+    return [resolved.get(a, a) for a in args]
 
 
 def list_import_formats(ctx):
@@ -29,6 +47,7 @@ def list_import_formats(ctx):
     "import",
     cls=KartCommand,
     context_settings=dict(ignore_unknown_options=True),
+    writes_to_repo=True,
 )
 @click.pass_context
 @click.option(
@@ -47,6 +66,22 @@ def list_import_formats(ctx):
     is_flag=True,
     help="Replace existing dataset(s) of the same name.",
 )
+@click.option(
+    "--skip-unchanged",
+    is_flag=True,
+    help=(
+        "Don't re-import datasets that haven't changed since they were last imported, and don't make a commit if "
+        "nothing has changed at all. Implies --replace-existing. Useful for scheduled imports of the same source."
+    ),
+)
+@click.option(
+    "--if-source-changed",
+    is_flag=True,
+    help=(
+        "Don't import anything if a source with the same SHA-256 has already been imported to the same datasets, "
+        "in the history of HEAD. Useful for jobs that may be retried. Only supported for file-based sources."
+    ),
+)
 @click.option(
     "--checkout/--no-checkout",
     "do_checkout",
@@ -127,6 +162,14 @@ def import_(ctx, args, **kwargs):
     $ kart import --dataset=my_points_clouds my_point_clouds/*.laz
 
     Note that --dataset can be ommitted if a reasonable name can be inferred from the sources.
+
+    \b
+    Sources can also be downloaded, or extracted from a zip archive, before they are imported:
+    $ kart import https://example.com/open-data/my_data.gpkg
+    $ kart import my_archive.zip!my_data.gpkg
+
+    Downloaded and extracted sources are cached in the repository. A source is only downloaded again if the
+    server reports that it has changed since it was last downloaded.
     """
 
     args = [a for a in args if not a.startswith("-")]
@@ -134,6 +177,9 @@ def import_(ctx, args, **kwargs):
         click.echo("At least one SOURCE is required for kart import.", err=True)
         raise click.MissingParameter(param=find_param(ctx, "args"))
 
+    if any(is_remote_or_zipped(a) for a in args):
+        args = resolve_remote_sources(ctx, args)
+
     for arg in args:
         import_source_type = from_spec(arg, allow_unrecognised=True)
         if import_source_type is not None:
@@ -148,6 +194,7 @@ def import_(ctx, args, **kwargs):
     if kwargs.get("do_link") and import_source_type.import_type in (
         ImportType.SQLALCHEMY_TABLE,
         ImportType.OGR_TABLE,
+        ImportType.EXT_TABLE,
     ):
         raise click.UsageError("--link is not supported for vector or tabular imports")
 
@@ -0,0 +1,143 @@
+import hashlib
+import json
+import logging
+from pathlib import Path
+
+from kart.remote_source import original_source_spec
+
+L = logging.getLogger("kart.import_provenance")
+
+# Provenance is recorded as a git note on each import commit, so that the commit message is left as-is.
+# It can be viewed with `kart git notes --ref=kart-import show COMMIT`
+PROVENANCE_NOTES_REF = "refs/notes/kart-import"
+
+# Prefixes that can be added to a local path to specify how it should be imported - eg GPKG:my_data.gpkg
+LOCAL_SOURCE_PREFIXES = ("GPKG:", "OSM:", "OGR:", "FGDB:")
+
+# Shapefiles are made up of several files, all of which affect what is imported.
+SHAPEFILE_SUFFIXES = (".shp", ".shx", ".dbf", ".prj", ".cpg")
+
+
+def _local_source_path(source_spec):
+    spec = str(source_spec)
+    for prefix in LOCAL_SOURCE_PREFIXES:
+        if spec.startswith(prefix):
+            spec = spec[len(prefix) :]
+            break
+    path = Path(spec).expanduser()
+    return path if path.exists() else None
+
+
+def _source_files(path):
+    if path.is_dir():
+        return sorted(p for p in path.rglob("*") if p.is_file())
+    if path.suffix.lower() in SHAPEFILE_SUFFIXES:
+        return sorted(
+            p
+            for p in path.parent.glob(f"{path.stem}.*")
+            if p.suffix.lower() in SHAPEFILE_SUFFIXES
+        )
+    return [path]
+
+
+def source_sha256(source_spec):
+    """
+    Returns the SHA-256 of the given import source, if it is a local file or directory - or None if it is not,
+    eg if it is a database. The names of the files are hashed too, for sources that are made up of several files.
+    """
+    path = _local_source_path(source_spec)
+    if path is None:
+        return None
+    files = _source_files(path)
+    sha256 = hashlib.sha256()
+    for file in files:
+        if len(files) > 1:
+            sha256.update(str(file.relative_to(path.parent)).encode("utf-8") + b"\0")
+        with open(file, "rb") as f:
+            for chunk in iter(lambda: f.read(1024 * 1024), b""):
+                sha256.update(chunk)
+    return sha256.hexdigest()
+
+
+def source_uri(source_spec):
+    """
+    Returns the given import source as it should be recorded - the URL it was downloaded from, if it was downloaded,
+    and without any password it contains.
+    """
+    from kart.sqlalchemy import strip_password
+
+    original_spec = original_source_spec(source_spec)
+    if original_spec != str(source_spec):
+        return strip_password(original_spec)
+    path = _local_source_path(source_spec)
+    if path is not None:
+        return str(path.resolve())
+    return strip_password(str(source_spec))
+
+
+def build_provenance(source_spec, ds_paths, options, *, sha256=None):
+    """
+    Returns a dict describing where an import came from: the source, its SHA-256, the datasets it was imported to,
+    the version of Kart that imported it, and the options it was imported with.
+    """
+    from kart.cli import get_version
+
+    return {
+        "source": source_uri(source_spec),
+        "sourceSha256": sha256 if sha256 is not None else source_sha256(source_spec),
+        "datasets": sorted(ds_paths),
+        "importerVersion": get_version(),
+        "options": {k: v for k, v in options.items() if v not in (None, False, ())},
+    }
+
+
+def record_provenance(repo, commit, provenance):
+    """Records the provenance of an import as a git note on the commit that was made by the import."""
+    repo.create_note(
+        json.dumps(provenance, indent=2),
+        repo.author_signature(),
+        repo.committer_signature(),
+        str(commit.id),
+        PROVENANCE_NOTES_REF,
+        True,
+    )
+
+
+def get_provenance(repo, commit):
+    """Returns the provenance recorded for the given import commit, or None if there is none."""
+    try:
+        note = repo.lookup_note(str(commit.id), PROVENANCE_NOTES_REF)
+    except KeyError:
+        return None
+    return json.loads(note.message)
+
+
+def find_previous_import(repo, sha256, ds_paths):
+    """
+    Returns the most recent commit in the history of HEAD which imported a source with the given SHA-256 to the
+    given datasets - or None if there isn't one.
+    """
+    if sha256 is None or repo.head_is_unborn:
+        return None
+    if PROVENANCE_NOTES_REF not in repo.references:
+        return None
+    ds_paths = sorted(ds_paths)
+    head_id = repo.head_commit.id
+    candidates = []
+    for note in repo.notes(PROVENANCE_NOTES_REF):
+        try:
+            provenance = json.loads(note.message)
+        except ValueError:
+            continue
+        if provenance.get("sourceSha256") != sha256:
+            continue
+        if provenance.get("datasets") != ds_paths:
+            continue
+        commit = repo.get(note.annotated_id)
+        if commit is None:
+            continue
+        if commit.id == head_id or repo.descendant_of(head_id, commit.id):
+            candidates.append(commit)
+    if not candidates:
+        return None
+    return max(candidates, key=lambda c: c.commit_time)
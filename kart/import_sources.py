@@ -15,12 +15,13 @@ class ImportType(Enum):
 
     SQLALCHEMY_TABLE = auto()
     OGR_TABLE = auto()
+    EXT_TABLE = auto()
     POINT_CLOUD = auto()
     RASTER = auto()
 
     @property
     def import_cmd(self):
-        if self in (self.SQLALCHEMY_TABLE, self.OGR_TABLE):
+        if self in (self.SQLALCHEMY_TABLE, self.OGR_TABLE, self.EXT_TABLE):
             from kart.tabular.import_ import table_import
 
             return table_import
@@ -43,6 +44,10 @@ class ImportType(Enum):
             from kart.tabular import OgrTableImportSource
 
             return OgrTableImportSource
+        elif self is self.EXT_TABLE:
+            from kart.tabular.ext_import_source import ExternalTableImportSource
+
+            return ExternalTableImportSource
 
 
 class ImportSourceType:
@@ -112,6 +117,12 @@ ALL_IMPORT_SOURCE_TYPES = [
         ImportType.SQLALCHEMY_TABLE,
         uri_scheme="mysql",
     ),
+    ImportSourceType(
+        "Oracle Spatial",
+        "oracle://HOST/SERVICE[/OWNER[/TABLE]]",
+        ImportType.SQLALCHEMY_TABLE,
+        uri_scheme="oracle",
+    ),
     # OGR tabular imports
     ImportSourceType(
         "ESRI Shapefile",
@@ -119,9 +130,31 @@ ALL_IMPORT_SOURCE_TYPES = [
         ImportType.OGR_TABLE,
         file_ext=(".shp", ".shx", ".dbf"),
     ),
+    ImportSourceType(
+        "ESRI File Geodatabase",
+        "PATH.gdb",
+        ImportType.OGR_TABLE,
+        file_ext=".gdb",
+        optional_prefix="FGDB:",
+    ),
+    ImportSourceType(
+        "OpenStreetMap",
+        "PATH.osm.pbf or PATH.osm",
+        ImportType.OGR_TABLE,
+        file_ext=(".pbf", ".osm"),
+        optional_prefix="OSM:",
+    ),
     ImportSourceType(
         "OGR", "OGR:...", ImportType.OGR_TABLE, uri_scheme="OGR", hidden=True
     ),
+    # Tabular imports from external importers - see `kart load-ext`
+    ImportSourceType(
+        "External importer",
+        "EXT:COMMAND",
+        ImportType.EXT_TABLE,
+        uri_scheme="EXT",
+        hidden=True,
+    ),
     # Point cloud imports:
     ImportSourceType(
         "LAS (LASer)",
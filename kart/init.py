@@ -12,6 +12,7 @@ from .dataset_util import validate_dataset_paths
 from .exceptions import InvalidOperation
 from .fast_import import FastImportSettings, fast_import_tables
 from .repo import KartRepo, PotentialRepo
+from .repo_template import RepoTemplate, open_template
 from .spatial_filter import SpatialFilterString, spatial_filter_help_text
 from .tabular.import_source import TableImportSource
 from .working_copy import PartType
@@ -33,7 +34,10 @@ from .working_copy import PartType
     "do_checkout",
     is_flag=True,
     default=True,
-    help="Whether to immediately create a working copy with the initial import. Has no effect if --import is not set.",
+    help=(
+        "Whether to immediately create a working copy with the initial import. Has no effect if neither --import nor "
+        "a --template containing datasets is given."
+    ),
 )
 @click.option(
     "--message",
@@ -86,6 +90,14 @@ from .working_copy import PartType
     type=SpatialFilterString(encoding="utf-8", allow_reference=False),
     help=spatial_filter_help_text(allow_reference=False),
 )
+@click.option(
+    "--template",
+    help=(
+        "A template directory - or the URL of a git repository containing one - to set up the new repository from. "
+        "Its config, publish profiles, hooks and dataset schemas are copied to the new repository."
+    ),
+    shell_complete=file_path_completer,
+)
 def init(
     ctx,
     message,
@@ -98,10 +110,16 @@ def init(
     max_delta_depth,
     num_workers,
     spatial_filter_spec,
+    template,
 ):
     """
     Initialise a new repository and optionally import data.
     DIRECTORY must be empty. Defaults to the current directory.
+
+    Use --template so that new repositories are set up the same way every time. A template directory can contain:
+    a git-config file called config, whose kart.* settings - eg validation rules - are copied to the new repository;
+    profiles/NAME.json publish profiles; hooks/NAME git hooks; and datasets/PATH/ directories, each containing a
+    schema.json and any other meta items, which are committed as empty datasets.
     """
 
     if directory is None:
@@ -117,6 +135,13 @@ def init(
         wc_location, PotentialRepo(repo_path)
     )
 
+    repo_template = None
+    if template:
+        with open_template(template) as template_path:
+            repo_template = RepoTemplate(template_path)
+        if repo_template.datasets:
+            check_git_user(repo=None)
+
     if not repo_path.exists():
         repo_path.mkdir(parents=True)
 
@@ -139,19 +164,24 @@ def init(
         spatial_filter_spec=spatial_filter_spec,
     )
 
+    template_ds_paths = []
+    if repo_template:
+        repo_template.apply(repo)
+        template_ds_paths = list(repo_template.datasets)
+
     if import_from:
-        validate_dataset_paths([s.dest_path for s in sources])
+        validate_dataset_paths(template_ds_paths + [s.dest_path for s in sources])
         fast_import_tables(
             repo,
             sources,
             settings=FastImportSettings(max_delta_depth=max_delta_depth),
-            from_commit=None,
+            from_commit=repo.head_commit,
             message=message,
         )
-        if do_checkout:
-            repo.working_copy.reset_to_head(create_parts_if_missing=[PartType.TABULAR])
 
-    else:
+    if not (import_from or template_ds_paths):
         click.echo(
             f"Created an empty repository at {repo_path} — import some data with `kart import`"
         )
+    elif do_checkout:
+        repo.working_copy.reset_to_head(create_parts_if_missing=[PartType.TABULAR])
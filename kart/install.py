@@ -1,6 +1,10 @@
 import click
 
-from kart.completion import Shells, install_tab_completion
+from kart.completion import (
+    Shells,
+    get_tab_completion_script,
+    install_tab_completion,
+)
 from kart.cli_util import add_help_subcommand, KartGroup
 
 
@@ -19,11 +23,28 @@ def install(ctx, **kwargs):
     default="auto",
     help="Select a shell to install tab completion for. Defaults to auto for auto-detecting shell.",
 )
-def tab_completion(shell: str):
-    """Install tab completion for the specific or current shell"""
+@click.option(
+    "--print",
+    "do_print",
+    is_flag=True,
+    help="Print the completion script to stdout instead of installing it.",
+)
+def tab_completion(shell: str, do_print: bool):
+    """
+    Install tab completion for the specific or current shell. Commands, options, branches and dataset names are
+    completed.
+
+    \b
+    Examples:
+    $ kart install tab-completion
+    $ kart install tab-completion --shell=zsh --print > ~/.zfunc/_kart
+    """
     if shell == "auto":
-        shell, path = install_tab_completion()
-    else:
-        shell, path = install_tab_completion(shell=shell)
+        shell = None
+    if do_print:
+        _, script = get_tab_completion_script(shell=shell)
+        click.echo(script)
+        return
+    shell, path = install_tab_completion(shell=shell)
     click.secho(f"{shell} completion installed in {path}", fg="green")
     click.echo("Completion will take effect once you restart the terminal")
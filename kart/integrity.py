@@ -0,0 +1,166 @@
+import logging
+import sys
+from collections import namedtuple
+
+import click
+
+from kart.cli_util import KartCommand
+from kart.completion_shared import ref_completer
+from kart.exceptions import InvalidOperation, NotFound, NO_TABLE, SUCCESS_WITH_FLAG
+from kart.output_util import dump_json_output
+from kart.repo import KartRepoState
+
+L = logging.getLogger("kart.integrity")
+
+# Relationships between datasets are declared in the repository config, one per value of this multi-valued key,
+# eg `kart config --add kart.foreignKey "pipes.owner_id -> owners.id"`
+FOREIGN_KEY_CONFIG_KEY = "kart.foreignKey"
+
+# Merges don't list more violations than this when refusing to commit.
+MAX_VIOLATIONS_SHOWN = 10
+
+
+class ForeignKey(
+    namedtuple("ForeignKey", ("ds_path", "column", "ref_ds_path", "ref_column"))
+):
+    """
+    A relationship between two datasets: every non-NULL value of column in the dataset at ds_path must be present
+    in ref_column of the dataset at ref_ds_path.
+    """
+
+    @classmethod
+    def parse(cls, spec):
+        """Parses a foreign key declaration - eg "pipes.owner_id -> owners.id"."""
+        source, arrow, target = spec.partition("->")
+        # Dataset paths can contain dots, but column names rarely do.
+        ds_path, _, column = source.strip().rpartition(".")
+        ref_ds_path, _, ref_column = target.strip().rpartition(".")
+        if not (arrow and ds_path and column and ref_ds_path and ref_column):
+            raise InvalidOperation(
+                f"Invalid {FOREIGN_KEY_CONFIG_KEY} in config: {spec!r} - "
+                "expected DATASET.COLUMN -> DATASET.COLUMN"
+            )
+        return cls(ds_path, column, ref_ds_path, ref_column)
+
+    def __str__(self):
+        return f"{self.ds_path}.{self.column} -> {self.ref_ds_path}.{self.ref_column}"
+
+
+def get_foreign_keys(repo):
+    if FOREIGN_KEY_CONFIG_KEY not in repo.config:
+        return []
+    return [
+        ForeignKey.parse(spec)
+        for spec in repo.config.get_multivar(FOREIGN_KEY_CONFIG_KEY)
+    ]
+
+
+def _check_column(dataset, column):
+    if column not in [c.name for c in dataset.schema]:
+        raise NotFound(
+            f"Dataset {dataset.path} has no column {column}", exit_code=NO_TABLE
+        )
+
+
+def find_violations(rs, foreign_keys):
+    """
+    Yields a dict describing every feature in the given RepoStructure that references a feature that doesn't exist,
+    according to the given foreign keys.
+    """
+    datasets = rs.datasets()
+    for fk in foreign_keys:
+        dataset = datasets.get(fk.ds_path)
+        if dataset is None:
+            # No features, so nothing to violate the foreign key.
+            continue
+        _check_column(dataset, fk.column)
+
+        ref_dataset = datasets.get(fk.ref_ds_path)
+        ref_values = set()
+        if ref_dataset is not None:
+            _check_column(ref_dataset, fk.ref_column)
+            ref_values = set(f[fk.ref_column] for f in ref_dataset.features())
+
+        for feature in dataset.features():
+            value = feature[fk.column]
+            if value is not None and value not in ref_values:
+                yield {
+                    "foreignKey": str(fk),
+                    "dataset": fk.ds_path,
+                    "feature": dataset.pk_value(feature),
+                    "value": value,
+                }
+
+
+def violation_to_text(violation):
+    return (
+        f"{violation['dataset']}:feature:{violation['feature']} references missing value "
+        f"{violation['value']!r} ({violation['foreignKey']})"
+    )
+
+
+def check_merge_integrity(repo, merge_tree_id):
+    """Raises InvalidOperation if the result of a merge would break any of the repository's foreign keys."""
+    foreign_keys = get_foreign_keys(repo)
+    if not foreign_keys:
+        return
+    merged = repo.structure(repo[merge_tree_id])
+    violations = list(find_violations(merged, foreign_keys))
+    if violations:
+        desc = "\n".join(
+            violation_to_text(v) for v in violations[:MAX_VIOLATIONS_SHOWN]
+        )
+        if len(violations) > MAX_VIOLATIONS_SHOWN:
+            desc += f"\n... and {len(violations) - MAX_VIOLATIONS_SHOWN} more"
+        raise InvalidOperation(
+            f"Merging would break the referential integrity of the repository:\n{desc}\n"
+            "Use --no-integrity-check to merge anyway."
+        )
+
+
+@click.command(cls=KartCommand, name="check-integrity")
+@click.pass_context
+@click.option(
+    "--output-format",
+    "-o",
+    type=click.Choice(["text", "json"]),
+    default="text",
+)
+@click.argument(
+    "refish", default="HEAD", required=False, shell_complete=ref_completer
+)
+def check_integrity(ctx, output_format, refish):
+    """
+    Check that the relationships between datasets hold at the given commit - that every feature only references
+    features that exist. Relationships are declared in the repository config, eg:
+
+    kart config --add kart.foreignKey "pipes.owner_id -> owners.id"
+
+    Exits with code 1 if any features reference missing features.
+    """
+    repo = ctx.obj.get_repo(allowed_states=KartRepoState.ALL_STATES)
+    foreign_keys = get_foreign_keys(repo)
+    if not foreign_keys:
+        raise NotFound(
+            f"No relationships between datasets are declared - add them with `kart config --add {FOREIGN_KEY_CONFIG_KEY}`"
+        )
+
+    violations = list(find_violations(repo.structure(refish), foreign_keys))
+    if output_format == "json":
+        dump_json_output(
+            {
+                "kart.check-integrity/v1": {
+                    "foreignKeys": [str(fk) for fk in foreign_keys],
+                    "violations": violations,
+                }
+            },
+            sys.stdout,
+        )
+    else:
+        for violation in violations:
+            click.echo(violation_to_text(violation))
+        click.echo(
+            f"Checked {len(foreign_keys)} relationship(s): {len(violations)} violation(s) found"
+        )
+    if violations:
+        ctx.exit(SUCCESS_WITH_FLAG)
@@ -0,0 +1,436 @@
+"""
+Describes a table dataset as ISO 19115 metadata, encoded as ISO 19139 XML - the format that most spatial data
+catalogues, such as GeoNetwork or CSW servers, expect for each dataset they list.
+See https://www.iso.org/standard/32557.html
+"""
+
+import xml.etree.ElementTree as ET
+from datetime import datetime, timezone
+from pathlib import Path
+
+import click
+import pygit2
+
+from kart.changelog import dataset_extent
+from kart.cli_util import KartCommand
+from kart.completion_shared import ref_completer, repo_path_completer
+from kart.crs_util import get_identifier_str, make_crs
+from kart.exceptions import NO_DATA, InvalidOperation, NotFound
+from kart.import_provenance import get_provenance
+from kart.lineage import lineage_graph
+from kart.release import get_releases
+from kart.stac import _wgs84_bbox
+from kart.structs import CommitWithReference
+from kart.tabular.table_dataset import TableDataset
+from kart.timestamps import datetime_to_iso8601_utc
+
+GMD_NS = "http://www.isotc211.org/2005/gmd"
+GCO_NS = "http://www.isotc211.org/2005/gco"
+CODE_LIST_URL = "http://standards.iso.org/iso/19139/resources/gmxCodelists.xml"
+
+ET.register_namespace("gmd", GMD_NS)
+ET.register_namespace("gco", GCO_NS)
+
+# The values of MD_TopicCategoryCode - ISO 19115 requires every dataset to have at least one.
+TOPIC_CATEGORIES = (
+    "farming",
+    "biota",
+    "boundaries",
+    "climatologyMeteorologyAtmosphere",
+    "economy",
+    "elevation",
+    "environment",
+    "geoscientificInformation",
+    "health",
+    "imageryBaseMapsEarthCover",
+    "intelligenceMilitary",
+    "inlandWaters",
+    "location",
+    "oceans",
+    "planningCadastre",
+    "society",
+    "structure",
+    "transportation",
+    "utilitiesCommunication",
+)
+
+# How many of the commits that changed the dataset are listed as process steps in its lineage, by default.
+DEFAULT_PROCESS_STEPS = 20
+
+
+def _gmd(parent, tag):
+    return ET.SubElement(parent, f"{{{GMD_NS}}}{tag}")
+
+
+def _gco(parent, tag, text):
+    elem = ET.SubElement(parent, f"{{{GCO_NS}}}{tag}")
+    elem.text = str(text)
+    return elem
+
+
+def _string(parent, tag, text):
+    return _gco(_gmd(parent, tag), "CharacterString", text)
+
+
+def _code(parent, tag, code_list, value):
+    elem = ET.SubElement(
+        _gmd(parent, tag),
+        f"{{{GMD_NS}}}{code_list}",
+        {"codeList": f"{CODE_LIST_URL}#{code_list}", "codeListValue": value},
+    )
+    elem.text = value
+    return elem
+
+
+def _commit_time(commit):
+    return datetime_to_iso8601_utc(
+        datetime.fromtimestamp(commit.commit_time, timezone.utc)
+    )
+
+
+def _responsible_party(parent, tag, signature, role):
+    party = _gmd(_gmd(parent, tag), "CI_ResponsibleParty")
+    _string(party, "individualName", signature.name)
+    if signature.email:
+        contact = _gmd(_gmd(party, "contactInfo"), "CI_Contact")
+        address = _gmd(_gmd(contact, "address"), "CI_Address")
+        _string(address, "electronicMailAddress", signature.email)
+    _code(party, "role", "CI_RoleCode", role)
+
+
+def _date(parent, date_time, date_type):
+    ci_date = _gmd(_gmd(parent, "date"), "CI_Date")
+    _gco(_gmd(ci_date, "date"), "DateTime", date_time)
+    _code(ci_date, "dateType", "CI_DateTypeCode", date_type)
+
+
+def _column_desc(column):
+    desc = f"{column.name} ({column.data_type}"
+    if column.data_type == "geometry":
+        desc += f" {column.get('geometryType', 'GEOMETRY')}"
+        if column.get("geometryCRS"):
+            desc += f", {column['geometryCRS']}"
+    if column.pk_index is not None:
+        desc += ", primary key"
+    return desc + ")"
+
+
+def dataset_changes(repo, ds_path, commit, limit):
+    """
+    Returns the most recent commits in the history of the given commit that changed the given dataset, newest first -
+    no more than limit of them.
+    """
+    result = []
+    walker = repo.walk(commit.id, pygit2.GIT_SORT_TOPOLOGICAL | pygit2.GIT_SORT_TIME)
+    for c in walker:
+        if len(result) >= limit:
+            break
+        tree_id = _dataset_tree_id(c, ds_path)
+        if tree_id is None:
+            # The dataset didn't exist before this.
+            break
+        if not c.parents or any(
+            _dataset_tree_id(p, ds_path) != tree_id for p in c.parents
+        ):
+            result.append(c)
+    return result
+
+
+def _dataset_tree_id(commit, ds_path):
+    try:
+        return (commit.peel(pygit2.Tree) / ds_path).id
+    except KeyError:
+        return None
+
+
+class Iso19139MetadataBuilder:
+    """
+    Builds an ISO 19139 MD_Metadata document describing a table dataset as it is at a particular commit, or as it was
+    in a particular release. The dataset's schema, extent, CRS, lineage and release details are all included.
+    """
+
+    def __init__(self, repo, dataset, commit, *, identifier=None, release=None):
+        self.repo = repo
+        self.dataset = dataset
+        self.commit = commit
+        self.identifier = identifier or dataset.path
+        self.release = release
+        self.root = ET.Element(f"{{{GMD_NS}}}MD_Metadata")
+
+    @property
+    def title(self):
+        return self.dataset.get_meta_item("title") or self.dataset.path
+
+    def build(self, *, topic_categories=(), extent=None, process_steps=0):
+        root = self.root
+        _string(root, "fileIdentifier", self.identifier)
+        _code(root, "language", "LanguageCode", "eng")
+        _code(root, "characterSet", "MD_CharacterSetCode", "utf8")
+        _code(root, "hierarchyLevel", "MD_ScopeCode", "dataset")
+        _responsible_party(root, "contact", self.commit.committer, "pointOfContact")
+        _gco(_gmd(root, "dateStamp"), "DateTime", _commit_time(self.commit))
+        _string(root, "metadataStandardName", "ISO 19115:2003/19139")
+        _string(root, "metadataStandardVersion", "1.0")
+        self.add_reference_systems()
+        self.add_identification(topic_categories, extent)
+        self.add_content()
+        self.add_distribution()
+        self.add_data_quality(process_steps)
+        return root
+
+    def add_reference_systems(self):
+        for definition in self.dataset.crs_definitions().values():
+            identifier = get_identifier_str(make_crs(definition))
+            ref_system = _gmd(
+                _gmd(self.root, "referenceSystemInfo"), "MD_ReferenceSystem"
+            )
+            rs_identifier = _gmd(
+                _gmd(ref_system, "referenceSystemIdentifier"), "RS_Identifier"
+            )
+            code_space, sep, code = identifier.partition(":")
+            _string(rs_identifier, "code", code if sep else identifier)
+            if sep:
+                _string(rs_identifier, "codeSpace", code_space)
+
+    def add_identification(self, topic_categories, extent):
+        ident = _gmd(_gmd(self.root, "identificationInfo"), "MD_DataIdentification")
+        citation = _gmd(_gmd(ident, "citation"), "CI_Citation")
+        _string(citation, "title", self.title)
+        _date(citation, _commit_time(self.commit), "revision")
+        if self.release:
+            _date(citation, self.release["created"], "publication")
+            _string(citation, "edition", self.release["version"])
+        md_identifier = _gmd(_gmd(citation, "identifier"), "MD_Identifier")
+        _string(md_identifier, "code", f"{self.dataset.path}@{self.commit.id.hex}")
+
+        _string(ident, "abstract", self.dataset.get_meta_item("description") or "")
+        _responsible_party(ident, "pointOfContact", self.commit.author, "author")
+        tags = self.dataset.get_meta_item("tags.json")
+        if tags:
+            keywords = _gmd(_gmd(ident, "descriptiveKeywords"), "MD_Keywords")
+            for tag in tags:
+                _string(keywords, "keyword", tag)
+        _code(
+            ident,
+            "spatialRepresentationType",
+            "MD_SpatialRepresentationTypeCode",
+            "vector" if self.dataset.schema.geometry_columns else "textTable",
+        )
+        _code(ident, "language", "LanguageCode", "eng")
+        _code(ident, "characterSet", "MD_CharacterSetCode", "utf8")
+        for topic_category in topic_categories:
+            _gco(_gmd(ident, "topicCategory"), "MD_TopicCategoryCode", topic_category)
+
+        bbox = self._wgs84_bbox(extent)
+        if bbox is not None:
+            ex_extent = _gmd(_gmd(ident, "extent"), "EX_Extent")
+            geo_bbox = _gmd(
+                _gmd(ex_extent, "geographicElement"), "EX_GeographicBoundingBox"
+            )
+            west, south, east, north = bbox
+            for tag, value in (
+                ("westBoundLongitude", west),
+                ("eastBoundLongitude", east),
+                ("southBoundLatitude", south),
+                ("northBoundLatitude", north),
+            ):
+                _gco(_gmd(geo_bbox, tag), "Decimal", value)
+
+        # ISO 19139 has nowhere to describe each attribute - that needs a separate ISO 19110 feature catalogue - so
+        # they are summarised here instead.
+        columns = ", ".join(_column_desc(c) for c in self.dataset.schema.columns)
+        _string(ident, "supplementalInformation", f"Attributes: {columns}")
+
+    def _wgs84_bbox(self, extent):
+        if not extent:
+            return None
+        crs_definitions = self.dataset.crs_definitions()
+        if not crs_definitions:
+            return None
+        return _wgs84_bbox(extent, next(iter(crs_definitions.values())))
+
+    def add_content(self):
+        catalogue = _gmd(
+            _gmd(self.root, "contentInfo"), "MD_FeatureCatalogueDescription"
+        )
+        _gco(_gmd(catalogue, "includedWithDataset"), "Boolean", "true")
+        table_name = TableDataset.dataset_path_to_table_name(self.dataset.path)
+        _gco(_gmd(catalogue, "featureTypes"), "LocalName", table_name)
+        citation = _gmd(_gmd(catalogue, "featureCatalogueCitation"), "CI_Citation")
+        _string(citation, "title", f"Schema of {self.title}")
+        _date(citation, _commit_time(self.commit), "revision")
+
+    def add_distribution(self):
+        artifacts = [
+            a
+            for a in (self.release or {}).get("artifacts", [])
+            if self.dataset.path in a["datasets"]
+        ]
+        if not artifacts:
+            return
+        distribution = _gmd(_gmd(self.root, "distributionInfo"), "MD_Distribution")
+        for artifact in artifacts:
+            md_format = _gmd(_gmd(distribution, "distributionFormat"), "MD_Format")
+            _string(md_format, "name", artifact["format"])
+            _gmd(md_format, "version").set(f"{{{GCO_NS}}}nilReason", "unknown")
+        transfer = _gmd(
+            _gmd(distribution, "transferOptions"), "MD_DigitalTransferOptions"
+        )
+        for artifact in artifacts:
+            online = _gmd(_gmd(transfer, "onLine"), "CI_OnlineResource")
+            url = ET.SubElement(_gmd(online, "linkage"), f"{{{GMD_NS}}}URL")
+            url.text = f"{self.release['version']}/{artifact['path']}"
+            _string(online, "name", artifact["path"])
+
+    def add_data_quality(self, process_steps):
+        quality = _gmd(_gmd(self.root, "dataQualityInfo"), "DQ_DataQuality")
+        scope = _gmd(_gmd(quality, "scope"), "DQ_Scope")
+        _code(scope, "level", "MD_ScopeCode", "dataset")
+        lineage = _gmd(_gmd(quality, "lineage"), "LI_Lineage")
+        _string(
+            lineage,
+            "statement",
+            f"Version controlled with Kart. Describes {self.dataset.path} as of commit {self.commit.id.hex}.",
+        )
+
+        sources = []
+        changes = dataset_changes(
+            self.repo, self.dataset.path, self.commit, process_steps
+        )
+        for commit in changes:
+            step = _gmd(_gmd(lineage, "processStep"), "LI_ProcessStep")
+            _string(step, "description", commit.message.strip().splitlines()[0])
+            _gco(_gmd(step, "dateTime"), "DateTime", _commit_time(commit))
+            _responsible_party(step, "processor", commit.author, "processor")
+            provenance = get_provenance(self.repo, commit)
+            if provenance and provenance.get("source"):
+                sources.append(f"Imported from {provenance['source']}")
+
+        nodes, edges = lineage_graph(self.repo, self.dataset.path, self.commit)
+        for derived, (source_ds_path, source_commit), recorded_in in edges:
+            if derived == nodes[0]:
+                sources.append(
+                    f"Derived from {source_ds_path} at commit {source_commit}"
+                )
+
+        for description in dict.fromkeys(sources):
+            source = _gmd(_gmd(lineage, "source"), "LI_Source")
+            _string(source, "description", description)
+
+    def to_xml(self):
+        ET.indent(self.root)
+        return ET.tostring(self.root, encoding="utf-8", xml_declaration=True)
+
+
+@click.command("export-metadata", cls=KartCommand)
+@click.pass_context
+@click.option(
+    "--format",
+    "metadata_format",
+    type=click.Choice(["iso19139"]),
+    default="iso19139",
+    show_default=True,
+    help="The metadata standard to export.",
+)
+@click.option(
+    "--ref",
+    default="HEAD",
+    show_default=True,
+    shell_complete=ref_completer,
+    help="Describe the dataset as it is at this commit.",
+)
+@click.option(
+    "--release",
+    "release_version",
+    metavar="VERSION",
+    help="Describe the dataset as it is in this release, including its version, date and distribution artifacts.",
+)
+@click.option(
+    "--identifier",
+    help="The identifier of the metadata record in the catalogue. Defaults to the dataset path.",
+)
+@click.option(
+    "--topic-category",
+    "topic_categories",
+    type=click.Choice(TOPIC_CATEGORIES),
+    multiple=True,
+    help="An ISO 19115 topic category for the dataset - catalogues generally require at least one.",
+)
+@click.option(
+    "--extent/--no-extent",
+    default=True,
+    help="Whether to include the extent of the dataset. Calculating the extent requires reading every feature.",
+)
+@click.option(
+    "--process-steps",
+    type=click.IntRange(min=0),
+    default=DEFAULT_PROCESS_STEPS,
+    show_default=True,
+    help="How many of the most recent commits that changed the dataset to list in its lineage.",
+)
+@click.option(
+    "--output",
+    type=click.Path(dir_okay=False, writable=True, path_type=Path),
+    help="Write the metadata to this file, rather than to stdout.",
+)
+@click.argument("ds_path", metavar="DATASET", shell_complete=repo_path_completer)
+def export_metadata(
+    ctx,
+    metadata_format,
+    ref,
+    release_version,
+    identifier,
+    topic_categories,
+    extent,
+    process_steps,
+    output,
+    ds_path,
+):
+    """
+    Export the metadata of a table dataset as an XML document, for publishing in a data catalogue.
+
+    The dataset's title, description, tags, schema, CRS, extent and history are all included - as well as its
+    version, release date and artifacts, if --release is given.
+
+    eg: kart export-metadata roads --release v1.2.0 --topic-category transportation --output roads.xml
+    """
+    repo = ctx.obj.repo
+    release = None
+    if release_version:
+        release = get_releases(repo).get(release_version)
+        if release is None:
+            raise NotFound(f"No release {release_version}", exit_code=NO_DATA)
+        ref = release["commit"]
+
+    commit = CommitWithReference.resolve(repo, ref).commit
+    dataset = repo.datasets(commit.id.hex).get(ds_path)
+    if dataset is None:
+        raise NotFound(
+            f"No dataset {ds_path} at commit {commit.short_id}", exit_code=NO_DATA
+        )
+    if dataset.DATASET_TYPE != "table":
+        raise InvalidOperation(
+            f"Can't export metadata for {ds_path} - it isn't a table dataset"
+        )
+
+    dataset_extent_value = None
+    if extent:
+        release_stats = (release or {}).get("datasets", {}).get(ds_path, {})
+        if "extent" in release_stats:
+            dataset_extent_value = release_stats["extent"]
+        else:
+            dataset_extent_value = dataset_extent(dataset)
+
+    builder = Iso19139MetadataBuilder(
+        repo, dataset, commit, identifier=identifier, release=release
+    )
+    builder.build(
+        topic_categories=topic_categories,
+        extent=dataset_extent_value,
+        process_steps=process_steps,
+    )
+    xml = builder.to_xml()
+    if output:
+        output.write_bytes(xml)
+    else:
+        click.echo(xml.decode("utf-8"))
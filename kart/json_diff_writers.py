@@ -121,6 +121,7 @@ class JsonDiffWriter(BaseDiffWriter):
             return
 
         old_transform, new_transform = self.get_geometry_transforms(ds_path, ds_diff)
+        measurers = self.get_geodesic_measurers(ds_path, ds_diff)
 
         for key, delta in self.filtered_dataset_deltas(ds_path, ds_diff):
             delta_as_json = {}
@@ -141,6 +142,11 @@ class JsonDiffWriter(BaseDiffWriter):
                 else:
                     key = "+"
                 delta_as_json[key] = feature
+
+            if any(measurers):
+                measurements = self.measure_delta(ds_path, delta, *measurers)
+                if measurements:
+                    delta_as_json["geodesic"] = measurements
             yield delta_as_json
 
 
@@ -347,6 +353,7 @@ class JsonLinesDiffWriter(BaseDiffWriter):
             return
 
         old_transform, new_transform = self.get_geometry_transforms(ds_path, ds_diff)
+        measurers = self.get_geodesic_measurers(ds_path, ds_diff)
 
         obj = {"type": item_type, "dataset": ds_path, "change": None}
 
@@ -362,6 +369,8 @@ class JsonLinesDiffWriter(BaseDiffWriter):
                     delta.new_value, delta.new_key, new_transform
                 )
             obj["change"] = change
+            if any(measurers):
+                obj["geodesic"] = self.measure_delta(ds_path, delta, *measurers)
             self.dump(obj)
 
     def write_file_diff(self, file_diff):
@@ -500,3 +509,83 @@ class GeojsonDiffWriter(BaseDiffWriter):
                     change_type,
                     new_transform,
                 )
+
+
+class GeojsonLinesDiffWriter(GeojsonDiffWriter):
+    """
+    Writes feature deltas as newline-delimited GeoJSON - one GeoJSON feature per line, for each changed feature.
+    Unlike GeojsonDiffWriter, each change is a single feature, so that web-based review tools can render it directly:
+
+        {
+            "type": "Feature",
+            "id": "dataset:feature:123",
+            "geometry": {new geometry - or old geometry, if the feature was deleted},
+            "properties": {"dataset": "dataset", "change": "update"},
+            "change": "insert" | "update" | "delete",
+            "old": {"geometry": {...}, "properties": {...}},  - not present for inserts
+            "new": {"geometry": {...}, "properties": {...}}   - not present for deletes
+        }
+
+    Features from every dataset are written to the same stream. Meta deltas aren't output at all.
+    """
+
+    @classmethod
+    def _check_output_path(cls, repo, output_path):
+        if isinstance(output_path, Path) and output_path.is_dir():
+            raise click.BadParameter(
+                "Directory is not valid for --output with -o geojson-lines",
+                param_hint="--output",
+            )
+        return output_path
+
+    def write_diff(self, diff_format=DiffFormat.FULL):
+        if diff_format != DiffFormat.FULL.value:
+            raise click.UsageError("GeoJSON format only supports full diffs")
+        separators = (",", ":") if self.json_style == "extracompact" else None
+        fp = resolve_output_path(self.output_path)
+
+        self.has_changes = False
+        for ds_path in self.all_ds_paths:
+            ds_diff = self.get_dataset_diff(ds_path)
+            if not ds_diff:
+                continue
+            self.has_changes = True
+            self._warn_about_any_non_feature_diffs(ds_path, ds_diff)
+            for feature in self.filtered_dataset_deltas_as_geojson_changes(
+                ds_path, ds_diff
+            ):
+                json.dump(feature, fp, separators=separators)
+                fp.write("\n")
+        self.write_warnings_footer()
+
+    def filtered_dataset_deltas_as_geojson_changes(self, ds_path, ds_diff):
+        if "feature" not in ds_diff:
+            return
+
+        old_transform, new_transform = self.get_geometry_transforms(ds_path, ds_diff)
+
+        for key, delta in self.filtered_dataset_deltas(ds_path, ds_diff):
+            old = new = None
+            if delta.old:
+                old = feature_as_geojson(
+                    delta.old_value, delta.old_key, geometry_transform=old_transform
+                )
+            if delta.new:
+                new = feature_as_geojson(
+                    delta.new_value, delta.new_key, geometry_transform=new_transform
+                )
+            current = new or old
+            feature = {
+                "type": "Feature",
+                "id": f"{ds_path}:feature:{current['id']}",
+                "geometry": current["geometry"],
+                "properties": {"dataset": ds_path, "change": delta.type},
+                "change": delta.type,
+            }
+            for side, value in (("old", old), ("new", new)):
+                if value is not None:
+                    feature[side] = {
+                        "geometry": value["geometry"],
+                        "properties": value["properties"],
+                    }
+            yield feature
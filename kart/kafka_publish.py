@@ -0,0 +1,308 @@
+import json
+import logging
+import sys
+import time
+import urllib.error
+import urllib.request
+
+import click
+
+from kart.changes import change_events, resolve_changes_range
+from kart.cli_util import KartCommand
+from kart.completion_shared import ref_completer
+from kart.exceptions import InvalidOperation, NotYetImplemented
+from kart.mirror import IntervalType
+
+L = logging.getLogger("kart.kafka_publish")
+
+# The last commit whose changes were published to each topic is kept in this ref namespace, so that each publish
+# carries on from where the last one finished.
+PUBLISHED_REF_PREFIX = "refs/published/kafka/"
+
+DEFAULT_TOPIC = "dataset-changes"
+
+# The JSON Schema of each message value - registered with the schema registry, if one is used, so that consumers can
+# validate and deserialise messages without knowing anything about Kart.
+VALUE_SCHEMA = {
+    "$schema": "http://json-schema.org/draft-07/schema#",
+    "title": "kart.changes/v1",
+    "type": "object",
+    "properties": {
+        "type": {"type": "string", "enum": ["feature", "meta"]},
+        "commit": {"type": "string"},
+        "commitTime": {"type": "string", "format": "date-time"},
+        "author": {"type": "string"},
+        "dataset": {"type": "string"},
+        "operation": {"type": "string", "enum": ["insert", "update", "delete"]},
+        "key": {},
+        "change": {
+            "type": "object",
+            "properties": {"-": {}, "+": {}},
+            "additionalProperties": False,
+        },
+    },
+    "required": ["type", "commit", "commitTime", "dataset", "change"],
+}
+
+# The first byte of every message framed in the Confluent wire format, which precedes the 4-byte schema ID.
+WIRE_FORMAT_MAGIC_BYTE = b"\x00"
+
+
+def import_confluent_kafka():
+    try:
+        import confluent_kafka
+    except ImportError:
+        raise NotYetImplemented(
+            "This build of Kart doesn't support publishing to Kafka - the confluent-kafka package is required"
+        )
+    return confluent_kafka
+
+
+def register_value_schema(registry_url, topic):
+    """
+    Registers VALUE_SCHEMA with the given schema registry, under the subject for the values of the given topic, and
+    returns its schema ID. Registering a schema that is already registered just returns its existing ID.
+    """
+    url = f"{registry_url.rstrip('/')}/subjects/{topic}-value/versions"
+    body = json.dumps({"schemaType": "JSON", "schema": json.dumps(VALUE_SCHEMA)})
+    request = urllib.request.Request(
+        url,
+        data=body.encode("utf-8"),
+        headers={"Content-Type": "application/vnd.schemaregistry.v1+json"},
+        method="POST",
+    )
+    try:
+        with urllib.request.urlopen(request) as response:
+            return json.load(response)["id"]
+    except urllib.error.HTTPError as e:
+        raise InvalidOperation(
+            f"The schema registry responded with HTTP {e.code}: {e.read().decode('utf-8', 'replace')}"
+        )
+    except urllib.error.URLError as e:
+        raise InvalidOperation(f"Couldn't connect to the schema registry: {e.reason}")
+
+
+def change_messages(events):
+    """
+    Turns the given change events - see kart.changes.change_events - into a sequence of (commit_hex, key, value)
+    tuples, one for each change. Each value is a complete description of one change, including the commit that made
+    it. Each key is the path of the dataset that was changed - so that all the changes to a dataset are published to
+    the same partition, and are consumed in the order that they were made.
+    """
+    commit = None
+    for event in events:
+        if event["type"] == "commit":
+            commit = event["value"]
+        elif event["type"] in ("feature", "meta"):
+            value = {
+                "type": event["type"],
+                "commit": commit["commit"],
+                "commitTime": commit["commitTime"],
+                "author": commit["authorEmail"],
+                **{k: v for k, v in event.items() if k not in ("type", "commit")},
+            }
+            yield commit["commit"], event["dataset"], value
+
+
+class KafkaChangePublisher:
+    """Publishes change messages to a Kafka topic, framed in the Confluent wire format if a schema ID is given."""
+
+    def __init__(self, brokers, topic, *, schema_id=None, config=None):
+        kafka = import_confluent_kafka()
+        self.topic = topic
+        self.schema_id = schema_id
+        self.producer = kafka.Producer(
+            {"bootstrap.servers": brokers, **(config or {})}
+        )
+        self.errors = []
+
+    def encode_value(self, value):
+        encoded = json.dumps(value).encode("utf-8")
+        if self.schema_id is None:
+            return encoded
+        return WIRE_FORMAT_MAGIC_BYTE + self.schema_id.to_bytes(4, "big") + encoded
+
+    def _on_delivery(self, error, message):
+        if error is not None:
+            self.errors.append(error)
+
+    def publish(self, key, value):
+        self.producer.produce(
+            self.topic,
+            key=key.encode("utf-8"),
+            value=self.encode_value(value),
+            on_delivery=self._on_delivery,
+        )
+        # Serves delivery callbacks, so that the producer's queue doesn't fill up.
+        self.producer.poll(0)
+
+    def flush(self):
+        """Waits until every message has been delivered - raising an InvalidOperation if any of them weren't."""
+        self.producer.flush()
+        if self.errors:
+            errors, self.errors = self.errors, []
+            raise InvalidOperation(
+                f"Couldn't publish {len(errors)} changes to Kafka topic {self.topic}: {errors[0]}"
+            )
+
+
+class DryRunPublisher:
+    """Writes change messages to stdout as JSON lines, rather than publishing them."""
+
+    def __init__(self, topic):
+        self.topic = topic
+
+    def publish(self, key, value):
+        json.dump({"topic": self.topic, "key": key, "value": value}, sys.stdout)
+        sys.stdout.write("\n")
+
+    def flush(self):
+        sys.stdout.flush()
+
+
+def publish_changes(repo, publisher, since, until, filters, *, update_ref=True):
+    """
+    Publishes every change made after since, up to until. Changes are published one commit at a time, and the
+    published ref for the topic is updated after each one - so if publishing fails part way through, it can carry on
+    from the last commit that was published in full. Returns a tuple (number of messages published, last commit).
+    """
+    ref_name = f"{PUBLISHED_REF_PREFIX}{publisher.topic}"
+    since_commit, until_commit = resolve_changes_range(repo, since, until)
+
+    def commit_published(commit_hex):
+        publisher.flush()
+        if update_ref:
+            repo.references.create(ref_name, commit_hex, force=True)
+
+    count = 0
+    current_commit = None
+    events = change_events(repo, since_commit, until_commit, filters)
+    for commit_hex, key, value in change_messages(events):
+        if current_commit is not None and commit_hex != current_commit:
+            commit_published(current_commit)
+        current_commit = commit_hex
+        publisher.publish(key, value)
+        count += 1
+    commit_published(until_commit.hex)
+    return count, until_commit.hex
+
+
+@click.command(cls=KartCommand)
+@click.pass_context
+@click.option(
+    "--kafka",
+    "brokers",
+    required=True,
+    metavar="BROKERS",
+    help="Comma-separated list of the Kafka brokers to connect to, as HOST:PORT.",
+)
+@click.option(
+    "--topic",
+    default=DEFAULT_TOPIC,
+    show_default=True,
+    help="The Kafka topic to publish changes to.",
+)
+@click.option(
+    "--since",
+    shell_complete=ref_completer,
+    help=(
+        "Publish the changes made after this commit. "
+        "Defaults to the last commit that was published to the topic."
+    ),
+)
+@click.option(
+    "--until",
+    default="HEAD",
+    show_default=True,
+    shell_complete=ref_completer,
+    help="Publish the changes made up to and including this commit.",
+)
+@click.option(
+    "--schema-registry",
+    metavar="URL",
+    help=(
+        "Register the schema of the messages with this Confluent-compatible schema registry, "
+        "and frame each message with the schema's ID."
+    ),
+)
+@click.option(
+    "--config",
+    "config_items",
+    multiple=True,
+    metavar="KEY=VALUE",
+    help="Extra Kafka producer configuration, eg --config=security.protocol=SSL. Can be given more than once.",
+)
+@click.option(
+    "--interval",
+    type=IntervalType(),
+    default=None,
+    help=(
+        "Keep running, and publish any new changes after this interval has passed - eg 30s, 5m, 1h. "
+        "If not specified, changes are published once."
+    ),
+)
+@click.option(
+    "--dry-run",
+    is_flag=True,
+    help="Write the messages to stdout, rather than publishing them.",
+)
+@click.argument("filters", nargs=-1, metavar="[FILTERS]...")
+def publish(
+    ctx,
+    brokers,
+    topic,
+    since,
+    until,
+    schema_registry,
+    config_items,
+    interval,
+    dry_run,
+    filters,
+):
+    """
+    Publish the feature changes made by each commit to a Kafka topic, for event-driven integrations.
+
+    Each feature or meta-item change is published as one message, in the order the changes were made. The message
+    key is the path of the changed dataset, and the value is the same as a change event from `kart changes`, plus
+    details of the commit that made the change. The last commit published to each topic is remembered, so next time
+    only newer changes are published. Use --interval to keep running on a server, publishing changes as they arrive.
+
+    FILTERS restrict the changes to particular datasets or features - see `kart diff --help`.
+    """
+    repo = ctx.obj.repo
+    if since is None:
+        ref_name = f"{PUBLISHED_REF_PREFIX}{topic}"
+        if ref_name not in repo.references:
+            raise click.UsageError(
+                f"Nothing has been published to {topic} yet - use --since to choose the first commit to publish"
+            )
+        since = repo.references[ref_name].target.hex
+
+    config = {}
+    for item in config_items:
+        if "=" not in item:
+            raise click.BadParameter(
+                f"Expected KEY=VALUE, got {item!r}", param_hint="--config"
+            )
+        key, value = item.split("=", 1)
+        config[key.strip()] = value.strip()
+
+    if dry_run:
+        publisher = DryRunPublisher(topic)
+    else:
+        schema_id = None
+        if schema_registry:
+            schema_id = register_value_schema(schema_registry, topic)
+        publisher = KafkaChangePublisher(
+            brokers, topic, schema_id=schema_id, config=config
+        )
+
+    while True:
+        count, since = publish_changes(
+            repo, publisher, since, until, filters, update_ref=not dry_run
+        )
+        if not dry_run:
+            click.echo(f"Published {count} changes to {topic}", err=True)
+        if interval is None:
+            break
+        time.sleep(interval)
@@ -0,0 +1,223 @@
+import json
+import sys
+
+import click
+import pygit2
+
+from kart.cli_util import KartCommand, KartGroup
+from kart.completion_shared import repo_path_completer
+from kart.exceptions import NO_DATA, NotFound
+from kart.output_util import dump_json_output
+from kart.structs import CommitWithReference
+
+# Lineage is recorded as a git note on each commit that made a derived dataset, listing the commits of the other
+# datasets that it was derived from. It can be viewed with `kart git notes --ref=kart-lineage show COMMIT`
+LINEAGE_NOTES_REF = "refs/notes/kart-lineage"
+
+
+class DerivedFromType(click.ParamType):
+    """Click parameter for a DATASET@COMMIT reference to a dataset as it was at a particular commit."""
+
+    name = "dataset@commit"
+
+    def convert(self, value, param, ctx):
+        ds_path, sep, commit = value.rpartition("@")
+        if not sep or not ds_path or not commit:
+            self.fail(f"Expected DATASET@COMMIT but got {value!r}", param, ctx)
+        return ds_path, commit
+
+
+def resolve_derived_from(repo, derived_from):
+    """
+    Given a list of (ds_path, commit-ish) pairs, checks that each dataset exists at the given commit, and returns
+    them as a list of {"dataset": ds_path, "commit": commit_hex} dicts, as they are recorded.
+    """
+    result = []
+    for ds_path, refish in derived_from:
+        commit = CommitWithReference.resolve(repo, refish).commit
+        if ds_path not in repo.datasets(commit.id.hex):
+            raise NotFound(
+                f"No dataset {ds_path} at commit {commit.short_id}", exit_code=NO_DATA
+            )
+        source = {"dataset": ds_path, "commit": commit.id.hex}
+        if source not in result:
+            result.append(source)
+    return result
+
+
+def record_lineage(repo, commit, ds_paths, derived_from):
+    """
+    Records that the given datasets, as changed by the given commit, were derived from the given sources - a list of
+    {"dataset": ds_path, "commit": commit_hex} dicts as returned by resolve_derived_from.
+    """
+    if not derived_from:
+        return
+    lineage = {"datasets": sorted(ds_paths), "derivedFrom": derived_from}
+    repo.create_note(
+        json.dumps(lineage, indent=2),
+        repo.author_signature(),
+        repo.committer_signature(),
+        str(commit.id),
+        LINEAGE_NOTES_REF,
+        True,
+    )
+
+
+def get_lineage(repo, commit):
+    """Returns the lineage recorded for the given commit, or None if there is none."""
+    try:
+        note = repo.lookup_note(str(commit.id), LINEAGE_NOTES_REF)
+    except KeyError:
+        return None
+    return json.loads(note.message)
+
+
+def _all_lineage(repo):
+    """Returns a list of (commit, lineage) for every commit in the repo that has lineage recorded."""
+    if LINEAGE_NOTES_REF not in repo.references:
+        return []
+    result = []
+    for note in repo.notes(LINEAGE_NOTES_REF):
+        try:
+            lineage = json.loads(note.message)
+        except ValueError:
+            continue
+        commit = repo.get(note.annotated_id)
+        if commit is None:
+            continue
+        result.append((commit, lineage))
+    return result
+
+
+def lineage_graph(repo, ds_path, commit):
+    """
+    Returns the provenance DAG of the given dataset as it was at the given commit, as a tuple (nodes, edges).
+    Each node is a (ds_path, commit_hex) tuple. Each edge is a (derived_node, source_node, recorded_in_commit_hex)
+    tuple, where recorded_in_commit_hex is the commit that made the derived dataset.
+    """
+    all_lineage = sorted(
+        _all_lineage(repo), key=lambda item: item[0].commit_time, reverse=True
+    )
+    root = (ds_path, commit.id.hex)
+    nodes = [root]
+    edges = []
+    to_visit = [root]
+    while to_visit:
+        node = to_visit.pop(0)
+        node_ds_path, node_commit_hex = node
+        node_commit_id = pygit2.Oid(hex=node_commit_hex)
+        for recorded_in, lineage in all_lineage:
+            if node_ds_path not in lineage.get("datasets", []):
+                continue
+            if recorded_in.id != node_commit_id and not repo.descendant_of(
+                node_commit_id, recorded_in.id
+            ):
+                continue
+            for source in lineage.get("derivedFrom", []):
+                source_node = (source["dataset"], source["commit"])
+                edges.append((node, source_node, recorded_in.id.hex))
+                if source_node not in nodes:
+                    nodes.append(source_node)
+                    to_visit.append(source_node)
+    return nodes, edges
+
+
+def _node_json(node):
+    return {"dataset": node[0], "commit": node[1]}
+
+
+def _node_label(node):
+    return f"{node[0]}@{node[1][:7]}"
+
+
+def _lineage_text_lines(node, edges, depth=0, seen=None):
+    seen = set() if seen is None else seen
+    lines = []
+    for derived, source, recorded_in in edges:
+        if derived != node:
+            continue
+        lines.append(
+            f"{'  ' * (depth + 1)}derived from {_node_label(source)} in commit {recorded_in[:7]}"
+        )
+        if source in seen:
+            continue
+        seen.add(source)
+        lines += _lineage_text_lines(source, edges, depth + 1, seen)
+    return lines
+
+
+def _lineage_dot(nodes, edges):
+    lines = ["digraph lineage {"]
+    for node in nodes:
+        lines.append(f'  "{_node_label(node)}";')
+    for derived, source, recorded_in in edges:
+        lines.append(
+            f'  "{_node_label(source)}" -> "{_node_label(derived)}" [label="{recorded_in[:7]}"];'
+        )
+    lines.append("}")
+    return "\n".join(lines)
+
+
+@click.group(cls=KartGroup)
+@click.pass_context
+def lineage(ctx, **kwargs):
+    """
+    The lineage of datasets that were derived from other datasets.
+
+    When a commit is made with `--derived-from DATASET@COMMIT`, Kart records that the datasets changed by that commit
+    were derived from the given dataset as it was at the given commit. This lets derived products be traced back to
+    their sources.
+    """
+
+
+@lineage.command(cls=KartCommand)
+@click.pass_context
+@click.option(
+    "--output-format",
+    "-o",
+    type=click.Choice(["text", "json", "dot"]),
+    default="text",
+    help="Output format. dot is the Graphviz format, eg for `kart lineage show DATASET -o dot | dot -Tsvg`",
+)
+@click.argument("ds_path", metavar="DATASET", shell_complete=repo_path_completer)
+@click.argument("refish", default="HEAD", required=False)
+def show(ctx, output_format, ds_path, refish):
+    """
+    Show the datasets that a dataset was derived from, and the datasets that those were derived from, and so on.
+
+    By default, the lineage of the dataset as it is at HEAD is shown - specify a commit to show an earlier version.
+    """
+    repo = ctx.obj.repo
+    commit = CommitWithReference.resolve(repo, refish).commit
+    if ds_path not in repo.datasets(commit.id.hex):
+        raise NotFound(
+            f"No dataset {ds_path} at commit {commit.short_id}", exit_code=NO_DATA
+        )
+
+    nodes, edges = lineage_graph(repo, ds_path, commit)
+    if output_format == "json":
+        dump_json_output(
+            {
+                "kart.lineage/v1": {
+                    "nodes": [_node_json(node) for node in nodes],
+                    "edges": [
+                        {
+                            "derived": _node_json(derived),
+                            "source": _node_json(source),
+                            "recordedIn": recorded_in,
+                        }
+                        for derived, source, recorded_in in edges
+                    ],
+                }
+            },
+            sys.stdout,
+        )
+    elif output_format == "dot":
+        click.echo(_lineage_dot(nodes, edges))
+    else:
+        click.echo(_node_label(nodes[0]))
+        lines = _lineage_text_lines(nodes[0], edges)
+        if lines:
+            click.echo("\n".join(lines))
+        else:
+            click.echo("No lineage recorded")
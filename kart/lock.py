@@ -0,0 +1,433 @@
+import json
+import sys
+from datetime import datetime, timezone
+
+import click
+import pygit2
+
+from kart.cli_util import KartCommand, KartGroup
+from kart.completion_shared import repo_path_completer
+from kart.exceptions import (
+    InvalidOperation,
+    NotFound,
+    NO_DATA,
+    NO_REPOSITORY,
+    SubprocessError,
+)
+from kart.output_util import dump_json_output
+from kart import subprocess_util as subprocess
+from kart.timestamps import datetime_to_iso8601_utc
+
+# Locks are stored in the remote as refs, one per locked dataset. Each points to an orphan commit with an empty tree,
+# whose message contains the lock details as JSON. Since pushing a ref that already exists (and which points to an
+# unrelated commit) is rejected by the remote, only one user can acquire any given lock.
+REMOTE_LOCKS_PREFIX = "refs/kart/locks/"
+# When we fetch locks from a remote, we store them locally under this prefix, followed by the remote name.
+LOCAL_LOCKS_PREFIX = "refs/kart/remote-locks/"
+
+
+class DatasetLock:
+    """An advisory lock on a dataset, held by a particular user, stored in a particular remote."""
+
+    def __init__(self, remote, ds_path, commit):
+        self.remote = remote
+        self.ds_path = ds_path
+        self.commit = commit
+        try:
+            self.details = json.loads(commit.message)
+        except ValueError:
+            self.details = {}
+
+    @property
+    def owner_email(self):
+        return self.commit.author.email
+
+    @property
+    def owner(self):
+        return f"{self.commit.author.name} <{self.commit.author.email}>"
+
+    def is_owned_by(self, signature):
+        return self.owner_email == signature.email
+
+    def as_json(self):
+        return {
+            "dataset": self.ds_path,
+            "remote": self.remote,
+            "owner": self.owner,
+            "acquired": self.details.get("acquired"),
+            "message": self.details.get("message"),
+        }
+
+    def __str__(self):
+        result = f"{self.ds_path} is locked by {self.owner}"
+        if self.details.get("acquired"):
+            result += f" since {self.details['acquired']}"
+        if self.details.get("message"):
+            result += f": {self.details['message']}"
+        return result
+
+
+def _local_locks_prefix(remote):
+    return f"{LOCAL_LOCKS_PREFIX}{remote}/"
+
+
+def fetch_locks(repo, remote, git_config_args=()):
+    """
+    Fetches the current set of locks from the remote, replacing any that were previously fetched.
+    Any git_config_args - eg from tls_git_config_args - are passed to git fetch.
+    """
+    try:
+        subprocess.check_call(
+            [
+                "git",
+                "-C",
+                repo.path,
+                *git_config_args,
+                "fetch",
+                "--quiet",
+                "--prune",
+                "--no-tags",
+                remote,
+                f"+{REMOTE_LOCKS_PREFIX}*:{_local_locks_prefix(remote)}*",
+            ],
+        )
+    except subprocess.CalledProcessError as e:
+        raise SubprocessError(
+            f"There was a problem fetching dataset locks from {remote}: {e}",
+            called_process_error=e,
+        )
+
+
+def get_locks(repo, remote):
+    """Returns the locks for the given remote as of the last time they were fetched, as a dict keyed by dataset path."""
+    prefix = _local_locks_prefix(remote)
+    result = {}
+    for ref_name in repo.references:
+        if ref_name.startswith(prefix):
+            ds_path = ref_name[len(prefix) :]
+            commit = repo.references[ref_name].peel(pygit2.Commit)
+            result[ds_path] = DatasetLock(remote, ds_path, commit)
+    return result
+
+
+def get_all_locks(repo):
+    """Returns the locks for every remote as of the last time they were fetched, as a list."""
+    result = []
+    for remote in repo.remotes:
+        result.extend(get_locks(repo, remote.name).values())
+    return result
+
+
+def check_datasets_not_locked(repo, ds_paths, locks, action):
+    """
+    Raises an InvalidOperation if any of the given datasets are locked by someone other than the current user.
+    """
+    user = repo.committer_signature()
+    blocking = [
+        locks[p]
+        for p in sorted(ds_paths)
+        if p in locks and not locks[p].is_owned_by(user)
+    ]
+    if blocking:
+        desc = "\n".join(f"  {lock}" for lock in blocking)
+        raise InvalidOperation(
+            f"Can't {action} - the following datasets are locked by other users:\n{desc}\n"
+            f"Use --ignore-locks to {action} anyway."
+        )
+
+
+def get_changed_dataset_paths(repo, old_commit, new_commit):
+    """Returns the set of paths of datasets that are different between the two given commits."""
+    old_trees = _dataset_trees(repo, old_commit)
+    new_trees = _dataset_trees(repo, new_commit)
+    return {
+        ds_path
+        for ds_path in old_trees.keys() | new_trees.keys()
+        if old_trees.get(ds_path) != new_trees.get(ds_path)
+    }
+
+
+def _dataset_trees(repo, commit):
+    if commit is None:
+        return {}
+    return {ds.path: ds.tree.id for ds in repo.datasets(commit.id.hex)}
+
+
+# Options of `git push` that take a value as the next argument.
+PUSH_OPTIONS_WITH_VALUES = {"-o", "--push-option", "--repo", "--receive-pack", "--exec"}
+
+
+def push_positional_args(args):
+    """
+    Returns the positional arguments of `git push` with the given args - the remote, followed by any refspecs -
+    skipping the values of options that take a value.
+    """
+    result = []
+    args = iter(args)
+    for arg in args:
+        if arg in PUSH_OPTIONS_WITH_VALUES:
+            next(args, None)
+        elif not arg.startswith("-"):
+            result.append(arg)
+    return result
+
+
+def _branch_shorthand(ref_name):
+    if ref_name.startswith("refs/heads/"):
+        return ref_name[len("refs/heads/") :]
+    if ref_name.startswith("refs/"):
+        # Not a branch - eg a tag.
+        return None
+    return ref_name
+
+
+def get_pushed_branches(repo, remote, args):
+    """
+    Returns a list of (commit, branch) for every branch on the given remote that `git push` with the given args would
+    update, where commit is the local commit that the branch would be updated to.
+    """
+    if "--all" in args or "--branches" in args:
+        return [
+            (repo.branches.local[name].peel(pygit2.Commit), name)
+            for name in repo.branches.local
+        ]
+
+    refspecs = push_positional_args(args)[1:]
+    if not refspecs:
+        # By default, git pushes the current branch - to its upstream branch, if it is tracking one on that remote.
+        if repo.head_is_detached or repo.head_is_unborn:
+            return []
+        branch = repo.branches.local[repo.head_branch_shorthand]
+        upstream = branch.upstream
+        if upstream is not None and upstream.remote_name == remote:
+            dst = upstream.branch_name[len(remote) + 1 :]
+        else:
+            dst = branch.branch_name
+        return [(repo.head_commit, dst)]
+
+    result = []
+    for refspec in refspecs:
+        src, _, dst = refspec.lstrip("+").partition(":")
+        if not src:
+            # Deletes a branch from the remote, which can't change any datasets.
+            continue
+        if not dst:
+            # If HEAD is detached, git can't tell which branch to push it to, and refuses to push.
+            dst = repo.head_branch_shorthand if src == "HEAD" else src
+        dst = _branch_shorthand(dst) if dst else None
+        try:
+            commit = repo.revparse_single(src).peel(pygit2.Commit)
+        except (KeyError, ValueError, pygit2.InvalidSpecError):
+            # Git will report the problem with this refspec when it pushes.
+            continue
+        if dst:
+            result.append((commit, dst))
+    return result
+
+
+def check_push_not_locked(repo, remote, args, git_config_args=()):
+    """
+    Fetches the latest locks from the remote, then raises an InvalidOperation if the commits that `git push` with the
+    given args would push - on any branch - change any datasets that are locked by other users.
+    Any git_config_args are passed to git when fetching the locks - see fetch_locks.
+    """
+    pushed_branches = get_pushed_branches(repo, remote, args)
+    if not pushed_branches:
+        return
+    fetch_locks(repo, remote, git_config_args)
+    locks = get_locks(repo, remote)
+    if not locks:
+        return
+    changed = set()
+    for commit, branch in pushed_branches:
+        changed |= get_changed_dataset_paths(
+            repo, push_base_commit(repo, remote, commit, branch), commit
+        )
+    check_datasets_not_locked(repo, changed, locks, "push")
+
+
+def push_base_commit(repo, remote, commit, branch):
+    """
+    Returns the commit to compare the given commit to, when it is pushed to the given branch on the given remote:
+    the branch as it was when last fetched, or if the branch doesn't exist on the remote yet, the point where the
+    commit diverged from the remote's default branch. Returns None if there is nothing to compare it to, in which
+    case every dataset at the commit counts as changed.
+    """
+    tracking_ref = repo.references.get(f"refs/remotes/{remote}/{branch}")
+    if tracking_ref is None:
+        tracking_ref = repo.references.get(f"refs/remotes/{remote}/HEAD")
+        if tracking_ref is None:
+            return None
+        ancestor_id = repo.merge_base(tracking_ref.resolve().target, commit.id)
+        return repo[ancestor_id] if ancestor_id else None
+    return tracking_ref.peel(pygit2.Commit)
+
+
+def _get_remote(repo, remote):
+    if remote is None:
+        remote = repo.head_remote_name_or_default
+    if remote is None or remote not in [r.name for r in repo.remotes]:
+        raise NotFound(
+            "No remote to store dataset locks in - use --remote to specify one",
+            exit_code=NO_REPOSITORY,
+        )
+    return remote
+
+
+def _check_datasets_exist(repo, ds_paths):
+    existing = {ds.path for ds in repo.datasets()}
+    missing = [p for p in ds_paths if p not in existing]
+    if missing:
+        raise NotFound(f"No such dataset: {', '.join(missing)}", exit_code=NO_DATA)
+
+
+def _push(repo, remote, refspecs, extra_args=()):
+    try:
+        subprocess.check_call(
+            [
+                "git",
+                "-C",
+                repo.path,
+                "push",
+                "--quiet",
+                "--atomic",
+                *extra_args,
+                remote,
+                *refspecs,
+            ],
+        )
+    except subprocess.CalledProcessError as e:
+        raise SubprocessError(
+            f"There was a problem updating dataset locks in {remote} - another user may have changed them. "
+            f'Use "kart lock list" to see the current locks.',
+            called_process_error=e,
+        )
+
+
+@click.group(cls=KartGroup)
+@click.pass_context
+def lock(ctx, **kwargs):
+    """
+    Advisory locks on datasets, shared with other users via a remote.
+
+    Acquiring a lock on a dataset tells other users of the same remote that you are editing it. Locks are advisory:
+    they don't prevent anybody from reading or editing a dataset, but commit and push will refuse to change a dataset
+    that is locked by another user, unless --ignore-locks is specified. Commit checks the locks as they were when last
+    fetched from the remote; push always fetches the latest locks first.
+    """
+
+
+@lock.command(cls=KartCommand, writes_to_repo=True)
+@click.pass_context
+@click.option("--remote", help="The remote to store the lock in.")
+@click.option(
+    "--message", "-m", help="A note for other users about why you need the lock."
+)
+@click.argument(
+    "datasets", nargs=-1, required=True, shell_complete=repo_path_completer
+)
+def acquire(ctx, remote, message, datasets):
+    """Acquire a lock on one or more datasets."""
+    repo = ctx.obj.repo
+    remote = _get_remote(repo, remote)
+    _check_datasets_exist(repo, datasets)
+
+    fetch_locks(repo, remote)
+    locks = get_locks(repo, remote)
+    user = repo.committer_signature()
+    to_acquire = []
+    for ds_path in datasets:
+        existing = locks.get(ds_path)
+        if existing is None:
+            to_acquire.append(ds_path)
+        elif not existing.is_owned_by(user):
+            raise InvalidOperation(f"Can't acquire lock - {existing}")
+
+    details = {"acquired": datetime_to_iso8601_utc(datetime.now(timezone.utc))}
+    if message:
+        details["message"] = message
+    empty_tree = repo.TreeBuilder().write()
+    refspecs = []
+    for ds_path in to_acquire:
+        commit_id = repo.create_commit(
+            None,
+            repo.author_signature(),
+            user,
+            json.dumps({"dataset": ds_path, **details}),
+            empty_tree,
+            [],
+        )
+        refspecs.append(f"{commit_id}:{REMOTE_LOCKS_PREFIX}{ds_path}")
+
+    if refspecs:
+        _push(repo, remote, refspecs)
+        fetch_locks(repo, remote)
+    for ds_path in datasets:
+        click.echo(f"Acquired lock on {ds_path} in {remote}")
+
+
+@lock.command(cls=KartCommand, writes_to_repo=True)
+@click.pass_context
+@click.option("--remote", help="The remote that stores the lock.")
+@click.option(
+    "--force",
+    is_flag=True,
+    help="Release the lock even if it is held by another user.",
+)
+@click.argument(
+    "datasets", nargs=-1, required=True, shell_complete=repo_path_completer
+)
+def release(ctx, remote, force, datasets):
+    """Release a lock on one or more datasets."""
+    repo = ctx.obj.repo
+    remote = _get_remote(repo, remote)
+
+    fetch_locks(repo, remote)
+    locks = get_locks(repo, remote)
+    user = repo.committer_signature()
+    refspecs = []
+    leases = []
+    for ds_path in datasets:
+        existing = locks.get(ds_path)
+        if existing is None:
+            raise NotFound(f"{ds_path} is not locked in {remote}", exit_code=NO_DATA)
+        if not force and not existing.is_owned_by(user):
+            raise InvalidOperation(
+                f"Can't release lock - {existing}\nUse --force to release it anyway."
+            )
+        ref_name = f"{REMOTE_LOCKS_PREFIX}{ds_path}"
+        refspecs.append(f":{ref_name}")
+        # Only delete the lock if it hasn't changed since we fetched it.
+        leases.append(f"--force-with-lease={ref_name}:{existing.commit.id}")
+
+    _push(repo, remote, refspecs, extra_args=leases)
+    fetch_locks(repo, remote)
+    for ds_path in datasets:
+        click.echo(f"Released lock on {ds_path} in {remote}")
+
+
+@lock.command(name="list", cls=KartCommand)
+@click.pass_context
+@click.option("--remote", help="The remote to list locks from.")
+@click.option(
+    "--output-format",
+    "-o",
+    type=click.Choice(["text", "json"]),
+    default="text",
+)
+def list_locks(ctx, remote, output_format):
+    """List the dataset locks held in a remote."""
+    repo = ctx.obj.repo
+    remote = _get_remote(repo, remote)
+
+    fetch_locks(repo, remote)
+    locks = get_locks(repo, remote)
+    if output_format == "json":
+        dump_json_output(
+            {"kart.lock/v1": [locks[p].as_json() for p in sorted(locks)]}, sys.stdout
+        )
+    elif not locks:
+        click.echo(f"No datasets are locked in {remote}")
+    else:
+        for ds_path in sorted(locks):
+            click.echo(str(locks[ds_path]))
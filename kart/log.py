@@ -231,6 +231,23 @@ def convert_user_patterns_to_raw_paths(paths, repo, commits):
         "This may cause extra lines to be printed in between commits, in order for the graph history to be drawn properly. "
     ),
 )
+@click.option(
+    "--dot",
+    is_flag=True,
+    help=(
+        "Output the structure of the commit history - the commits, their parents and the branches and tags that "
+        "point to them - as a Graphviz DOT graph, eg `kart log --dot --all | dot -Tsvg > history.svg`. "
+        "If FILTERS are specified, only the commits that changed them are shown."
+    ),
+)
+@click.option(
+    "--follow",
+    is_flag=True,
+    help=(
+        "Also show the history of datasets from before they were renamed with `kart data mv`. "
+        "Only applies to filters that are entire datasets."
+    ),
+)
 @click.argument(
     "args",
     metavar="[REVISIONS] [--] [FILTERS]",
@@ -242,6 +259,8 @@ def log(
     output_format,
     dataset_changes,
     with_feature_count,
+    dot,
+    follow,
     args,
     **kwargs,
 ):
@@ -256,10 +275,27 @@ def log(
     """
     repo = ctx.obj.get_repo(allowed_states=KartRepoState.ALL_STATES)
     options, commits, filters = parse_revisions_and_filters(repo, args, kwargs)
+    if follow:
+        from kart.data import find_previous_dataset_paths
+
+        filters = list(filters) + [
+            old_path
+            for f in filters
+            if ":" not in f
+            for old_path in find_previous_dataset_paths(repo, f, commits)
+        ]
 
     paths = convert_user_patterns_to_raw_paths(filters, repo, commits)
     output_type, fmt = output_format
 
+    if dot:
+        if output_type != "text" or fmt or kwargs.get("graph"):
+            raise click.UsageError(
+                "--dot is not compatible with --graph or --output-format"
+            )
+        write_dot_graph(repo, options, commits, paths)
+        return
+
     # TODO: should we check paths exist here? git doesn't!
     if output_type == "text":
         if fmt:
@@ -317,6 +353,66 @@ def log(
             dump_json_output(commit_log, sys.stdout, fmt)
 
 
+def _dot_quote(value):
+    value = value.replace("\\", "\\\\").replace('"', '\\"').replace("\n", "\\n")
+    return f'"{value}"'
+
+
+def write_dot_graph(repo, options, commits, paths):
+    """
+    Writes the commits that git log would output - and the parent-child relationships between them - as a Graphviz
+    DOT graph. When paths are given, history is simplified so that the parents of each commit are the nearest
+    ancestors that also changed those paths.
+    """
+    try:
+        cmd = [
+            "git",
+            "-C",
+            repo.path,
+            "log",
+            "--parents",
+            "--format=%H%x00%P%x00%D%x00%s",
+            *options,
+            *commits,
+            "--",
+            *paths,
+        ]
+        r = subprocess.run(
+            cmd,
+            encoding="utf8",
+            check=True,
+            capture_output=True,
+        )
+    except subprocess.CalledProcessError as e:
+        raise SubprocessError(
+            f"There was a problem with git log: {e}", called_process_error=e
+        )
+
+    log_entries = [line.split("\0") for line in r.stdout.splitlines() if line]
+    commit_ids = {commit_id for commit_id, *rest in log_entries}
+
+    click.echo("digraph kart_log {")
+    click.echo('  node [shape=box, fontname="monospace"];')
+    for commit_id, parents, refs, subject in log_entries:
+        label = f"{commit_id[:7]}\n{subject}"
+        click.echo(f"  {_dot_quote(commit_id)} [label={_dot_quote(label)}];")
+        for parent_id in parents.split():
+            # Parents that aren't part of this log (eg due to --max-count) are left out.
+            if parent_id in commit_ids:
+                click.echo(f"  {_dot_quote(commit_id)} -> {_dot_quote(parent_id)};")
+        for ref in refs.split(","):
+            ref = ref.strip()
+            if not ref:
+                continue
+            # "HEAD -> main" means HEAD is main - it is shown as a single node.
+            ref_id = _dot_quote(f"ref:{ref}")
+            click.echo(
+                f"  {ref_id} [label={_dot_quote(ref)}, shape=ellipse, style=filled, fillcolor=lightgrey];"
+            )
+            click.echo(f"  {ref_id} -> {_dot_quote(commit_id)} [style=dashed];")
+    click.echo("}")
+
+
 def _parse_git_log_output(lines):
     for line in lines:
         commit_id, *refs = line.split(",")
@@ -0,0 +1,81 @@
+import datetime
+import json
+import logging
+
+# The log levels that can be chosen with `kart --verbosity`, and the equivalent number of -v flags.
+VERBOSITY_LEVELS = {
+    "error": (logging.ERROR, 0),
+    "warning": (logging.WARNING, 0),
+    "info": (logging.INFO, 1),
+    "debug": (logging.DEBUG, 2),
+}
+
+TEXT_FORMAT = "%(asctime)s %(levelname)s %(name)s - %(message)s"
+DETAILED_TEXT_FORMAT = "%(asctime)s T%(thread)d %(levelname)s %(name)s [%(filename)s:%(lineno)d] - %(message)s"
+
+# The attributes that every LogRecord has - anything else was passed using extra={...} and is output as a field.
+_STANDARD_RECORD_ATTRS = set(
+    logging.LogRecord("", logging.INFO, "", 0, "", (), None).__dict__
+) | {"message", "asctime"}
+
+
+class JsonLogFormatter(logging.Formatter):
+    """
+    Formats each log record as a single line of JSON, so that logs can be ingested by log aggregators.
+    Any fields passed to the logger using extra={...} are included.
+    """
+
+    def format(self, record):
+        result = {
+            "time": datetime.datetime.fromtimestamp(
+                record.created, datetime.timezone.utc
+            ).isoformat(timespec="milliseconds"),
+            "level": record.levelname,
+            "logger": record.name,
+            "message": record.getMessage(),
+            "pid": record.process,
+            "thread": record.thread,
+        }
+        for key, value in record.__dict__.items():
+            if key not in _STANDARD_RECORD_ATTRS and key not in result:
+                result[key] = value
+        if record.exc_info:
+            result["exception"] = self.formatException(record.exc_info)
+        return json.dumps(result, default=str)
+
+
+def configure_logging(level, *, detailed=False, log_file=None, log_format="text"):
+    """
+    Configures logging to stderr at the given level. If log_file is set, logs are also appended to that file - at
+    INFO level or lower, so that long-running commands leave a useful record even without -v.
+    """
+    if log_format == "json":
+        formatter = JsonLogFormatter()
+    else:
+        formatter = logging.Formatter(
+            DETAILED_TEXT_FORMAT if detailed else TEXT_FORMAT
+        )
+
+    root = logging.getLogger()
+    # Remove any log file handler left over from a previous invocation in the same process.
+    for handler in list(root.handlers):
+        if getattr(handler, "is_kart_log_file", False):
+            root.removeHandler(handler)
+            handler.close()
+
+    # basicConfig does nothing if logging has already been configured, eg when running tests.
+    if not root.handlers:
+        logging.basicConfig(level=level)
+        for handler in root.handlers:
+            # Keep stderr at the requested level, even if the root logger is lowered for the log file below.
+            handler.setLevel(level)
+            handler.setFormatter(formatter)
+
+    if log_file is not None:
+        file_level = min(level, logging.INFO)
+        file_handler = logging.FileHandler(log_file, encoding="utf-8")
+        file_handler.is_kart_log_file = True
+        file_handler.setLevel(file_level)
+        file_handler.setFormatter(formatter)
+        root.addHandler(file_handler)
+        root.setLevel(min(root.level, file_level))
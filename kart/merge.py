@@ -4,11 +4,19 @@ import sys
 import click
 
 from . import commit
-from .cli_util import StringFromFile, call_and_exit_flag, KartCommand
+from .cli_util import JsonFromFile, StringFromFile, call_and_exit_flag, KartCommand
 from .conflicts_writer import BaseConflictsWriter
 from .core import check_git_user
 from .diff_util import get_repo_diff
 from .exceptions import InvalidOperation
+from .guardrails import check_change_size_guardrails
+from .integrity import check_merge_integrity
+from .merge_strategy import (
+    COLUMN_RULES_SCHEMA,
+    MERGE_STRATEGIES,
+    RENUMBER_PK_COLLISIONS,
+    ConflictAutoResolver,
+)
 from .merge_util import (
     ALL_MERGE_FILES,
     AncestorOursTheirs,
@@ -20,11 +28,29 @@ from .merge_util import (
 )
 from .output_util import dump_json_output
 from .pack_util import write_to_packfile
+from .pk_remap import find_pk_remaps, record_pk_remaps
 from .repo import KartRepoFiles, KartRepoState
 from .structs import CommitWithReference
 
 L = logging.getLogger("kart.merge")
 
+# The default for `kart merge --renumber-pk-collisions` - see ConflictAutoResolver.
+RENUMBER_PK_COLLISIONS_CONFIG = "kart.merge.renumberPkCollisions"
+
+
+def get_renumber_pk_collisions(repo):
+    if RENUMBER_PK_COLLISIONS_CONFIG not in repo.config:
+        return None
+    value = repo.config[RENUMBER_PK_COLLISIONS_CONFIG]
+    if value == "none":
+        return None
+    if value not in RENUMBER_PK_COLLISIONS:
+        raise InvalidOperation(
+            f"Invalid value for {RENUMBER_PK_COLLISIONS_CONFIG}: {value!r} - expected one of "
+            f"{', '.join(RENUMBER_PK_COLLISIONS)} or none"
+        )
+    return value
+
 
 def get_commit_message(
     merge_context,
@@ -53,9 +79,31 @@ def get_commit_message(
 
 
 def do_merge(
-    repo, ff, ff_only, dry_run, commit, message, launch_editor=True, quiet=False
+    repo,
+    ff,
+    ff_only,
+    dry_run,
+    commit,
+    message,
+    launch_editor=True,
+    quiet=False,
+    strategy=None,
+    column_rules=None,
+    geometry_merge=True,
+    integrity_check=True,
+    change_size_check=False,
+    renumber_pk_collisions=None,
 ):
-    """Does a merge, but doesn't update the working copy."""
+    """
+    Does a merge, but doesn't update the working copy.
+    Conflicts are automatically resolved where possible, according to the given strategy, column_rules,
+    geometry_merge and renumber_pk_collisions - see ConflictAutoResolver. Features that are renumbered are recorded
+    on the merge commit - see kart.pk_remap.
+    If integrity_check is set, the merge isn't committed if it would break any of the relationships between
+    datasets declared in the repository config - see kart.integrity.
+    If change_size_check is set, the merge isn't committed if it would change the current branch by more than the
+    guardrails configured with kart.guardrails.push.* allow - see kart.guardrails.
+    """
     if ff_only and not ff:
         raise click.BadParameter(
             "Conflicting parameters: --no-ff & --ff-only", param_hint="--ff-only"
@@ -107,6 +155,10 @@ def do_merge(
     if can_ff and ff:
         # do fast-forward merge
         L.debug(f"Fast forward: {theirs.id.hex}")
+        if change_size_check and not dry_run:
+            check_change_size_guardrails(
+                repo, ours.commit.tree, theirs.commit.tree, action="merge"
+            )
         merge_jdict["commit"] = theirs.id.hex
         merge_jdict["fastForward"] = True
         if not dry_run:
@@ -118,8 +170,27 @@ def do_merge(
     tree3 = commit_with_ref3.map(lambda c: c.tree)
     index = repo.merge_trees(**tree3.as_dict(), flags={"find_renames": False})
 
+    merged_index = None
+    pk_remaps = []
     if index.conflicts:
         merged_index = MergedIndex.from_pygit2_index(index)
+        if strategy or column_rules or geometry_merge or renumber_pk_collisions:
+            auto_resolver = ConflictAutoResolver(
+                repo,
+                merge_context,
+                strategy=strategy,
+                column_rules=column_rules,
+                merge_geometries=geometry_merge,
+                renumber_pk_collisions=renumber_pk_collisions,
+            )
+            auto_resolved = auto_resolver.resolve_all(merged_index)
+            if auto_resolved or strategy or column_rules:
+                merge_jdict["autoResolved"] = auto_resolved
+        pk_remaps = find_pk_remaps(merged_index, merge_context)
+        if pk_remaps:
+            merge_jdict["pkRemaps"] = pk_remaps
+
+    if merged_index is not None and merged_index.unresolved_conflicts:
         conflicts_writer_class = BaseConflictsWriter.get_conflicts_writer_class("json")
         conflicts_writer = conflicts_writer_class(
             repo, summarise=2, merged_index=merged_index, merge_context=merge_context
@@ -142,10 +213,19 @@ def do_merge(
     check_git_user(repo)
 
     with write_to_packfile(repo):
-        merge_tree_id = index.write_tree(repo, write_merged_index_flags(repo))
+        if merged_index is not None:
+            # Every conflict was resolved automatically.
+            merge_tree_id = merged_index.write_resolved_tree(repo)
+        else:
+            merge_tree_id = index.write_tree(repo, write_merged_index_flags(repo))
         L.debug(f"Merge tree: {merge_tree_id}")
+        if integrity_check:
+            check_merge_integrity(repo, merge_tree_id)
+        if change_size_check:
+            check_change_size_guardrails(
+                repo, ours.commit.tree, repo[merge_tree_id], action="merge"
+            )
 
-        user = repo.default_signature
         if not message:
             message = get_commit_message(
                 merge_context,
@@ -156,12 +236,13 @@ def do_merge(
             )
         merge_commit_id = repo.create_commit(
             repo.head.name,
-            user,
-            user,
+            repo.author_signature(),
+            repo.committer_signature(),
             message,
             merge_tree_id,
             [ours.id, theirs.id],
         )
+        record_pk_remaps(repo, merge_commit_id, pk_remaps)
 
     L.debug(f"Merge commit: {merge_commit_id}")
     merge_jdict["commit"] = merge_commit_id.hex
@@ -247,6 +328,8 @@ def complete_merging_state(ctx):
     with write_to_packfile(repo):
         merge_tree_id = merged_index.write_resolved_tree(repo)
         L.debug(f"Merge tree: {merge_tree_id}")
+        if ctx.params.get("integrity_check", True):
+            check_merge_integrity(repo, merge_tree_id)
 
         message = ctx.params.get("message")
         launch_editor = ctx.params.get("launch_editor")
@@ -259,15 +342,17 @@ def complete_merging_state(ctx):
                 launch_editor=launch_editor,
             )
 
-        user = repo.default_signature
         merge_commit_id = repo.create_commit(
             repo.head.name,
-            user,
-            user,
+            repo.author_signature(),
+            repo.committer_signature(),
             message,
             merge_tree_id,
             [commit_ids.ours, commit_ids.theirs],
         )
+        record_pk_remaps(
+            repo, merge_commit_id, find_pk_remaps(merged_index, merge_context)
+        )
 
     L.debug(f"Merge commit: {merge_commit_id}")
 
@@ -293,7 +378,7 @@ def complete_merging_state(ctx):
     repo.working_copy.reset_to_head()
 
 
-@click.command(cls=KartCommand)
+@click.command(cls=KartCommand, writes_to_repo=True)
 @click.option(
     "--ff/--no-ff",
     default=True,
@@ -322,6 +407,63 @@ def complete_merging_state(ctx):
     type=click.Choice(["text", "json"]),
     default="text",
 )
+@click.option(
+    "--strategy",
+    type=click.Choice(MERGE_STRATEGIES),
+    help=(
+        "Resolve conflicts automatically: \"ours\" or \"theirs\" takes that version of each conflicting item, "
+        "and \"newest\" takes the version from whichever branch was committed most recently. Features edited on both "
+        "branches are first merged attribute-by-attribute, keeping the changes from both branches where they changed "
+        "different attributes. \"union-attributes\" only does this attribute-by-attribute merge, and leaves any "
+        "other conflicts to be resolved with `kart resolve`."
+    ),
+)
+@click.option(
+    "--column-rules",
+    type=JsonFromFile(encoding="utf-8", schema=COLUMN_RULES_SCHEMA),
+    help=(
+        "Rules for merging attributes that were changed on both branches, as a JSON object or @filename of a JSON "
+        'file. Each key is a dataset path (or "*" for all datasets), and each value is an object mapping column names '
+        'to one of "max", "min", "ours" or "theirs", eg {"*": {"updated_at": "max"}}'
+    ),
+)
+@click.option(
+    "--geometry-merge/--no-geometry-merge",
+    default=True,
+    help=(
+        "Whether to merge geometries that were edited on both branches vertex-by-vertex, when the branches moved, "
+        "added or removed different vertices and the merged geometry is valid. Otherwise, any geometry edited on "
+        "both branches is a conflict."
+    ),
+)
+@click.option(
+    "--renumber-pk-collisions",
+    type=click.Choice([*RENUMBER_PK_COLLISIONS, "none"]),
+    help=(
+        "When both branches inserted a different feature with the same integer primary key, keep both features, and "
+        "give the one from this side (\"ours\" or \"theirs\") the next unassigned primary key - as if resolved with "
+        "`kart resolve --renumber`. Renumbered features are recorded in a git note on the merge commit. "
+        "Defaults to the kart.merge.renumberPkCollisions config, or \"none\", which leaves them as conflicts."
+    ),
+)
+@click.option(
+    "--integrity-check/--no-integrity-check",
+    default=True,
+    help=(
+        "Whether to refuse to commit a merge that would break the relationships between datasets declared in the "
+        "repository config - see `kart check-integrity`."
+    ),
+    is_eager=True,  # So that it can be accessed from the complete_merging_state callback.
+)
+@click.option(
+    "--allow-large-changes",
+    is_flag=True,
+    default=False,
+    help=(
+        "Merge even if the merge deletes more features, or moves a feature further, than the guardrails configured "
+        "with kart.guardrails.push.* allow."
+    ),
+)
 @click.option(
     "--message",
     "-m",
@@ -351,8 +493,28 @@ def complete_merging_state(ctx):
 )
 @click.argument("commit", required=True, metavar="COMMIT")
 @click.pass_context
-def merge(ctx, ff, ff_only, dry_run, message, launch_editor, output_format, commit):
-    """Incorporates changes from the named commits (usually other branch heads) into the current branch."""
+def merge(
+    ctx,
+    ff,
+    ff_only,
+    dry_run,
+    strategy,
+    column_rules,
+    geometry_merge,
+    renumber_pk_collisions,
+    integrity_check,
+    allow_large_changes,
+    message,
+    launch_editor,
+    output_format,
+    commit,
+):
+    """
+    Incorporates changes from the named commits (usually other branch heads) into the current branch.
+
+    Use --strategy or --column-rules to resolve some or all conflicts automatically. Any conflicts that can't be
+    resolved automatically are left to be resolved with `kart resolve`.
+    """
 
     repo = ctx.obj.get_repo(
         allowed_states=KartRepoState.NORMAL,
@@ -361,6 +523,10 @@ def merge(ctx, ff, ff_only, dry_run, message, launch_editor, output_format, comm
     ctx.obj.check_not_dirty()
 
     do_json = output_format == "json"
+    if renumber_pk_collisions is None:
+        renumber_pk_collisions = get_renumber_pk_collisions(repo)
+    elif renumber_pk_collisions == "none":
+        renumber_pk_collisions = None
 
     jdict = do_merge(
         repo,
@@ -371,6 +537,12 @@ def merge(ctx, ff, ff_only, dry_run, message, launch_editor, output_format, comm
         message,
         launch_editor=launch_editor,
         quiet=do_json,
+        strategy=strategy,
+        column_rules=column_rules,
+        geometry_merge=geometry_merge,
+        integrity_check=integrity_check,
+        change_size_check=not allow_large_changes,
+        renumber_pk_collisions=renumber_pk_collisions,
     )
     no_op = jdict.get("noOp", False) or jdict.get("dryRun", False)
     conflicts = jdict.get("conflicts", None)
@@ -0,0 +1,203 @@
+import logging
+
+from kart.merge_util import rich_conflicts, ensure_conflicts_ready
+
+L = logging.getLogger("kart.merge_strategy")
+
+# Strategies that pick a whole version of every conflicting item.
+OURS = "ours"
+THEIRS = "theirs"
+NEWEST = "newest"
+# Strategy that merges edit/edit feature conflicts attribute-by-attribute, where the two sides changed different ones.
+UNION_ATTRIBUTES = "union-attributes"
+
+MERGE_STRATEGIES = (OURS, THEIRS, NEWEST, UNION_ATTRIBUTES)
+
+# Rules for resolving a column that both sides changed to different values.
+COLUMN_RULES = {
+    "max": lambda ours, theirs: max(v for v in (ours, theirs) if v is not None),
+    "min": lambda ours, theirs: min(v for v in (ours, theirs) if v is not None),
+    "ours": lambda ours, theirs: ours,
+    "theirs": lambda ours, theirs: theirs,
+}
+
+COLUMN_RULES_SCHEMA = {
+    "type": "object",
+    "$schema": "http://json-schema.org/draft-07/schema",
+    "patternProperties": {
+        ".*": {
+            "type": "object",
+            "patternProperties": {".*": {"enum": list(COLUMN_RULES)}},
+        }
+    },
+}
+
+# The key in the column rules for rules that apply to every dataset.
+ALL_DATASETS = "*"
+
+# Which version of a feature to renumber when both sides inserted a feature with the same primary key.
+RENUMBER_PK_COLLISIONS = (OURS, THEIRS)
+
+
+class ConflictAutoResolver:
+    """
+    Resolves as many of the conflicts in a MergedIndex as possible without user input, according to a merge strategy
+    and per-column rules. Resolutions are added to the MergedIndex as if they were made with `kart resolve`, so
+    any conflicts that remain can still be resolved by hand.
+
+    strategy - one of MERGE_STRATEGIES, or None. "ours" and "theirs" pick that version of every conflicting item,
+        and "newest" picks the version from whichever side was committed most recently. Edit/edit feature conflicts
+        are first merged attribute-by-attribute, so that changes to other attributes made on the other side are kept.
+        "union-attributes" only does the attribute-by-attribute merge, leaving conflicts where both sides changed the
+        same attribute unresolved.
+    column_rules - a {dataset-path-or-*: {column-name: rule}} dict, where each rule is one of COLUMN_RULES - how to
+        resolve a column that both sides changed, eg {"*": {"updated_at": "max"}}.
+    merge_geometries - if True, geometries that both sides changed are merged vertex-by-vertex where possible - see
+        kart.geometry_merge. Without a strategy or column rules, this is the only auto-resolution done - so that
+        features whose geometries were edited differently on each side, but are otherwise the same, can be merged.
+    renumber_pk_collisions - one of RENUMBER_PK_COLLISIONS, or None. When both sides inserted a different feature
+        with the same integer primary key, both features are kept, and the one from this side is given the next
+        unassigned primary key - the same as `kart resolve --renumber`. This takes precedence over the strategy.
+    """
+
+    def __init__(
+        self,
+        repo,
+        merge_context,
+        strategy=None,
+        column_rules=None,
+        merge_geometries=True,
+        renumber_pk_collisions=None,
+    ):
+        self.repo = repo
+        self.merge_context = merge_context
+        self.column_rules = column_rules or {}
+        self.merge_geometries = merge_geometries
+        self.renumber_pk_collisions = renumber_pk_collisions
+        # The next primary key to renumber a feature to, for each dataset.
+        self._next_unassigned_pk = {}
+        # Whether to merge non-geometry attributes that were changed on different sides.
+        self.union_attributes = bool(strategy or column_rules)
+        self.prefer = strategy if strategy in (OURS, THEIRS) else None
+        if strategy == NEWEST:
+            commit_time = merge_context.versions.map(
+                lambda v: repo[v.commit_id].commit_time
+            )
+            self.prefer = THEIRS if commit_time.theirs > commit_time.ours else OURS
+
+    def resolve_all(self, merged_index):
+        """Adds resolves for every conflict that can be auto-resolved. Returns the number of conflicts resolved."""
+        conflicts = rich_conflicts(
+            merged_index.unresolved_conflicts.items(), self.merge_context
+        )
+        conflicts = ensure_conflicts_ready(conflicts, self.repo)
+        # Features in datasets with meta conflicts can't be merged attribute-by-attribute, since the schema is uncertain.
+        meta_conflict_ds_paths = set(
+            c.decoded_path[0] for c in conflicts if c.decoded_path[1] == "meta"
+        )
+
+        count = 0
+        for conflict in conflicts:
+            res = None
+            if conflict.decoded_path[0] not in meta_conflict_ds_paths:
+                res = self.merge_attributes(conflict)
+                if res is None and self.renumber_pk_collisions is not None:
+                    res = self.renumber_pk_collision(conflict)
+            if res is None and self.prefer is not None:
+                version = getattr(conflict.versions, self.prefer)
+                res = [version.entry] if version else []
+            if res is not None:
+                L.debug("Auto-resolved %s", conflict.label)
+                merged_index.add_resolve(conflict.key, res)
+                count += 1
+        return count
+
+    def _rules_for_dataset(self, ds_path):
+        result = dict(self.column_rules.get(ALL_DATASETS, {}))
+        result.update(self.column_rules.get(ds_path, {}))
+        return result
+
+    def merge_attributes(self, conflict):
+        """
+        Merges an edit/edit feature conflict attribute-by-attribute. Returns the resolve - a list containing a single
+        IndexEntry - or None if the conflict can't be resolved this way.
+        """
+        from kart.geometry_merge import merge_geometries
+        from kart.resolve import write_feature_to_dataset_entry
+
+        versions = conflict.versions
+        if conflict.has_multiple_paths or not all(versions):
+            return None
+        if not versions.ours.is_feature:
+            return None
+        datasets = versions.map(lambda v: v.dataset)
+        schemas = datasets.map(lambda ds: ds.schema)
+        if not (schemas.ancestor == schemas.ours == schemas.theirs):
+            return None
+
+        rules = self._rules_for_dataset(versions.ours.dataset_path)
+        geometry_columns = set(c.name for c in schemas.ours.geometry_columns)
+        ancestor, ours, theirs = versions.map(lambda v: v.feature)
+        result = {}
+        for key in ours:
+            a, o, t = ancestor.get(key), ours.get(key), theirs.get(key)
+            if key in geometry_columns and o != t and o != a and t != a:
+                merged = merge_geometries(a, o, t) if self.merge_geometries else None
+                if merged is not None:
+                    result[key] = merged
+                    continue
+            if o == t:
+                result[key] = o
+            elif not self.union_attributes and key not in geometry_columns:
+                return None
+            elif t == a:
+                result[key] = o
+            elif o == a:
+                result[key] = t
+            elif key in rules:
+                try:
+                    result[key] = COLUMN_RULES[rules[key]](o, t)
+                except TypeError:
+                    return None
+            elif self.prefer is not None:
+                result[key] = o if self.prefer == OURS else t
+            else:
+                return None
+        return [write_feature_to_dataset_entry(result, datasets.ours, self.repo)]
+
+    def renumber_pk_collision(self, conflict):
+        """
+        Resolves an insert/insert feature conflict by keeping both features, and renumbering one of them. Returns the
+        resolve - a list of two IndexEntries - or None if the conflict can't be resolved this way.
+        """
+        from kart.resolve import write_feature_to_dataset_entry
+
+        versions = conflict.versions
+        if versions.ancestor is not None or not (versions.ours and versions.theirs):
+            return None
+        if not versions.ours.is_feature:
+            return None
+        datasets = versions.map(lambda v: v.dataset)
+        if datasets.ours.schema != datasets.theirs.schema:
+            return None
+        if [c.data_type for c in datasets.ours.schema.pk_columns] != ["integer"]:
+            return None
+
+        ds_path = versions.ours.dataset_path
+        if ds_path not in self._next_unassigned_pk:
+            self._next_unassigned_pk[ds_path] = max(
+                datasets.ours.find_start_of_unassigned_range(),
+                datasets.theirs.find_start_of_unassigned_range(),
+            )
+        keep = versions.theirs if self.renumber_pk_collisions == OURS else versions.ours
+        renumber = getattr(versions, self.renumber_pk_collisions)
+        new_pk = self._next_unassigned_pk[ds_path]
+        self._next_unassigned_pk[ds_path] += 1
+        renumbered_feature = dict(renumber.feature)
+        renumbered_feature[datasets.ours.primary_key] = new_pk
+        return [
+            keep.entry,
+            write_feature_to_dataset_entry(
+                renumbered_feature, datasets.ours, self.repo
+            ),
+        ]
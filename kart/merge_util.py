@@ -795,6 +795,15 @@ def merge_status_to_text(jdict, fresh):
     from .conflicts_util import conflicts_json_as_text
 
     merging_text = merge_context_to_text(jdict["merging"])
+    auto_resolved = jdict.get("autoResolved")
+    if auto_resolved:
+        conflicts_desc = "conflict" if auto_resolved == 1 else "conflicts"
+        merging_text += f"\nAutomatically resolved {auto_resolved} {conflicts_desc}"
+    for remap in jdict.get("pkRemaps", []):
+        merging_text += (
+            f"\nRenumbered {remap['dataset']}:feature:{remap['oldPk']} from {remap['renumbered']} "
+            f"to {remap['dataset']}:feature:{remap['newPk']}"
+        )
 
     if jdict.get("noOp", False):
         return merging_text + "\nAlready up to date"
@@ -13,8 +13,15 @@ from .cli_util import (
     value_optionally_from_binary_file,
     value_optionally_from_text_file,
 )
+from .completion_shared import ref_completer, repo_path_completer
 from .core import check_git_user
-from .exceptions import NO_CHANGES, InvalidOperation, NotFound, NotYetImplemented
+from .exceptions import (
+    NO_CHANGES,
+    NO_TABLE,
+    InvalidOperation,
+    NotFound,
+    NotYetImplemented,
+)
 from .output_util import (
     dump_json_output,
     format_json_for_output,
@@ -33,6 +40,13 @@ from .cli_util import KartGroup, KartCommand
 def meta(ctx, **kwargs):
     """
     Read and update meta values for a dataset.
+
+    \b
+    Examples:
+    $ kart meta get my_points
+    $ kart meta get my_points schema.json -o json
+    $ kart meta set my_points title="My points"
+    $ kart meta set-description my_points README.md
     """
 
 
@@ -49,13 +63,13 @@ def meta(ctx, **kwargs):
     ),
     default="text",
 )
-@click.option("--ref", default="HEAD")
+@click.option("--ref", default="HEAD", shell_complete=ref_completer)
 @click.option(
     "--with-dataset-types",
     is_flag=True,
     help="When set, includes the dataset type and version as pseudo meta-items (these cannot be updated).",
 )
-@click.argument("dataset", required=False)
+@click.argument("dataset", required=False, shell_complete=repo_path_completer)
 @click.argument("keys", required=False, nargs=-1)
 @click.pass_context
 def meta_get(ctx, output_format, ref, with_dataset_types, dataset, keys):
@@ -145,7 +159,7 @@ class KeyValueType(click.ParamType):
         return key, value
 
 
-@meta.command(name="set")
+@meta.command(name="set", writes_to_repo=True)
 @click.option(
     "--message",
     "-m",
@@ -158,7 +172,7 @@ class KeyValueType(click.ParamType):
     is_flag=True,
     help="Amend the previous commit instead of adding a new commit",
 )
-@click.argument("dataset")
+@click.argument("dataset", shell_complete=repo_path_completer)
 @click.argument(
     "items",
     type=KeyValueType(),
@@ -195,11 +209,20 @@ def meta_set(ctx, message, amend, dataset, items):
         else:
             return value
 
+    _commit_meta_items(
+        repo,
+        dataset,
+        {key: _parse(key, value) for (key, value) in items},
+        message,
+        amend=amend,
+    )
+
+
+def _commit_meta_items(repo, dataset, items, message, *, amend=False):
+    """Creates a commit that sets the given {key: value} meta items of a dataset - or deletes those set to None."""
     patch = {
         "kart.diff/v1+hexwkb": {
-            dataset: {
-                "meta": {key: {"+": _parse(key, value)} for (key, value) in items}
-            }
+            dataset: {"meta": {key: {"+": value} for (key, value) in items.items()}}
         },
         "kart.patch/v1": {"message": message, "base": repo.head.target.hex},
     }
@@ -207,7 +230,7 @@ def meta_set(ctx, message, amend, dataset, items):
     json.dump(patch, patch_file)
     patch_file.seek(0)
     apply_patch(
-        repo=ctx.obj.repo,
+        repo=repo,
         do_commit=True,
         patch_file=patch_file,
         allow_empty=False,
@@ -215,7 +238,56 @@ def meta_set(ctx, message, amend, dataset, items):
     )
 
 
-@click.command("commit-files", hidden=True, cls=KartCommand)
+@meta.command(name="set-description", writes_to_repo=True)
+@click.option(
+    "--message",
+    "-m",
+    help="Use the given message as the commit message",
+    type=StringFromFile(encoding="utf-8"),
+)
+@click.option(
+    "--amend",
+    default=False,
+    is_flag=True,
+    help="Amend the previous commit instead of adding a new commit",
+)
+@click.argument("dataset", shell_complete=repo_path_completer)
+@click.argument(
+    "file", type=click.Path(exists=True, dir_okay=False, allow_dash=True)
+)
+@click.pass_context
+def meta_set_description(ctx, message, amend, dataset, file):
+    """
+    Sets the README of a dataset to the markdown document FILE (or - to read from stdin), and creates a commit.
+    The README is versioned along with the data, and is shown by `kart data show` - use it to document what the
+    data is for, and how it should and shouldn't be used. An empty FILE removes the README.
+    """
+    repo = ctx.obj.repo
+
+    if repo.table_dataset_version < 2:
+        raise InvalidOperation(
+            "This repo doesn't support meta changes, use `kart upgrade`"
+        )
+    if dataset not in repo.datasets():
+        raise NotFound(f"No dataset found at {dataset}", exit_code=NO_TABLE)
+
+    check_git_user(repo)
+
+    if message is None and not amend:
+        message = f"Update README for {dataset}"
+
+    with click.open_file(file, encoding="utf-8") as f:
+        readme = f.read()
+    _commit_meta_items(
+        repo,
+        dataset,
+        {"readme.md": readme if readme.strip() else None},
+        message,
+        amend=amend,
+    )
+
+
+@click.command("commit-files", hidden=True, cls=KartCommand, writes_to_repo=True)
 @click.option(
     "--message",
     "-m",
@@ -181,6 +181,9 @@ TITLE = MetaItemDefinition("title", MetaItemFileType.TEXT)
 # A longer description about the dataset's contents:
 DESCRIPTION = MetaItemDefinition("description", MetaItemFileType.TEXT)
 
+# Documentation about how the dataset should be used, in markdown:
+README = MetaItemDefinition("readme.md", MetaItemFileType.TEXT)
+
 # A list of tags - each tag is free form text.
 TAGS_JSON = MetaItemDefinition("tags.json", TagsJsonFileType.INSTANCE)
 
@@ -0,0 +1,245 @@
+import json
+import logging
+import re
+import sys
+import time
+from datetime import datetime, timezone
+from pathlib import Path
+
+import click
+
+from kart.audit import get_ref_targets
+from kart.cli_util import KartCommand
+from kart.exceptions import InvalidOperation
+from kart.lfs_commands import fetch_lfs_blobs_for_commits
+from kart.repo import KartRepo
+from kart import subprocess_util as subprocess
+from kart.timestamps import datetime_to_iso8601_utc
+
+L = logging.getLogger("kart.mirror")
+
+MIRROR_REMOTE = "origin"
+# No leading "+" - so only fast-forward updates are fetched. Any other change in the remote is reported as an error,
+# and that ref is left as it was.
+MIRROR_REFSPECS = ["refs/heads/*:refs/heads/*", "refs/tags/*:refs/tags/*"]
+MIRRORED_REF_PREFIXES = ("refs/heads/", "refs/tags/")
+
+PRE_RECEIVE_HOOK = """#!/bin/sh
+echo "This Kart repository is a read-only mirror of {url} - push to that repository instead." >&2
+exit 1
+"""
+
+
+class IntervalType(click.ParamType):
+    """A time interval such as 30s, 5m, 1h or 1d. A number without units is a number of seconds."""
+
+    name = "interval"
+
+    UNITS = {"s": 1, "m": 60, "h": 60 * 60, "d": 24 * 60 * 60}
+
+    def convert(self, value, param, ctx):
+        if isinstance(value, (int, float)):
+            return value
+        m = re.fullmatch(r"\s*(\d+(?:\.\d+)?)\s*([smhd]?)\s*", value)
+        if not m:
+            self.fail(
+                f"Invalid interval {value!r} - expected eg 30s, 5m, 1h or 1d", param, ctx
+            )
+        return float(m.group(1)) * self.UNITS[m.group(2) or "s"]
+
+
+def _init_mirror(remote_url, local_path, do_progress):
+    repo = KartRepo.clone_repository(
+        remote_url,
+        local_path,
+        ["--progress" if do_progress else "--quiet"],
+        None,
+        bare=True,
+    )
+    # Replace the refspecs set up by clone with fast-forward-only mirroring of all branches and tags.
+    subprocess.check_call(
+        [
+            "git",
+            "-C",
+            repo.path,
+            "config",
+            "--replace-all",
+            f"remote.{MIRROR_REMOTE}.fetch",
+            MIRROR_REFSPECS[0],
+        ]
+    )
+    subprocess.check_call(
+        [
+            "git",
+            "-C",
+            repo.path,
+            "config",
+            "--add",
+            f"remote.{MIRROR_REMOTE}.fetch",
+            MIRROR_REFSPECS[1],
+        ]
+    )
+    repo.config["kart.mirror.url"] = remote_url
+
+    hook_path = repo.gitdir_path / "hooks" / "pre-receive"
+    hook_path.parent.mkdir(parents=True, exist_ok=True)
+    hook_path.write_text(PRE_RECEIVE_HOOK.format(url=remote_url))
+    hook_path.chmod(0o755)
+    return repo
+
+
+def _open_mirror(remote_url, local_path):
+    repo = KartRepo(local_path)
+    mirror_url = repo.get_config_str("kart.mirror.url")
+    if mirror_url is None:
+        raise InvalidOperation(
+            f"{local_path} is an existing Kart repository, but it isn't a mirror"
+        )
+    if mirror_url != remote_url:
+        raise InvalidOperation(
+            f"{local_path} is already a mirror of a different remote: {mirror_url}"
+        )
+    return repo
+
+
+def sync_mirror(repo, do_progress, do_lfs):
+    """Fetches all branches and tags from the mirrored remote, then returns a dict describing what changed."""
+    refs_before = get_ref_targets(repo)
+    proc = subprocess.run(
+        [
+            "git",
+            "-C",
+            repo.path,
+            "fetch",
+            "--prune",
+            # Not --quiet, since that also hides which refs were rejected.
+            *(["--progress"] if do_progress else []),
+            MIRROR_REMOTE,
+        ],
+        capture_output=True,
+        encoding="utf-8",
+    )
+    refs_after = get_ref_targets(repo)
+
+    updated = {}
+    for ref_name in sorted(refs_before.keys() | refs_after.keys()):
+        if not ref_name.startswith(MIRRORED_REF_PREFIXES):
+            continue
+        old, new = refs_before.get(ref_name), refs_after.get(ref_name)
+        if old != new:
+            updated[ref_name] = {"old": old, "new": new}
+
+    # git reports refs it refused to update like so: ! [rejected]  main -> main  (non-fast-forward)
+    rejected = [
+        line.split("->")[-1].strip().split()[0]
+        for line in proc.stderr.splitlines()
+        if line.lstrip().startswith("!") and "->" in line
+    ]
+
+    result = {
+        "timestamp": datetime_to_iso8601_utc(datetime.now(timezone.utc)),
+        "updated": updated,
+        "rejected": rejected,
+        "error": None,
+    }
+    if proc.returncode != 0 and not rejected:
+        result["error"] = proc.stderr.strip()
+
+    if do_lfs and updated:
+        new_commits = [c["new"] for c in updated.values() if c["new"]]
+        fetch_lfs_blobs_for_commits(
+            repo,
+            new_commits,
+            remote_name=MIRROR_REMOTE,
+            do_spatial_filter=False,
+            quiet=not do_progress,
+        )
+    return result
+
+
+def sync_result_to_text(result):
+    lines = []
+    for ref_name, change in result["updated"].items():
+        old = change["old"][:7] if change["old"] else "(new)"
+        new = change["new"][:7] if change["new"] else "(deleted)"
+        lines.append(f"  {ref_name}: {old} -> {new}")
+    for ref_name in result["rejected"]:
+        lines.append(
+            f"  {ref_name}: rejected - this change to the remote isn't a fast-forward"
+        )
+    if result["error"]:
+        lines.append(f"  Error: {result['error']}")
+
+    if result["error"] or result["rejected"]:
+        summary = "Mirror sync failed"
+    elif result["updated"]:
+        summary = f"Mirror synced - {len(result['updated'])} refs updated"
+    else:
+        summary = "Mirror is up to date"
+    return "\n".join([f"{result['timestamp']} {summary}", *lines])
+
+
+@click.command(cls=KartCommand)
+@click.pass_context
+@click.option(
+    "--interval",
+    type=IntervalType(),
+    default=None,
+    help=(
+        "Keep running, and sync the mirror again after this interval has passed - eg 30s, 5m, 1h. "
+        "If not specified, the mirror is synced once."
+    ),
+)
+@click.option(
+    "--lfs/--no-lfs",
+    "do_lfs",
+    default=True,
+    help="Whether to also fetch the tiles of point-cloud and raster datasets.",
+)
+@click.option(
+    "--progress/--quiet",
+    "do_progress",
+    is_flag=True,
+    default=False,
+    help="Whether to report progress to stderr",
+)
+@click.option(
+    "--output-format",
+    "-o",
+    type=click.Choice(["text", "json"]),
+    default="text",
+    help="json outputs one JSON object per line, each describing one sync.",
+)
+@click.argument("remote")
+@click.argument("local", type=click.Path(file_okay=False))
+def mirror(ctx, interval, do_lfs, do_progress, output_format, remote, local):
+    """
+    Maintain a read-only replica of a remote Kart repository.
+
+    The first time, LOCAL is created as a bare Kart repository. Each sync then fetches every branch and tag from
+    REMOTE. Only fast-forward changes are applied - if a ref in REMOTE is rewritten, it is reported as rejected and
+    left as it was, rather than silently losing history in the replica. Branches and tags deleted from REMOTE are
+    deleted from LOCAL. Pushing to the replica is refused.
+    """
+    local_path = Path(local).resolve()
+    if local_path.exists() and any(local_path.iterdir()):
+        repo = _open_mirror(remote, local_path)
+    else:
+        repo = _init_mirror(remote, local_path, do_progress)
+
+    failed = False
+    while True:
+        result = sync_mirror(repo, do_progress, do_lfs)
+        failed = bool(result["error"] or result["rejected"])
+        if output_format == "json":
+            click.echo(json.dumps({"kart.mirror/v1": result}))
+        else:
+            click.echo(sync_result_to_text(result))
+        sys.stdout.flush()
+
+        if interval is None:
+            break
+        time.sleep(interval)
+
+    if failed:
+        ctx.exit(1)
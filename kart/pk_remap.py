@@ -0,0 +1,80 @@
+import json
+
+from kart.merge_util import rich_conflicts
+
+# When a merge renumbers a feature that was inserted on both branches with the same primary key, the new primary key
+# is recorded as a git note on the merge commit, so that other systems that refer to the feature can follow it.
+# It can be viewed with `kart git notes --ref=kart-pk-remap show COMMIT`
+PK_REMAP_NOTES_REF = "refs/notes/kart-pk-remap"
+
+
+def _renumbered_side(conflict, resolve):
+    """
+    Given an insert/insert feature conflict and its resolve, returns ("ours" or "theirs", renumbered_entry) if the
+    conflict was resolved by keeping both versions and renumbering one of them - otherwise returns None.
+    """
+    versions = conflict.versions
+    kept = [e for e in resolve if e.path == versions.ours.path]
+    renumbered = [e for e in resolve if e.path != versions.ours.path]
+    if len(kept) != 1 or len(renumbered) != 1:
+        return None
+    # Primary key values are only stored in the path of a feature, so a renumbered feature has the same blob as
+    # the version it was copied from.
+    if kept[0].id == versions.ours.id or renumbered[0].id == versions.theirs.id:
+        return "theirs", renumbered[0]
+    if kept[0].id == versions.theirs.id or renumbered[0].id == versions.ours.id:
+        return "ours", renumbered[0]
+    return None
+
+
+def find_pk_remaps(merged_index, merge_context):
+    """
+    Returns a list of dicts describing each feature that was renumbered to resolve an insert/insert conflict in the
+    given MergedIndex - either automatically, or with `kart resolve --renumber`.
+    """
+    result = []
+    conflicts = rich_conflicts(merged_index.resolved_conflicts.items(), merge_context)
+    for conflict in conflicts:
+        versions = conflict.versions
+        if versions.ancestor or not (versions.ours and versions.theirs):
+            continue
+        if not versions.ours.is_feature:
+            continue
+        renumbered = _renumbered_side(conflict, merged_index.resolves[conflict.key])
+        if renumbered is None:
+            continue
+        side, entry = renumbered
+        version = getattr(versions, side)
+        result.append(
+            {
+                "dataset": version.dataset_path,
+                "renumbered": side,
+                "commit": version.context.commit_id.hex,
+                "oldPk": version.pk,
+                "newPk": version.repo_structure.decode_path(entry.path)[2],
+            }
+        )
+    return sorted(result, key=lambda r: (r["dataset"], r["oldPk"]))
+
+
+def record_pk_remaps(repo, commit_id, pk_remaps):
+    """Records the given list of renumbered features as a git note on the given merge commit."""
+    if not pk_remaps:
+        return
+    repo.create_note(
+        json.dumps({"remaps": pk_remaps}, indent=2),
+        repo.author_signature(),
+        repo.committer_signature(),
+        str(commit_id),
+        PK_REMAP_NOTES_REF,
+        True,
+    )
+
+
+def get_pk_remaps(repo, commit):
+    """Returns the list of features that were renumbered by the given merge commit - empty if there are none."""
+    try:
+        note = repo.lookup_note(str(commit.id), PK_REMAP_NOTES_REF)
+    except KeyError:
+        return []
+    return json.loads(note.message)["remaps"]
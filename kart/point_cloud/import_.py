@@ -24,7 +24,7 @@ from kart.point_cloud.v1 import PointCloudV1
 L = logging.getLogger(__name__)
 
 
-@click.command("point-cloud-import", hidden=True, cls=KartCommand)
+@click.command("point-cloud-import", hidden=True, cls=KartCommand, writes_to_repo=True)
 @click.pass_context
 @click.option(
     "--convert-to-copc/--no-convert-to-copc",
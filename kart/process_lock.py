@@ -0,0 +1,134 @@
+import json
+import logging
+import os
+import time
+from datetime import datetime, timezone
+
+import click
+
+from kart.exceptions import FILE_LOCKED, InvalidOperation
+from kart.timestamps import datetime_to_iso8601_utc
+
+L = logging.getLogger("kart.process_lock")
+
+# Where the write lock is kept in the root click context, while it is held.
+WRITE_LOCK_META_KEY = "kart.process_lock"
+
+# How often to check whether the lock has been released, when waiting for it.
+WAIT_INTERVAL_SECONDS = 0.5
+
+# On Windows, locking a byte range stops other processes reading it - so the lock is taken on a byte well past the end
+# of the file, leaving the details of the process that holds it readable.
+WINDOWS_LOCK_OFFSET = 1 << 30
+
+
+def _try_lock(f):
+    """Takes an exclusive lock on the given open file, or returns False if another process already holds one."""
+    try:
+        if os.name == "nt":
+            import msvcrt
+
+            os.lseek(f.fileno(), WINDOWS_LOCK_OFFSET, os.SEEK_SET)
+            msvcrt.locking(f.fileno(), msvcrt.LK_NBLCK, 1)
+        else:
+            import fcntl
+
+            fcntl.flock(f.fileno(), fcntl.LOCK_EX | fcntl.LOCK_NB)
+        return True
+    except OSError:
+        return False
+
+
+class RepoWriteLock:
+    """
+    An advisory lock that is held by a Kart process for as long as it is running a command that writes to the
+    repository. The lock is held by the OS on a file in the Kart repository, so it is released when the process
+    exits, even if it crashes. The file records which process holds the lock, for when another process can't get it.
+    """
+
+    def __init__(self, repo, command_line):
+        from kart.repo import KartRepoFiles
+
+        self.path = repo.gitdir_file(KartRepoFiles.WRITE_LOCK)
+        self.command_line = command_line
+        self._file = None
+
+    def holder(self):
+        """Returns the details of the process that last held the lock, or None if they can't be read."""
+        try:
+            with open(self.path, encoding="utf-8") as f:
+                return json.load(f)
+        except (OSError, ValueError):
+            return None
+
+    def holder_desc(self):
+        holder = self.holder()
+        if not holder:
+            return "another Kart process"
+        return f"Kart process {holder['pid']} ({' '.join(holder['commandLine'])})"
+
+    def acquire(self, wait=False):
+        """
+        Acquires the lock. If another process holds it, raises an InvalidOperation - or if wait is True, waits until
+        the other process releases it.
+        """
+        # Opened with "a+" so that the file is created if need be, but the details of any holder aren't overwritten.
+        f = open(self.path, "a+", encoding="utf-8")
+        waiting = False
+        while not _try_lock(f):
+            if not wait:
+                f.close()
+                raise InvalidOperation(
+                    f"The repository is busy - {self.holder_desc()} is writing to it.\n"
+                    "Try again when it has finished, or use `kart --wait` to wait for it.",
+                    exit_code=FILE_LOCKED,
+                )
+            if not waiting:
+                click.echo(
+                    f"Waiting for {self.holder_desc()} to finish writing to the repository...",
+                    err=True,
+                )
+                waiting = True
+            time.sleep(WAIT_INTERVAL_SECONDS)
+
+        f.seek(0)
+        f.truncate()
+        details = {
+            "pid": os.getpid(),
+            "commandLine": self.command_line,
+            "started": datetime_to_iso8601_utc(datetime.now(timezone.utc)),
+        }
+        f.write(json.dumps(details))
+        f.flush()
+        self._file = f
+        L.debug("Acquired repository write lock: %s", details)
+
+    def release(self):
+        if self._file is not None:
+            self._file.close()
+            self._file = None
+
+
+def start_write_lock(ctx):
+    """
+    Called before any Kart command that writes to the repository runs - see KartCommand.writes_to_repo. Acquires the
+    repository's write lock, which is held until the whole Kart command finishes. Commands that change the
+    repository's refs or working copy must hold it, so that only one of them runs in a repository at a time - for
+    instance, two imports that both start from the same commit would otherwise race to update the branch.
+    """
+    from kart.repo import KartRepo
+
+    root_ctx = ctx.find_root()
+    if root_ctx.meta.get(WRITE_LOCK_META_KEY) is not None:
+        # Already held - eg, the command was invoked by another command that writes to the repository.
+        return
+    try:
+        repo = KartRepo(ctx.obj.repo_path)
+    except Exception:
+        # No repo, or it can't be opened - the command itself will report any problem with the repo.
+        return
+
+    lock = RepoWriteLock(repo, ["kart", *getattr(root_ctx, "unparsed_args", [])])
+    lock.acquire(wait=root_ctx.params.get("wait", False))
+    root_ctx.meta[WRITE_LOCK_META_KEY] = lock
+    root_ctx.call_on_close(lock.release)
@@ -0,0 +1,344 @@
+import sys
+
+import click
+import pygit2
+
+from kart.cli_util import KartCommand, KartGroup
+from kart.completion_shared import repo_path_completer
+from kart.exceptions import InvalidOperation, NotFound, NO_BRANCH, NO_DATA
+from kart.lock import get_changed_dataset_paths
+from kart.output_util import dump_json_output
+from kart import subprocess_util as subprocess
+
+# Each protected branch has its own config subsection, eg:
+#   kart.protect.main.dataset = roads         (multi-valued - if not set, every dataset is protected)
+#   kart.protect.main.allowForcePush = false
+#   kart.protect.main.requireMerge = true
+#   kart.protect.main.requiredCheck = ./validate.sh   (multi-valued)
+# The rules are enforced by the pre-receive hook installed by `kart guardrails install-hook`.
+PROTECT_CONFIG_PREFIX = "kart.protect."
+
+# At most this many of the commits that a push adds or rewrites are checked, so that huge pushes aren't too slow.
+MAX_COMMITS_CHECKED = 1000
+
+
+class BranchProtection:
+    """
+    The rules that protect the given datasets - or all datasets, if none are given - on a branch in a repository that
+    others push to.
+    """
+
+    def __init__(
+        self,
+        branch,
+        datasets=(),
+        *,
+        allow_force_push=False,
+        require_merge=False,
+        required_checks=(),
+    ):
+        self.branch = branch
+        self.datasets = sorted(datasets)
+        self.allow_force_push = allow_force_push
+        self.require_merge = require_merge
+        self.required_checks = list(required_checks)
+
+    @property
+    def config_prefix(self):
+        return f"{PROTECT_CONFIG_PREFIX}{self.branch}."
+
+    def protected_paths(self, ds_paths):
+        """Returns which of the given dataset paths are protected."""
+        if not self.datasets:
+            return set(ds_paths)
+        return set(ds_paths) & set(self.datasets)
+
+    def save(self, config):
+        prefix = self.config_prefix
+        config[f"{prefix}allowForcePush"] = self.allow_force_push
+        config[f"{prefix}requireMerge"] = self.require_merge
+        for ds_path in self.datasets:
+            config.set_multivar(f"{prefix}dataset", "^$", ds_path)
+        for command in self.required_checks:
+            config.set_multivar(f"{prefix}requiredCheck", "^$", command)
+
+    def as_json(self):
+        return {
+            "branch": self.branch,
+            "datasets": self.datasets or None,
+            "allowForcePush": self.allow_force_push,
+            "requireMerge": self.require_merge,
+            "requiredChecks": self.required_checks,
+        }
+
+    def __str__(self):
+        datasets = ", ".join(self.datasets) if self.datasets else "all datasets"
+        rules = []
+        if not self.allow_force_push:
+            rules.append("no force-push")
+        if self.require_merge:
+            rules.append("changes only by merging")
+        rules.extend(f"check `{c}`" for c in self.required_checks)
+        return f"{self.branch} ({datasets}): {'; '.join(rules) or 'no rules'}"
+
+
+def get_branch_protections(repo):
+    """Returns a dict of {branch_name: BranchProtection} for every protected branch in the repository config."""
+    config = repo.config
+    entries = {}
+    for entry in config:
+        if not entry.name.startswith(PROTECT_CONFIG_PREFIX):
+            continue
+        # Branch names can contain dots, but the key never does.
+        branch, _, key = entry.name[len(PROTECT_CONFIG_PREFIX) :].rpartition(".")
+        if branch:
+            entries.setdefault(branch, []).append((key.lower(), entry))
+
+    result = {}
+    for branch, items in entries.items():
+        values = {}
+        for key, entry in items:
+            if key in ("allowforcepush", "requiremerge"):
+                values[key] = config.get_bool(entry.name)
+            else:
+                values.setdefault(key, []).append(entry.value)
+        result[branch] = BranchProtection(
+            branch,
+            values.get("dataset", ()),
+            allow_force_push=values.get("allowforcepush", False),
+            require_merge=values.get("requiremerge", False),
+            required_checks=values.get("requiredcheck", ()),
+        )
+    return result
+
+
+def _delete_protection(config, branch):
+    prefix = f"{PROTECT_CONFIG_PREFIX}{branch}."
+    names = {entry.name for entry in config if entry.name.startswith(prefix)}
+    for name in names:
+        config.delete_multivar(name, ".*")
+
+
+def _changed_protected_paths(repo, protection, commit):
+    parent = commit.parents[0] if commit.parents else None
+    return protection.protected_paths(
+        get_changed_dataset_paths(repo, parent, commit)
+    )
+
+
+def _walk(repo, include_id, exclude_id, first_parent=False):
+    walker = repo.walk(include_id, pygit2.GIT_SORT_TOPOLOGICAL)
+    if first_parent:
+        walker.simplify_first_parent()
+    walker.hide(exclude_id)
+    for i, commit in enumerate(walker):
+        if i >= MAX_COMMITS_CHECKED:
+            break
+        yield commit
+
+
+def _run_required_check(repo, command, ref_name, old_commit, new_commit):
+    return subprocess.run(
+        command,
+        shell=True,
+        cwd=repo.path,
+        stdin=subprocess.DEVNULL,
+        env_overrides={
+            "KART_PROTECT_REF": ref_name,
+            "KART_PROTECT_OLD": old_commit.hex,
+            "KART_PROTECT_NEW": new_commit.hex,
+        },
+    ).returncode
+
+
+def find_protection_violations(repo, protection, ref_name, old_id, new_id):
+    """
+    Yields a line describing each way in which updating ref_name from old_id to new_id - either of which can be None,
+    if the ref is being created or deleted - would break the given protection's rules.
+    """
+    if old_id is None:
+        # A new branch doesn't change anything that is already protected.
+        return
+    if new_id is None:
+        if not protection.allow_force_push:
+            yield "protected branches can't be deleted"
+        return
+
+    old_commit = repo[old_id].peel(pygit2.Commit)
+    new_commit = repo[new_id].peel(pygit2.Commit)
+    if old_commit.id == new_commit.id:
+        return
+
+    found = False
+    if not protection.allow_force_push and not repo.descendant_of(
+        new_commit.id, old_commit.id
+    ):
+        rewritten = set()
+        for commit in _walk(repo, old_commit.id, new_commit.id):
+            rewritten |= _changed_protected_paths(repo, protection, commit)
+        if rewritten:
+            found = True
+            yield f"force-pushing would rewrite the history of {', '.join(sorted(rewritten))}"
+
+    if protection.require_merge:
+        for commit in _walk(repo, new_commit.id, old_commit.id, first_parent=True):
+            if len(commit.parents) > 1:
+                continue
+            changed = _changed_protected_paths(repo, protection, commit)
+            if changed:
+                found = True
+                yield (
+                    f"commit {commit.short_id} changes {', '.join(sorted(changed))} directly - "
+                    "protected datasets can only be changed by merging"
+                )
+
+    if found or not protection.required_checks:
+        return
+    if not protection.protected_paths(
+        get_changed_dataset_paths(repo, old_commit, new_commit)
+    ):
+        return
+    for command in protection.required_checks:
+        returncode = _run_required_check(
+            repo, command, ref_name, old_commit, new_commit
+        )
+        if returncode != 0:
+            yield f"required check `{command}` failed with exit code {returncode}"
+
+
+def check_protected_ref_update(repo, ref_name, old_id, new_id):
+    """
+    Raises an InvalidOperation if updating ref_name from old_id to new_id would break the rules of a protected
+    branch - see find_protection_violations.
+    """
+    if not ref_name.startswith("refs/heads/"):
+        return
+    branch = ref_name[len("refs/heads/") :]
+    protection = get_branch_protections(repo).get(branch)
+    if protection is None:
+        return
+    violations = list(
+        find_protection_violations(repo, protection, ref_name, old_id, new_id)
+    )
+    if violations:
+        desc = "\n".join(f"  {v}" for v in violations)
+        raise InvalidOperation(f"Can't update protected branch {branch}:\n{desc}")
+
+
+@click.group(cls=KartGroup)
+@click.pass_context
+def protect(ctx, **kwargs):
+    """
+    Protect the datasets on a branch of a repository that others push to.
+
+    Pushes that break the rules of a protected branch are rejected by the pre-receive hook - install it with
+    `kart guardrails install-hook`. Unless other datasets are specified, the rules protect every dataset on the branch;
+    otherwise, pushes that only change unprotected datasets are always accepted.
+    """
+
+
+@protect.command("add", cls=KartCommand, writes_to_repo=True)
+@click.pass_context
+@click.option(
+    "--allow-force-push",
+    is_flag=True,
+    help="Allow pushes that rewrite the history of the protected datasets, or delete the branch.",
+)
+@click.option(
+    "--require-merge",
+    is_flag=True,
+    help=(
+        "Only accept changes to the protected datasets that arrive in merge commits - eg from a merge queue or a "
+        "reviewed branch - rather than in commits made directly on the branch."
+    ),
+)
+@click.option(
+    "--require-check",
+    "required_checks",
+    multiple=True,
+    metavar="COMMAND",
+    help=(
+        "A shell command that must succeed before a push that changes the protected datasets is accepted. It is run "
+        "in the repository, with $KART_PROTECT_REF, $KART_PROTECT_OLD and $KART_PROTECT_NEW set to the ref and the "
+        "commits it is being updated from and to. Can be given more than once."
+    ),
+)
+@click.argument("branch")
+@click.argument("datasets", nargs=-1, shell_complete=repo_path_completer)
+def add(ctx, allow_force_push, require_merge, required_checks, branch, datasets):
+    """
+    Protect a branch, or only some of the datasets on it - replacing any existing protection of the branch.
+
+    Unless --allow-force-push is specified, protected datasets can't have their history rewritten, and the branch
+    can't be deleted.
+    """
+    repo = ctx.obj.repo
+    if f"refs/heads/{branch}" not in repo.references:
+        raise NotFound(f"No such branch: {branch}", exit_code=NO_BRANCH)
+    if datasets:
+        existing = {ds.path for ds in repo.datasets(f"refs/heads/{branch}")}
+        missing = [p for p in datasets if p not in existing]
+        if missing:
+            raise NotFound(
+                f"No such dataset on {branch}: {', '.join(missing)}",
+                exit_code=NO_DATA,
+            )
+
+    protection = BranchProtection(
+        branch,
+        datasets,
+        allow_force_push=allow_force_push,
+        require_merge=require_merge,
+        required_checks=required_checks,
+    )
+    _delete_protection(repo.config, branch)
+    protection.save(repo.config)
+    click.echo(f"Protected {protection}")
+
+    hook_path = repo.gitdir_path / "hooks" / "pre-receive"
+    if not hook_path.exists():
+        click.echo(
+            "Warning: the rules won't be enforced until the pre-receive hook is installed - "
+            "see `kart guardrails install-hook`",
+            err=True,
+        )
+
+
+@protect.command("remove", cls=KartCommand, writes_to_repo=True)
+@click.pass_context
+@click.argument("branch")
+def remove(ctx, branch):
+    """Stop protecting a branch."""
+    repo = ctx.obj.repo
+    if branch not in get_branch_protections(repo):
+        raise NotFound(f"Branch {branch} isn't protected", exit_code=NO_BRANCH)
+    _delete_protection(repo.config, branch)
+    click.echo(f"Branch {branch} is no longer protected")
+
+
+@protect.command("list", cls=KartCommand)
+@click.pass_context
+@click.option(
+    "--output-format",
+    "-o",
+    type=click.Choice(["text", "json"]),
+    default="text",
+)
+def list_protections(ctx, output_format):
+    """List the protected branches, and their rules."""
+    repo = ctx.obj.repo
+    protections = get_branch_protections(repo)
+    if output_format == "json":
+        dump_json_output(
+            {
+                "kart.protect/v1": [
+                    protections[b].as_json() for b in sorted(protections)
+                ]
+            },
+            sys.stdout,
+        )
+    elif not protections:
+        click.echo("No branches are protected")
+    else:
+        for branch in sorted(protections):
+            click.echo(str(protections[branch]))
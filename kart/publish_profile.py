@@ -0,0 +1,296 @@
+import json
+import logging
+import re
+import sys
+
+import click
+
+from kart.cli_util import JsonFromFile, KartCommand, KartGroup
+from kart.exceptions import InvalidOperation, NotFound, NO_DATA
+from kart.output_util import dump_json_output
+from kart.tabular.redaction import (
+    ALL_DATASETS,
+    EXCLUDE,
+    REDACTION_SCHEMA,
+    RedactedTableDataset,
+)
+
+L = logging.getLogger("kart.publish_profile")
+
+# By convention, profiles are kept in a profiles subfolder - the same way spatial filters are kept in refs/filters.
+PROFILE_REF_PREFIX = "refs/profiles/"
+
+PROFILE_NAME_PATTERN = re.compile(r"^[A-Za-z0-9][A-Za-z0-9_.-]*$")
+
+# Operators that can be used in a profile's "where" clause - see FilteredTableDataset.
+OPERATORS = {
+    "=": lambda value, operand: value == operand,
+    "!=": lambda value, operand: value != operand,
+    "<": lambda value, operand: value < operand,
+    "<=": lambda value, operand: value <= operand,
+    ">": lambda value, operand: value > operand,
+    ">=": lambda value, operand: value >= operand,
+    "in": lambda value, operand: value in operand,
+    "notIn": lambda value, operand: value not in operand,
+}
+IS_NULL = "isNull"
+
+PROFILE_SCHEMA = {
+    "type": "object",
+    "$schema": "http://json-schema.org/draft-07/schema",
+    "properties": {
+        "description": {"type": "string"},
+        "datasets": {"type": "array", "items": {"type": "string"}},
+        "spatialFilter": {
+            "type": "object",
+            "properties": {
+                "crs": {"type": "string"},
+                "geometry": {"type": "string"},
+            },
+            "required": ["crs", "geometry"],
+            "additionalProperties": False,
+        },
+        "where": {
+            "type": "object",
+            "patternProperties": {
+                ".*": {
+                    "anyOf": [
+                        {"type": ["string", "number", "boolean", "null"]},
+                        {
+                            "type": "object",
+                            "propertyNames": {"enum": [*OPERATORS, IS_NULL]},
+                        },
+                    ]
+                }
+            },
+        },
+        "columns": {"type": "array", "items": {"type": "string"}},
+        "redact": {
+            "type": "object",
+            "patternProperties": REDACTION_SCHEMA["patternProperties"],
+        },
+    },
+    "additionalProperties": False,
+}
+
+
+def _profile_ref(name):
+    # Profiles are always kept under refs/profiles/ - a profile name mustn't be able to overwrite any other ref.
+    if not PROFILE_NAME_PATTERN.match(name):
+        raise click.BadParameter(
+            f"Invalid publish profile name {name!r} - profile names can only contain letters, numbers, "
+            "'_', '-' and '.'"
+        )
+    return f"{PROFILE_REF_PREFIX}{name}"
+
+
+class PublishProfile:
+    """
+    A named, reusable definition of a published product derived from the datasets in a repository -
+    which datasets are published, which features (by spatial extent and attribute values), and which columns,
+    with any redaction rules applied. Profiles are stored as JSON blobs at refs/profiles/NAME, so they can be
+    pushed and fetched like any other ref.
+    """
+
+    def __init__(self, name, definition):
+        self.name = name
+        self.definition = definition
+        self.spatial_filter = None
+        if "spatialFilter" in definition:
+            from kart.spatial_filter import OriginalSpatialFilter
+
+            sf = definition["spatialFilter"]
+            self.spatial_filter = OriginalSpatialFilter(sf["crs"], sf["geometry"])
+
+    @classmethod
+    def load(cls, repo, name):
+        ref = _profile_ref(name)
+        if ref not in repo.references:
+            raise NotFound(f"No publish profile found at {ref}", exit_code=NO_DATA)
+        blob = repo[repo.references[ref].resolve().target]
+        try:
+            return cls(name, json.loads(blob.data))
+        except ValueError as e:
+            raise InvalidOperation(f"Couldn't parse publish profile {name}: {e}")
+
+    def save(self, repo):
+        oid = repo.create_blob(json.dumps(self.definition, indent=2).encode("utf-8"))
+        repo.references.create(_profile_ref(self.name), oid, force=True)
+
+    def includes_dataset(self, ds_path):
+        datasets = self.definition.get("datasets")
+        return not datasets or ds_path in datasets
+
+    def redaction_rules(self, dataset):
+        """
+        Returns the redaction rules for the given dataset: the profile's own redaction rules, plus an "exclude" rule
+        for every column not in the profile's column list (if it has one). Primary key columns are always included.
+        """
+        rules = {}
+        columns = self.definition.get("columns")
+        if columns is not None:
+            for column in dataset.schema.columns:
+                if column.name not in columns and column.pk_index is None:
+                    rules[column.name] = EXCLUDE
+        redact = self.definition.get("redact", {})
+        for key in (ALL_DATASETS, dataset.path):
+            rules.update(redact.get(key, {}))
+        return rules
+
+    def apply(self, dataset):
+        """Returns the given dataset as it would be published by this profile."""
+        if self.spatial_filter is not None or self.definition.get("where"):
+            dataset = FilteredTableDataset(
+                dataset, self.spatial_filter, self.definition.get("where", {})
+            )
+        return RedactedTableDataset.wrap_if_needed(
+            dataset, {dataset.path: self.redaction_rules(dataset)}
+        )
+
+
+def _matches_condition(value, condition):
+    if not isinstance(condition, dict):
+        return value == condition
+    for op, operand in condition.items():
+        if op == IS_NULL:
+            if (value is None) != bool(operand):
+                return False
+        elif value is None:
+            return False
+        else:
+            try:
+                if not OPERATORS[op](value, operand):
+                    return False
+            except TypeError:
+                return False
+    return True
+
+
+class FilteredTableDataset:
+    """
+    Wraps a table dataset so that only features that match the given spatial filter and "where" clause are
+    yielded. The where clause is an object mapping column names to conditions - each condition is either a
+    value that the column must equal, or an object of {operator: operand} eg {">=": 10, "<": 20},
+    using the operators =, !=, <, <=, >, >=, in, notIn and isNull. Features must match every condition.
+    """
+
+    def __init__(self, delegate, spatial_filter, where):
+        self.delegate = delegate
+        self.spatial_filter = spatial_filter
+        self.where = where
+        column_names = {c.name for c in delegate.schema.columns}
+        missing = [c for c in where if c not in column_names]
+        if missing:
+            raise InvalidOperation(
+                f"Publish profile refers to {', '.join(missing)}, which {delegate.path} doesn't have"
+            )
+
+    def __getattr__(self, name):
+        return getattr(self.delegate, name)
+
+    def features(self, **kwargs):
+        if self.spatial_filter is not None:
+            kwargs["spatial_filter"] = self.spatial_filter
+        for feature in self.delegate.features(**kwargs):
+            if all(
+                _matches_condition(feature.get(k), v) for k, v in self.where.items()
+            ):
+                yield feature
+
+    @property
+    def feature_count(self):
+        # Only the features that match the filter are counted, so this has to read every feature.
+        return sum(1 for feature in self.features())
+
+    def __str__(self):
+        return str(self.delegate)
+
+
+def get_profile_names(repo):
+    return sorted(
+        r[len(PROFILE_REF_PREFIX) :]
+        for r in repo.references
+        if r.startswith(PROFILE_REF_PREFIX)
+        and PROFILE_NAME_PATTERN.match(r[len(PROFILE_REF_PREFIX) :])
+    )
+
+
+@click.group(cls=KartGroup)
+@click.pass_context
+def profile(ctx, **kwargs):
+    """
+    Manage publish profiles - named definitions of which datasets, features and columns are published, so that
+    one canonical dataset can consistently feed several redacted products. Use a profile with `kart export --profile`.
+
+    Profiles are stored at refs/profiles/NAME. Share them with `kart push REMOTE "refs/profiles/*"`.
+    """
+
+
+@profile.command(cls=KartCommand, name="set", writes_to_repo=True)
+@click.pass_context
+@click.argument("name")
+@click.argument(
+    "definition", type=JsonFromFile(encoding="utf-8", schema=PROFILE_SCHEMA)
+)
+def profile_set(ctx, name, definition):
+    """
+    Create or replace the publish profile NAME. DEFINITION is a JSON object or @filename of a JSON file, eg:
+
+    \b
+    {
+      "datasets": ["roads"],
+      "spatialFilter": {"crs": "EPSG:4326", "geometry": "POLYGON((...))"},
+      "where": {"status": "open", "lanes": {">=": 2}},
+      "columns": ["name", "geom", "lanes"],
+      "redact": {"*": {"name": "truncate:20"}}
+    }
+
+    All keys are optional. See `kart export --help` for the supported redaction rules.
+    """
+    repo = ctx.obj.repo
+    publish_profile = PublishProfile(name, definition)
+    publish_profile.save(repo)
+    click.echo(f"Saved publish profile {name} to {_profile_ref(name)}")
+
+
+@profile.command(cls=KartCommand, name="list")
+@click.pass_context
+@click.option(
+    "--output-format",
+    "-o",
+    type=click.Choice(["text", "json"]),
+    default="text",
+)
+def profile_list(ctx, output_format):
+    """List the publish profiles in this repository."""
+    repo = ctx.obj.repo
+    names = get_profile_names(repo)
+    if output_format == "json":
+        profiles = {n: PublishProfile.load(repo, n).definition for n in names}
+        dump_json_output({"kart.profiles/v1": profiles}, sys.stdout)
+        return
+    for name in names:
+        description = PublishProfile.load(repo, name).definition.get("description")
+        click.echo(f"{name}  {description}" if description else name)
+
+
+@profile.command(cls=KartCommand, name="show")
+@click.pass_context
+@click.argument("name")
+def profile_show(ctx, name):
+    """Show the definition of the publish profile NAME."""
+    repo = ctx.obj.repo
+    dump_json_output(PublishProfile.load(repo, name).definition, sys.stdout)
+
+
+@profile.command(cls=KartCommand, name="delete", writes_to_repo=True)
+@click.pass_context
+@click.argument("name")
+def profile_delete(ctx, name):
+    """Delete the publish profile NAME."""
+    repo = ctx.obj.repo
+    ref = _profile_ref(name)
+    if ref not in repo.references:
+        raise NotFound(f"No publish profile found at {ref}", exit_code=NO_DATA)
+    repo.references.delete(ref)
+    click.echo(f"Deleted publish profile {name}")
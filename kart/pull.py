@@ -2,7 +2,7 @@ import logging
 
 import click
 
-from kart.cli_util import KartCommand
+from kart.cli_util import KartCommand, tls_git_config_args, tls_options
 from kart.completion_shared import ref_completer
 from kart.exceptions import NO_BRANCH, NotFound
 from kart import merge
@@ -11,7 +11,7 @@ from kart import subprocess_util as subprocess
 L = logging.getLogger("kart.pull")
 
 
-@click.command(cls=KartCommand)
+@click.command(cls=KartCommand, writes_to_repo=True)
 @click.option(
     "--ff/--no-ff",
     default=True,
@@ -43,12 +43,15 @@ L = logging.getLogger("kart.pull")
     default=True,
     help="Whether to report progress to stderr",
 )
+@tls_options
 @click.argument("repository", required=False, metavar="REMOTE")
 @click.argument(
     "refspecs", nargs=-1, required=False, metavar="REFISH", shell_complete=ref_completer
 )
 @click.pass_context
-def pull(ctx, ff, ff_only, launch_editor, do_progress, repository, refspecs):
+def pull(
+    ctx, ff, ff_only, launch_editor, do_progress, cert, key, ca, repository, refspecs
+):
     """Fetch from and integrate with another repository or a local branch"""
     repo = ctx.obj.repo
 
@@ -89,6 +92,7 @@ def pull(ctx, ff, ff_only, launch_editor, do_progress, repository, refspecs):
             "git",
             "-C",
             str(ctx.obj.repo_path),
+            *tls_git_config_args(cert, key, ca),
             "fetch",
             "--progress" if do_progress else "--quiet",
             repository,
@@ -103,5 +107,7 @@ def pull(ctx, ff, ff_only, launch_editor, do_progress, repository, refspecs):
         ff=ff,
         ff_only=ff_only,
         launch_editor=launch_editor,
+        # The changes have already been pushed to the remote, so it's too late for the guardrails to stop them.
+        allow_large_changes=True,
         commit="FETCH_HEAD",
     )
@@ -23,7 +23,7 @@ from kart.tile.tilename_util import PAM_SUFFIX
 L = logging.getLogger(__name__)
 
 
-@click.command("raster-import", hidden=True, cls=KartCommand)
+@click.command("raster-import", hidden=True, cls=KartCommand, writes_to_repo=True)
 @click.option(
     "--convert-to-cog/--no-convert-to-cog",
     "--cloud-optimized/--no-cloud-optimized",
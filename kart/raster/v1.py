@@ -46,6 +46,7 @@ class RasterV1(TileDataset):
     META_ITEMS = (
         TileDataset.TITLE,
         TileDataset.DESCRIPTION,
+        TileDataset.README,
         TileDataset.TAGS_JSON,
         TileDataset.FORMAT_JSON,
         TileDataset.SCHEMA_JSON,
@@ -2,6 +2,8 @@ import shutil
 
 import reflink as rl
 
+from kart.fs_util import long_path, retry_if_locked
+
 
 def reflink(from_, to):
     """Same as reflink.reflink, but can be called with pathlib.Path objects."""
@@ -9,7 +11,10 @@ def reflink(from_, to):
 
 
 def try_reflink(from_, to):
-    """Same as reflink_util.reflink, but falls back to shutil.copy if reflinking fails."""
+    """
+    Same as reflink_util.reflink, but falls back to shutil.copy if reflinking fails.
+    Supports long paths on Windows, and retries the copy if the destination is briefly locked by another process.
+    """
 
     assert not to.exists()
 
@@ -17,4 +22,6 @@ def try_reflink(from_, to):
         return reflink(from_, to)
     except (rl.ReflinkImpossibleError, NotImplementedError):
         pass
-    return shutil.copy(from_, to)
+    return retry_if_locked(
+        lambda to_path: shutil.copy(long_path(from_), to_path), to
+    )
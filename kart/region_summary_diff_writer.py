@@ -0,0 +1,293 @@
+import logging
+import math
+
+import click
+from osgeo import ogr, osr
+
+from kart.base_diff_writer import BaseDiffWriter
+from kart.diff_format import DiffFormat
+from kart.exceptions import InvalidOperation, INVALID_FILE_FORMAT
+from kart.output_util import dump_json_output, resolve_output_path
+
+L = logging.getLogger("kart.region_summary_diff_writer")
+
+# Changed features that have no geometry - either before or after the change - are counted under this key.
+NO_GEOMETRY = "no geometry"
+CHANGE_TYPES = ("insert", "update", "delete")
+
+
+class Region:
+    """A grid cell or a user-supplied polygon that changes are counted in."""
+
+    def __init__(self, name, ogr_geom):
+        self.name = name
+        self.ogr_geom = ogr_geom
+        self.envelope = ogr_geom.GetEnvelope() if ogr_geom is not None else None
+        self.counts = dict.fromkeys(CHANGE_TYPES, 0)
+
+    @property
+    def total(self):
+        return sum(self.counts.values())
+
+    def to_json(self):
+        result = {"region": self.name}
+        if self.envelope is not None:
+            min_x, max_x, min_y, max_y = self.envelope
+            result["bbox"] = [min_x, min_y, max_x, max_y]
+        result.update({f"{t}s": c for t, c in self.counts.items()})
+        result["total"] = self.total
+        return result
+
+
+def load_regions(path):
+    """
+    Loads the polygons in the first layer of the given OGR-readable file - eg a GeoJSON file or a GPKG - as
+    (name, ogr-geometry) pairs. Each polygon is named after its "name" field, if it has one, or its FID.
+    """
+    ogr_ds = ogr.Open(str(path))
+    if ogr_ds is None:
+        raise InvalidOperation(
+            f"Couldn't read regions from {path}", exit_code=INVALID_FILE_FORMAT
+        )
+    layer = ogr_ds.GetLayer(0)
+    srs = layer.GetSpatialRef()
+    if srs is not None:
+        srs.SetAxisMappingStrategy(osr.OAMS_TRADITIONAL_GIS_ORDER)
+    name_index = layer.GetLayerDefn().GetFieldIndex("name")
+    regions = []
+    for feature in layer:
+        geom = feature.GetGeometryRef()
+        if geom is None or geom.IsEmpty():
+            continue
+        name = feature.GetField(name_index) if name_index >= 0 else None
+        if name is None:
+            name = feature.GetFID()
+        regions.append((str(name), geom.Clone()))
+    if not regions:
+        raise InvalidOperation(f"No polygons found in {path}")
+    return srs, regions
+
+
+class RegionSummaryDiffWriter(BaseDiffWriter):
+    """
+    Summarises where features changed, rather than how - each changed feature is counted in the grid cells or the
+    user-supplied regions that its old and new geometries fall in. The summary is written as text or JSON, and can
+    also be written as a layer of polygons per dataset to a GPKG, for viewing as a heatmap.
+    """
+
+    def __init__(
+        self,
+        *args,
+        output_format="text",
+        grid_size=None,
+        regions_path=None,
+        heatmap_path=None,
+        **kwargs,
+    ):
+        super().__init__(*args, **kwargs)
+        if (grid_size is None) == (regions_path is None):
+            raise click.UsageError(
+                "Specify exactly one of --summarize-by-grid or --summarize-by-regions"
+            )
+        self.output_format = output_format
+        self.grid_size = grid_size
+        self.heatmap_path = heatmap_path
+        self.regions_srs, self.region_geoms = (
+            load_regions(regions_path) if regions_path is not None else (None, None)
+        )
+        # {ds_path: {region-key: Region}}
+        self.summaries = {}
+        self.crs_by_ds_path = {}
+
+    def write_diff(self, diff_format=DiffFormat.FULL):
+        if diff_format != DiffFormat.FULL:
+            raise click.UsageError("Region summaries only support full diffs")
+        self.has_changes = False
+        for ds_path in self.all_ds_paths:
+            dataset = self._get_old_or_new_dataset(ds_path)
+            ds_diff = self.get_dataset_diff(ds_path)
+            self.has_changes |= bool(ds_diff)
+            if dataset.DATASET_TYPE != "table":
+                if ds_diff:
+                    click.echo(
+                        f"Warning: changes to {ds_path} aren't summarised - only table datasets are supported",
+                        err=True,
+                    )
+                continue
+            if "feature" in ds_diff:
+                self.summaries[ds_path] = self.summarise_dataset(ds_path, ds_diff)
+
+        if self.output_format == "json":
+            dump_json_output(
+                {
+                    "kart.diff-summary/v1": {
+                        ds_path: [
+                            region.to_json()
+                            for region in self._sorted_regions(summary)
+                        ]
+                        for ds_path, summary in self.summaries.items()
+                    }
+                },
+                self.output_path,
+                json_style=self.json_style,
+            )
+        else:
+            self.write_text_summary()
+        if self.heatmap_path is not None:
+            self.write_heatmap()
+        self.write_warnings_footer()
+
+    def summarise_dataset(self, ds_path, ds_diff):
+        old_crs, new_crs = self.get_old_and_new_crs(ds_path, ds_diff)
+        self.crs_by_ds_path[ds_path] = new_crs or old_crs
+        old_schema, new_schema = self._get_old_and_new_schema(ds_path, ds_diff)
+        old_geom_column = self._geom_column_name(old_schema)
+        new_geom_column = self._geom_column_name(new_schema)
+        old_regions = self._regions_in_crs(old_crs)
+        new_regions = self._regions_in_crs(new_crs)
+        old_transform = None
+        if old_crs is not None and new_crs is not None and not old_crs.IsSame(new_crs):
+            # The CRS changed - old geometries are reprojected into the new CRS, so that they are counted in the same
+            # grid cells as new geometries.
+            old_transform = osr.CoordinateTransformation(old_crs, new_crs)
+            old_regions = new_regions
+
+        summary = {}
+        for key, delta in self.filtered_dataset_deltas(ds_path, ds_diff):
+            region_keys = set()
+            if delta.old is not None:
+                region_keys |= self._region_keys(
+                    delta.old_value.get(old_geom_column) if old_geom_column else None,
+                    old_regions,
+                    transform=old_transform,
+                )
+            if delta.new is not None:
+                region_keys |= self._region_keys(
+                    delta.new_value.get(new_geom_column) if new_geom_column else None,
+                    new_regions,
+                )
+            # A feature that moved from one region to another is counted in both.
+            for region_key in region_keys:
+                region = summary.get(region_key)
+                if region is None:
+                    region = summary[region_key] = self._make_region(
+                        region_key, new_regions or old_regions
+                    )
+                region.counts[delta.type] += 1
+        return summary
+
+    @staticmethod
+    def _geom_column_name(schema):
+        if schema is None or not schema.geometry_columns:
+            return None
+        return schema.geometry_columns[0].name
+
+    def _regions_in_crs(self, crs):
+        """Returns the user-supplied regions as a list of (name, ogr-geometry), reprojected into the given CRS."""
+        if self.region_geoms is None:
+            return None
+        if crs is None or self.regions_srs is None or crs.IsSame(self.regions_srs):
+            return self.region_geoms
+        transform = osr.CoordinateTransformation(self.regions_srs, crs)
+        result = []
+        for name, geom in self.region_geoms:
+            geom = geom.Clone()
+            geom.Transform(transform)
+            result.append((name, geom))
+        return result
+
+    def _region_keys(self, geom, regions, transform=None):
+        if geom is None or geom.is_empty():
+            return {NO_GEOMETRY}
+        ogr_geom = None
+        if transform is not None:
+            ogr_geom = geom.to_ogr()
+            ogr_geom.Transform(transform)
+        if regions is None:
+            # Grid cells - the feature is counted in the cell that contains the centre of its envelope.
+            if ogr_geom is not None:
+                min_x, max_x, min_y, max_y = ogr_geom.GetEnvelope()
+            else:
+                min_x, max_x, min_y, max_y = geom.envelope(
+                    only_2d=True, calculate_if_missing=True
+                )
+            centre_x, centre_y = (min_x + max_x) / 2, (min_y + max_y) / 2
+            return {
+                (
+                    math.floor(centre_x / self.grid_size),
+                    math.floor(centre_y / self.grid_size),
+                )
+            }
+        if ogr_geom is None:
+            ogr_geom = geom.to_ogr()
+        keys = {
+            name for name, region_geom in regions if region_geom.Intersects(ogr_geom)
+        }
+        return keys or {None}
+
+    def _make_region(self, region_key, regions):
+        if region_key == NO_GEOMETRY:
+            return Region(NO_GEOMETRY, None)
+        if region_key is None:
+            return Region("outside all regions", None)
+        if regions is None:
+            col, row = region_key
+            size = self.grid_size
+            ring = ogr.Geometry(ogr.wkbLinearRing)
+            for x, y in ((0, 0), (1, 0), (1, 1), (0, 1), (0, 0)):
+                ring.AddPoint_2D((col + x) * size, (row + y) * size)
+            cell = ogr.Geometry(ogr.wkbPolygon)
+            cell.AddGeometry(ring)
+            return Region(f"{col},{row}", cell)
+        return Region(region_key, dict(regions)[region_key].Clone())
+
+    @staticmethod
+    def _sorted_regions(summary):
+        return sorted(summary.values(), key=lambda r: (-r.total, r.name))
+
+    def write_text_summary(self):
+        fp = resolve_output_path(self.output_path)
+        if not self.summaries:
+            click.echo("No feature changes", file=fp)
+        for ds_path, summary in self.summaries.items():
+            click.secho(f"{ds_path}:", bold=True, file=fp)
+            for region in self._sorted_regions(summary):
+                counts = ", ".join(f"{c} {t}s" for t, c in region.counts.items() if c)
+                desc = region.name
+                if self.grid_size is not None and region.envelope is not None:
+                    min_x, max_x, min_y, max_y = region.envelope
+                    desc = f"{min_x:g},{min_y:g} - {max_x:g},{max_y:g}"
+                click.echo(
+                    f"\t{desc}: {region.total} features changed ({counts})", file=fp
+                )
+
+    def write_heatmap(self):
+        """Writes a layer of polygons for each dataset - the regions with changes, and how many."""
+        driver = ogr.GetDriverByName("GPKG")
+        ogr_ds = driver.CreateDataSource(str(self.heatmap_path))
+        if ogr_ds is None:
+            raise InvalidOperation(f"Couldn't create {self.heatmap_path}")
+        for ds_path, summary in self.summaries.items():
+            crs = self.crs_by_ds_path.get(ds_path)
+            layer = ogr_ds.CreateLayer(
+                ds_path.replace("/", "__"), srs=crs, geom_type=ogr.wkbPolygon
+            )
+            layer.CreateField(ogr.FieldDefn("region", ogr.OFTString))
+            for change_type in CHANGE_TYPES:
+                layer.CreateField(ogr.FieldDefn(f"{change_type}s", ogr.OFTInteger))
+            layer.CreateField(ogr.FieldDefn("total", ogr.OFTInteger))
+            layer_defn = layer.GetLayerDefn()
+            layer.StartTransaction()
+            for region in self._sorted_regions(summary):
+                if region.ogr_geom is None:
+                    continue
+                feature = ogr.Feature(layer_defn)
+                feature.SetGeometry(region.ogr_geom)
+                feature.SetField("region", region.name)
+                for change_type, count in region.counts.items():
+                    feature.SetField(f"{change_type}s", count)
+                feature.SetField("total", region.total)
+                layer.CreateFeature(feature)
+            layer.CommitTransaction()
+        ogr_ds = None
+        click.echo(f"Wrote heatmap: {self.heatmap_path}", err=True)
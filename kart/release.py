@@ -0,0 +1,415 @@
+import hashlib
+import json
+import logging
+import re
+import sys
+from datetime import datetime, timezone
+from pathlib import Path
+
+import click
+import pygit2
+
+from kart.changelog import dataset_extent
+from kart.cli_util import KartCommand, KartGroup
+from kart.completion_shared import ref_completer
+from kart.exceptions import (
+    InvalidOperation,
+    NotFound,
+    INVALID_ARGUMENT,
+    NO_DATA,
+)
+from kart.output_util import dump_json_output
+from kart.timestamps import datetime_to_iso8601_utc
+
+L = logging.getLogger("kart.release")
+
+SEMVER_PATTERN = re.compile(
+    r"^v?(?P<major>0|[1-9]\d*)\.(?P<minor>0|[1-9]\d*)\.(?P<patch>0|[1-9]\d*)"
+    r"(?:-(?P<prerelease>[0-9A-Za-z.-]+))?$"
+)
+
+# The release manifest is stored as JSON in the release tag's message, after this line.
+MANIFEST_MARKER = "--- kart.release/v1 ---"
+
+
+def parse_version(version):
+    """
+    Returns a tuple that can be used to sort semantic versions. Releases sort after their pre-releases.
+    Raises ValueError if version isn't a semantic version eg v1.2.0 or 1.2.0-beta.1
+    """
+    match = SEMVER_PATTERN.match(version)
+    if not match:
+        raise ValueError(version)
+    prerelease = match.group("prerelease")
+    return (
+        int(match.group("major")),
+        int(match.group("minor")),
+        int(match.group("patch")),
+        prerelease is None,
+        _prerelease_key(prerelease) if prerelease else (),
+    )
+
+
+def _prerelease_key(prerelease):
+    # As per semver: numeric identifiers are compared numerically, and sort before alphanumeric identifiers, which
+    # are compared as strings. If all of the identifiers are equal, the one with more identifiers sorts last.
+    return tuple(
+        (0, int(identifier), "") if identifier.isdigit() else (1, 0, identifier)
+        for identifier in prerelease.split(".")
+    )
+
+
+def release_tag_message(manifest, message=None):
+    summary = message or f"Release {manifest['version']}"
+    return f"{summary}\n\n{MANIFEST_MARKER}\n{json.dumps(manifest, indent=2)}\n"
+
+
+def manifest_from_tag(tag):
+    """Returns the release manifest stored in the given annotated tag, or None if it isn't a release tag."""
+    message = tag.message or ""
+    if MANIFEST_MARKER not in message:
+        return None
+    try:
+        return json.loads(message.split(MANIFEST_MARKER, 1)[1])
+    except ValueError:
+        L.warning("Couldn't parse release manifest in tag %s", tag.name)
+        return None
+
+
+def get_releases(repo):
+    """Returns a dict of {version: manifest} for every release in the repo, sorted by version."""
+    releases = {}
+    for ref_name in repo.references:
+        if not ref_name.startswith("refs/tags/"):
+            continue
+        tag = repo.get(repo.references[ref_name].target)
+        if not isinstance(tag, pygit2.Tag):
+            continue
+        manifest = manifest_from_tag(tag)
+        if manifest is None:
+            continue
+        try:
+            parse_version(manifest["version"])
+        except (KeyError, ValueError):
+            continue
+        releases[manifest["version"]] = manifest
+    return dict(sorted(releases.items(), key=lambda item: parse_version(item[0])))
+
+
+def dataset_stats(dataset, include_extent=True):
+    """
+    The statistics and checksum that are frozen into the release manifest for each dataset.
+    The checksum is the ID of the dataset's tree - it changes if anything about the dataset changes.
+    """
+    result = {"type": dataset.DATASET_TYPE, "checksum": dataset.tree.hex}
+    if dataset.DATASET_TYPE == "table":
+        result["featureCount"] = dataset.feature_count
+        if include_extent:
+            result["extent"] = dataset_extent(dataset)
+    return result
+
+
+def file_sha256(path):
+    sha256 = hashlib.sha256()
+    with open(path, "rb") as f:
+        for chunk in iter(lambda: f.read(1024 * 1024), b""):
+            sha256.update(chunk)
+    return sha256.hexdigest()
+
+
+def export_artifacts(datasets, export_formats, release_dir, version):
+    """
+    Exports the given table datasets in each of the given formats to release_dir.
+    Formats that can hold more than one dataset get a single file named after the release, the others get a file
+    per dataset. Returns a list describing each artifact.
+    """
+    from kart.tabular.table_dataset import TableDataset
+
+    datasets = [ds for ds in datasets if isinstance(ds, TableDataset)]
+    if not datasets:
+        return []
+
+    artifacts = []
+    for export_format in export_formats:
+        ext = export_format.extensions[0]
+        if export_format.single_dataset:
+            groups = [
+                (f"{ds.dataset_path_to_table_name(ds.path)}{ext}", [ds])
+                for ds in datasets
+            ]
+        else:
+            groups = [(f"{version}{ext}", datasets)]
+
+        for filename, group in groups:
+            path = release_dir / filename
+            if path.exists():
+                path.unlink()
+            with export_format.exporter(path) as exporter:
+                for dataset in group:
+                    exporter.write_dataset(dataset)
+            artifacts.append(
+                {
+                    "path": filename,
+                    "format": export_format.name,
+                    "datasets": [ds.path for ds in group],
+                    "size": path.stat().st_size,
+                    "sha256": file_sha256(path),
+                }
+            )
+            click.echo(f"Wrote {export_format.name} file: {path}")
+    return artifacts
+
+
+@click.group(cls=KartGroup)
+@click.pass_context
+def release(ctx, **kwargs):
+    """
+    Create and view semantically versioned data releases.
+
+    A release is an annotated tag, named after its version, which records a manifest of the released data: the
+    feature count, extent and checksum of each dataset, and the files exported for distribution, if any.
+    """
+
+
+@release.command(cls=KartCommand, name="create", writes_to_repo=True)
+@click.pass_context
+@click.option(
+    "--ref",
+    default="HEAD",
+    show_default=True,
+    shell_complete=ref_completer,
+    help="The commit to release.",
+)
+@click.option(
+    "--message",
+    "-m",
+    help="Release notes to add to the release tag. See `kart changelog` for a summary of changes to paste in.",
+)
+@click.option(
+    "--export",
+    "export_format_names",
+    multiple=True,
+    metavar="FORMAT",
+    help=(
+        "Export every table dataset in this format as a distribution artifact, eg GPKG, GEOJSON or PARQUET. "
+        "Can be given more than once. See `kart export --help` for the supported formats."
+    ),
+)
+@click.option(
+    "--output-dir",
+    type=click.Path(file_okay=False, path_type=Path),
+    default=Path("releases"),
+    show_default=True,
+    help="Artifacts and the manifest are written to a directory named after the version inside this directory.",
+)
+@click.option(
+    "--extent/--no-extent",
+    default=True,
+    help="Whether to record the extent of each table dataset. Calculating the extent requires reading every feature.",
+)
+@click.argument("version")
+def release_create(ctx, ref, message, export_format_names, output_dir, extent, version):
+    """
+    Create a release: tag a commit with a VERSION such as v1.2.0, recording a manifest of the released data.
+
+    The VERSION must be a semantic version, and newer than any existing release.
+    """
+    from kart.export import EXPORT_FORMATS
+
+    repo = ctx.obj.repo
+    try:
+        parse_version(version)
+    except ValueError:
+        raise click.BadParameter(
+            f"{version} isn't a semantic version - try eg v1.2.0", param_hint="VERSION"
+        )
+
+    export_formats = []
+    for name in export_format_names:
+        export_format = EXPORT_FORMATS.get(name.upper())
+        if export_format is None:
+            raise click.BadParameter(
+                f"Exporting to {name} is not supported - supported formats are: {', '.join(EXPORT_FORMATS)}",
+                param_hint="--export",
+            )
+        export_formats.append(export_format)
+
+    tag_name = f"refs/tags/{version}"
+    if tag_name in repo.references:
+        raise InvalidOperation(f"Tag {version} already exists")
+    releases = get_releases(repo)
+    latest = list(releases)[-1] if releases else None
+    if latest is not None:
+        if parse_version(version) <= parse_version(latest):
+            raise InvalidOperation(
+                f"Version {version} isn't newer than the latest release {latest}",
+                exit_code=INVALID_ARGUMENT,
+            )
+
+    commit = repo.structure(ref).commit
+    if commit is None:
+        raise NotFound(f"No commit found at {ref}", exit_code=NO_DATA)
+    datasets = list(repo.datasets(commit.hex))
+
+    manifest = {
+        "version": version,
+        "commit": commit.hex,
+        "created": datetime_to_iso8601_utc(datetime.now(timezone.utc)),
+        "previous": latest,
+        "datasets": {
+            ds.path: dataset_stats(ds, include_extent=extent) for ds in datasets
+        },
+    }
+    if export_formats:
+        release_dir = output_dir / version
+        release_dir.mkdir(parents=True, exist_ok=True)
+        manifest["artifacts"] = export_artifacts(
+            datasets, export_formats, release_dir, version
+        )
+        dump_json_output(
+            {"kart.release/v1": manifest}, release_dir / "manifest.json"
+        )
+
+    repo.create_tag(
+        version,
+        commit.id,
+        pygit2.GIT_OBJ_COMMIT,
+        repo.committer_signature(),
+        release_tag_message(manifest, message),
+    )
+    click.echo(f"Created release {version} at {commit.short_id}")
+
+
+@release.command(cls=KartCommand, name="list")
+@click.pass_context
+@click.option(
+    "--output-format",
+    "-o",
+    type=click.Choice(["text", "json"]),
+    default="text",
+)
+def release_list(ctx, output_format):
+    """List the releases in this repository, newest first."""
+    repo = ctx.obj.repo
+    releases = list(reversed(get_releases(repo).values()))
+    if output_format == "json":
+        dump_json_output({"kart.releases/v1": releases}, sys.stdout)
+        return
+    for manifest in releases:
+        click.echo(
+            f"{manifest['version']}  {manifest['commit'][:7]}  {manifest['created']}"
+        )
+
+
+@release.command(cls=KartCommand, name="show")
+@click.pass_context
+@click.option(
+    "--output-format",
+    "-o",
+    type=click.Choice(["text", "json"]),
+    default="text",
+)
+@click.argument("version")
+def release_show(ctx, output_format, version):
+    """Show the manifest of the given release."""
+    repo = ctx.obj.repo
+    manifest = get_releases(repo).get(version)
+    if manifest is None:
+        raise NotFound(f"No release found with version {version}", exit_code=NO_DATA)
+    if output_format == "json":
+        dump_json_output({"kart.release/v1": manifest}, sys.stdout)
+        return
+
+    click.echo(f"Release {manifest['version']}")
+    click.echo(f"Commit:  {manifest['commit']}")
+    click.echo(f"Created: {manifest['created']}")
+    for ds_path, stats in manifest["datasets"].items():
+        desc = f"  {ds_path}  {stats['checksum'][:10]}"
+        if "featureCount" in stats:
+            desc += f"  {stats['featureCount']} features"
+        click.echo(desc)
+    for artifact in manifest.get("artifacts", []):
+        click.echo(f"  {artifact['path']}  sha256:{artifact['sha256']}")
+
+
+@release.command(cls=KartCommand, name="publish-stac")
+@click.pass_context
+@click.option(
+    "--output",
+    "output_dir",
+    type=click.Path(file_okay=False, path_type=Path),
+    required=True,
+    help="The directory to write the STAC catalog to.",
+)
+@click.option(
+    "--releases-dir",
+    type=click.Path(file_okay=False, path_type=Path),
+    default=Path("releases"),
+    show_default=True,
+    help=(
+        "The directory that the releases' artifacts were written to - the --output-dir of `kart release create`. "
+        "Assets are linked to the artifacts in this directory by relative paths."
+    ),
+)
+@click.option(
+    "--base-url",
+    help="Link assets to the artifacts at this URL instead - eg https://example.com/releases",
+)
+@click.option(
+    "--id",
+    "collection_id",
+    help="The ID of the STAC collection. Defaults to the name of the repository.",
+)
+@click.option(
+    "--description",
+    help="The description of the STAC collection.",
+)
+@click.option(
+    "--license",
+    default="proprietary",
+    show_default=True,
+    help="The license of the data, as an SPDX license identifier.",
+)
+def release_publish_stac(
+    ctx,
+    output_dir,
+    releases_dir,
+    base_url,
+    collection_id,
+    description,
+    license,
+):
+    """
+    Write a STAC catalog describing releases, so that they can be found by STAC-based discovery systems. The
+    catalog is a collection.json containing an item for each release, which describes the release's extent,
+    creation time and datasets, and links to each of the release's artifacts along with its checksum.
+
+    Every release is published each time, so the catalog can be rewritten after each new release.
+    """
+    from kart.stac import release_collection, release_item
+
+    repo = ctx.obj.repo
+    releases = get_releases(repo)
+    if not releases:
+        raise NotFound("No releases to publish", exit_code=NO_DATA)
+
+    collection_id = collection_id or repo.workdir_path.name
+    description = description or f"Releases of {collection_id}"
+    items = []
+    for version, manifest in releases.items():
+        item_dir = output_dir / version
+        item = release_item(
+            repo,
+            manifest,
+            collection_id,
+            releases_dir=releases_dir,
+            item_dir=item_dir,
+            base_url=base_url,
+        )
+        item_dir.mkdir(parents=True, exist_ok=True)
+        dump_json_output(item, item_dir / f"{version}.json")
+        items.append(item)
+
+    collection = release_collection(collection_id, description, license, items)
+    dump_json_output(collection, output_dir / "collection.json")
+    click.echo(f"Wrote STAC catalog of {len(items)} releases to {output_dir}")
@@ -0,0 +1,223 @@
+import hashlib
+import json
+import logging
+import shutil
+import urllib.error
+import urllib.request
+import zipfile
+from pathlib import Path, PurePosixPath
+from urllib.parse import unquote, urlsplit
+
+import click
+
+from kart.exceptions import (
+    InvalidOperation,
+    NotFound,
+    CONNECTION_ERROR,
+    NO_IMPORT_SOURCE,
+)
+
+L = logging.getLogger("kart.remote_source")
+
+# Downloaded and extracted import sources are kept here, inside the gitdir, so that they can be reused next time.
+CACHE_DIR = "import-cache"
+CACHE_INFO = "source.json"
+
+URL_SCHEMES = ("http", "https")
+ZIP_MEMBER_SEPARATOR = "!"
+
+
+def is_url(spec):
+    return urlsplit(str(spec)).scheme.lower() in URL_SCHEMES
+
+
+def split_zip_member(spec):
+    """
+    Given a spec like archive.zip!layers.gpkg, returns ("archive.zip", "layers.gpkg").
+    Returns (spec, None) if the spec doesn't refer to a member of a zip archive.
+    """
+    spec = str(spec)
+    if ZIP_MEMBER_SEPARATOR not in spec:
+        return spec, None
+    archive, member = spec.rsplit(ZIP_MEMBER_SEPARATOR, 1)
+    if not PurePosixPath(urlsplit(archive).path).suffix.lower() == ".zip":
+        return spec, None
+    return archive, member
+
+
+def is_remote_or_zipped(spec):
+    return is_url(spec) or split_zip_member(spec)[1] is not None
+
+
+def _cache_key(*parts):
+    return hashlib.sha256("\0".join(parts).encode("utf-8")).hexdigest()[:16]
+
+
+def _read_cache_info(cache_path):
+    try:
+        return json.loads((cache_path / CACHE_INFO).read_text())
+    except (OSError, ValueError):
+        return {}
+
+
+def _write_cache_info(cache_path, info):
+    (cache_path / CACHE_INFO).write_text(json.dumps(info, indent=2))
+
+
+def download(url, cache_root):
+    """
+    Downloads the given URL into the cache, and returns the path of the downloaded file.
+    If the file was downloaded before, the server is asked whether it has changed since - using the ETag and
+    Last-Modified headers it sent last time - and the cached copy is reused if it hasn't.
+    """
+    cache_path = cache_root / "url" / _cache_key(url)
+    cache_path.mkdir(parents=True, exist_ok=True)
+    info = _read_cache_info(cache_path)
+    filename = PurePosixPath(unquote(urlsplit(url).path)).name or "download"
+    dest = cache_path / filename
+
+    headers = {}
+    if dest.is_file() and info.get("url") == url:
+        if info.get("etag"):
+            headers["If-None-Match"] = info["etag"]
+        if info.get("lastModified"):
+            headers["If-Modified-Since"] = info["lastModified"]
+
+    request = urllib.request.Request(url, headers=headers)
+    try:
+        with urllib.request.urlopen(request) as response:
+            click.echo(f"Downloading {url} ...")
+            temp_dest = dest.with_name(dest.name + ".part")
+            with open(temp_dest, "wb") as f:
+                shutil.copyfileobj(response, f)
+            temp_dest.replace(dest)
+            info = {
+                "url": url,
+                "etag": response.headers.get("ETag"),
+                "lastModified": response.headers.get("Last-Modified"),
+            }
+    except urllib.error.HTTPError as e:
+        if e.code == 304:
+            click.echo(f"Using cached copy of {url} - unchanged since last download")
+            return dest
+        raise NotFound(
+            f"Couldn't download {url}: HTTP {e.code}", exit_code=NO_IMPORT_SOURCE
+        )
+    except urllib.error.URLError as e:
+        raise InvalidOperation(
+            f"Couldn't connect to download {url}: {e.reason}",
+            exit_code=CONNECTION_ERROR,
+        )
+
+    _write_cache_info(cache_path, info)
+    return dest
+
+
+def _members_to_extract(zf, member):
+    """
+    Returns the names of the zip members needed to import the given member: the member itself, plus any sidecar
+    files with the same name but a different extension (eg the .dbf and .prj for a .shp) - or, if the member is a
+    directory, everything inside it.
+    """
+    names = [n for n in zf.namelist() if not n.endswith("/")]
+    member = member.strip("/")
+    if member in names:
+        path = PurePosixPath(member)
+        stem = (path.parent / path.stem).as_posix().lower()
+        return [
+            n
+            for n in names
+            if (PurePosixPath(n).parent / PurePosixPath(n).stem).as_posix().lower()
+            == stem
+        ]
+    return [n for n in names if n.startswith(member + "/")]
+
+
+def extract_zip_member(archive_path, member, cache_root):
+    """
+    Extracts the given member of the given zip archive (and any sidecar files it needs) into the cache,
+    and returns the path of the extracted member. Nothing is extracted if the cache is already up to date.
+    """
+    archive_path = Path(archive_path)
+    try:
+        zf = zipfile.ZipFile(archive_path)
+    except FileNotFoundError:
+        raise NotFound(f"No such file: {archive_path}", exit_code=NO_IMPORT_SOURCE)
+    except zipfile.BadZipFile:
+        raise NotFound(
+            f"Not a zip archive: {archive_path}", exit_code=NO_IMPORT_SOURCE
+        )
+
+    with zf:
+        names = _members_to_extract(zf, member)
+        if not names:
+            raise NotFound(
+                f"No such member in {archive_path}: {member}",
+                exit_code=NO_IMPORT_SOURCE,
+            )
+        for name in names:
+            path = PurePosixPath(name)
+            if path.is_absolute() or ".." in path.parts:
+                raise InvalidOperation(f"Unsafe member in {archive_path}: {name}")
+
+        cache_path = (
+            cache_root / "zip" / _cache_key(str(archive_path.resolve()), member)
+        )
+        cache_path.mkdir(parents=True, exist_ok=True)
+        # The CRC of each member identifies whether the archive has changed since it was last extracted.
+        checksums = {name: zf.getinfo(name).CRC for name in names}
+        info = _read_cache_info(cache_path)
+        up_to_date = info.get("checksums") == checksums and all(
+            (cache_path / name).is_file() for name in names
+        )
+        if not up_to_date:
+            L.info("Extracting %s from %s", ", ".join(names), archive_path)
+            for name in names:
+                zf.extract(name, cache_path)
+            _write_cache_info(
+                cache_path, {"archive": str(archive_path), "checksums": checksums}
+            )
+
+    return cache_path / member.strip("/")
+
+
+def resolve_source(spec, cache_root):
+    """
+    Given an import source spec which is an http(s) URL, or a member of a zip archive like archive.zip!layers.gpkg
+    (or both - https://example.com/archive.zip!layers.gpkg), fetches it into the cache and returns the local path.
+    Any other spec is returned unchanged.
+    """
+    spec = str(spec)
+    archive, member = split_zip_member(spec)
+    if member is not None:
+        archive_path = download(archive, cache_root) if is_url(archive) else archive
+        return str(extract_zip_member(archive_path, member, cache_root))
+    if is_url(spec):
+        return str(download(spec, cache_root))
+    return spec
+
+
+def source_cache_root(repo):
+    return repo.gitdir_file(CACHE_DIR)
+
+
+def original_source_spec(spec):
+    """
+    The inverse of resolve_source: if the given spec is the local path of a source that was downloaded or extracted
+    into the cache, returns the URL or archive member that it came from. Any other spec is returned unchanged.
+    """
+    path = Path(spec)
+    for cache_path in path.parents:
+        if cache_path.parent.name not in ("url", "zip"):
+            continue
+        if cache_path.parent.parent.name != CACHE_DIR:
+            continue
+        info = _read_cache_info(cache_path)
+        if "url" in info:
+            return info["url"]
+        if "archive" in info:
+            member = path.relative_to(cache_path).as_posix()
+            archive = original_source_spec(info["archive"])
+            return f"{archive}{ZIP_MEMBER_SEPARATOR}{member}"
+        break
+    return str(spec)
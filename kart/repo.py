@@ -1,6 +1,5 @@
 import contextlib
 import logging
-import re
 import struct
 import sys
 from enum import Enum
@@ -29,7 +28,7 @@ from kart.tabular.version import (
 )
 from kart.structure import RepoStructure
 from kart import subprocess_util as subprocess
-from kart.timestamps import tz_offset_to_minutes
+from kart.identity import get_signature
 from kart.working_copy import WorkingCopy
 
 L = logging.getLogger("kart.repo")
@@ -56,6 +55,12 @@ class KartRepoFiles:
     MERGED_TREE = "MERGED_TREE"
     # A sqlite table that maps each feature SHA to its EPSG:4326 envelope. Used for spatial filtered clones.
     FEATURE_ENVELOPES = "feature_envelopes.db"
+    # An append-only log of every operation that changed a ref, if kart.audit.enabled is set. One JSON object per line.
+    AUDIT_LOG = "audit.jsonl"
+    # The state of a `kart bisect` session - the bad commit, the good commits, and any commits that were skipped.
+    BISECT_STATE = "BISECT_STATE"
+    # Locked by the Kart process that is currently writing to the repository, if any - see kart.process_lock.
+    WRITE_LOCK = "kart-write.lock"
 
 
 class KartRepoState(Enum):
@@ -98,6 +103,8 @@ class KartConfigKeys:
     KART_SPATIALFILTER_REFERENCE = "kart.spatialfilter.reference"
     KART_SPATIALFILTER_OBJECTID = "kart.spatialfilter.objectid"
 
+    KART_AUDIT_ENABLED = "kart.audit.enabled"
+
     # This variable was also renamed, but when tidy-style repos were added - not during rebranding.
     CORE_BARE = "core.bare"  # Newer repos use the standard "core.bare" variable.
     SNO_WORKINGCOPY_BARE = (
@@ -787,36 +794,8 @@ class KartRepo(pygit2.Repository):
         except KeyError:
             return None
 
-    _GIT_VAR_OUTPUT_RE = re.compile(
-        r"^(?P<name>.*) <(?P<email>[^>]*)> (?P<time>\d+) (?P<offset>[+-]?\d+)$"
-    )
-
     def _signature(self, person_type, **overrides):
-        # 'git var' lets us use the environment variables to
-        # control the user info, e.g. GIT_AUTHOR_DATE.
-        # libgit2/pygit2 doesn't handle those env vars at all :(
-        env_overrides = {}
-
-        name = overrides.pop("name", None)
-        if name is not None:
-            env_overrides[f"GIT_{person_type}_NAME"] = name
-
-        email = overrides.pop("email", None)
-        if email is not None:
-            env_overrides[f"GIT_{person_type}_EMAIL"] = email
-
-        output = subprocess.check_output(
-            ["git", "var", f"GIT_{person_type}_IDENT"],
-            cwd=self.path,
-            encoding="utf8",
-            env_overrides=env_overrides,
-        )
-        m = self._GIT_VAR_OUTPUT_RE.match(output)
-        kwargs = m.groupdict()
-        kwargs["time"] = int(kwargs["time"])
-        kwargs["offset"] = tz_offset_to_minutes(kwargs["offset"])
-        kwargs.update(overrides)
-        return pygit2.Signature(**kwargs)
+        return get_signature(person_type, cwd=self.path, **overrides)
 
     def author_signature(self, **overrides):
         return self._signature("AUTHOR", **overrides)
@@ -824,6 +803,21 @@ class KartRepo(pygit2.Repository):
     def committer_signature(self, **overrides):
         return self._signature("COMMITTER", **overrides)
 
+    def create_commit(self, update_ref, *args, **kwargs):
+        """
+        As pygit2.Repository.create_commit - update_ref, if given, is only updated if it still points to the new
+        commit's first parent. If another process has moved it since, an InvalidOperation is raised instead of
+        overwriting the other process's changes.
+        """
+        try:
+            return super().create_commit(update_ref, *args, **kwargs)
+        except pygit2.GitError as e:
+            if update_ref is None or "current tip is not the first parent" not in str(e):
+                raise
+            raise InvalidOperation(
+                f"Couldn't update {update_ref} - it was changed by another process while this command was running"
+            )
+
     def gitdir_file(self, rel_path):
         return self.gitdir_path / rel_path
 
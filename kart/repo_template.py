@@ -0,0 +1,189 @@
+"""
+Templates for new repositories - see `kart init --template`. A template is a directory - or a git repository containing
+one at its root - that can contain any of the following:
+
+    config                  A git-config file. Its kart.* settings - eg kart.foreignKey validation rules, guardrails or
+                            derived columns - are copied to the new repository's config.
+    profiles/NAME.json      Publish profiles, as accepted by `kart profile set`.
+    hooks/NAME              Git hooks, eg pre-commit, which are copied to the new repository's hooks directory.
+    datasets/PATH/...       Empty datasets to create in an initial commit. Each is a directory containing a
+                            schema.json file and any other meta items, eg title, description or crs/EPSG:2193.wkt.
+"""
+
+import contextlib
+import json
+import tempfile
+from pathlib import Path
+
+import pygit2
+
+from kart import subprocess_util as subprocess
+from kart.dataset_util import validate_dataset_paths
+from kart.diff_structs import DatasetDiff, Delta, DeltaDiff, RepoDiff
+from kart.exceptions import NOT_FOUND, InvalidOperation, NotFound
+from kart.publish_profile import PROFILE_SCHEMA, PublishProfile
+from kart.schema import ColumnSchema, Schema
+
+# Config that describes how this particular repository is stored, which a template shouldn't change.
+PROTECTED_CONFIG_PREFIXES = (
+    "kart.repostructure.",
+    "kart.workingcopy.",
+    "kart.spatialfilter.",
+)
+
+# Kart installs its own pre-push hook, to push LFS tiles.
+PROTECTED_HOOKS = ("pre-push",)
+
+
+@contextlib.contextmanager
+def open_template(template):
+    """
+    Yields the path of the given template directory - or if it isn't a directory, clones the git repository at that
+    URL and yields the path of the clone, which is deleted afterwards.
+    """
+    path = Path(template).expanduser()
+    if path.is_dir():
+        yield path.resolve()
+        return
+
+    with tempfile.TemporaryDirectory() as tmp_dir:
+        clone_path = Path(tmp_dir) / "template"
+        try:
+            subprocess.check_call(
+                ["git", "clone", "--quiet", "--depth=1", template, str(clone_path)]
+            )
+        except subprocess.CalledProcessError:
+            raise NotFound(
+                f"Couldn't find a template directory or repository at {template}",
+                exit_code=NOT_FOUND,
+            )
+        yield clone_path
+
+
+def _load_config(path):
+    config = pygit2.Config(str(path))
+    items = []
+    for entry in config:
+        name = entry.name
+        if not name.startswith("kart.") or name.startswith(PROTECTED_CONFIG_PREFIXES):
+            raise InvalidOperation(
+                f"Template config can only contain kart.* settings that don't affect how the repository is stored, "
+                f"but it contains {name}"
+            )
+        items.append((name, entry.value))
+    return items
+
+
+def _load_profiles(path):
+    import jsonschema
+
+    profiles = []
+    for profile_path in sorted(path.glob("*.json")):
+        try:
+            definition = json.loads(profile_path.read_text(encoding="utf-8"))
+            jsonschema.validate(definition, PROFILE_SCHEMA)
+        except (ValueError, jsonschema.ValidationError) as e:
+            raise InvalidOperation(f"Invalid publish profile in template: {e}")
+        profiles.append(PublishProfile(profile_path.stem, definition))
+    return profiles
+
+
+def _load_hooks(path):
+    hooks = {}
+    for hook_path in sorted(p for p in path.iterdir() if p.is_file()):
+        if hook_path.name in PROTECTED_HOOKS:
+            raise InvalidOperation(
+                f"Template can't contain a {hook_path.name} hook - Kart needs its own"
+            )
+        hooks[hook_path.name] = hook_path.read_bytes()
+    return hooks
+
+
+def _load_meta_item(ds_path, path, name):
+    value = path.read_text(encoding="utf-8")
+    if not name.endswith(".json"):
+        return value
+    try:
+        value = json.loads(value)
+    except ValueError as e:
+        raise InvalidOperation(f"Invalid {name} for {ds_path} in template: {e}")
+    if name == "schema.json":
+        # Columns are given deterministic IDs if they don't have them, so that repositories created from the same
+        # template have compatible schemas.
+        for column in value:
+            if "id" not in column:
+                column["id"] = ColumnSchema.deterministic_id(ds_path, column["name"])
+        try:
+            Schema(value)
+        except (AssertionError, KeyError, TypeError, ValueError):
+            raise InvalidOperation(f"Invalid schema.json for {ds_path} in template")
+    return value
+
+
+def _load_datasets(path):
+    datasets = {}
+    for schema_path in sorted(path.glob("**/schema.json")):
+        ds_dir = schema_path.parent
+        ds_path = ds_dir.relative_to(path).as_posix()
+        meta_items = {}
+        for item_path in sorted(ds_dir.glob("**/*")):
+            if item_path.is_file():
+                name = item_path.relative_to(ds_dir).as_posix()
+                meta_items[name] = _load_meta_item(ds_path, item_path, name)
+        datasets[ds_path] = meta_items
+    return datasets
+
+
+class RepoTemplate:
+    """
+    The contents of a template directory, which are read and checked up front - so that the directory isn't needed
+    once this is loaded, and nothing is created if the template is invalid.
+    """
+
+    def __init__(self, path):
+        config_path = path / "config"
+        self.config = _load_config(config_path) if config_path.is_file() else []
+        profiles_path = path / "profiles"
+        self.profiles = _load_profiles(profiles_path) if profiles_path.is_dir() else []
+        hooks_path = path / "hooks"
+        self.hooks = _load_hooks(hooks_path) if hooks_path.is_dir() else {}
+        datasets_path = path / "datasets"
+        self.datasets = _load_datasets(datasets_path) if datasets_path.is_dir() else {}
+        validate_dataset_paths(list(self.datasets))
+
+    def apply(self, repo, message=None):
+        """
+        Applies this template to the given newly created repo. If the template contains any datasets, they are
+        committed, and the new commit is returned.
+        """
+        for name in dict.fromkeys(name for name, value in self.config):
+            values = [v for n, v in self.config if n == name]
+            if len(values) == 1:
+                repo.config[name] = values[0]
+                continue
+            for value in values:
+                subprocess.check_call(
+                    ["git", "-C", repo.path, "config", "--add", name, value]
+                )
+
+        for profile in self.profiles:
+            profile.save(repo)
+
+        hooks_dir = repo.gitdir_path / "hooks"
+        hooks_dir.mkdir(parents=True, exist_ok=True)
+        for name, contents in self.hooks.items():
+            hook_path = hooks_dir / name
+            hook_path.write_bytes(contents)
+            hook_path.chmod(0o755)
+
+        if not self.datasets:
+            return None
+        repo_diff = RepoDiff()
+        for ds_path, meta_items in self.datasets.items():
+            meta_diff = DeltaDiff(
+                Delta.insert((name, value)) for name, value in meta_items.items()
+            )
+            repo_diff[ds_path] = DatasetDiff([("meta", meta_diff)])
+        if not message:
+            message = f"Create {', '.join(self.datasets)} from template"
+        return repo.structure().commit_diff(repo_diff, message)
@@ -503,7 +503,7 @@ def _fix_conflict_label(conflict_label):
     return conflict_label
 
 
-@click.command(cls=KartCommand)
+@click.command(cls=KartCommand, writes_to_repo=True)
 @click.pass_context
 @click.option(
     "--with",
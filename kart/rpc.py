@@ -0,0 +1,216 @@
+import contextlib
+import json
+import logging
+import os
+import sys
+import tempfile
+
+import click
+
+from kart.cli_util import KartCommand
+from kart.exceptions import SUCCESS, SUCCESS_WITH_FLAG
+
+L = logging.getLogger("kart.rpc")
+
+JSONRPC_VERSION = "2.0"
+
+# Error codes defined by the JSON-RPC 2.0 spec. Errors from Kart commands use the command's exit code instead.
+PARSE_ERROR = -32700
+INVALID_REQUEST = -32600
+METHOD_NOT_FOUND = -32601
+INVALID_PARAMS = -32602
+INTERNAL_ERROR = -32603
+
+# Commands that can't sensibly be run from inside an RPC session.
+UNSUPPORTED_METHODS = {"helper", "rpc"}
+
+
+class RpcError(Exception):
+    def __init__(self, code, message, data=None):
+        super().__init__(message)
+        self.code = code
+        self.message = message
+        self.data = data
+
+    def to_json(self):
+        result = {"code": self.code, "message": self.message}
+        if self.data is not None:
+            result["data"] = self.data
+        return result
+
+
+def _all_methods():
+    from kart.cli import cli, load_all_commands
+
+    load_all_commands()
+    return sorted(
+        name for name in cli.commands if name not in UNSUPPORTED_METHODS
+    )
+
+
+def _parse_params(params):
+    """
+    Returns the command-line arguments for the given params, which can be either a list of arguments, or an object:
+    {"args": [...], "repo": "path/to/repo"}
+    """
+    if params is None:
+        return []
+    if isinstance(params, dict):
+        unknown = set(params) - {"args", "repo"}
+        if unknown:
+            raise RpcError(
+                INVALID_PARAMS, f"Unknown params: {', '.join(sorted(unknown))}"
+            )
+        args = params.get("args", [])
+        repo = params.get("repo")
+    else:
+        args, repo = params, None
+
+    if not isinstance(args, list) or not all(
+        isinstance(a, (str, int, float)) for a in args
+    ):
+        raise RpcError(INVALID_PARAMS, "args should be a list of strings")
+    args = [str(a) for a in args]
+    if repo is not None:
+        args = ["-C", str(repo), *args]
+    return args
+
+
+@contextlib.contextmanager
+def _capture_output():
+    """
+    Redirects stdin from /dev/null, and stdout and stderr to temporary files, for the duration of a single call -
+    at the file-descriptor level as well as sys.stdout etc, so that the output of any subprocesses is captured too,
+    and can't get mixed up with the RPC responses.
+    """
+    sys.stdout.flush()
+    sys.stderr.flush()
+    orig_streams = (sys.stdin, sys.stdout, sys.stderr)
+    saved_fds = [os.dup(fd) for fd in (0, 1, 2)]
+    with contextlib.ExitStack() as stack:
+        devnull = stack.enter_context(open(os.devnull, "rb"))
+        out = stack.enter_context(tempfile.TemporaryFile())
+        err = stack.enter_context(tempfile.TemporaryFile())
+        try:
+            for fd, f in ((0, devnull), (1, out), (2, err)):
+                os.dup2(f.fileno(), fd)
+            sys.stdin = open(0, "r", closefd=False)
+            sys.stdout = open(1, "w", encoding="utf-8", closefd=False)
+            sys.stderr = open(2, "w", encoding="utf-8", closefd=False)
+            yield out, err
+        finally:
+            sys.stdout.flush()
+            sys.stderr.flush()
+            sys.stdin, sys.stdout, sys.stderr = orig_streams
+            for fd, saved_fd in zip((0, 1, 2), saved_fds):
+                os.dup2(saved_fd, fd)
+                os.close(saved_fd)
+
+
+def run_command(args):
+    """Runs a single Kart command in this process, returning (exit_code, stdout, stderr)."""
+    from kart.cli import cli, load_commands_from_args
+
+    load_commands_from_args(args, skip_first_arg=False)
+    with _capture_output() as (out, err):
+        try:
+            cli.main(args=args, prog_name="kart", standalone_mode=True)
+            exit_code = 0
+        except SystemExit as e:
+            exit_code = e.code if isinstance(e.code, int) else int(bool(e.code))
+        out.seek(0)
+        err.seek(0)
+        stdout = out.read().decode("utf-8", errors="replace")
+        stderr = err.read().decode("utf-8", errors="replace")
+    return exit_code, stdout, stderr
+
+
+def call_method(method, params):
+    if method == "rpc.listMethods":
+        return _all_methods()
+
+    if not isinstance(method, str) or method not in _all_methods():
+        raise RpcError(METHOD_NOT_FOUND, f"No such method: {method}")
+    args = _parse_params(params)
+    if args[:1] == ["-C"]:
+        args = [*args[:2], method, *args[2:]]
+    else:
+        args = [method, *args]
+
+    L.debug("rpc: kart %s", " ".join(args))
+    exit_code, stdout, stderr = run_command(args)
+    result = {"exitCode": exit_code, "stdout": stdout, "stderr": stderr}
+    # Commands run with --output-format=json etc output a single JSON document, which is returned parsed.
+    try:
+        result["output"] = json.loads(stdout)
+    except ValueError:
+        pass
+
+    if exit_code not in (SUCCESS, SUCCESS_WITH_FLAG):
+        lines = stderr.strip().splitlines()
+        message = lines[-1] if lines else f"kart {method} failed"
+        raise RpcError(exit_code, message, data=result)
+    return result
+
+
+def handle_request(line):
+    """Handles a single line of input. Returns the response to send, or None if the request was a notification."""
+    try:
+        request = json.loads(line)
+    except ValueError as e:
+        return _error_response(None, RpcError(PARSE_ERROR, f"Parse error: {e}"))
+
+    if (
+        not isinstance(request, dict)
+        or request.get("jsonrpc") != JSONRPC_VERSION
+        or "method" not in request
+    ):
+        return _error_response(
+            request.get("id") if isinstance(request, dict) else None,
+            RpcError(INVALID_REQUEST, "Invalid Request"),
+        )
+
+    is_notification = "id" not in request
+    request_id = request.get("id")
+    try:
+        result = call_method(request["method"], request.get("params"))
+        response = {"jsonrpc": JSONRPC_VERSION, "id": request_id, "result": result}
+    except RpcError as e:
+        response = _error_response(request_id, e)
+    except Exception as e:
+        L.exception("rpc: unhandled exception")
+        response = _error_response(request_id, RpcError(INTERNAL_ERROR, str(e)))
+    return None if is_notification else response
+
+
+def _error_response(request_id, error):
+    return {"jsonrpc": JSONRPC_VERSION, "id": request_id, "error": error.to_json()}
+
+
+@click.command(cls=KartCommand)
+@click.pass_context
+def rpc(ctx):
+    """
+    Run Kart commands using newline-delimited JSON-RPC 2.0 over stdin and stdout.
+
+    Each line of input should be a JSON-RPC request, where the method is the name of a Kart command, and the params
+    are the command's arguments - either a list, or an object with "args" and optionally "repo":
+
+        {"jsonrpc": "2.0", "id": 1, "method": "status", "params": {"args": ["-o", "json"], "repo": "myrepo"}}
+
+    Each response is written as a single line. The result contains the command's exitCode, stdout and stderr -
+    and output, which is the parsed stdout if the command wrote JSON. If the command fails, an error is returned
+    with the command's exit code as the error code. The method rpc.listMethods returns the available commands.
+
+    Commands run one at a time until stdin is closed. This is intended for GUI wrappers and plugins, which can avoid
+    the overhead of starting a new Kart process for each command.
+    """
+    stdin = click.get_text_stream("stdin")
+    stdout = click.get_text_stream("stdout")
+    for line in stdin:
+        if not line.strip():
+            continue
+        response = handle_request(line)
+        if response is not None:
+            stdout.write(json.dumps(response) + "\n")
+            stdout.flush()
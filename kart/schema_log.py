@@ -0,0 +1,254 @@
+import sys
+
+import click
+import pygit2
+
+from kart.cli_util import KartCommand, KartGroup
+from kart.completion_shared import ref_completer, repo_path_completer
+from kart.exceptions import NotFound, NO_DATA
+from kart.log import commit_obj_to_json
+from kart.output_util import dump_json_output
+
+# The parts of a column's schema that describe its type - see ColumnSchema.
+TYPE_KEYS = (
+    "dataType",
+    "size",
+    "length",
+    "precision",
+    "scale",
+    "geometryType",
+    "geometryCRS",
+)
+
+
+def describe_type(column):
+    """Describes the type of a column - eg integer(64), text(100) or geometry(POINT, EPSG:4326)."""
+    params = [str(column[k]) for k in TYPE_KEYS[1:] if column.get(k) is not None]
+    return f"{column.data_type}({', '.join(params)})" if params else column.data_type
+
+
+def _pk_names(schema):
+    return [c.name for c in schema.pk_columns]
+
+
+def schema_timeline_changes(old_ds, new_ds):
+    """
+    Returns a list of dicts describing each way in which the schema and CRS of a dataset changed between old_ds and
+    new_ds - either of which can be None, if the dataset was created or deleted. Columns are matched up by ID, so
+    renames are recognised.
+    """
+    if old_ds is None and new_ds is None:
+        return []
+    if new_ds is None:
+        return [{"type": "datasetDeleted"}]
+    if old_ds is None:
+        columns = [
+            {"column": c.name, "dataType": describe_type(c)}
+            for c in new_ds.schema.columns
+        ]
+        return [
+            {
+                "type": "datasetCreated",
+                "columns": columns,
+                "primaryKey": _pk_names(new_ds.schema),
+                "crs": sorted(new_ds.crs_definitions()),
+            }
+        ]
+
+    changes = []
+    old_schema = old_ds.schema
+    new_schema = new_ds.schema
+    if old_schema != new_schema:
+        old_columns = {c.id: c for c in old_schema.columns}
+        new_columns = {c.id: c for c in new_schema.columns}
+        for col_id, new_col in new_columns.items():
+            old_col = old_columns.get(col_id)
+            if old_col is None:
+                changes.append(
+                    {
+                        "type": "columnAdded",
+                        "column": new_col.name,
+                        "dataType": describe_type(new_col),
+                    }
+                )
+                continue
+            if old_col.name != new_col.name:
+                changes.append(
+                    {
+                        "type": "columnRenamed",
+                        "column": new_col.name,
+                        "oldName": old_col.name,
+                    }
+                )
+            if describe_type(old_col) != describe_type(new_col):
+                changes.append(
+                    {
+                        "type": "columnRetyped",
+                        "column": new_col.name,
+                        "oldType": describe_type(old_col),
+                        "newType": describe_type(new_col),
+                    }
+                )
+        for col_id, old_col in old_columns.items():
+            if col_id not in new_columns:
+                changes.append({"type": "columnDropped", "column": old_col.name})
+
+        if [c.id for c in old_schema.pk_columns] != [
+            c.id for c in new_schema.pk_columns
+        ]:
+            changes.append(
+                {
+                    "type": "primaryKeyChanged",
+                    "oldPrimaryKey": _pk_names(old_schema),
+                    "newPrimaryKey": _pk_names(new_schema),
+                }
+            )
+
+    old_crs = old_ds.crs_definitions()
+    new_crs = new_ds.crs_definitions()
+    if old_crs != new_crs:
+        changes.append(
+            {
+                "type": "crsChanged",
+                "oldCrs": sorted(old_crs),
+                "newCrs": sorted(new_crs),
+            }
+        )
+    return changes
+
+
+def _meta_tree_id(ds):
+    return ds.meta_tree.id if ds is not None else None
+
+
+def schema_timeline(repo, ds_path, revision="HEAD"):
+    """
+    Yields (commit, changes) for each commit in the history of revision that changed the schema or CRS of the dataset
+    at ds_path, newest first - see schema_timeline_changes. A merge commit is only included if the schema it ended up
+    with is different to that of each of its parents - ie, if the merge itself changed the schema.
+    """
+    start = repo.revparse_single(revision).peel(pygit2.Commit)
+
+    def dataset_at(commit):
+        ds = repo.datasets(commit.hex).get(ds_path)
+        return ds if ds is not None and ds.DATASET_TYPE == "table" else None
+
+    # Each commit's dataset is loaded while its child is walked, and is needed once more when it is walked itself.
+    parent_datasets_cache = {}
+    walker = repo.walk(start.id, pygit2.GIT_SORT_TOPOLOGICAL | pygit2.GIT_SORT_TIME)
+    for commit in walker:
+        if commit.id in parent_datasets_cache:
+            new_ds = parent_datasets_cache.pop(commit.id)
+        else:
+            new_ds = dataset_at(commit)
+        try:
+            parents = commit.parents
+        except KeyError:
+            # A shallow clone - there's nothing to compare the oldest commits to.
+            parents = []
+        parent_datasets = []
+        for parent in parents:
+            if parent.id not in parent_datasets_cache:
+                parent_datasets_cache[parent.id] = dataset_at(parent)
+            parent_datasets.append(parent_datasets_cache[parent.id])
+
+        new_meta = _meta_tree_id(new_ds)
+        if any(_meta_tree_id(p) == new_meta for p in parent_datasets or [None]):
+            continue
+        old_ds = parent_datasets[0] if parent_datasets else None
+        changes = schema_timeline_changes(old_ds, new_ds)
+        if changes:
+            yield commit, changes
+
+
+def describe_change(change):
+    """Describes a change from schema_timeline_changes for humans."""
+    change_type = change["type"]
+    if change_type == "datasetCreated":
+        columns = ", ".join(
+            f"{c['column']} {c['dataType']}" for c in change["columns"]
+        )
+        return f"dataset created with columns: {columns}"
+    elif change_type == "datasetDeleted":
+        return "dataset deleted"
+    elif change_type == "columnAdded":
+        return f"+ column {change['column']} {change['dataType']}"
+    elif change_type == "columnDropped":
+        return f"- column {change['column']}"
+    elif change_type == "columnRenamed":
+        return f"~ column {change['oldName']} renamed to {change['column']}"
+    elif change_type == "columnRetyped":
+        return f"~ column {change['column']}: {change['oldType']} → {change['newType']}"
+    elif change_type == "primaryKeyChanged":
+        old_pk = ", ".join(change["oldPrimaryKey"]) or "none"
+        new_pk = ", ".join(change["newPrimaryKey"]) or "none"
+        return f"primary key: {old_pk} → {new_pk}"
+    elif change_type == "crsChanged":
+        old_crs = ", ".join(change["oldCrs"]) or "none"
+        new_crs = ", ".join(change["newCrs"]) or "none"
+        if old_crs == new_crs:
+            return f"CRS definition of {new_crs} changed"
+        return f"CRS: {old_crs} → {new_crs}"
+    return change_type
+
+
+@click.group(cls=KartGroup)
+@click.pass_context
+def schema(ctx, **kwargs):
+    """Commands for inspecting the schemas of datasets."""
+
+
+@schema.command("log", cls=KartCommand)
+@click.pass_context
+@click.option(
+    "--output-format",
+    "-o",
+    type=click.Choice(["text", "json"]),
+    default="text",
+)
+@click.option(
+    "--reverse",
+    is_flag=True,
+    help="List the oldest changes first.",
+)
+@click.argument("ds_path", metavar="DATASET", shell_complete=repo_path_completer)
+@click.argument(
+    "revision", default="HEAD", required=False, shell_complete=ref_completer
+)
+def log(ctx, output_format, reverse, ds_path, revision):
+    """
+    List every change to the schema or CRS of a dataset, with the commit that made it - columns added, dropped,
+    renamed or retyped, and changes to the primary key or CRS - so that consumers of the dataset can plan their
+    migrations.
+    """
+    repo = ctx.obj.repo
+    timeline = list(schema_timeline(repo, ds_path, revision))
+    if not timeline:
+        raise NotFound(
+            f"No table dataset found at {ds_path} in the history of {revision}",
+            exit_code=NO_DATA,
+        )
+    if reverse:
+        timeline.reverse()
+
+    if output_format == "json":
+        dump_json_output(
+            {
+                "kart.schema-log/v1": [
+                    {**commit_obj_to_json(commit), "changes": changes}
+                    for commit, changes in timeline
+                ]
+            },
+            sys.stdout,
+        )
+        return
+
+    for commit, changes in timeline:
+        commit_json = commit_obj_to_json(commit)
+        summary = commit.message.splitlines()[0] if commit.message else ""
+        click.secho(
+            f"{commit.short_id} {commit_json['authorTime'][:10]} {summary}",
+            fg="yellow",
+        )
+        for change in changes:
+            click.echo(f"    {describe_change(change)}")
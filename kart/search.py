@@ -0,0 +1,205 @@
+import re
+
+import click
+import pygit2
+
+from kart import subprocess_util as subprocess
+from kart.cli_util import KartCommand, OutputFormatType
+from kart.completion_shared import repo_path_completer
+from kart.exceptions import NotFound, SubprocessError, NO_COMMIT, NO_DATA
+from kart.geometry import Geometry
+from kart.output_util import dump_json_output
+
+
+class FeatureMatcher:
+    """Matches the text values of some or all of a feature's fields against a regular expression."""
+
+    def __init__(self, pattern, fields, ignore_case=False):
+        try:
+            self.regex = re.compile(pattern, re.IGNORECASE if ignore_case else 0)
+        except re.error as e:
+            raise click.BadParameter(
+                f"Invalid regular expression: {e}", param_hint="--regex"
+            )
+        self.fields = fields
+
+    def matches(self, feature):
+        """Yields (field, value) for every field in the given feature that matches."""
+        fields = self.fields or feature.keys()
+        for field in fields:
+            value = feature.get(field)
+            if value is None or isinstance(value, (Geometry, bytes)):
+                continue
+            if self.regex.search(str(value)):
+                yield field, value
+
+
+def _check_fields(dataset, fields):
+    columns = {c.name for c in dataset.schema.columns}
+    missing = [f for f in fields if f not in columns]
+    if missing:
+        raise click.BadParameter(
+            f"{dataset.path} has no field called {', '.join(missing)}",
+            param_hint="--field",
+        )
+
+
+def search_current(repo, ref, ds_path, matcher):
+    """Yields a match for every feature of the dataset at the given ref that matches."""
+    dataset = repo.datasets(ref).get(ds_path)
+    if dataset is None:
+        raise NotFound(f"No dataset found at {ds_path}", exit_code=NO_DATA)
+    _check_fields(dataset, matcher.fields)
+    for feature in dataset.features():
+        for field, value in matcher.matches(feature):
+            yield {
+                "commit": None,
+                "id": dataset.pk_value(feature),
+                "field": field,
+                "value": value,
+            }
+
+
+def _commits_that_changed(repo, ref, ds_path):
+    cmd = ["git", "-C", repo.path, "rev-list", ref, "--", ds_path]
+    try:
+        r = subprocess.run(cmd, encoding="utf8", check=True, capture_output=True)
+    except subprocess.CalledProcessError as e:
+        raise SubprocessError(
+            f"There was a problem with git rev-list: {e}", called_process_error=e
+        )
+    return r.stdout.split()
+
+
+def search_history(repo, ref, ds_path, matcher):
+    """
+    Yields a match for every commit in the history of ref that gave a feature a matching value - either by inserting
+    the feature, or by updating it so that a field matches that didn't match before. Newest commits are first.
+    """
+    commit_ids = _commits_that_changed(repo, ref, ds_path)
+    if not commit_ids:
+        raise NotFound(f"No dataset found at {ds_path}", exit_code=NO_DATA)
+    fields_checked = False
+    for commit_id in commit_ids:
+        commit = repo[commit_id]
+        new_ds = repo.datasets(commit).get(ds_path)
+        if new_ds is None:
+            # The dataset was deleted by this commit.
+            continue
+        if not fields_checked:
+            _check_fields(new_ds, matcher.fields)
+            fields_checked = True
+        old_ds = None
+        if commit.parents:
+            # For merge commits, only changes relative to the first parent are considered.
+            old_ds = repo.datasets(commit.parents[0]).get(ds_path)
+
+        if old_ds is not None:
+            deltas = old_ds.diff_feature(new_ds)
+        else:
+            deltas = new_ds.diff_feature(None, reverse=True)
+        for delta in deltas:
+            if delta.new is None:
+                continue
+            new_matches = dict(matcher.matches(delta.new_value))
+            if not new_matches:
+                continue
+            old_matches = dict(matcher.matches(delta.old_value)) if delta.old else {}
+            for field, value in new_matches.items():
+                if old_matches.get(field) == value:
+                    continue
+                yield {
+                    "commit": commit.hex,
+                    "id": delta.new_key,
+                    "field": field,
+                    "value": value,
+                }
+
+
+@click.command(cls=KartCommand)
+@click.pass_context
+@click.option(
+    "--field",
+    "fields",
+    multiple=True,
+    help="The field to search. Can be specified repeatedly. By default, every non-geometry field is searched.",
+)
+@click.option(
+    "--regex",
+    "pattern",
+    required=True,
+    help="The regular expression to search for. It can match any part of the value.",
+)
+@click.option(
+    "--ignore-case",
+    "-i",
+    is_flag=True,
+    help="Match the regular expression case-insensitively.",
+)
+@click.option(
+    "--all-history",
+    is_flag=True,
+    help=(
+        "Search the history of the dataset, rather than just its current features. "
+        "Reports each commit that gave a feature a matching value - ie, where the value came from."
+    ),
+)
+@click.option(
+    "--ref",
+    default="HEAD",
+    help="The commit to search - or to search the history of - instead of HEAD.",
+)
+@click.option(
+    "--output-format",
+    "-o",
+    type=OutputFormatType(
+        output_types=["text", "json"],
+        allow_text_formatstring=False,
+    ),
+    default="text",
+)
+@click.argument("dataset", shell_complete=repo_path_completer)
+def search(
+    ctx,
+    fields,
+    pattern,
+    ignore_case,
+    all_history,
+    ref,
+    output_format,
+    dataset,
+):
+    """
+    Search the values of a dataset's features, and report the primary keys of the features that match.
+
+    With --all-history, the whole history of the dataset is searched - this finds the commits where matching values
+    were introduced.
+
+    eg: kart search roads --field name --regex 'Main St.*' --all-history
+    """
+    repo = ctx.obj.repo
+    try:
+        repo.revparse_single(ref).peel(pygit2.Commit)
+    except (KeyError, ValueError):
+        raise NotFound(f"{ref} is not a commit", exit_code=NO_COMMIT)
+
+    matcher = FeatureMatcher(pattern, fields, ignore_case=ignore_case)
+    if all_history:
+        results = search_history(repo, ref, dataset, matcher)
+    else:
+        results = search_current(repo, ref, dataset, matcher)
+
+    output_type, fmt = output_format
+    if output_type == "json":
+        dump_json_output({"kart.search/v1": list(results)}, "-")
+        return
+
+    found = False
+    for result in results:
+        found = True
+        prefix = f"{result['commit'][:7]} " if result["commit"] else ""
+        click.echo(
+            f"{prefix}{dataset}:{result['id']}\t{result['field']}={result['value']}"
+        )
+    if not found:
+        click.echo("No matches found", err=True)
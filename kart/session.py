@@ -0,0 +1,303 @@
+import logging
+import re
+import sys
+
+import click
+import pygit2
+
+from kart.cli_util import KartCommand, KartGroup, StringFromFile
+from kart.completion_shared import ref_completer
+from kart.core import check_git_user
+from kart.exceptions import (
+    InvalidOperation,
+    NotFound,
+    NO_CHANGES,
+    NO_DATA,
+)
+from kart.output_util import dump_json_output
+from kart.pack_util import write_to_packfile
+
+L = logging.getLogger("kart.session")
+
+# Each session's base commit is kept at refs/sessions/NAME, so that it isn't garbage collected while the session lasts.
+SESSION_REF_PREFIX = "refs/sessions/"
+SESSION_NAME_PATTERN = re.compile(r"^[A-Za-z0-9][A-Za-z0-9_.-]*$")
+
+
+def _session_location_key(name):
+    return f"kart.session.{name}.location"
+
+
+class EditSession:
+    """
+    A named, long-running edit session: a GeoPackage that is checked out at a base commit, separately from the
+    repository's own working copy, so that it can be taken away and edited for days. When the session is
+    committed, the changes made to the GeoPackage are rebased onto whatever the current branch is by then.
+    """
+
+    def __init__(self, repo, name, base_commit, location):
+        self.repo = repo
+        self.name = name
+        self.base_commit = base_commit
+        self.location = location
+
+    @property
+    def ref(self):
+        return f"{SESSION_REF_PREFIX}{self.name}"
+
+    @classmethod
+    def load(cls, repo, name):
+        ref = f"{SESSION_REF_PREFIX}{name}"
+        location = repo.get_config_str(_session_location_key(name))
+        if ref not in repo.references or not location:
+            raise NotFound(f"No edit session found called {name}", exit_code=NO_DATA)
+        base_commit = repo.references[ref].peel(pygit2.Commit)
+        return cls(repo, name, base_commit, location)
+
+    @classmethod
+    def all(cls, repo):
+        names = sorted(
+            r[len(SESSION_REF_PREFIX) :]
+            for r in repo.references
+            if r.startswith(SESSION_REF_PREFIX)
+        )
+        return [cls.load(repo, name) for name in names]
+
+    def working_copy(self, allow_uncreated=False):
+        from kart.tabular.working_copy.base import TableWorkingCopy
+
+        wc = TableWorkingCopy.get_at_location(
+            self.repo, self.location, allow_uncreated=allow_uncreated
+        )
+        if wc is None:
+            raise NotFound(
+                f"The GeoPackage for edit session {self.name} is missing: {self.location}",
+                exit_code=NO_DATA,
+            )
+        return wc
+
+    def save(self):
+        self.repo.references.create(self.ref, self.base_commit.id, force=True)
+        self.repo.config[_session_location_key(self.name)] = self.location
+
+    def delete(self, delete_gpkg=True):
+        if delete_gpkg:
+            gpkg_path = self.repo.workdir_path / self.location
+            if gpkg_path.exists():
+                self.working_copy(allow_uncreated=True).delete()
+        self.repo.del_config(_session_location_key(self.name))
+        if self.ref in self.repo.references:
+            self.repo.references.delete(self.ref)
+
+    def changes(self):
+        """Returns the RepoDiff of the changes made to the session's GeoPackage since the session started."""
+        return self.working_copy().diff_repo_to_working_copy()
+
+    def as_json(self):
+        return {
+            "name": self.name,
+            "baseCommit": self.base_commit.id.hex,
+            "abbrevBaseCommit": self.base_commit.short_id,
+            "location": self.location,
+        }
+
+
+def rebase_session_tree(repo, session, session_tree):
+    """
+    Three-way merges the session's changes - from its base commit to session_tree - onto the current HEAD.
+    Returns the merged tree ID, or raises InvalidOperation if any of the changes conflict with changes that have
+    been committed since the session started.
+    """
+    head_tree = repo.head_tree
+    base_tree = session.base_commit.peel(pygit2.Tree)
+    if head_tree.id == base_tree.id:
+        return session_tree.id
+
+    index = repo.merge_trees(
+        ancestor=base_tree,
+        ours=head_tree,
+        theirs=session_tree,
+        flags={"find_renames": False},
+    )
+    if index.conflicts:
+        structure = repo.structure("HEAD")
+        labels = sorted(
+            ":".join(
+                str(p)
+                for p in structure.decode_path(next(e for e in c if e is not None).path)
+            )
+            for c in index.conflicts
+        )
+        desc = "\n".join(labels[:10] + (["..."] if len(labels) > 10 else []))
+        raise InvalidOperation(
+            f"Edit session {session.name} conflicts with changes committed since it started:\n{desc}\n"
+            f"Use `kart session commit {session.name} --branch BRANCH` to commit the session to a new branch "
+            "instead, then merge it with `kart merge BRANCH`."
+        )
+    return index.write_tree(repo)
+
+
+@click.group(cls=KartGroup)
+@click.pass_context
+def session(ctx, **kwargs):
+    """
+    Manage long-running edit sessions. Each session has its own GeoPackage, checked out at the commit the session
+    started from, which can be edited while disconnected - for days, if need be. When the session is committed,
+    its changes are rebased onto the current branch.
+    """
+
+
+@session.command(cls=KartCommand, name="start", writes_to_repo=True)
+@click.pass_context
+@click.option(
+    "--ref",
+    default="HEAD",
+    shell_complete=ref_completer,
+    help="The commit to start the session from. Defaults to HEAD.",
+)
+@click.option(
+    "--path",
+    "location",
+    help="Where to create the session's GeoPackage. Defaults to a .gpkg file named after the session.",
+)
+@click.argument("name")
+def session_start(ctx, ref, location, name):
+    """Start a new edit session NAME, creating a GeoPackage containing the datasets at the given commit."""
+    from kart.tabular.working_copy.base import TableWorkingCopy
+
+    repo = ctx.obj.repo
+    if not SESSION_NAME_PATTERN.match(name):
+        raise click.BadParameter(
+            "Session names can only contain letters, numbers, '_', '-' and '.'",
+            param_hint="NAME",
+        )
+    if f"{SESSION_REF_PREFIX}{name}" in repo.references:
+        raise InvalidOperation(f"An edit session called {name} already exists")
+
+    base_commit = repo.revparse_single(ref).peel(pygit2.Commit)
+    if location is None:
+        location = f"{repo.workdir_path.stem}-{name}.gpkg"
+    TableWorkingCopy.check_valid_creation_location(location, repo)
+    location = TableWorkingCopy.normalise_location(location, repo)
+
+    edit_session = EditSession(repo, name, base_commit, location)
+    wc = edit_session.working_copy(allow_uncreated=True)
+    click.echo(f"Creating edit session {name} at {wc} ...")
+    wc.create_and_initialise()
+    wc.reset(base_commit)
+    edit_session.save()
+    click.echo(
+        f"Started edit session {name} from commit {base_commit.short_id}. "
+        f"When you're done, use `kart session commit {name}`."
+    )
+
+
+@session.command(cls=KartCommand, name="list")
+@click.pass_context
+@click.option(
+    "--output-format",
+    "-o",
+    type=click.Choice(["text", "json"]),
+    default="text",
+)
+def session_list(ctx, output_format):
+    """List the ongoing edit sessions."""
+    repo = ctx.obj.repo
+    sessions = EditSession.all(repo)
+    if output_format == "json":
+        dump_json_output(
+            {"kart.sessions/v1": [s.as_json() for s in sessions]}, sys.stdout
+        )
+        return
+    for s in sessions:
+        click.echo(f"{s.name}  {s.base_commit.short_id}  {s.location}")
+
+
+@session.command(cls=KartCommand, name="commit", writes_to_repo=True)
+@click.pass_context
+@click.option(
+    "--message",
+    "-m",
+    type=StringFromFile(encoding="utf-8"),
+    help="Use the given message as the commit message. Defaults to a message naming the session.",
+)
+@click.option(
+    "--branch",
+    help=(
+        "Instead of rebasing the session's changes onto the current branch, commit them onto the session's base "
+        "commit as a new branch - eg, if they conflict with changes made since the session started."
+    ),
+)
+@click.option(
+    "--keep",
+    is_flag=True,
+    help="Don't delete the session's GeoPackage once its changes are committed.",
+)
+@click.argument("name")
+def session_commit(ctx, message, branch, keep, name):
+    """
+    Commit the changes made in the edit session NAME, and end the session.
+
+    The changes are rebased onto the current branch - as if they had been made to the latest commit - so that
+    the history stays linear. If they conflict with changes committed since the session started, nothing is
+    committed.
+    """
+    repo = ctx.obj.repo
+    edit_session = EditSession.load(repo, name)
+    changes = edit_session.changes()
+    if not changes:
+        raise NotFound(
+            f"No changes to commit in edit session {name}", exit_code=NO_CHANGES
+        )
+    if branch is None:
+        ctx.obj.check_not_dirty()
+    elif f"refs/heads/{branch}" in repo.references:
+        raise InvalidOperation(f"A branch named '{branch}' already exists")
+
+    check_git_user(repo)
+    message = message or f"Edit session {name}"
+    base_structure = repo.structure(edit_session.base_commit.id.hex)
+    session_tree = repo[base_structure.create_tree_from_diff(changes).id]
+    with write_to_packfile(repo):
+        if branch is not None:
+            commit_id = repo.create_commit(
+                f"refs/heads/{branch}",
+                repo.author_signature(),
+                repo.committer_signature(),
+                message,
+                session_tree.id,
+                [edit_session.base_commit.id],
+            )
+        else:
+            tree_id = rebase_session_tree(repo, edit_session, session_tree)
+            commit_id = repo.create_commit(
+                repo.head.name,
+                repo.author_signature(),
+                repo.committer_signature(),
+                message,
+                tree_id,
+                [repo.head.target],
+            )
+
+    edit_session.delete(delete_gpkg=not keep)
+    if branch is not None:
+        click.echo(f"Committed edit session {name} to branch {branch}: {commit_id}")
+        return
+    click.echo(f"Committed edit session {name}: {commit_id}")
+    repo.working_copy.reset_to_head()
+
+
+@session.command(cls=KartCommand, name="abort", writes_to_repo=True)
+@click.pass_context
+@click.option(
+    "--keep",
+    is_flag=True,
+    help="Don't delete the session's GeoPackage.",
+)
+@click.argument("name")
+def session_abort(ctx, keep, name):
+    """End the edit session NAME without committing its changes."""
+    repo = ctx.obj.repo
+    edit_session = EditSession.load(repo, name)
+    edit_session.delete(delete_gpkg=not keep)
+    click.echo(f"Aborted edit session {name}")
@@ -139,6 +139,7 @@ def resolve(ctx, reference, do_envelope, output_format):
 def index(ctx, clear_existing, dry_run, debug, commits):
     """
     Maintains the index needed to perform a spatially-filtered clone using this repo as the server.
+    The index is also used by `kart features-at` to find the features near a point or area at an indexed commit.
     Indexes all features added by the supplied commits and their ancestors.
     If no commits are supplied, indexes all features in all commits.
     """
@@ -0,0 +1,184 @@
+"""
+The feature cell index - maps cells of a quadtree covering the globe to the features whose envelopes touch them, so
+that the features near an area at a particular commit can be found without reading every feature.
+
+The cells are the cells of a quadtree over longitude / latitude in WGS 84. Each cell is named by a string of the
+digits 0-3, one per level of the quadtree - so the empty string is the whole world, and each cell's name is a prefix
+of the names of the cells within it. A feature is recorded in the few smallest cells that cover its envelope.
+
+Rows are keyed by (dataset path, cell, feature path, blob ID) and are never removed - a row is only relevant to a
+particular commit if the feature at that path at that commit is still that blob. Rows are added incrementally, by
+diffing each newly indexed commit against its first parent, so every feature of every indexed commit is covered.
+"""
+
+import math
+from itertools import islice
+
+from kart.exceptions import SubprocessError
+from kart.rev_list_objects import FEATURE_BLOBS_PATTERN
+from kart import subprocess_util as subprocess
+
+# The smallest cells are about 20m across at the equator.
+MAX_LEVEL = 20
+
+# Each feature (or query) envelope is covered by at most this many cells on each side of the anti-meridian.
+MAX_CELLS = 4
+
+# Features that have no envelope - because they have no geometry, or couldn't be indexed - are recorded in the
+# root cell, so that they are always checked.
+ROOT_CELL = ""
+
+# How many rows to look up or insert at a time.
+BATCH_SIZE = 500
+
+
+def _lon_ranges(w, e):
+    # Envelopes that cross the anti-meridian have w > e.
+    return [(w, e)] if w <= e else [(w, 180), (-180, e)]
+
+
+def _cell_coord(value, min_value, max_value, level):
+    count = 1 << level
+    coord = math.floor((value - min_value) / (max_value - min_value) * count)
+    return min(count - 1, max(0, coord))
+
+
+def _cell_name(x, y, level):
+    return "".join(
+        str(((y >> i) & 1) * 2 + ((x >> i) & 1)) for i in range(level - 1, -1, -1)
+    )
+
+
+def cells_for_envelope(envelope):
+    """
+    Returns the set of cells that cover the given (w, s, e, n) envelope in WGS 84 - the smallest cells such that no
+    more than MAX_CELLS are needed on each side of the anti-meridian.
+    """
+    w, s, e, n = envelope
+    result = set()
+    for range_w, range_e in _lon_ranges(w, e):
+        for level in range(MAX_LEVEL, -1, -1):
+            x0 = _cell_coord(range_w, -180, 180, level)
+            x1 = _cell_coord(range_e, -180, 180, level)
+            y0 = _cell_coord(s, -90, 90, level)
+            y1 = _cell_coord(n, -90, 90, level)
+            if (x1 - x0 + 1) * (y1 - y0 + 1) <= MAX_CELLS:
+                result.update(
+                    _cell_name(x, y, level)
+                    for x in range(x0, x1 + 1)
+                    for y in range(y0, y1 + 1)
+                )
+                break
+    return result
+
+
+def _batches(iterable, size):
+    iterator = iter(iterable)
+    while True:
+        batch = list(islice(iterator, size))
+        if not batch:
+            return
+        yield batch
+
+
+def _added_feature_blobs(repo, start_commits, stop_commits):
+    """
+    Yields (ds_path, feature_path, blob_id) for every feature added or changed by the commits between the start and
+    stop commits, relative to each commit's first parent.
+    """
+    cmd = [
+        "git",
+        "-C",
+        repo.path,
+        "log",
+        "--format=%H",
+        "--raw",
+        "-r",
+        "--no-abbrev",
+        "--no-renames",
+        "--root",
+        "--diff-merges=first-parent",
+        *start_commits,
+        "--not",
+        *stop_commits,
+    ]
+    try:
+        with subprocess.Popen(cmd, stdout=subprocess.PIPE, encoding="utf8") as p:
+            for line in p.stdout:
+                # Raw diff lines look like ":100644 100644 <old-oid> <new-oid> M\t<path>"
+                if not line.startswith(":"):
+                    continue
+                meta, path = line.rstrip("\n").split("\t", 1)
+                _, _, _, new_oid, status = meta.split()
+                if status == "D":
+                    continue
+                m = FEATURE_BLOBS_PATTERN.fullmatch(path)
+                if m:
+                    yield m.group(1), path, new_oid
+    except subprocess.CalledProcessError as e:
+        raise SubprocessError(
+            f"There was a problem with git log: {e}", called_process_error=e
+        )
+
+
+def update_feature_cells(repo, dbcur, encoder, start_commits, stop_commits):
+    """
+    Adds the cells of every feature added or changed between the start and stop commits to the feature_cells table.
+    Envelopes are read from the feature_envelopes table, so the feature blobs must be indexed first.
+    Returns the number of features added.
+    """
+    count = 0
+    added = _added_feature_blobs(repo, start_commits, stop_commits)
+    for batch in _batches(added, BATCH_SIZE):
+        blob_ids = list({bytes.fromhex(blob_id) for _, _, blob_id in batch})
+        placeholders = ",".join("?" * len(blob_ids))
+        envelopes = {
+            bytes(blob_id): encoder.decode(bytes(envelope))
+            for blob_id, envelope in dbcur.execute(
+                f"SELECT blob_id, envelope FROM feature_envelopes WHERE blob_id IN ({placeholders});",
+                blob_ids,
+            )
+        }
+        params = []
+        for ds_path, feature_path, blob_id in batch:
+            blob_id = bytes.fromhex(blob_id)
+            envelope = envelopes.get(blob_id)
+            cells = cells_for_envelope(envelope) if envelope else {ROOT_CELL}
+            params.extend((ds_path, cell, feature_path, blob_id) for cell in cells)
+        dbcur.executemany(
+            "INSERT OR IGNORE INTO feature_cells (ds_path, cell, feature_path, blob_id) VALUES (?, ?, ?, ?);",
+            params,
+        )
+        count += len(batch)
+    return count
+
+
+def find_candidate_features(db, ds_path, envelope):
+    """
+    Yields (feature_path, blob_id) for every feature of the given dataset whose cells touch the cells of the given
+    (w, s, e, n) envelope in WGS 84 - at any indexed commit. The caller should check that the feature at that path is
+    that blob at the commit in question, and that it actually intersects whatever is being queried.
+    """
+    query_cells = cells_for_envelope(envelope)
+    # A feature's cell touches a query cell if it is the same cell, or contains it, or is contained by it.
+    containing_cells = {cell[:i] for cell in query_cells for i in range(len(cell))}
+    clauses = []
+    params = [ds_path]
+    if containing_cells:
+        clauses.append(f"cell IN ({','.join('?' * len(containing_cells))})")
+        params.extend(containing_cells)
+    for cell in query_cells:
+        # Cell names only contain the digits 0-3, so this range is the cell and every cell within it.
+        clauses.append("(cell >= ? AND cell < ?)")
+        params.extend((cell, cell + "4"))
+
+    seen = set()
+    rows = db.execute(
+        f"SELECT feature_path, blob_id FROM feature_cells WHERE ds_path = ? AND ({' OR '.join(clauses)});",
+        params,
+    )
+    for feature_path, blob_id in rows:
+        key = (feature_path, bytes(blob_id))
+        if key not in seen:
+            seen.add(key)
+            yield feature_path, bytes(blob_id).hex()
@@ -10,7 +10,7 @@ from osgeo import ogr, osr
 from pysqlite3 import dbapi2 as sqlite
 from sqlalchemy import Column, Table
 from sqlalchemy.orm import sessionmaker
-from sqlalchemy.types import BLOB
+from sqlalchemy.types import BLOB, TEXT
 
 from kart.crs_util import make_crs, normalise_wkt
 from kart.exceptions import InvalidOperation, SubprocessError
@@ -18,6 +18,7 @@ from kart.geometry import Geometry
 from kart.repo import KartRepoFiles
 from kart.rev_list_objects import rev_list_feature_blobs
 from kart.serialise_util import msg_unpack
+from kart.spatial_filter.cell_index import update_feature_cells
 from kart.sqlalchemy import TableSet
 from kart.sqlalchemy.sqlite import sqlite_engine
 from kart.structs import CommitWithReference
@@ -208,6 +209,18 @@ class SpatialTreeTables(TableSet):
             sqlite_with_rowid=False,
         )
 
+        # "feature_cells" maps quadtree cells to the features that touch them - see cell_index.py.
+        # Features are identified by path and blob ID, since the same blob can be found at more than one path.
+        self.feature_cells = Table(
+            "feature_cells",
+            self.sqlalchemy_metadata,
+            Column("ds_path", TEXT, nullable=False, primary_key=True),
+            Column("cell", TEXT, nullable=False, primary_key=True),
+            Column("feature_path", TEXT, nullable=False, primary_key=True),
+            Column("blob_id", BLOB, nullable=False, primary_key=True),
+            sqlite_with_rowid=False,
+        )
+
 
 SpatialTreeTables.copy_tables_to_class()
 
@@ -215,6 +228,7 @@ SpatialTreeTables.copy_tables_to_class()
 def drop_tables(sess):
     sess.execute("DROP TABLE IF EXISTS commits;")
     sess.execute("DROP TABLE IF EXISTS feature_envelopes;")
+    sess.execute("DROP TABLE IF EXISTS feature_cells;")
 
 
 def _minimal_description_of_commit_set(repo, commits):
@@ -302,7 +316,16 @@ def update_spatial_filter_index(
 
     crs_helper = CrsHelper(repo, start_commits, stop_commits)
 
-    if not start_commits:
+    # Indexes made before the feature_cells table existed need their cells indexed from the very start.
+    with sessionmaker(bind=engine)() as sess:
+        feature_cells_exists = sess.scalar(
+            "SELECT count(*) FROM sqlite_master WHERE name = 'feature_cells';"
+        )
+    cell_start_commits, cell_stop_commits = start_commits, stop_commits
+    if stop_commits and not feature_cells_exists:
+        cell_start_commits, cell_stop_commits = all_independent_commits, set()
+
+    if not cell_start_commits:
         click.echo("Nothing to do: index already up to date.")
         return
 
@@ -328,7 +351,9 @@ def update_spatial_filter_index(
     # but in terms of logging it makes more sense to say: indexing from <ANCESTORS> to <CURRENT>.
     ancestor_desc = _format_commits(repo, stop_commits)
     current_desc = _format_commits(repo, start_commits)
-    if not ancestor_desc:
+    if not start_commits:
+        click.echo("Feature envelopes are up to date - indexing feature cells only ...")
+    elif not ancestor_desc:
         click.echo(f"Indexing from the very start up to {current_desc} ...")
     else:
         click.echo(f"Indexing from {ancestor_desc} up to {current_desc} ...")
@@ -380,6 +405,12 @@ def update_spatial_filter_index(
         click.echo(f"  {i:,d} features... @{time.monotonic()-t0:.1f}s")
         L.flush_bulk_warns()
 
+        click.echo("Indexing feature cells ...")
+        cell_count = update_feature_cells(
+            repo, dbcur, encoder, cell_start_commits, cell_stop_commits
+        )
+        click.echo(f"  {cell_count:,d} features @{time.monotonic()-t0:.1f}s")
+
         # Update indexed commits.
         params = [(bytes.fromhex(commit_id),) for commit_id in all_independent_commits]
         dbcur.execute("DELETE FROM commits;")
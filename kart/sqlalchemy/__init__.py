@@ -7,12 +7,16 @@ from sqlalchemy import MetaData
 
 
 class DbType(Enum):
-    """Different types of Database connection currently supported Kart."""
+    """
+    Different types of Database connection currently supported Kart.
+    Not every type can be used as a working copy - see TableWorkingCopyType.
+    """
 
     GPKG = auto()
     POSTGIS = auto()
     SQL_SERVER = auto()
     MYSQL = auto()
+    ORACLE = auto()
 
     @classmethod
     def from_spec(cls, spec):
@@ -23,6 +27,8 @@ class DbType(Enum):
             return DbType.SQL_SERVER
         elif spec.startswith("mysql:"):
             return DbType.MYSQL
+        elif spec.startswith("oracle:"):
+            return DbType.ORACLE
         elif spec.lower().endswith(".gpkg"):
             return DbType.GPKG
         return None
@@ -45,6 +51,10 @@ class DbType(Enum):
             from .mysql import Db_MySql
 
             return Db_MySql
+        elif self is DbType.ORACLE:
+            from .oracle import Db_Oracle
+
+            return Db_Oracle
         raise RuntimeError("Invalid DbType")
 
     @property
@@ -65,6 +75,10 @@ class DbType(Enum):
             from .adapter.mysql import KartAdapter_MySql
 
             return KartAdapter_MySql
+        elif self is DbType.ORACLE:
+            from .adapter.oracle import KartAdapter_Oracle
+
+            return KartAdapter_Oracle
         raise RuntimeError("Invalid DbType")
 
     @property
@@ -77,6 +91,8 @@ class DbType(Enum):
             return "mssql"
         elif self is DbType.MYSQL:
             return "mysql"
+        elif self is DbType.ORACLE:
+            return "oracle"
 
     def path_length(self, spec):
         """
@@ -97,6 +113,8 @@ class DbType(Enum):
             return 2  # DBNAME.TABLE
         elif self in (self.POSTGIS, self.SQL_SERVER):
             return 3  # DBNAME.DBSCHEMA.TABLE
+        elif self is self.ORACLE:
+            return 3  # SERVICE.OWNER.TABLE
 
     @property
     def path_length_for_table_container(self):
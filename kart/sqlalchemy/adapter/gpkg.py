@@ -89,8 +89,14 @@ class KartAdapter_GPKG(BaseKartAdapter, Db_GPKG):
         "gpkg_spatial_ref_sys",
         "gpkg_metadata",
         "gpkg_metadata_reference",
+        "gpkgext_relations",
     )
 
+    # The GeoPackage Related Tables Extension, which links the rows of a base table to the rows of a related table -
+    # often a media table of photos or documents - via a mapping table. See https://www.geopackage.org/18-000.html
+    RELATED_TABLES_EXTENSION = "gpkg_related_tables"
+    RELATED_TABLES_DEFINITION = "http://www.geopackage.org/18-000.html"
+
     @classmethod
     def v2_schema_to_sql_spec(cls, schema, v2_obj=None):
         # GPKG requires an integer primary key:
@@ -154,6 +160,9 @@ class KartAdapter_GPKG(BaseKartAdapter, Db_GPKG):
         yield "gpkg_metadata_reference", cls.generate_gpkg_metadata(
             v2_obj, table_name, reference=True
         )
+        yield "gpkgext_relations", cls.generate_gpkgext_relations(
+            v2_obj, table_name
+        )
 
     @classmethod
     def all_v2_meta_items_including_empty(cls, sess, db_schema, table_name, id_salt):
@@ -209,6 +218,12 @@ class KartAdapter_GPKG(BaseKartAdapter, Db_GPKG):
             id_str = crs_util.get_identifier_str(d)
             yield f"crs/{id_str}.wkt", crs_util.normalise_wkt(d)
 
+        gpkgext_relations = gpkg_meta_items.get("gpkgext_relations")
+        if gpkgext_relations:
+            yield "relations.json", cls.gpkgext_relations_to_v2_relations(
+                gpkgext_relations
+            )
+
     @classmethod
     def generate_sqlite_table_info(cls, v2_obj):
         """Generate a sqlite_table_info meta item from a dataset."""
@@ -325,6 +340,163 @@ class KartAdapter_GPKG(BaseKartAdapter, Db_GPKG):
             return cls.json_to_gpkg_metadata(v2json, table_name, reference)
         return None
 
+    @classmethod
+    def generate_gpkgext_relations(cls, v2_obj, table_name, ds_paths=None):
+        """
+        Generate gpkgext_relations rows from the relations.json meta item of a v2 dataset - which refers to the
+        related and mapping tables by their dataset paths. If ds_paths is given, relations to any datasets that
+        aren't in it are left out, since they can't be followed.
+        """
+        relations = v2_obj.get_meta_item("relations.json")
+        if not relations:
+            return []
+        return [
+            {
+                "base_table_name": table_name,
+                "base_primary_column": r["basePrimaryColumn"],
+                "related_table_name": cls._table_name(r["relatedDataset"]),
+                "related_primary_column": r["relatedPrimaryColumn"],
+                "relation_name": r["relationName"],
+                "mapping_table_name": cls._table_name(r["mappingDataset"]),
+            }
+            for r in relations
+            if ds_paths is None
+            or (r["relatedDataset"] in ds_paths and r["mappingDataset"] in ds_paths)
+        ]
+
+    @classmethod
+    def gpkgext_relations_to_v2_relations(cls, gpkgext_relations):
+        """
+        Generate a relations.json meta item from gpkgext_relations rows. Related and mapping tables are referred to
+        by name - which is also the path they are imported to, unless they are renamed.
+        """
+        return [
+            {
+                "relationName": row["relation_name"],
+                "basePrimaryColumn": row["base_primary_column"],
+                "relatedDataset": row["related_table_name"],
+                "relatedPrimaryColumn": row["related_primary_column"],
+                "mappingDataset": row["mapping_table_name"],
+            }
+            for row in gpkgext_relations
+        ]
+
+    @classmethod
+    def _table_name(cls, ds_path):
+        from kart.tabular.table_dataset import TableDataset
+
+        return TableDataset.dataset_path_to_table_name(ds_path)
+
+    @classmethod
+    def write_gpkgext_relations(cls, sess, gpkgext_relations):
+        """
+        Writes the given rows to the gpkgext_relations table - creating it if need be - and registers the Related
+        Tables Extension for it and for each mapping table, as the extension requires.
+        """
+        if not gpkgext_relations:
+            return
+        sess.execute(
+            """
+            CREATE TABLE IF NOT EXISTS gpkgext_relations (
+                id INTEGER PRIMARY KEY AUTOINCREMENT,
+                base_table_name TEXT NOT NULL,
+                base_primary_column TEXT NOT NULL DEFAULT 'id',
+                related_table_name TEXT NOT NULL,
+                related_primary_column TEXT NOT NULL DEFAULT 'id',
+                relation_name TEXT NOT NULL,
+                mapping_table_name TEXT NOT NULL UNIQUE
+            );
+            """
+        )
+        sess.execute(
+            """
+            CREATE TABLE IF NOT EXISTS gpkg_extensions (
+                table_name TEXT,
+                column_name TEXT,
+                extension_name TEXT NOT NULL,
+                definition TEXT NOT NULL,
+                scope TEXT NOT NULL,
+                CONSTRAINT ge_tce UNIQUE (table_name, column_name, extension_name)
+            );
+            """
+        )
+        cls._register_related_tables_extension(sess, "gpkgext_relations")
+        for row in gpkgext_relations:
+            sess.execute(
+                """
+                DELETE FROM gpkgext_relations
+                WHERE mapping_table_name = :mapping_table_name;
+                """,
+                row,
+            )
+            sess.execute(
+                """
+                INSERT INTO gpkgext_relations (
+                    base_table_name, base_primary_column, related_table_name, related_primary_column,
+                    relation_name, mapping_table_name
+                ) VALUES (
+                    :base_table_name, :base_primary_column, :related_table_name, :related_primary_column,
+                    :relation_name, :mapping_table_name
+                );
+                """,
+                row,
+            )
+            cls._register_related_tables_extension(sess, row["mapping_table_name"])
+
+    @classmethod
+    def _register_related_tables_extension(cls, sess, table_name):
+        # column_name is NULL, so the UNIQUE constraint on gpkg_extensions doesn't stop duplicate rows.
+        params = {
+            "table_name": table_name,
+            "extension_name": cls.RELATED_TABLES_EXTENSION,
+            "definition": cls.RELATED_TABLES_DEFINITION,
+        }
+        sess.execute(
+            """
+            DELETE FROM gpkg_extensions
+            WHERE table_name = :table_name AND extension_name = :extension_name;
+            """,
+            params,
+        )
+        sess.execute(
+            """
+            INSERT INTO gpkg_extensions (table_name, column_name, extension_name, definition, scope)
+            VALUES (:table_name, NULL, :extension_name, :definition, 'read-write');
+            """,
+            params,
+        )
+
+    @classmethod
+    def delete_gpkgext_relations(cls, sess, base_table_name):
+        """
+        Deletes the gpkgext_relations rows for the given base table, and the extension rows for their mapping tables.
+        """
+        table_exists = sess.scalar(
+            """
+            SELECT COUNT(*) FROM sqlite_master
+            WHERE type='table' AND name='gpkgext_relations';
+            """
+        )
+        if not table_exists:
+            return
+        params = {
+            "base_table_name": base_table_name,
+            "extension_name": cls.RELATED_TABLES_EXTENSION,
+        }
+        sess.execute(
+            """
+            DELETE FROM gpkg_extensions
+            WHERE extension_name = :extension_name AND table_name IN (
+                SELECT mapping_table_name FROM gpkgext_relations WHERE base_table_name = :base_table_name
+            );
+            """,
+            params,
+        )
+        sess.execute(
+            "DELETE FROM gpkgext_relations WHERE base_table_name = :base_table_name;",
+            params,
+        )
+
     @classmethod
     def _gpkg_to_v2_schema(cls, gpkg_meta_items, id_salt):
         """Generate a v2 Schema from the given gpkg meta items."""
@@ -604,6 +776,14 @@ class KartAdapter_GPKG(BaseKartAdapter, Db_GPKG):
                 cls.METADATA_QUERY.format(select="MR.*"),
                 list,
             ),
+            "gpkgext_relations": (
+                """
+                SELECT base_table_name, base_primary_column, related_table_name, related_primary_column,
+                    relation_name, mapping_table_name
+                FROM gpkgext_relations WHERE base_table_name=:table_name ORDER BY id;
+                """,
+                list,
+            ),
             "gpkg_spatial_ref_sys": (
                 """
                 SELECT DISTINCT SRS.*
@@ -134,6 +134,12 @@ class KartAdapter_MySql(BaseKartAdapter, Db_MySql):
             )
 
         mysql_type = geometry_type_parts[0]
+        if mysql_type.upper() not in cls.MYSQL_GEOMETRY_TYPES:
+            raise NotYetImplemented(
+                "Curved geometries are not supported by MySQL working copy: "
+                f'("{column_schema.name}" {geometry_type.upper()})\n'
+                "To import the data with the curves replaced by linear approximations, use `kart import --linearize`"
+            )
 
         crs_id = None
         crs_name = column_schema.get("geometryCRS")
@@ -0,0 +1,303 @@
+import re
+
+from kart import crs_util
+from kart.exceptions import NotYetImplemented
+from kart.geometry import Geometry
+from kart.sqlalchemy.adapter.base import (
+    BaseKartAdapter,
+    ConverterType,
+    aliased_converter_type,
+)
+from kart.sqlalchemy.oracle import Db_Oracle
+from kart.schema import ColumnSchema, Schema
+from kart.utils import ungenerator
+from sqlalchemy.sql.functions import Function
+
+
+class KartAdapter_Oracle(BaseKartAdapter, Db_Oracle):
+    """
+    Adapts a table in Oracle (and the attached CRS, if there is one) to a V2 dataset.
+    Only reading is supported - Kart can import from Oracle, but can't use it as a working copy, so there is no
+    adapting of V2 datasets to Oracle tables.
+    """
+
+    SQL_TYPE_TO_V2_TYPE = {
+        "BINARY_FLOAT": ("float", 32),
+        "BINARY_DOUBLE": ("float", 64),
+        "BLOB": "blob",
+        "CHAR": "text",
+        "CLOB": "text",
+        "DATE": ("timestamp", None),
+        "FLOAT": ("float", 64),
+        "LONG": "text",
+        "LONG RAW": "blob",
+        "NCHAR": "text",
+        "NCLOB": "text",
+        "NUMBER": "numeric",
+        "NVARCHAR2": "text",
+        "RAW": "blob",
+        "SDO_GEOMETRY": "geometry",
+        "TIMESTAMP": ("timestamp", None),
+        "TIMESTAMP WITH TIME ZONE": ("timestamp", "UTC"),
+        "TIMESTAMP WITH LOCAL TIME ZONE": ("timestamp", "UTC"),
+        "VARCHAR": "text",
+        "VARCHAR2": "text",
+    }
+
+    # NUMBER(p, 0) columns are imported as the smallest integer type that can hold every p-digit value.
+    INTEGER_SIZE_FOR_PRECISION = ((2, 8), (4, 16), (9, 32), (18, 64))
+
+    # The number of dimensions in the DIMINFO of a geometry column, and the V2 geometry type that it implies.
+    GEOMETRY_TYPE_FOR_DIMENSIONS = {3: "GEOMETRY Z", 4: "GEOMETRY ZM"}
+
+    # Oracle adds the fractional-seconds precision to the type name - eg "TIMESTAMP(6) WITH TIME ZONE".
+    _TYPE_PRECISION_PATTERN = re.compile(r"\(\d+\)")
+
+    @classmethod
+    def v2_schema_to_sql_spec(cls, schema, v2_obj=None):
+        raise NotYetImplemented("Oracle is only supported as an import source")
+
+    @classmethod
+    @ungenerator(dict)
+    def all_v2_meta_items_including_empty(
+        cls, sess, db_schema, table_name, id_salt=None
+    ):
+        """
+        Generate all V2 meta items for the given table.
+        Varying the id_salt varies the ids that are generated for the schema.json item.
+        """
+        title = sess.scalar(
+            """
+            SELECT COMMENTS FROM ALL_TAB_COMMENTS
+            WHERE OWNER = :table_schema AND TABLE_NAME = :table_name
+            """,
+            {"table_schema": db_schema, "table_name": table_name},
+        )
+        yield "title", title
+
+        primary_key_sql = """
+            SELECT CC.OWNER, CC.TABLE_NAME, CC.COLUMN_NAME, CC.POSITION
+            FROM ALL_CONS_COLUMNS CC
+            INNER JOIN ALL_CONSTRAINTS C
+            ON CC.OWNER = C.OWNER
+            AND CC.CONSTRAINT_NAME = C.CONSTRAINT_NAME
+            WHERE C.CONSTRAINT_TYPE = 'P'
+        """
+
+        table_info_sql = f"""
+            SELECT
+                C.COLUMN_NAME, C.COLUMN_ID, C.DATA_TYPE, C.CHAR_LENGTH,
+                C.DATA_PRECISION, C.DATA_SCALE,
+                PK.POSITION AS PK_ORDINAL_POSITION
+            FROM ALL_TAB_COLUMNS C
+            LEFT OUTER JOIN ({primary_key_sql}) PK
+            ON (PK.OWNER = C.OWNER)
+            AND (PK.TABLE_NAME = C.TABLE_NAME)
+            AND (PK.COLUMN_NAME = C.COLUMN_NAME)
+            WHERE C.OWNER = :table_schema AND C.TABLE_NAME = :table_name
+            ORDER BY C.COLUMN_ID
+        """
+        r = sess.execute(
+            table_info_sql,
+            {"table_schema": db_schema, "table_name": table_name},
+        )
+        ora_table_info = list(r)
+
+        # Oracle doesn't record the CRS of a geometry column in the column type - it is registered separately in
+        # ALL_SDO_GEOM_METADATA, along with the number of dimensions.
+        spatial_ref_sys_sql = """
+            SELECT
+                M.COLUMN_NAME, M.SRID,
+                (SELECT COUNT(*) FROM TABLE(M.DIMINFO)) AS DIMENSIONS,
+                SRS.AUTH_NAME, SRS.AUTH_SRID, SRS.WKTEXT
+            FROM ALL_SDO_GEOM_METADATA M
+            LEFT OUTER JOIN MDSYS.CS_SRS SRS ON SRS.SRID = M.SRID
+            WHERE M.OWNER = :table_schema AND M.TABLE_NAME = :table_name
+        """
+        r = sess.execute(
+            spatial_ref_sys_sql,
+            {"table_schema": db_schema, "table_name": table_name},
+        )
+        ora_spatial_ref_sys = list(r)
+
+        schema = KartAdapter_Oracle.oracle_to_v2_schema(
+            ora_table_info, ora_spatial_ref_sys, id_salt
+        )
+        yield "schema.json", schema
+
+        for crs_info in ora_spatial_ref_sys:
+            if not crs_info["srid"]:
+                continue
+            auth_name, auth_code = cls._crs_authority(crs_info)
+            wkt = crs_info["wktext"] or ""
+            yield f"crs/{auth_name}:{auth_code}.wkt", crs_util.normalise_wkt(
+                crs_util.ensure_authority_specified(wkt, auth_name, auth_code)
+            )
+
+    @classmethod
+    def _crs_authority(cls, ora_crs_info):
+        # Oracle's own legacy CRS (eg 8307) have authority "Oracle" - these are stored as custom CRS.
+        auth_name = ora_crs_info["auth_name"]
+        auth_code = ora_crs_info["auth_srid"]
+        if auth_name and auth_code and auth_name.upper().startswith("EPSG"):
+            return "EPSG", auth_code
+        return "CUSTOM", ora_crs_info["srid"]
+
+    @classmethod
+    def oracle_to_v2_schema(cls, ora_table_info, ora_crs_info, id_salt):
+        """Generate a V2 schema from the given Oracle metadata."""
+        return Schema(
+            [
+                cls._oracle_to_column_schema(col, ora_crs_info, id_salt)
+                for col in ora_table_info
+            ]
+        )
+
+    @classmethod
+    def _oracle_to_column_schema(cls, ora_col_info, ora_crs_info, id_salt):
+        """
+        Given the Oracle column info for a particular column, converts it to a ColumnSchema.
+
+        Parameters:
+        ora_col_info - info about a single column from ora_table_info.
+        ora_crs_info - info about all the geometry columns and their CRS, in case this column is a geometry column.
+        id_salt - the UUIDs of the generated ColumnSchema are deterministic and depend on
+                  the name and type of the column, and on this salt.
+        """
+        name = ora_col_info["column_name"]
+        pk_index = ora_col_info["pk_ordinal_position"]
+        if pk_index is not None:
+            pk_index -= 1
+
+        if ora_col_info["data_type"].upper() == "SDO_GEOMETRY":
+            data_type, extra_type_info = cls._oracle_type_to_v2_geometry_type(
+                ora_col_info, ora_crs_info
+            )
+        else:
+            data_type, extra_type_info = cls._oracle_type_to_v2_type(ora_col_info)
+
+        col_id = ColumnSchema.deterministic_id(name, data_type, id_salt)
+        return ColumnSchema(
+            id=col_id,
+            name=name,
+            data_type=data_type,
+            pk_index=pk_index,
+            **extra_type_info,
+        )
+
+    @classmethod
+    def _oracle_type_to_v2_type(cls, ora_col_info):
+        sql_type = cls._TYPE_PRECISION_PATTERN.sub("", ora_col_info["data_type"])
+        v2_type, extra_type_info = super().sql_type_to_v2_type(sql_type)
+
+        if v2_type in ("text", "blob"):
+            length = ora_col_info["char_length"] or None
+            if length is not None and length > 0:
+                extra_type_info["length"] = length
+
+        if v2_type == "numeric":
+            precision = ora_col_info["data_precision"]
+            scale = ora_col_info["data_scale"]
+            if precision is not None and scale == 0:
+                for max_precision, size in cls.INTEGER_SIZE_FOR_PRECISION:
+                    if precision <= max_precision:
+                        return "integer", {"size": size}
+            extra_type_info["precision"] = precision or None
+            extra_type_info["scale"] = scale or None
+
+        return v2_type, extra_type_info
+
+    @classmethod
+    def _oracle_type_to_v2_geometry_type(cls, ora_col_info, ora_crs_info):
+        extra_type_info = {"geometryType": "GEOMETRY"}
+
+        crs_row = next(
+            (
+                r
+                for r in ora_crs_info
+                if r["column_name"].upper() == ora_col_info["column_name"].upper()
+            ),
+            None,
+        )
+        if crs_row:
+            geometry_type = cls.GEOMETRY_TYPE_FOR_DIMENSIONS.get(crs_row["dimensions"])
+            if geometry_type:
+                extra_type_info["geometryType"] = geometry_type
+            if crs_row["srid"]:
+                auth_name, auth_code = cls._crs_authority(crs_row)
+                extra_type_info["geometryCRS"] = f"{auth_name}:{auth_code}"
+
+        return "geometry", extra_type_info
+
+    @classmethod
+    def _type_def_for_column_schema(cls, col, dataset=None):
+        if col.data_type == "geometry":
+            # This user-defined GeometryType adapts Oracle's SDO_GEOMETRY to Kart's GPKG geometry.
+            return GeometryType
+        elif col.data_type == "blob":
+            return BlobType
+        elif col.data_type == "numeric":
+            return TextType
+        elif col.data_type == "timestamp":
+            return TimestampType(col.get("timezone"))
+        elif col.data_type == "text":
+            return TextType
+        else:
+            # Don't need to specify type information for other columns at present, since we just pass through the values.
+            return None
+
+
+def _read_lob(value):
+    # LOBs are normally fetched as bytes or str by sqlalchemy, but not when the column type isn't known to be a LOB.
+    return value.read() if hasattr(value, "read") else value
+
+
+@aliased_converter_type
+class GeometryType(ConverterType):
+    """ConverterType so that Oracle's SDO_GEOMETRY is adapted to V2 geometry."""
+
+    def sql_read(self, column):
+        # 1. Reading - SQL layer - wrap in call to SDO_UTIL.TO_WKBGEOMETRY to convert SDO_GEOMETRY to WKB.
+        return Function(
+            "TO_WKBGEOMETRY", column, packagenames=("SDO_UTIL",), type_=self
+        )
+
+    def python_postread(self, wkb):
+        # 2. Reading - Python layer - convert WKB to Kart geometry.
+        return Geometry.from_wkb(_read_lob(wkb))
+
+
+@aliased_converter_type
+class BlobType(ConverterType):
+    # ConverterType to read BLOBs as bytes.
+    def python_postread(self, value):
+        value = _read_lob(value)
+        return bytes(value) if value is not None else None
+
+
+@aliased_converter_type
+class TimestampType(ConverterType):
+    """
+    ConverterType to read DATEs and TIMESTAMPs as text. Oracle's DATE type includes the time, so both are timestamps.
+    Timestamps with a timezone are converted to UTC.
+    """
+
+    def __init__(self, timezone):
+        self.timezone = timezone
+
+    def sql_read(self, column):
+        if self.timezone == "UTC":
+            column = Function("SYS_EXTRACT_UTC", column)
+        return Function("TO_CHAR", column, 'YYYY-MM-DD"T"HH24:MI:SS', type_=self)
+
+
+@aliased_converter_type
+class TextType(ConverterType):
+    """
+    ConverterType to that casts everything to text in the Python layer. Handles NUMBERs (which Kart stores as text),
+    and CLOBs.
+    """
+
+    def python_postread(self, value):
+        value = _read_lob(value)
+        return str(value) if value is not None else None
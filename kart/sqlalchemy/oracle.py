@@ -0,0 +1,101 @@
+import logging
+import sys
+from urllib.parse import urlsplit, urlunsplit
+
+import sqlalchemy
+from kart.exceptions import NO_DRIVER, NotFound
+from sqlalchemy.dialects.oracle.base import OracleDialect, OracleIdentifierPreparer
+
+from .base import BaseDb
+
+L = logging.getLogger("kart.sqlalchemy.oracle")
+
+
+class Db_Oracle(BaseDb):
+    """
+    Functionality for using sqlalchemy to connect to an Oracle database.
+    Oracle is only supported as an import source - not as a working copy.
+    """
+
+    CANONICAL_SCHEME = "oracle"
+    INTERNAL_SCHEME = "oracle+cx_oracle"
+    INSTALL_DOC_URL = "https://python-oracledb.readthedocs.io/en/latest/user_guide/installation.html"
+
+    preparer = OracleIdentifierPreparer(OracleDialect())
+
+    @classmethod
+    def create_engine(cls, orurl):
+        url = urlsplit(orurl)
+        if url.scheme != cls.CANONICAL_SCHEME:
+            raise ValueError("Expecting oracle://")
+
+        cls._ensure_driver()
+
+        # The first (and only) part of the path is the service name - eg oracle://HOST:1521/SERVICE
+        service_name = url.path.strip("/")
+        url_query = url.query
+        if service_name:
+            url_query = cls._append_to_query(url_query, {"service_name": service_name})
+        orurl = urlunsplit([cls.INTERNAL_SCHEME, url.netloc, "/", url_query, ""])
+
+        engine = sqlalchemy.create_engine(orurl, poolclass=cls._pool_class())
+        return engine
+
+    @classmethod
+    def _ensure_driver(cls):
+        """
+        SQLAlchemy 1.4 only knows about cx_Oracle - but python-oracledb (its successor) is a drop-in replacement,
+        and doesn't need the Oracle client libraries to be installed.
+        """
+        try:
+            import cx_Oracle  # noqa: F401
+
+            return
+        except ImportError as e:
+            L.debug("cx_Oracle import error: %s", e)
+
+        try:
+            import oracledb
+        except ImportError as e:
+            L.debug("oracledb import error: %s", e)
+            raise NotFound(
+                f"python-oracledb is required to connect to Oracle but was not found.\nSee {cls.INSTALL_DOC_URL}",
+                exit_code=NO_DRIVER,
+            )
+
+        oracledb.version = "8.3.0"
+        sys.modules["cx_Oracle"] = oracledb
+
+    @classmethod
+    def list_tables(cls, sess, db_schema=None):
+        # TODO - include titles.
+        if db_schema is not None:
+            r = sess.execute(
+                sqlalchemy.text(
+                    """
+                    SELECT TABLE_NAME FROM ALL_TABLES WHERE OWNER = :db_schema
+                    ORDER BY TABLE_NAME
+                    """
+                ),
+                {"db_schema": db_schema},
+            )
+            return {row["table_name"]: None for row in r}
+        else:
+            r = sess.execute(
+                sqlalchemy.text(
+                    """
+                    SELECT T.OWNER, T.TABLE_NAME FROM ALL_TABLES T
+                    INNER JOIN ALL_USERS U ON U.USERNAME = T.OWNER
+                    WHERE U.ORACLE_MAINTAINED = 'N'
+                    ORDER BY T.OWNER, T.TABLE_NAME
+                    """
+                )
+            )
+            return {f"{row['owner']}.{row['table_name']}": None for row in r}
+
+    @classmethod
+    def db_schema_searchpath(cls, sess):
+        current_schema = sess.scalar(
+            sqlalchemy.text("SELECT SYS_CONTEXT('USERENV', 'CURRENT_SCHEMA') FROM DUAL")
+        )
+        return [current_schema]
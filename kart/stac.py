@@ -0,0 +1,167 @@
+"""
+Describes releases (see kart.release) as a STAC catalog, so that they can be found by STAC-based discovery systems.
+The catalog is a single STAC Collection for the repository, with a STAC Item for each release. Each item's assets are
+the artifacts that were exported when the release was created.
+See https://stacspec.org
+"""
+
+import os
+import posixpath
+
+from kart.crs_util import make_crs
+from kart.geometry import bbox_as_wkt_polygon
+
+STAC_VERSION = "1.0.0"
+FILE_EXTENSION = "https://stac-extensions.github.io/file/v2.1.0/schema.json"
+
+# The media type of the artifacts written by each export format.
+MEDIA_TYPES = {
+    "GPKG": "application/geopackage+sqlite3",
+    "SPATIALITE": "application/vnd.sqlite3",
+    "KML": "application/vnd.google-earth.kml+xml",
+    "KMZ": "application/vnd.google-earth.kmz",
+    "DXF": "image/vnd.dxf",
+    "GEOJSON": "application/geo+json",
+    "ARROW": "application/vnd.apache.arrow.file",
+    "PARQUET": "application/vnd.apache.parquet",
+}
+
+# A multihash is the hash's code and length, followed by the hash itself. 0x12 is the code for SHA-256.
+SHA256_MULTIHASH_PREFIX = "1220"
+
+# STAC collections must have a spatial extent, even if the extent of the data isn't known.
+WORLD_BBOX = [-180, -90, 180, 90]
+
+
+def _wgs84_bbox(extent, crs_definition):
+    """Reprojects the given [min-x, min-y, max-x, max-y] extent in the given CRS to a STAC bbox in WGS 84."""
+    from osgeo import ogr, osr
+
+    min_x, min_y, max_x, max_y = extent
+    geom = ogr.CreateGeometryFromWkt(bbox_as_wkt_polygon(min_x, max_x, min_y, max_y))
+    # Add vertices along each edge, since straight edges in one CRS are curved in another.
+    geom.Segmentize(max(max_x - min_x, max_y - min_y) / 16 or 1)
+    transform = osr.CoordinateTransformation(
+        make_crs(crs_definition), make_crs("EPSG:4326")
+    )
+    geom.Transform(transform)
+    w, e, s, n = geom.GetEnvelope()
+    return [w, s, e, n]
+
+
+def release_bbox(repo, manifest):
+    """
+    Returns the union of the extents of the datasets in the given release manifest, as a STAC bbox in WGS 84 -
+    or None if no extents were recorded.
+    """
+    bboxes = []
+    datasets = repo.datasets(manifest["commit"])
+    for ds_path, stats in manifest["datasets"].items():
+        extent = stats.get("extent")
+        if not extent:
+            continue
+        crs_definitions = datasets[ds_path].crs_definitions()
+        if not crs_definitions:
+            continue
+        bboxes.append(_wgs84_bbox(extent, next(iter(crs_definitions.values()))))
+    return union_bbox(bboxes)
+
+
+def union_bbox(bboxes):
+    bboxes = [b for b in bboxes if b]
+    if not bboxes:
+        return None
+    return [
+        min(b[0] for b in bboxes),
+        min(b[1] for b in bboxes),
+        max(b[2] for b in bboxes),
+        max(b[3] for b in bboxes),
+    ]
+
+
+def _bbox_geometry(bbox):
+    if bbox is None:
+        return None
+    w, s, e, n = bbox
+    return {
+        "type": "Polygon",
+        "coordinates": [[[w, s], [e, s], [e, n], [w, n], [w, s]]],
+    }
+
+
+def _asset_href(artifact, version, releases_dir, item_dir, base_url):
+    if base_url:
+        return posixpath.join(base_url.rstrip("/"), version, artifact["path"])
+    artifact_path = os.path.join(releases_dir, version, artifact["path"])
+    return os.path.relpath(artifact_path, item_dir).replace(os.sep, "/")
+
+
+def release_item(repo, manifest, collection_id, *, releases_dir, item_dir, base_url):
+    """Returns a STAC Item describing the given release."""
+    version = manifest["version"]
+    bbox = release_bbox(repo, manifest)
+    assets = {}
+    for artifact in manifest.get("artifacts", []):
+        assets[artifact["path"]] = {
+            "href": _asset_href(artifact, version, releases_dir, item_dir, base_url),
+            "title": f"{artifact['format']}: {', '.join(artifact['datasets'])}",
+            "roles": ["data"],
+            "file:size": artifact["size"],
+            "file:checksum": SHA256_MULTIHASH_PREFIX + artifact["sha256"],
+        }
+        media_type = MEDIA_TYPES.get(artifact["format"])
+        if media_type:
+            assets[artifact["path"]]["type"] = media_type
+
+    item = {
+        "type": "Feature",
+        "stac_version": STAC_VERSION,
+        "stac_extensions": [FILE_EXTENSION],
+        "id": version,
+        "collection": collection_id,
+        "geometry": _bbox_geometry(bbox),
+        "properties": {
+            "datetime": manifest["created"],
+            "kart:commit": manifest["commit"],
+            "kart:previous": manifest.get("previous"),
+            "kart:datasets": manifest["datasets"],
+        },
+        "assets": assets,
+        "links": [
+            {"rel": rel, "href": "../collection.json", "type": "application/json"}
+            for rel in ("root", "parent", "collection")
+        ],
+    }
+    if bbox is not None:
+        item["bbox"] = bbox
+    return item
+
+
+def release_collection(collection_id, description, license, items):
+    """Returns a STAC Collection containing the given release items."""
+    datetimes = [item["properties"]["datetime"] for item in items]
+    return {
+        "type": "Collection",
+        "stac_version": STAC_VERSION,
+        "id": collection_id,
+        "description": description,
+        "license": license,
+        "extent": {
+            "spatial": {
+                "bbox": [union_bbox([item.get("bbox") for item in items]) or WORLD_BBOX]
+            },
+            "temporal": {"interval": [[min(datetimes), max(datetimes)]]},
+        },
+        "links": [
+            {"rel": "root", "href": "./collection.json", "type": "application/json"},
+            *(
+                {
+                    "rel": "item",
+                    "href": f"./{item['id']}/{item['id']}.json",
+                    "type": "application/geo+json",
+                    "title": item["id"],
+                }
+                for item in items
+            ),
+        ],
+    }
@@ -0,0 +1,333 @@
+import fnmatch
+import math
+import re
+import sys
+
+import click
+
+from kart.cli_util import KartCommand, KartGroup
+from kart.completion_shared import ref_completer
+from kart.exceptions import SUCCESS_WITH_FLAG
+from kart.output_util import dump_json_output
+
+# Summary statistics are calculated for the values of these types of columns - other columns only have their NULLs
+# counted.
+NUMERIC_TYPES = ("integer", "float")
+
+# eg "count delta > 5%", "*.nulls delta >= 100", "population.max > 1000000"
+ALERT_RULE_PATTERN = re.compile(
+    r"^\s*(?P<metric>\S+)\s+(?:(?P<delta>delta)\s+)?(?P<op>>=|<=|>|<)\s*"
+    r"(?P<threshold>[-+]?(?:\d+\.?\d*|\.\d+)(?:[eE][-+]?\d+)?)\s*(?P<percent>%)?\s*$"
+)
+
+OPERATORS = {
+    ">": lambda a, b: a > b,
+    ">=": lambda a, b: a >= b,
+    "<": lambda a, b: a < b,
+    "<=": lambda a, b: a <= b,
+}
+
+
+class AlertRule:
+    """
+    A threshold for a summary statistic - either for its value at the second commit, or with "delta", for how much
+    it changed between the commits: as an absolute amount, or with "%", relative to its value at the first commit.
+    The metric can be a glob pattern, to apply the rule to every metric that matches it - eg "*.nulls".
+    """
+
+    def __init__(self, spec):
+        match = ALERT_RULE_PATTERN.match(spec)
+        if not match:
+            raise ValueError(
+                f"Invalid alert rule: {spec!r} - expected eg 'count delta > 5%' or 'name.nulls > 0'"
+            )
+        self.spec = " ".join(spec.split())
+        self.metric_pattern = match.group("metric")
+        self.is_delta = bool(match.group("delta"))
+        self.op = match.group("op")
+        self.threshold = float(match.group("threshold"))
+        self.is_percent = bool(match.group("percent"))
+        if self.is_percent and not self.is_delta:
+            raise ValueError(
+                f"Invalid alert rule: {spec!r} - percentages are only supported for deltas"
+            )
+
+    def matches(self, metric):
+        return fnmatch.fnmatchcase(metric, self.metric_pattern)
+
+    def is_triggered(self, old_value, new_value):
+        if not self.is_delta:
+            if new_value is None:
+                return False
+            return OPERATORS[self.op](new_value, self.threshold)
+        if old_value is None or new_value is None:
+            return False
+        change = abs(new_value - old_value)
+        if self.is_percent:
+            change = _percent_change(old_value, new_value)
+            change = abs(change) if change is not None else math.inf
+        return OPERATORS[self.op](change, self.threshold)
+
+    def __str__(self):
+        return self.spec
+
+
+class AlertRuleType(click.ParamType):
+    name = "rule"
+
+    def convert(self, value, param, ctx):
+        if isinstance(value, AlertRule):
+            return value
+        try:
+            return AlertRule(value)
+        except ValueError as e:
+            self.fail(str(e), param, ctx)
+
+
+def _percent_change(old_value, new_value):
+    if old_value == new_value:
+        return 0.0
+    if not old_value:
+        # Infinitely larger - which can't be expressed as a percentage.
+        return None
+    return (new_value - old_value) * 100 / abs(old_value)
+
+
+def dataset_stats(dataset):
+    """
+    Returns a dict of {metric: value} summarising the features of the given table dataset: the number of features,
+    the size of their extent, the number of NULLs in each column, and the minimum, maximum and mean of each numeric
+    column.
+    """
+    columns = [c for c in dataset.schema.columns if c.pk_index is None]
+    numeric_names = {c.name for c in columns if c.data_type in NUMERIC_TYPES}
+    geom_columns = dataset.schema.geometry_columns
+    geom_name = geom_columns[0].name if geom_columns else None
+
+    count = 0
+    nulls = {c.name: 0 for c in columns}
+    totals = {name: 0 for name in numeric_names}
+    minimums = {}
+    maximums = {}
+    extent = None
+    for feature in dataset.features():
+        count += 1
+        for name in nulls:
+            value = feature.get(name)
+            if value is None:
+                nulls[name] += 1
+            elif name in numeric_names:
+                totals[name] += value
+                minimums[name] = min(minimums.get(name, value), value)
+                maximums[name] = max(maximums.get(name, value), value)
+        geom = feature.get(geom_name) if geom_name else None
+        envelope = (
+            geom.envelope(only_2d=True, calculate_if_missing=True) if geom else None
+        )
+        if envelope is not None:
+            min_x, max_x, min_y, max_y = envelope
+            if extent is None:
+                extent = [min_x, max_x, min_y, max_y]
+            else:
+                extent = [
+                    min(extent[0], min_x),
+                    max(extent[1], max_x),
+                    min(extent[2], min_y),
+                    max(extent[3], max_y),
+                ]
+
+    result = {"count": count}
+    if geom_name:
+        result["extent.width"] = extent[1] - extent[0] if extent else None
+        result["extent.height"] = extent[3] - extent[2] if extent else None
+    for column in columns:
+        name = column.name
+        result[f"{name}.nulls"] = nulls[name]
+        if name in numeric_names:
+            non_null = count - nulls[name]
+            result[f"{name}.min"] = minimums.get(name)
+            result[f"{name}.max"] = maximums.get(name)
+            result[f"{name}.mean"] = totals[name] / non_null if non_null else None
+    return result
+
+
+def repo_stats(rs, ds_paths=()):
+    """Returns a dict of {ds_path: dataset_stats} for each table dataset in the given RepoStructure."""
+    return {
+        ds.path: dataset_stats(ds)
+        for ds in rs.datasets()
+        if ds.DATASET_TYPE == "table" and (not ds_paths or ds.path in ds_paths)
+    }
+
+
+def diff_stats(old_stats, new_stats):
+    """
+    Returns a dict of {metric: {"old", "new", "delta", "deltaPercent"}} for each metric that differs between the
+    given stats.
+    """
+    result = {}
+    for metric in list(old_stats) + [m for m in new_stats if m not in old_stats]:
+        old_value = old_stats.get(metric)
+        new_value = new_stats.get(metric)
+        if old_value == new_value:
+            continue
+        change = {"old": old_value, "new": new_value}
+        if old_value is not None and new_value is not None:
+            change["delta"] = new_value - old_value
+            change["deltaPercent"] = _percent_change(old_value, new_value)
+        result[metric] = change
+    return result
+
+
+def find_alerts(ds_path, old_stats, new_stats, rules):
+    """Yields a dict describing each of the given rules that is triggered by a metric of the given dataset."""
+    for metric in list(old_stats) + [m for m in new_stats if m not in old_stats]:
+        old_value = old_stats.get(metric)
+        new_value = new_stats.get(metric)
+        for rule in rules:
+            if rule.matches(metric) and rule.is_triggered(old_value, new_value):
+                yield {
+                    "dataset": ds_path,
+                    "metric": metric,
+                    "rule": str(rule),
+                    "old": old_value,
+                    "new": new_value,
+                }
+
+
+def _format_value(value):
+    if value is None:
+        return "none"
+    if isinstance(value, float):
+        return f"{value:.6g}"
+    return str(value)
+
+
+def _format_change(change):
+    text = f"{_format_value(change['old'])} → {_format_value(change['new'])}"
+    if "delta" in change:
+        delta = change["delta"]
+        text += f" ({'+' if delta > 0 else ''}{_format_value(delta)}"
+        percent = change["deltaPercent"]
+        if percent is not None:
+            text += f", {'+' if percent > 0 else ''}{percent:.1f}%"
+        text += ")"
+    return text
+
+
+@click.group(cls=KartGroup)
+@click.pass_context
+def stats(ctx, **kwargs):
+    """
+    Summary statistics of table datasets - feature counts, extents, NULL counts and numeric ranges.
+
+    Use `kart stats diff` with --alert-on to catch unexpected shifts in the data, eg as a check in CI after an
+    automated import.
+    """
+
+
+@stats.command("show", cls=KartCommand)
+@click.pass_context
+@click.option(
+    "--output-format",
+    "-o",
+    type=click.Choice(["text", "json"]),
+    default="text",
+)
+@click.argument("commit", default="HEAD", required=False, shell_complete=ref_completer)
+@click.argument("datasets", nargs=-1)
+def show(ctx, output_format, commit, datasets):
+    """Show the summary statistics of each table dataset at a commit."""
+    repo = ctx.obj.repo
+    all_stats = repo_stats(repo.structure(commit), datasets)
+    if output_format == "json":
+        dump_json_output({"kart.stats/v1": all_stats}, sys.stdout)
+        return
+    for ds_path, ds_stats in sorted(all_stats.items()):
+        click.secho(f"{ds_path}:", bold=True)
+        for metric, value in ds_stats.items():
+            click.echo(f"  {metric}: {_format_value(value)}")
+
+
+@stats.command("diff", cls=KartCommand)
+@click.pass_context
+@click.option(
+    "--alert-on",
+    "rules",
+    type=AlertRuleType(),
+    multiple=True,
+    help=(
+        "Alert if a statistic crosses a threshold - eg 'count delta > 5%', '*.nulls delta > 100' or "
+        "'population.max > 1000000'. If any alerts are raised, exits with code 1. Can be given more than once."
+    ),
+)
+@click.option(
+    "--output-format",
+    "-o",
+    type=click.Choice(["text", "json"]),
+    default="text",
+)
+@click.argument("commit_a", shell_complete=ref_completer)
+@click.argument(
+    "commit_b", default="HEAD", required=False, shell_complete=ref_completer
+)
+@click.argument("datasets", nargs=-1)
+def diff(ctx, rules, output_format, commit_a, commit_b, datasets):
+    """
+    Show how the summary statistics of each table dataset changed between two commits.
+
+    Statistics are compared for each dataset that is in either commit - a dataset that is only in one commit has a
+    count of 0 in the other. The available statistics are listed by `kart stats show`.
+    """
+    repo = ctx.obj.repo
+    old_rs = repo.structure(commit_a)
+    new_rs = repo.structure(commit_b)
+    old_stats = repo_stats(old_rs, datasets)
+
+    # Datasets that are the same at both commits only need their stats calculated once.
+    old_trees = {ds.path: ds.tree.id for ds in old_rs.datasets()}
+    new_stats = {}
+    for ds in new_rs.datasets():
+        if ds.DATASET_TYPE != "table" or (datasets and ds.path not in datasets):
+            continue
+        if old_trees.get(ds.path) == ds.tree.id:
+            new_stats[ds.path] = old_stats[ds.path]
+        else:
+            new_stats[ds.path] = dataset_stats(ds)
+
+    changes = {}
+    alerts = []
+    for ds_path in sorted(old_stats.keys() | new_stats.keys()):
+        old = old_stats.get(ds_path, {"count": 0})
+        new = new_stats.get(ds_path, {"count": 0})
+        ds_changes = diff_stats(old, new)
+        if ds_changes:
+            changes[ds_path] = ds_changes
+        alerts.extend(find_alerts(ds_path, old, new, rules))
+
+    if output_format == "json":
+        dump_json_output(
+            {
+                "kart.stats.diff/v1": {
+                    "base": old_rs.commit.hex,
+                    "target": new_rs.commit.hex,
+                    "datasets": changes,
+                    "alerts": alerts,
+                }
+            },
+            sys.stdout,
+        )
+    else:
+        for ds_path, ds_changes in changes.items():
+            click.secho(f"{ds_path}:", bold=True)
+            for metric, change in ds_changes.items():
+                click.echo(f"  {metric}: {_format_change(change)}")
+        for alert in alerts:
+            click.secho(
+                f"ALERT {alert['dataset']}: {alert['rule']} - {alert['metric']} is "
+                f"{_format_value(alert['new'])} (was {_format_value(alert['old'])})",
+                fg="red",
+                err=True,
+            )
+    if alerts:
+        ctx.exit(SUCCESS_WITH_FLAG)
@@ -384,7 +384,16 @@ class RepoStructure:
         list_of_conflicts.check_repo_diff_is_committable(wcdiff)
         self.check_values_match_schema(wcdiff)
 
+        from kart.tabular.archive import archive_deleted_features
+
         with packfile_object_builder(self.repo, self.tree) as object_builder:
+            archive_deleted_features(
+                self.repo,
+                self,
+                wcdiff,
+                object_builder,
+                author or self.repo.author_signature(),
+            )
             new_tree = self.create_tree_from_diff(
                 wcdiff,
                 resolve_missing_values_from_rs=resolve_missing_values_from_rs,
@@ -0,0 +1,236 @@
+import io
+import json
+import logging
+from pathlib import Path
+
+import click
+
+from kart.apply import apply_patch
+from kart.cli_util import KartCommand, KartGroup, StringFromFile
+from kart.completion_shared import ref_completer, repo_path_completer
+from kart.core import check_git_user
+from kart.exceptions import InvalidOperation, NotFound, NO_DATA, NO_TABLE
+from kart.tabular.ogr_export import LayerStyle
+
+L = logging.getLogger("kart.style")
+
+DEFAULT_STYLE_NAME = "default"
+STYLE_FORMATS = ("qml", "sld")
+
+
+def get_styles(dataset):
+    """
+    Returns the styles of the given table dataset, as a {style-name: {format: document}} dict -
+    eg {"default": {"qml": "<!DOCTYPE qgis...", "sld": "<StyledLayerDescriptor..."}}
+    """
+    definition = getattr(dataset, "STYLE", None)
+    if definition is None:
+        return {}
+    result = {}
+    for path, value in dataset.get_meta_items_matching(definition).items():
+        name = definition.match_group(path, 1)
+        style_format = definition.match_group(path, 2)
+        result.setdefault(name, {})[style_format] = value
+    return result
+
+
+def get_layer_styles(dataset, layer_name):
+    """Returns a LayerStyle for each of the dataset's styles, as written to the layer_styles table on export."""
+    styles = get_styles(dataset)
+    # Use the exported schema, which may have had columns removed.
+    geom_columns = dataset.schema.geometry_columns
+    if not styles or not geom_columns:
+        return []
+    # QGIS applies the default style when the layer is loaded.
+    default_name = (
+        DEFAULT_STYLE_NAME if DEFAULT_STYLE_NAME in styles else min(styles)
+    )
+    return [
+        LayerStyle(
+            layer_name,
+            geom_columns[0].name,
+            name,
+            documents.get("qml"),
+            documents.get("sld"),
+            name == default_name,
+        )
+        for name, documents in sorted(styles.items())
+    ]
+
+
+def _style_format_from_path(path):
+    style_format = path.suffix.lower().lstrip(".")
+    if style_format not in STYLE_FORMATS:
+        raise click.BadParameter(
+            f"Expected a QGIS .qml or an SLD .sld file, got {path.name}",
+            param_hint="FILE",
+        )
+    return style_format
+
+
+def _get_dataset(repo, refish, ds_path):
+    dataset = repo.datasets(refish).get(ds_path)
+    if dataset is None:
+        raise NotFound(f"No dataset found at {ds_path}", exit_code=NO_DATA)
+    if getattr(dataset, "STYLE", None) is None:
+        raise InvalidOperation(
+            f"Styles can't be attached to {ds_path} - only table datasets in a V3 repository can have styles",
+            exit_code=NO_TABLE,
+        )
+    return dataset
+
+
+def _get_style_document(dataset, name, style_format):
+    styles = get_styles(dataset)
+    documents = styles.get(name)
+    if not documents:
+        available = f" - it has: {', '.join(sorted(styles))}" if styles else ""
+        raise NotFound(
+            f"Dataset {dataset.path} has no style called {name}{available}",
+            exit_code=NO_DATA,
+        )
+    if style_format is None:
+        if len(documents) > 1:
+            raise click.UsageError(
+                f"Style {name} has both a QML and an SLD document - specify which one with --format"
+            )
+        style_format = next(iter(documents))
+    if style_format not in documents:
+        raise NotFound(
+            f"Style {name} of {dataset.path} has no {style_format.upper()} document",
+            exit_code=NO_DATA,
+        )
+    return documents[style_format]
+
+
+@click.group(cls=KartGroup)
+@click.pass_context
+def style(ctx, **kwargs):
+    """
+    Attach map styles - QGIS QML or SLD documents - to datasets. Styles are versioned along with the data, and are
+    written to the layer_styles table when datasets are exported to a GPKG, so QGIS applies them automatically.
+    """
+
+
+@style.command(cls=KartCommand, name="set", writes_to_repo=True)
+@click.pass_context
+@click.option(
+    "--name",
+    default=DEFAULT_STYLE_NAME,
+    show_default=True,
+    help="The name of the style. A dataset can have several styles - the one called default is used by default.",
+)
+@click.option(
+    "--message",
+    "-m",
+    type=StringFromFile(encoding="utf-8"),
+    help="Use the given message as the commit message.",
+)
+@click.argument("dataset", shell_complete=repo_path_completer)
+@click.argument(
+    "file", type=click.Path(exists=True, dir_okay=False, path_type=Path)
+)
+def style_set(ctx, name, message, dataset, file):
+    """
+    Attach the style document FILE - either a QGIS .qml file or an SLD .sld file - to DATASET, and create a commit.
+    To remove a style, use eg `kart meta set DATASET style/NAME.qml=`
+    """
+    repo = ctx.obj.repo
+    check_git_user(repo)
+    style_format = _style_format_from_path(file)
+    _get_dataset(repo, "HEAD", dataset)
+    if "/" in name:
+        raise click.BadParameter(
+            "Style names can't contain /", param_hint="--name"
+        )
+
+    document = file.read_text(encoding="utf-8")
+    if message is None:
+        message = f"Update {name} style for {dataset}"
+
+    patch = {
+        "kart.diff/v1+hexwkb": {
+            dataset: {"meta": {f"style/{name}.{style_format}": {"+": document}}}
+        },
+        "kart.patch/v1": {"message": message, "base": repo.head.target.hex},
+    }
+    patch_file = io.StringIO()
+    json.dump(patch, patch_file)
+    patch_file.seek(0)
+    apply_patch(
+        repo=repo,
+        do_commit=True,
+        patch_file=patch_file,
+        allow_empty=False,
+    )
+
+
+@style.command(cls=KartCommand, name="get")
+@click.pass_context
+@click.option(
+    "--ref",
+    default="HEAD",
+    shell_complete=ref_completer,
+    help="The commit to get the style from. Defaults to HEAD.",
+)
+@click.option(
+    "--name",
+    help="The name of the style to show. If not specified, the names of all of the dataset's styles are listed.",
+)
+@click.option(
+    "--format",
+    "style_format",
+    type=click.Choice(STYLE_FORMATS),
+    help="Which document to show, if the style has both a QML and an SLD document.",
+)
+@click.argument("dataset", shell_complete=repo_path_completer)
+def style_get(ctx, ref, name, style_format, dataset):
+    """
+    Show a style attached to DATASET, or list its styles.
+    """
+    dataset = _get_dataset(ctx.obj.repo, ref, dataset)
+    if name is None:
+        for style_name, documents in sorted(get_styles(dataset).items()):
+            formats = ", ".join(f.upper() for f in sorted(documents))
+            click.echo(f"{style_name} ({formats})")
+        return
+    click.echo(_get_style_document(dataset, name, style_format))
+
+
+@style.command(cls=KartCommand, name="export")
+@click.pass_context
+@click.option(
+    "--ref",
+    default="HEAD",
+    shell_complete=ref_completer,
+    help="The commit to export the style from. Defaults to HEAD.",
+)
+@click.option(
+    "--name",
+    default=DEFAULT_STYLE_NAME,
+    show_default=True,
+    help="The name of the style to export.",
+)
+@click.option(
+    "--overwrite",
+    is_flag=True,
+    help="Overwrite the destination file if it already exists.",
+)
+@click.argument("dataset", shell_complete=repo_path_completer)
+@click.argument("file", type=click.Path(dir_okay=False, path_type=Path))
+def style_export(ctx, ref, name, overwrite, dataset, file):
+    """
+    Write a style attached to DATASET to FILE. Whether the QML or SLD document is written depends on the
+    extension of FILE.
+    """
+    style_format = _style_format_from_path(file)
+    dataset = _get_dataset(ctx.obj.repo, ref, dataset)
+    document = _get_style_document(dataset, name, style_format)
+    if file.exists() and not overwrite:
+        raise InvalidOperation(
+            f"{file} already exists - use --overwrite to replace it"
+        )
+    file.write_text(document, encoding="utf-8")
+    click.echo(
+        f"Wrote {style_format.upper()} style {name} of {dataset.path}: {file}"
+    )
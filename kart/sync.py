@@ -0,0 +1,263 @@
+import gzip
+import io
+import json
+import logging
+import os
+import re
+import socket
+import time
+from pathlib import Path
+
+import click
+import pygit2
+
+from kart.cli_util import KartCommand, KartGroup
+from kart.completion_shared import ref_completer
+from kart.exceptions import InvalidOperation, NotFound, NO_DATA
+
+L = logging.getLogger("kart.sync")
+
+# The last commit that this repository pushed as a delta, for each device name it has pushed as.
+SYNC_REF_PREFIX = "refs/sync/"
+DELTA_SUFFIX = ".kartdelta"
+DEVICE_NAME_PATTERN = re.compile(r"^[A-Za-z0-9][A-Za-z0-9_.]*$")
+
+# Deltas are usually exchanged via a directory that is synced over a slow or unreliable connection,
+# or a network share - so reading and writing them is retried a few times before giving up.
+SYNC_RETRIES = int(os.environ.get("KART_SYNC_RETRIES", 3))
+SYNC_RETRY_DELAY = float(os.environ.get("KART_SYNC_RETRY_DELAY", 1.0))
+
+
+def _applied_config_key(device):
+    return f"kart.sync.{device}.applied"
+
+
+def get_device_name(repo, device=None):
+    """Returns the name this repository syncs as - the given name, the kart.sync.device config, or the hostname."""
+    device = device or repo.get_config_str("kart.sync.device")
+    if not device:
+        device = re.sub(r"[^A-Za-z0-9_.]", "_", socket.gethostname().split(".")[0])
+    if not DEVICE_NAME_PATTERN.match(device):
+        raise click.BadParameter(
+            "Device names can only contain letters, numbers, '_' and '.'",
+            param_hint="--device",
+        )
+    return device
+
+
+def with_retries(fn, description):
+    """Calls fn, retrying if it raises an OSError. The last error is raised if every attempt fails."""
+    for attempt in range(1, SYNC_RETRIES + 1):
+        try:
+            return fn()
+        except OSError as e:
+            if attempt == SYNC_RETRIES:
+                raise
+            L.warning(
+                "Error %s (attempt %d of %d): %s", description, attempt, SYNC_RETRIES, e
+            )
+            time.sleep(SYNC_RETRY_DELAY * attempt)
+
+
+def _commit_patch(repo, commit):
+    """Returns a full patch - as created by `kart create-patch` - of the changes made by the given commit."""
+    from kart.json_diff_writers import PatchWriter
+
+    buf = io.StringIO()
+    diff_writer = PatchWriter(
+        repo,
+        f"{commit.hex}^?...{commit.hex}",
+        [],
+        buf,
+        json_style="extracompact",
+    )
+    diff_writer.full_file_diffs(True)
+    diff_writer.include_target_commit_as_header()
+    diff_writer.write_diff()
+    return json.loads(buf.getvalue())
+
+
+def write_delta(path, delta):
+    """Writes a gzipped delta file - via a temporary file, so that a partially written delta is never applied."""
+    tmp_path = path.with_name(f".{path.name}.tmp")
+
+    def _write():
+        with gzip.open(tmp_path, "wt", encoding="utf-8") as f:
+            json.dump({"kart.delta/v1": delta}, f, separators=(",", ":"))
+        os.replace(tmp_path, path)
+
+    with_retries(_write, f"writing {path}")
+
+
+def read_delta(path):
+    def _read():
+        with gzip.open(path, "rt", encoding="utf-8") as f:
+            return json.load(f)
+
+    try:
+        delta = with_retries(_read, f"reading {path}").get("kart.delta/v1")
+    except (EOFError, gzip.BadGzipFile, json.JSONDecodeError) as e:
+        raise click.FileError(str(path), f"Failed to read delta: {e}")
+    if delta is None:
+        raise click.FileError(str(path), "File contains no `kart.delta/v1` object")
+    return delta
+
+
+def _unapplied_deltas(repo, deltas, device):
+    """
+    Given all the deltas pushed by a device, returns those that haven't been applied to this repository yet, in order.
+    Each delta starts from the commit the previous one ended at, so they form a chain.
+    """
+    by_since = {d["since"]: d for d in deltas}
+    applied = repo.get_config_str(_applied_config_key(device))
+    if applied is None:
+        heads = set(d["head"] for d in deltas)
+        starts = [d for d in deltas if d["since"] not in heads]
+        if len(starts) != 1:
+            raise InvalidOperation(
+                f"Can't tell which delta from device {device} comes first - there are {len(starts)} candidates"
+            )
+        applied = starts[0]["since"]
+
+    result = []
+    while applied in by_since:
+        delta = by_since.pop(applied)
+        result.append(delta)
+        applied = delta["head"]
+    return result
+
+
+@click.group(cls=KartGroup)
+@click.pass_context
+def sync(ctx, **kwargs):
+    """
+    Exchange changes with other devices as compressed feature-level deltas, for devices with slow or intermittent
+    connections. Deltas are exchanged via a directory - for instance a synced folder or a network share - and each
+    repository keeps track of which deltas it has already pushed and applied, so only new changes are transferred.
+    """
+
+
+@sync.command(cls=KartCommand, name="push", writes_to_repo=True)
+@click.pass_context
+@click.option(
+    "--delta",
+    "delta_dir",
+    required=True,
+    type=click.Path(file_okay=False, path_type=Path),
+    help="Directory to write the delta to.",
+)
+@click.option(
+    "--device",
+    help="The name of this device. Defaults to the kart.sync.device config, or the hostname.",
+)
+@click.option(
+    "--since",
+    shell_complete=ref_completer,
+    help=(
+        "Push the changes made since this commit. Only needed the first time a device pushes: after that, "
+        "the changes made since the last push are pushed."
+    ),
+)
+def sync_push(ctx, delta_dir, device, since):
+    """
+    Write the commits made since the last sync to a single compressed delta file, containing only the features
+    that changed. Merge commits are pushed as the changes they made to their first parent.
+    """
+    repo = ctx.obj.repo
+    device = get_device_name(repo, device)
+    sync_ref = f"{SYNC_REF_PREFIX}{device}/pushed"
+
+    if since is not None:
+        since_commit = repo.revparse_single(since).peel(pygit2.Commit)
+    elif sync_ref in repo.references:
+        since_commit = repo.references[sync_ref].peel(pygit2.Commit)
+    else:
+        raise click.UsageError(
+            f"Device {device} hasn't pushed a delta before - use --since to choose the first commit to push"
+        )
+
+    head_commit = repo.head_commit
+    walker = repo.walk(
+        head_commit.id, pygit2.GIT_SORT_TOPOLOGICAL | pygit2.GIT_SORT_REVERSE
+    )
+    walker.simplify_first_parent()
+    walker.hide(since_commit.id)
+    commits = list(walker)
+    if not commits:
+        click.echo(f"Nothing to push - no commits since {since_commit.short_id}")
+        return
+
+    delta = {
+        "device": device,
+        "since": since_commit.hex,
+        "head": head_commit.hex,
+        "patches": [_commit_patch(repo, c) for c in commits],
+    }
+    delta_dir.mkdir(parents=True, exist_ok=True)
+    delta_path = delta_dir / f"{device}-{head_commit.hex}{DELTA_SUFFIX}"
+    write_delta(delta_path, delta)
+    repo.references.create(sync_ref, head_commit.id, force=True)
+    click.echo(
+        f"Pushed {len(commits)} commit(s) to {delta_path} ({delta_path.stat().st_size} bytes)"
+    )
+
+
+@sync.command(cls=KartCommand, name="pull", writes_to_repo=True)
+@click.pass_context
+@click.option(
+    "--delta",
+    "delta_dir",
+    required=True,
+    type=click.Path(exists=True, file_okay=False, path_type=Path),
+    help="Directory to read deltas from.",
+)
+@click.option(
+    "--device",
+    help="The name of this device. Defaults to the kart.sync.device config, or the hostname.",
+)
+def sync_pull(ctx, delta_dir, device):
+    """
+    Apply the deltas pushed by other devices that haven't been applied yet. Each commit in a delta is recreated on
+    the current branch, as if by `kart apply`.
+    """
+    from kart.apply import apply_patch
+
+    repo = ctx.obj.repo
+    device = get_device_name(repo, device)
+    sync_ref = f"{SYNC_REF_PREFIX}{device}/pushed"
+    ctx.obj.check_not_dirty()
+    # Commits applied from other devices mustn't be pushed back to them - so they are treated as pushed already,
+    # which is only possible if every local commit has been pushed first.
+    pushed = repo.references.get(sync_ref)
+    if pushed is not None and pushed.target != repo.head.target:
+        raise InvalidOperation(
+            f"There are commits that haven't been pushed yet - use `kart sync push --delta {delta_dir}` first"
+        )
+
+    deltas_by_device = {}
+    for path in sorted(delta_dir.glob(f"*{DELTA_SUFFIX}")):
+        if path.name.startswith(f"{device}-"):
+            continue
+        delta = read_delta(path)
+        deltas_by_device.setdefault(delta["device"], []).append(delta)
+    if not deltas_by_device:
+        raise NotFound(
+            f"No deltas from other devices found in {delta_dir}", exit_code=NO_DATA
+        )
+
+    count = 0
+    for other_device, deltas in sorted(deltas_by_device.items()):
+        for delta in _unapplied_deltas(repo, deltas, other_device):
+            for patch in delta["patches"]:
+                apply_patch(
+                    repo=repo,
+                    do_commit=True,
+                    patch_file=io.StringIO(json.dumps(patch)),
+                    allow_empty=True,
+                )
+                count += 1
+            repo.config[_applied_config_key(other_device)] = delta["head"]
+
+    if sync_ref in repo.references:
+        repo.references.create(sync_ref, repo.head.target, force=True)
+    click.echo(f"Applied {count} commit(s) from other devices")
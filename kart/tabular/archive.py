@@ -0,0 +1,303 @@
+import sys
+from datetime import datetime, timezone
+
+import click
+
+from kart.cli_util import KartCommand, KartGroup, StringFromFile
+from kart.completion_shared import repo_path_completer
+from kart.core import all_blobs_in_tree
+from kart.exceptions import NotFound, NO_CHANGES, NO_TABLE
+from kart.output_util import dump_json_output
+from kart.schema import ColumnSchema, Schema
+from kart.serialise_util import json_pack, json_unpack
+from kart.spatial_filter import SpatialFilter
+from kart.timestamps import datetime_to_iso8601_utc, iso8601_utc_to_datetime
+
+# Datasets for which deleting a feature archives it, rather than removing it from the repository entirely.
+# Enable with eg `kart config --add kart.archive.dataset parcels`
+ARCHIVE_DATASET_CONFIG_KEY = "kart.archive.dataset"
+
+# Archived features are stored inside the dataset, at the same path they had under feature/ - so the blob itself is
+# shared with the history - along with a small blob recording when and by whom the feature was deleted:
+#   .table-dataset/archive/feature/encoded_path(pk_value) = [the feature blob, as it was before it was deleted]
+#   .table-dataset/archive/deletion/encoded_path(pk_value) = {"deletedAt": ..., "deletedBy": ...}
+# Nothing under archive/ is a feature or a meta item, so archived features don't show up in diffs or working copies.
+ARCHIVE_PATH = "archive/"
+ARCHIVE_FEATURE_PATH = ARCHIVE_PATH + "feature/"
+ARCHIVE_DELETION_PATH = ARCHIVE_PATH + "deletion/"
+
+# The columns added to exported datasets by `kart export --include-archived`. Both are NULL for live features.
+ARCHIVED_AT = "_archived_at"
+ARCHIVED_BY = "_archived_by"
+
+
+def get_archived_ds_paths(repo):
+    """Returns the set of dataset paths for which deleted features are archived - see ARCHIVE_DATASET_CONFIG_KEY."""
+    if ARCHIVE_DATASET_CONFIG_KEY not in repo.config:
+        return set()
+    return set(
+        p.strip() for p in repo.config.get_multivar(ARCHIVE_DATASET_CONFIG_KEY)
+    )
+
+
+def _deletion_path(dataset, pk):
+    rel_path = dataset.encode_1pk_to_path(pk, relative=True)
+    return ARCHIVE_DELETION_PATH + rel_path[len(dataset.FEATURE_PATH) :]
+
+
+def archive_deleted_features(
+    repo, repo_structure, repo_diff, object_builder, author
+):
+    """
+    Called while committing the given diff to the given RepoStructure: for every feature deleted from a dataset that
+    has archiving enabled, writes the feature as it was before it was deleted to the dataset's archive, along with
+    when and by whom it was deleted. If the feature has been archived before, the old archived version is replaced.
+    """
+    archived_ds_paths = get_archived_ds_paths(repo)
+    if not archived_ds_paths:
+        return
+    datasets = repo_structure.datasets()
+    deleted_at = datetime_to_iso8601_utc(
+        datetime.fromtimestamp(author.time, timezone.utc)
+    )
+    deletion = json_pack(
+        {"deletedAt": deleted_at, "deletedBy": f"{author.name} <{author.email}>"}
+    )
+    for ds_path, ds_diff in repo_diff.items():
+        if ds_path not in archived_ds_paths:
+            continue
+        dataset = datasets.get(ds_path)
+        if dataset is None or dataset.DATASET_TYPE != "table":
+            continue
+        feature_diff = ds_diff.get("feature")
+        if not feature_diff:
+            continue
+        with object_builder.chdir(dataset.inner_path):
+            for delta in feature_diff.values():
+                if delta.type != "delete":
+                    continue
+                rel_path = dataset.encode_1pk_to_path(delta.old_key, relative=True)
+                blob = dataset.get_blob_at(rel_path, missing_ok=True)
+                if blob is None:
+                    # Committing the diff will fail anyway - see apply_feature_diff.
+                    continue
+                object_builder.insert(ARCHIVE_PATH + rel_path, blob)
+                object_builder.insert(
+                    _deletion_path(dataset, delta.old_key), deletion
+                )
+
+
+def archived_features(dataset):
+    """
+    Yields (feature, deletion) for every archived feature of the given table dataset, where deletion is a dict
+    containing deletedAt and deletedBy.
+    """
+    for blob in all_blobs_in_tree(dataset.get_subtree(ARCHIVE_FEATURE_PATH)):
+        feature = dataset.get_feature(path=blob.name, data=memoryview(blob))
+        pk = dataset.decode_path_to_1pk(blob.name)
+        data = dataset.get_data_at(_deletion_path(dataset, pk), missing_ok=True)
+        yield feature, json_unpack(data) if data is not None else {}
+
+
+class ArchivedFeaturesTableDataset:
+    """
+    Wraps a table dataset so that its archived features are exported along with its live features, with the
+    _archived_at and _archived_by columns recording when and by whom each archived feature was deleted. An archived
+    feature that has since been inserted again is only exported once, as it is now. Everything else is delegated to
+    the wrapped dataset, so this can be passed to any of the table exporters in place of the dataset itself.
+    """
+
+    def __init__(self, delegate):
+        self.delegate = delegate
+        existing_names = {c.name for c in delegate.schema.columns}
+        new_columns = []
+        for name in (ARCHIVED_AT, ARCHIVED_BY):
+            if name in existing_names:
+                raise click.UsageError(
+                    f"Can't include archived features of {delegate.path} - it already has a column called {name}"
+                )
+            new_columns.append(
+                ColumnSchema(
+                    id=ColumnSchema.deterministic_id("archive", name),
+                    name=name,
+                    data_type="text",
+                )
+            )
+        self.schema = Schema(list(delegate.schema.columns) + new_columns)
+
+    @classmethod
+    def wrap_if_needed(cls, dataset):
+        if ARCHIVE_FEATURE_PATH not in dataset.inner_tree:
+            return dataset
+        return cls(dataset)
+
+    def __getattr__(self, name):
+        return getattr(self.delegate, name)
+
+    def features(self, spatial_filter=SpatialFilter.MATCH_ALL, show_progress=False):
+        for feature in self.delegate.features(
+            spatial_filter, show_progress=show_progress
+        ):
+            feature[ARCHIVED_AT] = None
+            feature[ARCHIVED_BY] = None
+            yield feature
+
+        spatial_filter = spatial_filter.transform_for_dataset(self.delegate)
+        pk_name = self.delegate.primary_key
+        for feature, deletion in archived_features(self.delegate):
+            pk = feature[pk_name]
+            live_path = self.delegate.encode_1pk_to_path(pk, relative=True)
+            if self.delegate.get_blob_at(live_path, missing_ok=True) is not None:
+                continue
+            if not spatial_filter.matches(feature):
+                continue
+            feature[ARCHIVED_AT] = deletion.get("deletedAt")
+            feature[ARCHIVED_BY] = deletion.get("deletedBy")
+            yield feature
+
+    def __str__(self):
+        return str(self.delegate)
+
+
+def _table_datasets(repo, ds_paths):
+    datasets = repo.datasets()
+    if not ds_paths:
+        return [ds for ds in datasets if ds.DATASET_TYPE == "table"]
+    result = []
+    for ds_path in ds_paths:
+        dataset = datasets.get(ds_path)
+        if dataset is None or dataset.DATASET_TYPE != "table":
+            raise NotFound(
+                f"No table dataset found at {ds_path}", exit_code=NO_TABLE
+            )
+        result.append(dataset)
+    return result
+
+
+@click.group(cls=KartGroup)
+@click.pass_context
+def archive(ctx, **kwargs):
+    """
+    Commands for archived features.
+
+    For datasets where features mustn't ever be truly deleted - eg because of legal retention requirements - Kart can
+    archive each feature as it is deleted, along with when and by whom it was deleted. Enable this for a dataset with
+    `kart config --add kart.archive.dataset DATASET`. Archived features don't show up in diffs or in the working copy,
+    but can be listed with `kart archive list`, exported with `kart export --include-archived`, and eventually
+    removed with `kart archive purge`.
+    """
+
+
+@archive.command("list", cls=KartCommand)
+@click.pass_context
+@click.option(
+    "--output-format",
+    "-o",
+    type=click.Choice(["text", "json"]),
+    default="text",
+)
+@click.argument("datasets", nargs=-1, shell_complete=repo_path_completer)
+def list_archived(ctx, output_format, datasets):
+    """List the archived features at HEAD, with when and by whom they were deleted."""
+    repo = ctx.obj.repo
+    rows = []
+    for dataset in _table_datasets(repo, datasets):
+        pk_name = dataset.primary_key
+        for feature, deletion in archived_features(dataset):
+            rows.append({"dataset": dataset.path, "pk": feature[pk_name], **deletion})
+
+    if output_format == "json":
+        dump_json_output({"kart.archive/v1": rows}, sys.stdout)
+        return
+    for row in rows:
+        click.echo(
+            f"{row['dataset']}:feature:{row['pk']}\tdeleted {row.get('deletedAt')} "
+            f"by {row.get('deletedBy')}"
+        )
+
+
+@archive.command("purge", cls=KartCommand, writes_to_repo=True)
+@click.pass_context
+@click.option(
+    "--message",
+    "-m",
+    multiple=True,
+    help=(
+        "Use the given message as the commit message. If multiple `-m` options are given, their values are "
+        "concatenated as separate paragraphs."
+    ),
+    type=StringFromFile(encoding="utf-8"),
+)
+@click.option(
+    "--all",
+    "purge_all",
+    is_flag=True,
+    help="Purge every archived feature of the dataset.",
+)
+@click.option(
+    "--before",
+    type=click.DateTime(formats=["%Y-%m-%d", "%Y-%m-%dT%H:%M:%S"]),
+    help="Purge the archived features that were deleted before this date (UTC), eg --before=2020-01-01",
+)
+@click.argument("ds_path", metavar="DATASET", shell_complete=repo_path_completer)
+@click.argument("pks", nargs=-1)
+def purge(ctx, message, purge_all, before, ds_path, pks):
+    """
+    Remove archived features from the archive of a dataset, and commit the result - once the period that they must
+    be retained for has passed. Specify the primary keys of the features to purge, or use --all or --before.
+
+    Purged features are only removed from the latest commit - they can still be found in the history of the dataset.
+    """
+    from kart.pack_util import packfile_object_builder
+
+    if sum([bool(pks), purge_all, before is not None]) != 1:
+        raise click.UsageError(
+            "Specify the primary keys of the archived features to purge, or one of --all or --before"
+        )
+    repo = ctx.obj.repo
+    [dataset] = _table_datasets(repo, [ds_path])
+    pk_name = dataset.primary_key
+
+    if pks:
+        archived_pks = {feature[pk_name] for feature, _ in archived_features(dataset)}
+        to_purge = [dataset.schema.sanitise_pks(pk)[0] for pk in pks]
+        missing = [str(pk) for pk in to_purge if pk not in archived_pks]
+        if missing:
+            raise NotFound(
+                f"No archived features found in {ds_path} with primary key {', '.join(missing)}"
+            )
+    else:
+        if before is not None:
+            before = before.replace(tzinfo=timezone.utc)
+        to_purge = [
+            feature[pk_name]
+            for feature, deletion in archived_features(dataset)
+            if before is None
+            or iso8601_utc_to_datetime(deletion["deletedAt"]) < before
+        ]
+    if not to_purge:
+        raise NotFound("No archived features to purge", exit_code=NO_CHANGES)
+
+    repo.working_copy.check_not_dirty()
+
+    commit_msg = "\n\n".join([m.strip() for m in message]).strip()
+    if not commit_msg:
+        commit_msg = f"Purge {len(to_purge)} archived features from {ds_path}"
+
+    with packfile_object_builder(repo, repo.head_tree) as object_builder:
+        with object_builder.chdir(dataset.inner_path):
+            for pk in to_purge:
+                rel_path = dataset.encode_1pk_to_path(pk, relative=True)
+                object_builder.remove(ARCHIVE_PATH + rel_path)
+                object_builder.remove(_deletion_path(dataset, pk))
+        new_commit = object_builder.commit(
+            "HEAD",
+            repo.author_signature(),
+            repo.committer_signature(),
+            commit_msg,
+            [repo.head_commit.id],
+        )
+    repo.working_copy.reset_to_head()
+    click.echo(
+        f"Purged {len(to_purge)} archived features from {ds_path}: {new_commit.short_id}"
+    )
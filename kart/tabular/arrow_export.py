@@ -0,0 +1,69 @@
+import logging
+import sys
+
+from kart.arrow_util import ArrowFeatureWriter, import_pyarrow
+from kart.exceptions import InvalidOperation
+
+L = logging.getLogger("kart.tabular.arrow_export")
+
+
+class ArrowTableExporter:
+    """
+    Writes a table dataset to an Apache Arrow IPC file or stream, or an Apache Parquet file, with geometries encoded
+    as WKB. These files have a single schema, so only one dataset can be written to each file.
+
+    Usage:
+    with ArrowTableExporter(path) as exporter:
+        exporter.write_dataset(dataset)
+    """
+
+    # Paths with these suffixes are written using the streaming format - anything else uses the file format.
+    STREAM_SUFFIXES = (".arrows",)
+    PARQUET_SUFFIXES = (".parquet", ".geoparquet")
+
+    def __init__(self, path, driver_name=None, **kwargs):
+        # driver_name and any other OGR options are ignored - Arrow files are written using pyarrow.
+        self.path = path
+        self.to_stdout = str(path) == "-"
+        suffix = "" if self.to_stdout else path.suffix.lower()
+        if suffix in self.PARQUET_SUFFIXES:
+            self.file_format = "parquet"
+        elif self.to_stdout or suffix in self.STREAM_SUFFIXES:
+            self.file_format = "stream"
+        else:
+            self.file_format = "file"
+        self.written = False
+
+    def __enter__(self):
+        import_pyarrow()
+        return self
+
+    def __exit__(self, *args):
+        pass
+
+    def write_dataset(self, dataset, layer_name=None, features=None):
+        """
+        Writes the given dataset - all of its features, or only the given features if features is not None.
+        Returns the number of features written.
+        """
+        if self.written:
+            raise InvalidOperation(
+                "Only one dataset can be exported to each file in this format - export each dataset separately"
+            )
+        self.written = True
+        if features is None:
+            features = dataset.features()
+
+        sink = sys.stdout.buffer if self.to_stdout else str(self.path)
+        with ArrowFeatureWriter(
+            sink,
+            dataset.schema,
+            get_crs_definition=dataset.get_crs_definition,
+            file_format=self.file_format,
+        ) as writer:
+            for feature in features:
+                writer.write(feature)
+        if self.to_stdout:
+            sys.stdout.buffer.flush()
+        L.info("Wrote %s features to %s", writer.count, self.path)
+        return writer.count
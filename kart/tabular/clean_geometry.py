@@ -0,0 +1,220 @@
+import click
+from osgeo import ogr
+
+from kart.cli_util import KartCommand, StringFromFile
+from kart.completion_shared import repo_path_completer
+from kart.core import check_git_user
+from kart.diff_structs import Delta, DeltaDiff
+from kart.exceptions import NO_CHANGES, InvalidOperation, NotFound
+from kart.geometry import ogr_to_gpkg_geom
+from kart.tabular.transform import (
+    commit_feature_diff,
+    get_table_dataset,
+    preview_feature_diff,
+)
+
+
+def _signed_area(points):
+    """The signed area of a ring - positive if it is counter-clockwise, negative if it is clockwise."""
+    pairs = zip(points, points[1:] + points[:1])
+    return sum(a[0] * b[1] - b[0] * a[1] for a, b in pairs) / 2
+
+
+class GeometryCleaner:
+    """
+    Cleans OGR geometries: snaps the X and Y of each vertex to a grid of the given size, removes consecutive duplicate
+    vertices, and makes the exterior rings of polygons counter-clockwise and their interior rings clockwise.
+    """
+
+    def __init__(
+        self, snap_grid=None, remove_duplicate_vertices=False, force_ccw=False
+    ):
+        self.snap_grid = snap_grid
+        self.remove_duplicate_vertices = remove_duplicate_vertices
+        self.force_ccw = force_ccw
+
+    def _snap(self, value):
+        return round(value / self.snap_grid) * self.snap_grid
+
+    def _clean_points(self, points):
+        if self.snap_grid:
+            points = [(self._snap(x), self._snap(y), z, m) for x, y, z, m in points]
+        if self.remove_duplicate_vertices:
+            deduped = []
+            for point in points:
+                if not deduped or point != deduped[-1]:
+                    deduped.append(point)
+            points = deduped
+        return points
+
+    def clean(self, geom, ring=None):
+        """
+        Returns a cleaned copy of the given OGR geometry. ring is "exterior" or "interior" if the geometry is one of the
+        rings of a polygon.
+        """
+        if geom.IsEmpty():
+            return geom.Clone()
+
+        geom_type = geom.GetGeometryType()
+        if geom.GetGeometryCount():
+            is_polygon = ogr.GT_Flatten(geom_type) == ogr.wkbPolygon
+            result = ogr.Geometry(geom_type)
+            for i in range(geom.GetGeometryCount()):
+                part_ring = None
+                if is_polygon:
+                    part_ring = "exterior" if i == 0 else "interior"
+                result.AddGeometry(self.clean(geom.GetGeometryRef(i), part_ring))
+            return result
+
+        points = self._clean_points(
+            [geom.GetPointZM(i) for i in range(geom.GetPointCount())]
+        )
+        if ring and self.force_ccw:
+            area = _signed_area(points)
+            if area and (area > 0) != (ring == "exterior"):
+                points.reverse()
+
+        result = ogr.Geometry(ogr.wkbLinearRing if ring else geom_type)
+        has_z, has_m = geom.Is3D(), geom.IsMeasured()
+        for x, y, z, m in points:
+            if has_z and has_m:
+                result.AddPointZM(x, y, z, m)
+            elif has_m:
+                result.AddPointM(x, y, m)
+            elif has_z:
+                result.AddPoint(x, y, z)
+            else:
+                result.AddPoint_2D(x, y)
+        return result
+
+
+def clean_features(dataset, cleaner):
+    """
+    Returns a tuple (feature_diff, modified_pks) - a DeltaDiff which updates every feature in the dataset whose
+    geometry is changed by cleaning it, and the primary key values of those features.
+    """
+    geom_columns = dataset.schema.geometry_columns
+    if not geom_columns:
+        raise InvalidOperation(f"Can't clean {dataset.path} - it has no geometry")
+
+    pk_name = dataset.schema.pk_columns[0].name
+    feature_diff = DeltaDiff()
+    modified_pks = []
+    for feature in dataset.features():
+        pk = feature[pk_name]
+        new_feature = dict(feature)
+        for column in geom_columns:
+            geometry = feature[column.name]
+            if geometry is None:
+                continue
+            ogr_geom = geometry.to_ogr()
+            if ogr_geom.HasCurveGeometry():
+                raise InvalidOperation(
+                    f"Can't clean feature {pk} - curved geometries aren't supported"
+                )
+            cleaned = cleaner.clean(ogr_geom)
+            if cleaned.ExportToIsoWkb() != ogr_geom.ExportToIsoWkb():
+                new_feature[column.name] = ogr_to_gpkg_geom(cleaned)
+        if new_feature != feature:
+            feature_diff.add_delta(Delta.update((pk, feature), (pk, new_feature)))
+            modified_pks.append(pk)
+    return feature_diff, modified_pks
+
+
+@click.command("clean-geometry", cls=KartCommand, writes_to_repo=True)
+@click.pass_context
+@click.option(
+    "--snap-grid",
+    type=click.FloatRange(min=0, min_open=True),
+    help="Snap the X and Y of every vertex to a grid of this size, in the units of the dataset's CRS.",
+)
+@click.option(
+    "--remove-duplicate-vertices",
+    is_flag=True,
+    help="Remove any vertex that is the same as the vertex before it.",
+)
+@click.option(
+    "--force-ccw",
+    is_flag=True,
+    help="Make the exterior rings of polygons counter-clockwise, and their interior rings clockwise.",
+)
+@click.option(
+    "--dry-run",
+    is_flag=True,
+    help="Show the diff that would be committed, without committing it.",
+)
+@click.option(
+    "--message",
+    "-m",
+    multiple=True,
+    help=(
+        "Use the given message as the commit message. If multiple `-m` options are given, their values are "
+        "concatenated as separate paragraphs."
+    ),
+    type=StringFromFile(encoding="utf-8"),
+)
+@click.option(
+    "--output-format",
+    "-o",
+    type=click.Choice(["text", "json"]),
+    default="text",
+)
+@click.argument("ds_path", metavar="DATASET", shell_complete=repo_path_completer)
+def clean_geometry(
+    ctx,
+    snap_grid,
+    remove_duplicate_vertices,
+    force_ccw,
+    dry_run,
+    message,
+    output_format,
+    ds_path,
+):
+    """
+    Clean up the geometries of a dataset's features, and commit the result as a single commit - eg to prepare messy
+    source data for publication. The primary key values of the features that were changed are listed.
+
+    eg: kart clean-geometry parcels --snap-grid 0.001 --remove-duplicate-vertices --force-ccw -m "Clean up parcels"
+
+    Snapping happens first, so any vertices that are snapped onto the same point are removed as duplicates.
+    Use --dry-run to review the changes as a diff before committing them.
+    """
+    if not (snap_grid or remove_duplicate_vertices or force_ccw):
+        raise click.UsageError(
+            "Specify at least one of --snap-grid, --remove-duplicate-vertices or --force-ccw"
+        )
+
+    repo = ctx.obj.repo
+    dataset = get_table_dataset(repo, ds_path, "clean")
+    cleaner = GeometryCleaner(snap_grid, remove_duplicate_vertices, force_ccw)
+    feature_diff, modified_pks = clean_features(dataset, cleaner)
+
+    if dry_run:
+        if not feature_diff:
+            raise NotFound("No geometries to clean", exit_code=NO_CHANGES)
+        preview_feature_diff(repo, ds_path, feature_diff, output_format)
+        return
+
+    check_git_user(repo)
+    repo.working_copy.check_not_dirty()
+    operations = [
+        f"snap to {snap_grid} grid" if snap_grid else None,
+        "remove duplicate vertices" if remove_duplicate_vertices else None,
+        "force counter-clockwise polygons" if force_ccw else None,
+    ]
+    draft_message = f"Clean geometry of {ds_path}: " + ", ".join(
+        op for op in operations if op
+    )
+    commit_feature_diff(
+        repo,
+        ds_path,
+        feature_diff,
+        message,
+        output_format,
+        draft_message,
+        extra_json={"kart.clean_geometry/v1": {"modified": modified_pks}},
+    )
+    if output_format == "text":
+        click.echo(f"\nCleaned the geometry of {len(modified_pks)} features:")
+        for pk in modified_pks:
+            click.echo(f"  {pk}")
@@ -0,0 +1,324 @@
+import functools
+import logging
+from datetime import datetime, timezone
+from urllib.parse import urlsplit, urlunsplit
+
+import click
+import pygit2
+
+from kart.exceptions import InvalidOperation
+from kart.schema import ColumnSchema, Schema
+from kart.tabular.expressions import Expression, ExpressionError, coerce_value
+from kart.tabular.geodesic import GeodesicMeasurer
+from kart.timestamps import datetime_to_iso8601_utc
+
+L = logging.getLogger("kart.tabular.derived_columns")
+
+# Derived columns are declared in the repository config, one per value of this multi-valued key, as
+# [DATASET:]NAME=FUNCTION - eg `kart config --add kart.derivedColumn "parcels:area_m2=area"` - or as
+# [DATASET:]NAME=EXPRESSION - eg `kart config --add kart.derivedColumn "parcels:area_ha=area(geom) / 10000"`.
+# Columns declared without a dataset are added to every dataset they can be computed for.
+DERIVED_COLUMN_CONFIG_KEY = "kart.derivedColumn"
+
+ALL_DATASETS = "*"
+
+# The columns added to every exported dataset by `kart export --stamp-provenance`, so that downstream copies of the
+# data can be traced back to the exact commit they came from.
+PROVENANCE_COLUMNS = {
+    "_commit": "commit",
+    "_exported_at": "exported_at",
+    "_source": "source",
+}
+
+
+def _ogr_geometry(dataset, feature):
+    geom = feature.get(dataset.geom_column_name) if dataset.has_geometry else None
+    if geom is None or geom.is_empty():
+        return None
+    return geom.to_ogr()
+
+
+def _area(dataset, feature, context):
+    ogr_geom = _ogr_geometry(dataset, feature)
+    return ogr_geom.GetArea() if ogr_geom is not None else None
+
+
+def _length(dataset, feature, context):
+    ogr_geom = _ogr_geometry(dataset, feature)
+    return ogr_geom.Length() if ogr_geom is not None else None
+
+
+@functools.lru_cache()
+def _geodesic_measurer(crs_definition):
+    return GeodesicMeasurer(crs_definition)
+
+
+def _geodesic(measurement):
+    # Geodesic measurements are in square metres or metres, measured on the ellipsoid of the dataset's CRS.
+    def _geodesic_measurement(dataset, feature, context):
+        crs_defs = list(dataset.crs_definitions().values())
+        geom = feature.get(dataset.geom_column_name) if dataset.has_geometry else None
+        if len(crs_defs) != 1 or geom is None:
+            return None
+        measurer = _geodesic_measurer(crs_defs[0])
+        return getattr(measurer, measurement)(geom)
+
+    return _geodesic_measurement
+
+
+def _centroid(index):
+    def _centroid_ordinate(dataset, feature, context):
+        ogr_geom = _ogr_geometry(dataset, feature)
+        if ogr_geom is None:
+            return None
+        return ogr_geom.Centroid().GetPoint_2D()[index]
+
+    return _centroid_ordinate
+
+
+def _updated_by(dataset, feature, context):
+    pks = [feature[c.name] for c in dataset.schema.pk_columns]
+    return context.last_authors().get(dataset.encode_pks_to_path(pks, relative=True))
+
+
+def _commit(dataset, feature, context):
+    return context.commit.id.hex
+
+
+def _exported_at(dataset, feature, context):
+    return context.exported_at
+
+
+def _source(dataset, feature, context):
+    return f"{context.repo_location()}#{dataset.path}"
+
+
+# Each function computes a value from a feature, and needs a geometry column - or not.
+DERIVED_COLUMN_FUNCTIONS = {
+    "area": ("float", True, _area),
+    "length": ("float", True, _length),
+    "geodesic_area": ("float", True, _geodesic("area")),
+    "geodesic_length": ("float", True, _geodesic("length")),
+    "centroid_x": ("float", True, _centroid(0)),
+    "centroid_y": ("float", True, _centroid(1)),
+    "updated_by": ("text", False, _updated_by),
+    "commit": ("text", False, _commit),
+    "exported_at": ("text", False, _exported_at),
+    "source": ("text", False, _source),
+}
+
+
+def get_derived_columns(repo):
+    """
+    Returns the derived columns declared in the repository config, as a {dataset-path-or-*: {name: function}} dict.
+    """
+    result = {}
+    if DERIVED_COLUMN_CONFIG_KEY not in repo.config:
+        return result
+    for spec in repo.config.get_multivar(DERIVED_COLUMN_CONFIG_KEY):
+        column, _, function = spec.partition("=")
+        ds_path, _, name = column.strip().rpartition(":")
+        name, function = name.strip(), function.strip()
+        if not name:
+            raise InvalidOperation(
+                f"Invalid {DERIVED_COLUMN_CONFIG_KEY} in config: {spec!r} - expected [DATASET:]NAME=FUNCTION, "
+                f"where FUNCTION is one of {', '.join(DERIVED_COLUMN_FUNCTIONS)} or an expression"
+            )
+        if function not in DERIVED_COLUMN_FUNCTIONS:
+            try:
+                Expression(function)
+            except ExpressionError as e:
+                raise InvalidOperation(
+                    f"Invalid {DERIVED_COLUMN_CONFIG_KEY} in config: {spec!r} - {e}"
+                )
+        result.setdefault(ds_path or ALL_DATASETS, {})[name] = function
+    return result
+
+
+class _DerivationContext:
+    """State shared between the derived columns of one dataset, which is computed lazily."""
+
+    def __init__(self, dataset, commit, exported_at=None):
+        self.dataset = dataset
+        self.commit = commit
+        self.exported_at = exported_at or datetime_to_iso8601_utc(
+            datetime.now(timezone.utc)
+        )
+        self._last_authors = None
+
+    def repo_location(self):
+        """
+        Returns the URL of the remote that the repository tracks - without any credentials - or if it has no remote,
+        the path of the repository.
+        """
+        repo = self.dataset.repo
+        remote_name = repo.head_remote_name_or_default
+        if not remote_name:
+            return str(repo.workdir_path)
+        url = urlsplit(repo.remotes[remote_name].url)
+        if url.password or url.username:
+            url = url._replace(netloc=url.netloc.rpartition("@")[2])
+        return urlunsplit(url)
+
+    def last_authors(self):
+        """
+        Returns a {feature-path: author-name} dict of who last changed each feature, by walking back through the
+        history until every feature is accounted for.
+        """
+        if self._last_authors is not None:
+            return self._last_authors
+
+        repo = self.dataset.repo
+        ds_path = self.dataset.path
+        target_tree = self.dataset.feature_tree
+        remaining = self.dataset.feature_count
+        result = {}
+        walker = repo.walk(self.commit.id, pygit2.GIT_SORT_TOPOLOGICAL)
+        # Features that were changed on a merged branch are attributed to whoever merged them.
+        walker.simplify_first_parent()
+        for commit in walker:
+            if remaining <= 0:
+                break
+            dataset = repo.datasets(commit.id.hex).get(ds_path)
+            if dataset is None:
+                break
+            parent_dataset = None
+            if commit.parents:
+                parent_dataset = repo.datasets(commit.parent_ids[0].hex).get(ds_path)
+            if parent_dataset is None:
+                # Every feature in the dataset was added by this commit.
+                changed_paths = _all_blob_paths(
+                    dataset.feature_tree, dataset.FEATURE_PATH
+                )
+            else:
+                diff = parent_dataset.feature_tree.diff_to_tree(dataset.feature_tree)
+                changed_paths = (
+                    f"{dataset.FEATURE_PATH}{d.new_file.path}"
+                    for d in diff.deltas
+                    if d.status != pygit2.GIT_DELTA_DELETED
+                )
+            for path in changed_paths:
+                if path in result:
+                    continue
+                # Features that have since been deleted don't count towards the features still to be found.
+                if path[len(dataset.FEATURE_PATH) :] not in target_tree:
+                    continue
+                result[path] = commit.author.name
+                remaining -= 1
+
+        self._last_authors = result
+        return result
+
+
+def _all_blob_paths(tree, prefix):
+    for entry in tree:
+        if entry.type_str == "tree":
+            yield from _all_blob_paths(entry, f"{prefix}{entry.name}/")
+        else:
+            yield f"{prefix}{entry.name}"
+
+
+def _expression_type(dataset, name, expression):
+    """Returns the data type of a column derived from the given expression."""
+    column_types = {c.name: c.data_type for c in dataset.schema.columns}
+    try:
+        expression.check_fields(column_types)
+    except ExpressionError as e:
+        raise InvalidOperation(f"Can't derive {name} for {dataset.path} - {e}")
+    data_type = expression.infer_type(column_types) or "text"
+    if data_type == "geometry":
+        raise InvalidOperation(
+            f"Can't derive {name} for {dataset.path} - derived columns can't be geometries"
+        )
+    return data_type
+
+
+def _expression_function(expression, column):
+    def _evaluate(dataset, feature, context):
+        pks = [feature[c.name] for c in dataset.schema.pk_columns]
+        try:
+            value = expression.evaluate(feature)
+        except ExpressionError as e:
+            raise InvalidOperation(
+                f"Can't derive {column.name} for feature {pks} of {dataset.path}: {e}"
+            )
+        return coerce_value(value, column, pks)
+
+    return _evaluate
+
+
+def _can_derive(dataset, function):
+    """Returns True if the given function or expression can be computed for the given dataset."""
+    if function in DERIVED_COLUMN_FUNCTIONS:
+        return dataset.has_geometry or not DERIVED_COLUMN_FUNCTIONS[function][1]
+    field_names = {c.name for c in dataset.schema.columns}
+    return Expression(function).field_names <= field_names
+
+
+class DerivedColumnsTableDataset:
+    """
+    Wraps a table dataset so that extra columns, computed from each feature at export time, are appended to its
+    schema and features - eg the area of each polygon, or who last changed each feature. These columns are never
+    stored in the repository, so they don't show up in diffs. Everything else is delegated to the wrapped dataset,
+    so this can be passed to any of the table exporters in place of the dataset itself.
+    """
+
+    def __init__(self, delegate, columns, commit, exported_at=None):
+        self.delegate = delegate
+        self.context = _DerivationContext(delegate, commit, exported_at)
+        existing_names = {c.name for c in delegate.schema.columns}
+        self.functions = {}
+        new_columns = []
+        for name, function in columns.items():
+            if name in existing_names:
+                raise click.UsageError(
+                    f"Can't add derived column {name} to {delegate.path} - it already has a column called {name}"
+                )
+            if function in DERIVED_COLUMN_FUNCTIONS:
+                data_type, _, self.functions[name] = DERIVED_COLUMN_FUNCTIONS[function]
+                expression = None
+            else:
+                expression = Expression(function)
+                data_type = _expression_type(delegate, name, expression)
+            col_kwargs = {"size": 64} if data_type in ("float", "integer") else {}
+            column = ColumnSchema(
+                id=ColumnSchema.deterministic_id("derived", name),
+                name=name,
+                data_type=data_type,
+                **col_kwargs,
+            )
+            new_columns.append(column)
+            if expression is not None:
+                self.functions[name] = _expression_function(expression, column)
+        self.schema = Schema(list(delegate.schema.columns) + new_columns)
+
+    @classmethod
+    def wrap_if_needed(cls, dataset, derived_columns, commit, exported_at=None):
+        # Columns for every dataset are only added where they can be computed.
+        columns = {
+            k: v
+            for k, v in derived_columns.get(ALL_DATASETS, {}).items()
+            if _can_derive(dataset, v)
+        }
+        ds_columns = derived_columns.get(dataset.path, {})
+        for name, function in ds_columns.items():
+            if function not in DERIVED_COLUMN_FUNCTIONS:
+                continue
+            if DERIVED_COLUMN_FUNCTIONS[function][1] and not dataset.has_geometry:
+                raise InvalidOperation(
+                    f"Can't derive {name} for {dataset.path} - {function} needs a geometry column"
+                )
+        columns.update(ds_columns)
+        return cls(dataset, columns, commit, exported_at) if columns else dataset
+
+    def __getattr__(self, name):
+        return getattr(self.delegate, name)
+
+    def features(self, *args, **kwargs):
+        for feature in self.delegate.features(*args, **kwargs):
+            for name, function in self.functions.items():
+                feature[name] = function(self.delegate, feature, self.context)
+            yield feature
+
+    def __str__(self):
+        return str(self.delegate)
@@ -0,0 +1,142 @@
+import click
+
+from kart.cli_util import KartCommand
+from kart.completion_shared import repo_path_completer
+from kart.diff_structs import DatasetDiff, Delta, DeltaDiff, RepoDiff
+from kart.exceptions import InvalidOperation, NotFound, NO_DATA
+from kart.structs import CommitWithReference
+from kart.tabular.import_source import TableImportSource
+from kart.tabular.pk_generation import PkGeneratingTableImportSource
+
+
+def external_feature_diff(dataset, source):
+    """
+    Returns a DeltaDiff which changes the features of the dataset into the features of the given TableImportSource,
+    matching them up by primary key. The source's schema should already be aligned to the dataset's schema - see
+    TableImportSource.align_schema_to_existing_schema
+    """
+    pk_columns = dataset.schema.pk_columns
+    if len(pk_columns) != 1:
+        raise InvalidOperation(
+            f"Can't compare {dataset.path} - only datasets with a single primary key column are supported"
+        )
+    pk_name = pk_columns[0].name
+    if [c.name for c in source.schema.pk_columns] != [pk_name]:
+        raise InvalidOperation(
+            f"Can't compare {dataset.path} to {source} - its primary key isn't {pk_name}"
+        )
+
+    with source:
+        new_features = {f[pk_name]: f for f in source.features()}
+
+    old_schema = dataset.schema
+    new_schema = source.schema
+    feature_diff = DeltaDiff()
+    for old_feature in dataset.features():
+        pk = old_feature[pk_name]
+        new_feature = new_features.pop(pk, None)
+        if new_feature is None:
+            feature_diff.add_delta(Delta.delete((pk, old_feature)))
+            continue
+        # Comparing the encoded features means that equivalent geometries and values compare as equal.
+        if old_schema.hash_feature(old_feature) != new_schema.hash_feature(new_feature):
+            feature_diff.add_delta(Delta.update((pk, old_feature), (pk, new_feature)))
+    for pk, new_feature in new_features.items():
+        feature_diff.add_delta(Delta.insert((pk, new_feature)))
+    return feature_diff
+
+
+def external_dataset_diff(dataset, source):
+    """
+    Returns a DatasetDiff which changes the dataset into the given TableImportSource - its schema, and its features.
+    Other meta-items aren't compared, since third-party files rarely have the same title or description.
+    """
+    ds_diff = DatasetDiff()
+    old_schema = dataset.schema
+    new_schema = source.schema
+    if old_schema != new_schema:
+        ds_diff["meta"] = DeltaDiff(
+            [Delta.update(("schema.json", old_schema), ("schema.json", new_schema))]
+        )
+    feature_diff = external_feature_diff(dataset, source)
+    if feature_diff:
+        ds_diff["feature"] = feature_diff
+    return ds_diff
+
+
+@click.command("diff-external", cls=KartCommand)
+@click.pass_context
+@click.option(
+    "--output-format",
+    "-o",
+    type=click.Choice(["text", "json", "json-lines", "geojson", "quiet"]),
+    default="text",
+    help="Output format. 'quiet' disables all output and implies --exit-code.",
+)
+@click.option(
+    "--exit-code",
+    is_flag=True,
+    help="Exit with 1 if the file has drifted from the dataset, and 0 if it hasn't - like diff(1).",
+)
+@click.option(
+    "--output",
+    "output_path",
+    type=click.Path(writable=True, allow_dash=True),
+    help="Output to a specific file instead of stdout.",
+)
+@click.argument(
+    "dataset_spec", metavar="DATASET[@COMMIT]", shell_complete=repo_path_completer
+)
+@click.argument("other_path", metavar="OTHER")
+@click.argument("table", required=False)
+def diff_external(
+    ctx, output_format, exit_code, output_path, dataset_spec, other_path, table
+):
+    """
+    Show how a table in an external file - eg a GeoPackage delivered by a third party - differs from a dataset.
+
+    The dataset is compared as it was at COMMIT, or at HEAD if no commit is given. Features are matched up by primary
+    key - or for data without one, by the primary keys that Kart generated when it was last imported - so the output
+    shows what would change if the file was imported over the top of the dataset. Nothing is imported or committed.
+
+    eg: kart diff-external parcels@v1.2 delivery.gpkg parcels --exit-code
+    """
+    repo = ctx.obj.repo
+    ds_path, _, commit_spec = dataset_spec.partition("@")
+    commit = CommitWithReference.resolve(repo, commit_spec or "HEAD").commit
+    base_rs = repo.structure(commit.hex)
+    dataset = base_rs.datasets().get(ds_path)
+    if dataset is None:
+        raise NotFound(
+            f"No dataset found at {ds_path} in {commit.short_id}", exit_code=NO_DATA
+        )
+    if dataset.DATASET_TYPE != "table":
+        raise InvalidOperation(f"Can't compare {ds_path} - it isn't a table dataset")
+
+    source = TableImportSource.open(other_path, table=table)
+    source.dest_path = ds_path
+    source = PkGeneratingTableImportSource.wrap_source_if_needed(source, repo)
+    source.align_schema_to_existing_schema(dataset.schema)
+
+    repo_diff = RepoDiff()
+    ds_diff = external_dataset_diff(dataset, source)
+    if ds_diff:
+        repo_diff[ds_path] = ds_diff
+    # The changes are written to a tree which isn't referenced by anything, so it is cleaned up when the repository is
+    # next garbage-collected - then it can be shown with the usual diff writers.
+    tree = base_rs.create_tree_from_diff(repo_diff)
+
+    from kart.base_diff_writer import BaseDiffWriter
+
+    diff_writer_class = BaseDiffWriter.get_diff_writer_class(output_format)
+    diff_writer = diff_writer_class(
+        repo, f"{commit.hex}...{tree.id.hex}", [ds_path], output_path or "-"
+    )
+    diff_writer.write_diff()
+    if not ds_diff and output_format == "text":
+        click.echo(
+            f"No drift: {source} matches {ds_path} at {commit.short_id}", err=True
+        )
+
+    if exit_code or output_format == "quiet":
+        diff_writer.exit_with_code()
@@ -0,0 +1,221 @@
+import logging
+import re
+import tempfile
+
+from osgeo import ogr
+
+L = logging.getLogger("kart.tabular.dxf_export")
+
+# Layer and block names in AutoCAD R12 can only contain these characters.
+DXF_NAME_INVALID_CHARS = re.compile(r"[^A-Za-z0-9_$-]")
+
+
+def dxf_name(name):
+    return DXF_NAME_INVALID_CHARS.sub("_", name).upper()
+
+
+def dxf_text(value):
+    """DXF R12 files aren't unicode - characters outside of ASCII are written as \\U+XXXX escapes."""
+    text = str(value).replace("\r", " ").replace("\n", " ")
+    return "".join(c if ord(c) < 128 else f"\\U+{ord(c):04X}" for c in text)
+
+
+class DxfTableExporter:
+    """
+    Writes table datasets to an AutoCAD R12 DXF file, which almost every CAD package can read.
+
+    Each dataset becomes a DXF layer. Points are written as POINT entities, and lines and polygon rings as POLYLINE
+    entities (curves are linearized first). If attributes are selected, each feature also gets an INSERT of a block
+    with one ATTRIB per selected attribute, positioned at the point (or a point on the line or polygon), so the values
+    can be seen and queried in CAD. DXF has no concept of a CRS, so coordinates are written as they are stored.
+
+    Usage:
+    with DxfTableExporter(path, attributes=["name"]) as exporter:
+        exporter.write_dataset(dataset)
+    """
+
+    def __init__(self, path, driver_name=None, *, attributes=(), **kwargs):
+        # driver_name and any other OGR options are ignored - DXF files are written directly, since OGR's DXF driver
+        # can't write more than one layer's worth of attributes, or block attributes.
+        self.path = path
+        self.attributes = list(attributes or [])
+        self.layers = []
+        self.blocks = {}
+        self.entities = None
+
+    def __enter__(self):
+        self.entities = tempfile.TemporaryFile("w+", encoding="ascii")
+        return self
+
+    def __exit__(self, exc_type, *args):
+        try:
+            if exc_type is None:
+                self._write_file()
+        finally:
+            self.entities.close()
+            self.entities = None
+
+    def _group(self, out, code, value):
+        out.write(f"{code}\n{value}\n")
+
+    def write_dataset(self, dataset, layer_name=None, features=None):
+        """
+        Writes the given dataset as a new DXF layer. By default, the layer is named after the dataset, and all of its
+        features are written - or only the given features if features is not None. Returns the number of features
+        written.
+        """
+        if layer_name is None:
+            layer_name = dataset.dataset_path_to_table_name(dataset.path)
+        layer_name = dxf_name(layer_name)
+        self.layers.append(layer_name)
+
+        schema = dataset.schema
+        geom_columns = schema.geometry_columns
+        column_names = [c.name for c in schema.columns]
+        attributes = [a for a in self.attributes if a in column_names]
+        block_name = None
+        if attributes:
+            block_name = dxf_name(f"KART_{layer_name}")
+            self.blocks[block_name] = (layer_name, attributes)
+
+        if features is None:
+            features = dataset.features()
+
+        count = 0
+        for feature in features:
+            geometry = feature[geom_columns[0].name] if geom_columns else None
+            ogr_geom = geometry.to_ogr() if geometry is not None else None
+            if ogr_geom is not None and not ogr_geom.IsEmpty():
+                if ogr_geom.HasCurveGeometry():
+                    ogr_geom = ogr_geom.GetLinearGeometry()
+                self._write_geometry(layer_name, ogr_geom)
+                if block_name:
+                    self._write_insert(
+                        layer_name, block_name, attributes, feature, ogr_geom
+                    )
+            count += 1
+        L.info("Wrote %s features to %s", count, layer_name)
+        return count
+
+    def _write_geometry(self, layer_name, ogr_geom):
+        geom_type = ogr.GT_Flatten(ogr_geom.GetGeometryType())
+        out = self.entities
+        if geom_type == ogr.wkbPoint:
+            self._group(out, 0, "POINT")
+            self._group(out, 8, layer_name)
+            self._write_coords(out, ogr_geom.GetPoint(0), ogr_geom.Is3D())
+        elif geom_type == ogr.wkbLineString:
+            self._write_polyline(layer_name, ogr_geom, closed=False)
+        elif geom_type == ogr.wkbPolygon:
+            for i in range(ogr_geom.GetGeometryCount()):
+                self._write_polyline(
+                    layer_name, ogr_geom.GetGeometryRef(i), closed=True
+                )
+        else:
+            # Multi-geometries and geometry collections.
+            for i in range(ogr_geom.GetGeometryCount()):
+                self._write_geometry(layer_name, ogr_geom.GetGeometryRef(i))
+
+    def _write_polyline(self, layer_name, ring, closed):
+        out = self.entities
+        is_3d = ring.Is3D()
+        points = ring.GetPoints() or []
+        if closed and len(points) > 1 and points[0] == points[-1]:
+            points = points[:-1]
+        flags = (1 if closed else 0) | (8 if is_3d else 0)
+        self._group(out, 0, "POLYLINE")
+        self._group(out, 8, layer_name)
+        self._group(out, 66, 1)
+        self._write_coords(out, (0, 0, 0), is_3d)
+        self._group(out, 70, flags)
+        for point in points:
+            self._group(out, 0, "VERTEX")
+            self._group(out, 8, layer_name)
+            self._write_coords(out, point, is_3d)
+            if is_3d:
+                self._group(out, 70, 32)
+        self._group(out, 0, "SEQEND")
+        self._group(out, 8, layer_name)
+
+    def _write_coords(self, out, point, is_3d):
+        self._group(out, 10, repr(float(point[0])))
+        self._group(out, 20, repr(float(point[1])))
+        z = point[2] if is_3d and len(point) > 2 else 0.0
+        self._group(out, 30, repr(float(z)))
+
+    def _write_insert(self, layer_name, block_name, attributes, feature, ogr_geom):
+        out = self.entities
+        anchor = ogr_geom
+        if ogr.GT_Flatten(ogr_geom.GetGeometryType()) != ogr.wkbPoint:
+            anchor = ogr_geom.PointOnSurface() or ogr_geom.Centroid()
+        x, y = anchor.GetX(), anchor.GetY()
+
+        self._group(out, 0, "INSERT")
+        self._group(out, 8, layer_name)
+        self._group(out, 66, 1)
+        self._group(out, 2, block_name)
+        self._write_coords(out, (x, y), False)
+        for i, attribute in enumerate(attributes):
+            value = feature.get(attribute)
+            self._group(out, 0, "ATTRIB")
+            self._group(out, 8, layer_name)
+            self._write_coords(out, (x, y - i), False)
+            self._group(out, 40, 1.0)
+            self._group(out, 1, dxf_text(value) if value is not None else "")
+            self._group(out, 2, dxf_name(attribute))
+            self._group(out, 70, 0)
+        self._group(out, 0, "SEQEND")
+        self._group(out, 8, layer_name)
+
+    def _write_file(self):
+        with open(self.path, "w", encoding="ascii", newline="\r\n") as out:
+            self._group(out, 0, "SECTION")
+            self._group(out, 2, "HEADER")
+            self._group(out, 9, "$ACADVER")
+            self._group(out, 1, "AC1009")
+            self._group(out, 0, "ENDSEC")
+
+            self._group(out, 0, "SECTION")
+            self._group(out, 2, "TABLES")
+            self._group(out, 0, "TABLE")
+            self._group(out, 2, "LAYER")
+            self._group(out, 70, len(self.layers))
+            for layer_name in self.layers:
+                self._group(out, 0, "LAYER")
+                self._group(out, 2, layer_name)
+                self._group(out, 70, 0)
+                self._group(out, 62, 7)
+                self._group(out, 6, "CONTINUOUS")
+            self._group(out, 0, "ENDTAB")
+            self._group(out, 0, "ENDSEC")
+
+            self._group(out, 0, "SECTION")
+            self._group(out, 2, "BLOCKS")
+            for block_name, (layer_name, attributes) in self.blocks.items():
+                self._group(out, 0, "BLOCK")
+                self._group(out, 8, layer_name)
+                self._group(out, 2, block_name)
+                # 2 means the block has attribute definitions.
+                self._group(out, 70, 2)
+                self._write_coords(out, (0, 0), False)
+                self._group(out, 3, block_name)
+                for i, attribute in enumerate(attributes):
+                    self._group(out, 0, "ATTDEF")
+                    self._group(out, 8, layer_name)
+                    self._write_coords(out, (0, -i), False)
+                    self._group(out, 40, 1.0)
+                    self._group(out, 1, "")
+                    self._group(out, 3, dxf_text(attribute))
+                    self._group(out, 2, dxf_name(attribute))
+                    self._group(out, 70, 0)
+                self._group(out, 0, "ENDBLK")
+                self._group(out, 8, layer_name)
+            self._group(out, 0, "ENDSEC")
+
+            self._group(out, 0, "SECTION")
+            self._group(out, 2, "ENTITIES")
+            self.entities.seek(0)
+            for line in self.entities:
+                out.write(line)
+            self._group(out, 0, "ENDSEC")
+            self._group(out, 0, "EOF")
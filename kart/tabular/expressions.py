@@ -0,0 +1,412 @@
+import ast
+import math
+import operator
+
+from osgeo import ogr
+
+from kart.exceptions import InvalidOperation
+from kart.geometry import Geometry, bbox_as_wkt_polygon, ogr_to_gpkg_geom
+
+
+class ExpressionError(ValueError):
+    """Raised when an expression can't be parsed, or can't be evaluated for a particular feature."""
+
+
+def _float_pow(base, exponent):
+    # Always a float power - integer powers aren't bounded, so eg 9 ** 9 ** 9 would take forever to evaluate.
+    try:
+        return math.pow(base, exponent)
+    except (OverflowError, ValueError) as e:
+        raise ExpressionError(f"Can't evaluate {base!r} ** {exponent!r}: {e}")
+
+
+# Expressions use Python syntax, but only the following operators are allowed. Any operation on NULL gives NULL,
+# except for `is` and `is not`, and comparisons - which are false if either side is NULL.
+BINARY_OPERATORS = {
+    ast.Add: operator.add,
+    ast.Sub: operator.sub,
+    ast.Mult: operator.mul,
+    ast.Div: operator.truediv,
+    ast.FloorDiv: operator.floordiv,
+    ast.Mod: operator.mod,
+    ast.Pow: _float_pow,
+}
+
+UNARY_OPERATORS = {
+    ast.USub: operator.neg,
+    ast.UAdd: operator.pos,
+}
+
+COMPARISON_OPERATORS = {
+    ast.Eq: operator.eq,
+    ast.NotEq: operator.ne,
+    ast.Lt: operator.lt,
+    ast.LtE: operator.le,
+    ast.Gt: operator.gt,
+    ast.GtE: operator.ge,
+    ast.In: lambda a, b: a in b,
+    ast.NotIn: lambda a, b: a not in b,
+}
+
+
+def _null_safe(func):
+    """Wraps a function so that it returns NULL if any of its arguments are NULL."""
+
+    def wrapper(*args):
+        return None if any(a is None for a in args) else func(*args)
+
+    return wrapper
+
+
+def _coalesce(*values):
+    return next((v for v in values if v is not None), None)
+
+
+def _ogr(value):
+    if not isinstance(value, Geometry):
+        raise ExpressionError(f"Expected a geometry but got {value!r}")
+    return value.to_ogr()
+
+
+def _geometry_function(func):
+    """Wraps a function of OGR geometries so that it takes Kart geometries, and returns any geometry as a Kart geometry."""
+
+    def wrapper(geom, *args):
+        result = func(_ogr(geom), *args)
+        if isinstance(result, ogr.Geometry):
+            return ogr_to_gpkg_geom(result)
+        return result
+
+    return _null_safe(wrapper)
+
+
+def _geometry_predicate(func):
+    """Wraps a function of two OGR geometries, so that it takes two Kart geometries."""
+
+    def wrapper(a, b):
+        return func(_ogr(a), _ogr(b))
+
+    return _null_safe(wrapper)
+
+
+def _geom_from_wkt(wkt):
+    try:
+        return Geometry.from_wkt(wkt)
+    except Exception:
+        raise ExpressionError(f"Invalid WKT: {wkt!r}")
+
+
+# Functions that can be called from an expression, by name.
+FUNCTIONS = {
+    "abs": _null_safe(abs),
+    "round": _null_safe(round),
+    "floor": _null_safe(math.floor),
+    "ceil": _null_safe(math.ceil),
+    "sqrt": _null_safe(math.sqrt),
+    "int": _null_safe(int),
+    "float": _null_safe(float),
+    "str": _null_safe(str),
+    "lower": _null_safe(str.lower),
+    "upper": _null_safe(str.upper),
+    "strip": _null_safe(str.strip),
+    "len": _null_safe(len),
+    "replace": _null_safe(str.replace),
+    "coalesce": _coalesce,
+    "min": min,
+    "max": max,
+    # Geometry functions. Measurements are in the units of the geometry's CRS.
+    "area": _geometry_function(lambda g: g.GetArea()),
+    "length": _geometry_function(lambda g: g.Length()),
+    "centroid": _geometry_function(lambda g: g.Centroid()),
+    "buffer": _geometry_function(lambda g, distance: g.Buffer(distance)),
+    "envelope": _geometry_function(
+        lambda g: ogr.CreateGeometryFromWkt(bbox_as_wkt_polygon(*g.GetEnvelope()))
+    ),
+    "x": _geometry_function(lambda g: g.GetX()),
+    "y": _geometry_function(lambda g: g.GetY()),
+    "num_points": _geometry_function(lambda g: g.GetPointCount()),
+    "geometry_type": _geometry_function(lambda g: g.GetGeometryName()),
+    "is_empty": _geometry_function(lambda g: g.IsEmpty()),
+    "is_valid": _geometry_function(lambda g: g.IsValid()),
+    "wkt": _geometry_function(lambda g: g.ExportToIsoWkt()),
+    "geom_from_wkt": _null_safe(_geom_from_wkt),
+    "intersects": _geometry_predicate(lambda a, b: a.Intersects(b)),
+    "contains": _geometry_predicate(lambda a, b: a.Contains(b)),
+    "within": _geometry_predicate(lambda a, b: a.Within(b)),
+    "distance": _geometry_predicate(lambda a, b: a.Distance(b)),
+}
+
+# The data type of the result of each function, or None if it is the same as the type of its first argument.
+FUNCTION_TYPES = {
+    "abs": None,
+    "round": None,
+    "floor": "integer",
+    "ceil": "integer",
+    "sqrt": "float",
+    "int": "integer",
+    "float": "float",
+    "str": "text",
+    "lower": "text",
+    "upper": "text",
+    "strip": "text",
+    "len": "integer",
+    "replace": "text",
+    "coalesce": None,
+    "min": None,
+    "max": None,
+    "area": "float",
+    "length": "float",
+    "centroid": "geometry",
+    "buffer": "geometry",
+    "envelope": "geometry",
+    "x": "float",
+    "y": "float",
+    "num_points": "integer",
+    "geometry_type": "text",
+    "is_empty": "boolean",
+    "is_valid": "boolean",
+    "wkt": "text",
+    "geom_from_wkt": "geometry",
+    "intersects": "boolean",
+    "contains": "boolean",
+    "within": "boolean",
+    "distance": "float",
+}
+
+CONSTANT_TYPES = {bool: "boolean", int: "integer", float: "float", str: "text"}
+
+CONSTANTS = {"NULL": None, "pi": math.pi}
+
+
+class Expression:
+    """
+    An expression that computes a value from the fields of a feature - eg "height_ft * 0.3048" or
+    "height_ft > 100 and name is not None". Fields are referred to by name, and functions by the names in FUNCTIONS.
+    """
+
+    def __init__(self, text):
+        self.text = text
+        try:
+            self.tree = ast.parse(text.strip(), mode="eval")
+        except SyntaxError as e:
+            raise ExpressionError(f"Invalid expression {text!r}: {e.msg}")
+        self.field_names = set()
+        self._check(self.tree.body)
+
+    def _check(self, node):
+        """Checks that the expression only contains what can be evaluated, and records which fields it uses."""
+        if isinstance(node, ast.Name):
+            if node.id not in CONSTANTS:
+                self.field_names.add(node.id)
+            return
+        if isinstance(node, ast.Call):
+            if not isinstance(node.func, ast.Name) or node.func.id not in FUNCTIONS:
+                name = getattr(node.func, "id", ast.dump(node.func))
+                raise ExpressionError(
+                    f"Invalid expression {self.text!r}: unknown function {name}"
+                )
+            if node.keywords:
+                raise ExpressionError(
+                    f"Invalid expression {self.text!r}: keyword arguments are not supported"
+                )
+            for arg in node.args:
+                self._check(arg)
+            return
+        allowed = (
+            ast.Constant,
+            ast.BinOp,
+            ast.UnaryOp,
+            ast.BoolOp,
+            ast.Compare,
+            ast.IfExp,
+            ast.List,
+            ast.Tuple,
+            ast.Load,
+            ast.And,
+            ast.Or,
+            ast.Not,
+            ast.Is,
+            ast.IsNot,
+            *BINARY_OPERATORS,
+            *UNARY_OPERATORS,
+            *COMPARISON_OPERATORS,
+        )
+        if not isinstance(node, allowed):
+            raise ExpressionError(
+                f"Invalid expression {self.text!r}: {type(node).__name__} is not supported"
+            )
+        for child in ast.iter_child_nodes(node):
+            self._check(child)
+
+    def infer_type(self, column_types):
+        """
+        Returns the data type of the values of this expression - eg "float" or "geometry" - given a dict of
+        {field-name: data-type} for the fields it uses. Returns None if the type can't be inferred.
+        """
+        return self._infer_type(self.tree.body, column_types)
+
+    def _infer_type(self, node, column_types):
+        if isinstance(node, ast.Constant):
+            return CONSTANT_TYPES.get(type(node.value))
+        if isinstance(node, ast.Name):
+            return column_types.get(node.id)
+        if isinstance(node, (ast.BoolOp, ast.Compare)):
+            return "boolean"
+        if isinstance(node, ast.UnaryOp):
+            if isinstance(node.op, ast.Not):
+                return "boolean"
+            return self._infer_type(node.operand, column_types)
+        if isinstance(node, ast.BinOp):
+            left = self._infer_type(node.left, column_types)
+            right = self._infer_type(node.right, column_types)
+            if left == right == "text" and isinstance(node.op, ast.Add):
+                return "text"
+            if left == right == "integer" and not isinstance(
+                node.op, (ast.Div, ast.Pow)
+            ):
+                return "integer"
+            if {left, right} <= {"integer", "float"}:
+                return "float"
+            return None
+        if isinstance(node, ast.IfExp):
+            return self._infer_type(node.body, column_types) or self._infer_type(
+                node.orelse, column_types
+            )
+        if isinstance(node, ast.Call):
+            result_type = FUNCTION_TYPES[node.func.id]
+            if result_type is None and node.args:
+                result_type = self._infer_type(node.args[0], column_types)
+            return result_type
+        return None
+
+    def check_fields(self, field_names):
+        """Raises an ExpressionError if the expression uses any fields that aren't in the given field names."""
+        missing = sorted(self.field_names - set(field_names))
+        if missing:
+            raise ExpressionError(
+                f"Invalid expression {self.text!r}: no field called {', '.join(missing)}"
+            )
+
+    def evaluate(self, feature):
+        """Evaluates the expression for the given feature - a dict of {field-name: value}."""
+        try:
+            return self._eval(self.tree.body, feature)
+        except ExpressionError:
+            raise
+        except Exception as e:
+            raise ExpressionError(f"Couldn't evaluate {self.text!r}: {e}")
+
+    def matches(self, feature):
+        """Evaluates the expression for the given feature as a condition - NULL counts as false."""
+        return bool(self.evaluate(feature))
+
+    def _eval(self, node, feature):
+        if isinstance(node, ast.Constant):
+            return node.value
+        if isinstance(node, ast.Name):
+            if node.id in CONSTANTS:
+                return CONSTANTS[node.id]
+            return feature[node.id]
+        if isinstance(node, (ast.List, ast.Tuple)):
+            return [self._eval(elt, feature) for elt in node.elts]
+        if isinstance(node, ast.BinOp):
+            left = self._eval(node.left, feature)
+            right = self._eval(node.right, feature)
+            if left is None or right is None:
+                return None
+            return BINARY_OPERATORS[type(node.op)](left, right)
+        if isinstance(node, ast.UnaryOp):
+            operand = self._eval(node.operand, feature)
+            if operand is None:
+                return None
+            if isinstance(node.op, ast.Not):
+                return not operand
+            return UNARY_OPERATORS[type(node.op)](operand)
+        if isinstance(node, ast.BoolOp):
+            values = (self._eval(v, feature) for v in node.values)
+            return all(values) if isinstance(node.op, ast.And) else any(values)
+        if isinstance(node, ast.Compare):
+            left = self._eval(node.left, feature)
+            for op, right_node in zip(node.ops, node.comparators):
+                right = self._eval(right_node, feature)
+                if isinstance(op, ast.Is):
+                    result = left is right
+                elif isinstance(op, ast.IsNot):
+                    result = left is not right
+                elif left is None or right is None:
+                    result = False
+                else:
+                    result = COMPARISON_OPERATORS[type(op)](left, right)
+                if not result:
+                    return False
+                left = right
+            return True
+        if isinstance(node, ast.IfExp):
+            if self._eval(node.test, feature):
+                return self._eval(node.body, feature)
+            return self._eval(node.orelse, feature)
+        if isinstance(node, ast.Call):
+            args = [self._eval(arg, feature) for arg in node.args]
+            return FUNCTIONS[node.func.id](*args)
+        raise ExpressionError(
+            f"Invalid expression {self.text!r}: {type(node).__name__} is not supported"
+        )
+
+    def __str__(self):
+        return self.text
+
+
+class Assignment:
+    """An assignment of the value of an expression to a field - eg "height_m = height_ft * 0.3048"."""
+
+    def __init__(self, text):
+        field_name, sep, expression = text.partition("=")
+        field_name = field_name.strip()
+        if not sep or not field_name.isidentifier() or expression.startswith("="):
+            raise ExpressionError(
+                f"Invalid assignment {text!r}: expected FIELD = EXPRESSION"
+            )
+        self.text = text
+        self.field_name = field_name
+        self.expression = Expression(expression)
+
+    def __str__(self):
+        return self.text
+
+
+# The Python type of the values of each column data type that values are checked against.
+PYTHON_TYPES = {
+    "boolean": bool,
+    "integer": int,
+    "float": float,
+    "text": str,
+    "geometry": Geometry,
+    "blob": bytes,
+}
+
+
+def coerce_value(value, column, pk):
+    """
+    Converts the value of an expression to suit the given column, or raises an InvalidOperation if it can't.
+    pk identifies the feature in any error message.
+    """
+    if value is None:
+        return None
+    data_type = column.data_type
+    if data_type == "integer" and isinstance(value, float) and value.is_integer():
+        value = int(value)
+    elif data_type == "float" and type(value) is int:
+        value = float(value)
+    elif data_type == "text" and type(value) in (int, float):
+        value = str(value)
+
+    python_type = PYTHON_TYPES.get(data_type)
+    if python_type is None:
+        return value
+    if not isinstance(value, python_type) or (
+        isinstance(value, bool) and python_type is not bool
+    ):
+        raise InvalidOperation(
+            f"Can't set {column.name} of feature {pk} to {value!r} - expected a value of type {data_type}"
+        )
+    return value
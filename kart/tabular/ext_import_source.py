@@ -0,0 +1,246 @@
+import binascii
+import functools
+import json
+import shlex
+import sys
+
+import click
+
+from kart import subprocess_util as subprocess
+from kart.exceptions import (
+    NO_IMPORT_SOURCE,
+    NO_TABLE,
+    InvalidOperation,
+    NotFound,
+    SubprocessError,
+)
+from kart.geometry import hex_wkb_to_gpkg_geom
+from kart.output_util import dump_json_output
+from kart.schema import ColumnSchema, Schema
+
+from .import_source import TableImportSource
+
+# The version of the protocol that Kart speaks to external importers - see "External importers" in the docs.
+PROTOCOL_VERSION = "kart.ext/v1"
+
+
+class ExternalTableImportSource(TableImportSource):
+    """
+    TableImportSource that runs an external importer - any executable that speaks Kart's NDJSON protocol - so that
+    data can be imported from formats and APIs that Kart doesn't support itself.
+
+    For each request, the command is run once: the request is written to its stdin as a single line of JSON, and the
+    command writes its response to stdout as newline-delimited JSON - one line per table or per feature.
+    """
+
+    PREFIX = "EXT:"
+
+    @classmethod
+    def open(cls, spec, table=None):
+        command = shlex.split(spec)
+        if not command:
+            raise click.UsageError("No external importer command was given")
+        return ExternalTableImportSource(command, table=table)
+
+    def __init__(self, command, *, table=None, dest_path=None, meta_overrides=None):
+        self.command = command
+        self.table = table
+        if dest_path:
+            self.dest_path = dest_path
+        self.meta_overrides = {
+            k: v for k, v in (meta_overrides or {}).items() if v is not None
+        }
+
+    @property
+    def command_desc(self):
+        return shlex.join(self.command)
+
+    def __str__(self):
+        if self.table is None:
+            return self.command_desc
+        return f"{self.command_desc}:{self.table}"
+
+    def import_source_desc(self):
+        return f"Import from {self} to {self.dest_path}/"
+
+    def default_dest_path(self):
+        return self._normalise_dataset_path(self.table)
+
+    def _request(self, request, **params):
+        """Runs the external importer with the given request, and yields each line of its response as a dict."""
+        request_line = json.dumps(
+            {"protocol": PROTOCOL_VERSION, "request": request, **params}
+        )
+        try:
+            proc = subprocess.Popen(
+                self.command,
+                stdin=subprocess.PIPE,
+                stdout=subprocess.PIPE,
+                encoding="utf-8",
+            )
+        except OSError as e:
+            raise NotFound(
+                f"Couldn't run external importer {self.command_desc}: {e}",
+                exit_code=NO_IMPORT_SOURCE,
+            )
+
+        try:
+            proc.stdin.write(request_line + "\n")
+            proc.stdin.close()
+            for line in proc.stdout:
+                if not line.strip():
+                    continue
+                try:
+                    message = json.loads(line)
+                except ValueError:
+                    raise InvalidOperation(
+                        f"External importer {self.command_desc} wrote invalid JSON: {line.strip()!r}"
+                    )
+                if not isinstance(message, dict):
+                    raise InvalidOperation(
+                        f"External importer {self.command_desc} wrote {line.strip()!r} - expected a JSON object"
+                    )
+                if "error" in message:
+                    raise InvalidOperation(
+                        f"External importer {self.command_desc} failed: {message['error']}"
+                    )
+                yield message
+        finally:
+            # Stops the importer if it hasn't finished - eg if the response wasn't read to the end.
+            if proc.poll() is None:
+                proc.kill()
+            proc.stdout.close()
+            returncode = proc.wait()
+
+        if returncode:
+            raise SubprocessError(
+                f"External importer {self.command_desc} exited with code {returncode}",
+                exit_code=returncode,
+            )
+
+    @functools.lru_cache(maxsize=1)
+    def get_tables(self):
+        tables = {m["table"]: m.get("title") for m in self._request("tables")}
+        if self.table is not None:
+            return {self.table: tables.get(self.table)}
+        return tables
+
+    def print_table_list(self, do_json=False):
+        tables = self.get_tables()
+        if do_json:
+            dump_json_output({"kart.tables/v1": tables}, sys.stdout)
+        else:
+            click.secho("Tables found:", bold=True)
+            for table_name, title in tables.items():
+                if title:
+                    click.echo(f"  {table_name} - {title}")
+                else:
+                    click.echo(f"  {table_name}")
+        return tables
+
+    def clone_for_table(
+        self, table, *, dest_path=None, primary_key=None, meta_overrides={}
+    ):
+        if table not in self.get_tables():
+            raise NotFound(f"Table '{table}' not found", exit_code=NO_TABLE)
+
+        result = ExternalTableImportSource(
+            self.command,
+            table=table,
+            dest_path=dest_path,
+            meta_overrides={**self.meta_overrides, **meta_overrides},
+        )
+        if primary_key is not None:
+            result.override_primary_key(primary_key)
+        return result
+
+    @functools.lru_cache(maxsize=1)
+    def describe(self):
+        """The importer's description of the table - its schema, CRS definitions, title and so on."""
+        responses = list(self._request("describe", table=self.table))
+        if len(responses) != 1 or "schema" not in responses[0]:
+            raise InvalidOperation(
+                f"External importer {self.command_desc} didn't describe {self.table} - expected a single line with a schema"
+            )
+        return responses[0]
+
+    @functools.lru_cache(maxsize=1)
+    def meta_items_from_importer(self):
+        description = self.describe()
+        # Columns that the importer doesn't give an ID are given new ones - these are replaced by the IDs of the
+        # existing columns if the table has been imported before, see align_schema_to_existing_schema.
+        schema = [
+            {"id": ColumnSchema.new_id(), **column}
+            for column in description["schema"]
+        ]
+        try:
+            Schema(schema)
+        except (AssertionError, KeyError, TypeError, ValueError):
+            raise InvalidOperation(
+                f"External importer {self.command_desc} gave an invalid schema for {self.table}"
+            )
+
+        meta_items = {"schema.json": schema}
+        for key in ("title", "description"):
+            if description.get(key):
+                meta_items[key] = description[key]
+        for identifier, definition in description.get("crs", {}).items():
+            meta_items[f"crs/{identifier}.wkt"] = definition
+        return meta_items
+
+    def meta_items(self):
+        return {**self.meta_items_from_importer(), **self.meta_overrides}
+
+    def crs_definitions(self):
+        return {
+            key[4:-4]: value
+            for key, value in self.meta_items().items()
+            if key.startswith("crs/") and key.endswith(".wkt")
+        }
+
+    def align_schema_to_existing_schema(self, existing_schema):
+        aligned_schema = existing_schema.align_to_self(self.schema)
+        self.meta_overrides["schema.json"] = aligned_schema
+        assert self.schema == aligned_schema
+
+    def override_primary_key(self, new_primary_key):
+        """Modify the schema such that the given column is the primary key."""
+
+        def _modify_col(col):
+            pk_index = 0 if col["name"] == new_primary_key else None
+            return {**col, **{"primaryKeyIndex": pk_index}}
+
+        old_schema = self.get_meta_item("schema.json")
+        self.meta_overrides["schema.json"] = [_modify_col(c) for c in old_schema]
+
+        if not self.schema.pk_columns:
+            raise click.UsageError(
+                f"Cannot use column '{new_primary_key}' as primary key - column not found"
+            )
+
+    @property
+    def feature_count(self):
+        count = self.describe().get("featureCount")
+        if count is not None:
+            return count
+        return super().feature_count
+
+    def features(self):
+        columns = self.schema.columns
+        for feature in self._request("features", table=self.table):
+            yield {c.name: self._decode_value(feature.get(c.name), c) for c in columns}
+
+    def _decode_value(self, value, column):
+        if value is None:
+            return None
+        try:
+            if column.data_type == "geometry":
+                return hex_wkb_to_gpkg_geom(value)
+            elif column.data_type == "blob":
+                return binascii.unhexlify(value)
+        except (binascii.Error, TypeError):
+            raise InvalidOperation(
+                f"External importer {self.command_desc} wrote an invalid {column.data_type} value for {column.name} - "
+                "expected a hex string"
+            )
+        return value
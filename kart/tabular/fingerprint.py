@@ -0,0 +1,72 @@
+import logging
+
+from kart.tabular.pk_generation import PkGeneratingTableImportSource
+
+L = logging.getLogger("kart.tabular.fingerprint")
+
+# Meta items that are compared - along with the schema and CRS definitions - to check that a dataset is unchanged.
+COMPARED_META_ITEMS = ("title", "description", "metadata.xml")
+
+# Row hashes are SHA-1s, so they're summed modulo 2^160 to get the fingerprint.
+HASH_MODULUS = 1 << 160
+
+
+def table_fingerprint(schema, features, *, without_pk=False):
+    """
+    Returns a fingerprint of all of the given features: the number of features, and the sum of the git-hash of
+    each feature as encoded with the given schema. Since the hashes are summed, the fingerprint doesn't depend on
+    the order of the features, and only one feature needs to be held in memory at a time.
+    If without_pk is True, the fingerprint doesn't depend on the features' primary key values.
+    """
+    count = 0
+    total = 0
+    for feature in features:
+        count += 1
+        row_hash = schema.hash_feature(feature, without_pk=without_pk)
+        total = (total + int(row_hash, 16)) % HASH_MODULUS
+    return count, f"{total:040x}"
+
+
+def _source_fingerprint(source):
+    if isinstance(source, PkGeneratingTableImportSource):
+        # The primary keys haven't been generated yet, and generating them changes the state of the source -
+        # so compare the features without their primary keys.
+        pk_name = source.primary_key
+        features = ({pk_name: None, **f} for f in source.delegate.features())
+        return table_fingerprint(source.schema, features, without_pk=True), True
+    return table_fingerprint(source.schema, source.features()), False
+
+
+def is_import_unchanged(source, existing_dataset):
+    """
+    Returns True if importing the given TableImportSource over the top of the existing dataset wouldn't change it -
+    ie, the schema, CRS definitions, title etc are the same, and the fingerprint of every feature in the source
+    matches the fingerprint of the existing dataset. The source's schema should already be aligned to the existing
+    dataset's schema - see TableImportSource.align_schema_to_existing_schema
+    """
+    if source.schema != existing_dataset.schema:
+        L.debug("%s: schema has changed", source.dest_path)
+        return False
+    for name in COMPARED_META_ITEMS:
+        if source.get_meta_item(name) != existing_dataset.get_meta_item(name):
+            L.debug("%s: %s has changed", source.dest_path, name)
+            return False
+    if dict(source.crs_definitions()) != dict(existing_dataset.crs_definitions()):
+        L.debug("%s: CRS definitions have changed", source.dest_path)
+        return False
+    if source.feature_count != existing_dataset.feature_count:
+        L.debug("%s: feature count has changed", source.dest_path)
+        return False
+
+    with source:
+        source_fingerprint, without_pk = _source_fingerprint(source)
+    existing_fingerprint = table_fingerprint(
+        existing_dataset.schema, existing_dataset.features(), without_pk=without_pk
+    )
+    L.debug(
+        "%s: source fingerprint %s, existing fingerprint %s",
+        source.dest_path,
+        source_fingerprint,
+        existing_fingerprint,
+    )
+    return source_fingerprint == existing_fingerprint
@@ -0,0 +1,278 @@
+import math
+import random
+from datetime import date, timedelta
+from pathlib import Path
+
+import click
+
+from kart.cli_util import KartCommand
+from kart.crs_util import make_crs
+from kart.exceptions import InvalidOperation
+from kart.geometry import Geometry
+from kart.schema import ColumnSchema, Schema
+from kart.tabular.ogr_export import OgrTableExporter
+
+# Features are generated in New Zealand Transverse Mercator, so that their coordinates are in metres.
+FIXTURE_CRS = "EPSG:2193"
+# The area that features are generated in, as (min_x, min_y, max_x, max_y) - roughly the North Island of New Zealand.
+FIXTURE_EXTENT = (1_600_000, 5_400_000, 2_100_000, 6_200_000)
+
+GEOMETRY_TYPES = {"point": "POINT", "line": "LINESTRING", "polygon": "POLYGON"}
+
+# Categories and their relative frequencies - a few categories are much more common than the rest.
+CATEGORIES = {
+    "residential": 50,
+    "rural": 20,
+    "commercial": 12,
+    "industrial": 8,
+    "reserve": 6,
+    "education": 3,
+    "health": 1,
+}
+NAME_PREFIXES = (
+    "Kowhai",
+    "Totara",
+    "Rimu",
+    "Harbour",
+    "Station",
+    "Church",
+    "Victoria",
+    "Albert",
+    "Queen",
+    "Hill",
+    "Beach",
+    "Park",
+    "Mill",
+    "Bridge",
+    "Tui",
+    "Kereru",
+)
+NAME_SUFFIXES = (
+    "Street",
+    "Road",
+    "Avenue",
+    "Terrace",
+    "Crescent",
+    "Place",
+    "Drive",
+    "Lane",
+    "Way",
+)
+# Most features have no notes - the rest have one of these.
+NOTES = ("Surveyed", "Boundary disputed", "Awaiting inspection", "Heritage listed")
+NOTES_PROBABILITY = 0.15
+
+EARLIEST_DATE = date(2000, 1, 1)
+LATEST_DATE = date(2022, 12, 31)
+
+
+def fixture_schema(geometry_type, layer_name):
+    """The schema of a fixture layer - column IDs are deterministic, so that fixtures always import the same way."""
+    measure_name = {"point": "elevation_m", "line": "length_m", "polygon": "area_m2"}
+    columns = [
+        {"name": "fid", "dataType": "integer", "size": 64, "primaryKeyIndex": 0},
+        {
+            "name": "geom",
+            "dataType": "geometry",
+            "geometryType": GEOMETRY_TYPES[geometry_type],
+            "geometryCRS": FIXTURE_CRS,
+        },
+        {"name": "name", "dataType": "text"},
+        {"name": "category", "dataType": "text"},
+        {"name": measure_name[geometry_type], "dataType": "float", "size": 64},
+        {"name": "occupants", "dataType": "integer", "size": 32},
+        {"name": "recorded", "dataType": "date"},
+        {"name": "notes", "dataType": "text"},
+    ]
+    for column in columns:
+        column["id"] = ColumnSchema.deterministic_id(layer_name, column["name"])
+    return Schema(columns)
+
+
+def _coords_wkt(points):
+    return ", ".join(f"{x:.2f} {y:.2f}" for x, y in points)
+
+
+def _ring_area(points):
+    pairs = zip(points, points[1:] + points[:1])
+    return abs(sum(a[0] * b[1] - b[0] * a[1] for a, b in pairs)) / 2
+
+
+class FixtureGenerator:
+    """
+    Generates synthetic features, deterministically for any given seed. Features are clustered around a number of
+    towns, and their attributes follow skewed distributions, rather than being uniformly random - so that they are
+    a better stand-in for real data, eg when comparing how well they compress.
+    """
+
+    def __init__(self, geometry_type, seed, num_towns=50):
+        self.geometry_type = geometry_type
+        self.random = random.Random(seed)
+        min_x, min_y, max_x, max_y = FIXTURE_EXTENT
+        # Each town is (x, y, spread) - a few towns are much bigger than the rest.
+        self.towns = [
+            (
+                self.random.uniform(min_x, max_x),
+                self.random.uniform(min_y, max_y),
+                self.random.paretovariate(1.5) * 1000,
+            )
+            for i in range(num_towns)
+        ]
+        self.town_weights = [spread for x, y, spread in self.towns]
+        self.categories = list(CATEGORIES)
+        self.category_weights = list(CATEGORIES.values())
+
+    def _location(self):
+        x, y, spread = self.random.choices(self.towns, self.town_weights)[0]
+        return x + self.random.gauss(0, spread), y + self.random.gauss(0, spread)
+
+    def _point(self):
+        x, y = self._location()
+        elevation = round(self.random.lognormvariate(4, 1), 2)
+        return f"POINT({_coords_wkt([(x, y)])})", elevation
+
+    def _line(self):
+        x, y = self._location()
+        heading = self.random.uniform(0, 2 * math.pi)
+        points = [(x, y)]
+        length = 0
+        for i in range(self.random.randint(1, 12)):
+            # Mostly straight, with some turns - like a road.
+            heading += self.random.gauss(0, 0.4)
+            step = self.random.uniform(20, 200)
+            x, y = x + step * math.cos(heading), y + step * math.sin(heading)
+            points.append((x, y))
+            length += step
+        return f"LINESTRING({_coords_wkt(points)})", round(length, 2)
+
+    def _polygon(self):
+        x, y = self._location()
+        radius = self.random.lognormvariate(3, 0.7)
+        num_vertices = self.random.randint(4, 12)
+        # Vertices at increasing angles around the centre make a simple polygon, with a counter-clockwise ring.
+        angles = sorted(
+            self.random.uniform(0, 2 * math.pi) for i in range(num_vertices)
+        )
+        points = []
+        for angle in angles:
+            r = radius * self.random.uniform(0.6, 1)
+            points.append(
+                (round(x + r * math.cos(angle), 2), round(y + r * math.sin(angle), 2))
+            )
+        area = round(_ring_area(points), 2)
+        return f"POLYGON(({_coords_wkt(points + points[:1])}))", area
+
+    def _date(self):
+        days = (LATEST_DATE - EARLIEST_DATE).days
+        # Skewed towards recent dates - more data has been recorded lately.
+        offset = int(days * (1 - self.random.random() ** 2))
+        return (EARLIEST_DATE + timedelta(days=offset)).isoformat()
+
+    def features(self, count, schema):
+        make_geometry = {
+            "point": self._point,
+            "line": self._line,
+            "polygon": self._polygon,
+        }[self.geometry_type]
+        column_names = [c.name for c in schema.columns]
+        for fid in range(1, count + 1):
+            wkt, measure = make_geometry()
+            category = self.random.choices(self.categories, self.category_weights)[0]
+            name = " ".join(
+                (self.random.choice(NAME_PREFIXES), self.random.choice(NAME_SUFFIXES))
+            )
+            occupants = None
+            if category != "reserve":
+                occupants = int(self.random.lognormvariate(1, 1))
+            notes = None
+            if self.random.random() < NOTES_PROBABILITY:
+                notes = self.random.choice(NOTES)
+            values = (
+                fid,
+                Geometry.from_wkt(wkt),
+                name,
+                category,
+                measure,
+                occupants,
+                self._date(),
+                notes,
+            )
+            yield dict(zip(column_names, values))
+
+
+class FixtureDataset:
+    """Just enough of a table dataset for OgrTableExporter to write generated features as a layer."""
+
+    def __init__(self, layer_name, geometry_type, count, seed):
+        self.path = layer_name
+        self.schema = fixture_schema(geometry_type, layer_name)
+        self.count = count
+        self.generator = FixtureGenerator(geometry_type, seed)
+
+    def get_crs_definition(self, identifier):
+        return make_crs(identifier).ExportToWkt()
+
+    def features(self):
+        return self.generator.features(self.count, self.schema)
+
+
+@click.command("generate-fixture", cls=KartCommand)
+@click.option(
+    "--features",
+    "count",
+    type=click.IntRange(min=0),
+    default=1000,
+    show_default=True,
+    help="How many features to generate.",
+)
+@click.option(
+    "--geometry",
+    "geometry_type",
+    type=click.Choice(list(GEOMETRY_TYPES)),
+    default="point",
+    show_default=True,
+    help="The type of geometry to generate.",
+)
+@click.option(
+    "--seed",
+    type=int,
+    default=0,
+    show_default=True,
+    help="Generating features with the same seed always gives the same features.",
+)
+@click.option(
+    "--layer",
+    "layer_name",
+    default="fixture",
+    show_default=True,
+    help="The name of the layer to create.",
+)
+@click.option(
+    "--overwrite",
+    is_flag=True,
+    help="Overwrite the output file if it already exists.",
+)
+@click.argument("path", type=click.Path(dir_okay=False, writable=True))
+def generate_fixture(count, geometry_type, seed, layer_name, overwrite, path):
+    """
+    Generate a GeoPackage of synthetic features - eg for benchmarks, or to test a pipeline at scale without using
+    real data. The output is the same every time for any given seed.
+
+    Features are clustered around towns in New Zealand, in the EPSG:2193 CRS, and have a name, a category, a measure
+    (the elevation of points, the length of lines or the area of polygons), a count of occupants, the date they were
+    recorded and some notes. As in real data, some values are much more common than others, and some are NULL.
+
+    eg: kart generate-fixture --features 100000 --geometry polygon --seed 42 out.gpkg
+    """
+    path = Path(path)
+    if path.exists():
+        if not overwrite:
+            raise InvalidOperation(
+                f"{path} already exists - use --overwrite to replace it"
+            )
+        path.unlink()
+
+    dataset = FixtureDataset(layer_name, geometry_type, count, seed)
+    with OgrTableExporter(path, "GPKG", fid_layer_option="FID") as exporter:
+        written = exporter.write_dataset(dataset, layer_name=layer_name)
+    click.echo(f"Wrote {written} {geometry_type} features to {path}")
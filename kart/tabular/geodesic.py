@@ -0,0 +1,190 @@
+import math
+
+from osgeo import osr
+
+from kart.crs_util import make_crs
+
+# Vincenty's inverse formula is iterated until the change in longitude on the auxiliary sphere is below this.
+VINCENTY_TOLERANCE = 1e-12
+VINCENTY_MAX_ITERATIONS = 200
+
+
+class GeodesicMeasurer:
+    """
+    Measures geometries on the ellipsoid of a CRS, rather than in the CRS's own units - so that areas are in square
+    metres and lengths are in metres, whether the CRS is geographic or projected, and however distorted it is.
+    """
+
+    def __init__(self, crs):
+        if isinstance(crs, str):
+            crs = make_crs(crs)
+        self.geographic_crs = crs.CloneGeogCS()
+        self.geographic_crs.SetAxisMappingStrategy(osr.OAMS_TRADITIONAL_GIS_ORDER)
+        self.transform = osr.CoordinateTransformation(crs, self.geographic_crs)
+
+        self.semi_major = self.geographic_crs.GetSemiMajor()
+        self.semi_minor = self.geographic_crs.GetSemiMinor()
+        self.flattening = (self.semi_major - self.semi_minor) / self.semi_major
+
+    @classmethod
+    def for_dataset(cls, dataset):
+        """Returns a GeodesicMeasurer for the CRS of the given dataset, or None if it doesn't have exactly one."""
+        crs_defs = list(dataset.crs_definitions().values())
+        if len(crs_defs) != 1:
+            return None
+        return cls(crs_defs[0])
+
+    def _to_geographic(self, geom):
+        ogr_geom = geom.to_ogr()
+        if ogr_geom.HasCurveGeometry():
+            ogr_geom = ogr_geom.GetLinearGeometry()
+        ogr_geom.Transform(self.transform)
+        return ogr_geom
+
+    def measure(self, geom):
+        """
+        Returns a dict of the geodesic measurements that make sense for the given Geometry - the area of polygons, and
+        the length of lines. Points have no measurements.
+        """
+        if geom is None or geom.is_empty():
+            return {}
+        ogr_geom = self._to_geographic(geom)
+        dimension = ogr_geom.GetDimension()
+        if dimension == 2:
+            return {"area": self._area(ogr_geom)}
+        elif dimension == 1:
+            return {"length": self._length(ogr_geom)}
+        return {}
+
+    def area(self, geom):
+        """The geodesic area of the given Geometry, in square metres."""
+        if geom is None or geom.is_empty():
+            return None
+        return self._area(self._to_geographic(geom))
+
+    def length(self, geom):
+        """The geodesic length of the given Geometry - or the perimeter, for polygons - in metres."""
+        if geom is None or geom.is_empty():
+            return None
+        return self._length(self._to_geographic(geom))
+
+    def centroid_distance(self, geom1, geom2):
+        """How far apart the centroids of the two given Geometries are, in metres - eg how far a feature moved."""
+        if geom1 is None or geom1.is_empty() or geom2 is None or geom2.is_empty():
+            return None
+        lon1, lat1 = self._to_geographic(geom1).Centroid().GetPoint_2D()
+        lon2, lat2 = self._to_geographic(geom2).Centroid().GetPoint_2D()
+        return self.distance(lon1, lat1, lon2, lat2)
+
+    def _area(self, ogr_geom):
+        # Lambert azimuthal equal-area preserves area on the ellipsoid - centring it on the geometry keeps the
+        # difference between straight edges in the projection and geodesic edges on the ellipsoid small.
+        lon, lat = ogr_geom.Centroid().GetPoint_2D()
+        laea = osr.SpatialReference()
+        laea.ImportFromProj4(
+            f"+proj=laea +lat_0={lat} +lon_0={lon} "
+            f"+a={self.semi_major} +b={self.semi_minor} +units=m +no_defs"
+        )
+        laea.SetAxisMappingStrategy(osr.OAMS_TRADITIONAL_GIS_ORDER)
+        ogr_geom = ogr_geom.Clone()
+        ogr_geom.Transform(osr.CoordinateTransformation(self.geographic_crs, laea))
+        return ogr_geom.GetArea()
+
+    def _length(self, ogr_geom):
+        count = ogr_geom.GetGeometryCount()
+        if count:
+            return sum(self._length(ogr_geom.GetGeometryRef(i)) for i in range(count))
+        points = ogr_geom.GetPoints() or []
+        return sum(
+            self.distance(p1[0], p1[1], p2[0], p2[1])
+            for p1, p2 in zip(points, points[1:])
+        )
+
+    def distance(self, lon1, lat1, lon2, lat2):
+        """
+        The geodesic distance in metres between two points given in degrees, using Vincenty's inverse formula -
+        falling back to the great-circle distance for nearly antipodal points, where it doesn't converge.
+        """
+        if (lon1, lat1) == (lon2, lat2):
+            return 0.0
+        a, b, f = self.semi_major, self.semi_minor, self.flattening
+        L = math.radians(lon2 - lon1)
+        U1 = math.atan((1 - f) * math.tan(math.radians(lat1)))
+        U2 = math.atan((1 - f) * math.tan(math.radians(lat2)))
+        sin_U1, cos_U1 = math.sin(U1), math.cos(U1)
+        sin_U2, cos_U2 = math.sin(U2), math.cos(U2)
+
+        lam = L
+        for _ in range(VINCENTY_MAX_ITERATIONS):
+            sin_lam, cos_lam = math.sin(lam), math.cos(lam)
+            sin_sigma = math.hypot(
+                cos_U2 * sin_lam, cos_U1 * sin_U2 - sin_U1 * cos_U2 * cos_lam
+            )
+            if sin_sigma == 0:
+                return 0.0
+            cos_sigma = sin_U1 * sin_U2 + cos_U1 * cos_U2 * cos_lam
+            sigma = math.atan2(sin_sigma, cos_sigma)
+            sin_alpha = cos_U1 * cos_U2 * sin_lam / sin_sigma
+            cos2_alpha = 1 - sin_alpha**2
+            cos_2sigma_m = (
+                cos_sigma - 2 * sin_U1 * sin_U2 / cos2_alpha if cos2_alpha else 0.0
+            )
+            C = f / 16 * cos2_alpha * (4 + f * (4 - 3 * cos2_alpha))
+            prev_lam = lam
+            lam = L + (1 - C) * f * sin_alpha * (
+                sigma
+                + C
+                * sin_sigma
+                * (cos_2sigma_m + C * cos_sigma * (-1 + 2 * cos_2sigma_m**2))
+            )
+            if abs(lam - prev_lam) < VINCENTY_TOLERANCE:
+                break
+        else:
+            return self._great_circle_distance(lon1, lat1, lon2, lat2)
+
+        u2 = cos2_alpha * (a**2 - b**2) / b**2
+        A = 1 + u2 / 16384 * (4096 + u2 * (-768 + u2 * (320 - 175 * u2)))
+        B = u2 / 1024 * (256 + u2 * (-128 + u2 * (74 - 47 * u2)))
+        delta_sigma = (
+            B
+            * sin_sigma
+            * (
+                cos_2sigma_m
+                + B
+                / 4
+                * (
+                    cos_sigma * (-1 + 2 * cos_2sigma_m**2)
+                    - B
+                    / 6
+                    * cos_2sigma_m
+                    * (-3 + 4 * sin_sigma**2)
+                    * (-3 + 4 * cos_2sigma_m**2)
+                )
+            )
+        )
+        return b * A * (sigma - delta_sigma)
+
+    def _great_circle_distance(self, lon1, lat1, lon2, lat2):
+        radius = (2 * self.semi_major + self.semi_minor) / 3
+        phi1, phi2 = math.radians(lat1), math.radians(lat2)
+        d_phi, d_lam = phi2 - phi1, math.radians(lon2 - lon1)
+        h = (
+            math.sin(d_phi / 2) ** 2
+            + math.cos(phi1) * math.cos(phi2) * math.sin(d_lam / 2) ** 2
+        )
+        return 2 * radius * math.asin(min(1.0, math.sqrt(h)))
+
+
+def format_measurement(name, value):
+    """Formats a geodesic measurement for humans - eg 1,234.5 m²."""
+    unit = "m²" if name == "area" else "m"
+    return f"{value:,.1f} {unit}"
+
+
+def describe_measurement_change(name, old_value, new_value):
+    """Describes how a measurement changed - eg "shrank by 34.0 m²"."""
+    change = new_value - old_value
+    if round(change, 1) == 0:
+        return "unchanged"
+    verb = "grew" if change > 0 else "shrank"
+    return f"{verb} by {format_measurement(name, abs(change))}"
@@ -14,12 +14,26 @@ from kart.cli_util import (
 from kart.completion_shared import file_path_completer
 from kart.core import check_git_user
 from kart.dataset_util import validate_dataset_paths
-from kart.exceptions import InvalidOperation
+from kart.exceptions import InvalidOperation, NotFound, NO_CHANGES
 from kart.fast_import import FastImportSettings, ReplaceExisting, fast_import_tables
+from kart.import_provenance import (
+    build_provenance,
+    find_previous_import,
+    record_provenance,
+    source_sha256,
+)
 from kart.import_sources import suggest_specs
 from kart.key_filters import RepoKeyFilter
+from kart.lineage import DerivedFromType, record_lineage, resolve_derived_from
+from kart.tabular.fingerprint import is_import_unchanged
 from kart.tabular.import_source import TableImportSource
+from kart.tabular.ogr_import_source import OSMImportSource
+from kart.tabular.linearizing_import_source import LinearizingTableImportSource
+from kart.tabular.require_geometry_import_source import (
+    RequireGeometryTableImportSource,
+)
 from kart.tabular.pk_generation import PkGeneratingTableImportSource
+from kart.tabular.source_encoding import AUTO_ENCODING, SourceEncodingType
 from kart.working_copy import PartType
 
 
@@ -39,7 +53,7 @@ def any_at_all(iterable):
     return any(True for _ in iterable)
 
 
-@click.command("table-import", hidden=True, cls=KartCommand)
+@click.command("table-import", hidden=True, cls=KartCommand, writes_to_repo=True)
 @click.pass_context
 @click.option(
     "--all-tables",
@@ -83,6 +97,42 @@ def any_at_all(iterable):
         "To import from a file, prefix with `@`, e.g. `--table-info=@filename.json`"
     ),
 )
+@click.option(
+    "--tag-mapping",
+    type=JsonFromFile(
+        encoding="utf-8",
+        schema={
+            "type": "object",
+            "$schema": "http://json-schema.org/draft-07/schema",
+            "patternProperties": {
+                ".*": {
+                    "type": "object",
+                    "properties": {
+                        "columns": {
+                            "oneOf": [
+                                {"type": "array", "items": {"type": "string"}},
+                                {
+                                    "type": "object",
+                                    "additionalProperties": {"type": "string"},
+                                },
+                            ]
+                        },
+                        "require": {"type": "array", "items": {"type": "string"}},
+                    },
+                    "additionalProperties": False,
+                }
+            },
+        },
+    ),
+    help=(
+        "OpenStreetMap imports only: which OSM tags to import as columns, in a nested JSON object. \n"
+        "Each key is an OSM layer name (points, lines, multilinestrings, multipolygons or other_relations), and each "
+        "value is an object containing 'columns' - either a list of tags, or an object mapping column names to tags - "
+        "and optionally 'require' - a list of tags that a feature must have to be imported.\n"
+        """e.g.:  --tag-mapping='{"points": {"columns": {"name": "name", "kind": "amenity"}, "require": ["amenity"]}}'\n"""
+        "To import from a file, prefix with `@`, e.g. `--tag-mapping=@mapping.json`"
+    ),
+)
 @click.option(
     "--list",
     "do_list",
@@ -111,6 +161,22 @@ def any_at_all(iterable):
     is_flag=True,
     help="Replace existing dataset(s) of the same name.",
 )
+@click.option(
+    "--skip-unchanged",
+    is_flag=True,
+    help=(
+        "Don't re-import datasets that haven't changed since they were last imported, and don't make a commit if "
+        "nothing has changed at all. Implies --replace-existing. Useful for scheduled imports of the same source."
+    ),
+)
+@click.option(
+    "--if-source-changed",
+    is_flag=True,
+    help=(
+        "Don't import anything if a source with the same SHA-256 has already been imported to the same datasets, "
+        "in the history of HEAD. Useful for jobs that may be retried. Only supported for file-based sources."
+    ),
+)
 @click.option(
     "--replace-ids",
     type=IdsFromFile(encoding="utf-8"),
@@ -168,6 +234,49 @@ def any_at_all(iterable):
     default=None,
     hidden=True,
 )
+@click.option(
+    "--linearize",
+    is_flag=True,
+    default=False,
+    help=(
+        "Replace any curved geometries (CIRCULARSTRING, COMPOUNDCURVE, CURVEPOLYGON, MULTICURVE, MULTISURFACE) with "
+        "linear approximations as they are imported, and change the geometry type of the dataset to match. "
+        "Useful if the data is to be checked out to a working copy that doesn't support curves."
+    ),
+)
+@click.option(
+    "--require-geometry",
+    is_flag=True,
+    default=False,
+    help=(
+        "Abort the import if any feature has a NULL or EMPTY geometry. "
+        "Without this option, NULL and EMPTY geometries are both imported as-is, and are kept distinct."
+    ),
+)
+@click.option(
+    "--source-encoding",
+    type=SourceEncodingType(),
+    help=(
+        "The encoding of the text in the source, eg latin-1 or shift_jis - use this if text from a legacy source "
+        "is garbled when imported. Specify auto to guess the encoding from a sample of the text. "
+        "Without this option, the encoding is decided by the source format - eg, shapefiles declare their encoding "
+        "in a .cpg file."
+    ),
+)
+@click.option(
+    "--derived-from",
+    type=DerivedFromType(),
+    multiple=True,
+    help=(
+        "Record that the imported datasets were derived from the given dataset as it was at the given commit - eg "
+        "--derived-from=roads/raw@HEAD. Can be specified more than once. See `kart lineage show`."
+    ),
+)
+@click.option(
+    "--ogr-driver",
+    help="Only open the source using this OGR driver. Used by `kart load-ogr`.",
+    hidden=True,
+)
 @click.option(
     "--dataset-path",
     "--dataset",
@@ -189,13 +298,21 @@ def table_import(
     output_format,
     primary_key,
     table_info,
+    tag_mapping,
     replace_existing,
+    skip_unchanged,
+    if_source_changed,
     replace_ids,
     similarity_detection_limit,
     allow_empty,
     max_delta_depth,
     do_checkout,
     num_workers,
+    linearize,
+    require_geometry,
+    source_encoding,
+    derived_from,
+    ogr_driver,
     ds_path,
     args,
 ):
@@ -232,14 +349,27 @@ def table_import(
             "Illegal usage: '--output-format=json' only supports --list"
         )
     if do_list:
-        TableImportSource.open(source).print_table_list(do_json=output_format == "json")
+        TableImportSource.open(source, ogr_driver=ogr_driver).print_table_list(
+            do_json=output_format == "json"
+        )
         return
 
     repo = ctx.obj.repo
     check_git_user(repo)
     check_for_import_from_within_working_copy(repo, source, tables)
+    derived_from = resolve_derived_from(repo, derived_from)
+
+    base_import_source = TableImportSource.open(
+        source, source_encoding=source_encoding, ogr_driver=ogr_driver
+    )
+    if tag_mapping:
+        if not isinstance(base_import_source, OSMImportSource):
+            raise click.UsageError(
+                "--tag-mapping is only supported when importing from OpenStreetMap data"
+            )
+        base_import_source.tag_mapping = tag_mapping
+        base_import_source.check_tag_mapping(param_hint="--tag-mapping")
 
-    base_import_source = TableImportSource.open(source)
     if all_tables:
         tables = base_import_source.get_tables().keys()
     elif not tables:
@@ -250,7 +380,17 @@ def table_import(
             "Cannot specify a --dataset-path while importing more than one table"
         )
 
+    if skip_unchanged:
+        replace_existing = True
+
+    sha256 = source_sha256(source)
+    if if_source_changed and sha256 is None:
+        raise click.UsageError(
+            "--if-source-changed is only supported for sources that are local files or directories"
+        )
+
     import_sources = []
+    requested_ds_paths = []
     for table in tables:
         if ":" in table:
             if ds_path:
@@ -277,6 +417,24 @@ def table_import(
             primary_key=primary_key,
             meta_overrides=meta_overrides,
         )
+        requested_ds_paths.append(import_source.dest_path)
+        if source_encoding == AUTO_ENCODING:
+            import_source.source_encoding = import_source.detect_source_encoding()
+            click.echo(
+                f"Warning: Guessed that the text in {import_source} is encoded as {import_source.source_encoding}. "
+                "If the imported text looks wrong, specify the encoding with --source-encoding.",
+                err=True,
+            )
+        if linearize:
+            import_source = LinearizingTableImportSource.wrap_source_if_needed(
+                import_source
+            )
+        if require_geometry:
+            if not import_source.schema.has_geometry:
+                raise InvalidOperation(
+                    f"--require-geometry was specified, but {import_source} has no geometry column"
+                )
+            import_source = RequireGeometryTableImportSource(import_source)
 
         if replace_ids is not None:
             if repo.table_dataset_version < 2:
@@ -322,8 +480,25 @@ def table_import(
                     raise InvalidOperation(
                         "--replace-ids is not supported when the primary key column is being changed"
                     )
+                if skip_unchanged and is_import_unchanged(import_source, existing_ds):
+                    click.echo(
+                        f"Skipping {import_source} - {import_source.dest_path}/ is unchanged since it was last imported"
+                    )
+                    continue
         import_sources.append(import_source)
 
+    if if_source_changed:
+        previous_import = find_previous_import(repo, sha256, requested_ds_paths)
+        if previous_import is not None:
+            click.echo(
+                f"Source is unchanged since it was imported in commit {previous_import.short_id} - nothing to import"
+            )
+            return
+
+    if skip_unchanged and not import_sources:
+        click.echo("No changes to import")
+        return
+
     TableImportSource.check_valid(import_sources, param_hint="tables")
 
     new_ds_paths = [s.dest_path for s in import_sources]
@@ -336,17 +511,43 @@ def table_import(
     replace_existing_enum = (
         ReplaceExisting.GIVEN if replace_existing else ReplaceExisting.DONT_REPLACE
     )
-    fast_import_tables(
-        repo,
-        import_sources,
-        settings=FastImportSettings(max_delta_depth=max_delta_depth),
-        verbosity=ctx.obj.verbosity + 1,
-        message=message,
-        replace_existing=replace_existing_enum,
-        from_commit=repo.head_commit,
-        replace_ids=replace_ids,
-        allow_empty=allow_empty,
+    try:
+        fast_import_tables(
+            repo,
+            import_sources,
+            settings=FastImportSettings(max_delta_depth=max_delta_depth),
+            verbosity=ctx.obj.verbosity + 1,
+            message=message,
+            replace_existing=replace_existing_enum,
+            from_commit=repo.head_commit,
+            replace_ids=replace_ids,
+            allow_empty=allow_empty,
+        )
+    except NotFound as e:
+        # The fingerprints only catch datasets that are exactly the same - this catches anything else that would
+        # have resulted in an empty commit.
+        if not (skip_unchanged and e.exit_code == NO_CHANGES):
+            raise
+        click.echo("No changes to import")
+        return
+
+    provenance = build_provenance(
+        source,
+        requested_ds_paths,
+        {
+            "tables": list(tables),
+            "primaryKey": primary_key,
+            "replaceExisting": replace_existing,
+            "replaceIds": replace_ids is not None,
+            "linearize": linearize,
+            "requireGeometry": require_geometry,
+            "sourceEncoding": source_encoding,
+            "ogrDriver": ogr_driver,
+        },
+        sha256=sha256,
     )
+    record_provenance(repo, repo.head_commit, provenance)
+    record_lineage(repo, repo.head_commit, requested_ds_paths, derived_from)
 
     # During imports we can keep old changes since they won't conflict with newly imported datasets.
     parts_to_create = [PartType.TABULAR] if do_checkout else []
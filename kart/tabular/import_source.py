@@ -24,20 +24,45 @@ class TableImportSource:
         return spec
 
     @classmethod
-    def open(cls, full_spec, table=None):
+    def open(cls, full_spec, table=None, source_encoding=None, ogr_driver=None):
         from kart.sqlalchemy import DbType
 
         spec = cls._remove_unnecessary_prefix(str(full_spec))
 
+        from .ext_import_source import ExternalTableImportSource
+
+        if spec.upper().startswith(ExternalTableImportSource.PREFIX):
+            if source_encoding is not None or ogr_driver is not None:
+                raise click.UsageError(
+                    "--source-encoding and --ogr-driver are not supported when importing with an external importer"
+                )
+            return ExternalTableImportSource.open(
+                spec[len(ExternalTableImportSource.PREFIX) :], table=table
+            )
+
         db_type = DbType.from_spec(spec)
         if db_type is not None:
             from .sqlalchemy_import_source import SqlAlchemyTableImportSource
 
+            if source_encoding is not None:
+                raise click.UsageError(
+                    "--source-encoding is not supported when importing from a database - "
+                    "the database's own encoding is used"
+                )
+            if ogr_driver is not None:
+                raise click.UsageError(
+                    "An OGR driver can't be specified when importing from a database"
+                )
             return SqlAlchemyTableImportSource.open(spec, table=table)
         else:
             from .ogr_import_source import OgrTableImportSource
 
-            return OgrTableImportSource.open(full_spec, table=table)
+            return OgrTableImportSource.open(
+                full_spec,
+                table=table,
+                source_encoding=source_encoding,
+                ogr_driver=ogr_driver,
+            )
 
     @classmethod
     def check_valid(cls, import_sources, param_hint=None):
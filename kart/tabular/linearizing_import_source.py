@@ -0,0 +1,54 @@
+from kart.geometry import is_curve_geometry_type_name, linearized_geometry_type_name
+from kart.schema import ColumnSchema
+
+from .wrapping_import_source import WrappingTableImportSource
+
+
+class LinearizingTableImportSource(WrappingTableImportSource):
+    """
+    Wrapper of TableImportSource that replaces any curved geometries found in the delegate TableImportSource with
+    linear approximations - curves are approximated by a series of straight line segments.
+    The schema is also modified so that curved geometry types are replaced by their linear equivalents:
+    CIRCULARSTRING and COMPOUNDCURVE become LINESTRING, CURVEPOLYGON becomes POLYGON, MULTICURVE becomes
+    MULTILINESTRING and MULTISURFACE becomes MULTIPOLYGON.
+
+    This is useful when the imported data needs to be checked out to a working copy - or read by some other
+    software - that doesn't support curved geometries.
+    """
+
+    @classmethod
+    def wrap_source_if_needed(cls, source, **kwargs):
+        """Wraps a TableImportSource in a LinearizingTableImportSource if the original data may contain curves."""
+        for col in source.schema.geometry_columns:
+            geometry_type = col.get("geometryType", "GEOMETRY").upper()
+            # GEOMETRY and GEOMETRYCOLLECTION columns can also contain curves.
+            if is_curve_geometry_type_name(geometry_type) or geometry_type.startswith(
+                "GEOMETRY"
+            ):
+                return cls(source, **kwargs)
+        return source
+
+    def __init__(self, delegate, *, max_angle_step_degrees=0):
+        self.max_angle_step_degrees = max_angle_step_degrees
+        super().__init__(delegate)
+
+    def transform_schema(self, schema):
+        result = []
+        for col in schema:
+            geometry_type = col.get("geometryType")
+            if col.data_type == "geometry" and geometry_type:
+                col = ColumnSchema(
+                    {
+                        **col,
+                        "geometryType": linearized_geometry_type_name(geometry_type),
+                    }
+                )
+            result.append(col)
+        return result
+
+    def transform_feature(self, feature):
+        for col in self.schema.geometry_columns:
+            geom = feature.get(col.name)
+            if geom is not None:
+                feature[col.name] = geom.linearize(self.max_angle_step_degrees)
+        return feature
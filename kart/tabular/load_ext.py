@@ -0,0 +1,43 @@
+import shlex
+
+import click
+
+from kart.cli_util import KartCommand
+
+
+@click.command(
+    "load-ext",
+    cls=KartCommand,
+    context_settings=dict(ignore_unknown_options=True),
+    writes_to_repo=True,
+)
+@click.pass_context
+@click.option(
+    "--arg",
+    "command_args",
+    multiple=True,
+    help="An argument to pass to the external importer command. Can be specified more than once.",
+)
+@click.argument("command")
+@click.argument("import_args", nargs=-1, type=click.UNPROCESSED)
+def load_ext(ctx, command_args, command, import_args):
+    """
+    Import tables from an external importer - a program that reads a format or an API that Kart doesn't support
+    itself, and writes the tables' schemas and features to stdout as newline-delimited JSON. This means a new source
+    of data only needs an importer, rather than changes to Kart. See "External importers" in the documentation of
+    table datasets for the protocol that importers speak.
+
+    COMMAND is the importer to run, which must be executable. Arguments for it - eg the location of the data, or
+    credentials - are given with --arg. Any other arguments are passed on to `kart table-import` - for instance,
+    the TABLE or TABLE:DATASET to import, --all-tables, --list, --primary-key or --message.
+
+    eg: kart load-ext ./asset-register-importer --arg=--env=prod pipes:assets/pipes
+    """
+    from kart.tabular.import_ import table_import
+
+    source = shlex.join([command, *command_args])
+    subctx = table_import.make_context(
+        table_import.name, [f"EXT:{source}", *import_args]
+    )
+    subctx.obj = ctx.obj
+    subctx.forward(table_import)
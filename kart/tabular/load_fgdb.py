@@ -0,0 +1,41 @@
+import click
+
+from kart.cli_util import KartCommand
+from kart.completion_shared import file_path_completer
+
+
+@click.command(
+    "load-fgdb",
+    cls=KartCommand,
+    context_settings=dict(ignore_unknown_options=True),
+    writes_to_repo=True,
+)
+@click.pass_context
+@click.argument(
+    "gdb_path",
+    metavar="DATA.gdb",
+    type=click.Path(exists=True, file_okay=False),
+    shell_complete=file_path_completer,
+)
+@click.argument("layer")
+@click.argument("ds_path", metavar="DATASET")
+@click.argument("import_args", nargs=-1, type=click.UNPROCESSED)
+def load_fgdb(ctx, gdb_path, layer, ds_path, import_args):
+    """
+    Import a feature class from an ESRI File Geodatabase as a new dataset. The geodatabase is read directly - there
+    is no need to convert it to another format first. The OBJECTID of each feature is used as its primary key, and
+    any ArcGIS metadata is imported as metadata.xml.
+
+    Any other options are passed on to `kart table-import` - for instance, --replace-existing or --message.
+
+    eg: kart load-fgdb roads.gdb Roads_2023 transport/roads
+    """
+    from kart.tabular.import_ import table_import
+
+    gdb_path = gdb_path.rstrip("/\\")
+    subctx = table_import.make_context(
+        table_import.name,
+        [f"FGDB:{gdb_path}", f"{layer}:{ds_path}", *import_args],
+    )
+    subctx.obj = ctx.obj
+    subctx.forward(table_import)
@@ -0,0 +1,70 @@
+import click
+
+from kart.cli_util import KartCommand
+from kart.completion_shared import file_path_completer
+
+ANY_FORMAT = "any"
+
+
+class OgrDriverType(click.ParamType):
+    """Click parameter for the short name of an OGR vector driver, eg "GML" - or "any"."""
+
+    name = "ogr-driver"
+
+    def convert(self, value, param, ctx):
+        from osgeo import gdal
+
+        if value.lower() == ANY_FORMAT:
+            return None
+        driver = gdal.GetDriverByName(value)
+        if driver is None or driver.GetMetadataItem(gdal.DCAP_VECTOR) != "YES":
+            self.fail(
+                f"{value!r} isn't the name of an OGR vector driver - see https://gdal.org/drivers/vector/",
+                param,
+                ctx,
+            )
+        return driver.ShortName
+
+
+@click.command(
+    "load-ogr",
+    cls=KartCommand,
+    context_settings=dict(ignore_unknown_options=True),
+    writes_to_repo=True,
+)
+@click.pass_context
+@click.option(
+    "--format",
+    "ogr_driver",
+    type=OgrDriverType(),
+    default=ANY_FORMAT,
+    show_default=True,
+    help="The OGR driver to read the source with, eg GML or GeoJSON. By default, OGR chooses the driver.",
+)
+@click.argument("source", shell_complete=file_path_completer)
+@click.argument("layer")
+@click.argument("ds_path", metavar="DATASET")
+@click.argument("import_args", nargs=-1, type=click.UNPROCESSED)
+def load_ogr(ctx, ogr_driver, source, layer, ds_path, import_args):
+    """
+    Import a layer from any source that GDAL/OGR can read as a new dataset - including formats that `kart import`
+    doesn't support directly, such as GML, GeoJSON, KML, MapInfo or DXF. The source is read in-process by the
+    GDAL that is bundled with Kart, so the layer's field types and CRS are kept.
+
+    SOURCE is a file path or any other OGR connection string. LAYER is the name of the layer within it - for
+    single-layer formats, this is usually the filename without its extension.
+
+    Any other options are passed on to `kart table-import` - for instance, --primary-key or --message.
+    Formats other than those supported by `kart import` aren't tested, and might not import perfectly.
+
+    eg: kart load-ogr --format GML parcels.gml parcels cadastral/parcels
+    """
+    from kart.tabular.import_ import table_import
+
+    driver_args = ["--ogr-driver", ogr_driver] if ogr_driver else []
+    subctx = table_import.make_context(
+        table_import.name,
+        [f"OGR:{source}", f"{layer}:{ds_path}", *driver_args, *import_args],
+    )
+    subctx.obj = ctx.obj
+    subctx.forward(table_import)
@@ -0,0 +1,450 @@
+import concurrent.futures
+import itertools
+import logging
+import queue
+import threading
+from collections import namedtuple
+from pathlib import Path
+import tempfile
+import zipfile
+
+from osgeo import ogr
+
+from kart.crs_util import make_crs
+from kart.exceptions import InvalidOperation, NotYetImplemented
+
+L = logging.getLogger("kart.tabular.ogr_export")
+
+
+OGR_GEOMETRY_TYPES = {
+    "GEOMETRY": ogr.wkbUnknown,
+    "POINT": ogr.wkbPoint,
+    "LINESTRING": ogr.wkbLineString,
+    "POLYGON": ogr.wkbPolygon,
+    "MULTIPOINT": ogr.wkbMultiPoint,
+    "MULTILINESTRING": ogr.wkbMultiLineString,
+    "MULTIPOLYGON": ogr.wkbMultiPolygon,
+    "GEOMETRYCOLLECTION": ogr.wkbGeometryCollection,
+    "CIRCULARSTRING": ogr.wkbCircularString,
+    "COMPOUNDCURVE": ogr.wkbCompoundCurve,
+    "CURVEPOLYGON": ogr.wkbCurvePolygon,
+    "MULTICURVE": ogr.wkbMultiCurve,
+    "MULTISURFACE": ogr.wkbMultiSurface,
+}
+
+
+def ogr_geometry_type(geometry_type_name):
+    """Converts a Kart geometryType such as "MULTIPOLYGON ZM" to the equivalent OGR geometry type."""
+    parts = (geometry_type_name or "GEOMETRY").upper().split()
+    result = OGR_GEOMETRY_TYPES.get(parts[0], ogr.wkbUnknown)
+    suffix = parts[1] if len(parts) > 1 else ""
+    if "Z" in suffix:
+        result = ogr.GT_SetZ(result)
+    if "M" in suffix:
+        result = ogr.GT_SetM(result)
+    return result
+
+
+def ogr_field_defn(column):
+    """Returns an ogr.FieldDefn for the given (non-geometry) Kart ColumnSchema."""
+    data_type = column.data_type
+    size = column.get("size")
+    field_defn = ogr.FieldDefn(column.name)
+    if data_type == "boolean":
+        field_defn.SetType(ogr.OFTInteger)
+        field_defn.SetSubType(ogr.OFSTBoolean)
+    elif data_type == "blob":
+        field_defn.SetType(ogr.OFTBinary)
+    elif data_type == "date":
+        field_defn.SetType(ogr.OFTDate)
+    elif data_type == "float":
+        field_defn.SetType(ogr.OFTReal)
+        if size == 32:
+            field_defn.SetSubType(ogr.OFSTFloat32)
+    elif data_type == "integer":
+        if size == 64:
+            field_defn.SetType(ogr.OFTInteger64)
+        else:
+            field_defn.SetType(ogr.OFTInteger)
+            if size == 16:
+                field_defn.SetSubType(ogr.OFSTInt16)
+    elif data_type == "numeric":
+        field_defn.SetType(ogr.OFTReal)
+        if column.get("precision"):
+            field_defn.SetWidth(column["precision"])
+        if column.get("scale"):
+            field_defn.SetPrecision(column["scale"])
+    elif data_type == "text":
+        field_defn.SetType(ogr.OFTString)
+        if column.get("length"):
+            field_defn.SetWidth(column["length"])
+    elif data_type == "time":
+        field_defn.SetType(ogr.OFTTime)
+    elif data_type == "timestamp":
+        field_defn.SetType(ogr.OFTDateTime)
+    else:
+        # interval, and anything else OGR has no equivalent type for.
+        field_defn.SetType(ogr.OFTString)
+    return field_defn
+
+
+# How many features are written in each transaction.
+DEFAULT_BATCH_SIZE = 10000
+
+
+def _batches(iterable, batch_size):
+    iterator = iter(iterable)
+    while True:
+        batch = list(itertools.islice(iterator, batch_size))
+        if not batch:
+            return
+        yield batch
+
+
+# A style for a layer, as stored in the layer_styles table that QGIS reads. Either of qml or sld may be None.
+LayerStyle = namedtuple(
+    "LayerStyle",
+    ("layer_name", "geometry_column", "style_name", "qml", "sld", "use_as_default"),
+)
+
+
+class OgrTableExporter:
+    """
+    Writes table datasets to a new file in any format that OGR can write - one layer per dataset.
+    Subclasses can customise how features are written for particular formats.
+
+    Usage:
+    with OgrTableExporter(path, "GPKG") as exporter:
+        exporter.write_dataset(dataset)
+    """
+
+    def __init__(
+        self,
+        path,
+        driver_name,
+        *,
+        dataset_options=(),
+        layer_options=(),
+        fid_layer_option=None,
+        num_workers=None,
+        batch_size=None,
+    ):
+        """
+        path - the path of the file to create. It mustn't already exist.
+        driver_name - the name of the OGR driver to use eg "GPKG"
+        dataset_options, layer_options - OGR creation options, eg ["SPATIALITE=YES"]
+        fid_layer_option - the name of the layer creation option that sets the name of the FID column, if the driver
+            has one. If set, datasets with a single integer primary key use it as the FID.
+        num_workers - how many threads convert features to OGR features, while they are written by the calling
+            thread. If None or 1, features are read, converted and written by the calling thread.
+        batch_size - how many features are written in each transaction.
+        """
+        self.path = path
+        self.driver_name = driver_name
+        self.dataset_options = list(dataset_options)
+        self.layer_options = list(layer_options)
+        self.fid_layer_option = fid_layer_option
+        self.num_workers = max(1, num_workers or 1)
+        self.batch_size = max(1, batch_size or DEFAULT_BATCH_SIZE)
+        self.ogr_ds = None
+
+    def __enter__(self):
+        driver = ogr.GetDriverByName(self.driver_name)
+        if driver is None:
+            raise NotYetImplemented(
+                f"This build of Kart can't write {self.driver_name} files"
+            )
+        try:
+            self.ogr_ds = driver.CreateDataSource(
+                str(self.path), options=self.dataset_options
+            )
+        except RuntimeError as e:
+            raise InvalidOperation(f"Couldn't create {self.path}: {e}")
+        return self
+
+    def __exit__(self, *args):
+        if self.ogr_ds is not None:
+            self.ogr_ds.FlushCache()
+            # OGR only finishes writing the file once the datasource is dereferenced.
+            self.ogr_ds = None
+
+    def _fid_column(self, dataset):
+        if not self.fid_layer_option:
+            return None
+        pk_columns = dataset.schema.pk_columns
+        if len(pk_columns) == 1 and pk_columns[0].data_type == "integer":
+            return pk_columns[0]
+        return None
+
+    def _srs(self, dataset, geom_column):
+        crs_identifier = geom_column.get("geometryCRS")
+        if not crs_identifier:
+            return None
+        try:
+            return make_crs(dataset.get_crs_definition(crs_identifier))
+        except KeyError:
+            return None
+
+    def create_layer(self, dataset, layer_name):
+        schema = dataset.schema
+        geom_columns = schema.geometry_columns
+        layer_options = list(self.layer_options)
+        fid_column = self._fid_column(dataset)
+        if fid_column is not None:
+            layer_options.append(f"{self.fid_layer_option}={fid_column.name}")
+        if geom_columns:
+            layer_options.append(f"GEOMETRY_NAME={geom_columns[0].name}")
+
+        first_geom = geom_columns[0] if geom_columns else None
+        layer = self.ogr_ds.CreateLayer(
+            layer_name,
+            srs=self._srs(dataset, first_geom) if first_geom else None,
+            geom_type=(
+                ogr_geometry_type(first_geom.get("geometryType"))
+                if first_geom
+                else ogr.wkbNone
+            ),
+            options=self.filter_layer_options(layer_options),
+        )
+        for geom_column in geom_columns[1:]:
+            geom_field_defn = ogr.GeomFieldDefn(
+                geom_column.name, ogr_geometry_type(geom_column.get("geometryType"))
+            )
+            srs = self._srs(dataset, geom_column)
+            if srs is not None:
+                geom_field_defn.SetSpatialRef(srs)
+            layer.CreateGeomField(geom_field_defn)
+
+        for column in schema.columns:
+            if column.data_type == "geometry" or column == fid_column:
+                continue
+            layer.CreateField(ogr_field_defn(column))
+        return layer
+
+    def filter_layer_options(self, layer_options):
+        """Removes any layer creation options that the driver doesn't understand."""
+        driver = self.ogr_ds.GetDriver()
+        supported = driver.GetMetadataItem("DS_LAYER_CREATIONOPTIONLIST") or ""
+        return [o for o in layer_options if f'name="{o.split("=")[0]}"' in supported]
+
+    def write_dataset(self, dataset, layer_name=None, features=None):
+        """
+        Writes the given dataset as a new layer. By default, the layer is named after the dataset, and all of its
+        features are written - or only the given features if features is not None. Returns the number of features
+        written.
+        """
+        if layer_name is None:
+            layer_name = dataset.dataset_path_to_table_name(dataset.path)
+        layer = self.create_layer(dataset, layer_name)
+        layer_defn = layer.GetLayerDefn()
+        fid_column = self._fid_column(dataset)
+
+        if features is None:
+            features = dataset.features()
+
+        def convert_batch(batch):
+            return [
+                self.kart_feature_to_ogr_feature(
+                    dataset, feature, layer_defn, fid_column
+                )
+                for feature in batch
+            ]
+
+        count = 0
+        for ogr_features in self._ogr_feature_batches(features, convert_batch):
+            layer.StartTransaction()
+            for ogr_feature in ogr_features:
+                layer.CreateFeature(ogr_feature)
+            layer.CommitTransaction()
+            count += len(ogr_features)
+        L.info("Wrote %s features to %s", count, layer_name)
+        return count
+
+    def _ogr_feature_batches(self, features, convert_batch):
+        """
+        Yields the given features as lists of OGR features, converted by convert_batch, in their original order.
+        If num_workers is more than 1, the features are read by a background thread and converted by a pool of
+        workers, so that reading and converting features overlaps with the calling thread writing them.
+        """
+        if self.num_workers == 1:
+            for batch in _batches(features, self.batch_size):
+                yield convert_batch(batch)
+            return
+
+        # Futures are queued in order, so that batches are written in order - the bound on the queue stops the
+        # reader from getting too far ahead of the writer.
+        pending = queue.Queue(maxsize=self.num_workers * 2)
+        stop = threading.Event()
+        end = concurrent.futures.Future()
+        end.set_result(None)
+
+        def put(future):
+            while not stop.is_set():
+                try:
+                    pending.put(future, timeout=0.1)
+                    return
+                except queue.Full:
+                    pass
+
+        def read(executor):
+            try:
+                for batch in _batches(features, self.batch_size):
+                    if stop.is_set():
+                        return
+                    put(executor.submit(convert_batch, batch))
+            except Exception as e:
+                failed = concurrent.futures.Future()
+                failed.set_exception(e)
+                put(failed)
+            put(end)
+
+        with concurrent.futures.ThreadPoolExecutor(
+            max_workers=self.num_workers
+        ) as executor:
+            reader = threading.Thread(target=read, args=(executor,), daemon=True)
+            reader.start()
+            try:
+                while True:
+                    future = pending.get()
+                    if future is end:
+                        break
+                    yield future.result()
+            finally:
+                stop.set()
+                reader.join()
+
+    def write_layer_styles(self, styles):
+        """
+        Writes the given LayerStyles to a new layer_styles table, which is where QGIS looks for the styles of the
+        layers in a GPKG.
+        """
+        layer = self.ogr_ds.CreateLayer(
+            "layer_styles", geom_type=ogr.wkbNone, options=["FID=id"]
+        )
+        for name in (
+            "f_table_name",
+            "f_geometry_column",
+            "styleName",
+            "styleQML",
+            "styleSLD",
+        ):
+            layer.CreateField(ogr.FieldDefn(name, ogr.OFTString))
+        use_as_default = ogr.FieldDefn("useAsDefault", ogr.OFTInteger)
+        use_as_default.SetSubType(ogr.OFSTBoolean)
+        layer.CreateField(use_as_default)
+
+        layer_defn = layer.GetLayerDefn()
+        for style in styles:
+            ogr_feature = ogr.Feature(layer_defn)
+            ogr_feature.SetField("f_table_name", style.layer_name)
+            ogr_feature.SetField("f_geometry_column", style.geometry_column)
+            ogr_feature.SetField("styleName", style.style_name)
+            for field, value in (("styleQML", style.qml), ("styleSLD", style.sld)):
+                if value is None:
+                    ogr_feature.SetFieldNull(field)
+                else:
+                    ogr_feature.SetField(field, value)
+            ogr_feature.SetField("useAsDefault", int(style.use_as_default))
+            layer.CreateFeature(ogr_feature)
+
+    def kart_feature_to_ogr_feature(self, dataset, feature, layer_defn, fid_column):
+        ogr_feature = ogr.Feature(layer_defn)
+        geom_index = 0
+        for column in dataset.schema.columns:
+            value = feature.get(column.name)
+            if column == fid_column:
+                ogr_feature.SetFID(value)
+            elif column.data_type == "geometry":
+                if value is not None:
+                    ogr_feature.SetGeomField(geom_index, value.to_ogr())
+                geom_index += 1
+            elif value is None:
+                ogr_feature.SetFieldNull(column.name)
+            elif column.data_type == "blob":
+                ogr_feature.SetFieldBinaryFromHexString(column.name, value.hex())
+            elif column.data_type == "boolean":
+                ogr_feature.SetField(column.name, int(value))
+            elif column.data_type == "numeric":
+                ogr_feature.SetField(column.name, float(value))
+            else:
+                ogr_feature.SetField(column.name, value)
+        return ogr_feature
+
+
+def ogr_style_string(style):
+    """
+    Converts a style from a Kart style config - eg {"color": "#ff0000", "width": 2, "fill": "#ff000080"} - to an
+    OGR feature style string, which drivers such as KML translate into their own styling.
+    """
+    parts = []
+    if "color" in style or "width" in style:
+        pen = []
+        if "color" in style:
+            pen.append(f"c:{style['color']}")
+        if "width" in style:
+            pen.append(f"w:{style['width']}px")
+        parts.append(f"PEN({','.join(pen)})")
+    if "fill" in style:
+        parts.append(f"BRUSH(fc:{style['fill']})")
+    if "icon" in style or "color" in style:
+        symbol = [f'id:"{style["icon"]}"'] if "icon" in style else []
+        if "color" in style:
+            symbol.append(f"c:{style['color']}")
+        parts.append(f"SYMBOL({','.join(symbol)})")
+    return ";".join(parts) or None
+
+
+class KmlTableExporter(OgrTableExporter):
+    """
+    Writes table datasets to KML or KMZ - each dataset becomes a Folder of Placemarks. KML is always in WGS84, so
+    geometries are reprojected as they are written.
+    """
+
+    def __init__(
+        self, path, driver_name="KML", *, name_field=None, styles=None, **kwargs
+    ):
+        """
+        name_field - the column to use as the name of each Placemark.
+        styles - a dict of {dataset_path: style}. The "*" style is used for any dataset that isn't listed.
+        If path ends in .kmz, the KML is written as doc.kml inside a zip file.
+        """
+        super().__init__(path, driver_name, **kwargs)
+        self.name_field = name_field
+        self.styles = styles or {}
+        self.is_kmz = str(path).lower().endswith(".kmz")
+        if name_field:
+            self.dataset_options.append(f"NameField={name_field}")
+        self._style_string = None
+
+    def __enter__(self):
+        if self.is_kmz:
+            self.kmz_path = self.path
+            self.tmp_dir = tempfile.TemporaryDirectory()
+            self.path = Path(self.tmp_dir.name) / "doc.kml"
+        return super().__enter__()
+
+    def __exit__(self, exc_type, *args):
+        super().__exit__(exc_type, *args)
+        if self.is_kmz:
+            try:
+                if exc_type is None:
+                    with zipfile.ZipFile(
+                        self.kmz_path, "w", zipfile.ZIP_DEFLATED
+                    ) as kmz:
+                        kmz.write(self.path, "doc.kml")
+            finally:
+                self.path = self.kmz_path
+                self.tmp_dir.cleanup()
+
+    def write_dataset(self, dataset, layer_name=None, features=None):
+        style = self.styles.get(dataset.path, self.styles.get("*"))
+        self._style_string = ogr_style_string(style) if style else None
+        return super().write_dataset(dataset, layer_name=layer_name, features=features)
+
+    def kart_feature_to_ogr_feature(self, dataset, feature, layer_defn, fid_column):
+        ogr_feature = super().kart_feature_to_ogr_feature(
+            dataset, feature, layer_defn, fid_column
+        )
+        if self._style_string:
+            ogr_feature.SetStyleString(self._style_string)
+        return ogr_feature
@@ -10,6 +10,7 @@ from osgeo import gdal, ogr
 
 from kart import crs_util, ogr_util
 from kart.exceptions import (
+    INVALID_FILE_FORMAT,
     NO_IMPORT_SOURCE,
     NO_TABLE,
     InvalidOperation,
@@ -19,9 +20,11 @@ from kart.exceptions import (
 from kart.geometry import ogr_to_gpkg_geom
 from kart.output_util import dump_json_output
 from kart.schema import ColumnSchema, Schema
+from kart.serialise_util import ensure_bytes
 from kart.utils import chunk, ungenerator
 
 from .import_source import TableImportSource
+from .source_encoding import DETECTION_SAMPLE_SIZE, detect_encoding
 
 # This defines what formats are allowed, as well as mapping
 # Kart prefixes onto an OGR format shortname.
@@ -31,6 +34,8 @@ FORMAT_TO_OGR_MAP = {
     # https://github.com/koordinates/kart/issues/86
     # 'TAB': 'MapInfo File',
     "PG": "PostgreSQL",
+    "OSM": "OSM",
+    "FGDB": "OpenFileGDB",
 }
 # The set of format prefixes where a local path is expected
 # (as opposed to a URL / something else)
@@ -61,6 +66,10 @@ class OgrTableImportSource(TableImportSource):
 
     DEFAULT_GEOMETRY_COLUMN_NAME = "geom"
 
+    # Open options that stop the driver from converting text to UTF-8 itself, for when the source encoding is
+    # specified by the user - so that the text can be read as raw bytes and decoded from that encoding instead.
+    RAW_TEXT_OPEN_OPTIONS = ()
+
     @classmethod
     def _all_subclasses(cls):
         for sub in cls.__subclasses__():
@@ -120,9 +129,23 @@ class OgrTableImportSource(TableImportSource):
         )
 
     @classmethod
-    def open(cls, source, table=None, primary_key=None):
+    def open(
+        cls,
+        source,
+        table=None,
+        primary_key=None,
+        source_encoding=None,
+        ogr_driver=None,
+    ):
+        """
+        ogr_driver - if set, the source is only opened using this OGR driver (eg "GML"), regardless of whether it is
+            one of the whitelisted formats.
+        """
         ogr_source, allowed_formats = cls.adapt_source_for_ogr(source)
-        if allowed_formats is None:
+        if ogr_driver is not None:
+            allowed_formats = [ogr_driver]
+            open_kwargs = {"allowed_drivers": [ogr_driver]}
+        elif allowed_formats is None:
             # let OGR use any driver it's been compiled with.
             open_kwargs = {}
         else:
@@ -155,10 +178,17 @@ class OgrTableImportSource(TableImportSource):
             klass = cls
         else:
             # Reopen ds to give subclasses a chance to specify open options.
+            if source_encoding is not None and klass.RAW_TEXT_OPEN_OPTIONS:
+                open_kwargs["open_options"] = list(klass.RAW_TEXT_OPEN_OPTIONS)
             ds = klass._ogr_open(ogr_source, **open_kwargs)
 
         return klass(
-            ds, table, source=source, ogr_source=ogr_source, primary_key=primary_key
+            ds,
+            table,
+            source=source,
+            ogr_source=ogr_source,
+            primary_key=primary_key,
+            source_encoding=source_encoding,
         )
 
     @classmethod
@@ -192,7 +222,12 @@ class OgrTableImportSource(TableImportSource):
         dest_path=None,
         primary_key=None,
         meta_overrides=None,
+        source_encoding=None,
     ):
+        """
+        source_encoding - if set, text fields are read as bytes and decoded from this encoding, rather than trusting
+            OGR to decode them. Use "auto" to guess the encoding with detect_source_encoding().
+        """
         self.ds = ogr_ds
         self.driver = self.ds.GetDriver()
         self.table = table
@@ -204,6 +239,7 @@ class OgrTableImportSource(TableImportSource):
         self.meta_overrides = {
             k: v for k, v in (meta_overrides or {}).items() if v is not None
         }
+        self.source_encoding = source_encoding
 
     def default_dest_path(self):
         return self._normalise_dataset_path(self.table)
@@ -241,6 +277,7 @@ class OgrTableImportSource(TableImportSource):
             ogr_source=self.ogr_source,
             primary_key=primary_key or self._primary_key,
             meta_overrides=meta_overrides,
+            source_encoding=self.source_encoding,
         )
 
     @property
@@ -360,6 +397,38 @@ class OgrTableImportSource(TableImportSource):
     def _get_type_value_adapter(self, name, v2_type):
         return ogr_util.get_type_value_adapter(v2_type)
 
+    @property
+    @functools.lru_cache(maxsize=1)
+    def text_column_names(self):
+        return set(c.name for c in self.schema if c.data_type == "text")
+
+    def _raw_text_values(self, ogr_feature):
+        for name in self.text_column_names:
+            if ogr_feature.IsFieldSetAndNotNull(name):
+                yield ogr_feature.GetFieldAsBinary(name)
+
+    def _decode_text_field(self, ogr_feature, name):
+        if not ogr_feature.IsFieldSetAndNotNull(name):
+            return None
+        data = ogr_feature.GetFieldAsBinary(name)
+        try:
+            return data.decode(self.source_encoding)
+        except UnicodeDecodeError as e:
+            raise InvalidOperation(
+                f"Couldn't decode the text in {self} as {self.source_encoding} - specify a different --source-encoding\n{e}",
+                exit_code=INVALID_FILE_FORMAT,
+            )
+
+    def detect_source_encoding(self):
+        """Guesses the encoding of the source's text fields from a sample of its features. See detect_encoding."""
+        samples = []
+        for i, ogr_feature in enumerate(self._iter_ogr_features()):
+            if i >= DETECTION_SAMPLE_SIZE:
+                break
+            samples.extend(self._raw_text_values(ogr_feature))
+        self.ogrlayer.ResetReading()
+        return detect_encoding(samples)
+
     @ungenerator(dict)
     def _ogr_feature_to_kart_feature(self, ogr_feature):
         for name, adapter in self.field_adapter_map.items():
@@ -367,6 +436,8 @@ class OgrTableImportSource(TableImportSource):
                 value = ogr_feature.GetFID()
             elif name in self.geometry_column_names:
                 value = ogr_feature.GetGeometryRef()
+            elif self.source_encoding and name in self.text_column_names:
+                value = self._decode_text_field(ogr_feature, name)
             else:
                 value = ogr_feature.GetField(name)
             yield name, adapter(value)
@@ -619,6 +690,9 @@ class OgrTableImportSource(TableImportSource):
 
 
 class ESRIShapefileImportSource(OgrTableImportSource):
+    # Don't recode from the encoding declared in the .cpg file or DBF header.
+    RAW_TEXT_OPEN_OPTIONS = ("ENCODING=",)
+
     def __init__(self, *args, **kwargs):
         super().__init__(*args, **kwargs)
         self.force_promote_geom_columns = {}
@@ -663,6 +737,204 @@ class ESRIShapefileImportSource(OgrTableImportSource):
         return super()._get_type_value_adapter(name, v2_type)
 
 
+class OpenFileGDBImportSource(OgrTableImportSource):
+    """
+    Imports from an ESRI File Geodatabase (.gdb), using OGR's OpenFileGDB driver - which reads the geodatabase
+    directly, without ESRI's FileGDB SDK.
+
+    Every feature class in a File Geodatabase has an OBJECTID, which is assigned when a feature is created and
+    doesn't change when it is edited - so unlike the FID of most other OGR formats, it is used as the primary key
+    unless a different one is specified.
+    """
+
+    @property
+    @functools.lru_cache(maxsize=1)
+    def primary_key(self):
+        if self._primary_key is None and self.table is not None:
+            fid_column = self.ogrlayer.GetFIDColumn()
+            if fid_column:
+                self.use_ogc_fid_as_pk = True
+                return fid_column
+        return self._primary_key
+
+    def attachments(self):
+        # ArcGIS stores the metadata of each feature class alongside it, as an XML document.
+        metadata_xml = self.ogrlayer.GetMetadata_List("xml:documentation")
+        if metadata_xml:
+            yield "metadata.xml", ensure_bytes(metadata_xml[0])
+
+
+class OSMImportSource(OgrTableImportSource):
+    """
+    Imports from an OpenStreetMap extract (.osm.pbf or .osm), using OGR's OSM driver. The driver splits the extract
+    into the layers points, lines, multilinestrings, multipolygons and other_relations.
+
+    OSM features don't have a PK that is unique across these layers - a multipolygon can come from either a closed
+    way or a relation - so every layer gets a text primary key osm_id in OSM's own style, eg "node/123", "way/456"
+    or "relation/789". This stays the same from one extract to the next, so re-importing a newer extract with
+    --replace-existing only records the features that actually changed.
+
+    By default, each layer has the columns chosen by OGR's default OSM config, including other_tags. A tag mapping
+    can instead specify - per layer - which tags become which columns, and which tags a feature must have to be
+    imported at all.
+    """
+
+    PK_COLUMN = "osm_id"
+    # OGR fields that are used to build the PK, rather than being imported as columns.
+    OSM_ID_FIELDS = ("osm_id", "osm_way_id")
+    OTHER_TAGS_FIELD = "other_tags"
+    # The OSM element type that the features in each layer are derived from.
+    LAYER_ELEMENT_TYPES = {
+        "points": "node",
+        "lines": "way",
+        "multilinestrings": "relation",
+        "other_relations": "relation",
+    }
+    # other_tags is written by OGR in hstore syntax: "key"=>"value","key2"=>"value2"
+    HSTORE_ITEM_RE = re.compile(r'"((?:[^"\\]|\\.)*)"=>"((?:[^"\\]|\\.)*)"')
+
+    def __init__(self, *args, tag_mapping=None, **kwargs):
+        super().__init__(*args, **kwargs)
+        self.tag_mapping = tag_mapping or {}
+
+    def clone_for_table(self, table, **kwargs):
+        result = super().clone_for_table(table, **kwargs)
+        result.tag_mapping = self.tag_mapping
+        return result
+
+    def check_tag_mapping(self, param_hint=None):
+        unknown = sorted(set(self.tag_mapping) - set(self.get_tables()))
+        if unknown:
+            raise click.BadParameter(
+                f"No such OSM layer: {', '.join(unknown)} - expected one of {', '.join(self.get_tables())}",
+                param_hint=param_hint,
+            )
+        reserved = {self.PK_COLUMN, self.DEFAULT_GEOMETRY_COLUMN_NAME}
+        for layer, mapping in self.tag_mapping.items():
+            columns = mapping.get("columns", {})
+            clashes = sorted(reserved & set(columns))
+            if clashes:
+                raise click.BadParameter(
+                    f"Can't map tags to column {', '.join(clashes)} in layer {layer} - that column name is reserved",
+                    param_hint=param_hint,
+                )
+
+    @property
+    def layer_mapping(self):
+        """The tag mapping for the current layer, as {"columns": {column: tag}, "require": [tags]}, or None."""
+        mapping = self.tag_mapping.get(self.table)
+        if mapping is None:
+            return None
+        columns = mapping.get("columns", {})
+        if isinstance(columns, list):
+            columns = {tag: tag for tag in columns}
+        return {"columns": columns, "require": mapping.get("require", [])}
+
+    def _check_primary_key_option(self, primary_key_name):
+        self.use_ogc_fid_as_pk = False
+        if primary_key_name not in (None, self.PK_COLUMN):
+            raise InvalidOperation(
+                f"OSM layers always use {self.PK_COLUMN} as their primary key",
+                param_hint="--primary-key",
+            )
+        return self.PK_COLUMN
+
+    @property
+    def pk_column_schema(self):
+        return ColumnSchema(
+            id=ColumnSchema.new_id(),
+            name=self.PK_COLUMN,
+            data_type="text",
+            pk_index=0,
+        )
+
+    @property
+    def regular_columns_schema(self):
+        mapping = self.layer_mapping
+        if mapping is not None:
+            return [
+                ColumnSchema(id=ColumnSchema.new_id(), name=column, data_type="text")
+                for column in mapping["columns"]
+            ]
+        ld = self.layer_defn
+        return [
+            self._field_to_v2_column_schema(ld.GetFieldDefn(i))
+            for i in range(ld.GetFieldCount())
+            if ld.GetFieldDefn(i).GetName() not in self.OSM_ID_FIELDS
+        ]
+
+    @property
+    @functools.lru_cache(maxsize=1)
+    def feature_count(self):
+        mapping = self.layer_mapping
+        if mapping and mapping["require"]:
+            return sum(1 for _ in self._iter_mapped_ogr_features())
+        # The OSM driver can't count features without reading the whole layer.
+        return self.ogrlayer.GetFeatureCount(force=True)
+
+    def _osm_id(self, ogr_feature):
+        if self.table == "multipolygons":
+            way_id = ogr_feature.GetField("osm_way_id")
+            if way_id:
+                return f"way/{way_id}"
+            return f"relation/{ogr_feature.GetField('osm_id')}"
+        element_type = self.LAYER_ELEMENT_TYPES.get(self.table, "node")
+        return f"{element_type}/{ogr_feature.GetField('osm_id')}"
+
+    def _get_tags(self, ogr_feature):
+        tags = {}
+        ld = self.layer_defn
+        for i in range(ld.GetFieldCount()):
+            name = ld.GetFieldDefn(i).GetName()
+            if name in self.OSM_ID_FIELDS or not ogr_feature.IsFieldSetAndNotNull(i):
+                continue
+            if name == self.OTHER_TAGS_FIELD:
+                for key, value in self.HSTORE_ITEM_RE.findall(ogr_feature.GetField(i)):
+                    tags[_hstore_unescape(key)] = _hstore_unescape(value)
+            else:
+                tags[name] = str(ogr_feature.GetField(i))
+        return tags
+
+    def _iter_mapped_ogr_features(self):
+        required = self.layer_mapping["require"] if self.layer_mapping else []
+        for ogr_feature in self._iter_ogr_features():
+            if required:
+                tags = self._get_tags(ogr_feature)
+                if not all(tag in tags for tag in required):
+                    continue
+            yield ogr_feature
+
+    @ungenerator(dict)
+    def _ogr_feature_to_kart_feature(self, ogr_feature):
+        mapping = self.layer_mapping
+        tags = self._get_tags(ogr_feature) if mapping is not None else None
+        for name, adapter in self.field_adapter_map.items():
+            if name == self.PK_COLUMN:
+                value = self._osm_id(ogr_feature)
+            elif name in self.geometry_column_names:
+                value = ogr_feature.GetGeometryRef()
+            elif mapping is not None:
+                value = tags.get(mapping["columns"][name])
+            else:
+                value = ogr_feature.GetField(name)
+            yield name, adapter(value)
+
+    def features(self):
+        for ogr_feature in self._iter_mapped_ogr_features():
+            yield self._ogr_feature_to_kart_feature(ogr_feature)
+
+    def get_features(self, row_pks, *, ignore_missing=False):
+        # OSM IDs aren't stored in the same form in the source, so they can't be filtered using SQL.
+        wanted = set(self._first_pk_values(row_pks))
+        for feature in self.features():
+            if feature[self.PK_COLUMN] in wanted:
+                yield feature
+
+
+def _hstore_unescape(value):
+    return re.sub(r"\\(.)", r"\1", value)
+
+
 def adapt_ogr_force_multilinestring(value):
     if value is None:
         return value
@@ -0,0 +1,121 @@
+import hashlib
+import re
+
+import click
+
+from kart.schema import ColumnSchema, Schema
+
+# A redaction rule for a column is one of these, or truncate:N to keep only the first N characters of text.
+EXCLUDE = "exclude"
+NULL = "null"
+HASH = "hash"
+TRUNCATE_PATTERN = re.compile(r"^truncate:(?P<length>[0-9]+)$")
+
+RULE_PATTERN = f"^({EXCLUDE}|{NULL}|{HASH}|truncate:[0-9]+)$"
+REDACTION_SCHEMA = {
+    "type": "object",
+    "$schema": "http://json-schema.org/draft-07/schema",
+    "patternProperties": {
+        ".*": {
+            "type": "object",
+            "patternProperties": {".*": {"type": "string", "pattern": RULE_PATTERN}},
+        }
+    },
+}
+
+# The key in the redaction config for rules that apply to every dataset.
+ALL_DATASETS = "*"
+
+
+def build_redaction_rules(exclude_columns=(), redaction_config=None):
+    """
+    Combines --exclude-columns and a redaction config into a
+    {dataset-path-or-*: {column-name: rule}} dict. Rules for a specific dataset take precedence over
+    rules for all datasets.
+    """
+    rules = {k: dict(v) for k, v in (redaction_config or {}).items()}
+    for column_name in exclude_columns:
+        rules.setdefault(ALL_DATASETS, {})[column_name] = EXCLUDE
+    return rules
+
+
+def rules_for_dataset(rules, ds_path):
+    result = dict(rules.get(ALL_DATASETS, {}))
+    result.update(rules.get(ds_path, {}))
+    return result
+
+
+def hash_value(value):
+    if isinstance(value, bytes):
+        data = value
+    else:
+        data = str(value).encode("utf-8")
+    return hashlib.sha256(data).hexdigest()
+
+
+class RedactedTableDataset:
+    """
+    Wraps a table dataset so that its schema and features have the given redaction rules applied - columns are
+    excluded, or values are replaced by NULL, a SHA-256 hash, or truncated. Everything else is delegated to the
+    wrapped dataset, so this can be passed to any of the table exporters in place of the dataset itself.
+    """
+
+    def __init__(self, delegate, rules):
+        self.delegate = delegate
+        self.rules = rules
+        columns = []
+        for column in delegate.schema.columns:
+            rule = rules.get(column.name)
+            if rule is None:
+                columns.append(column)
+                continue
+            if column.pk_index is not None:
+                raise click.UsageError(
+                    f"Can't redact {column.name} in {delegate.path} - it's part of the primary key"
+                )
+            if rule == EXCLUDE:
+                continue
+            if rule == HASH:
+                # Hashes are always text, whatever the type of the original value.
+                column = ColumnSchema(
+                    id=column.id, name=column.name, data_type="text"
+                )
+            elif TRUNCATE_PATTERN.match(rule) and column.data_type != "text":
+                raise click.UsageError(
+                    f"Can't truncate {column.name} in {delegate.path} - only text columns can be truncated"
+                )
+            columns.append(column)
+        self.schema = Schema(columns)
+
+    @classmethod
+    def wrap_if_needed(cls, dataset, rules):
+        ds_rules = rules_for_dataset(rules, dataset.path)
+        column_names = {c.name for c in dataset.schema.columns}
+        ds_rules = {k: v for k, v in ds_rules.items() if k in column_names}
+        return cls(dataset, ds_rules) if ds_rules else dataset
+
+    def __getattr__(self, name):
+        return getattr(self.delegate, name)
+
+    def redact_feature(self, feature):
+        result = {}
+        for column in self.schema.columns:
+            value = feature.get(column.name)
+            rule = self.rules.get(column.name)
+            if rule is None or value is None:
+                pass
+            elif rule == NULL:
+                value = None
+            elif rule == HASH:
+                value = hash_value(value)
+            else:
+                value = value[: int(TRUNCATE_PATTERN.match(rule).group("length"))]
+            result[column.name] = value
+        return result
+
+    def features(self, *args, **kwargs):
+        for feature in self.delegate.features(*args, **kwargs):
+            yield self.redact_feature(feature)
+
+    def __str__(self):
+        return str(self.delegate)
@@ -0,0 +1,172 @@
+import re
+
+import click
+
+from kart.cli_util import KartCommand, StringFromFile
+from kart.completion_shared import repo_path_completer
+from kart.core import check_git_user
+from kart.diff_structs import Delta, DeltaDiff
+from kart.exceptions import NO_CHANGES, NotFound
+from kart.tabular.transform import (
+    commit_feature_diff,
+    get_table_dataset,
+    preview_feature_diff,
+)
+
+
+class ValueReplacer:
+    """
+    Replaces text values - either the whole of any value that is exactly equal to from_value, or with regex=True,
+    every part of any value that matches the from_value regular expression, as in re.sub.
+    """
+
+    def __init__(self, from_value, to_value, regex=False, ignore_case=False):
+        self.from_value = from_value
+        self.to_value = to_value
+        self.regex = None
+        if regex:
+            try:
+                self.regex = re.compile(from_value, re.IGNORECASE if ignore_case else 0)
+            except re.error as e:
+                raise click.BadParameter(
+                    f"Invalid regular expression: {e}", param_hint="--from"
+                )
+        elif ignore_case:
+            self.from_value = from_value.casefold()
+        self.ignore_case = ignore_case
+
+    def replace(self, value):
+        if not isinstance(value, str):
+            return value
+        if self.regex is not None:
+            try:
+                return self.regex.sub(self.to_value, value)
+            except re.error as e:
+                raise click.BadParameter(f"Invalid replacement: {e}", param_hint="--to")
+        compare_value = value.casefold() if self.ignore_case else value
+        return self.to_value if compare_value == self.from_value else value
+
+
+def replace_features(dataset, fields, replacer):
+    """Returns a DeltaDiff which updates every feature in the dataset that has a value in the given fields to replace."""
+    columns = {c.name: c for c in dataset.schema.columns}
+    for field in fields:
+        if field not in columns:
+            raise click.BadParameter(
+                f"{dataset.path} has no field called {field}", param_hint="--field"
+            )
+        if columns[field].data_type != "text":
+            raise click.BadParameter(
+                f"Can't replace values of {field} - only text fields are supported",
+                param_hint="--field",
+            )
+        if columns[field].pk_index is not None:
+            raise click.BadParameter(
+                f"Can't replace values of primary key field {field}",
+                param_hint="--field",
+            )
+
+    pk_name = dataset.schema.pk_columns[0].name
+    feature_diff = DeltaDiff()
+    for feature in dataset.features():
+        new_feature = dict(feature)
+        for field in fields:
+            new_feature[field] = replacer.replace(feature[field])
+        if new_feature != feature:
+            pk = feature[pk_name]
+            feature_diff.add_delta(Delta.update((pk, feature), (pk, new_feature)))
+    return feature_diff
+
+
+@click.command("replace", cls=KartCommand, writes_to_repo=True)
+@click.pass_context
+@click.option(
+    "--field",
+    "fields",
+    multiple=True,
+    required=True,
+    help="The text field to replace values in. Can be specified repeatedly.",
+)
+@click.option(
+    "--from",
+    "from_value",
+    required=True,
+    help="The value to replace. Only values that are exactly equal to this are replaced, unless --regex is given.",
+)
+@click.option(
+    "--to",
+    "to_value",
+    required=True,
+    help="The value to replace it with. With --regex, this can refer to groups in the match - eg \\1.",
+)
+@click.option(
+    "--regex",
+    is_flag=True,
+    help="Treat --from as a regular expression, and replace every part of each value that matches it.",
+)
+@click.option(
+    "--ignore-case",
+    "-i",
+    is_flag=True,
+    help="Match --from case-insensitively.",
+)
+@click.option(
+    "--dry-run",
+    is_flag=True,
+    help="Show the diff that would be committed, without committing it.",
+)
+@click.option(
+    "--message",
+    "-m",
+    multiple=True,
+    help=(
+        "Use the given message as the commit message. If multiple `-m` options are given, their values are "
+        "concatenated as separate paragraphs."
+    ),
+    type=StringFromFile(encoding="utf-8"),
+)
+@click.option(
+    "--output-format",
+    "-o",
+    type=click.Choice(["text", "json"]),
+    default="text",
+)
+@click.argument("ds_path", metavar="DATASET", shell_complete=repo_path_completer)
+def replace(
+    ctx,
+    fields,
+    from_value,
+    to_value,
+    regex,
+    ignore_case,
+    dry_run,
+    message,
+    output_format,
+    ds_path,
+):
+    """
+    Find and replace values in the text fields of a dataset's features, and commit the result as a single commit.
+
+    eg: kart replace parcels --field owner --from "ACME Ltd" --to "ACME Limited" -m "Normalise owner names"
+
+    Use --dry-run to review the changes as a diff before committing them.
+    """
+    repo = ctx.obj.repo
+    dataset = get_table_dataset(repo, ds_path, "replace values in")
+    replacer = ValueReplacer(from_value, to_value, regex=regex, ignore_case=ignore_case)
+    feature_diff = replace_features(dataset, fields, replacer)
+
+    if dry_run:
+        if not feature_diff:
+            raise NotFound("No values to replace", exit_code=NO_CHANGES)
+        preview_feature_diff(repo, ds_path, feature_diff, output_format)
+        return
+
+    check_git_user(repo)
+    repo.working_copy.check_not_dirty()
+    draft_message = (
+        f"Replace {from_value!r} with {to_value!r} in {ds_path}: {', '.join(fields)}"
+    )
+    commit_feature_diff(
+        repo, ds_path, feature_diff, message, output_format, draft_message
+    )
@@ -0,0 +1,27 @@
+from kart.exceptions import GeometryError
+from kart.geometry import is_missing_geometry, missing_geometry_description
+
+from .wrapping_import_source import WrappingTableImportSource
+
+
+class RequireGeometryTableImportSource(WrappingTableImportSource):
+    """
+    Wrapper of TableImportSource that checks that every feature has a geometry in every geometry column, and raises
+    a GeometryError as soon as a feature is found with a missing geometry. Both NULL and EMPTY geometries are
+    considered missing - although these are different values and Kart stores them differently, neither gives
+    the feature a location.
+    """
+
+    def transform_feature(self, feature):
+        for col in self.schema.geometry_columns:
+            geom = feature.get(col.name)
+            if is_missing_geometry(geom):
+                pk_desc = ", ".join(
+                    str(feature.get(c.name)) for c in self.schema.pk_columns
+                )
+                feature_desc = f"Feature {pk_desc}" if pk_desc else "A feature"
+                raise GeometryError(
+                    f"{feature_desc} in {self.dest_path} has a {missing_geometry_description(geom)} "
+                    f"geometry in column '{col.name}', but --require-geometry was specified"
+                )
+        return feature
@@ -0,0 +1,61 @@
+import codecs
+import re
+
+import click
+
+# Specifying this as the source encoding means that the encoding is guessed from a sample of the data.
+AUTO_ENCODING = "auto"
+
+# The encodings that are tried, in order, when guessing. Single-byte encodings decode almost any data at all, so they
+# go last - and latin-1 decodes every byte, so it always succeeds.
+CANDIDATE_ENCODINGS = ("utf-8", "shift_jis", "euc_jp", "cp1252", "latin-1")
+MULTIBYTE_ENCODINGS = ("shift_jis", "euc_jp")
+
+# How many features are sampled when guessing.
+DETECTION_SAMPLE_SIZE = 1000
+
+# Latin text that has been decoded with a multi-byte encoding by mistake tends to have stray characters in the
+# middle of words - either a single non-ASCII character surrounded by ASCII letters, or a half-width katakana
+# character next to an ASCII letter.
+_IMPLAUSIBLE_MULTIBYTE_TEXT = re.compile(
+    r"[A-Za-z][^\x00-\x7f][A-Za-z]|[A-Za-z][｡-ﾟ]|[｡-ﾟ][A-Za-z]"
+)
+
+
+class SourceEncodingType(click.ParamType):
+    """The name of a text encoding that Python can decode, or "auto"."""
+
+    name = "encoding"
+
+    def convert(self, value, param, ctx):
+        if value.lower() == AUTO_ENCODING:
+            return AUTO_ENCODING
+        try:
+            return codecs.lookup(value).name
+        except LookupError:
+            self.fail(f"Unknown encoding: {value}", param, ctx)
+
+
+def _is_plausible(encoding, samples):
+    try:
+        text = [s.decode(encoding) for s in samples]
+    except UnicodeDecodeError:
+        return False
+    if encoding not in MULTIBYTE_ENCODINGS:
+        return True
+    return not any(_IMPLAUSIBLE_MULTIBYTE_TEXT.search(t) for t in text)
+
+
+def detect_encoding(samples):
+    """
+    Guesses the encoding of the given samples of text, which are bytes. This is only a guess - the first of
+    CANDIDATE_ENCODINGS that can decode every sample and gives plausible looking text is returned - so the user
+    should be warned about it.
+    """
+    samples = [s for s in samples if any(b >= 0x80 for b in s)]
+    if not samples:
+        # Plain ASCII.
+        return "utf-8"
+    for encoding in CANDIDATE_ENCODINGS:
+        if _is_plausible(encoding, samples):
+            return encoding
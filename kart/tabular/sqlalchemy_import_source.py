@@ -22,7 +22,7 @@ from .import_source import TableImportSource
 class SqlAlchemyTableImportSource(TableImportSource):
     """
     TableImportSource that uses SqlAlchemy directly to import into Kart.
-    Supports GPKG, Postgres (+ PostGIS), SQL Server, MySQL.
+    Supports GPKG, Postgres (+ PostGIS), SQL Server, MySQL, Oracle (+ Oracle Spatial).
     """
 
     CURSOR_SIZE = 10000
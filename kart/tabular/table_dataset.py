@@ -105,6 +105,14 @@ class TableDataset(BaseDataset, TableImportSource):
             return self.schema.pk_columns[0].name
         raise ValueError(f"No single primary key: {self.schema.pk_columns}")
 
+    def pk_value(self, feature):
+        """
+        Returns the primary key of the given feature (or of any dict keyed by column name) as it is shown in JSON
+        output - the value itself, or a list of values if the dataset has more than one primary key column.
+        """
+        pk_values = [feature[c.name] for c in self.schema.pk_columns]
+        return pk_values[0] if len(pk_values) == 1 else pk_values
+
     @property
     @functools.lru_cache(maxsize=1)
     def has_geometry(self):
@@ -0,0 +1,221 @@
+import sys
+
+import click
+
+from kart.cli_util import KartCommand, StringFromFile
+from kart.commit import commit_json_to_text, commit_obj_to_json, get_commit_message
+from kart.completion_shared import repo_path_completer
+from kart.core import check_git_user
+from kart.diff_structs import DatasetDiff, Delta, DeltaDiff, RepoDiff
+from kart.exceptions import (
+    InvalidOperation,
+    NotFound,
+    NO_CHANGES,
+    NO_DATA,
+)
+from kart.lineage import record_lineage
+from kart.output_util import dump_json_output
+from kart.tabular.expressions import (
+    Assignment,
+    Expression,
+    ExpressionError,
+    coerce_value,
+)
+
+
+class ExpressionType(click.ParamType):
+    """Click parameter for an expression - or for an assignment of an expression to a field, if assignment=True."""
+
+    def __init__(self, assignment=False):
+        self.assignment = assignment
+        self.name = "assignment" if assignment else "expression"
+
+    def convert(self, value, param, ctx):
+        try:
+            return Assignment(value) if self.assignment else Expression(value)
+        except ExpressionError as e:
+            self.fail(str(e), param, ctx)
+
+
+def get_table_dataset(repo, ds_path, verb):
+    """Returns the table dataset at the given path at HEAD, or raises an error if there isn't one."""
+    dataset = repo.datasets().get(ds_path)
+    if dataset is None:
+        raise NotFound(f"No dataset found at {ds_path}", exit_code=NO_DATA)
+    if dataset.DATASET_TYPE != "table":
+        raise InvalidOperation(f"Can't {verb} {ds_path} - it isn't a table dataset")
+    return dataset
+
+
+def commit_feature_diff(
+    repo,
+    ds_path,
+    feature_diff,
+    message,
+    output_format,
+    draft_message="",
+    extra_json=None,
+):
+    """
+    Commits the given changes to the features of a dataset, and updates the working copy to match. The commit
+    records that the dataset was derived from the dataset as it was before. message is a tuple of -m values - if it
+    is empty, the user is asked for a message, starting from the draft message. extra_json is added to the JSON
+    output, if any.
+    """
+    if not feature_diff:
+        raise NotFound("No features were changed", exit_code=NO_CHANGES)
+
+    repo_diff = RepoDiff()
+    repo_diff[ds_path] = DatasetDiff([("feature", feature_diff)])
+
+    do_json = output_format == "json"
+    if message:
+        commit_msg = "\n\n".join([m.strip() for m in message]).strip()
+    else:
+        commit_msg = get_commit_message(repo, repo_diff, draft_message, quiet=do_json)
+
+    if not commit_msg:
+        raise click.UsageError("Aborting commit due to empty commit message.")
+
+    previous_commit = repo.head_commit
+    new_commit = repo.structure().commit_diff(repo_diff, commit_msg)
+    record_lineage(
+        repo,
+        new_commit,
+        [ds_path],
+        [{"dataset": ds_path, "commit": previous_commit.id.hex}],
+    )
+    repo.working_copy.reset_to_head()
+
+    jdict = commit_obj_to_json(new_commit, repo, repo_diff)
+    if do_json:
+        jdict.update(extra_json or {})
+        dump_json_output(jdict, sys.stdout)
+    else:
+        click.echo(commit_json_to_text(jdict))
+
+
+def preview_feature_diff(repo, ds_path, feature_diff, output_format):
+    """
+    Writes the diff that committing the given changes to the features of a dataset would make, without committing
+    them. The changes are written to a tree which isn't referenced by anything, so it is cleaned
+    up when the repository is next garbage-collected.
+    """
+    from kart.base_diff_writer import BaseDiffWriter
+
+    repo_diff = RepoDiff()
+    repo_diff[ds_path] = DatasetDiff([("feature", feature_diff)])
+    tree = repo.structure().create_tree_from_diff(repo_diff)
+    diff_writer_class = BaseDiffWriter.get_diff_writer_class(output_format)
+    diff_writer = diff_writer_class(repo, f"HEAD...{tree.id.hex}", [ds_path])
+    diff_writer.write_diff()
+
+
+def transform_features(dataset, assignments, where=None):
+    """
+    Returns a DeltaDiff which updates every feature in the dataset that matches the where expression (or every
+    feature, if there is none) by evaluating each assignment in turn.
+    """
+    columns = {c.name: c for c in dataset.schema.columns}
+    pk_names = {c.name for c in dataset.schema.pk_columns}
+    if len(pk_names) != 1:
+        raise InvalidOperation(
+            f"Can't transform {dataset.path} - only datasets with a single primary key column are supported"
+        )
+    [pk_name] = pk_names
+    for assignment in assignments:
+        try:
+            assignment.expression.check_fields(columns)
+        except ExpressionError as e:
+            raise click.BadParameter(str(e), param_hint="--expr")
+        if assignment.field_name not in columns:
+            raise click.BadParameter(
+                f"{dataset.path} has no field called {assignment.field_name} - transform can't add fields",
+                param_hint="--expr",
+            )
+        if assignment.field_name in pk_names:
+            raise click.BadParameter(
+                f"Can't assign to primary key field {assignment.field_name}",
+                param_hint="--expr",
+            )
+    if where is not None:
+        try:
+            where.check_fields(columns)
+        except ExpressionError as e:
+            raise click.BadParameter(str(e), param_hint="--where")
+
+    feature_diff = DeltaDiff()
+    for feature in dataset.features():
+        pk = feature[pk_name]
+        try:
+            if where is not None and not where.matches(feature):
+                continue
+            new_feature = dict(feature)
+            for assignment in assignments:
+                value = assignment.expression.evaluate(new_feature)
+                column = columns[assignment.field_name]
+                new_feature[assignment.field_name] = coerce_value(value, column, pk)
+        except ExpressionError as e:
+            raise InvalidOperation(f"Can't transform feature {pk}: {e}")
+        if new_feature != feature:
+            feature_diff.add_delta(Delta.update((pk, feature), (pk, new_feature)))
+    return feature_diff
+
+
+@click.command("transform", cls=KartCommand, writes_to_repo=True)
+@click.pass_context
+@click.option(
+    "--expr",
+    "assignments",
+    type=ExpressionType(assignment=True),
+    multiple=True,
+    required=True,
+    help=(
+        'Set a field to the value of an expression, eg --expr "height_m = height_ft * 0.3048". Can be specified '
+        "more than once - each expression is evaluated in turn, and sees the results of the ones before it."
+    ),
+)
+@click.option(
+    "--where",
+    type=ExpressionType(),
+    help='Only transform the features for which this expression is true, eg --where "height_m is None"',
+)
+@click.option(
+    "--message",
+    "-m",
+    multiple=True,
+    help=(
+        "Use the given message as the commit message. If multiple `-m` options are given, their values are "
+        "concatenated as separate paragraphs."
+    ),
+    type=StringFromFile(encoding="utf-8"),
+)
+@click.option(
+    "--output-format",
+    "-o",
+    type=click.Choice(["text", "json"]),
+    default="text",
+)
+@click.argument("ds_path", metavar="DATASET", shell_complete=repo_path_completer)
+def transform(ctx, assignments, where, message, output_format, ds_path):
+    """
+    Compute new values for the fields of a dataset's features, and commit the result - so that routine bulk edits
+    don't need the data to be exported, edited and imported again.
+
+    Expressions use Python syntax, and can refer to any of the feature's fields by name. Arithmetic, comparisons,
+    `and`, `or`, `not`, `is None`, `X if CONDITION else Y` and functions - including geometry functions such as
+    area, length, buffer, centroid and intersects - are supported. Any arithmetic on a NULL value gives NULL.
+    See the documentation of table datasets for the full list of functions.
+
+    eg: kart transform buildings --expr "height_m = height_ft * 0.3048" --where "height_ft is not None" -m "Metricate"
+    """
+    repo = ctx.obj.repo
+    check_git_user(repo)
+    dataset = get_table_dataset(repo, ds_path, "transform")
+    repo.working_copy.check_not_dirty()
+
+    feature_diff = transform_features(dataset, assignments, where)
+    draft_message = "\n".join(f"Transform {ds_path}: {a}" for a in assignments)
+    commit_feature_diff(
+        repo, ds_path, feature_diff, message, output_format, draft_message
+    )
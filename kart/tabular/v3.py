@@ -66,9 +66,17 @@ class TableV3(RichTableDataset):
     # === Visible meta-items ===
     TITLE = meta_items.TITLE
     DESCRIPTION = meta_items.DESCRIPTION
+    README = meta_items.README
     TAGS_JSON = meta_items.TAGS_JSON
     SCHEMA_JSON = meta_items.SCHEMA_JSON
     CRS_DEFINITIONS = meta_items.CRS_DEFINITIONS
+    # Map styling - QGIS QML or OGC SLD documents, which are written to the layer_styles table on GPKG export:
+    STYLE = MetaItemDefinition(
+        re.compile(r"style/(.*)\.(qml|sld)"), MetaItemFileType.XML
+    )
+    # Related tables - eg a table of photos, linked to the features of this dataset via a mapping table - which are
+    # written to the gpkgext_relations table on GPKG export:
+    RELATIONS_JSON = MetaItemDefinition("relations.json", MetaItemFileType.JSON)
 
     # == Hidden meta-items (which don't show in diffs) ==
     # How automatically generated PKs have been assigned so far:
@@ -89,9 +97,12 @@ class TableV3(RichTableDataset):
     META_ITEMS = (
         TITLE,
         DESCRIPTION,
+        README,
         TAGS_JSON,
         SCHEMA_JSON,
         CRS_DEFINITIONS,
+        STYLE,
+        RELATIONS_JSON,
         GENERATED_PKS,
         PATH_STRUCTURE,
         LEGEND,
@@ -17,7 +17,7 @@ class TableWorkingCopyType(Enum):
 
         location = str(location)
         db_type = DbType.from_spec(location)
-        if db_type is not None:
+        if db_type is not None and db_type.name in cls.__members__:
             return TableWorkingCopyType[db_type.name]
 
         if allow_invalid:
@@ -8,12 +8,14 @@ from pathlib import Path
 import click
 
 import sqlalchemy as sa
-from kart import crs_util
+from kart import crs_util, fs_util
 from kart.exceptions import InvalidOperation
+from kart.fs_util import file_locked_error
 from kart import meta_items
 from kart.sqlalchemy import text_with_inlined_params
 from kart.sqlalchemy.adapter.gpkg import KartAdapter_GPKG
 from kart.schema import Schema
+from kart.tabular.v3 import TableV3
 from sqlalchemy.dialects.sqlite.base import SQLiteIdentifierPreparer
 from sqlalchemy.orm import sessionmaker
 
@@ -39,6 +41,7 @@ class WorkingCopy_GPKG(TableWorkingCopy):
         meta_items.DESCRIPTION,
         meta_items.SCHEMA_JSON,
         meta_items.CRS_DEFINITIONS,
+        TableV3.RELATIONS_JSON,
     )
 
     def __init__(self, repo, location):
@@ -135,6 +138,12 @@ class WorkingCopy_GPKG(TableWorkingCopy):
             yield self._session
             self._session.commit()
 
+        except sa.exc.OperationalError as e:
+            self._session.rollback()
+            if "database is locked" in str(e):
+                raise file_locked_error(self.full_path, e) from e
+            raise
+
         except Exception:
             self._session.rollback()
             raise
@@ -145,13 +154,13 @@ class WorkingCopy_GPKG(TableWorkingCopy):
 
     def delete(self, keep_db_schema_if_possible=False):
         """Delete the working copy files."""
-        self.full_path.unlink()
+        fs_util.unlink(self.full_path)
 
         # for sqlite this might include wal/journal/etc files
         # app.gpkg -> app.gpkg-wal, app.gpkg-journal
         # https://www.sqlite.org/shortnames.html
         for f in Path(self.full_path).parent.glob(Path(self.path).name + "-*"):
-            f.unlink()
+            fs_util.unlink(f)
 
     def status(self, check_if_dirty=False, allow_unconnectable=False):
         result = 0
@@ -228,7 +237,8 @@ class WorkingCopy_GPKG(TableWorkingCopy):
     def _write_meta(self, sess, dataset):
         """
         Populate the following tables with data from this dataset:
-        gpkg_contents, gpkg_geometry_columns, gpkg_spatial_ref_sys, gpkg_metadata, gpkg_metadata_reference
+        gpkg_contents, gpkg_geometry_columns, gpkg_spatial_ref_sys, gpkg_metadata, gpkg_metadata_reference,
+        gpkgext_relations
         """
         table_name = dataset.table_name
         gpkg_meta_items = dict(
@@ -275,6 +285,10 @@ class WorkingCopy_GPKG(TableWorkingCopy):
                     sess, table_name, gpkg_metadata, gpkg_metadata_reference
                 )
 
+            KartAdapter_GPKG.write_gpkgext_relations(
+                sess, gpkg_meta_items.get("gpkgext_relations")
+            )
+
     def _write_meta_metadata(
         self,
         sess,
@@ -343,6 +357,11 @@ class WorkingCopy_GPKG(TableWorkingCopy):
                 dataset, ds_meta_items.get("title"), wc_meta_items["title"]
             )
 
+        if "relations.json" in ds_meta_items and "relations.json" in wc_meta_items:
+            wc_meta_items["relations.json"] = self._restore_relation_dataset_paths(
+                ds_meta_items["relations.json"], wc_meta_items["relations.json"]
+            )
+
         super()._remove_hidden_meta_diffs(dataset, ds_meta_items, wc_meta_items)
 
     def _restore_approximated_title(self, dataset, ds_title, wc_title):
@@ -353,6 +372,24 @@ class WorkingCopy_GPKG(TableWorkingCopy):
             wc_title = wc_title[len(prefix) :]
         return wc_title or None
 
+    def _restore_relation_dataset_paths(self, ds_relations, wc_relations):
+        # The working copy refers to related tables by table name - not dataset path - so we map the names back to
+        # the paths that the dataset refers to, where they are equivalent.
+        table_names_to_paths = {}
+        for relation in ds_relations:
+            for key in ("relatedDataset", "mappingDataset"):
+                ds_path = relation[key]
+                table_name = TableV3.dataset_path_to_table_name(ds_path)
+                table_names_to_paths[table_name] = ds_path
+
+        def _restore(relation):
+            relation = dict(relation)
+            for key in ("relatedDataset", "mappingDataset"):
+                relation[key] = table_names_to_paths.get(relation[key], relation[key])
+            return relation
+
+        return [_restore(relation) for relation in wc_relations]
+
     def _restore_approximated_primary_key(self, ds_schema, wc_schema):
         """
         GPKG requires that there is a primary key of type INTEGER (int64) in geospatial tables.
@@ -408,6 +445,7 @@ class WorkingCopy_GPKG(TableWorkingCopy):
         table_name = dataset.table_name
         with self.session() as sess:
             self._delete_meta_metadata(sess, table_name)
+            KartAdapter_GPKG.delete_gpkgext_relations(sess, table_name)
 
             # FOREIGN KEY constraints are still active, so we delete in a particular order:
             for table in (GpkgTables.gpkg_geometry_columns, GpkgTables.gpkg_contents):
@@ -641,6 +679,14 @@ class WorkingCopy_GPKG(TableWorkingCopy):
                 sess, table, gpkg_metadata, gpkg_metadata_reference
             )
 
+    def _apply_meta_relations_json(self, sess, dataset, src_value, dest_value):
+        table = dataset.table_name
+        KartAdapter_GPKG.delete_gpkgext_relations(sess, table)
+        if dest_value:
+            KartAdapter_GPKG.write_gpkgext_relations(
+                sess, KartAdapter_GPKG.generate_gpkgext_relations(dataset, table)
+            )
+
     def _update_last_write_time(self, sess, dataset, commit=None):
         self._update_gpkg_contents(sess, dataset, commit)
 
@@ -0,0 +1,103 @@
+from kart.schema import Schema
+
+from .import_source import TableImportSource
+
+
+class WrappingTableImportSource(TableImportSource):
+    """
+    Base class for a TableImportSource that wraps a delegate TableImportSource, and modifies the schema and/or the
+    features of the delegate as they are imported. Everything not modified is passed straight through to the delegate.
+
+    Subclasses should override transform_schema and / or transform_feature as needed.
+    """
+
+    def __init__(self, delegate):
+        self.delegate = delegate
+        self._transformed_schema = Schema(self.transform_schema(delegate.schema))
+
+    def transform_schema(self, schema):
+        """Given the schema of the delegate, returns the schema of this TableImportSource."""
+        return schema
+
+    def transform_feature(self, feature):
+        """
+        Given a feature from the delegate, returns the feature as it should be imported, or None if it should be
+        skipped. The feature dict may be modified in place.
+        """
+        return feature
+
+    def _transform_features(self, features):
+        for feature in features:
+            feature = self.transform_feature(feature)
+            if feature is not None:
+                yield feature
+
+    def features(self):
+        yield from self._transform_features(self.delegate.features())
+
+    def get_features(self, row_pks, *, ignore_missing=False):
+        yield from self._transform_features(
+            self.delegate.get_features(row_pks, ignore_missing=ignore_missing)
+        )
+
+    def check_fully_specified(self):
+        self.delegate.check_fully_specified()
+
+    @property
+    def dest_path(self):
+        return self.delegate.dest_path
+
+    @dest_path.setter
+    def dest_path(self, dest_path):
+        self.delegate.dest_path = dest_path
+
+    @property
+    def schema(self):
+        return self._transformed_schema
+
+    def get_meta_item(self, name, missing_ok=True):
+        if name == "schema.json":
+            return self._transformed_schema
+        return self.delegate.get_meta_item(name, missing_ok=missing_ok)
+
+    def meta_items(self):
+        return {**self.delegate.meta_items(), "schema.json": self._transformed_schema}
+
+    def align_schema_to_existing_schema(self, existing_schema):
+        self._transformed_schema = existing_schema.align_to_self(
+            self._transformed_schema
+        )
+
+    def crs_definitions(self):
+        return self.delegate.crs_definitions()
+
+    def get_crs_definition(self, identifier=None):
+        return self.delegate.get_crs_definition(identifier)
+
+    @property
+    def has_geometry(self):
+        return self.schema.has_geometry
+
+    @property
+    def feature_count(self):
+        return self.delegate.feature_count
+
+    @property
+    def table(self):
+        return self.delegate.table
+
+    def __enter__(self):
+        self.delegate.__enter__()
+        return self
+
+    def __exit__(self, *args):
+        return self.delegate.__exit__(*args)
+
+    def __str__(self):
+        return str(self.delegate)
+
+    def import_source_desc(self):
+        return self.delegate.import_source_desc()
+
+    def aggregate_import_source_desc(self, import_sources):
+        return self.delegate.aggregate_import_source_desc(import_sources)
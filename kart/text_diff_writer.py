@@ -66,10 +66,13 @@ class TextDiffWriter(BaseDiffWriter):
         if diff_format != DiffFormat.NO_DATA_CHANGES:
             item_type = self._get_old_or_new_dataset(ds_path).ITEM_TYPE
             if item_type:
+                measurers = self.get_geodesic_measurers(ds_path, ds_diff)
                 for key, delta in self.filtered_dataset_deltas(ds_path, ds_diff):
                     self.write_dict_delta_only_show_diffs(
                         ds_path, item_type, key, delta
                     )
+                    if any(measurers):
+                        self.write_measurements(ds_path, delta, *measurers)
 
     def write_full_delta(self, ds_path, item_type, key, delta):
         """Writes the old and new halves of a delta in full - ie, not just those parts that have changed."""
@@ -170,6 +173,34 @@ class TextDiffWriter(BaseDiffWriter):
                 output = feature_field_as_text(new_value, k, prefix="+ ")
                 click.secho(output, fg="green", **self.pecho)
 
+    def write_measurements(self, ds_path, delta, old_measurer, new_measurer):
+        """Writes how the geodesic area or length of the feature changed - eg "shrank by 34.0 m²"."""
+        if delta.type == "update":
+            geom_column = self._get_old_or_new_dataset(ds_path).geom_column_name
+            if delta.old_value.get(geom_column) == delta.new_value.get(geom_column):
+                return
+
+        from kart.tabular.geodesic import (
+            describe_measurement_change,
+            format_measurement,
+        )
+
+        measurements = self.measure_delta(ds_path, delta, old_measurer, new_measurer)
+        old, new = measurements.get("-", {}), measurements.get("+", {})
+        for name in ("area", "length"):
+            if name in old and name in new:
+                change = describe_measurement_change(name, old[name], new[name])
+                output = (
+                    f"  (geodesic {name}: {format_measurement(name, old[name])} → "
+                    f"{format_measurement(name, new[name])}, {change})"
+                )
+            elif name in old or name in new:
+                value = old.get(name, new.get(name))
+                output = f"  (geodesic {name}: {format_measurement(name, value)})"
+            else:
+                continue
+            click.secho(output, fg="cyan", **self.pecho)
+
     # The rest of the class is all just so we can get nice schema diffs. Still, that's important.
     @classmethod
     def _schema_diff_as_text(cls, old_schema, new_schema):
@@ -50,6 +50,7 @@ class TileDataset(BaseDataset):
 
     TITLE = meta_items.TITLE
     DESCRIPTION = meta_items.DESCRIPTION
+    README = meta_items.README
     TAGS_JSON = meta_items.TAGS_JSON
 
     # Information about which tile format(s) this dataset requires / allows.
@@ -64,6 +65,7 @@ class TileDataset(BaseDataset):
     META_ITEMS = (
         TITLE,
         DESCRIPTION,
+        README,
         TAGS_JSON,
         FORMAT_JSON,
         SCHEMA_JSON,
@@ -0,0 +1,352 @@
+import logging
+import sys
+import urllib.error
+import urllib.request
+import xml.etree.ElementTree as ET
+
+import click
+
+from kart.base_diff_writer import BaseDiffWriter
+from kart.cli_util import KartCommand
+from kart.completion_shared import repo_path_completer
+from kart.crs_util import make_crs
+from kart.diff_util import get_repo_diff
+from kart.exceptions import (
+    InvalidOperation,
+    NotYetImplemented,
+    NO_CHANGES,
+    UNCATEGORIZED_ERROR,
+)
+from kart.key_filters import RepoKeyFilter
+from kart.tabular.table_dataset import TableDataset
+
+L = logging.getLogger("kart.wfst")
+
+WFS_NS = "http://www.opengis.net/wfs/2.0"
+FES_NS = "http://www.opengis.net/fes/2.0"
+GML_NS = "http://www.opengis.net/gml/3.2"
+
+ET.register_namespace("wfs", WFS_NS)
+ET.register_namespace("fes", FES_NS)
+ET.register_namespace("gml", GML_NS)
+
+
+def _qname(ns, tag):
+    return f"{{{ns}}}{tag}"
+
+
+class WfsTransactionBuilder:
+    """
+    Builds a WFS 2.0 Transaction document from the feature changes in a RepoDiff.
+
+    Each dataset is mapped to a feature type, whose name defaults to the dataset's table name. Features are
+    identified in Update and Delete actions by a filter on their primary key property, so the upstream feature type
+    needs to have the same primary key as the Kart dataset. Changes to a feature's primary key are sent as a Delete
+    followed by an Insert.
+    """
+
+    def __init__(self, type_names=None, namespace=None):
+        """
+        type_names - dict of {dataset_path: feature type name}, for any datasets that aren't named after their table.
+        namespace - (prefix, uri) of the namespace the feature types are in, or None if they are unqualified.
+        """
+        self.type_names = type_names or {}
+        self.namespace = namespace
+        if namespace:
+            ET.register_namespace(*namespace)
+        self.root = ET.Element(
+            _qname(WFS_NS, "Transaction"), {"service": "WFS", "version": "2.0.0"}
+        )
+        self.counts = {"insert": 0, "update": 0, "delete": 0}
+        self._gml_id = 0
+
+    def _ns_tag(self, name):
+        if self.namespace:
+            return _qname(self.namespace[1], name)
+        return name
+
+    def _type_name_attr(self, type_name):
+        if self.namespace:
+            return f"{self.namespace[0]}:{type_name}"
+        return type_name
+
+    def type_name(self, dataset):
+        return self.type_names.get(
+            dataset.path, TableDataset.dataset_path_to_table_name(dataset.path)
+        )
+
+    def add_dataset_diff(self, dataset, ds_diff):
+        if "meta" in ds_diff:
+            raise NotYetImplemented(
+                f"Can't push changes to {dataset.path} using WFS-T - it has changes to its schema or metadata, "
+                "which WFS-T can't express"
+            )
+        pk_columns = dataset.schema.pk_columns
+        if len(pk_columns) != 1:
+            raise NotYetImplemented(
+                f"Can't push changes to {dataset.path} using WFS-T - only datasets with a single primary key column "
+                "are supported"
+            )
+        pk_name = pk_columns[0].name
+        type_name = self.type_name(dataset)
+        for delta in ds_diff.get("feature", {}).values():
+            if delta.type == "insert":
+                self.add_insert(dataset, type_name, delta.new_value)
+            elif delta.type == "delete":
+                self.add_delete(type_name, pk_name, delta.old_value[pk_name])
+            elif delta.is_rename():
+                self.add_delete(type_name, pk_name, delta.old_value[pk_name])
+                self.add_insert(dataset, type_name, delta.new_value)
+            else:
+                self.add_update(
+                    dataset, type_name, pk_name, delta.old_value, delta.new_value
+                )
+
+    def add_insert(self, dataset, type_name, feature):
+        insert = ET.SubElement(self.root, _qname(WFS_NS, "Insert"))
+        feature_elem = ET.SubElement(insert, self._ns_tag(type_name))
+        for column in dataset.schema.columns:
+            value = feature.get(column.name)
+            if value is None:
+                continue
+            prop = ET.SubElement(feature_elem, self._ns_tag(column.name))
+            self._set_value(prop, dataset, column, value)
+        self.counts["insert"] += 1
+
+    def add_update(self, dataset, type_name, pk_name, old_feature, new_feature):
+        update = ET.SubElement(
+            self.root,
+            _qname(WFS_NS, "Update"),
+            {"typeName": self._type_name_attr(type_name)},
+        )
+        for column in dataset.schema.columns:
+            old_value = old_feature.get(column.name)
+            new_value = new_feature.get(column.name)
+            if old_value == new_value:
+                continue
+            prop = ET.SubElement(update, _qname(WFS_NS, "Property"))
+            ET.SubElement(prop, _qname(WFS_NS, "ValueReference")).text = column.name
+            if new_value is not None:
+                # A Property without a Value sets that property to null.
+                value = ET.SubElement(prop, _qname(WFS_NS, "Value"))
+                self._set_value(value, dataset, column, new_value)
+        update.append(self._pk_filter(pk_name, new_feature[pk_name]))
+        self.counts["update"] += 1
+
+    def add_delete(self, type_name, pk_name, pk_value):
+        delete = ET.SubElement(
+            self.root,
+            _qname(WFS_NS, "Delete"),
+            {"typeName": self._type_name_attr(type_name)},
+        )
+        delete.append(self._pk_filter(pk_name, pk_value))
+        self.counts["delete"] += 1
+
+    def _pk_filter(self, pk_name, pk_value):
+        filter_elem = ET.Element(_qname(FES_NS, "Filter"))
+        equal_to = ET.SubElement(filter_elem, _qname(FES_NS, "PropertyIsEqualTo"))
+        ET.SubElement(equal_to, _qname(FES_NS, "ValueReference")).text = pk_name
+        ET.SubElement(equal_to, _qname(FES_NS, "Literal")).text = str(pk_value)
+        return filter_elem
+
+    def _set_value(self, elem, dataset, column, value):
+        if column.data_type == "geometry":
+            elem.append(self._geometry_to_gml(dataset, column, value))
+        elif column.data_type == "boolean":
+            elem.text = "true" if value else "false"
+        elif column.data_type == "blob":
+            elem.text = value.hex()
+        else:
+            elem.text = str(value)
+
+    def _geometry_to_gml(self, dataset, column, geometry):
+        ogr_geom = geometry.to_ogr()
+        crs_identifier = column.get("geometryCRS")
+        if crs_identifier:
+            try:
+                ogr_geom.AssignSpatialReference(
+                    make_crs(dataset.get_crs_definition(crs_identifier))
+                )
+            except KeyError:
+                pass
+        self._gml_id += 1
+        gml = ogr_geom.ExportToGML(
+            options=[
+                "FORMAT=GML32",
+                f"GMLID=kart.geom.{self._gml_id}",
+                "SRSNAME_FORMAT=OGC_URN",
+            ]
+        )
+        # ExportToGML doesn't declare the gml prefix, since its output is intended to be embedded in a larger document.
+        wrapper = ET.fromstring(f'<wrapper xmlns:gml="{GML_NS}">{gml}</wrapper>')
+        return wrapper[0]
+
+    @property
+    def is_empty(self):
+        return not any(self.counts.values())
+
+    def to_xml(self):
+        ET.indent(self.root)
+        return ET.tostring(self.root, encoding="utf-8", xml_declaration=True)
+
+
+def parse_transaction_response(response_body):
+    """
+    Parses a WFS TransactionResponse, returning a dict of {"insert": n, "update": n, "delete": n}.
+    Raises InvalidOperation if the server responded with an exception report instead.
+    """
+    try:
+        root = ET.fromstring(response_body)
+    except ET.ParseError as e:
+        raise InvalidOperation(f"The WFS-T server sent an invalid response: {e}")
+
+    if root.tag.endswith("ExceptionReport"):
+        messages = [
+            elem.text.strip()
+            for elem in root.iter()
+            if elem.tag.endswith("ExceptionText") and elem.text
+        ]
+        raise InvalidOperation(
+            "The WFS-T server rejected the transaction:\n" + "\n".join(messages),
+            exit_code=UNCATEGORIZED_ERROR,
+        )
+
+    result = {}
+    for action, tag in [
+        ("insert", "totalInserted"),
+        ("update", "totalUpdated"),
+        ("delete", "totalDeleted"),
+    ]:
+        elem = next((e for e in root.iter() if e.tag.endswith(tag)), None)
+        result[action] = int(elem.text) if elem is not None and elem.text else 0
+    return result
+
+
+def post_transaction(url, body, headers):
+    request = urllib.request.Request(
+        url,
+        data=body,
+        headers={"Content-Type": "application/xml", **headers},
+        method="POST",
+    )
+    try:
+        with urllib.request.urlopen(request) as response:
+            return response.read()
+    except urllib.error.HTTPError as e:
+        # Servers generally describe what went wrong using an ExceptionReport, even if the status isn't 200.
+        body = e.read()
+        if body:
+            parse_transaction_response(body)
+        raise InvalidOperation(f"The WFS-T server responded with HTTP {e.code}")
+    except urllib.error.URLError as e:
+        raise InvalidOperation(f"Couldn't connect to the WFS-T server: {e.reason}")
+
+
+def _parse_key_value(values, param_hint, separator="="):
+    result = {}
+    for value in values:
+        if separator not in value:
+            raise click.BadParameter(
+                f"Expected KEY{separator}VALUE, got {value!r}", param_hint=param_hint
+            )
+        key, value = value.split(separator, 1)
+        result[key.strip()] = value.strip()
+    return result
+
+
+@click.command("push-wfst", cls=KartCommand)
+@click.pass_context
+@click.option(
+    "--type-name",
+    "type_names",
+    multiple=True,
+    metavar="DATASET=TYPENAME",
+    help=(
+        "The name of the upstream feature type to send a dataset's changes to. "
+        "Defaults to the dataset's table name. Can be given more than once."
+    ),
+)
+@click.option(
+    "--namespace",
+    metavar="PREFIX=URI",
+    help="The XML namespace that the upstream feature types belong to, if any.",
+)
+@click.option(
+    "--header",
+    "headers",
+    multiple=True,
+    metavar="NAME:VALUE",
+    help='Extra HTTP headers to send, eg --header="Authorization: Bearer TOKEN". Can be given more than once.',
+)
+@click.option(
+    "--dry-run",
+    is_flag=True,
+    help="Write the WFS-T transaction to stdout, rather than sending it to the server.",
+)
+@click.argument("commit_spec", metavar="COMMIT_RANGE")
+@click.argument("url")
+@click.argument("datasets", nargs=-1, shell_complete=repo_path_completer)
+def push_wfst(ctx, type_names, namespace, headers, dry_run, commit_spec, url, datasets):
+    """
+    Send the feature changes in a range of commits to a WFS-T server.
+
+    Converts every feature insert, update and delete between the two commits of COMMIT_RANGE (eg "v1.0...main", or
+    "HEAD^...HEAD") into a single WFS 2.0 Transaction, and posts it to URL. The transaction is atomic - if the server
+    rejects any part of it, none of it is applied. Only changes to the given DATASETS are sent, if any are specified.
+
+    Each dataset must have a single primary key column, and the upstream feature type must have a property with the
+    same name and values, which is used to identify features being updated or deleted.
+    """
+    repo = ctx.obj.repo
+    if ".." not in commit_spec:
+        raise click.BadParameter(
+            "Expected a range of commits, eg HEAD^...HEAD", param_hint="COMMIT_RANGE"
+        )
+    base_rs, target_rs, _ = BaseDiffWriter.parse_diff_commit_spec(repo, commit_spec)
+
+    repo_key_filter = (
+        RepoKeyFilter.datasets(datasets) if datasets else RepoKeyFilter.MATCH_ALL
+    )
+    repo_diff = get_repo_diff(base_rs, target_rs, repo_key_filter=repo_key_filter)
+
+    type_names = _parse_key_value(type_names, "--type-name")
+    if namespace:
+        namespace = tuple(_parse_key_value([namespace], "--namespace").items())[0]
+    headers = _parse_key_value(headers, "--header", separator=":")
+
+    builder = WfsTransactionBuilder(type_names=type_names, namespace=namespace)
+    base_datasets = base_rs.datasets()
+    target_datasets = target_rs.datasets()
+    for ds_path, ds_diff in repo_diff.items():
+        dataset = target_datasets.get(ds_path) or base_datasets.get(ds_path)
+        if not isinstance(dataset, TableDataset):
+            raise NotYetImplemented(
+                f"Can't push changes to {ds_path} using WFS-T - only table datasets are supported"
+            )
+        if ds_path not in target_datasets or ds_path not in base_datasets:
+            raise NotYetImplemented(
+                f"Can't push changes to {ds_path} using WFS-T - the dataset was created or deleted in this range"
+            )
+        builder.add_dataset_diff(dataset, ds_diff)
+
+    if builder.is_empty:
+        raise InvalidOperation(
+            f"No feature changes to send in {commit_spec}", exit_code=NO_CHANGES
+        )
+
+    body = builder.to_xml()
+    if dry_run:
+        sys.stdout.buffer.write(body)
+        sys.stdout.buffer.write(b"\n")
+        return
+
+    counts = parse_transaction_response(post_transaction(url, body, headers))
+    click.echo(
+        f"{url} accepted {counts['insert']} inserts, {counts['update']} updates and {counts['delete']} deletes"
+    )
+    if counts != builder.counts:
+        click.echo(
+            f"Warning: {builder.counts['insert']} inserts, {builder.counts['update']} updates and "
+            f"{builder.counts['delete']} deletes were sent",
+            err=True,
+        )
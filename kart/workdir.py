@@ -4,7 +4,6 @@ import click
 import logging
 from enum import Enum, auto
 import functools
-import shutil
 import sys
 from kart.structure import RepoStructure
 
@@ -14,7 +13,7 @@ from sqlalchemy.orm import sessionmaker
 from sqlalchemy.schema import CreateTable
 
 
-from kart import diff_util
+from kart import diff_util, fs_util
 from kart.diff_util import get_file_diff
 from kart.diff_structs import Delta, DatasetDiff
 from kart.exceptions import (
@@ -395,7 +394,7 @@ class FileSystemWorkingCopy(WorkingCopyPart):
             for filename in set(filter(None, (file_delta.old_key, file_delta.new_key))):
                 workdir_path = self.path / filename
                 if workdir_path.is_file():
-                    workdir_path.unlink()
+                    fs_util.unlink(workdir_path)
                 if write_to_index:
                     workdir_index.remove_all([filename])
 
@@ -516,7 +515,7 @@ class FileSystemWorkingCopy(WorkingCopyPart):
             assert self.path in ds_tiles_dir.parents
             assert self.repo.workdir_path in ds_tiles_dir.parents
             if ds_tiles_dir.is_dir():
-                shutil.rmtree(ds_tiles_dir)
+                fs_util.rmtree(ds_tiles_dir)
             if write_to_index:
                 workdir_index.remove_all([f"{dataset.path}/**"])
 
@@ -583,7 +582,7 @@ class FileSystemWorkingCopy(WorkingCopyPart):
                 )
                 for child in ds_tiles_dir.glob(tilename + ".*"):
                     if name_pattern.fullmatch(child.name) and child.is_file():
-                        child.unlink()
+                        fs_util.unlink(child)
                     if write_to_index:
                         workdir_index.remove_all([f"{dataset.path}/{child.name}"])
 
@@ -621,7 +620,7 @@ class FileSystemWorkingCopy(WorkingCopyPart):
             for tile_name in set(_all_names_in_tile_delta(tile_delta)):
                 tile_path = ds_tiles_dir / tile_name
                 if tile_path.is_file():
-                    tile_path.unlink()
+                    fs_util.unlink(tile_path)
                 if write_to_index:
                     workdir_index.remove_all([f"{ds_path}/{tile_name}"])
 
@@ -781,7 +780,7 @@ class FileSystemWorkingCopy(WorkingCopyPart):
         name_pattern = dataset.get_tile_path_pattern(tilename)
         for child in ds_tiles_dir.glob(tilename + ".*"):
             if name_pattern.fullmatch(child.name) and child.is_file():
-                child.unlink()
+                fs_util.unlink(child)
 
         self._write_tile_or_pam_file_to_workdir(
             dataset, tile_delta.new_value, workdir_index, write_to_index=True
@@ -805,7 +804,7 @@ class FileSystemWorkingCopy(WorkingCopyPart):
 
         for child in ds_tiles_dir.glob(case_insensitive(tilename) + ".*"):
             if pam_name_pattern.fullmatch(child.name) and child.is_file():
-                child.unlink()
+                fs_util.unlink(child)
 
         self._write_tile_or_pam_file_to_workdir(
             dataset,
@@ -0,0 +1,416 @@
+import json
+import logging
+from pathlib import Path
+
+import click
+import pygit2
+
+from kart.cli_util import KartGroup, add_help_subcommand
+from kart.exceptions import (
+    InvalidOperation,
+    NotFound,
+    INVALID_FILE_FORMAT,
+    NO_COMMIT,
+    NO_DATA,
+)
+from kart.output_util import dump_json_output
+
+L = logging.getLogger("kart.workspace")
+
+DEFAULT_MANIFEST = "workspace.yaml"
+DEFAULT_OUTPUT = "workspace.gpkg"
+# Clones of the stores, and a record of what was last checked out, are kept here - next to the manifest.
+WORKSPACE_DIR = ".kart-workspace"
+CHECKOUT_STATE = "checkout.json"
+
+MANIFEST_SCHEMA = {
+    "type": "object",
+    "$schema": "http://json-schema.org/draft-07/schema",
+    "properties": {
+        "output": {"type": "string"},
+        "stores": {
+            "type": "array",
+            "minItems": 1,
+            "items": {
+                "type": "object",
+                "properties": {
+                    "name": {"type": "string", "pattern": "^[A-Za-z0-9_.-]+$"},
+                    "url": {"type": "string"},
+                    "ref": {"type": "string"},
+                    "commit": {"type": "string"},
+                    "datasets": {
+                        "type": "array",
+                        "minItems": 1,
+                        "items": {"type": "string"},
+                    },
+                },
+                "required": ["name", "url", "datasets"],
+                "additionalProperties": False,
+            },
+        },
+    },
+    "required": ["stores"],
+    "additionalProperties": False,
+}
+
+
+class Store:
+    """One of the repositories that a workspace takes datasets from, as described in the manifest."""
+
+    def __init__(self, workspace, definition):
+        self.workspace = workspace
+        self.definition = definition
+        self.name = definition["name"]
+        self.url = definition["url"]
+        self.ref = definition.get("ref", "HEAD")
+        self.path = workspace.workspace_dir / "stores" / self.name
+        self._repo = None
+
+    @property
+    def pinned_commit(self):
+        return self.definition.get("commit")
+
+    @pinned_commit.setter
+    def pinned_commit(self, commit_id):
+        self.definition["commit"] = commit_id
+
+    @property
+    def datasets(self):
+        """Returns a list of (ds_path, layer_name) - each dataset can be given a new name as DATASET:LAYER."""
+        result = []
+        for spec in self.definition["datasets"]:
+            ds_path, _, layer_name = spec.partition(":")
+            result.append((ds_path, layer_name or None))
+        return result
+
+    @property
+    def is_cloned(self):
+        return self.path.exists()
+
+    @property
+    def repo(self):
+        from kart.repo import KartRepo
+
+        if self._repo is None:
+            if not self.is_cloned:
+                self.clone()
+            self._repo = KartRepo(self.path)
+        return self._repo
+
+    def clone(self):
+        from kart.repo import KartRepo
+
+        click.echo(f"Cloning {self.name} from {self.url} ...")
+        self.path.parent.mkdir(parents=True, exist_ok=True)
+        # A mirror clone, so that fetching later updates every branch and tag.
+        self._repo = KartRepo.clone_repository(
+            self.url, self.path, ["--quiet", "--mirror"], bare=True
+        )
+
+    def fetch(self):
+        if not self.is_cloned:
+            self.clone()
+            return
+        click.echo(f"Fetching {self.name} from {self.url} ...")
+        self.repo.invoke_git("fetch", "--quiet", "--prune", "origin")
+
+    def resolve_ref(self):
+        try:
+            return self.repo.revparse_single(self.ref).peel(pygit2.Commit)
+        except (KeyError, ValueError):
+            raise NotFound(
+                f"No such ref in {self.name}: {self.ref}", exit_code=NO_COMMIT
+            )
+
+    def pinned(self):
+        """Returns the pinned commit, fetching it first if it isn't in the clone yet."""
+        commit_id = self.pinned_commit
+        try:
+            return self.repo.revparse_single(commit_id).peel(pygit2.Commit)
+        except (KeyError, ValueError):
+            pass
+        self.fetch()
+        try:
+            return self.repo.revparse_single(commit_id).peel(pygit2.Commit)
+        except (KeyError, ValueError):
+            raise NotFound(
+                f"No such commit in {self.name}: {commit_id}", exit_code=NO_COMMIT
+            )
+
+
+class Workspace:
+    """
+    A set of datasets taken from several Kart repositories - each at a pinned commit - that are checked out together
+    into one multi-layer GeoPackage. Much like git submodules, the manifest records exactly which commit of each
+    repository is used, so the same workspace can be rebuilt later.
+    """
+
+    def __init__(self, manifest_path):
+        import jsonschema
+        import yaml
+
+        self.manifest_path = Path(manifest_path).resolve()
+        try:
+            with open(self.manifest_path, encoding="utf-8") as f:
+                self.manifest = yaml.safe_load(f)
+        except FileNotFoundError:
+            raise NotFound(f"No workspace manifest found at {manifest_path}")
+        except yaml.YAMLError as e:
+            raise InvalidOperation(
+                f"Invalid workspace manifest {manifest_path}: {e}",
+                exit_code=INVALID_FILE_FORMAT,
+            )
+        try:
+            jsonschema.validate(instance=self.manifest, schema=MANIFEST_SCHEMA)
+        except jsonschema.ValidationError as e:
+            raise InvalidOperation(
+                f"Invalid workspace manifest {manifest_path}: {e.message}",
+                exit_code=INVALID_FILE_FORMAT,
+            )
+
+        self.workspace_dir = self.manifest_path.parent / WORKSPACE_DIR
+        self.output_path = self.manifest_path.parent / self.manifest.get(
+            "output", DEFAULT_OUTPUT
+        )
+        self.stores = [Store(self, s) for s in self.manifest["stores"]]
+        names = [s.name for s in self.stores]
+        duplicates = sorted({n for n in names if names.count(n) > 1})
+        if duplicates:
+            raise InvalidOperation(
+                f"Invalid workspace manifest {manifest_path}: duplicate store names: {', '.join(duplicates)}"
+            )
+
+    def get_stores(self, names):
+        if not names:
+            return self.stores
+        by_name = {s.name: s for s in self.stores}
+        missing = [n for n in names if n not in by_name]
+        if missing:
+            raise NotFound(
+                f"No such store in the workspace: {', '.join(missing)}",
+                exit_code=NO_DATA,
+            )
+        return [by_name[n] for n in names]
+
+    def save_manifest(self):
+        import yaml
+
+        with open(self.manifest_path, "w", encoding="utf-8") as f:
+            yaml.safe_dump(self.manifest, f, sort_keys=False)
+
+    def read_checkout_state(self):
+        try:
+            return json.loads((self.workspace_dir / CHECKOUT_STATE).read_text())
+        except (OSError, ValueError):
+            return {}
+
+    def write_checkout_state(self, commits):
+        self.workspace_dir.mkdir(parents=True, exist_ok=True)
+        (self.workspace_dir / CHECKOUT_STATE).write_text(
+            json.dumps({"output": str(self.output_path), "stores": commits}, indent=2)
+        )
+
+    def pin_unpinned_stores(self):
+        """Any store that doesn't have a pinned commit yet is pinned to the current commit of its ref."""
+        unpinned = [s for s in self.stores if not s.pinned_commit]
+        for store in unpinned:
+            store.pinned_commit = store.resolve_ref().hex
+            click.echo(f"Pinned {store.name} to {store.pinned_commit[:7]}")
+        if unpinned:
+            self.save_manifest()
+
+    def checkout(self):
+        """Writes every dataset in the workspace - at its pinned commit - to the output GeoPackage."""
+        from kart.export import EXPORT_FORMATS, get_datasets_to_export
+
+        self.pin_unpinned_stores()
+        to_export = []
+        layer_names = {}
+        for store in self.stores:
+            commit = store.pinned()
+            datasets = get_datasets_to_export(
+                store.repo, commit.hex, [ds_path for ds_path, _ in store.datasets]
+            )
+            for dataset, (ds_path, layer_name) in zip(datasets, store.datasets):
+                layer_name = layer_name or dataset.dataset_path_to_table_name(ds_path)
+                if layer_name in layer_names:
+                    raise InvalidOperation(
+                        f"Both {layer_names[layer_name]} and {store.name}:{ds_path} would be written to the layer "
+                        f"{layer_name} - rename one of them using DATASET:LAYER in the manifest"
+                    )
+                layer_names[layer_name] = f"{store.name}:{ds_path}"
+                to_export.append((store, dataset, layer_name))
+
+        # Write to a temporary file first, so the previous checkout is left alone if anything goes wrong.
+        temp_path = self.output_path.with_name(self.output_path.name + ".part")
+        if temp_path.exists():
+            temp_path.unlink()
+        export_format = EXPORT_FORMATS["GPKG"]
+        with export_format.exporter(temp_path) as exporter:
+            for store, dataset, layer_name in to_export:
+                count = exporter.write_dataset(dataset, layer_name=layer_name)
+                click.echo(
+                    f"Wrote {count} features from {store.name}:{dataset.path} to {layer_name}"
+                )
+        temp_path.replace(self.output_path)
+        self.write_checkout_state({s.name: s.pinned_commit for s in self.stores})
+        click.echo(f"Checked out workspace to {self.output_path}")
+
+
+@add_help_subcommand
+@click.group(cls=KartGroup)
+@click.option(
+    "--manifest",
+    "-f",
+    "manifest_path",
+    type=click.Path(dir_okay=False),
+    default=DEFAULT_MANIFEST,
+    show_default=True,
+    help="The workspace manifest.",
+)
+@click.pass_context
+def workspace(ctx, manifest_path, **kwargs):
+    """
+    Assemble datasets from several Kart repositories into one multi-layer GeoPackage.
+
+    The datasets are listed in a manifest, workspace.yaml - much like git submodules, each repository is pinned to a
+    particular commit, so that the workspace can be rebuilt exactly. For example:
+
+    \b
+    output: roads-and-parcels.gpkg
+    stores:
+      - name: roads
+        url: https://example.com/kart/roads
+        ref: main
+        commit: 6e2984a28150330a6c51019a70f9e8fcfe405e8c
+        datasets:
+          - nz_roads
+          - nz_road_centrelines:centrelines
+      - name: parcels
+        url: /path/to/parcels-repo
+        datasets:
+          - nz_parcels
+
+    Each dataset can be given a new layer name as DATASET:LAYER. Any store without a pinned commit is pinned to the
+    commit that its ref - which defaults to HEAD - currently points to.
+    """
+
+
+def _load_workspace(ctx):
+    return Workspace(ctx.parent.params["manifest_path"])
+
+
+@workspace.command()
+@click.pass_context
+def checkout(ctx):
+    """Write every dataset in the workspace, at its pinned commit, to the output GeoPackage."""
+    _load_workspace(ctx).checkout()
+
+
+@workspace.command()
+@click.pass_context
+@click.option(
+    "--checkout/--no-checkout",
+    "do_checkout",
+    default=True,
+    help="Whether to check out the workspace once the stores are updated.",
+)
+@click.argument("stores", nargs=-1)
+def update(ctx, do_checkout, stores):
+    """
+    Fetch the given stores - or every store - and pin them to the latest commit of their ref.
+    The new commits are written to the manifest. Comments in the manifest are not preserved.
+    """
+    ws = _load_workspace(ctx)
+    changed = False
+    for store in ws.get_stores(stores):
+        store.fetch()
+        new_commit = store.resolve_ref().hex
+        old_commit = store.pinned_commit
+        if new_commit == old_commit:
+            click.echo(f"{store.name}: already up to date at {new_commit[:7]}")
+            continue
+        desc = f"{old_commit[:7]} -> " if old_commit else ""
+        click.echo(f"{store.name}: {desc}{new_commit[:7]}")
+        store.pinned_commit = new_commit
+        changed = True
+    if changed:
+        ws.save_manifest()
+    if do_checkout:
+        ws.checkout()
+
+
+@workspace.command()
+@click.pass_context
+@click.option(
+    "--output-format",
+    "-o",
+    type=click.Choice(["text", "json"]),
+    default="text",
+)
+@click.option(
+    "--fetch",
+    "do_fetch",
+    is_flag=True,
+    help="Fetch each store first, to compare its pinned commit with the latest upstream commit.",
+)
+def status(ctx, output_format, do_fetch):
+    """
+    Show which commit each store is pinned to, which commit was last checked out, and whether there are newer
+    commits available - as of the last fetch, unless --fetch is specified.
+    """
+    ws = _load_workspace(ctx)
+    checked_out = ws.read_checkout_state().get("stores", {})
+    result = []
+    for store in ws.stores:
+        info = {
+            "name": store.name,
+            "url": store.url,
+            "ref": store.ref,
+            "pinnedCommit": store.pinned_commit,
+            "checkedOutCommit": checked_out.get(store.name),
+            "datasets": store.definition["datasets"],
+            "latestCommit": None,
+            "commitsBehind": None,
+        }
+        if do_fetch:
+            store.fetch()
+        if store.is_cloned:
+            latest = store.resolve_ref()
+            info["latestCommit"] = latest.hex
+            if store.pinned_commit:
+                pinned = store.pinned()
+                info["commitsBehind"] = store.repo.ahead_behind(latest.id, pinned.id)[0]
+        result.append(info)
+
+    if output_format == "json":
+        dump_json_output(
+            {
+                "kart.workspace.status/v1": {
+                    "output": str(ws.output_path),
+                    "stores": result,
+                }
+            },
+            "-",
+        )
+        return
+
+    click.echo(f"Workspace: {ws.manifest_path}")
+    click.echo(f"Output: {ws.output_path}")
+    for info in result:
+        click.echo()
+        click.secho(f"{info['name']} ({info['url']})", bold=True)
+        pinned = info["pinnedCommit"]
+        click.echo(f"  Pinned to: {pinned[:7] if pinned else 'nothing yet'}")
+        checked_out_commit = info["checkedOutCommit"]
+        if checked_out_commit is None:
+            click.echo("  Not checked out")
+        elif checked_out_commit != pinned:
+            click.echo(
+                f"  Checked out at {checked_out_commit[:7]} - run `kart workspace checkout` to update"
+            )
+        if info["commitsBehind"]:
+            click.echo(
+                f"  {info['commitsBehind']} newer commits on {info['ref']} - run `kart workspace update` to pin them"
+            )
+        click.echo(f"  Datasets: {', '.join(info['datasets'])}")
@@ -0,0 +1,95 @@
+import json
+
+import pytest
+from osgeo import ogr
+
+from kart.exceptions import NO_CHANGES
+from kart.repo import KartRepo
+
+
+H = pytest.helpers.helpers()
+
+
+def test_archive_deleted_features(data_working_copy, cli_runner, tmp_path):
+    layer = H.POINTS.LAYER
+    with data_working_copy("points") as (repo_path, wc):
+        r = cli_runner.invoke(["config", "--add", "kart.archive.dataset", layer])
+        assert r.exit_code == 0, r.stderr
+
+        repo = KartRepo(repo_path)
+        with repo.working_copy.tabular.session() as sess:
+            sess.execute(f"DELETE FROM {layer} WHERE fid IN (1, 2, 3);")
+        r = cli_runner.invoke(["commit", "-m", "Delete three points"])
+        assert r.exit_code == 0, r.stderr
+
+        # The features are gone from the dataset, and the diff is an ordinary delete.
+        dataset = repo.datasets()[layer]
+        assert dataset.feature_count == H.POINTS.ROWCOUNT - 3
+        r = cli_runner.invoke(["diff", "HEAD^...HEAD", "-o", "json"])
+        assert r.exit_code == 0, r.stderr
+        feature_diff = json.loads(r.stdout)["kart.diff/v1+hexwkb"][layer]["feature"]
+        assert [set(d) for d in feature_diff] == [{"-"}] * 3
+
+        r = cli_runner.invoke(["archive", "list", "-o", "json"])
+        assert r.exit_code == 0, r.stderr
+        rows = json.loads(r.stdout)["kart.archive/v1"]
+        rows.sort(key=lambda row: row["pk"])
+        assert [(row["dataset"], row["pk"]) for row in rows] == [
+            (layer, 1),
+            (layer, 2),
+            (layer, 3),
+        ]
+        author = repo.head_commit.author
+        assert all(
+            row["deletedBy"] == f"{author.name} <{author.email}>" for row in rows
+        )
+        assert all(row["deletedAt"].endswith("Z") for row in rows)
+
+        path = tmp_path / "out.gpkg"
+        r = cli_runner.invoke(["export", path, "--include-archived"])
+        assert r.exit_code == 0, r.stderr
+        ogr_ds = ogr.Open(str(path))
+        ogr_layer = ogr_ds.GetLayerByName(layer)
+        assert ogr_layer.GetFeatureCount() == H.POINTS.ROWCOUNT
+        archived = ogr_layer.GetFeature(1)
+        assert archived.GetField("_archived_by") == rows[0]["deletedBy"]
+        assert ogr_layer.GetFeature(4).GetField("_archived_at") is None
+        ogr_ds = None
+
+        r = cli_runner.invoke(["export", tmp_path / "live.gpkg"])
+        assert r.exit_code == 0, r.stderr
+        ogr_ds = ogr.Open(str(tmp_path / "live.gpkg"))
+        ogr_layer = ogr_ds.GetLayerByName(layer)
+        assert ogr_layer.GetFeatureCount() == H.POINTS.ROWCOUNT - 3
+        assert ogr_layer.GetLayerDefn().GetFieldIndex("_archived_at") == -1
+        ogr_ds = None
+
+        r = cli_runner.invoke(["archive", "purge", layer, "1", "2"])
+        assert r.exit_code == 0, r.stderr
+        assert r.stdout.startswith(f"Purged 2 archived features from {layer}")
+        r = cli_runner.invoke(["archive", "list", "-o", "json"])
+        assert r.exit_code == 0, r.stderr
+        assert [row["pk"] for row in json.loads(r.stdout)["kart.archive/v1"]] == [3]
+
+        r = cli_runner.invoke(["archive", "purge", layer, "--before", "2000-01-01"])
+        assert r.exit_code == NO_CHANGES, r.stderr
+        r = cli_runner.invoke(["archive", "purge", layer, "--all"])
+        assert r.exit_code == 0, r.stderr
+        r = cli_runner.invoke(["archive", "list"])
+        assert r.exit_code == 0, r.stderr
+        assert r.stdout == ""
+
+
+def test_deletions_not_archived_by_default(data_working_copy, cli_runner):
+    layer = H.POINTS.LAYER
+    with data_working_copy("points") as (repo_path, wc):
+        repo = KartRepo(repo_path)
+        with repo.working_copy.tabular.session() as sess:
+            sess.execute(f"DELETE FROM {layer} WHERE fid = 1;")
+        r = cli_runner.invoke(["commit", "-m", "Delete a point"])
+        assert r.exit_code == 0, r.stderr
+
+        r = cli_runner.invoke(["archive", "list", "-o", "json"])
+        assert r.exit_code == 0, r.stderr
+        assert json.loads(r.stdout)["kart.archive/v1"] == []
+        assert "archive" not in repo.datasets()[layer].inner_tree
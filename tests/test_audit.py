@@ -0,0 +1,41 @@
+import json
+
+
+def test_audit_log(data_archive, cli_runner):
+    with data_archive("points"):
+        r = cli_runner.invoke(["audit", "log"])
+        assert r.exit_code == 42, r.stderr
+        assert "kart.audit.enabled" in r.stderr
+
+        r = cli_runner.invoke(["config", "kart.audit.enabled", "true"])
+        assert r.exit_code == 0, r.stderr
+
+        r = cli_runner.invoke(["commit-files", "-m", "first", "a=b"])
+        assert r.exit_code == 0, r.stderr
+        # Read-only commands aren't recorded:
+        r = cli_runner.invoke(["log"])
+        assert r.exit_code == 0, r.stderr
+        r = cli_runner.invoke(["branch", "other"])
+        assert r.exit_code == 0, r.stderr
+
+        r = cli_runner.invoke(["audit", "log", "-o", "json"])
+        assert r.exit_code == 0, r.stderr
+        events = json.loads(r.stdout)["kart.audit/v1"]
+        assert [e["operation"] for e in events] == ["branch", "commit-files"]
+
+        commit_event = events[1]
+        assert commit_event["commandLine"] == [
+            "kart",
+            "commit-files",
+            "-m",
+            "first",
+            "a=b",
+        ]
+        assert commit_event["user"] == "Kart Tester <kart-tester@example.com>"
+        head = commit_event["commit"]
+        assert commit_event["refs"]["refs/heads/main"]["new"] == head
+        assert events[0]["refs"] == {"refs/heads/other": {"old": None, "new": head}}
+
+        r = cli_runner.invoke(["audit", "log", "-n", "1"])
+        assert r.exit_code == 0, r.stderr
+        assert r.stdout.splitlines()[1] == "    kart branch other"
@@ -0,0 +1,70 @@
+import gzip
+import io
+import json
+import tarfile
+
+import pytest
+
+from kart.exceptions import INVALID_FILE_FORMAT
+from kart.repo import KartRepo
+
+
+H = pytest.helpers.helpers()
+
+
+def test_backup_and_restore(data_archive, cli_runner, tmp_path):
+    backup_path = tmp_path / "points.kartbackup"
+    with data_archive("points") as repo_path:
+        r = cli_runner.invoke(["branch", "other", "HEAD^"])
+        assert r.exit_code == 0, r.stderr
+        r = cli_runner.invoke(["config", "user.name", "Backup Tester"])
+        assert r.exit_code == 0, r.stderr
+
+        r = cli_runner.invoke(["backup", backup_path])
+        assert r.exit_code == 0, r.stderr
+
+    restored_path = tmp_path / "restored"
+    r = cli_runner.invoke(["restore-backup", backup_path, restored_path])
+    assert r.exit_code == 0, r.stderr
+    assert r.stdout.splitlines()[-1] == f"Restored repository to {restored_path}"
+
+    repo = KartRepo(restored_path)
+    assert repo.head_commit.hex == H.POINTS.HEAD_SHA
+    assert repo.head_branch == "refs/heads/main"
+    other = repo.lookup_reference("refs/heads/other")
+    assert other.target.hex == H.POINTS.HEAD1_SHA
+    assert repo.config["user.name"] == "Backup Tester"
+    assert "origin" not in repo.remotes.names()
+
+    r = cli_runner.invoke(["-C", restored_path, "status", "-o", "json"])
+    assert r.exit_code == 0, r.stderr
+    status = json.loads(r.stdout)["kart.status/v2"]
+    assert status["workingCopy"]["parts"]["tabular"]["status"] == "ok"
+
+
+def test_restore_corrupt_backup(data_archive, cli_runner, tmp_path):
+    backup_path = tmp_path / "points.kartbackup"
+    with data_archive("points"):
+        r = cli_runner.invoke(["backup", backup_path])
+        assert r.exit_code == 0, r.stderr
+
+    # Rewrite the backup with a tampered config file, but the original manifest.
+    corrupt_path = tmp_path / "corrupt.kartbackup"
+    with tarfile.open(backup_path, "r:gz") as src, tarfile.open(
+        corrupt_path, "w:gz"
+    ) as dst:
+        for member in src:
+            data = src.extractfile(member).read()
+            if member.name == "config":
+                data += b"\n[user]\n\tname = Mallory\n"
+                member.size = len(data)
+            dst.addfile(member, io.BytesIO(data))
+
+    r = cli_runner.invoke(["restore-backup", corrupt_path, tmp_path / "restored"])
+    assert r.exit_code == INVALID_FILE_FORMAT, r.stderr
+    assert "checksum mismatch for config" in r.stderr
+
+    not_a_backup = tmp_path / "not-a-backup"
+    not_a_backup.write_bytes(gzip.compress(b"hello"))
+    r = cli_runner.invoke(["restore-backup", not_a_backup, tmp_path / "restored"])
+    assert r.exit_code == INVALID_FILE_FORMAT, r.stderr
@@ -0,0 +1,77 @@
+import sys
+
+import pytest
+
+from kart.exceptions import INVALID_OPERATION
+from kart.repo import KartRepo
+
+
+H = pytest.helpers.helpers()
+
+
+def _make_commits(cli_runner, repo_path, count):
+    for i in range(count):
+        r = cli_runner.invoke(["meta", "set", H.POINTS.LAYER, f"title=Title {i}"])
+        assert r.exit_code == 0, r.stderr
+    repo = KartRepo(repo_path)
+    commits = []
+    commit = repo.head_commit
+    for i in range(count + 1):
+        commits.append(commit.hex)
+        commit = commit.parents[0]
+    return list(reversed(commits))
+
+
+def test_bisect_manual(data_archive, cli_runner):
+    with data_archive("points") as repo_path:
+        commits = _make_commits(cli_runner, repo_path, 4)
+
+        r = cli_runner.invoke(["bisect", "start", commits[-1], commits[0]])
+        assert r.exit_code == 0, r.stderr
+        assert r.stdout.startswith("Bisecting: 4 commits left to test. Next: ")
+
+        # Keep marking whichever commit is next - commits[2] onwards are bad.
+        while r.stdout.startswith("Bisecting"):
+            next_commit = r.stdout.split("Next: ")[1].split()[0]
+            verdict = (
+                "bad"
+                if any(c.startswith(next_commit) for c in commits[2:])
+                else "good"
+            )
+            r = cli_runner.invoke(["bisect", verdict, next_commit])
+            assert r.exit_code == 0, r.stderr
+        assert r.stdout.splitlines()[-1] == (
+            f"{commits[2][:7]} Update metadata for {H.POINTS.LAYER} is the first bad commit"
+        )
+
+        r = cli_runner.invoke(["bisect", "start", "HEAD"])
+        assert r.exit_code == INVALID_OPERATION, r.stderr
+        r = cli_runner.invoke(["bisect", "reset"])
+        assert r.exit_code == 0, r.stderr
+        r = cli_runner.invoke(["bisect", "good", "HEAD"])
+        assert r.exit_code == INVALID_OPERATION, r.stderr
+
+
+def test_bisect_run(data_archive, cli_runner, tmp_path):
+    with data_archive("points") as repo_path:
+        commits = _make_commits(cli_runner, repo_path, 6)
+        bad_commits = commits[4:]
+        script = tmp_path / "check.py"
+        script.write_text(
+            "\n".join(
+                [
+                    "import os, sqlite3, sys",
+                    "db = sqlite3.connect(os.environ['KART_BISECT_GPKG'])",
+                    f"db.execute('SELECT COUNT(*) FROM {H.POINTS.LAYER}').fetchone()",
+                    f"sys.exit(1 if os.environ['KART_BISECT_COMMIT'] in {bad_commits!r} else 0)",
+                ]
+            )
+        )
+
+        r = cli_runner.invoke(["bisect", "start", "HEAD", commits[0]])
+        assert r.exit_code == 0, r.stderr
+        r = cli_runner.invoke(["bisect", "run", f'"{sys.executable}" "{script}"'])
+        assert r.exit_code == 0, r.stderr
+        assert r.stdout.splitlines()[-1] == (
+            f"{commits[4][:7]} Update metadata for {H.POINTS.LAYER} is the first bad commit"
+        )
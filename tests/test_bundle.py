@@ -0,0 +1,41 @@
+import pytest
+
+from kart.bundle import read_bundle_header
+from kart.exceptions import NO_CHANGES, NO_COMMIT
+from kart.repo import KartRepo
+
+
+H = pytest.helpers.helpers()
+
+
+def test_bundle_create_and_fetch(data_archive, cli_runner, tmp_path, chdir):
+    bundle_path = tmp_path / "changes.bundle"
+    with data_archive("points") as repo_path:
+        repo = KartRepo(repo_path)
+        head = repo.head_commit
+        parent = head.parents[0]
+
+        r = cli_runner.invoke(["bundle", "create", bundle_path, "--since", "HEAD"])
+        assert r.exit_code == NO_CHANGES, r.stderr
+
+        r = cli_runner.invoke(["bundle", "create", bundle_path, "--since", "HEAD^"])
+        assert r.exit_code == 0, r.stderr
+        prerequisites, refs = read_bundle_header(bundle_path)
+        assert prerequisites == [parent.hex]
+        assert refs == {"refs/heads/main": head.hex}
+
+        # A repository that doesn't have the prerequisite commit can't fetch the bundle.
+        r = cli_runner.invoke(["init", tmp_path / "empty"])
+        assert r.exit_code == 0, r.stderr
+        with chdir(tmp_path / "empty"):
+            r = cli_runner.invoke(["bundle", "fetch", bundle_path])
+            assert r.exit_code == NO_COMMIT, r.stderr
+            assert parent.hex in r.stderr
+
+        # One that does can.
+        r = cli_runner.invoke(["bundle", "fetch", bundle_path, "--remote", "office"])
+        assert r.exit_code == 0, r.stderr
+        assert r.stdout.splitlines() == [
+            f"{head.hex[:7]}  refs/heads/main -> refs/remotes/office/main"
+        ]
+        assert repo.references["refs/remotes/office/main"].target == head.id
@@ -0,0 +1,66 @@
+import json
+
+import pytest
+
+from kart.changed_tiles import tile_ranges_for_envelope
+from kart.exceptions import INVALID_OPERATION
+from kart.repo import KartRepo
+
+
+H = pytest.helpers.helpers()
+
+
+@pytest.mark.parametrize(
+    "envelope,zoom,expected",
+    [
+        ((-180, -85, 180, 85), 0, [(0, 0, 0, 0)]),
+        ((-180, -85, 180, 85), 1, [(0, 1, 0, 1)]),
+        ((174.7, -41.3, 174.8, -41.2), 2, [(3, 3, 2, 2)]),
+        # Crosses the anti-meridian:
+        ((170, -10, -170, 10), 2, [(3, 3, 1, 2), (0, 0, 1, 2)]),
+    ],
+)
+def test_tile_ranges_for_envelope(envelope, zoom, expected):
+    assert tile_ranges_for_envelope(envelope, zoom) == expected
+
+
+def test_changed_tiles(data_archive_readonly, cli_runner):
+    with data_archive_readonly("points") as repo_path:
+        # All of the points are in New Zealand.
+        r = cli_runner.invoke(["changed-tiles", "HEAD^", "HEAD", "--max-zoom", "2"])
+        assert r.exit_code == 0, r.stderr
+        assert r.stdout.splitlines() == ["0/0/0", "1/1/1", "2/3/2"]
+
+        r = cli_runner.invoke(
+            [
+                "changed-tiles",
+                "HEAD^",
+                "HEAD",
+                "--min-zoom",
+                "1",
+                "--max-zoom",
+                "2",
+                "-o",
+                "json",
+            ]
+        )
+        assert r.exit_code == 0, r.stderr
+        repo = KartRepo(repo_path)
+        assert json.loads(r.stdout) == {
+            "kart.changed-tiles/v1": {
+                "base": repo.head_commit.parents[0].hex,
+                "target": repo.head_commit.hex,
+                "minZoom": 1,
+                "maxZoom": 2,
+                "tiles": ["1/1/1", "2/3/2"],
+            }
+        }
+
+        r = cli_runner.invoke(["changed-tiles", "HEAD", "HEAD"])
+        assert r.exit_code == 0, r.stderr
+        assert r.stdout == ""
+
+        r = cli_runner.invoke(
+            ["changed-tiles", "HEAD^", "HEAD", "--max-zoom", "20", "--max-tiles", "5"]
+        )
+        assert r.exit_code == INVALID_OPERATION, r.stderr
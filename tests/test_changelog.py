@@ -0,0 +1,39 @@
+import json
+
+import pytest
+
+
+H = pytest.helpers.helpers()
+
+
+def test_changelog(data_archive_readonly, cli_runner):
+    layer = H.POINTS.LAYER
+    with data_archive_readonly("points"):
+        r = cli_runner.invoke(["changelog", "--since=HEAD^", "-o", "json"])
+        assert r.exit_code == 0, r.stderr
+        changelog = json.loads(r.stdout)["kart.changelog/v1"]
+        assert changelog["until"]["commit"] == H.POINTS.HEAD_SHA
+        summary = changelog["datasets"][layer]
+        assert summary["status"] == "modified"
+        assert summary["features"] == {"updates": 5}
+        assert summary["featureCount"] == {
+            "old": H.POINTS.ROWCOUNT,
+            "new": H.POINTS.ROWCOUNT,
+        }
+        assert len(summary["extent"]["new"]) == 4
+        assert "schema" not in summary
+
+        r = cli_runner.invoke(
+            ["changelog", "--since=HEAD^", "-o", "markdown", "--no-extent"]
+        )
+        assert r.exit_code == 0, r.stderr
+        assert r.stdout.splitlines()[1:] == [
+            "",
+            f"## {layer}",
+            "",
+            "- 5 features modified",
+        ]
+
+        r = cli_runner.invoke(["changelog", "--since=HEAD", "--until=HEAD"])
+        assert r.exit_code == 0, r.stderr
+        assert r.stdout.splitlines()[-1] == "  No changes"
@@ -0,0 +1,59 @@
+import json
+
+import pytest
+
+from kart.exceptions import INVALID_OPERATION
+
+
+H = pytest.helpers.helpers()
+
+
+def test_changes_ndjson(data_archive_readonly, cli_runner):
+    layer = H.POINTS.LAYER
+    with data_archive_readonly("points"):
+        r = cli_runner.invoke(["changes", "--since=HEAD^", "-o", "ndjson"])
+        assert r.exit_code == 0, r.stderr
+        events = [json.loads(line) for line in r.stdout.splitlines()]
+        assert [e["type"] for e in events] == [
+            "version",
+            "commit",
+            "feature",
+            "feature",
+            "feature",
+            "feature",
+            "feature",
+            "checkpoint",
+        ]
+        head = events[-1]["commit"]
+        assert events[0]["until"] == head
+        assert events[1]["value"]["commit"] == head
+
+        features = events[2:-1]
+        assert {e["dataset"] for e in features} == {layer}
+        assert {e["operation"] for e in features} == {"update"}
+        keys = [e["key"] for e in features]
+        assert keys == sorted(keys)
+        [feature] = [e for e in features if e["key"] == 1095]
+        assert feature["change"]["+"]["name"] == "Harataunga (Rākairoa)"
+
+        # Consuming from the checkpoint gives no more changes:
+        r = cli_runner.invoke(["changes", f"--since={head}", "-o", "ndjson"])
+        assert r.exit_code == 0, r.stderr
+        types = [json.loads(line)["type"] for line in r.stdout.splitlines()]
+        assert types == ["version", "checkpoint"]
+
+        # Changes can only be streamed forwards:
+        r = cli_runner.invoke(["changes", "--since=HEAD", "--until=HEAD^"])
+        assert r.exit_code == INVALID_OPERATION, r.stderr
+
+
+def test_changes_filter(data_archive_readonly, cli_runner):
+    layer = H.POINTS.LAYER
+    with data_archive_readonly("points"):
+        r = cli_runner.invoke(
+            ["changes", "--since=HEAD^", "-o", "ndjson", f"{layer}:1095"]
+        )
+        assert r.exit_code == 0, r.stderr
+        events = [json.loads(line) for line in r.stdout.splitlines()]
+        assert [e["key"] for e in events if e["type"] == "feature"] == [1095]
+
@@ -1,7 +1,12 @@
 import pytest
 
 
-from kart.exceptions import UNCOMMITTED_CHANGES, NO_BRANCH, NO_COMMIT
+from kart.exceptions import (
+    INVALID_OPERATION,
+    UNCOMMITTED_CHANGES,
+    NO_BRANCH,
+    NO_COMMIT,
+)
 from kart.repo import KartRepo
 from kart.structs import CommitWithReference
 
@@ -126,6 +131,49 @@ def test_reset(data_working_copy, cli_runner, edit_points):
         ]
 
 
+def test_deletion_guardrails(data_working_copy, cli_runner):
+    layer = H.POINTS.LAYER
+    with data_working_copy("points") as (repo_path, wc):
+        repo = KartRepo(repo_path)
+        r = cli_runner.invoke(["checkout", "-b", "wrong"])
+        assert r.exit_code == 0, r.stderr
+        with repo.working_copy.tabular.session() as sess:
+            sess.execute(f"DELETE FROM {layer} WHERE fid > 100;")
+        r = cli_runner.invoke(["commit", "-m", "Delete most points"])
+        assert r.exit_code == 0, r.stderr
+        r = cli_runner.invoke(["checkout", "main"])
+        assert r.exit_code == 0, r.stderr
+
+        r = cli_runner.invoke(["config", "kart.guardrails.maxDeletedPercent", "30"])
+        assert r.exit_code == 0, r.stderr
+        for command in ("checkout", "switch", "reset"):
+            r = cli_runner.invoke([command, "wrong"])
+            assert r.exit_code == INVALID_OPERATION, r.stderr
+            assert f"{layer}: " in r.stderr
+            assert f" of {H.POINTS.ROWCOUNT} features" in r.stderr
+            assert "Use --yes to continue anyway" in r.stderr
+        assert repo.head_branch == "refs/heads/main"
+
+        r = cli_runner.invoke(["switch", "wrong", "--yes"])
+        assert r.exit_code == 0, r.stderr
+        # Going back only adds features.
+        r = cli_runner.invoke(["switch", "main"])
+        assert r.exit_code == 0, r.stderr
+
+        r = cli_runner.invoke(
+            ["config", "--unset", "kart.guardrails.maxDeletedPercent"]
+        )
+        assert r.exit_code == 0, r.stderr
+        r = cli_runner.invoke(["config", "kart.guardrails.maxDeletedFeatures", "10"])
+        assert r.exit_code == 0, r.stderr
+        r = cli_runner.invoke(["restore", "--source", "wrong"])
+        assert r.exit_code == INVALID_OPERATION, r.stderr
+        r = cli_runner.invoke(["restore", "--source", "wrong", "-y"])
+        assert r.exit_code == 0, r.stderr
+        r = cli_runner.invoke(["diff", "--exit-code"])
+        assert r.exit_code == 1
+
+
 def _check_workingcopy_contains_tables(repo, expected_tables):
     with repo.working_copy.tabular.session() as sess:
         r = sess.execute("""SELECT name FROM sqlite_master SM WHERE type='table';""")
@@ -0,0 +1,117 @@
+import json
+
+import pytest
+from osgeo import ogr
+
+from kart.exceptions import NO_CHANGES
+from kart.repo import KartRepo
+from kart.tabular.clean_geometry import GeometryCleaner, _signed_area
+
+
+H = pytest.helpers.helpers()
+
+
+def _clean_wkt(wkt, **kwargs):
+    cleaned = GeometryCleaner(**kwargs).clean(ogr.CreateGeometryFromWkt(wkt))
+    return cleaned.ExportToIsoWkt()
+
+
+def test_geometry_cleaner():
+    assert (
+        _clean_wkt("LINESTRING (0.12 0.18,0.31 0.29,1.04 1.0)", snap_grid=0.5)
+        == "LINESTRING (0 0,0.5 0.5,1 1)"
+    )
+    assert (
+        _clean_wkt(
+            "LINESTRING Z (0 0 1,1 1 2,1 1 2,2 2 3)", remove_duplicate_vertices=True
+        )
+        == "LINESTRING Z (0 0 1,1 1 2,2 2 3)"
+    )
+    # Vertices which are snapped together are removed as duplicates.
+    assert (
+        _clean_wkt(
+            "LINESTRING (0 0,1.1 1.1,0.9 0.9,2 2)",
+            snap_grid=1,
+            remove_duplicate_vertices=True,
+        )
+        == "LINESTRING (0 0,1 1,2 2)"
+    )
+    assert (
+        _clean_wkt(
+            "POLYGON ((0 0,0 10,10 10,10 0,0 0),(1 1,2 1,2 2,1 2,1 1))", force_ccw=True
+        )
+        == "POLYGON ((0 0,10 0,10 10,0 10,0 0),(1 1,1 2,2 2,2 1,1 1))"
+    )
+    assert (
+        _clean_wkt("MULTIPOLYGON (((0 0,1 0,1 1,0 0)))", force_ccw=True)
+        == "MULTIPOLYGON (((0 0,1 0,1 1,0 0)))"
+    )
+    assert _clean_wkt("POINT EMPTY", snap_grid=1) == "POINT EMPTY"
+
+
+def test_clean_geometry(data_working_copy, cli_runner):
+    with data_working_copy("points") as (repo_path, wc_path):
+        repo = KartRepo(repo_path)
+        old_head = repo.head_commit.id.hex
+        args = ["clean-geometry", H.POINTS.LAYER, "--snap-grid=0.01"]
+
+        r = cli_runner.invoke([*args, "--dry-run", "-o", "json"])
+        assert r.exit_code == 0, r.stderr
+        feature_diff = json.loads(r.stdout)["kart.diff/v1+hexwkb"][H.POINTS.LAYER][
+            "feature"
+        ]
+        expected_pks = [d["+"]["fid"] for d in feature_diff]
+        assert expected_pks
+        assert repo.head_commit.id.hex == old_head
+
+        r = cli_runner.invoke([*args, "-m", "Snap points", "-o", "json"])
+        assert r.exit_code == 0, r.stderr
+        jdict = json.loads(r.stdout)
+        assert jdict["kart.clean_geometry/v1"]["modified"] == expected_pks
+        assert repo.head_commit.message == "Snap points"
+
+        for feature in repo.datasets()[H.POINTS.LAYER].features():
+            geom = feature["geom"].to_ogr()
+            for value in (geom.GetX(), geom.GetY()):
+                assert value * 100 == pytest.approx(round(value * 100))
+
+        # Everything is on the grid now.
+        r = cli_runner.invoke([*args, "-m", "Snap points again"])
+        assert r.exit_code == NO_CHANGES, r.stderr
+
+
+def test_clean_geometry_force_ccw(data_working_copy, cli_runner):
+    with data_working_copy("polygons") as (repo_path, wc_path):
+        repo = KartRepo(repo_path)
+        r = cli_runner.invoke(
+            ["clean-geometry", H.POLYGONS.LAYER, "--force-ccw", "-m", "Force CCW"]
+        )
+        assert r.exit_code in (0, NO_CHANGES), r.stderr
+        if r.exit_code == 0:
+            assert "Cleaned the geometry of" in r.stdout
+
+        for feature in repo.datasets()[H.POLYGONS.LAYER].features():
+            geom = feature["geom"].to_ogr()
+            for i in range(geom.GetGeometryCount()):
+                polygon = geom.GetGeometryRef(i)
+                for j in range(polygon.GetGeometryCount()):
+                    ring = polygon.GetGeometryRef(j)
+                    area = _signed_area(ring.GetPoints())
+                    assert (area > 0) == (j == 0)
+
+
+def test_clean_geometry_errors(data_archive, cli_runner):
+    with data_archive("points"):
+        r = cli_runner.invoke(["clean-geometry", H.POINTS.LAYER, "-m", "x"])
+        assert r.exit_code == 2, r.stderr
+        assert "Specify at least one of" in r.stderr
+
+        r = cli_runner.invoke(["clean-geometry", H.POINTS.LAYER, "--snap-grid=0"])
+        assert r.exit_code == 2, r.stderr
+
+    with data_archive("table"):
+        r = cli_runner.invoke(
+            ["clean-geometry", H.TABLE.LAYER, "--force-ccw", "-m", "x"]
+        )
+        assert r.exit_code == 20, r.stderr
+        assert "it has no geometry" in r.stderr
@@ -1,5 +1,6 @@
 import contextlib
 import json
+import logging
 import os
 import re
 from pathlib import Path
@@ -38,6 +39,36 @@ def test_help_page_render(cli_runner, command):
     assert r.exit_code == 0, r.stderr
 
 
+def test_log_file(data_archive, cli_runner, tmp_path):
+    log_path = tmp_path / "kart.log"
+    with data_archive("points"):
+        r = cli_runner.invoke(["--verbosity", "debug", "-v", "status"])
+        assert r.exit_code == 2, r.stderr
+        assert "mutually exclusive" in r.stderr
+
+        r = cli_runner.invoke(
+            ["--log-file", log_path, "--log-format", "json", "status"]
+        )
+        assert r.exit_code == 0, r.stderr
+
+    root = logging.getLogger()
+    try:
+        logging.getLogger("kart.test").info(
+            "Imported %d features", 5, extra={"dataset": "points"}
+        )
+    finally:
+        for handler in list(root.handlers):
+            if getattr(handler, "is_kart_log_file", False):
+                root.removeHandler(handler)
+                handler.close()
+
+    records = [json.loads(line) for line in log_path.read_text().splitlines()]
+    assert records[-1]["level"] == "INFO"
+    assert records[-1]["logger"] == "kart.test"
+    assert records[-1]["message"] == "Imported 5 features"
+    assert records[-1]["dataset"] == "points"
+
+
 @pytest.fixture
 def sys_path_reset(monkeypatch):
     """A context manager to save & reset after code that changes sys.path"""
@@ -0,0 +1,125 @@
+import json
+import time
+from datetime import datetime, timedelta, timezone
+
+import pygit2
+
+from kart.exceptions import NO_CHANGES
+from kart.repo import KartRepo
+
+
+def _days_ago_at(days, hour):
+    """A timestamp at the given hour (UTC) of the 15th of the month, around the given number of days ago."""
+    date = datetime.now(timezone.utc) - timedelta(days=days)
+    return int(date.replace(day=15, hour=hour, minute=0, second=0).timestamp())
+
+
+def _make_commits(repo, commit_times):
+    commits = {}
+    for name, commit_time in commit_times:
+        blob = repo.create_blob(name.encode())
+        tree_builder = repo.TreeBuilder()
+        tree_builder.insert("file", blob, pygit2.GIT_FILEMODE_BLOB)
+        signature = pygit2.Signature("Test", "test@example.com", commit_time, 0)
+        parents = [] if repo.head_is_unborn else [repo.head_commit.id]
+        commits[name] = repo.create_commit(
+            "HEAD", signature, signature, name, tree_builder.write(), parents
+        )
+    return commits
+
+
+def _first_parent_history(repo):
+    commits = []
+    commit = repo.head_commit
+    while commit is not None:
+        commits.append(commit)
+        commit = commit.parents[0] if commit.parents else None
+    return list(reversed(commits))
+
+
+def test_compact_history(tmp_path, cli_runner, chdir):
+    repo_path = tmp_path / "repo"
+    r = cli_runner.invoke(["init", repo_path])
+    assert r.exit_code == 0, r.stderr
+    repo = KartRepo(repo_path)
+
+    now = time.time()
+    old_commits = _make_commits(
+        repo,
+        [
+            # A year old - only the last commit of each month is kept.
+            ("a1", _days_ago_at(400, 10)),
+            ("a2", _days_ago_at(400, 11)),
+            # 100 days old - only the last commit of each day is kept.
+            ("b1", _days_ago_at(100, 10)),
+            ("b2", _days_ago_at(100, 11)),
+            ("b3", _days_ago_at(100, 12)),
+            ("c", _days_ago_at(100, 12) + 24 * 60 * 60),
+            # Recent - every commit is kept.
+            ("d1", int(now) - 2 * 24 * 60 * 60),
+            ("d2", int(now) - 60 * 60),
+        ],
+    )
+    repo.create_tag(
+        "v1",
+        old_commits["b2"],
+        pygit2.GIT_OBJ_COMMIT,
+        repo.committer_signature(),
+        "Version 1",
+    )
+    old_head = repo.head_commit.id
+
+    with chdir(repo_path):
+        r = cli_runner.invoke(["compact-history", "--dry-run", "-o", "json"])
+        assert r.exit_code == 0, r.stderr
+        plan = json.loads(r.stdout)["kart.compact_history/v1"]
+        assert plan["dryRun"] is True
+        assert [c["commit"] for c in plan["commits"]] == [
+            old_commits[name].hex for name in ("a2", "b2", "b3", "c", "d1", "d2")
+        ]
+        assert plan["commits"][0]["squashed"] == [old_commits["a1"].hex]
+        assert plan["commits"][1]["squashed"] == [old_commits["b1"].hex]
+        assert all(c["newCommit"] is None for c in plan["commits"])
+        assert repo.head_commit.id == old_head
+
+        r = cli_runner.invoke(["compact-history"])
+        assert r.exit_code == 0, r.stderr
+        assert r.stdout.splitlines()[0] == "Compacted 8 commits into 6:"
+
+        history = _first_parent_history(repo)
+        assert [c.message.splitlines()[0] for c in history] == [
+            "a2",
+            "b2",
+            "b3",
+            "c",
+            "d1",
+            "d2",
+        ]
+        assert history[0].message == "a2\n\nCompacted-Commits: 2\n"
+        assert history[-1].tree_id == repo[old_head].tree_id
+        # The tag was moved to the rewritten commit.
+        assert repo.references["refs/tags/v1"].peel(pygit2.Commit).id == history[1].id
+        assert repo.revparse_single("v1").message.strip() == "Version 1"
+
+        r = cli_runner.invoke(["compact-history"])
+        assert r.exit_code == NO_CHANGES, r.stderr
+
+        # Keeping everything from the last 200 days means there's nothing more to do.
+        r = cli_runner.invoke(["config", "kart.retention.keepAllDays", "200"])
+        assert r.exit_code == 0, r.stderr
+        r = cli_runner.invoke(["compact-history", "--keep-daily-days=300"])
+        assert r.exit_code == NO_CHANGES, r.stderr
+
+        # Keeping only monthly commits squashes the commits from 100 days ago.
+        r = cli_runner.invoke(
+            ["compact-history", "--keep-all-days=1", "--keep-daily-days=1"]
+        )
+        assert r.exit_code == 0, r.stderr
+        history = _first_parent_history(repo)
+        assert [c.message.splitlines()[0] for c in history] == [
+            "a2",
+            "b2",
+            "c",
+            "d1",
+            "d2",
+        ]
@@ -4,10 +4,13 @@ from pathlib import Path
 import subprocess
 
 import shellingham
+from click.shell_completion import ShellComplete
 
+from kart import cli
 from kart.cli_util import OutputFormatType
 from kart.completion_shared import (
     conflict_completer,
+    deleted_repo_path_completer,
     ref_completer,
     repo_path_completer,
 )
@@ -101,6 +104,37 @@ def test_path_completer(data_archive, cli_runner):
         assert repo_path_completer(incomplete="nz") == set(["nz_pa_points_topo_150k"])
 
 
+def test_completion_print(cli_runner):
+    r = cli_runner.invoke(["install", "tab-completion", "--shell", "bash", "--print"])
+    assert r.exit_code == 0, r.stderr
+    assert "complete -o nosort -F _kart_completion kart" in r.stdout
+    assert "completion installed in" not in r.stdout
+
+
+def _complete(args, incomplete):
+    cli.load_all_commands()
+    completer = ShellComplete(cli.cli, {}, "kart", "_KART_COMPLETE")
+    return [c.value for c in completer.get_completions(args, incomplete)]
+
+
+def test_dataset_argument_completion(data_archive, cli_runner):
+    with data_archive("points"):
+        for args in (
+            ["data", "show"],
+            ["meta", "get"],
+            ["style", "get"],
+            ["lock", "acquire"],
+            ["export", "out.gpkg"],
+        ):
+            assert _complete(args, "nz") == ["nz_pa_points_topo_150k"], args
+
+        assert deleted_repo_path_completer() == set()
+        r = cli_runner.invoke(["data", "rm", "nz_pa_points_topo_150k"])
+        assert r.exit_code == 0, r.stderr
+        assert repo_path_completer() == set()
+        assert _complete(["data", "undelete"], "") == ["nz_pa_points_topo_150k"]
+
+
 def test_conflict_completer(data_archive, cli_runner):
     with data_archive("conflicts/points.tgz") as _:
         r = cli_runner.invoke(["merge", "theirs_branch"])
@@ -1,12 +1,15 @@
 import json
+import sqlite3
+
 import pytest
+from osgeo import ogr
 
 from kart.merge_util import MergedIndex
 from kart.repo import KartRepo
 from kart.structs import CommitWithReference
 
 H = pytest.helpers.helpers()
-CONFLICTS_OUTPUT_FORMATS = ["text", "geojson", "json", "quiet"]
+CONFLICTS_OUTPUT_FORMATS = ["text", "geojson", "gpkg", "json", "quiet"]
 
 
 def test_merged_index_roundtrip(data_archive, cli_runner):
@@ -509,6 +512,54 @@ def test_conflicts_geojson_usage(data_archive, cli_runner, tmp_path):
         )
 
 
+def test_conflicts_gpkg(data_archive, cli_runner, tmp_path):
+    with data_archive("conflicts/polygons.tgz"):
+        r = cli_runner.invoke(["merge", "theirs_branch"])
+        assert r.exit_code == 0, r
+
+        # A file is required.
+        r = cli_runner.invoke(["conflicts", "-o", "gpkg"])
+        assert r.exit_code == 2, r.stderr
+        assert "Need to specify a file via --output for GPKG format" in r.stderr
+
+        path = tmp_path / "conflicts.gpkg"
+        r = cli_runner.invoke(["conflicts", "-o", "gpkg", f"--output={path}"])
+        assert r.exit_code == 0, r.stderr
+
+        layer = H.POLYGONS.LAYER
+        ogr_ds = ogr.Open(str(path))
+        layer_names = {
+            ogr_ds.GetLayer(i).GetName() for i in range(ogr_ds.GetLayerCount())
+        }
+        assert {f"{layer}_ours", f"{layer}_theirs", "layer_styles"} <= layer_names
+        for version in ("ours", "theirs"):
+            ogr_layer = ogr_ds.GetLayerByName(f"{layer}_{version}")
+            assert sorted(f.GetFID() for f in ogr_layer) == [
+                98001,
+                1452332,
+                1456853,
+                1456912,
+            ]
+        ogr_ds = None
+
+        with sqlite3.connect(path) as db:
+            styles = dict(
+                db.execute("SELECT f_table_name, styleQML FROM layer_styles;")
+            )
+        assert styles.keys() == layer_names - {"layer_styles"}
+        assert 'v="227,26,28,255"' in styles[f"{layer}_theirs"]
+
+        # Writing again replaces the file.
+        r = cli_runner.invoke(
+            ["conflicts", "-o", "gpkg", f"--output={path}", f"{layer}:98001"]
+        )
+        assert r.exit_code == 0, r.stderr
+        ogr_ds = ogr.Open(str(path))
+        ogr_layer = ogr_ds.GetLayerByName(f"{layer}_ours")
+        assert [f.GetFID() for f in ogr_layer] == [98001]
+        ogr_ds = None
+
+
 def test_diff_during_merge(data_working_copy, cli_runner):
     with data_working_copy("conflicts/points.tgz") as (repo_path, wc_path):
         r = cli_runner.invoke(["merge", "theirs_branch"])
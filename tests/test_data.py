@@ -1,9 +1,12 @@
 import json
 from pathlib import Path
-from kart.exceptions import NO_REPOSITORY
+from kart.exceptions import INVALID_OPERATION, NO_REPOSITORY, NO_TABLE
+from kart.repo import KartRepo
 from kart import subprocess_util as subprocess
 import pytest
 
+H = pytest.helpers.helpers()
+
 
 @pytest.mark.parametrize("output_format", ("text", "json"))
 @pytest.mark.parametrize(
@@ -91,6 +94,77 @@ def test_data_rm(data_archive, cli_runner):
         assert r.stdout.splitlines() == ["nz_pa_points_topo_150k"]
 
 
+def test_data_undelete(data_archive, cli_runner):
+    with data_archive("points") as repo_path:
+        repo = KartRepo(repo_path)
+        orig_tree = repo.head_tree
+
+        r = cli_runner.invoke(["data", "rm", H.POINTS.LAYER, "-m", "deletion"])
+        assert r.exit_code == 0, r.stderr
+        r = cli_runner.invoke(["data", "undelete", "--list"])
+        assert r.exit_code == 0, r.stderr
+        assert r.stdout.startswith(f"{H.POINTS.LAYER}\tdeleted in ")
+
+        r = cli_runner.invoke(["data", "undelete", H.POINTS.LAYER])
+        assert r.exit_code == 0, r.stderr
+        assert repo.head_tree.id == orig_tree.id
+        assert repo.head_commit.message == f"Restore deleted dataset {H.POINTS.LAYER}"
+        r = cli_runner.invoke(["data", "undelete", H.POINTS.LAYER])
+        assert r.exit_code == NO_TABLE, r.stderr
+
+        # Tombstones that have expired are removed by gc.
+        r = cli_runner.invoke(["data", "rm", H.POINTS.LAYER, "-m", "deletion"])
+        assert r.exit_code == 0, r.stderr
+        r = cli_runner.invoke(["config", "kart.tombstones.expiryDays", "-1"])
+        assert r.exit_code == 0, r.stderr
+        r = cli_runner.invoke(["data", "undelete", H.POINTS.LAYER])
+        assert r.exit_code == INVALID_OPERATION, r.stderr
+        r = cli_runner.invoke(["gc"])
+        assert r.exit_code == 0, r.stderr
+        assert r.stdout.splitlines()[0] == (
+            f"Expired tombstone for deleted dataset {H.POINTS.LAYER}"
+        )
+        r = cli_runner.invoke(["data", "undelete", "--list"])
+        assert r.exit_code == 0, r.stderr
+        assert r.stdout == ""
+
+
+def test_data_mv(data_archive, cli_runner):
+    with data_archive("points"):
+        r = cli_runner.invoke(["log", "-o", "json", "--", "nz_pa_points_topo_150k"])
+        assert r.exit_code == 0, r.stderr
+        history = [c["commit"] for c in json.loads(r.stdout)]
+
+        r = cli_runner.invoke(["data", "mv", "nz_pa_points_topo_150k", "nz/points"])
+        assert r.exit_code == 0, r.stderr
+
+        r = cli_runner.invoke(["data", "ls"])
+        assert r.exit_code == 0, r.stderr
+        assert r.stdout.splitlines() == ["nz/points"]
+
+        r = cli_runner.invoke(["show", "-o", "json", "HEAD"])
+        assert r.exit_code == 0, r.stderr
+        message = json.loads(r.stdout)["kart.show/v1"]["message"]
+        assert message.splitlines() == [
+            "Rename dataset nz_pa_points_topo_150k to nz/points",
+            "",
+            "Renamed-Dataset: nz_pa_points_topo_150k -> nz/points",
+        ]
+
+        r = cli_runner.invoke(["log", "-o", "json", "--", "nz/points"])
+        assert r.exit_code == 0, r.stderr
+        assert len(json.loads(r.stdout)) == 1
+
+        r = cli_runner.invoke(["log", "-o", "json", "--follow", "--", "nz/points"])
+        assert r.exit_code == 0, r.stderr
+        assert [c["commit"] for c in json.loads(r.stdout)][1:] == history
+
+        r = cli_runner.invoke(["data", "mv", "nz_pa_points_topo_150k", "points"])
+        assert r.exit_code == NO_TABLE, r.stderr
+        r = cli_runner.invoke(["data", "mv", "nz/points", "nz/points"])
+        assert r.exit_code == INVALID_OPERATION, r.stderr
+
+
 @pytest.mark.parametrize("output_format", ("text", "json"))
 @pytest.mark.parametrize("version", (0, 1, 2, 3))
 def test_data_version(version, output_format, data_archive_readonly, cli_runner):
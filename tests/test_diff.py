@@ -8,6 +8,7 @@ import webbrowser
 
 import html5lib
 import pytest
+from osgeo import gdal, ogr
 
 import kart
 from kart.tabular.v3 import TableV3
@@ -743,6 +744,56 @@ def test_diff_polygons(output_format, data_working_copy, cli_runner):
             _check_html_output(r.stdout)
 
 
+def test_diff_measure(data_working_copy, cli_runner):
+    with data_working_copy("polygons") as (repo_path, wc):
+        repo = KartRepo(repo_path)
+        with repo.working_copy.tabular.session() as sess:
+            r = sess.execute(H.POLYGONS.INSERT, H.POLYGONS.RECORD)
+            assert r.rowcount == 1
+            r = sess.execute(
+                f"UPDATE {H.POLYGONS.LAYER} SET geom=:geom WHERE id=1424927;",
+                {"geom": H.POLYGONS.RECORD["geom"]},
+            )
+            assert r.rowcount == 1
+            r = sess.execute(
+                f"UPDATE {H.POLYGONS.LAYER} SET survey_reference='test' WHERE id=1443053;"
+            )
+            assert r.rowcount == 1
+
+        r = cli_runner.invoke(["diff", "--measure", "-o", "json-lines"])
+        assert r.exit_code == 0, r.stderr
+        changes = {}
+        for line in r.stdout.splitlines():
+            obj = json.loads(line)
+            if obj["type"] == "feature":
+                change = obj["change"]
+                pk = (change.get("+") or change["-"])["id"]
+                changes[pk] = obj["geodesic"]
+
+        # The Null Island square is 0.001 degrees across - about 111 metres east-west and 110 metres north-south.
+        inserted_area = changes[9_999_999]["+"]["area"]
+        assert 12_300 < inserted_area < 12_320
+        assert "-" not in changes[9_999_999]
+        assert changes[1424927]["+"]["area"] == pytest.approx(inserted_area)
+        assert changes[1424927]["-"]["area"] != pytest.approx(inserted_area)
+
+        r = cli_runner.invoke(["diff", "--measure"])
+        assert r.exit_code == 0, r.stderr
+        measurements = [
+            line for line in r.stdout.splitlines() if "(geodesic area:" in line
+        ]
+        # The feature whose geometry didn't change isn't measured:
+        assert len(measurements) == 2
+        assert measurements[0].startswith("  (geodesic area: ")
+        assert any(
+            "→" in line and ("shrank by" in line or "grew by" in line)
+            for line in measurements
+        )
+
+        r = cli_runner.invoke(["diff", "--measure", "-o", "geojson"])
+        assert r.exit_code == 2, r.stderr
+
+
 @pytest.mark.parametrize("output_format", DIFF_OUTPUT_FORMATS)
 def test_diff_table(output_format, data_working_copy, cli_runner):
     """diff the working copy against HEAD"""
@@ -1866,6 +1917,149 @@ def test_diff_streaming(data_archive_readonly):
             assert new.get_feature_calls == expected_calls
 
 
+def test_diff_limit_and_quiet(data_archive_readonly, cli_runner):
+    def feature_lines(r):
+        return [
+            line
+            for line in r.stdout.splitlines()
+            if json.loads(line)["type"] == "feature"
+        ]
+
+    with data_archive_readonly("points"):
+        r = cli_runner.invoke(["diff", "HEAD^...HEAD", "-o", "json-lines"])
+        assert r.exit_code == 0, r.stderr
+        num_changes = len(feature_lines(r))
+        assert num_changes > 2
+
+        r = cli_runner.invoke(
+            ["diff", "HEAD^...HEAD", "-o", "json-lines", "--limit", "2"]
+        )
+        assert r.exit_code == 0, r.stderr
+        assert len(feature_lines(r)) == 2
+        assert "only the first 2 changes are shown" in r.stderr
+
+        r = cli_runner.invoke(
+            ["diff", "HEAD^...HEAD", "-o", "json-lines", "--limit", str(num_changes)]
+        )
+        assert r.exit_code == 0, r.stderr
+        assert len(feature_lines(r)) == num_changes
+        assert "--limit" not in r.stderr
+
+        r = cli_runner.invoke(["diff", "HEAD^...HEAD", "--quiet"])
+        assert r.exit_code == 1, r.stderr
+        assert r.stdout == ""
+        r = cli_runner.invoke(["diff", "HEAD...HEAD", "--quiet"])
+        assert r.exit_code == 0, r.stderr
+
+
+def test_diff_summarize_by_region(data_archive_readonly, cli_runner, tmp_path):
+    layer = H.POINTS.LAYER
+    with data_archive_readonly("points"):
+        r = cli_runner.invoke(["diff", "HEAD^...HEAD", "-o", "json"])
+        assert r.exit_code == 0, r.stderr
+        diff = json.loads(r.stdout)["kart.diff/v1+hexwkb"]
+        num_changes = len(diff[layer]["feature"])
+
+        heatmap_path = tmp_path / "heatmap.gpkg"
+        r = cli_runner.invoke(
+            [
+                "diff",
+                "HEAD^...HEAD",
+                "-o",
+                "json",
+                "--summarize-by-grid",
+                "0.5",
+                "--heatmap",
+                heatmap_path,
+            ]
+        )
+        assert r.exit_code == 0, r.stderr
+        cells = json.loads(r.stdout)["kart.diff-summary/v1"][layer]
+        assert sum(c["total"] for c in cells) >= num_changes
+        for cell in cells:
+            min_x, min_y, max_x, max_y = cell["bbox"]
+            assert max_x - min_x == max_y - min_y == 0.5
+            assert cell["total"] == sum(
+                cell[t] for t in ("inserts", "updates", "deletes")
+            )
+
+        heatmap = ogr.Open(str(heatmap_path))
+        heatmap_layer = heatmap.GetLayerByName(layer)
+        assert heatmap_layer.GetFeatureCount() == len(cells)
+        assert heatmap_layer.GetSpatialRef().GetAuthorityCode(None) == "4326"
+        heatmap = None
+
+        regions_path = tmp_path / "regions.geojson"
+        regions_path.write_text(
+            json.dumps(
+                {
+                    "type": "FeatureCollection",
+                    "features": [
+                        {
+                            "type": "Feature",
+                            "properties": {"name": "everywhere"},
+                            "geometry": {
+                                "type": "Polygon",
+                                "coordinates": [
+                                    [
+                                        [-180, -90],
+                                        [180, -90],
+                                        [180, 90],
+                                        [-180, 90],
+                                        [-180, -90],
+                                    ]
+                                ],
+                            },
+                        }
+                    ],
+                }
+            )
+        )
+        r = cli_runner.invoke(
+            ["diff", "HEAD^...HEAD", "--summarize-by-regions", regions_path]
+        )
+        assert r.exit_code == 0, r.stderr
+        lines = r.stdout.splitlines()
+        assert lines[0] == f"{layer}:"
+        assert lines[1].startswith(f"\teverywhere: {num_changes} features changed (")
+
+        r = cli_runner.invoke(
+            ["diff", "HEAD^...HEAD", "-o", "geojson", "--summarize-by-grid", "1"]
+        )
+        assert r.exit_code == 2, r.stderr
+
+
+def test_diff_summarize_by_grid_after_crs_change(data_archive, cli_runner, tmp_path):
+    layer = H.POINTS.LAYER
+    with data_archive("gpkg-points") as data:
+        reprojected_path = tmp_path / "reprojected.gpkg"
+        gdal.VectorTranslate(
+            str(reprojected_path),
+            str(data / "nz-pa-points-topo-150k.gpkg"),
+            format="GPKG",
+            dstSRS="EPSG:2193",
+            layers=[layer],
+        )
+        with data_archive("points"):
+            r = cli_runner.invoke(
+                ["import", "--replace-existing", reprojected_path, layer]
+            )
+            assert r.exit_code == 0, r.stderr
+
+            r = cli_runner.invoke(
+                ["diff", "HEAD^...HEAD", "-o", "json", "--summarize-by-grid", "100000"]
+            )
+            assert r.exit_code == 0, r.stderr
+            cells = json.loads(r.stdout)["kart.diff-summary/v1"][layer]
+            assert sum(c["updates"] for c in cells) >= H.POINTS.ROWCOUNT
+            # The old geometries were reprojected into NZTM before being counted, so every cell is an NZTM cell -
+            # none of them are cells of longitude and latitude, near 0,0.
+            for cell in cells:
+                min_x, min_y, max_x, max_y = cell["bbox"]
+                assert 1_000_000 <= min_x < max_x <= 2_200_000
+                assert 4_700_000 <= min_y < max_y <= 6_300_000
+
+
 @pytest.mark.parametrize(
     "output_format", [o for o in SHOW_OUTPUT_FORMATS if o not in {"html", "quiet"}]
 )
@@ -1973,6 +2167,75 @@ def test_diff_geojson_usage(data_archive, cli_runner, tmp_path):
         )
 
 
+def test_diff_geojson_lines(data_archive, cli_runner, tmp_path):
+    with data_archive("points"):
+        r = cli_runner.invoke(["diff", "--output-format=geojson-lines", "HEAD^..."])
+        assert r.exit_code == 0, r.stderr
+        features = [json.loads(line) for line in r.stdout.splitlines()]
+        assert features
+        for feature in features:
+            assert feature["type"] == "Feature"
+            assert feature["id"].startswith("nz_pa_points_topo_150k:feature:")
+            change = feature["change"]
+            assert change in ("insert", "update", "delete")
+            assert feature["properties"] == {
+                "dataset": "nz_pa_points_topo_150k",
+                "change": change,
+            }
+            assert ("old" in feature) == (change != "insert")
+            assert ("new" in feature) == (change != "delete")
+            current = feature["old"] if change == "delete" else feature["new"]
+            assert feature["geometry"] == current["geometry"]
+            assert "fid" in current["properties"]
+
+        # Output to a file
+        output_path = tmp_path / "changes.geojsonl"
+        r = cli_runner.invoke(
+            [
+                "diff",
+                "--output-format=geojson-lines",
+                f"--output={output_path}",
+                "HEAD^...",
+            ]
+        )
+        assert r.exit_code == 0, r.stderr
+        assert len(output_path.read_text().splitlines()) == len(features)
+
+        # But not to a directory
+        r = cli_runner.invoke(
+            [
+                "diff",
+                "--output-format=geojson-lines",
+                f"--output={tmp_path}",
+                "HEAD^...",
+            ]
+        )
+        assert r.exit_code == 2, r.stderr
+
+
+def test_diff_arrow(data_archive, cli_runner, tmp_path):
+    pa = pytest.importorskip("pyarrow")
+    import pyarrow.ipc
+
+    with data_archive("points"):
+        r = cli_runner.invoke(
+            ["diff", "--output-format=arrow", f"--output={tmp_path}", "HEAD^..."]
+        )
+        assert r.exit_code == 0, r.stderr
+        with pa.OSFile(str(tmp_path / f"{H.POINTS.LAYER}.arrows"), "rb") as f:
+            table = pyarrow.ipc.open_stream(f).read_all()
+
+        assert table.schema.names[:2] == ["_change", "fid"]
+        assert table.schema.field("geom").metadata[
+            b"ARROW:extension:name"
+        ] == b"geoarrow.wkb"
+        changes = table.column("_change").to_pylist()
+        assert changes == ["U-", "U+"] * 5
+        fids = table.column("fid").to_pylist()
+        assert fids[0::2] == fids[1::2]
+        assert 1095 in fids
+
+
 @pytest.mark.parametrize(
     "output_format",
     [o for o in SHOW_OUTPUT_FORMATS if o not in {"html", "quiet"}],
@@ -2131,6 +2394,22 @@ def test_load_user_provided_html_template(data_archive, cli_runner, monkeypatch)
         assert r.exit_code == 0, r.stderr
 
 
+def test_diff_html_report(data_archive, cli_runner, monkeypatch, tmp_path):
+    opened = []
+    monkeypatch.setattr(webbrowser, "open_new", opened.append)
+    report_path = tmp_path / "report.html"
+    with data_archive("points"):
+        r = cli_runner.invoke(["diff", "HEAD^...", "--html", report_path])
+        assert r.exit_code == 0, r.stderr
+        assert opened == []
+        report = report_path.read_text()
+        assert H.POINTS.LAYER in report
+        assert '"type": "FeatureCollection"' in report
+
+        r = cli_runner.invoke(["diff", "HEAD^...", "--html", report_path, "-o", "json"])
+        assert r.exit_code == 2, r.stderr
+
+
 def test_xss_protection():
     TEMPLATE = """
 <html>
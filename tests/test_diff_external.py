@@ -0,0 +1,56 @@
+import json
+import sqlite3
+
+import pytest
+
+from kart.exceptions import NO_DATA
+from kart.repo import KartRepo
+
+
+H = pytest.helpers.helpers()
+
+
+def test_diff_external(data_archive, tmp_path, cli_runner, chdir):
+    layer = H.POINTS.LAYER
+    with data_archive("gpkg-points") as data:
+        gpkg_path = data / "nz-pa-points-topo-150k.gpkg"
+        repo_path = tmp_path / "repo"
+        r = cli_runner.invoke(["init", "--import", gpkg_path, str(repo_path)])
+        assert r.exit_code == 0, r.stderr
+
+        with chdir(repo_path):
+            r = cli_runner.invoke(
+                ["diff-external", layer, gpkg_path, layer, "--exit-code"]
+            )
+            assert r.exit_code == 0, r.stderr
+            assert r.stdout == ""
+            assert "No drift" in r.stderr
+
+            r = cli_runner.invoke(["diff-external", "nonexistent", gpkg_path, layer])
+            assert r.exit_code == NO_DATA, r.stderr
+
+            with sqlite3.connect(gpkg_path) as db:
+                db.execute(f"UPDATE {layer} SET name = 'Drifted' WHERE fid = 1;")
+                db.execute(f"DELETE FROM {layer} WHERE fid = 2;")
+
+            r = cli_runner.invoke(
+                ["diff-external", f"{layer}@HEAD", gpkg_path, layer, "-o", "json"]
+            )
+            assert r.exit_code == 0, r.stderr
+            feature_diff = json.loads(r.stdout)["kart.diff/v1+hexwkb"][layer][
+                "feature"
+            ]
+            [updated, deleted] = feature_diff
+            assert updated["-"]["fid"] == updated["+"]["fid"] == 1
+            assert updated["+"]["name"] == "Drifted"
+            assert deleted["-"]["fid"] == 2
+            assert "+" not in deleted
+
+            r = cli_runner.invoke(
+                ["diff-external", layer, gpkg_path, layer, "-o", "quiet"]
+            )
+            assert r.exit_code == 1, r.stderr
+
+            # Nothing was committed.
+            repo = KartRepo(repo_path)
+            assert len(list(repo.walk(repo.head_commit.id))) == 1
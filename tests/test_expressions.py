@@ -0,0 +1,135 @@
+import pytest
+
+from kart.geometry import Geometry
+from kart.tabular.expressions import Assignment, Expression, ExpressionError
+
+
+SQUARE = Geometry.from_wkt("POLYGON((0 0,10 0,10 10,0 10,0 0))")
+FEATURE = {
+    "fid": 1,
+    "name": "Main St",
+    "height_ft": 100,
+    "note": None,
+    "geom": SQUARE,
+}
+
+
+@pytest.mark.parametrize(
+    "text,expected",
+    [
+        ("height_ft * 0.3048", pytest.approx(30.48)),
+        ("height_ft // 3 + 1", 34),
+        ("-height_ft", -100),
+        ("upper(name)", "MAIN ST"),
+        ("replace(name, 'St', 'Street')", "Main Street"),
+        ("len(name)", 7),
+        ("round(height_ft / 3, 1)", 33.3),
+        ("coalesce(note, name)", "Main St"),
+        ("'tall' if height_ft > 50 else 'short'", "tall"),
+        ("name in ['Main St', 'High St']", True),
+        ("10 < height_ft <= 100", True),
+        ("height_ft > 50 and note is None", True),
+        ("not name", False),
+        ("height_ft ** 2", 10000.0),
+        # Operations on NULL give NULL, and comparisons with NULL are false.
+        ("note + 1", None),
+        ("not note", None),
+        ("upper(note)", None),
+        ("note > 1", False),
+        ("note == NULL", False),
+        ("note is NULL", True),
+    ],
+)
+def test_evaluate(text, expected):
+    assert Expression(text).evaluate(FEATURE) == expected
+
+
+def test_power_is_bounded():
+    # Powers are evaluated as floats, so that huge powers fail quickly instead of never finishing.
+    assert Expression("2 ** 0.5").evaluate(FEATURE) == pytest.approx(1.41421356)
+    with pytest.raises(ExpressionError, match="Can't evaluate"):
+        Expression("9 ** 9 ** 9").evaluate(FEATURE)
+    assert Expression("height_ft ** 2").infer_type({"height_ft": "integer"}) == "float"
+
+
+def test_geometry_functions():
+    def evaluate(text):
+        return Expression(text).evaluate(FEATURE)
+
+    assert evaluate("area(geom)") == 100
+    assert evaluate("length(geom)") == 40
+    assert evaluate("wkt(centroid(geom))") == "POINT (5 5)"
+    assert evaluate("x(centroid(geom))") == 5
+    assert evaluate("area(buffer(geom, 1))") == pytest.approx(100 + 40 + 3.14, abs=0.1)
+    assert evaluate("wkt(envelope(buffer(centroid(geom), 1)))") == (
+        "POLYGON ((4 4,6 4,6 6,4 6,4 4))"
+    )
+    assert evaluate("intersects(geom, geom_from_wkt('POINT(5 5)'))") is True
+    assert evaluate("contains(geom, geom_from_wkt('POINT(50 5)'))") is False
+    assert evaluate("within(geom_from_wkt('POINT(1 1)'), geom)") is True
+    assert evaluate("distance(geom, geom_from_wkt('POINT(13 14)'))") == 5
+    assert evaluate("geometry_type(geom)") == "POLYGON"
+    assert evaluate("num_points(geom_from_wkt('LINESTRING(0 0,1 1,2 2)'))") == 3
+    assert evaluate("is_valid(geom) and not is_empty(geom)") is True
+    assert evaluate("area(note)") is None
+    assert isinstance(evaluate("buffer(geom, 1)"), Geometry)
+
+    with pytest.raises(ExpressionError, match="Expected a geometry"):
+        evaluate("area(name)")
+
+
+def test_infer_type():
+    column_types = {
+        "fid": "integer",
+        "name": "text",
+        "height_ft": "float",
+        "geom": "geometry",
+    }
+
+    def infer_type(text):
+        return Expression(text).infer_type(column_types)
+
+    assert infer_type("fid + 1") == "integer"
+    assert infer_type("fid / 2") == "float"
+    assert infer_type("height_ft * 0.3048") == "float"
+    assert infer_type("name + '!'") == "text"
+    assert infer_type("area(geom) > 100") == "boolean"
+    assert infer_type("buffer(geom, 10)") == "geometry"
+    assert infer_type("round(height_ft)") == "float"
+    assert infer_type("coalesce(name, 'unnamed')") == "text"
+    assert infer_type("len(name)") == "integer"
+    assert infer_type("NULL") is None
+
+
+@pytest.mark.parametrize(
+    "text,message",
+    [
+        ("height_ft +", "invalid syntax"),
+        ("__import__('os')", "unknown function __import__"),
+        ("name.upper()", "unknown function"),
+        ("geom.wkt", "Attribute is not supported"),
+        ("[x for x in name]", "ListComp is not supported"),
+        ("lambda: 1", "Lambda is not supported"),
+        ("round(height_ft, ndigits=2)", "keyword arguments are not supported"),
+    ],
+)
+def test_invalid_expressions(text, message):
+    with pytest.raises(ExpressionError, match=message):
+        Expression(text)
+
+
+def test_check_fields():
+    expression = Expression("area(geom) / height_ft")
+    assert expression.field_names == {"geom", "height_ft"}
+    expression.check_fields(FEATURE.keys())
+    with pytest.raises(ExpressionError, match="no field called height_ft"):
+        expression.check_fields(["geom"])
+
+
+def test_assignment():
+    assignment = Assignment("height_m = height_ft * 0.3048")
+    assert assignment.field_name == "height_m"
+    assert assignment.expression.evaluate(FEATURE) == pytest.approx(30.48)
+    for text in ["height_ft == 1", "height_ft >= 1", "= 1", "1 = height_ft"]:
+        with pytest.raises(ExpressionError, match="expected FIELD = EXPRESSION"):
+            Assignment(text)
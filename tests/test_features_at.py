@@ -0,0 +1,64 @@
+import json
+
+import pytest
+
+from kart.exceptions import NO_DATA
+from kart.features_at import envelopes_intersect
+
+
+H = pytest.helpers.helpers()
+
+# A small bounding box around feature 3 - "Tauwhare Pa".
+BBOX = "177.0712,-37.9795,177.0713,-37.9794"
+
+
+@pytest.mark.parametrize(
+    "a,b,expected",
+    [
+        ((0, 0, 10, 10), (5, 5, 15, 15), True),
+        ((0, 0, 10, 10), (11, 0, 15, 10), False),
+        ((0, 0, 10, 10), (5, 5, 5, 5), True),
+        ((0, 0, 10, 10), (10, 10, 10, 10), True),
+        ((0, 0, 10, 10), (0, 11, 10, 15), False),
+        # Crosses the anti-meridian:
+        ((170, 0, -170, 10), (175, 5, 175, 5), True),
+        ((170, 0, -170, 10), (-175, 5, -175, 5), True),
+        ((170, 0, -170, 10), (0, 5, 0, 5), False),
+    ],
+)
+def test_envelopes_intersect(a, b, expected):
+    assert envelopes_intersect(a, b) == expected
+    assert envelopes_intersect(b, a) == expected
+
+
+@pytest.mark.parametrize("use_index", [False, True])
+def test_features_at_bbox(use_index, data_archive, cli_runner):
+    with data_archive("points"):
+        if use_index:
+            r = cli_runner.invoke(["spatial-filter", "index"])
+            assert r.exit_code == 0, r.stderr
+
+        r = cli_runner.invoke(["features-at", H.POINTS.LAYER, "--bbox", BBOX])
+        assert r.exit_code == 0, r.stderr
+        assert ("spatial index doesn't cover" in r.stderr) != use_index
+        output = json.loads(r.stdout)
+        assert output["type"] == "FeatureCollection"
+        assert [f["id"] for f in output["features"]] == ["3"]
+        assert output["features"][0]["properties"]["name"] == "Tauwhare Pa"
+        assert output["features"][0]["geometry"]["type"] == "Point"
+
+        r = cli_runner.invoke(
+            ["features-at", f"{H.POINTS.LAYER}@HEAD^", "--point", "0,0"]
+        )
+        assert r.exit_code == 0, r.stderr
+        assert json.loads(r.stdout) == {"type": "FeatureCollection", "features": []}
+
+
+def test_features_at_errors(data_archive_readonly, cli_runner):
+    with data_archive_readonly("points"):
+        r = cli_runner.invoke(["features-at", H.POINTS.LAYER])
+        assert r.exit_code == 2, r.stderr
+        r = cli_runner.invoke(["features-at", H.POINTS.LAYER, "--bbox", "1,2,3"])
+        assert r.exit_code == 2, r.stderr
+        r = cli_runner.invoke(["features-at", "nonexistent", "--point", "0,0"])
+        assert r.exit_code == NO_DATA, r.stderr
@@ -0,0 +1,57 @@
+import errno
+import os
+
+import pytest
+
+from kart import fs_util
+from kart.exceptions import FILE_LOCKED, InvalidOperation
+
+
+def test_long_path(monkeypatch):
+    short_path = "C:\\data\\my.gpkg"
+    long_path = "C:\\data\\" + "x" * 300 + "\\my.gpkg"
+    unc_path = "\\\\server\\share\\" + "x" * 300 + "\\my.gpkg"
+
+    monkeypatch.setattr(fs_util, "is_windows", False)
+    assert fs_util.long_path(long_path) == long_path
+
+    monkeypatch.setattr(fs_util, "is_windows", True)
+    monkeypatch.setattr(os.path, "abspath", lambda p: p)
+    assert fs_util.long_path(short_path) == short_path
+    assert fs_util.long_path(long_path) == "\\\\?\\" + long_path
+    assert fs_util.long_path("\\\\?\\" + long_path) == "\\\\?\\" + long_path
+    assert fs_util.long_path(unc_path) == "\\\\?\\UNC\\" + unc_path[2:]
+
+
+def test_retry_if_locked(monkeypatch):
+    monkeypatch.setattr(fs_util, "is_windows", False)
+    monkeypatch.setenv("KART_LOCK_RETRIES", "2")
+    monkeypatch.setenv("KART_LOCK_RETRY_DELAY", "0")
+    monkeypatch.setattr(
+        fs_util, "find_processes_holding_file", lambda p: [(123, "qgis")]
+    )
+
+    calls = []
+
+    def locked_twice(path):
+        calls.append(path)
+        if len(calls) <= 2:
+            raise OSError(errno.EBUSY, "Device or resource busy")
+        return "done"
+
+    assert fs_util.retry_if_locked(locked_twice, "my.gpkg") == "done"
+    assert calls == ["my.gpkg"] * 3
+
+    def always_locked(path):
+        raise OSError(errno.EBUSY, "Device or resource busy")
+
+    with pytest.raises(InvalidOperation) as e:
+        fs_util.retry_if_locked(always_locked, "my.gpkg")
+    assert e.value.exit_code == FILE_LOCKED
+    assert "PID 123 (qgis)" in str(e.value)
+
+    def not_found(path):
+        raise FileNotFoundError(errno.ENOENT, "No such file or directory")
+
+    with pytest.raises(FileNotFoundError):
+        fs_util.retry_if_locked(not_found, "my.gpkg")
@@ -1,4 +1,7 @@
+import subprocess
+
 import pytest
+from kart.fsck import _find_damaged_objects
 from kart.repo import KartRepo
 
 
@@ -32,3 +35,61 @@ def test_fsck(data_working_copy, cli_runner):
 
         r = cli_runner.invoke(["fsck"])
         assert r.exit_code == 0, r
+
+
+def test_find_damaged_objects():
+    corrupt_oid = "ab" + "c" * 38
+    missing_oid = "12" + "3" * 38
+    linked_oid = "45" + "6" * 38
+    output = "\n".join(
+        [
+            f"error: hash mismatch for ./objects/ab/{'c' * 38} (expected {corrupt_oid})",
+            f"error: {corrupt_oid}: object corrupt or missing: ./objects/ab/{'c' * 38}",
+            f"missing blob {missing_oid}",
+            f"broken link from    tree {'7' * 40}",
+            f"              to    blob {linked_oid}",
+            f"dangling commit {'8' * 40}",
+        ]
+    )
+    assert _find_damaged_objects(output) == ({corrupt_oid}, {missing_oid, linked_oid})
+
+
+def test_fsck_repair_from(data_archive, cli_runner, tmp_path):
+    with data_archive("points") as remote_path:
+        clone_path = tmp_path / "clone"
+        r = cli_runner.invoke(
+            ["clone", "--no-checkout", f"file://{remote_path}", clone_path]
+        )
+        assert r.exit_code == 0, r.stderr
+        repo = KartRepo(clone_path)
+
+        # Unpack every object, so that one can be corrupted without damaging a whole pack.
+        for pack in (repo.gitdir_path / "objects" / "pack").glob("*.pack"):
+            moved_pack = tmp_path / pack.name
+            pack.rename(moved_pack)
+            pack.with_suffix(".idx").unlink()
+            with open(moved_pack, "rb") as f:
+                subprocess.run(
+                    ["git", "-C", repo.path, "unpack-objects", "-q"],
+                    stdin=f,
+                    check=True,
+                )
+
+        dataset = repo.datasets()[H.POINTS.LAYER]
+        feature, blob = next(dataset.features_plus_blobs())
+        oid = blob.id.hex
+        object_path = repo.gitdir_path / "objects" / oid[:2] / oid[2:]
+        object_path.chmod(0o644)
+        object_path.write_bytes(b"not a git object")
+        quarantine_path = repo.gitdir_path / "objects-corrupt"
+        repo = None
+
+        r = cli_runner.invoke(["-C", clone_path, "fsck"])
+        assert r.exit_code == 1, r.stderr
+        assert "✘ Repository has 1 corrupt and 0 missing objects" in r.stdout
+
+        r = cli_runner.invoke(["-C", clone_path, "fsck", "--repair-from=origin"])
+        assert r.exit_code == 0, r.stderr
+        assert "✔︎ Repository repaired from origin" in r.stdout
+        quarantined = list(quarantine_path.glob("*/*"))
+        assert [p.name for p in quarantined] == [oid]
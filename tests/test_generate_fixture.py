@@ -0,0 +1,81 @@
+import pytest
+from osgeo import ogr
+
+from kart.exceptions import INVALID_OPERATION
+from kart.repo import KartRepo
+
+
+def _read_layer(path, layer_name):
+    ogr_ds = ogr.Open(str(path))
+    layer = ogr_ds.GetLayerByName(layer_name)
+    return [
+        (f.GetFID(), f.GetGeometryRef().ExportToWkt(), *f.items().values())
+        for f in layer
+    ]
+
+
+@pytest.mark.parametrize("geometry_type", ["point", "line", "polygon"])
+def test_generate_fixture(geometry_type, tmp_path, cli_runner):
+    paths = [tmp_path / f"{name}.gpkg" for name in ("a", "b", "c")]
+    for path, seed in zip(paths, ("42", "42", "43")):
+        r = cli_runner.invoke(
+            [
+                "generate-fixture",
+                "--features=200",
+                f"--geometry={geometry_type}",
+                f"--seed={seed}",
+                path,
+            ]
+        )
+        assert r.exit_code == 0, r.stderr
+        assert r.stdout.splitlines() == [
+            f"Wrote 200 {geometry_type} features to {path}"
+        ]
+
+    a, b, c = [_read_layer(path, "fixture") for path in paths]
+    assert len(a) == 200
+    assert a == b
+    assert a != c
+    assert [f[0] for f in a] == list(range(1, 201))
+    assert {f[1].split(" ")[0] for f in a} == {
+        {"point": "POINT", "line": "LINESTRING", "polygon": "POLYGON"}[geometry_type]
+    }
+
+    r = cli_runner.invoke(["generate-fixture", paths[0]])
+    assert r.exit_code == INVALID_OPERATION, r.stderr
+    assert "already exists" in r.stderr
+
+
+def test_import_generated_fixture(tmp_path, cli_runner, chdir):
+    gpkg_path = tmp_path / "parcels.gpkg"
+    r = cli_runner.invoke(
+        [
+            "generate-fixture",
+            "--features=500",
+            "--geometry=polygon",
+            "--layer=parcels",
+            gpkg_path,
+        ]
+    )
+    assert r.exit_code == 0, r.stderr
+
+    repo_path = tmp_path / "repo"
+    r = cli_runner.invoke(["init", repo_path])
+    assert r.exit_code == 0, r.stderr
+    with chdir(repo_path):
+        r = cli_runner.invoke(["import", gpkg_path, "parcels"])
+        assert r.exit_code == 0, r.stderr
+
+        dataset = KartRepo(repo_path).datasets()["parcels"]
+        assert dataset.feature_count == 500
+        assert list(dataset.crs_definitions().keys()) == ["EPSG:2193"]
+        assert [c.name for c in dataset.schema.columns] == [
+            "fid",
+            "geom",
+            "name",
+            "category",
+            "area_m2",
+            "occupants",
+            "recorded",
+            "notes",
+        ]
@@ -4,6 +4,7 @@ import pytest
 from osgeo import ogr, osr
 
 from kart.geometry import (
+    Geometry,
     gpkg_geom_to_hex_wkb,
     gpkg_geom_to_ogr,
     hex_wkb_to_gpkg_geom,
@@ -11,7 +12,12 @@ from kart.geometry import (
     ogr_to_gpkg_geom,
     GPKG_ENVELOPE_NONE,
     GPKG_ENVELOPE_XY,
+    is_curve_geometry_type_name,
+    is_missing_geometry,
+    linearized_geometry_type_name,
+    missing_geometry_description,
 )
+from kart.geometry_merge import merge_geometries, merge_sequences
 
 SRID_RE = re.compile(r"^SRID=(-?\d+);(.*)$")
 
@@ -232,3 +238,190 @@ def test_gpkg_wkb_gpkg_roundtrip(
     gpkg_geom = hex_wkb_to_gpkg_geom(hex_wkb_2)
 
     assert gpkg_geom == input
+
+
+@pytest.mark.parametrize(
+    "wkt,type_name,has_curve",
+    [
+        ("CIRCULARSTRING(0 0,1 1,2 0)", "CIRCULARSTRING", True),
+        (
+            "COMPOUNDCURVE Z (CIRCULARSTRING Z (0 0 0,1 1 0,2 0 0),(2 0 0,3 0 0))",
+            "COMPOUNDCURVE Z",
+            True,
+        ),
+        (
+            "CURVEPOLYGON(CIRCULARSTRING(0 0,2 0,2 2,0 2,0 0))",
+            "CURVEPOLYGON",
+            True,
+        ),
+        ("MULTICURVE((0 0,1 1),CIRCULARSTRING(1 1,2 2,3 1))", "MULTICURVE", True),
+        (
+            "MULTISURFACE(CURVEPOLYGON(CIRCULARSTRING(0 0,2 0,2 2,0 2,0 0)))",
+            "MULTISURFACE",
+            True,
+        ),
+        (
+            "GEOMETRYCOLLECTION(POINT(1 2),CIRCULARSTRING(0 0,1 1,2 0))",
+            "GEOMETRYCOLLECTION",
+            True,
+        ),
+        (
+            "GEOMETRYCOLLECTION(POINT(1 2),LINESTRING(0 0,1 1))",
+            "GEOMETRYCOLLECTION",
+            False,
+        ),
+        ("LINESTRING(0 0,1 1)", "LINESTRING", False),
+    ],
+)
+def test_curve_geometries(wkt, type_name, has_curve):
+    geom = Geometry.from_wkt(wkt)
+    assert geom.geometry_type_name == type_name
+    assert geom.has_curve() == has_curve
+
+    linear_geom = geom.linearize()
+    assert not linear_geom.has_curve()
+    if not has_curve:
+        assert linear_geom == geom
+    else:
+        assert linear_geom != geom
+        assert linear_geom.geometry_type_name == linearized_geometry_type_name(
+            type_name
+        )
+        # Envelope of the approximation is close to the envelope of the original.
+        assert linear_geom.envelope(only_2d=True) == pytest.approx(
+            geom.envelope(only_2d=True), abs=0.01
+        )
+
+
+@pytest.mark.parametrize(
+    "type_name,expected",
+    [
+        ("CIRCULARSTRING", "LINESTRING"),
+        ("COMPOUNDCURVE Z", "LINESTRING Z"),
+        ("CURVEPOLYGON ZM", "POLYGON ZM"),
+        ("MULTICURVE", "MULTILINESTRING"),
+        ("MULTISURFACE M", "MULTIPOLYGON M"),
+        ("POLYGON", "POLYGON"),
+        ("GEOMETRY", "GEOMETRY"),
+    ],
+)
+def test_linearized_geometry_type_name(type_name, expected):
+    assert linearized_geometry_type_name(type_name) == expected
+    assert is_curve_geometry_type_name(type_name) == (type_name != expected)
+
+
+@pytest.mark.parametrize(
+    "unflagged_gpkg_geom,expected_wkt",
+    [
+        pytest.param(
+            # POINT(nan nan) without the empty flag set
+            b"GP\x00\x01\x00\x00\x00\x00\x01\x01\x00\x00\x00\x00\x00\x00\x00\x00\x00\xf8\x7f\x00\x00\x00\x00\x00\x00\xf8\x7f",
+            "POINT EMPTY",
+            id="point",
+        ),
+        pytest.param(
+            # LINESTRING EMPTY without the empty flag set
+            b"GP\x00\x01\x00\x00\x00\x00\x01\x02\x00\x00\x00\x00\x00\x00\x00",
+            "LINESTRING EMPTY",
+            id="linestring",
+        ),
+    ],
+)
+def test_normalise_sets_empty_flag(unflagged_gpkg_geom, expected_wkt):
+    geom = Geometry.of(unflagged_gpkg_geom)
+    assert not geom.is_empty()
+
+    normalised = normalise_gpkg_geom(geom)
+    assert normalised.is_empty()
+    assert normalised.envelope_type == GPKG_ENVELOPE_NONE
+    assert normalised.to_wkt() == expected_wkt
+    assert normalised == Geometry.from_wkt(expected_wkt)
+    # Once normalised, it stays the same.
+    assert normalise_gpkg_geom(normalised) == normalised
+
+
+def test_null_vs_empty_geometry():
+    assert is_missing_geometry(None)
+    assert missing_geometry_description(None) == "NULL"
+
+    empty = Geometry.from_wkt("POINT EMPTY")
+    assert empty is not None
+    assert is_missing_geometry(empty)
+    assert missing_geometry_description(empty) == "EMPTY (POINT EMPTY)"
+
+    point = Geometry.from_wkt("POINT(1 2)")
+    assert not is_missing_geometry(point)
+    assert missing_geometry_description(point) is None
+
+
+@pytest.mark.parametrize(
+    "ancestor,ours,theirs,expected",
+    [
+        pytest.param(
+            "POLYGON ((0 0,10 0,10 10,0 10,0 0))",
+            "POLYGON ((0 0,11 0,10 10,0 10,0 0))",
+            "POLYGON ((0 0,10 0,10 10,0 11,0 0))",
+            "POLYGON ((0 0,11 0,10 10,0 11,0 0))",
+            id="move-different-vertices",
+        ),
+        pytest.param(
+            "POLYGON ((0 0,10 0,10 10,0 10,0 0))",
+            "POLYGON ((-1 -1,10 0,10 10,0 10,-1 -1))",
+            "POLYGON ((0 0,10 0,11 11,0 10,0 0))",
+            "POLYGON ((-1 -1,10 0,11 11,0 10,-1 -1))",
+            id="move-first-vertex",
+        ),
+        pytest.param(
+            "POLYGON ((0 0,10 0,10 10,0 10,0 0))",
+            "POLYGON ((0 0,5 -1,10 0,10 10,0 10,0 0))",
+            "POLYGON ((0 0,10 0,11 5,10 10,0 10,0 0))",
+            "POLYGON ((0 0,5 -1,10 0,11 5,10 10,0 10,0 0))",
+            id="insert-vertices",
+        ),
+        pytest.param(
+            "LINESTRING (0 0,1 1,2 2,3 3)",
+            "LINESTRING (0 0,1 2,2 2,3 3)",
+            "LINESTRING (0 0,1 1,2 2)",
+            "LINESTRING (0 0,1 2,2 2)",
+            id="linestring-remove-vertex",
+        ),
+        pytest.param(
+            "POLYGON ((0 0,10 0,10 10,0 10,0 0))",
+            "POLYGON ((0 0,11 0,10 10,0 10,0 0))",
+            "POLYGON ((0 0,12 0,10 10,0 10,0 0))",
+            None,
+            id="move-same-vertex",
+        ),
+        pytest.param(
+            "POLYGON ((0 0,10 0,10 10,0 10,0 0))",
+            "POLYGON ((0 0,5 20,10 10,0 10,0 0))",
+            "POLYGON ((0 0,10 0,10 10,0 11,0 0))",
+            None,
+            id="invalid-result",
+        ),
+        pytest.param(
+            "POLYGON ((0 0,10 0,10 10,0 10,0 0))",
+            "POLYGON ((0 0,11 0,10 10,0 10,0 0))",
+            "MULTIPOLYGON (((0 0,10 0,10 10,0 11,0 0)))",
+            None,
+            id="different-structure",
+        ),
+        pytest.param("POINT (0 0)", "POINT (1 0)", "POINT (0 1)", None, id="point"),
+    ],
+)
+def test_merge_geometries(ancestor, ours, theirs, expected):
+    result = merge_geometries(
+        Geometry.from_wkt(ancestor), Geometry.from_wkt(ours), Geometry.from_wkt(theirs)
+    )
+    if expected is None:
+        assert result is None
+    else:
+        assert result.to_wkt() == expected
+
+
+def test_merge_sequences():
+    assert merge_sequences("abcdef", "aXcdef", "abcdeY") == list("aXcdeY")
+    assert merge_sequences("abcdef", "aXcdef", "aXcdef") == list("aXcdef")
+    assert merge_sequences("abcdef", "abXcdef", "abYcdef") is None
+    assert merge_sequences("abcdef", "abXcdef", "acdef") is None
+    assert merge_sequences("abcdef", "abcdef", "") == []
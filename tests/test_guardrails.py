@@ -0,0 +1,142 @@
+import subprocess
+
+import pygit2
+import pytest
+
+from kart.exceptions import INVALID_OPERATION
+from kart.guardrails import PRE_RECEIVE_HOOK
+from kart.repo import KartRepo
+
+
+H = pytest.helpers.helpers()
+
+
+def _commit_bad_edits(repo, cli_runner):
+    layer = H.POINTS.LAYER
+    r = cli_runner.invoke(["checkout", "-b", "bulk-edit"])
+    assert r.exit_code == 0, r.stderr
+    with repo.working_copy.tabular.session() as sess:
+        sess.execute(f"DELETE FROM {layer} WHERE fid > 100;")
+        sess.execute(
+            f"UPDATE {layer} SET geom = ST_GeomFromText('POINT(0 0)', 4326) WHERE fid = 1;"
+        )
+    r = cli_runner.invoke(["commit", "-m", "Bad bulk edit"])
+    assert r.exit_code == 0, r.stderr
+    r = cli_runner.invoke(["checkout", "main"])
+    assert r.exit_code == 0, r.stderr
+
+
+def test_merge_change_size_guardrails(data_working_copy, cli_runner):
+    layer = H.POINTS.LAYER
+    with data_working_copy("points") as (repo_path, wc):
+        repo = KartRepo(repo_path)
+        _commit_bad_edits(repo, cli_runner)
+        head = repo.head_commit.id
+
+        # Nothing is configured, so nothing is checked.
+        r = cli_runner.invoke(["merge", "bulk-edit", "--dry-run"])
+        assert r.exit_code == 0, r.stderr
+
+        r = cli_runner.invoke(
+            ["config", "kart.guardrails.push.maxDeletedFeatures", "100"]
+        )
+        assert r.exit_code == 0, r.stderr
+        r = cli_runner.invoke(
+            ["config", "kart.guardrails.push.maxGeometryShift", "1000"]
+        )
+        assert r.exit_code == 0, r.stderr
+
+        r = cli_runner.invoke(["merge", "bulk-edit"])
+        assert r.exit_code == INVALID_OPERATION, r.stderr
+        deleted = H.POINTS.ROWCOUNT - 100
+        assert f"{deleted} features would be deleted" in r.stderr
+        assert f"{layer}:feature:1 would move " in r.stderr
+        assert "Use --allow-large-changes to merge anyway" in r.stderr
+        assert repo.head_commit.id == head
+
+        r = cli_runner.invoke(["merge", "bulk-edit", "--no-ff"])
+        assert r.exit_code == INVALID_OPERATION, r.stderr
+        assert repo.head_commit.id == head
+
+        r = cli_runner.invoke(["merge", "bulk-edit", "--allow-large-changes"])
+        assert r.exit_code == 0, r.stderr
+        assert repo.head_commit.id == repo.revparse_single("bulk-edit").id
+
+
+def test_push_change_size_guardrails(data_working_copy, cli_runner, tmp_path):
+    with data_working_copy("points") as (repo_path, wc):
+        repo = KartRepo(repo_path)
+        remote_path = tmp_path / "remote.git"
+        subprocess.run(["git", "init", "--bare", str(remote_path)], check=True)
+        r = cli_runner.invoke(["remote", "add", "origin", remote_path])
+        assert r.exit_code == 0, r.stderr
+        r = cli_runner.invoke(["push", "--set-upstream", "origin", "main"])
+        assert r.exit_code == 0, r.stderr
+        # The bulk-edit branch is on the remote, before the bad edits are made.
+        r = cli_runner.invoke(["push", "origin", "main:bulk-edit"])
+        assert r.exit_code == 0, r.stderr
+        _commit_bad_edits(repo, cli_runner)
+        r = cli_runner.invoke(
+            ["config", "kart.guardrails.push.maxDeletedFeatures", "100"]
+        )
+        assert r.exit_code == 0, r.stderr
+
+        # None of these push the current branch, but they all push the bad edits:
+        for push_args in (
+            ["origin", "bulk-edit"],
+            ["origin", "bulk-edit:main"],
+            ["--all", "origin"],
+        ):
+            r = cli_runner.invoke(["push", *push_args])
+            assert r.exit_code == INVALID_OPERATION, r.stderr
+            assert "Use --allow-large-changes to push anyway" in r.stderr
+        remote_repo = pygit2.Repository(str(remote_path))
+        assert remote_repo.revparse_single("bulk-edit").id == repo.head_commit.id
+
+        r = cli_runner.invoke(
+            ["push", "--allow-large-changes", "origin", "bulk-edit"]
+        )
+        assert r.exit_code == 0, r.stderr
+        assert (
+            remote_repo.revparse_single("bulk-edit").id
+            == repo.revparse_single("bulk-edit").id
+        )
+
+
+def test_pre_receive_guardrails(data_working_copy, cli_runner, monkeypatch):
+    with data_working_copy("points") as (repo_path, wc):
+        repo = KartRepo(repo_path)
+        _commit_bad_edits(repo, cli_runner)
+        old_id = repo.head_commit.hex
+        new_id = repo.revparse_single("bulk-edit").hex
+        ref_updates = f"{old_id} {new_id} refs/heads/main\n"
+
+        r = cli_runner.invoke(["guardrails", "install-hook"])
+        assert r.exit_code == 0, r.stderr
+        hook_path = repo.gitdir_path / "hooks" / "pre-receive"
+        assert hook_path.read_text() == PRE_RECEIVE_HOOK
+        assert repo.config.get_bool("receive.advertisePushOptions")
+        r = cli_runner.invoke(["guardrails", "install-hook"])
+        assert r.exit_code == 0, r.stderr
+        assert "already installed" in r.stdout
+
+        r = cli_runner.invoke(
+            ["config", "kart.guardrails.push.maxDeletedFeatures", "100"]
+        )
+        assert r.exit_code == 0, r.stderr
+        r = cli_runner.invoke(["guardrails", "pre-receive"], input=ref_updates)
+        assert r.exit_code == INVALID_OPERATION, r.stderr
+        assert "Can't update refs/heads/main" in r.stderr
+        assert "kart push -o kart.allowLargeChanges" in r.stderr
+
+        # New branches have nothing to compare to.
+        r = cli_runner.invoke(
+            ["guardrails", "pre-receive"],
+            input=f"{'0' * 40} {new_id} refs/heads/other\n",
+        )
+        assert r.exit_code == 0, r.stderr
+
+        monkeypatch.setenv("GIT_PUSH_OPTION_COUNT", "1")
+        monkeypatch.setenv("GIT_PUSH_OPTION_0", "kart.allowLargeChanges")
+        r = cli_runner.invoke(["guardrails", "pre-receive"], input=ref_updates)
+        assert r.exit_code == 0, r.stderr
@@ -0,0 +1,68 @@
+import json
+
+import pytest
+
+from kart.repo import KartRepo
+
+
+H = pytest.helpers.helpers()
+
+
+def test_id_map_export_merge_reassignments(data_archive, cli_runner):
+    with data_archive("conflicts/inserts.tgz") as repo_path:
+        r = cli_runner.invoke(
+            [
+                "merge",
+                "theirs_branch",
+                "--renumber-pk-collisions=theirs",
+                "-m",
+                "Merge with theirs_branch",
+            ]
+        )
+        assert r.exit_code == 0, r.stderr
+        repo = KartRepo(repo_path)
+        merge_commit = repo.head_commit.id.hex
+
+        r = cli_runner.invoke(["id-map", "export", "-o", "json"])
+        assert r.exit_code == 0, r.stderr
+        rows = json.loads(r.stdout)["kart.id-map/v1"]
+        assert [(r["emittedPk"], r["source"], r["commit"]) for r in rows] == [
+            (pk, "merge", merge_commit) for pk in range(11, 15)
+        ]
+        assert sorted(r["pk"] for r in rows) == list(range(17, 21))
+
+        r = cli_runner.invoke(["id-map", "export"])
+        assert r.exit_code == 0, r.stderr
+        assert len(r.stdout.splitlines()) == 4
+        assert r.stdout.startswith("boys_names\tmerge\t11\t→\t")
+
+        # Nothing was renumbered before the merge.
+        r = cli_runner.invoke(["id-map", "export", "--ref=HEAD^", "-o", "json"])
+        assert r.exit_code == 0, r.stderr
+        assert json.loads(r.stdout)["kart.id-map/v1"] == []
+
+        r = cli_runner.invoke(["id-map", "export", "other_dataset", "-o", "json"])
+        assert r.exit_code == 0, r.stderr
+        assert json.loads(r.stdout)["kart.id-map/v1"] == []
+
+
+def test_id_map_export_working_copy_keys(data_working_copy, cli_runner):
+    layer = H.POLYGONS.LAYER
+    with data_working_copy("string-pks") as (repo_path, wc):
+        r = cli_runner.invoke(["id-map", "export", "-o", "json"])
+        assert r.exit_code == 0, r.stderr
+        rows = json.loads(r.stdout)["kart.id-map/v1"]
+        assert len(rows) == H.POLYGONS.ROWCOUNT
+        assert all(r["dataset"] == layer for r in rows)
+        assert all(r["source"] == "workingcopy" for r in rows)
+        assert all(isinstance(r["emittedPk"], int) for r in rows)
+        assert all(r["pk"].startswith("POLY") for r in rows)
+
+        repo = KartRepo(repo_path)
+        with repo.working_copy.tabular.session() as sess:
+            [wc_pk] = sess.execute(
+                f"SELECT auto_int_pk FROM {layer} WHERE id = 'POLY1424927';"
+            ).fetchone()
+        assert {"pk": "POLY1424927", "emittedPk": wc_pk} in [
+            {"pk": r["pk"], "emittedPk": r["emittedPk"]} for r in rows
+        ]
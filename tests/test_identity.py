@@ -0,0 +1,49 @@
+import json
+import subprocess
+
+
+def test_whoami(git_user_config, data_archive, cli_runner, monkeypatch):
+    u_email, u_name = git_user_config
+
+    with data_archive("points"):
+        r = cli_runner.invoke(["whoami", "-o", "json"])
+        assert r.exit_code == 0, r.stderr
+        jdict = json.loads(r.stdout)["kart.whoami/v1"]
+        assert jdict["author"] == {
+            "name": u_name,
+            "email": u_email,
+            "nameSource": "global config user.name",
+            "emailSource": "global config user.email",
+        }
+        assert jdict["committer"]["name"] == u_name
+
+        # Per-repository identity overrides the global config:
+        subprocess.check_call(["git", "config", "--local", "user.name", "Alice"])
+        r = cli_runner.invoke(["whoami", "-o", "json"])
+        assert r.exit_code == 0, r.stderr
+        jdict = json.loads(r.stdout)["kart.whoami/v1"]
+        assert jdict["author"]["name"] == "Alice"
+        assert jdict["author"]["nameSource"] == "repository config user.name"
+        assert jdict["author"]["email"] == u_email
+
+        # Environment variables override all config:
+        monkeypatch.setenv("GIT_COMMITTER_NAME", "Bob")
+        r = cli_runner.invoke(["whoami"])
+        assert r.exit_code == 0, r.stderr
+        assert r.stdout.splitlines() == [
+            f"Author:    Alice <{u_email}>",
+            "  name from repository config user.name",
+            "  email from global config user.email",
+            f"Committer: Bob <{u_email}>",
+            "  name from environment variable GIT_COMMITTER_NAME",
+            "  email from global config user.email",
+        ]
+
+        # The same identity is stamped on commits:
+        r = cli_runner.invoke(["commit-files", "-m", "test", "a=b"])
+        assert r.exit_code == 0, r.stderr
+        r = cli_runner.invoke(["log", "-o", "json", "-n", "1"])
+        assert r.exit_code == 0, r.stderr
+        commit = json.loads(r.stdout)[0]
+        assert commit["authorName"] == "Alice"
+        assert commit["committerName"] == "Bob"
@@ -1,16 +1,27 @@
+import hashlib
+import http.server
 import json
 import re
 import shutil
+import sqlite3
+import sys
+import threading
+import zipfile
 
 import pytest
+from osgeo import ogr, osr
 
 from kart import dataset_util
+from kart.tabular.source_encoding import detect_encoding
 from kart.sqlalchemy.gpkg import Db_GPKG
+from kart.import_provenance import get_provenance
 from kart.repo import KartRepo
 from kart.exceptions import (
+    INVALID_FILE_FORMAT,
     INVALID_OPERATION,
     NO_IMPORT_SOURCE,
     NO_TABLE,
+    NOT_FOUND,
     WORKING_COPY_OR_IMPORT_CONFLICT,
     InvalidOperation,
 )
@@ -275,6 +286,143 @@ def test_import_replace_existing_with_no_changes(
             )
             assert r.exit_code == 44, r.stderr
 
+            # --allow-empty records the identical snapshot as a commit anyway.
+            repo = KartRepo(repo_path)
+            orig_head = repo.head_commit
+            r = cli_runner.invoke(
+                [
+                    "import",
+                    "--replace-existing",
+                    "--allow-empty",
+                    data / "nz-waca-adjustments.gpkg",
+                    "nz_waca_adjustments:mytable",
+                ]
+            )
+            assert r.exit_code == 0, r.stderr
+            assert repo.head_commit.parent_ids == [orig_head.id]
+            assert repo.head_commit.tree == orig_head.tree
+
+
+def test_import_skip_unchanged(data_archive, tmp_path, cli_runner, chdir):
+    with data_archive("gpkg-polygons") as data:
+        repo_path = tmp_path / "emptydir"
+        r = cli_runner.invoke(["init", repo_path])
+        assert r.exit_code == 0
+        with chdir(repo_path):
+            source = data / "nz-waca-adjustments.gpkg"
+            r = cli_runner.invoke(["import", source, "nz_waca_adjustments:mytable"])
+            assert r.exit_code == 0, r.stderr
+            repo = KartRepo(repo_path)
+            orig_head = repo.head_commit.hex
+
+            # Re-import the same thing - the dataset is skipped, and no commit is made.
+            r = cli_runner.invoke(
+                [
+                    "import",
+                    "--skip-unchanged",
+                    source,
+                    "nz_waca_adjustments:mytable",
+                ]
+            )
+            assert r.exit_code == 0, r.stderr
+            assert "mytable/ is unchanged since it was last imported" in r.stdout
+            assert r.stdout.splitlines()[-1] == "No changes to import"
+            assert repo.head_commit.hex == orig_head
+
+            with Db_GPKG.create_engine(source).connect() as conn:
+                conn.execute("DELETE FROM nz_waca_adjustments WHERE id = 1424927;")
+
+            # Now only the changed features are committed.
+            r = cli_runner.invoke(
+                [
+                    "import",
+                    "--skip-unchanged",
+                    source,
+                    "nz_waca_adjustments:mytable",
+                ]
+            )
+            assert r.exit_code == 0, r.stderr
+            assert repo.head_commit.hex != orig_head
+            r = cli_runner.invoke(["show", "-o", "json"])
+            assert r.exit_code == 0, r.stderr
+            diff = json.loads(r.stdout)["kart.diff/v1+hexwkb"]["mytable"]
+            assert list(diff) == ["feature"]
+            assert len(diff["feature"]) == 1
+            assert diff["feature"][0]["-"]["id"] == 1424927
+
+
+def test_import_if_source_changed(data_archive, tmp_path, cli_runner, chdir):
+    with data_archive("gpkg-polygons") as data:
+        repo_path = tmp_path / "emptydir"
+        r = cli_runner.invoke(["init", repo_path])
+        assert r.exit_code == 0
+        with chdir(repo_path):
+            source = data / "nz-waca-adjustments.gpkg"
+            r = cli_runner.invoke(["import", source, "nz_waca_adjustments:mytable"])
+            assert r.exit_code == 0, r.stderr
+            repo = KartRepo(repo_path)
+            orig_head = repo.head_commit
+
+            # The provenance of the import is recorded as a note on the commit.
+            provenance = get_provenance(repo, orig_head)
+            assert provenance["source"] == str(source.resolve())
+            assert provenance["sourceSha256"] == hashlib.sha256(
+                source.read_bytes()
+            ).hexdigest()
+            assert provenance["datasets"] == ["mytable"]
+            assert provenance["options"] == {
+                "tables": ["nz_waca_adjustments:mytable"]
+            }
+
+            # Re-import the same source - nothing is imported.
+            r = cli_runner.invoke(
+                [
+                    "import",
+                    "--if-source-changed",
+                    "--replace-existing",
+                    source,
+                    "nz_waca_adjustments:mytable",
+                ]
+            )
+            assert r.exit_code == 0, r.stderr
+            assert r.stdout.splitlines()[-1] == (
+                f"Source is unchanged since it was imported in commit {orig_head.short_id} - nothing to import"
+            )
+            assert repo.head_commit.id == orig_head.id
+
+            # Importing the same source to a different dataset isn't skipped.
+            r = cli_runner.invoke(
+                [
+                    "import",
+                    "--if-source-changed",
+                    source,
+                    "nz_waca_adjustments:othertable",
+                ]
+            )
+            assert r.exit_code == 0, r.stderr
+            assert repo.head_commit.id != orig_head.id
+
+            with Db_GPKG.create_engine(source).connect() as conn:
+                conn.execute("DELETE FROM nz_waca_adjustments WHERE id = 1424927;")
+
+            # Once the source has changed, it is imported.
+            head = repo.head_commit
+            r = cli_runner.invoke(
+                [
+                    "import",
+                    "--if-source-changed",
+                    "--replace-existing",
+                    source,
+                    "nz_waca_adjustments:mytable",
+                ]
+            )
+            assert r.exit_code == 0, r.stderr
+            assert repo.head_commit.id != head.id
+            assert get_provenance(repo, repo.head_commit)["options"] == {
+                "tables": ["nz_waca_adjustments:mytable"],
+                "replaceExisting": True,
+            }
+
 
 def test_import_replace_existing_with_compatible_schema_changes(
     data_archive,
@@ -865,6 +1013,82 @@ def test_init_empty(tmp_path, cli_runner, chdir):
         assert not (repo_path / ".kart" / "HEAD").exists()
 
 
+def test_init_template(tmp_path, cli_runner, chdir):
+    template = tmp_path / "template"
+    (template / "profiles").mkdir(parents=True)
+    (template / "hooks").mkdir()
+    (template / "datasets" / "assets" / "poles").mkdir(parents=True)
+    (template / "config").write_text(
+        '[kart "guardrails"]\n'
+        "\tmaxDeletedPercent = 30\n"
+        "[kart]\n"
+        "\tderivedColumn = updated_by=updated_by\n"
+        "\tderivedColumn = assets/poles:who=updated_by\n"
+    )
+    (template / "profiles" / "public.json").write_text(
+        json.dumps({"description": "Public", "datasets": ["assets/poles"]})
+    )
+    (template / "hooks" / "pre-commit").write_text("#!/bin/sh\nexit 0\n")
+    ds_dir = template / "datasets" / "assets" / "poles"
+    (ds_dir / "schema.json").write_text(
+        json.dumps(
+            [
+                {
+                    "name": "id",
+                    "dataType": "integer",
+                    "primaryKeyIndex": 0,
+                    "size": 64,
+                },
+                {"name": "material", "dataType": "text"},
+            ]
+        )
+    )
+    (ds_dir / "title").write_text("Power poles")
+
+    repo_path = tmp_path / "repo"
+    r = cli_runner.invoke(["init", str(repo_path), "--template", str(template)])
+    assert r.exit_code == 0, r.stderr
+    repo = KartRepo(repo_path)
+
+    assert repo.get_config_str("kart.guardrails.maxDeletedPercent") == "30"
+    assert list(repo.config.get_multivar("kart.derivedColumn")) == [
+        "updated_by=updated_by",
+        "assets/poles:who=updated_by",
+    ]
+    assert "refs/profiles/public" in repo.references
+    hook = repo.gitdir_path / "hooks" / "pre-commit"
+    assert hook.read_text() == "#!/bin/sh\nexit 0\n"
+    assert hook.stat().st_mode & 0o111
+
+    dataset = repo.datasets()["assets/poles"]
+    assert [c.name for c in dataset.schema] == ["id", "material"]
+    assert dataset.get_meta_item("title") == "Power poles"
+    assert dataset.feature_count == 0
+    assert repo.head_commit.message == "Create assets/poles from template"
+
+    # Repositories created from the same template have the same column IDs.
+    r = cli_runner.invoke(
+        ["init", str(tmp_path / "repo2"), "--template", str(template)]
+    )
+    assert r.exit_code == 0, r.stderr
+    dataset2 = KartRepo(tmp_path / "repo2").datasets()["assets/poles"]
+    assert dataset2.schema == dataset.schema
+
+    # Templates can't change how the repository is stored.
+    (template / "config").write_text("[core]\n\tbare = true\n")
+    r = cli_runner.invoke(
+        ["init", str(tmp_path / "repo3"), "--template", str(template)]
+    )
+    assert r.exit_code == INVALID_OPERATION, r.stderr
+    assert "can only contain kart.* settings" in r.stderr
+    assert not (tmp_path / "repo3").exists()
+
+    r = cli_runner.invoke(
+        ["init", str(tmp_path / "repo4"), "--template", str(tmp_path / "missing")]
+    )
+    assert r.exit_code == NOT_FOUND, r.stderr
+
+
 @pytest.mark.slow
 def test_init_import_alt_names(data_archive, tmp_path, cli_runner, chdir):
     """Import the GeoPackage (eg. `kx-foo-layer.gpkg`) into a Kart repository."""
@@ -1055,7 +1279,10 @@ def test_import_list_formats(data_archive_readonly, cli_runner):
             "PostgreSQL",
             "Microsoft SQL Server",
             "MySQL",
+            "Oracle Spatial",
             "ESRI Shapefile",
+            "ESRI File Geodatabase",
+            "OpenStreetMap",
             "LAS (LASer)",
             "GeoTIFF",
         ]
@@ -1255,3 +1482,392 @@ def test_import_from_wc(data_working_copy, cli_runner):
         )
         assert r.exit_code == 2
         assert "Import-source is already inside working-copy." in r.stderr
+
+
+OSM_EXTRACT = """<?xml version="1.0" encoding="UTF-8"?>
+<osm version="0.6" generator="kart-tests">
+  <node id="1" version="1" lat="-41.29" lon="174.78">
+    <tag k="amenity" v="cafe"/>
+    <tag k="name" v="Kart Cafe"/>
+    <tag k="cuisine" v="coffee_shop"/>
+  </node>
+  <node id="2" version="1" lat="-41.30" lon="174.77">
+    <tag k="tourism" v="viewpoint"/>
+  </node>
+  <node id="3" version="1" lat="-41.31" lon="174.76"/>
+  <node id="4" version="1" lat="-41.32" lon="174.75"/>
+  <way id="10" version="1">
+    <nd ref="3"/>
+    <nd ref="4"/>
+    <tag k="highway" v="residential"/>
+    <tag k="name" v="Kart Street"/>
+  </way>
+</osm>
+"""
+
+
+def test_import_osm(tmp_path, cli_runner, chdir):
+    osm_path = tmp_path / "extract.osm"
+    osm_path.write_text(OSM_EXTRACT)
+    repo_path = tmp_path / "repo"
+    r = cli_runner.invoke(["init", repo_path])
+    assert r.exit_code == 0, r.stderr
+
+    with chdir(repo_path):
+        r = cli_runner.invoke(
+            [
+                "import",
+                osm_path,
+                "--tag-mapping",
+                '{"points": {"columns": {"name": "name", "kind": "amenity", "cuisine": "cuisine"}, "require": ["amenity"]}}',
+                "points:osm/amenities",
+                "lines:osm/lines",
+            ]
+        )
+        assert r.exit_code == 0, r.stderr
+
+        repo = KartRepo(repo_path)
+        amenities = repo.datasets()["osm/amenities"]
+        assert [c.name for c in amenities.schema] == [
+            "osm_id",
+            "geom",
+            "name",
+            "kind",
+            "cuisine",
+        ]
+        [amenity] = list(amenities.features())
+        assert amenity["osm_id"] == "node/1"
+        assert amenity["geom"].to_wkt() == "POINT(174.78 -41.29)"
+        assert amenity["name"] == "Kart Cafe"
+        assert amenity["kind"] == "cafe"
+        assert amenity["cuisine"] == "coffee_shop"
+
+        lines = repo.datasets()["osm/lines"]
+        [line] = list(lines.features())
+        assert line["osm_id"] == "way/10"
+        assert line["name"] == "Kart Street"
+        assert line["highway"] == "residential"
+
+        r = cli_runner.invoke(
+            [
+                "import",
+                osm_path,
+                "--tag-mapping",
+                '{"roads": {"columns": ["name"]}}',
+                "lines",
+            ]
+        )
+        assert r.exit_code == 2, r.stderr
+        assert "No such OSM layer: roads" in r.stderr
+
+
+def test_load_fgdb(tmp_path, cli_runner, chdir):
+    gdb_path = tmp_path / "transport.gdb"
+    ogr_ds = ogr.GetDriverByName("OpenFileGDB").CreateDataSource(str(gdb_path))
+    srs = osr.SpatialReference()
+    srs.ImportFromEPSG(4326)
+    layer = ogr_ds.CreateLayer("Roads", srs=srs, geom_type=ogr.wkbLineString)
+    layer.CreateField(ogr.FieldDefn("name", ogr.OFTString))
+    for name in ("Kart Street", "Kart Avenue"):
+        ogr_feature = ogr.Feature(layer.GetLayerDefn())
+        ogr_feature.SetField("name", name)
+        ogr_feature.SetGeometry(
+            ogr.CreateGeometryFromWkt("LINESTRING(174.75 -41.32,174.76 -41.31)")
+        )
+        layer.CreateFeature(ogr_feature)
+    ogr_ds = None
+
+    repo_path = tmp_path / "repo"
+    r = cli_runner.invoke(["init", repo_path])
+    assert r.exit_code == 0, r.stderr
+
+    with chdir(repo_path):
+        r = cli_runner.invoke(["load-fgdb", gdb_path, "Roads", "transport/roads"])
+        assert r.exit_code == 0, r.stderr
+
+        repo = KartRepo(repo_path)
+        dataset = repo.datasets()["transport/roads"]
+        assert [c.name for c in dataset.schema.pk_columns] == ["OBJECTID"]
+        assert list(dataset.crs_definitions().keys()) == ["EPSG:4326"]
+        features = sorted(dataset.features(), key=lambda f: f["OBJECTID"])
+        assert [(f["OBJECTID"], f["name"]) for f in features] == [
+            (1, "Kart Street"),
+            (2, "Kart Avenue"),
+        ]
+
+        r = cli_runner.invoke(["load-fgdb", gdb_path, "Roads", "transport/roads"])
+        assert r.exit_code == INVALID_OPERATION, r.stderr
+
+        r = cli_runner.invoke(
+            ["load-fgdb", gdb_path, "Roads", "transport/roads", "--replace-existing"]
+        )
+        assert r.exit_code == 0, r.stderr
+
+
+def test_load_ogr(tmp_path, cli_runner, chdir):
+    geojson_path = tmp_path / "cafes.geojson"
+    geojson_path.write_text(
+        json.dumps(
+            {
+                "type": "FeatureCollection",
+                "features": [
+                    {
+                        "type": "Feature",
+                        "properties": {"name": "Kart Cafe", "seats": 12},
+                        "geometry": {"type": "Point", "coordinates": [174.78, -41.29]},
+                    }
+                ],
+            }
+        )
+    )
+    repo_path = tmp_path / "repo"
+    r = cli_runner.invoke(["init", repo_path])
+    assert r.exit_code == 0, r.stderr
+
+    with chdir(repo_path):
+        # GeoJSON isn't one of the formats that `kart import` supports directly.
+        r = cli_runner.invoke(
+            ["load-ogr", "--format", "GeoJSON", geojson_path, "cafes", "food/cafes"]
+        )
+        assert r.exit_code == 0, r.stderr
+
+        repo = KartRepo(repo_path)
+        dataset = repo.datasets()["food/cafes"]
+        [feature] = list(dataset.features())
+        assert feature["name"] == "Kart Cafe"
+        assert feature["seats"] == 12
+        assert list(dataset.crs_definitions().keys()) == ["EPSG:4326"]
+
+        r = cli_runner.invoke(
+            ["load-ogr", "--format", "GML", geojson_path, "cafes", "cafes"]
+        )
+        assert r.exit_code == NO_IMPORT_SOURCE, r.stderr
+
+        r = cli_runner.invoke(
+            ["load-ogr", "--format", "klingon", geojson_path, "cafes", "cafes"]
+        )
+        assert r.exit_code == 2, r.stderr
+        assert "isn't the name of an OGR vector driver" in r.stderr
+
+
+EXT_IMPORTER = """
+import json, struct, sys
+
+request = json.loads(sys.stdin.readline())
+assert request["protocol"] == "kart.ext/v1"
+if sys.argv[1:] == ["--fail"]:
+    print(json.dumps({"error": "API is down"}))
+elif request["request"] == "tables":
+    print(json.dumps({"table": "cafes", "title": "Cafes"}))
+elif request["request"] == "describe":
+    print(json.dumps(DESCRIPTION))
+elif request["request"] == "features":
+    for fid, name, y in [(1, "Kart Cafe", -41.29), (2, None, -41.3)]:
+        wkb = struct.pack("<BIdd", 1, 1, 174.78, y)
+        print(json.dumps({"fid": fid, "geom": wkb.hex(), "name": name}))
+"""
+
+
+def test_load_ext(tmp_path, cli_runner, chdir):
+    srs = osr.SpatialReference()
+    srs.ImportFromEPSG(4326)
+    description = {
+        "title": "Cafes",
+        "crs": {"EPSG:4326": srs.ExportToWkt()},
+        "schema": [
+            {"name": "fid", "dataType": "integer", "size": 64, "primaryKeyIndex": 0},
+            {
+                "name": "geom",
+                "dataType": "geometry",
+                "geometryType": "POINT",
+                "geometryCRS": "EPSG:4326",
+            },
+            {"name": "name", "dataType": "text"},
+        ],
+    }
+    importer_path = tmp_path / "importer.py"
+    importer_path.write_text(
+        f"DESCRIPTION = {json.dumps(description)}\n{EXT_IMPORTER}"
+    )
+    repo_path = tmp_path / "repo"
+    r = cli_runner.invoke(["init", repo_path])
+    assert r.exit_code == 0, r.stderr
+
+    with chdir(repo_path):
+        r = cli_runner.invoke(
+            ["load-ext", sys.executable, f"--arg={importer_path}", "--list", "-ojson"]
+        )
+        assert r.exit_code == 0, r.stderr
+        assert json.loads(r.stdout) == {"kart.tables/v1": {"cafes": "Cafes"}}
+
+        r = cli_runner.invoke(
+            ["load-ext", sys.executable, f"--arg={importer_path}", "cafes:food/cafes"]
+        )
+        assert r.exit_code == 0, r.stderr
+
+        repo = KartRepo(repo_path)
+        dataset = repo.datasets()["food/cafes"]
+        assert dataset.get_meta_item("title") == "Cafes"
+        assert list(dataset.crs_definitions().keys()) == ["EPSG:4326"]
+        features = sorted(dataset.features(), key=lambda f: f["fid"])
+        assert [(f["fid"], f["name"]) for f in features] == [
+            (1, "Kart Cafe"),
+            (2, None),
+        ]
+        assert features[0]["geom"].to_wkt() == "POINT (174.78 -41.29)"
+
+        r = cli_runner.invoke(
+            [
+                "load-ext",
+                sys.executable,
+                f"--arg={importer_path}",
+                "--arg=--fail",
+                "cafes",
+            ]
+        )
+        assert r.exit_code == INVALID_OPERATION, r.stderr
+        assert "API is down" in r.stderr
+
+
+@pytest.mark.parametrize(
+    "text,encoding,expected",
+    [
+        ("Zürich", "cp1252", "cp1252"),
+        ("Ötztal", "cp1252", "cp1252"),
+        ("東京都", "shift_jis", "shift_jis"),
+        ("Zürich", "utf-8", "utf-8"),
+        ("Zurich", "ascii", "utf-8"),
+    ],
+)
+def test_detect_encoding(text, encoding, expected):
+    assert detect_encoding([text.encode(encoding), b"plain"]) == expected
+
+
+def test_import_source_encoding(tmp_path, cli_runner, chdir):
+    gpkg_path = tmp_path / "legacy.gpkg"
+    ogr_ds = ogr.GetDriverByName("GPKG").CreateDataSource(str(gpkg_path))
+    layer = ogr_ds.CreateLayer("places", geom_type=ogr.wkbNone)
+    layer.CreateField(ogr.FieldDefn("name", ogr.OFTString))
+    layer.CreateFeature(ogr.Feature(layer.GetLayerDefn()))
+    ogr_ds = None
+    with sqlite3.connect(gpkg_path) as db:
+        # Legacy text, encoded as Latin-1 rather than UTF-8.
+        db.execute(
+            "UPDATE places SET name = CAST(? AS TEXT);",
+            ("Zürich".encode("latin-1"),),
+        )
+
+    repo_path = tmp_path / "repo"
+    r = cli_runner.invoke(["init", repo_path])
+    assert r.exit_code == 0, r.stderr
+    with chdir(repo_path):
+        r = cli_runner.invoke(
+            ["import", gpkg_path, "places:explicit", "--source-encoding", "latin-1"]
+        )
+        assert r.exit_code == 0, r.stderr
+        r = cli_runner.invoke(
+            ["import", gpkg_path, "places:guessed", "--source-encoding", "auto"]
+        )
+        assert r.exit_code == 0, r.stderr
+        assert "is encoded as cp1252" in r.stderr
+
+        repo = KartRepo(repo_path)
+        for ds_path in ("explicit", "guessed"):
+            [feature] = list(repo.datasets()[ds_path].features())
+            assert feature["name"] == "Zürich"
+
+        r = cli_runner.invoke(
+            ["import", gpkg_path, "places:wrong", "--source-encoding", "utf-8"]
+        )
+        assert r.exit_code == INVALID_FILE_FORMAT, r.stderr
+        assert "specify a different --source-encoding" in r.stderr
+
+        r = cli_runner.invoke(["import", gpkg_path, "--source-encoding", "klingon"])
+        assert r.exit_code == 2, r.stderr
+        assert "Unknown encoding: klingon" in r.stderr
+
+
+def test_import_from_zip_member(data_archive_readonly, tmp_path, cli_runner, chdir):
+    with data_archive_readonly("gpkg-points") as data:
+        archive_path = tmp_path / "open-data.zip"
+        with zipfile.ZipFile(archive_path, "w") as zf:
+            zf.write(data / "nz-pa-points-topo-150k.gpkg", "layers/points.gpkg")
+
+    repo_path = tmp_path / "repo"
+    r = cli_runner.invoke(["init", repo_path])
+    assert r.exit_code == 0, r.stderr
+    with chdir(repo_path):
+        r = cli_runner.invoke(
+            ["import", f"{archive_path}!layers/points.gpkg", H.POINTS.LAYER]
+        )
+        assert r.exit_code == 0, r.stderr
+        assert f"Import from points.gpkg:{H.POINTS.LAYER}" in r.stdout
+
+        repo = KartRepo(repo_path)
+        assert repo.datasets()[H.POINTS.LAYER].feature_count == H.POINTS.ROWCOUNT
+
+        r = cli_runner.invoke(["import", f"{archive_path}!layers/missing.gpkg"])
+        assert r.exit_code == NO_IMPORT_SOURCE, r.stderr
+        assert "No such member" in r.stderr
+
+
+def test_import_from_url(data_archive_readonly, tmp_path, cli_runner, chdir):
+    with data_archive_readonly("gpkg-points") as data:
+        serve_dir = tmp_path / "serve"
+        serve_dir.mkdir()
+        shutil.copy(data / "nz-pa-points-topo-150k.gpkg", serve_dir / "points.gpkg")
+
+    requests = []
+
+    class Handler(http.server.SimpleHTTPRequestHandler):
+        def __init__(self, *args, **kwargs):
+            super().__init__(*args, directory=str(serve_dir), **kwargs)
+
+        def send_head(self):
+            requests.append(self.headers.get("If-None-Match"))
+            etag = '"points-v1"'
+            if self.headers.get("If-None-Match") == etag:
+                self.send_response(304)
+                self.end_headers()
+                return None
+            return super().send_head()
+
+        def end_headers(self):
+            self.send_header("ETag", '"points-v1"')
+            super().end_headers()
+
+        def log_message(self, *args):
+            pass
+
+    server = http.server.ThreadingHTTPServer(("127.0.0.1", 0), Handler)
+    thread = threading.Thread(target=server.serve_forever, daemon=True)
+    thread.start()
+    url = f"http://127.0.0.1:{server.server_address[1]}/points.gpkg"
+    try:
+        repo_path = tmp_path / "repo"
+        r = cli_runner.invoke(["init", repo_path])
+        assert r.exit_code == 0, r.stderr
+        with chdir(repo_path):
+            r = cli_runner.invoke(["import", url, H.POINTS.LAYER])
+            assert r.exit_code == 0, r.stderr
+            assert f"Downloading {url} ..." in r.stdout
+            repo = KartRepo(repo_path)
+            assert (
+                repo.datasets()[H.POINTS.LAYER].feature_count == H.POINTS.ROWCOUNT
+            )
+
+            # The second time, the cached copy is used since the server says it is unchanged.
+            r = cli_runner.invoke(
+                ["import", url, f"{H.POINTS.LAYER}:points_again"]
+            )
+            assert r.exit_code == 0, r.stderr
+            assert "unchanged since last download" in r.stdout
+            assert requests == [None, '"points-v1"']
+            assert "points_again" in KartRepo(repo_path).datasets()
+
+            r = cli_runner.invoke(["import", url.replace("points", "missing")])
+            assert r.exit_code == NO_IMPORT_SOURCE, r.stderr
+            assert "HTTP 404" in r.stderr
+    finally:
+        server.shutdown()
+        server.server_close()
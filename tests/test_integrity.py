@@ -0,0 +1,76 @@
+import json
+
+import pytest
+
+from kart.exceptions import INVALID_OPERATION, NOT_FOUND
+from kart.repo import KartRepo
+
+
+H = pytest.helpers.helpers()
+
+
+def _edit_and_commit(repo, cli_runner, sql, message):
+    with repo.working_copy.tabular.session() as sess:
+        sess.execute(sql)
+    r = cli_runner.invoke(["commit", "-m", message])
+    assert r.exit_code == 0, r.stderr
+
+
+def test_check_integrity_and_merge(data_working_copy, cli_runner):
+    layer = H.POINTS.LAYER
+    with data_working_copy("points") as (repo_path, wc_path):
+        repo = KartRepo(repo_path)
+
+        r = cli_runner.invoke(["check-integrity"])
+        assert r.exit_code == NOT_FOUND, r.stderr
+
+        # Each point can refer to another point, by its fid.
+        _edit_and_commit(
+            repo, cli_runner, f"UPDATE {layer} SET t50_fid = NULL;", "Clear t50_fid"
+        )
+        r = cli_runner.invoke(
+            ["config", "--add", "kart.foreignKey", f"{layer}.t50_fid -> {layer}.fid"]
+        )
+        assert r.exit_code == 0, r.stderr
+        r = cli_runner.invoke(["check-integrity"])
+        assert r.exit_code == 0, r.stderr
+        assert r.stdout.splitlines() == [
+            "Checked 1 relationship(s): 0 violation(s) found"
+        ]
+
+        r = cli_runner.invoke(["checkout", "-b", "refer"])
+        assert r.exit_code == 0, r.stderr
+        _edit_and_commit(
+            repo,
+            cli_runner,
+            f"UPDATE {layer} SET t50_fid = 1 WHERE fid = 2;",
+            "Refer to point 1",
+        )
+        r = cli_runner.invoke(["checkout", "main"])
+        assert r.exit_code == 0, r.stderr
+        _edit_and_commit(
+            repo, cli_runner, f"DELETE FROM {layer} WHERE fid = 1;", "Delete point 1"
+        )
+
+        # Each branch is fine on its own, but merging them isn't.
+        r = cli_runner.invoke(["merge", "refer", "-m", "Merge"])
+        assert r.exit_code == INVALID_OPERATION, r.stderr
+        assert "Merging would break the referential integrity" in r.stderr
+        assert f"{layer}:feature:2 references missing value 1" in r.stderr
+
+        r = cli_runner.invoke(["merge", "refer", "-m", "Merge", "--no-integrity-check"])
+        assert r.exit_code == 0, r.stderr
+
+        r = cli_runner.invoke(["check-integrity", "-o", "json"])
+        assert r.exit_code == 1, r.stderr
+        assert json.loads(r.stdout)["kart.check-integrity/v1"]["violations"] == [
+            {
+                "foreignKey": f"{layer}.t50_fid -> {layer}.fid",
+                "dataset": layer,
+                "feature": 2,
+                "value": 1,
+            }
+        ]
+
+        r = cli_runner.invoke(["check-integrity", "HEAD^"])
+        assert r.exit_code == 0, r.stderr
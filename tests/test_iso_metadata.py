@@ -0,0 +1,103 @@
+import xml.etree.ElementTree as ET
+
+import pytest
+
+from kart.exceptions import NO_DATA
+from kart.iso_metadata import GCO_NS, GMD_NS
+from kart.repo import KartRepo
+
+
+H = pytest.helpers.helpers()
+
+NS = {"gmd": GMD_NS, "gco": GCO_NS}
+
+
+def test_export_metadata(data_archive, cli_runner):
+    layer = H.POINTS.LAYER
+    with data_archive("points") as repo_path:
+        r = cli_runner.invoke(
+            ["export-metadata", "--topic-category=location", "--process-steps=1", layer]
+        )
+        assert r.exit_code == 0, r.stderr
+
+        root = ET.fromstring(r.stdout_bytes)
+        assert root.tag == f"{{{GMD_NS}}}MD_Metadata"
+        assert (
+            root.findtext("gmd:fileIdentifier/gco:CharacterString", namespaces=NS)
+            == layer
+        )
+        assert (
+            root.findtext(
+                ".//gmd:referenceSystemIdentifier//gmd:code/gco:CharacterString",
+                namespaces=NS,
+            )
+            == "4326"
+        )
+
+        ident = root.find("gmd:identificationInfo/gmd:MD_DataIdentification", NS)
+        assert (
+            ident.findtext("gmd:topicCategory/gmd:MD_TopicCategoryCode", namespaces=NS)
+            == "location"
+        )
+        bbox = ident.find(".//gmd:EX_GeographicBoundingBox", NS)
+        west = float(
+            bbox.findtext("gmd:westBoundLongitude/gco:Decimal", namespaces=NS)
+        )
+        east = float(
+            bbox.findtext("gmd:eastBoundLongitude/gco:Decimal", namespaces=NS)
+        )
+        assert 165 < west < east < 180
+        assert "fid (integer, primary key)" in ident.findtext(
+            "gmd:supplementalInformation/gco:CharacterString", namespaces=NS
+        )
+
+        steps = root.findall(".//gmd:LI_Lineage/gmd:processStep", NS)
+        assert len(steps) == 1
+        description = steps[0].findtext(
+            ".//gmd:description/gco:CharacterString", namespaces=NS
+        )
+        assert description == KartRepo(repo_path).head_commit.message.splitlines()[0]
+
+        r = cli_runner.invoke(["export-metadata", "--no-extent", "nonexistent"])
+        assert r.exit_code == NO_DATA, r.stderr
+
+
+def test_export_metadata_release(data_archive, cli_runner, tmp_path):
+    layer = H.POINTS.LAYER
+    with data_archive("points"):
+        r = cli_runner.invoke(
+            [
+                "release",
+                "create",
+                "v1.0.0",
+                "--export=GPKG",
+                f"--output-dir={tmp_path}",
+            ]
+        )
+        assert r.exit_code == 0, r.stderr
+
+        output = tmp_path / "metadata.xml"
+        r = cli_runner.invoke(
+            ["export-metadata", "--release=v1.0.0", f"--output={output}", layer]
+        )
+        assert r.exit_code == 0, r.stderr
+        root = ET.parse(output).getroot()
+        citation = root.find(
+            ".//gmd:MD_DataIdentification/gmd:citation/gmd:CI_Citation", NS
+        )
+        assert (
+            citation.findtext("gmd:edition/gco:CharacterString", namespaces=NS)
+            == "v1.0.0"
+        )
+        date_types = [
+            e.get("codeListValue")
+            for e in citation.findall(".//gmd:CI_DateTypeCode", NS)
+        ]
+        assert date_types == ["revision", "publication"]
+        assert [
+            e.findtext("gco:CharacterString", namespaces=NS)
+            for e in root.findall(".//gmd:MD_Format/gmd:name", NS)
+        ] == ["GPKG"]
+
+        r = cli_runner.invoke(["export-metadata", "--release=v9.9.9", layer])
+        assert r.exit_code == NO_DATA, r.stderr
@@ -0,0 +1,105 @@
+import json
+
+import pytest
+
+from kart import kafka_publish
+from kart.kafka_publish import PUBLISHED_REF_PREFIX
+from kart.repo import KartRepo
+
+
+H = pytest.helpers.helpers()
+
+
+class FakeProducer:
+    def __init__(self, config):
+        self.config = config
+        self.messages = []
+
+    def produce(self, topic, key, value, on_delivery):
+        self.messages.append((topic, key, value))
+        on_delivery(None, None)
+
+    def poll(self, timeout):
+        pass
+
+    def flush(self):
+        pass
+
+
+class FakeKafka:
+    def __init__(self):
+        self.producers = []
+
+    def Producer(self, config):
+        producer = FakeProducer(config)
+        self.producers.append(producer)
+        return producer
+
+
+def test_publish_dry_run(data_archive_readonly, cli_runner):
+    layer = H.POINTS.LAYER
+    with data_archive_readonly("points") as repo_path:
+        r = cli_runner.invoke(
+            ["publish", "--kafka=localhost:9092", "--since=HEAD^", "--dry-run"]
+        )
+        assert r.exit_code == 0, r.stderr
+        messages = [json.loads(line) for line in r.stdout.splitlines()]
+        assert len(messages) == 5
+        repo = KartRepo(repo_path)
+        head = repo.head_commit.hex
+        for message in messages:
+            assert message["topic"] == "dataset-changes"
+            assert message["key"] == layer
+            value = message["value"]
+            assert value["type"] == "feature"
+            assert value["commit"] == head
+            assert value["operation"] == "update"
+            assert set(value["change"]) == {"-", "+"}
+
+        # A dry run doesn't record what was published:
+        assert f"{PUBLISHED_REF_PREFIX}dataset-changes" not in repo.references
+
+        r = cli_runner.invoke(["publish", "--kafka=localhost:9092", "--dry-run"])
+        assert r.exit_code == 2, r.stderr
+        assert "use --since" in r.stderr
+
+
+def test_publish(data_archive, cli_runner, monkeypatch):
+    kafka = FakeKafka()
+    monkeypatch.setattr(kafka_publish, "import_confluent_kafka", lambda: kafka)
+    monkeypatch.setattr(kafka_publish, "register_value_schema", lambda url, topic: 7)
+
+    with data_archive("points") as repo_path:
+        r = cli_runner.invoke(
+            [
+                "publish",
+                "--kafka=broker:9092",
+                "--topic=points",
+                "--since=HEAD^",
+                "--schema-registry=http://registry:8081",
+                "--config=client.id=kart",
+            ]
+        )
+        assert r.exit_code == 0, r.stderr
+        [producer] = kafka.producers
+        assert producer.config == {
+            "bootstrap.servers": "broker:9092",
+            "client.id": "kart",
+        }
+        assert len(producer.messages) == 5
+        topic, key, value = producer.messages[0]
+        assert topic == "points"
+        assert key == H.POINTS.LAYER.encode("utf-8")
+        # Confluent wire format - a zero byte, then the schema ID:
+        assert value[:5] == b"\x00\x00\x00\x00\x07"
+        assert json.loads(value[5:])["type"] == "feature"
+
+        repo = KartRepo(repo_path)
+        ref = repo.references[f"{PUBLISHED_REF_PREFIX}points"]
+        assert ref.target == repo.head_commit.id
+
+        # Next time, only new changes are published:
+        r = cli_runner.invoke(["publish", "--kafka=broker:9092", "--topic=points"])
+        assert r.exit_code == 0, r.stderr
+        assert len(kafka.producers) == 2
+        assert kafka.producers[1].messages == []
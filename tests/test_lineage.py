@@ -0,0 +1,135 @@
+import json
+
+import pytest
+
+from kart.exceptions import NO_DATA
+from kart.lineage import LINEAGE_NOTES_REF
+from kart.repo import KartRepo
+
+
+H = pytest.helpers.helpers()
+
+
+def test_lineage(data_archive_readonly, cli_runner, chdir, tmp_path):
+    repo_path = tmp_path / "repo"
+    with data_archive_readonly("gpkg-points") as data:
+        gpkg = data / "nz-pa-points-topo-150k.gpkg"
+        r = cli_runner.invoke(["init", "--import", gpkg, str(repo_path)])
+        assert r.exit_code == 0, r.stderr
+
+        with chdir(repo_path):
+            repo = KartRepo(repo_path)
+            source_commit = repo.head_commit.id.hex
+
+            r = cli_runner.invoke(
+                [
+                    "import",
+                    gpkg,
+                    f"{H.POINTS.LAYER}:derived",
+                    "--derived-from=nope@HEAD",
+                ]
+            )
+            assert r.exit_code == NO_DATA, r.stderr
+            r = cli_runner.invoke(
+                ["import", gpkg, f"{H.POINTS.LAYER}:derived", "--derived-from=HEAD"]
+            )
+            assert r.exit_code == 2, r.stderr
+
+            r = cli_runner.invoke(
+                [
+                    "import",
+                    gpkg,
+                    f"{H.POINTS.LAYER}:derived",
+                    f"--derived-from={H.POINTS.LAYER}@HEAD",
+                ]
+            )
+            assert r.exit_code == 0, r.stderr
+            derived_commit = repo.head_commit.id.hex
+            assert json.loads(
+                repo.lookup_note(derived_commit, LINEAGE_NOTES_REF).message
+            ) == {
+                "datasets": ["derived"],
+                "derivedFrom": [{"dataset": H.POINTS.LAYER, "commit": source_commit}],
+            }
+
+            r = cli_runner.invoke(
+                [
+                    "import",
+                    gpkg,
+                    f"{H.POINTS.LAYER}:product",
+                    "--derived-from=derived@HEAD",
+                ]
+            )
+            assert r.exit_code == 0, r.stderr
+            product_commit = repo.head_commit.id.hex
+
+    with chdir(repo_path):
+        r = cli_runner.invoke(["lineage", "show", "product", "-o", "json"])
+        assert r.exit_code == 0, r.stderr
+        assert json.loads(r.stdout)["kart.lineage/v1"] == {
+            "nodes": [
+                {"dataset": "product", "commit": product_commit},
+                {"dataset": "derived", "commit": derived_commit},
+                {"dataset": H.POINTS.LAYER, "commit": source_commit},
+            ],
+            "edges": [
+                {
+                    "derived": {"dataset": "product", "commit": product_commit},
+                    "source": {"dataset": "derived", "commit": derived_commit},
+                    "recordedIn": product_commit,
+                },
+                {
+                    "derived": {"dataset": "derived", "commit": derived_commit},
+                    "source": {"dataset": H.POINTS.LAYER, "commit": source_commit},
+                    "recordedIn": derived_commit,
+                },
+            ],
+        }
+
+        r = cli_runner.invoke(["lineage", "show", "product"])
+        assert r.exit_code == 0, r.stderr
+        assert r.stdout.splitlines() == [
+            f"product@{product_commit[:7]}",
+            f"  derived from derived@{derived_commit[:7]} in commit {product_commit[:7]}",
+            f"    derived from {H.POINTS.LAYER}@{source_commit[:7]} in commit {derived_commit[:7]}",
+        ]
+
+        # The source dataset wasn't derived from anything.
+        r = cli_runner.invoke(["lineage", "show", H.POINTS.LAYER])
+        assert r.exit_code == 0, r.stderr
+        assert r.stdout.splitlines()[1:] == ["No lineage recorded"]
+
+        # At the earlier commit, the product dataset didn't exist yet.
+        r = cli_runner.invoke(["lineage", "show", "product", derived_commit])
+        assert r.exit_code == NO_DATA, r.stderr
+
+
+def test_commit_derived_from(data_working_copy, cli_runner, edit_points):
+    with data_working_copy("points") as (repo_path, wc_path):
+        repo = KartRepo(repo_path)
+        source_commit = repo.head_commit.id.hex
+        with repo.working_copy.tabular.session() as sess:
+            edit_points(sess)
+
+        r = cli_runner.invoke(
+            [
+                "commit",
+                "-m",
+                "Cleaned points",
+                f"--derived-from={H.POINTS.LAYER}@HEAD",
+            ]
+        )
+        assert r.exit_code == 0, r.stderr
+
+        r = cli_runner.invoke(["lineage", "show", H.POINTS.LAYER, "-o", "json"])
+        assert r.exit_code == 0, r.stderr
+        assert json.loads(r.stdout)["kart.lineage/v1"]["edges"] == [
+            {
+                "derived": {
+                    "dataset": H.POINTS.LAYER,
+                    "commit": repo.head_commit.id.hex,
+                },
+                "source": {"dataset": H.POINTS.LAYER, "commit": source_commit},
+                "recordedIn": repo.head_commit.id.hex,
+            }
+        ]
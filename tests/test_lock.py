@@ -0,0 +1,141 @@
+import json
+import subprocess
+
+import pytest
+
+from kart import subprocess_util
+from kart.exceptions import INVALID_OPERATION
+from kart.lock import push_positional_args
+from kart.sqlalchemy.gpkg import Db_GPKG
+
+
+H = pytest.helpers.helpers()
+
+
+def test_lock_acquire_release(
+    data_working_copy, cli_runner, insert, tmp_path, monkeypatch
+):
+    layer = H.POINTS.LAYER
+    with data_working_copy("points") as (repo_path, wc):
+        subprocess.run(["git", "init", "--bare", str(tmp_path)], check=True)
+        r = cli_runner.invoke(["remote", "add", "origin", tmp_path])
+        assert r.exit_code == 0, r.stderr
+        r = cli_runner.invoke(["push", "--set-upstream", "origin", "main"])
+        assert r.exit_code == 0, r.stderr
+
+        r = cli_runner.invoke(["lock", "acquire", layer, "-m", "Fixing road names"])
+        assert r.exit_code == 0, r.stderr
+        assert r.stdout.splitlines() == [f"Acquired lock on {layer} in origin"]
+
+        r = cli_runner.invoke(["lock", "list", "-o", "json"])
+        assert r.exit_code == 0, r.stderr
+        [lock] = json.loads(r.stdout)["kart.lock/v1"]
+        assert lock["dataset"] == layer
+        assert lock["owner"] == "Kart Tester <kart-tester@example.com>"
+        assert lock["message"] == "Fixing road names"
+
+        # The user who holds the lock can still commit:
+        with Db_GPKG.create_engine(wc).connect() as conn:
+            insert(conn)
+
+        # But other users can't:
+        monkeypatch.setenv("GIT_AUTHOR_EMAIL", "bob@example.com")
+        monkeypatch.setenv("GIT_COMMITTER_EMAIL", "bob@example.com")
+        with Db_GPKG.create_engine(wc).connect() as conn:
+            insert(conn, commit=False)
+        r = cli_runner.invoke(["commit", "-m", "bob's edit"])
+        assert r.exit_code == INVALID_OPERATION, r.stderr
+        assert f"{layer} is locked by Kart Tester" in r.stderr
+
+        r = cli_runner.invoke(["commit", "-m", "bob's edit", "--ignore-locks"])
+        assert r.exit_code == 0, r.stderr
+        r = cli_runner.invoke(["push"])
+        assert r.exit_code == INVALID_OPERATION, r.stderr
+        assert "Can't push" in r.stderr
+        # Pushing the same commits to a branch that isn't on the remote yet is checked too:
+        for push_args in (["HEAD:bobs-edits"], ["main:refs/heads/bobs-edits"]):
+            r = cli_runner.invoke(["push", "origin", *push_args])
+            assert r.exit_code == INVALID_OPERATION, r.stderr
+            assert "Can't push" in r.stderr
+        r = cli_runner.invoke(["checkout", "-b", "bobs-edits"])
+        assert r.exit_code == 0, r.stderr
+        r = cli_runner.invoke(["push", "origin", "bobs-edits"])
+        assert r.exit_code == INVALID_OPERATION, r.stderr
+        r = cli_runner.invoke(["checkout", "main"])
+        assert r.exit_code == 0, r.stderr
+
+        r = cli_runner.invoke(["lock", "release", layer])
+        assert r.exit_code == INVALID_OPERATION, r.stderr
+        assert "Use --force" in r.stderr
+
+        monkeypatch.delenv("GIT_AUTHOR_EMAIL")
+        monkeypatch.delenv("GIT_COMMITTER_EMAIL")
+        r = cli_runner.invoke(["lock", "release", layer])
+        assert r.exit_code == 0, r.stderr
+
+        r = cli_runner.invoke(["lock", "list"])
+        assert r.exit_code == 0, r.stderr
+        assert r.stdout.splitlines() == ["No datasets are locked in origin"]
+
+        r = cli_runner.invoke(["push"])
+        assert r.exit_code == 0, r.stderr
+
+
+def test_push_positional_args():
+    assert push_positional_args(["-o", "ci.skip", "origin", "main"]) == [
+        "origin",
+        "main",
+    ]
+    assert push_positional_args(
+        ["--force", "--push-option", "a=b", "upstream", "+feature:main"]
+    ) == ["upstream", "+feature:main"]
+    assert push_positional_args(["--receive-pack", "git-receive-pack"]) == []
+
+
+def test_push_fetches_locks_with_tls_options(
+    data_archive, cli_runner, tmp_path, monkeypatch
+):
+    cert_path, key_path, ca_path = (
+        tmp_path / f for f in ("client.crt", "client.key", "ca.crt")
+    )
+    for f in (cert_path, key_path, ca_path):
+        f.write_text("dummy")
+    remote_path = tmp_path / "remote.git"
+
+    with data_archive("points"):
+        subprocess.run(["git", "init", "--bare", str(remote_path)], check=True)
+        r = cli_runner.invoke(["remote", "add", "origin", remote_path])
+        assert r.exit_code == 0, r.stderr
+        r = cli_runner.invoke(["push", "--set-upstream", "origin", "main"])
+        assert r.exit_code == 0, r.stderr
+
+        commands = []
+        orig_check_call = subprocess_util.check_call
+
+        def _check_call(cmd, *args, **kwargs):
+            commands.append([str(c) for c in cmd])
+            return orig_check_call(cmd, *args, **kwargs)
+
+        monkeypatch.setattr(subprocess_util, "check_call", _check_call)
+        r = cli_runner.invoke(
+            [
+                "push",
+                "--cert",
+                cert_path,
+                "--key",
+                key_path,
+                "--ca",
+                ca_path,
+                "origin",
+                "main",
+            ]
+        )
+        assert r.exit_code == 0, r.stderr
+        [fetch_command] = [c for c in commands if "fetch" in c]
+        for config in (
+            f"http.sslCert={cert_path}",
+            f"http.sslKey={key_path}",
+            f"http.sslCAInfo={ca_path}",
+        ):
+            i = fetch_command.index(config)
+            assert fetch_command[i - 1] == "-c"
@@ -453,3 +453,27 @@ def test_log_decorate(data_archive, args, expected_ref, cli_runner):
         r = cli_runner.invoke(["log", *args])
         assert r.exit_code == 0, r
         assert get_log_refs(r) == expected_ref
+
+
+def test_log_dot(data_archive_readonly, cli_runner):
+    with data_archive_readonly("points"):
+        r = cli_runner.invoke(["log", "--dot"])
+        assert r.exit_code == 0, r.stderr
+        assert r.stdout.splitlines() == [
+            "digraph kart_log {",
+            '  node [shape=box, fontname="monospace"];',
+            f'  "{H.POINTS.HEAD_SHA}" [label="{H.POINTS.HEAD_SHA[:7]}\\nImprove naming on Coromandel East coast"];',
+            f'  "{H.POINTS.HEAD_SHA}" -> "{H.POINTS.HEAD1_SHA}";',
+            '  "ref:HEAD -> main" [label="HEAD -> main", shape=ellipse, style=filled, fillcolor=lightgrey];',
+            f'  "ref:HEAD -> main" -> "{H.POINTS.HEAD_SHA}" [style=dashed];',
+            f'  "{H.POINTS.HEAD1_SHA}" [label="{H.POINTS.HEAD1_SHA[:7]}\\nImport from nz-pa-points-topo-150k.gpkg"];',
+            "}",
+        ]
+
+        # Commits outside the log aren't included:
+        r = cli_runner.invoke(["log", "--dot", "-n", "1"])
+        assert r.exit_code == 0, r.stderr
+        assert H.POINTS.HEAD1_SHA not in r.stdout
+
+        r = cli_runner.invoke(["log", "--dot", "-o", "json"])
+        assert r.exit_code == 2, r.stderr
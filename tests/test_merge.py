@@ -10,6 +10,7 @@ from kart.merge_util import (
     MERGE_MSG,
     ALL_MERGE_FILES,
 )
+from kart.pk_remap import get_pk_remaps
 from kart.repo import KartRepo, KartRepoState
 
 
@@ -333,6 +334,132 @@ def test_merge_conflicts(
             assert not repo.gitdir_file(filename).exists()
 
 
+def test_merge_strategy(data_archive, cli_runner):
+    with data_archive("conflicts/polygons.tgz") as repo_path:
+        repo = KartRepo(repo_path)
+        layer = H.POLYGONS.LAYER
+
+        # Both branches changed survey_reference, so this can't be resolved attribute-by-attribute -
+        # unless there is a rule for that column.
+        r = cli_runner.invoke(
+            [
+                "merge",
+                "theirs_branch",
+                "--strategy=union-attributes",
+                "--dry-run",
+                "-o",
+                "json",
+            ]
+        )
+        assert r.exit_code == 0, r.stderr
+        jdict = json.loads(r.stdout)["kart.merge/v1"]
+        assert jdict["autoResolved"] < 4
+        assert jdict["conflicts"] == {layer: {"feature": 4 - jdict["autoResolved"]}}
+
+        r = cli_runner.invoke(
+            [
+                "merge",
+                "theirs_branch",
+                "--column-rules=nope",
+            ]
+        )
+        assert r.exit_code == 2, r.stderr
+        assert "Invalid JSON" in r.stderr
+
+        r = cli_runner.invoke(["merge", "theirs_branch", "--strategy=theirs"])
+        assert r.exit_code == 0, r.stderr
+        lines = r.stdout.splitlines()
+        assert lines[:3] == [
+            'Merging branch "theirs_branch" into ours_branch',
+            "Automatically resolved 4 conflicts",
+            "No conflicts!",
+        ]
+        assert repo.state == KartRepoState.NORMAL
+
+        head = repo.head_commit
+        assert [p.hex for p in head.parent_ids] == [
+            CommitWithReference.resolve(repo, "ours_branch").id.hex,
+            CommitWithReference.resolve(repo, "theirs_branch").id.hex,
+        ]
+        merged = repo.datasets("HEAD")[layer]
+        theirs = repo.datasets("theirs_branch")[layer]
+        for feature in theirs.features():
+            if feature["survey_reference"] == "theirs_version":
+                merged_feature = merged.get_feature([feature["id"]])
+                assert merged_feature["survey_reference"] == "theirs_version"
+
+
+def test_merge_renumber_pk_collisions(data_archive, cli_runner):
+    with data_archive("conflicts/inserts.tgz") as repo_path:
+        repo = KartRepo(repo_path)
+        r = cli_runner.invoke(
+            [
+                "merge",
+                "theirs_branch",
+                "--renumber-pk-collisions=theirs",
+                "-m",
+                "Merge with theirs_branch",
+                "-o",
+                "json",
+            ]
+        )
+        assert r.exit_code == 0, r.stderr
+        jdict = json.loads(r.stdout)["kart.merge/v1"]
+        assert jdict["conflicts"] is None
+        assert repo.state == KartRepoState.NORMAL
+
+        # The conflicting PKs from 11-14 are renumbered to 17-20.
+        pk_remaps = jdict["pkRemaps"]
+        theirs_id = CommitWithReference.resolve(repo, "theirs_branch").id.hex
+        assert [(r["oldPk"], r["renumbered"], r["commit"]) for r in pk_remaps] == [
+            (pk, "theirs", theirs_id) for pk in range(11, 15)
+        ]
+        assert sorted(r["newPk"] for r in pk_remaps) == list(range(17, 21))
+        assert get_pk_remaps(repo, repo.head_commit) == pk_remaps
+
+        merged = repo.datasets("HEAD")["boys_names"]
+        theirs = repo.datasets("theirs_branch")["boys_names"]
+        for remap in pk_remaps:
+            assert merged.get_feature([remap["newPk"]])["name"] == (
+                theirs.get_feature([remap["oldPk"]])["name"]
+            )
+        assert merged.feature_count == 10
+
+
+def test_merge_renumber_pk_collisions_config(data_archive, cli_runner):
+    with data_archive("conflicts/inserts.tgz") as repo_path:
+        repo = KartRepo(repo_path)
+        repo.config["kart.merge.renumberPkCollisions"] = "ours"
+
+        r = cli_runner.invoke(["merge", "theirs_branch", "--dry-run"])
+        assert r.exit_code == 0, r.stderr
+        lines = r.stdout.splitlines()
+        assert "Renumbered boys_names:feature:11 from ours to boys_names:feature:" in (
+            lines[2]
+        )
+        assert "No conflicts: merge will succeed!" in lines
+
+        # Collisions can still be left as conflicts, and then renumbered by hand.
+        r = cli_runner.invoke(
+            ["merge", "theirs_branch", "--renumber-pk-collisions=none"]
+        )
+        assert r.exit_code == 0, r.stderr
+        assert repo.state == KartRepoState.MERGING
+        r = cli_runner.invoke(["resolve", "--renumber=theirs"])
+        assert r.exit_code == 0, r.stderr
+        r = cli_runner.invoke(["merge", "--continue", "-m", "Merge with theirs_branch"])
+        assert r.exit_code == 0, r.stderr
+
+        pk_remaps = get_pk_remaps(repo, repo.head_commit)
+        assert [(r["oldPk"], r["renumbered"]) for r in pk_remaps] == [
+            (pk, "theirs") for pk in range(11, 15)
+        ]
+
+        repo.config["kart.merge.renumberPkCollisions"] = "both"
+        r = cli_runner.invoke(["merge", "theirs_branch"])
+        assert r.exit_code == INVALID_OPERATION, r.stderr
+
+
 def test_merge_state_lock(data_archive, cli_runner):
     with data_archive("conflicts/points.tgz") as repo_path:
         repo = KartRepo(repo_path)
@@ -176,6 +176,43 @@ def test_meta_set_custom_fields(data_archive, cli_runner):
         assert meta["custom_list.json"]["+"] == [1, 2, 3]
 
 
+def test_meta_set_description(data_archive, cli_runner, tmp_path):
+    readme_path = tmp_path / "README.md"
+    readme_path.write_text("# NZ Pa Points\n\nNot suitable for navigation.\n")
+    with data_archive("points"):
+        r = cli_runner.invoke(["data", "show", "nz_pa_points_topo_150k"])
+        assert r.exit_code == 0, r.stderr
+        assert "No README" in r.stdout
+
+        r = cli_runner.invoke(
+            ["meta", "set-description", "nz_pa_points_topo_150k", readme_path]
+        )
+        assert r.exit_code == 0, r.stderr
+        r = cli_runner.invoke(["show", "-o", "json"])
+        assert r.exit_code == 0, r.stderr
+        output = json.loads(r.stdout)
+        patch_info = output.pop("kart.show/v1")
+        assert patch_info["message"] == "Update README for nz_pa_points_topo_150k"
+        meta = output["kart.diff/v1+hexwkb"]["nz_pa_points_topo_150k"]["meta"]
+        assert meta["readme.md"] == {
+            "+": "# NZ Pa Points\n\nNot suitable for navigation.\n"
+        }
+
+        r = cli_runner.invoke(["data", "show", "nz_pa_points_topo_150k"])
+        assert r.exit_code == 0, r.stderr
+        lines = r.stdout.splitlines()
+        assert lines[:2] == [
+            "nz_pa_points_topo_150k\t(table.v3)",
+            "Title: NZ Pa Points (Topo, 1:50k)",
+        ]
+        assert lines[-3:] == ["# NZ Pa Points", "", "Not suitable for navigation."]
+        r = cli_runner.invoke(
+            ["data", "show", "nz_pa_points_topo_150k", "--ref", "HEAD^", "-o", "json"]
+        )
+        assert r.exit_code == 0, r.stderr
+        assert json.loads(r.stdout)["kart.data.show/v1"]["readme"] is None
+
+
 def test_meta_get_ref(data_archive, cli_runner):
     with data_archive("points"):
         r = cli_runner.invoke(
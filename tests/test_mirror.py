@@ -0,0 +1,42 @@
+import json
+
+from kart.repo import KartRepo
+
+
+def test_mirror(data_archive, cli_runner, tmp_path):
+    mirror_path = tmp_path / "mirror"
+    with data_archive("points") as remote_path:
+        remote_repo = KartRepo(remote_path)
+        r = cli_runner.invoke(["mirror", remote_path, mirror_path, "-o", "json"])
+        assert r.exit_code == 0, r.stderr
+        result = json.loads(r.stdout)["kart.mirror/v1"]
+        assert result["updated"] == {}
+        assert result["rejected"] == []
+
+        mirror_repo = KartRepo(mirror_path)
+        assert mirror_repo.head_commit.id == remote_repo.head_commit.id
+
+        # Fast-forward changes are mirrored:
+        r = cli_runner.invoke(["commit-files", "-m", "new commit", "a=b"])
+        assert r.exit_code == 0, r.stderr
+        new_head = remote_repo.head_commit.hex
+        r = cli_runner.invoke(["mirror", remote_path, mirror_path, "-o", "json"])
+        assert r.exit_code == 0, r.stderr
+        result = json.loads(r.stdout)["kart.mirror/v1"]
+        assert result["updated"]["refs/heads/main"]["new"] == new_head
+        assert mirror_repo.head_commit.hex == new_head
+
+        # Rewritten history is not:
+        remote_repo.references["refs/heads/main"].set_target(
+            remote_repo.head_commit.parents[0].id
+        )
+        r = cli_runner.invoke(["mirror", remote_path, mirror_path])
+        assert r.exit_code == 1, r.stderr
+        assert "Mirror sync failed" in r.stdout
+        assert "main: rejected" in r.stdout
+        assert mirror_repo.head_commit.hex == new_head
+
+        # A mirror can't be switched to a different remote:
+        r = cli_runner.invoke(["mirror", tmp_path / "other", mirror_path])
+        assert r.exit_code == 20, r.stderr
+        assert "already a mirror of a different remote" in r.stderr
@@ -0,0 +1,209 @@
+import os
+import threading
+
+import click
+import pytest
+
+from kart import cli
+from kart.exceptions import FILE_LOCKED, InvalidOperation
+from kart.process_lock import RepoWriteLock
+from kart.repo import KartRepo
+
+# Commands that don't take the write lock. Every other command must be declared with writes_to_repo=True - so a new
+# command that writes to the repository has to either be marked, or be added here along with the reason why not.
+UNLOCKED_COMMANDS = {
+    # Only read the repository:
+    "archive list",
+    "audit log",
+    "backup",
+    "bundle create",
+    "changed-tiles",
+    "changelog",
+    "changes",
+    "check-integrity",
+    "conflicts",
+    "create-patch",
+    "data ls",
+    "data show",
+    "data version",
+    "diff",
+    "diff-external",
+    "export",
+    "export-metadata",
+    "features-at",
+    "generate-fixture",
+    "id-map export",
+    "lfs+ ls-files",
+    "lineage show",
+    "lock list",
+    "log",
+    "meta get",
+    "profile list",
+    "profile show",
+    "protect list",
+    "push-wfst",
+    "release list",
+    "release publish-stac",
+    "release show",
+    "schema log",
+    "search",
+    "session list",
+    "show",
+    "spatial-filter resolve",
+    "stats diff",
+    "stats show",
+    "status",
+    "style export",
+    "style get",
+    "whoami",
+    # Help, commands that don't use a repository, and commands that run other commands - which take the lock
+    # themselves if they need it:
+    "help",
+    "bisect help",
+    "data help",
+    "install help",
+    "lfs+ help",
+    "meta help",
+    "spatial-filter help",
+    "workspace help",
+    "install tab-completion",
+    "ext-run",
+    "helper",
+    "rpc",
+    # Create a new repository, or work on repositories other than the current one:
+    "clone",
+    "init",
+    "mirror",
+    "upgrade",
+    "workspace checkout",
+    "workspace status",
+    "workspace update",
+    # Passed straight to git, which locks the refs and config that it changes itself:
+    "branch",
+    "config",
+    "fetch",
+    "gc",
+    "git",
+    "lfs",
+    "push",
+    "reflog",
+    "remote",
+    "tag",
+    # Hooks, which run while git is already updating the repository:
+    "guardrails pre-receive",
+    "lfs+ pre-push",
+    # Only write caches, indexes or LFS objects, which other commands don't change:
+    "build-annotations",
+    "lfs+ fetch",
+    "lfs+ gc",
+    "spatial-filter index",
+    # Only writes refs of its own, which no other command changes - and with --interval, it runs indefinitely:
+    "publish",
+    # Only takes the lock when it is asked to repair or reset something:
+    "fsck",
+}
+
+
+def _all_commands(group, parent_names=()):
+    for name, command in group.commands.items():
+        names = (*parent_names, name)
+        if isinstance(command, click.Group):
+            yield from _all_commands(command, names)
+        else:
+            yield " ".join(names), command
+
+
+def test_every_command_declares_whether_it_writes():
+    cli.load_all_commands()
+    unlocked = {
+        name
+        for name, command in _all_commands(cli.cli)
+        if not getattr(command, "writes_to_repo", False)
+    }
+    assert unlocked == UNLOCKED_COMMANDS
+
+
+def test_write_lock(data_archive, cli_runner):
+    with data_archive("points") as repo_path:
+        repo = KartRepo(repo_path)
+        lock = RepoWriteLock(repo, ["kart", "import", "example.gpkg"])
+        lock.acquire()
+        try:
+            r = cli_runner.invoke(["commit-files", "-m", "first", "a=b"])
+            assert r.exit_code == FILE_LOCKED, r.stderr
+            assert (
+                f"Kart process {os.getpid()} (kart import example.gpkg) is writing"
+                in r.stderr
+            )
+            # Read-only commands don't need the lock:
+            r = cli_runner.invoke(["log"])
+            assert r.exit_code == 0, r.stderr
+        finally:
+            lock.release()
+
+        r = cli_runner.invoke(["commit-files", "-m", "first", "a=b"])
+        assert r.exit_code == 0, r.stderr
+        assert lock.holder()["commandLine"] == [
+            "kart",
+            "commit-files",
+            "-m",
+            "first",
+            "a=b",
+        ]
+
+
+@pytest.mark.parametrize(
+    "command",
+    [
+        ["data", "rm", "nz_pa_points_topo_150k", "-m", "Remove"],
+        ["meta", "set", "nz_pa_points_topo_150k", "title=New title"],
+        ["lock", "acquire", "nz_pa_points_topo_150k"],
+    ],
+)
+def test_write_lock_blocks_subcommands(data_archive, cli_runner, command):
+    with data_archive("points") as repo_path:
+        repo = KartRepo(repo_path)
+        head_commit_id = repo.head_commit.id
+        lock = RepoWriteLock(repo, ["kart", "import", "example.gpkg"])
+        lock.acquire()
+        try:
+            r = cli_runner.invoke(command)
+            assert r.exit_code == FILE_LOCKED, r.stderr
+            # Read-only subcommands of the same group don't need the lock:
+            r = cli_runner.invoke(["data", "ls"])
+            assert r.exit_code == 0, r.stderr
+        finally:
+            lock.release()
+        assert repo.head_commit.id == head_commit_id
+
+
+def test_write_lock_wait(data_archive, cli_runner):
+    with data_archive("points") as repo_path:
+        repo = KartRepo(repo_path)
+        lock = RepoWriteLock(repo, ["kart", "import", "example.gpkg"])
+        lock.acquire()
+        timer = threading.Timer(1, lock.release)
+        timer.start()
+        try:
+            r = cli_runner.invoke(["--wait", "commit-files", "-m", "first", "a=b"])
+            assert r.exit_code == 0, r.stderr
+            assert "Waiting for Kart process" in r.stderr
+        finally:
+            timer.join()
+
+
+def test_create_commit_checks_ref_is_unchanged(data_archive):
+    with data_archive("points") as repo_path:
+        repo = KartRepo(repo_path)
+        head_commit = repo.head_commit
+        parent = head_commit.parents[0]
+        with pytest.raises(InvalidOperation, match="changed by another process"):
+            repo.create_commit(
+                repo.head_branch,
+                repo.author_signature(),
+                repo.committer_signature(),
+                "Based on an out-of-date commit",
+                parent.tree_id,
+                [parent.id],
+            )
+        assert repo.head_commit.id == head_commit.id
@@ -0,0 +1,119 @@
+import json
+
+import pytest
+
+from kart.exceptions import INVALID_OPERATION, NO_DATA
+from kart.repo import KartRepo
+
+
+H = pytest.helpers.helpers()
+
+NULL_ID = "0" * 40
+
+
+def test_protect_add_list_remove(data_archive, cli_runner):
+    layer = H.POINTS.LAYER
+    with data_archive("points"):
+        r = cli_runner.invoke(["protect", "add", "main", "nonexistent"])
+        assert r.exit_code == NO_DATA, r.stderr
+
+        r = cli_runner.invoke(
+            ["protect", "add", "main", layer, "--require-merge", "--require-check=true"]
+        )
+        assert r.exit_code == 0, r.stderr
+        assert "kart guardrails install-hook" in r.stderr
+
+        r = cli_runner.invoke(["protect", "list"])
+        assert r.exit_code == 0, r.stderr
+        assert r.stdout.splitlines() == [
+            f"main ({layer}): no force-push; changes only by merging; check `true`"
+        ]
+
+        # Adding protection again replaces the existing rules.
+        r = cli_runner.invoke(["protect", "add", "main", "--allow-force-push"])
+        assert r.exit_code == 0, r.stderr
+        r = cli_runner.invoke(["protect", "list", "-o", "json"])
+        assert r.exit_code == 0, r.stderr
+        assert json.loads(r.stdout) == {
+            "kart.protect/v1": [
+                {
+                    "branch": "main",
+                    "datasets": None,
+                    "allowForcePush": True,
+                    "requireMerge": False,
+                    "requiredChecks": [],
+                }
+            ]
+        }
+
+        r = cli_runner.invoke(["protect", "remove", "main"])
+        assert r.exit_code == 0, r.stderr
+        r = cli_runner.invoke(["protect", "list"])
+        assert r.exit_code == 0, r.stderr
+        assert r.stdout.splitlines() == ["No branches are protected"]
+
+
+def test_pre_receive_protected_branch(data_working_copy, cli_runner):
+    layer = H.POINTS.LAYER
+    with data_working_copy("points") as (repo_path, wc):
+        repo = KartRepo(repo_path)
+        base = repo.head_commit.hex
+
+        r = cli_runner.invoke(["checkout", "-b", "edits"])
+        assert r.exit_code == 0, r.stderr
+        with repo.working_copy.tabular.session() as sess:
+            sess.execute(f"UPDATE {layer} SET name = 'test' WHERE fid = 1;")
+        r = cli_runner.invoke(["commit", "-m", "Direct edit"])
+        assert r.exit_code == 0, r.stderr
+        direct = repo.head_commit.hex
+
+        r = cli_runner.invoke(["checkout", "main"])
+        assert r.exit_code == 0, r.stderr
+        r = cli_runner.invoke(["merge", "edits", "--no-ff"])
+        assert r.exit_code == 0, r.stderr
+        merge = repo.head_commit.hex
+
+        r = cli_runner.invoke(["protect", "add", "main", "--require-merge"])
+        assert r.exit_code == 0, r.stderr
+
+        def pre_receive(old, new, ref="refs/heads/main"):
+            return cli_runner.invoke(
+                ["guardrails", "pre-receive"], input=f"{old} {new} {ref}\n"
+            )
+
+        r = pre_receive(base, direct)
+        assert r.exit_code == INVALID_OPERATION, r.stderr
+        assert "Can't update protected branch main" in r.stderr
+        assert f"changes {layer} directly" in r.stderr
+        r = pre_receive(base, merge)
+        assert r.exit_code == 0, r.stderr
+        # Other branches aren't protected.
+        r = pre_receive(base, direct, ref="refs/heads/edits")
+        assert r.exit_code == 0, r.stderr
+
+        r = pre_receive(merge, base)
+        assert r.exit_code == INVALID_OPERATION, r.stderr
+        assert f"would rewrite the history of {layer}" in r.stderr
+        r = pre_receive(merge, NULL_ID)
+        assert r.exit_code == INVALID_OPERATION, r.stderr
+        assert "protected branches can't be deleted" in r.stderr
+        r = pre_receive(NULL_ID, merge)
+        assert r.exit_code == 0, r.stderr
+
+        r = cli_runner.invoke(["protect", "add", "main", "--require-check=exit 3"])
+        assert r.exit_code == 0, r.stderr
+        r = pre_receive(base, merge)
+        assert r.exit_code == INVALID_OPERATION, r.stderr
+        assert "required check `exit 3` failed with exit code 3" in r.stderr
+
+        r = cli_runner.invoke(
+            [
+                "protect",
+                "add",
+                "main",
+                '--require-check=test "$KART_PROTECT_NEW" = ' + merge,
+            ]
+        )
+        assert r.exit_code == 0, r.stderr
+        r = pre_receive(base, merge)
+        assert r.exit_code == 0, r.stderr
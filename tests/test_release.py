@@ -0,0 +1,157 @@
+import json
+
+import pytest
+from osgeo import ogr
+
+from kart.exceptions import INVALID_ARGUMENT, INVALID_OPERATION, NO_DATA
+from kart.release import parse_version
+
+
+H = pytest.helpers.helpers()
+
+
+def test_parse_version():
+    assert parse_version("v1.2.0") == (1, 2, 0, True, ())
+    assert parse_version("1.2.0-beta.1") < parse_version("1.2.0")
+    assert parse_version("v1.10.0") > parse_version("v1.9.3")
+    assert parse_version("1.2.0-beta.10") > parse_version("1.2.0-beta.2")
+    assert parse_version("1.2.0-beta.2") > parse_version("1.2.0-beta")
+    assert parse_version("1.2.0-beta") > parse_version("1.2.0-alpha.beta")
+    assert parse_version("1.2.0-alpha.beta") > parse_version("1.2.0-alpha.1")
+    assert parse_version("1.2.0-alpha.1") > parse_version("1.2.0-alpha")
+    assert parse_version("1.2.0-rc.1") > parse_version("1.2.0-beta.11")
+    for bad in ("1.2", "v01.2.3", "release-1"):
+        with pytest.raises(ValueError):
+            parse_version(bad)
+
+
+def test_release_create(data_archive, cli_runner, tmp_path):
+    layer = H.POINTS.LAYER
+    with data_archive("points"):
+        r = cli_runner.invoke(
+            [
+                "release",
+                "create",
+                "v1.0.0",
+                "--ref=HEAD^",
+                "--export=GPKG",
+                "--export=GEOJSON",
+                f"--output-dir={tmp_path}",
+            ]
+        )
+        assert r.exit_code == 0, r.stderr
+        assert r.stdout.splitlines()[-1].startswith("Created release v1.0.0 at ")
+
+        release_dir = tmp_path / "v1.0.0"
+        assert {p.name for p in release_dir.iterdir()} == {
+            "v1.0.0.gpkg",
+            f"{layer}.geojson",
+            "manifest.json",
+        }
+        ogr_ds = ogr.Open(str(release_dir / "v1.0.0.gpkg"))
+        assert ogr_ds.GetLayerByName(layer).GetFeatureCount() == H.POINTS.ROWCOUNT
+        ogr_ds = None
+
+        manifest = json.loads((release_dir / "manifest.json").read_text())[
+            "kart.release/v1"
+        ]
+        assert manifest["previous"] is None
+        stats = manifest["datasets"][layer]
+        assert stats["featureCount"] == H.POINTS.ROWCOUNT
+        assert len(stats["extent"]) == 4
+        assert [a["format"] for a in manifest["artifacts"]] == ["GPKG", "GEOJSON"]
+
+        # The tag exists, so it can be used anywhere a ref can:
+        r = cli_runner.invoke(["changelog", "--since=v1.0.0", "-o", "json"])
+        assert r.exit_code == 0, r.stderr
+
+        r = cli_runner.invoke(["release", "create", "v1.0.0"])
+        assert r.exit_code == INVALID_OPERATION, r.stderr
+        r = cli_runner.invoke(["release", "create", "v0.9.0"])
+        assert r.exit_code == INVALID_ARGUMENT, r.stderr
+        assert "isn't newer than the latest release v1.0.0" in r.stderr
+        r = cli_runner.invoke(["release", "create", "latest"])
+        assert r.exit_code == INVALID_ARGUMENT, r.stderr
+
+        r = cli_runner.invoke(["release", "create", "v1.1.0", "--no-extent"])
+        assert r.exit_code == 0, r.stderr
+
+        r = cli_runner.invoke(["release", "list", "-o", "json"])
+        assert r.exit_code == 0, r.stderr
+        releases = json.loads(r.stdout)["kart.releases/v1"]
+        assert [rel["version"] for rel in releases] == ["v1.1.0", "v1.0.0"]
+        assert releases[0]["previous"] == "v1.0.0"
+        assert releases[0]["commit"] == H.POINTS.HEAD_SHA
+        assert "extent" not in releases[0]["datasets"][layer]
+
+        r = cli_runner.invoke(["release", "show", "v1.0.0", "-o", "json"])
+        assert r.exit_code == 0, r.stderr
+        assert json.loads(r.stdout)["kart.release/v1"] == manifest
+
+
+def test_release_publish_stac(data_archive, cli_runner, tmp_path):
+    releases_dir = tmp_path / "releases"
+    catalog_dir = tmp_path / "catalog"
+    with data_archive("points"):
+        r = cli_runner.invoke(["release", "publish-stac", f"--output={catalog_dir}"])
+        assert r.exit_code == NO_DATA, r.stderr
+
+        r = cli_runner.invoke(
+            [
+                "release",
+                "create",
+                "v1.0.0",
+                "--export=GPKG",
+                f"--output-dir={releases_dir}",
+            ]
+        )
+        assert r.exit_code == 0, r.stderr
+
+        r = cli_runner.invoke(
+            [
+                "release",
+                "publish-stac",
+                f"--output={catalog_dir}",
+                f"--releases-dir={releases_dir}",
+                "--id=points",
+            ]
+        )
+        assert r.exit_code == 0, r.stderr
+
+        collection = json.loads((catalog_dir / "collection.json").read_text())
+        assert collection["type"] == "Collection"
+        assert collection["id"] == "points"
+        assert [l["href"] for l in collection["links"] if l["rel"] == "item"] == [
+            "./v1.0.0/v1.0.0.json"
+        ]
+
+        item = json.loads((catalog_dir / "v1.0.0" / "v1.0.0.json").read_text())
+        assert item["type"] == "Feature"
+        assert item["id"] == "v1.0.0"
+        assert item["properties"]["kart:commit"] == H.POINTS.HEAD_SHA
+        # All of the points are in New Zealand.
+        w, s, e, n = item["bbox"]
+        assert 165 < w < e < 180 and -48 < s < n < -34
+        assert collection["extent"]["spatial"]["bbox"] == [item["bbox"]]
+
+        asset = item["assets"]["v1.0.0.gpkg"]
+        assert asset["href"] == "../../releases/v1.0.0/v1.0.0.gpkg"
+        assert asset["type"] == "application/geopackage+sqlite3"
+        manifest = json.loads((releases_dir / "v1.0.0" / "manifest.json").read_text())
+        [artifact] = manifest["kart.release/v1"]["artifacts"]
+        assert asset["file:checksum"] == "1220" + artifact["sha256"]
+
+        r = cli_runner.invoke(
+            [
+                "release",
+                "publish-stac",
+                f"--output={catalog_dir}",
+                "--base-url=https://example.com/releases/",
+            ]
+        )
+        assert r.exit_code == 0, r.stderr
+        item = json.loads((catalog_dir / "v1.0.0" / "v1.0.0.json").read_text())
+        assert (
+            item["assets"]["v1.0.0.gpkg"]["href"]
+            == "https://example.com/releases/v1.0.0/v1.0.0.gpkg"
+        )
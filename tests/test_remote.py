@@ -194,6 +194,46 @@ def test_clone_filter(
             )
 
 
+def test_clone_tls_options(
+    data_archive,
+    tmp_path,
+    cli_runner,
+    chdir,
+):
+    cert_path, key_path, ca_path = (
+        tmp_path / f for f in ("client.crt", "client.key", "ca.crt")
+    )
+    for f in (cert_path, key_path, ca_path):
+        f.write_text("dummy")
+
+    with data_archive("points") as remote_path:
+        with chdir(tmp_path):
+            r = cli_runner.invoke(["clone", "--key", key_path, remote_path, "--bare"])
+            assert r.exit_code == 2, r.stderr
+            assert "--key requires --cert" in r.stderr
+
+            r = cli_runner.invoke(
+                [
+                    "clone",
+                    "--cert",
+                    cert_path,
+                    "--key",
+                    key_path,
+                    "--ca",
+                    ca_path,
+                    remote_path,
+                    "--bare",
+                ]
+            )
+            assert r.exit_code == 0, r.stderr
+
+            # The options are saved in the new repo, so that later fetches and pushes use them too.
+            repo = KartRepo(tmp_path / "points.git")
+            assert repo.config["http.sslCert"] == str(cert_path)
+            assert repo.config["http.sslKey"] == str(key_path)
+            assert repo.config["http.sslCAInfo"] == str(ca_path)
+
+
 def test_fetch(
     data_archive_readonly,
     data_working_copy,
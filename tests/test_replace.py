@@ -0,0 +1,96 @@
+import json
+import re
+
+import pytest
+
+from kart.exceptions import NO_CHANGES
+from kart.repo import KartRepo
+
+
+H = pytest.helpers.helpers()
+
+
+def test_replace(data_working_copy, cli_runner):
+    with data_working_copy("points") as (repo_path, wc_path):
+        repo = KartRepo(repo_path)
+        old_head = repo.head_commit.id.hex
+        features = list(repo.datasets()[H.POINTS.LAYER].features())
+        expected_pks = sorted(f["fid"] for f in features if f["macronated"] == "N")
+        assert expected_pks
+
+        args = [
+            "replace",
+            H.POINTS.LAYER,
+            "--field=macronated",
+            "--from=N",
+            "--to=n",
+        ]
+        r = cli_runner.invoke([*args, "--dry-run"])
+        assert r.exit_code == 0, r.stderr
+        assert f"--- {H.POINTS.LAYER}:feature:{expected_pks[0]}" in r.stdout
+        assert "+                               macronated = n" in r.stdout
+
+        r = cli_runner.invoke([*args, "--dry-run", "-o", "json"])
+        assert r.exit_code == 0, r.stderr
+        feature_diff = json.loads(r.stdout)["kart.diff/v1+hexwkb"][H.POINTS.LAYER][
+            "feature"
+        ]
+        assert sorted(d["+"]["fid"] for d in feature_diff) == expected_pks
+        assert {d["+"]["macronated"] for d in feature_diff} == {"n"}
+        # Nothing was committed.
+        assert repo.head_commit.id.hex == old_head
+
+        r = cli_runner.invoke([*args, "-m", "Lower case", "-o", "json"])
+        assert r.exit_code == 0, r.stderr
+        assert json.loads(r.stdout)["kart.commit/v1"]["changes"] == {
+            H.POINTS.LAYER: {"feature": {"updates": len(expected_pks)}}
+        }
+        assert repo.head_commit.message == "Lower case"
+
+        # Running it again changes nothing.
+        r = cli_runner.invoke([*args, "-m", "Lower case again"])
+        assert r.exit_code == NO_CHANGES, r.stderr
+
+
+def test_replace_regex(data_working_copy, cli_runner):
+    with data_working_copy("points") as (repo_path, wc_path):
+        repo = KartRepo(repo_path)
+        dataset = repo.datasets()[H.POINTS.LAYER]
+        expected = {
+            f["fid"]: re.sub(r"^Te (\w)", r"The \1", f["name"])
+            for f in dataset.features()
+            if f["name"] and re.match(r"^Te \w", f["name"])
+        }
+        assert expected
+
+        r = cli_runner.invoke(
+            [
+                "replace",
+                H.POINTS.LAYER,
+                "--field=name",
+                "--regex",
+                r"--from=^Te (\w)",
+                r"--to=The \1",
+                "-m",
+                "Translate",
+            ]
+        )
+        assert r.exit_code == 0, r.stderr
+
+        dataset = repo.datasets()[H.POINTS.LAYER]
+        for fid, name in expected.items():
+            assert dataset.get_feature([fid])["name"] == name
+
+
+def test_replace_errors(data_working_copy, cli_runner):
+    with data_working_copy("points") as (repo_path, wc_path):
+        for args, message in [
+            (["--field=nope"], "has no field called nope"),
+            (["--field=t50_fid"], "only text fields are supported"),
+            (["--field=name", "--regex", "--from=("], "Invalid regular expression"),
+        ]:
+            r = cli_runner.invoke(
+                ["replace", H.POINTS.LAYER, "--from=x", "--to=y", *args, "-m", "x"]
+            )
+            assert r.exit_code == 2, (args, r.stderr)
+            assert message in r.stderr, (args, r.stderr)
@@ -0,0 +1,66 @@
+import json
+
+import pytest
+
+from kart.rpc import INVALID_REQUEST, METHOD_NOT_FOUND, PARSE_ERROR
+
+
+H = pytest.helpers.helpers()
+
+
+def _rpc(cli_runner, *requests):
+    lines = [r if isinstance(r, str) else json.dumps(r) for r in requests]
+    r = cli_runner.invoke(["rpc"], input="\n".join(lines) + "\n")
+    assert r.exit_code == 0, r.stderr
+    return [json.loads(line) for line in r.stdout.splitlines()]
+
+
+def test_rpc(data_archive_readonly, cli_runner):
+    with data_archive_readonly("points"):
+        responses = _rpc(
+            cli_runner,
+            {
+                "jsonrpc": "2.0",
+                "id": 1,
+                "method": "log",
+                "params": ["-o", "json", "-n", "1"],
+            },
+            {
+                "jsonrpc": "2.0",
+                "id": 2,
+                "method": "diff",
+                "params": {"args": ["-o", "json", "HEAD^...HEAD"]},
+            },
+            # Notifications don't get a response.
+            {"jsonrpc": "2.0", "method": "status"},
+            {"jsonrpc": "2.0", "id": 3, "method": "show", "params": ["nonexistent"]},
+        )
+        assert [r["id"] for r in responses] == [1, 2, 3]
+
+        log = responses[0]["result"]
+        assert log["exitCode"] == 0
+        assert log["output"][0]["commit"] == H.POINTS.HEAD_SHA
+
+        diff = responses[1]["result"]["output"]
+        feature_diff = diff["kart.diff/v1+hexwkb"][H.POINTS.LAYER]["feature"]
+        assert len(feature_diff) == 5
+
+        error = responses[2]["error"]
+        assert error["code"] == error["data"]["exitCode"] != 0
+        assert error["message"].startswith("Error:")
+
+
+def test_rpc_errors(cli_runner):
+    responses = _rpc(
+        cli_runner,
+        "not json",
+        {"id": 1, "method": "status"},
+        {"jsonrpc": "2.0", "id": 2, "method": "rpc"},
+        {"jsonrpc": "2.0", "id": 3, "method": "rpc.listMethods"},
+    )
+    assert responses[0]["error"]["code"] == PARSE_ERROR
+    assert responses[1]["error"]["code"] == INVALID_REQUEST
+    assert responses[2]["error"]["code"] == METHOD_NOT_FOUND
+    methods = responses[3]["result"]
+    assert {"checkout", "diff", "import", "log"} <= set(methods)
+    assert "rpc" not in methods
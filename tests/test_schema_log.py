@@ -0,0 +1,60 @@
+import json
+
+import pytest
+
+from kart.exceptions import NO_DATA
+from kart.repo import KartRepo
+
+
+H = pytest.helpers.helpers()
+
+
+def test_schema_log(data_working_copy, cli_runner):
+    layer = H.POINTS.LAYER
+    with data_working_copy("points") as (repo_path, wc):
+        r = cli_runner.invoke(["schema", "log", layer, "-o", "json"])
+        assert r.exit_code == 0, r.stderr
+        [created] = json.loads(r.stdout)["kart.schema-log/v1"]
+        assert created["message"].startswith("Import from nz-pa-points-topo-150k")
+        [change] = created["changes"]
+        assert change["type"] == "datasetCreated"
+        assert change["primaryKey"] == ["fid"]
+        assert change["crs"] == ["EPSG:4326"]
+        assert [c["column"] for c in change["columns"]] == [
+            "fid",
+            "geom",
+            "t50_fid",
+            "name_ascii",
+            "macronated",
+            "name",
+        ]
+
+        repo = KartRepo(repo_path)
+        with repo.working_copy.tabular.session() as sess:
+            sess.execute(f"ALTER TABLE {layer} ADD COLUMN colour TEXT(20);")
+        r = cli_runner.invoke(["commit", "-m", "Add colour column"])
+        assert r.exit_code == 0, r.stderr
+
+        r = cli_runner.invoke(["schema", "log", layer, "-o", "json"])
+        assert r.exit_code == 0, r.stderr
+        [added, created] = json.loads(r.stdout)["kart.schema-log/v1"]
+        assert added["commit"] == repo.head_commit.hex
+        assert added["changes"] == [
+            {"type": "columnAdded", "column": "colour", "dataType": "text(20)"}
+        ]
+        assert created["changes"][0]["type"] == "datasetCreated"
+
+        r = cli_runner.invoke(["schema", "log", layer, "--reverse"])
+        assert r.exit_code == 0, r.stderr
+        lines = r.stdout.splitlines()
+        assert lines[1].startswith("    dataset created with columns: fid integer(64)")
+        assert lines[2].endswith("Add colour column")
+        assert lines[3] == "    + column colour text(20)"
+
+        # Only the history of the given revision is listed.
+        r = cli_runner.invoke(["schema", "log", layer, "HEAD^"])
+        assert r.exit_code == 0, r.stderr
+        assert "colour" not in r.stdout
+
+        r = cli_runner.invoke(["schema", "log", "nonexistent"])
+        assert r.exit_code == NO_DATA, r.stderr
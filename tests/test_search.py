@@ -0,0 +1,68 @@
+import json
+
+import pytest
+
+from kart.repo import KartRepo
+
+
+H = pytest.helpers.helpers()
+
+
+def test_search(data_working_copy, cli_runner):
+    with data_working_copy("points") as (repo_path, wc_path):
+        r = cli_runner.invoke(
+            ["search", H.POINTS.LAYER, "--field", "name", "--regex", "^Main St"]
+        )
+        assert r.exit_code == 0, r.stderr
+        assert r.stdout == ""
+        assert r.stderr.splitlines() == ["No matches found"]
+
+        repo = KartRepo(repo_path)
+        with repo.working_copy.tabular.session() as sess:
+            sess.execute(
+                f"UPDATE {H.POINTS.LAYER} SET name = 'Main Street' WHERE fid = 1;"
+            )
+        r = cli_runner.invoke(["commit", "-m", "Rename"])
+        assert r.exit_code == 0, r.stderr
+        commit_id = repo.head_commit.hex
+
+        r = cli_runner.invoke(
+            ["search", H.POINTS.LAYER, "--field", "name", "--regex", "^main st", "-i"]
+        )
+        assert r.exit_code == 0, r.stderr
+        assert r.stdout.splitlines() == [f"{H.POINTS.LAYER}:1\tname=Main Street"]
+
+        r = cli_runner.invoke(
+            [
+                "search",
+                H.POINTS.LAYER,
+                "--regex",
+                "^Main St",
+                "--all-history",
+                "-o",
+                "json",
+            ]
+        )
+        assert r.exit_code == 0, r.stderr
+        assert json.loads(r.stdout) == {
+            "kart.search/v1": [
+                {
+                    "commit": commit_id,
+                    "id": 1,
+                    "field": "name",
+                    "value": "Main Street",
+                }
+            ]
+        }
+
+        # The value didn't exist at HEAD^
+        r = cli_runner.invoke(
+            ["search", H.POINTS.LAYER, "--regex", "^Main St", "--ref", "HEAD^"]
+        )
+        assert r.exit_code == 0, r.stderr
+        assert r.stdout == ""
+
+        r = cli_runner.invoke(
+            ["search", H.POINTS.LAYER, "--field", "nope", "--regex", "x"]
+        )
+        assert r.exit_code == 2, r.stderr
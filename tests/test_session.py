@@ -0,0 +1,97 @@
+import json
+
+import pytest
+
+from kart.exceptions import INVALID_OPERATION, NO_DATA
+from kart.repo import KartRepo
+from kart.session import EditSession
+
+
+H = pytest.helpers.helpers()
+
+
+def _edit(working_copy, sql):
+    with working_copy.session() as sess:
+        sess.execute(sql)
+
+
+def test_session_commit_rebases_onto_head(data_working_copy, cli_runner):
+    layer = H.POINTS.LAYER
+    with data_working_copy("points") as (repo_path, wc_path):
+        repo = KartRepo(repo_path)
+
+        r = cli_runner.invoke(["session", "start", "survey"])
+        assert r.exit_code == 0, r.stderr
+        session_gpkg = repo_path / f"{repo_path.stem}-survey.gpkg"
+        assert session_gpkg.exists()
+
+        r = cli_runner.invoke(["session", "list", "-o", "json"])
+        assert r.exit_code == 0, r.stderr
+        [session_json] = json.loads(r.stdout)["kart.sessions/v1"]
+        assert session_json["name"] == "survey"
+        assert session_json["baseCommit"] == repo.head_commit.id.hex
+
+        edit_session = EditSession.load(repo, "survey")
+        _edit(
+            edit_session.working_copy(),
+            f"UPDATE {layer} SET name='surveyed' WHERE fid=1;",
+        )
+
+        # Meanwhile, someone else commits a change to a different feature.
+        _edit(
+            repo.working_copy.tabular,
+            f"UPDATE {layer} SET name='elsewhere' WHERE fid=2;",
+        )
+        r = cli_runner.invoke(["commit", "-m", "elsewhere"])
+        assert r.exit_code == 0, r.stderr
+        head_before = repo.head_commit
+
+        r = cli_runner.invoke(["session", "commit", "survey", "-m", "Survey edits"])
+        assert r.exit_code == 0, r.stderr
+        assert r.stdout.splitlines()[0].startswith("Committed edit session survey: ")
+
+        head = repo.head_commit
+        assert head.message.strip() == "Survey edits"
+        assert head.parent_ids == [head_before.id]
+        dataset = repo.datasets()[layer]
+        assert dataset.get_feature([1])["name"] == "surveyed"
+        assert dataset.get_feature([2])["name"] == "elsewhere"
+
+        assert not session_gpkg.exists()
+        r = cli_runner.invoke(["session", "list"])
+        assert r.exit_code == 0, r.stderr
+        assert r.stdout == ""
+
+
+def test_session_conflict(data_working_copy, cli_runner):
+    layer = H.POINTS.LAYER
+    with data_working_copy("points") as (repo_path, wc_path):
+        repo = KartRepo(repo_path)
+
+        r = cli_runner.invoke(["session", "start", "survey", "--path=survey.gpkg"])
+        assert r.exit_code == 0, r.stderr
+        _edit(
+            EditSession.load(repo, "survey").working_copy(),
+            f"UPDATE {layer} SET name='surveyed' WHERE fid=1;",
+        )
+        _edit(
+            repo.working_copy.tabular,
+            f"UPDATE {layer} SET name='elsewhere' WHERE fid=1;",
+        )
+        r = cli_runner.invoke(["commit", "-m", "elsewhere"])
+        assert r.exit_code == 0, r.stderr
+
+        r = cli_runner.invoke(["session", "commit", "survey"])
+        assert r.exit_code == INVALID_OPERATION, r.stderr
+        assert f"{layer}:feature:1" in r.stderr
+        # The session is still there.
+        assert (repo_path / "survey.gpkg").exists()
+
+        r = cli_runner.invoke(["session", "commit", "survey", "--branch=survey"])
+        assert r.exit_code == 0, r.stderr
+        survey_commit = repo.references["refs/heads/survey"].peel()
+        assert survey_commit.message.strip() == "Edit session survey"
+        assert repo.datasets("survey")[layer].get_feature([1])["name"] == "surveyed"
+
+        r = cli_runner.invoke(["session", "abort", "survey"])
+        assert r.exit_code == NO_DATA, r.stderr
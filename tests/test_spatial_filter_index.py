@@ -6,6 +6,7 @@ from osgeo import osr
 
 from kart.crs_util import make_crs
 from kart.sqlalchemy.sqlite import sqlite_engine
+from kart.spatial_filter.cell_index import MAX_LEVEL, cells_for_envelope
 from kart.spatial_filter.index import (
     CannotIndex,
     EnvelopeEncoder,
@@ -285,6 +286,69 @@ def test_index_points_idempotent(data_archive, cli_runner):
         _check_index(s, EXPECTED_POINTS_INDEX)
 
 
+def test_index_points_feature_cells(data_archive, cli_runner):
+    with data_archive("points.tgz") as repo_path:
+        r = cli_runner.invoke(["spatial-filter", "index", H.POINTS.HEAD1_SHA])
+        assert r.exit_code == 0, r.stderr
+        r = cli_runner.invoke(["spatial-filter", "index", H.POINTS.HEAD_SHA])
+        assert r.exit_code == 0, r.stderr
+
+        db_path = repo_path / ".kart" / "feature_envelopes.db"
+        engine = sqlite_engine(db_path)
+
+        def _cell_summary():
+            with sessionmaker(bind=engine)() as sess:
+                return sess.execute(
+                    "SELECT ds_path, length(cell), COUNT(*) FROM feature_cells GROUP BY 1, 2;"
+                ).fetchall()
+
+        # Every point is in exactly one of the smallest cells.
+        summary = _cell_summary()
+        assert [(ds_path, level) for ds_path, level, count in summary] == [
+            (H.POINTS.LAYER, MAX_LEVEL)
+        ]
+        assert summary[0][2] >= H.POINTS.ROWCOUNT
+
+        # Indexes made before feature cells existed get their cells indexed next time.
+        with sessionmaker(bind=engine)() as sess:
+            sess.execute("DROP TABLE feature_cells;")
+        r = cli_runner.invoke(["spatial-filter", "index"])
+        assert r.exit_code == 0, r.stderr
+        assert "indexing feature cells only" in r.stdout
+        assert _cell_summary() == summary
+
+        r = cli_runner.invoke(["spatial-filter", "index"])
+        assert r.exit_code == 0, r.stderr
+        assert "Nothing to do" in r.stdout
+
+
+@pytest.mark.parametrize(
+    "envelope,expected_cells",
+    [
+        ((-180, -90, 180, 90), {"0", "1", "2", "3"}),
+        ((-90, -45, 90, 45), {"0", "1", "2", "3"}),
+        ((-180, 0, -90, 90), {"20", "21", "22", "23"}),
+        ((-180, 0, -170, 90), {"200", "202", "220", "222"}),
+    ],
+)
+def test_cells_for_envelope(envelope, expected_cells):
+    assert cells_for_envelope(envelope) == expected_cells
+
+
+def test_cells_for_antimeridian_envelope():
+    # Envelopes that cross the anti-meridian are covered on both sides.
+    cells = cells_for_envelope((179.9, 0, -179.9, 1))
+    assert {c[:2] for c in cells} == {"20", "31"}
+
+
+def test_cells_for_point_envelope():
+    [cell] = cells_for_envelope((174.77, -41.29, 174.77, -41.29))
+    assert len(cell) == MAX_LEVEL
+    # A bigger envelope around the point is covered by cells that contain the point's cell.
+    containing = cells_for_envelope((174.7, -41.3, 174.8, -41.2))
+    assert any(cell.startswith(c) for c in containing)
+
+
 def test_index_polygons_all(data_archive, cli_runner):
     with data_archive("polygons.tgz") as repo_path:
         r = cli_runner.invoke(["spatial-filter", "index"])
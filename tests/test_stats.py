@@ -0,0 +1,82 @@
+import json
+
+import pytest
+
+from kart.exceptions import SUCCESS_WITH_FLAG
+from kart.repo import KartRepo
+
+
+H = pytest.helpers.helpers()
+
+
+def test_stats_show(data_archive_readonly, cli_runner):
+    layer = H.POINTS.LAYER
+    with data_archive_readonly("points"):
+        r = cli_runner.invoke(["stats", "show", "-o", "json"])
+        assert r.exit_code == 0, r.stderr
+        stats = json.loads(r.stdout)["kart.stats/v1"][layer]
+        assert stats["count"] == H.POINTS.ROWCOUNT
+        assert "name.nulls" in stats
+        assert stats["t50_fid.min"] <= stats["t50_fid.mean"] <= stats["t50_fid.max"]
+        assert stats["extent.width"] > 0
+
+        r = cli_runner.invoke(["stats", "show"])
+        assert r.exit_code == 0, r.stderr
+        assert f"  count: {H.POINTS.ROWCOUNT}" in r.stdout.splitlines()
+
+
+def test_stats_diff_alerts(data_working_copy, cli_runner):
+    layer = H.POINTS.LAYER
+    with data_working_copy("points") as (repo_path, wc):
+        r = cli_runner.invoke(
+            ["stats", "diff", "HEAD^", "HEAD", "--alert-on", "count delta > 5%"]
+        )
+        assert r.exit_code == 0, r.stderr
+
+        r = cli_runner.invoke(["stats", "diff", "HEAD^", "--alert-on", "count > 5%"])
+        assert r.exit_code == 2, r.stderr
+        assert "percentages are only supported for deltas" in r.stderr
+
+        repo = KartRepo(repo_path)
+        with repo.working_copy.tabular.session() as sess:
+            sess.execute(f"DELETE FROM {layer} WHERE fid > 100;")
+        r = cli_runner.invoke(["commit", "-m", "Delete most points"])
+        assert r.exit_code == 0, r.stderr
+
+        r = cli_runner.invoke(
+            [
+                "stats",
+                "diff",
+                "HEAD^",
+                "--alert-on=count delta > 5%",
+                "--alert-on=*.nulls delta > 1000000",
+                "-o",
+                "json",
+            ]
+        )
+        assert r.exit_code == SUCCESS_WITH_FLAG, r.stderr
+        output = json.loads(r.stdout)["kart.stats.diff/v1"]
+        assert output["datasets"][layer]["count"] == {
+            "old": H.POINTS.ROWCOUNT,
+            "new": 100,
+            "delta": 100 - H.POINTS.ROWCOUNT,
+            "deltaPercent": pytest.approx(
+                (100 - H.POINTS.ROWCOUNT) * 100 / H.POINTS.ROWCOUNT
+            ),
+        }
+        assert output["alerts"] == [
+            {
+                "dataset": layer,
+                "metric": "count",
+                "rule": "count delta > 5%",
+                "old": H.POINTS.ROWCOUNT,
+                "new": 100,
+            }
+        ]
+
+        r = cli_runner.invoke(
+            ["stats", "diff", "HEAD^", "HEAD", "--alert-on", "count delta > 5%"]
+        )
+        assert r.exit_code == SUCCESS_WITH_FLAG, r.stderr
+        assert f"  count: {H.POINTS.ROWCOUNT} → 100" in r.stdout
+        assert f"ALERT {layer}: count delta > 5%" in r.stderr
@@ -0,0 +1,70 @@
+import sqlite3
+
+import pytest
+
+from kart.exceptions import NO_DATA
+from kart.repo import KartRepo
+
+
+H = pytest.helpers.helpers()
+
+QML = '<!DOCTYPE qgis><qgis version="3.28"><renderer-v2 type="singleSymbol"/></qgis>'
+SLD = '<StyledLayerDescriptor version="1.0.0"><NamedLayer/></StyledLayerDescriptor>'
+
+
+def test_style_set_get_export(data_archive, cli_runner, tmp_path):
+    layer = H.POINTS.LAYER
+    qml_path = tmp_path / "points.qml"
+    qml_path.write_text(QML)
+    sld_path = tmp_path / "points.sld"
+    sld_path.write_text(SLD)
+
+    with data_archive("points") as repo_path:
+        repo = KartRepo(repo_path)
+        r = cli_runner.invoke(["style", "get", layer, "--name", "default"])
+        assert r.exit_code == NO_DATA, r.stderr
+
+        r = cli_runner.invoke(["style", "set", layer, qml_path])
+        assert r.exit_code == 0, r.stderr
+        assert repo.head_commit.message.startswith(
+            f"Update default style for {layer}"
+        )
+        r = cli_runner.invoke(
+            ["style", "set", layer, sld_path, "--name", "print", "-m", "Print style"]
+        )
+        assert r.exit_code == 0, r.stderr
+
+        r = cli_runner.invoke(["style", "get", layer])
+        assert r.exit_code == 0, r.stderr
+        assert r.stdout.splitlines() == ["default (QML)", "print (SLD)"]
+        r = cli_runner.invoke(["style", "get", layer, "--name", "print"])
+        assert r.exit_code == 0, r.stderr
+        assert r.stdout.splitlines() == [SLD]
+
+        # Styles are versioned along with the data.
+        r = cli_runner.invoke(["style", "get", layer, "--ref", "HEAD^"])
+        assert r.exit_code == 0, r.stderr
+        assert r.stdout.splitlines() == ["default (QML)"]
+        r = cli_runner.invoke(["diff", "HEAD^...HEAD", "-o", "json"])
+        assert r.exit_code == 0, r.stderr
+        assert "style/print.sld" in r.stdout
+
+        out_path = tmp_path / "out.qml"
+        r = cli_runner.invoke(["style", "export", layer, out_path])
+        assert r.exit_code == 0, r.stderr
+        assert out_path.read_text() == QML
+
+        gpkg_path = tmp_path / "out.gpkg"
+        r = cli_runner.invoke(["export", gpkg_path])
+        assert r.exit_code == 0, r.stderr
+        with sqlite3.connect(gpkg_path) as db:
+            styles = list(
+                db.execute(
+                    "SELECT f_table_name, f_geometry_column, styleName, styleQML, styleSLD, useAsDefault "
+                    "FROM layer_styles ORDER BY styleName;"
+                )
+            )
+        assert styles == [
+            (layer, "geom", "default", QML, None, 1),
+            (layer, "geom", "print", None, SLD, 0),
+        ]
@@ -0,0 +1,113 @@
+import gzip
+import json
+
+import pytest
+
+from kart.exceptions import INVALID_OPERATION
+from kart.repo import KartRepo
+
+
+H = pytest.helpers.helpers()
+
+
+def _edit(repo, sql):
+    with repo.working_copy.tabular.session() as sess:
+        sess.execute(sql)
+
+
+def test_sync_delta_push_pull(data_working_copy, cli_runner, tmp_path):
+    layer = H.POINTS.LAYER
+    delta_dir = tmp_path / "deltas"
+    with data_working_copy("points") as (repo_path, wc_path):
+        repo = KartRepo(repo_path)
+        orig_head = repo.head_commit
+
+        _edit(repo, f"UPDATE {layer} SET name='tablet' WHERE fid=1;")
+        r = cli_runner.invoke(["commit", "-m", "Edit on tablet"])
+        assert r.exit_code == 0, r.stderr
+
+        # The first push needs a starting point.
+        r = cli_runner.invoke(
+            ["sync", "push", "--delta", delta_dir, "--device", "tablet"]
+        )
+        assert r.exit_code == 2, r.stderr
+
+        r = cli_runner.invoke(
+            [
+                "sync",
+                "push",
+                "--delta",
+                delta_dir,
+                "--device",
+                "tablet",
+                "--since",
+                "HEAD^",
+            ]
+        )
+        assert r.exit_code == 0, r.stderr
+        [delta_path] = delta_dir.glob("*.kartdelta")
+        with gzip.open(delta_path, "rt", encoding="utf-8") as f:
+            delta = json.load(f)["kart.delta/v1"]
+        assert delta["device"] == "tablet"
+        assert delta["since"] == orig_head.hex
+        [patch] = delta["patches"]
+        assert patch["kart.patch/v1"]["message"] == "Edit on tablet"
+        assert len(patch["kart.diff/v1+hexwkb"][layer]["feature"]) == 1
+
+        r = cli_runner.invoke(
+            ["sync", "push", "--delta", delta_dir, "--device", "tablet"]
+        )
+        assert r.exit_code == 0, r.stderr
+        assert r.stdout.startswith("Nothing to push")
+
+        # Pretend to be the office copy, which doesn't have the tablet's commit yet.
+        r = cli_runner.invoke(["reset", orig_head.hex, "--discard-changes"])
+        assert r.exit_code == 0, r.stderr
+        r = cli_runner.invoke(
+            ["sync", "pull", "--delta", delta_dir, "--device", "office"]
+        )
+        assert r.exit_code == 0, r.stderr
+        assert r.stdout.splitlines()[-1] == "Applied 1 commit(s) from other devices"
+
+        head = repo.head_commit
+        assert head.parent_ids == [orig_head.id]
+        assert head.message.strip() == "Edit on tablet"
+        assert repo.datasets()[layer].get_feature([1])["name"] == "tablet"
+
+        # Deltas are only applied once.
+        r = cli_runner.invoke(
+            ["sync", "pull", "--delta", delta_dir, "--device", "office"]
+        )
+        assert r.exit_code == 0, r.stderr
+        assert r.stdout.splitlines()[-1] == "Applied 0 commit(s) from other devices"
+        assert repo.head_commit.id == head.id
+
+
+def test_sync_pull_requires_push(data_working_copy, cli_runner, tmp_path):
+    layer = H.POINTS.LAYER
+    delta_dir = tmp_path / "deltas"
+    with data_working_copy("points") as (repo_path, wc_path):
+        r = cli_runner.invoke(
+            [
+                "sync",
+                "push",
+                "--delta",
+                delta_dir,
+                "--device",
+                "tablet",
+                "--since",
+                "HEAD^",
+            ]
+        )
+        assert r.exit_code == 0, r.stderr
+
+        repo = KartRepo(repo_path)
+        _edit(repo, f"UPDATE {layer} SET name='unpushed' WHERE fid=1;")
+        r = cli_runner.invoke(["commit", "-m", "Unpushed edit"])
+        assert r.exit_code == 0, r.stderr
+
+        r = cli_runner.invoke(
+            ["sync", "pull", "--delta", delta_dir, "--device", "tablet"]
+        )
+        assert r.exit_code == INVALID_OPERATION, r.stderr
+        assert "haven't been pushed yet" in r.stderr
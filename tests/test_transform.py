@@ -0,0 +1,90 @@
+import json
+
+import pytest
+
+from kart.exceptions import INVALID_OPERATION, NO_CHANGES
+from kart.lineage import get_lineage
+from kart.repo import KartRepo
+
+
+H = pytest.helpers.helpers()
+
+
+def test_transform(data_working_copy, cli_runner):
+    with data_working_copy("points") as (repo_path, wc_path):
+        repo = KartRepo(repo_path)
+        old_head = repo.head_commit.id.hex
+        r = cli_runner.invoke(
+            [
+                "transform",
+                H.POINTS.LAYER,
+                "--expr",
+                "name_ascii = upper(name_ascii)",
+                "--expr",
+                "t50_fid = t50_fid + 1",
+                "--where",
+                "fid <= 10 and name_ascii is not None",
+                "-m",
+                "Shout",
+                "-o",
+                "json",
+            ]
+        )
+        assert r.exit_code == 0, r.stderr
+        assert json.loads(r.stdout)["kart.commit/v1"]["changes"] == {
+            H.POINTS.LAYER: {"feature": {"updates": 10}}
+        }
+        assert get_lineage(repo, repo.head_commit) == {
+            "datasets": [H.POINTS.LAYER],
+            "derivedFrom": [{"dataset": H.POINTS.LAYER, "commit": old_head}],
+        }
+
+        dataset = repo.datasets()[H.POINTS.LAYER]
+        old_dataset = repo.datasets(old_head)[H.POINTS.LAYER]
+        feature = dataset.get_feature([1])
+        old_feature = old_dataset.get_feature([1])
+        assert feature["name_ascii"] == old_feature["name_ascii"].upper()
+        assert feature["t50_fid"] == old_feature["t50_fid"] + 1
+        assert dataset.get_feature([11]) == old_dataset.get_feature([11])
+
+        # The working copy is updated to match.
+        with repo.working_copy.tabular.session() as sess:
+            name_ascii = sess.scalar(
+                f"SELECT name_ascii FROM {H.POINTS.LAYER} WHERE fid = 1;"
+            )
+        assert name_ascii == feature["name_ascii"]
+        r = cli_runner.invoke(["status", "-o", "json"])
+        assert r.exit_code == 0, r.stderr
+        assert not json.loads(r.stdout)["kart.status/v2"]["workingCopy"]["changes"]
+
+        # Running it again changes nothing.
+        r = cli_runner.invoke(
+            [
+                "transform",
+                H.POINTS.LAYER,
+                "--expr",
+                "name_ascii = upper(name_ascii)",
+                "--where",
+                "fid <= 10",
+                "-m",
+                "Shout again",
+            ]
+        )
+        assert r.exit_code == NO_CHANGES, r.stderr
+
+
+def test_transform_errors(data_working_copy, cli_runner):
+    with data_working_copy("points") as (repo_path, wc_path):
+        for args, exit_code, message in [
+            (["--expr", "nope = 1"], 2, "has no field called nope"),
+            (["--expr", "name = nope"], 2, "no field called nope"),
+            (["--expr", "fid = fid + 1"], 2, "Can't assign to primary key field fid"),
+            (["--expr", "name == 1"], 2, "expected FIELD = EXPRESSION"),
+            (["--expr", "name = open('x')"], 2, "unknown function open"),
+            (["--expr", "name = 1", "--where", "nope > 1"], 2, "no field called"),
+            (["--expr", "t50_fid = name"], INVALID_OPERATION, "expected a value"),
+            (["--expr", "t50_fid = t50_fid / 0"], INVALID_OPERATION, "division"),
+        ]:
+            r = cli_runner.invoke(["transform", H.POINTS.LAYER, *args, "-m", "Fail"])
+            assert r.exit_code == exit_code, (args, r.stderr)
+            assert message in r.stderr, (args, r.stderr)
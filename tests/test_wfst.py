@@ -0,0 +1,69 @@
+import xml.etree.ElementTree as ET
+
+import pytest
+
+from kart.exceptions import InvalidOperation, NO_CHANGES
+from kart.wfst import FES_NS, WFS_NS, parse_transaction_response
+
+
+H = pytest.helpers.helpers()
+
+
+def test_push_wfst_dry_run(data_archive_readonly, cli_runner):
+    with data_archive_readonly("points"):
+        r = cli_runner.invoke(
+            ["push-wfst", "HEAD^...HEAD", "http://example.com/wfs", "--dry-run"]
+        )
+        assert r.exit_code == 0, r.stderr
+
+        root = ET.fromstring(r.stdout_bytes)
+        assert root.tag == f"{{{WFS_NS}}}Transaction"
+        updates = root.findall(f"{{{WFS_NS}}}Update")
+        assert len(updates) == 5
+        assert not root.findall(f"{{{WFS_NS}}}Insert")
+        assert not root.findall(f"{{{WFS_NS}}}Delete")
+
+        [update] = [
+            u for u in updates if u.findtext(f".//{{{FES_NS}}}Literal") == "1095"
+        ]
+        assert update.get("typeName") == H.POINTS.LAYER
+        props = {
+            p.findtext(f"{{{WFS_NS}}}ValueReference"): p.findtext(f"{{{WFS_NS}}}Value")
+            for p in update.findall(f"{{{WFS_NS}}}Property")
+        }
+        assert props == {
+            "name_ascii": "Harataunga (Rakairoa)",
+            "macronated": "Y",
+            "name": "Harataunga (Rākairoa)",
+        }
+        assert update.findtext(f".//{{{FES_NS}}}ValueReference") == "fid"
+
+        r = cli_runner.invoke(
+            ["push-wfst", "HEAD...HEAD", "http://example.com/wfs", "--dry-run"]
+        )
+        assert r.exit_code == NO_CHANGES, r.stderr
+
+
+def test_parse_transaction_response():
+    response = b"""<?xml version="1.0" encoding="UTF-8"?>
+<wfs:TransactionResponse xmlns:wfs="http://www.opengis.net/wfs/2.0" version="2.0.0">
+  <wfs:TransactionSummary>
+    <wfs:totalInserted>2</wfs:totalInserted>
+    <wfs:totalUpdated>5</wfs:totalUpdated>
+    <wfs:totalDeleted>0</wfs:totalDeleted>
+  </wfs:TransactionSummary>
+</wfs:TransactionResponse>"""
+    assert parse_transaction_response(response) == {
+        "insert": 2,
+        "update": 5,
+        "delete": 0,
+    }
+
+    error = b"""<?xml version="1.0" encoding="UTF-8"?>
+<ows:ExceptionReport xmlns:ows="http://www.opengis.net/ows/1.1" version="2.0.0">
+  <ows:Exception exceptionCode="InvalidValue">
+    <ows:ExceptionText>Feature type nz_pa_points_topo_150k is read-only</ows:ExceptionText>
+  </ows:Exception>
+</ows:ExceptionReport>"""
+    with pytest.raises(InvalidOperation, match="is read-only"):
+        parse_transaction_response(error)
@@ -0,0 +1,89 @@
+import json
+import sqlite3
+
+import pytest
+
+from kart.exceptions import INVALID_FILE_FORMAT
+from kart.repo import KartRepo
+
+
+H = pytest.helpers.helpers()
+
+
+def _write_manifest(path, repo_path, commit=None):
+    lines = [
+        "output: product.gpkg",
+        "stores:",
+        "  - name: points",
+        f"    url: {repo_path}",
+        "    ref: main",
+    ]
+    if commit:
+        lines.append(f"    commit: {commit}")
+    lines += [
+        "    datasets:",
+        f"      - {H.POINTS.LAYER}:points",
+    ]
+    path.write_text("\n".join(lines) + "\n")
+
+
+def test_workspace(data_archive_readonly, cli_runner, tmp_path, chdir):
+    with data_archive_readonly("points") as repo_path:
+        repo = KartRepo(repo_path)
+        head = repo.head_commit.hex
+        parent = repo.head_commit.parents[0].hex
+
+        workspace_path = tmp_path / "ws"
+        workspace_path.mkdir()
+        _write_manifest(workspace_path / "workspace.yaml", repo_path, commit=parent)
+
+        with chdir(workspace_path):
+            r = cli_runner.invoke(["workspace", "checkout"])
+            assert r.exit_code == 0, r.stderr
+            assert r.stdout.splitlines()[-1] == (
+                f"Checked out workspace to {workspace_path / 'product.gpkg'}"
+            )
+            with sqlite3.connect(workspace_path / "product.gpkg") as db:
+                (count,) = db.execute("SELECT COUNT(*) FROM points;").fetchone()
+            assert count == H.POINTS.ROWCOUNT
+
+            r = cli_runner.invoke(["workspace", "status", "-o", "json"])
+            assert r.exit_code == 0, r.stderr
+            [store] = json.loads(r.stdout)["kart.workspace.status/v1"]["stores"]
+            assert store["pinnedCommit"] == parent
+            assert store["checkedOutCommit"] == parent
+            assert store["latestCommit"] == head
+            assert store["commitsBehind"] == 1
+
+            r = cli_runner.invoke(["workspace", "update"])
+            assert r.exit_code == 0, r.stderr
+            assert f"points: {parent[:7]} -> {head[:7]}" in r.stdout
+            assert f"commit: {head}" in (workspace_path / "workspace.yaml").read_text()
+
+            r = cli_runner.invoke(["workspace", "status", "-o", "json"])
+            assert r.exit_code == 0, r.stderr
+            [store] = json.loads(r.stdout)["kart.workspace.status/v1"]["stores"]
+            assert store["pinnedCommit"] == head
+            assert store["checkedOutCommit"] == head
+            assert store["commitsBehind"] == 0
+
+
+def test_workspace_pins_unpinned_stores(data_archive_readonly, cli_runner, tmp_path):
+    with data_archive_readonly("points") as repo_path:
+        head = KartRepo(repo_path).head_commit.hex
+        manifest_path = tmp_path / "workspace.yaml"
+        _write_manifest(manifest_path, repo_path)
+
+        r = cli_runner.invoke(["workspace", "-f", manifest_path, "checkout"])
+        assert r.exit_code == 0, r.stderr
+        assert f"Pinned points to {head[:7]}" in r.stdout
+        assert f"commit: {head}" in manifest_path.read_text()
+        assert (tmp_path / "product.gpkg").exists()
+
+
+def test_workspace_invalid_manifest(cli_runner, tmp_path):
+    manifest_path = tmp_path / "workspace.yaml"
+    manifest_path.write_text("stores:\n  - name: points\n")
+    r = cli_runner.invoke(["workspace", "-f", manifest_path, "checkout"])
+    assert r.exit_code == INVALID_FILE_FORMAT, r.stderr
+    assert "'url' is a required property" in r.stderr